@@ -0,0 +1,406 @@
+package config
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults used when a setting is not present in the environment
+const (
+	DefaultPort                        = "8080"
+	DefaultMongoDatabase               = "ginchat"
+	DefaultJWTExpiration               = 24 * time.Hour
+	DefaultDBConnectAttempts           = 5
+	DefaultDBConnectRetryDelay         = 2 * time.Second // Doubled after each failed attempt
+	DefaultMySQLMaxOpenConns           = 25
+	DefaultMySQLMaxIdleConns           = 10
+	DefaultMySQLConnMaxLifetime        = 5 * time.Minute
+	DefaultMaxUploadSize               = 10 * 1024 * 1024 // bytes
+	DefaultChunkedUploadThreshold      = 20 * 1024 * 1024 // bytes
+	DefaultMediaUploadConcurrency      = 4
+	DefaultMediaUploadQueueTimeout     = 5 * time.Second
+	DefaultMediaLocalDir               = "."
+	DefaultMessageHistoryMaxLimit      = 100
+	DefaultMessageEditWindow           = 15 * time.Minute
+	DefaultWSPingInterval              = 90 * time.Second
+	DefaultWSPongTimeout               = 120 * time.Second
+	DefaultWSMaxMessageSize            = 64 * 1024 // bytes
+	DefaultChatroomCreateLimit         = 10
+	DefaultChatroomCreateWindow        = time.Hour
+	DefaultMaxChatroomsPerUser         = 200
+	DefaultReadStatusReconcileInterval = 6 * time.Hour
+	DefaultReadStatusReconcileBatch    = 500
+	// API responses and proxied media need nothing beyond the same origin; frame-ancestors matches X-Frame-Options
+	DefaultContentSecurityPolicy = "default-src 'self'; img-src 'self' data: https:; media-src 'self' https:; frame-ancestors 'none'"
+	DefaultFrameOptions          = "DENY"
+)
+
+// Push notification priorities understood by Expo
+const (
+	PushPriorityDefault = "default"
+	PushPriorityNormal  = "normal"
+	PushPriorityHigh    = "high"
+)
+
+// Keys in PushStyles besides message types
+const (
+	PushStyleDefaultKey = "default" // Fallback for message types without their own entry
+	PushStyleMentionKey = "mention" // Recipients named by @everyone / @here
+)
+
+// PushStyle is the sound and priority of a push notification. An empty Sound sends it silently.
+type PushStyle struct {
+	Sound    string
+	Priority string
+}
+
+// DefaultPushStyle matches what every notification used before styles were configurable
+var DefaultPushStyle = PushStyle{Sound: "default", Priority: PushPriorityHigh}
+
+// Config holds all settings read from the environment at startup
+type Config struct {
+	Port        string
+	CORSOrigins []string // "*" allows any origin
+
+	// MySQL: MySQLURI takes precedence over the individual parameters
+	MySQLURI             string
+	MySQLHost            string
+	MySQLPort            string
+	MySQLUser            string
+	MySQLPassword        string
+	MySQLDatabase        string
+	MySQLSSLMode         string
+	MySQLMaxOpenConns    int
+	MySQLMaxIdleConns    int
+	MySQLConnMaxLifetime time.Duration
+
+	MongoURI      string
+	MongoDatabase string
+
+	DBConnectAttempts   int
+	DBConnectRetryDelay time.Duration
+
+	JWTSecret     string
+	JWTExpiration time.Duration
+
+	CloudinaryCloudName    string
+	CloudinaryAPIKey       string
+	CloudinaryAPISecret    string
+	MaxUploadSize          int64 // bytes
+	ChunkedUploadThreshold int64 // bytes; larger files are sent to Cloudinary in chunks instead of one request
+
+	// At most MediaUploadConcurrency Cloudinary uploads run at once; others wait up to MediaUploadQueueTimeout for a slot
+	MediaUploadConcurrency  int
+	MediaUploadQueueTimeout time.Duration
+	MediaLocalDir           string // Files go under <dir>/uploads when Cloudinary isn't configured
+
+	MessageHistoryMaxLimit int
+	MessageEditWindow      time.Duration // How long senders can edit a message, unless the room sets its own window
+	MessageFilterEnabled   bool
+	MessageFilterWordsFile string
+
+	WSPingInterval   time.Duration
+	WSPongTimeout    time.Duration
+	WSMaxMessageSize int64 // bytes; larger inbound frames close the connection
+
+	// Each user may create at most ChatroomCreateLimit chatrooms per ChatroomCreateWindow (admins are exempt)
+	ChatroomCreateLimit  int
+	ChatroomCreateWindow time.Duration
+
+	// A user may be a member of at most MaxChatroomsPerUser chatrooms (admins are exempt)
+	MaxChatroomsPerUser int
+
+	// New users are added to each of DefaultChatroomIDs (chatroom ObjectIDs) when they register
+	DefaultChatroomIDs []string
+
+	// Orphaned read-status and last-read records are pruned every ReadStatusReconcileInterval, ReadStatusReconcileBatch at a time
+	ReadStatusReconcileEnabled  bool
+	ReadStatusReconcileInterval time.Duration
+	ReadStatusReconcileBatch    int
+
+	// With ReadPointerTracking, unread state comes from each member's last-read pointer instead of
+	// a read-status row per message and recipient (see MessageReadStatusService)
+	ReadPointerTracking bool
+
+	// Security response headers; set SECURITY_HEADERS_ENABLED=false for local development
+	SecurityHeadersEnabled bool
+	ContentSecurityPolicy  string // Empty sends no Content-Security-Policy header
+	FrameOptions           string // DENY or SAMEORIGIN
+
+	// MessageEncryptionKey enables AES-GCM encryption of message text at rest when set (16, 24 or 32 bytes)
+	MessageEncryptionKey []byte
+
+	// PushStyles maps a message type, PushStyleMentionKey or PushStyleDefaultKey to its notification style
+	PushStyles map[string]PushStyle
+
+	// FCMCredentialsFile is a Firebase service account key; without it FCM tokens get no notifications
+	FCMCredentialsFile string
+}
+
+// Load reads the configuration from the environment, applying defaults for unset values.
+// Every invalid or missing required setting is reported in the returned error, not just the first.
+func Load() (*Config, error) {
+	l := &loader{}
+
+	cfg := &Config{
+		Port:        l.str("PORT", DefaultPort),
+		CORSOrigins: l.list("CORS_ALLOWED_ORIGINS", []string{"*"}),
+
+		MySQLURI:             os.Getenv("MYSQL_URI"),
+		MySQLHost:            os.Getenv("MYSQL_HOST"),
+		MySQLPort:            os.Getenv("MYSQL_PORT"),
+		MySQLUser:            os.Getenv("MYSQL_USER"),
+		MySQLPassword:        os.Getenv("MYSQL_PASSWORD"),
+		MySQLDatabase:        os.Getenv("MYSQL_DATABASE"),
+		MySQLSSLMode:         os.Getenv("MYSQL_SSL_MODE"),
+		MySQLMaxOpenConns:    l.positiveInt("MYSQL_MAX_OPEN_CONNS", DefaultMySQLMaxOpenConns),
+		MySQLMaxIdleConns:    l.positiveInt("MYSQL_MAX_IDLE_CONNS", DefaultMySQLMaxIdleConns),
+		MySQLConnMaxLifetime: l.duration("MYSQL_CONN_MAX_LIFETIME", DefaultMySQLConnMaxLifetime),
+
+		MongoURI:      os.Getenv("MONGO_URI"),
+		MongoDatabase: l.str("MONGO_DATABASE", DefaultMongoDatabase),
+
+		DBConnectAttempts:   l.positiveInt("DB_CONNECT_ATTEMPTS", DefaultDBConnectAttempts),
+		DBConnectRetryDelay: l.duration("DB_CONNECT_RETRY_DELAY", DefaultDBConnectRetryDelay),
+
+		JWTSecret:     os.Getenv("JWT_SECRET"),
+		JWTExpiration: l.duration("JWT_EXPIRATION", DefaultJWTExpiration),
+
+		CloudinaryCloudName:    os.Getenv("CLOUDINARY_CLOUD_NAME"),
+		CloudinaryAPIKey:       os.Getenv("CLOUDINARY_API_KEY"),
+		CloudinaryAPISecret:    os.Getenv("CLOUDINARY_API_SECRET"),
+		MaxUploadSize:          int64(l.positiveInt("MEDIA_MAX_UPLOAD_MB", DefaultMaxUploadSize/(1024*1024))) * 1024 * 1024,
+		ChunkedUploadThreshold: int64(l.positiveInt("MEDIA_CHUNKED_UPLOAD_MB", DefaultChunkedUploadThreshold/(1024*1024))) * 1024 * 1024,
+
+		MediaUploadConcurrency:  l.positiveInt("MEDIA_UPLOAD_CONCURRENCY", DefaultMediaUploadConcurrency),
+		MediaUploadQueueTimeout: l.duration("MEDIA_UPLOAD_QUEUE_TIMEOUT", DefaultMediaUploadQueueTimeout),
+		MediaLocalDir:           l.str("MEDIA_LOCAL_DIR", DefaultMediaLocalDir),
+
+		MessageHistoryMaxLimit: l.positiveInt("MESSAGE_HISTORY_MAX_LIMIT", DefaultMessageHistoryMaxLimit),
+		MessageEditWindow:      l.duration("MESSAGE_EDIT_WINDOW", DefaultMessageEditWindow),
+		MessageFilterEnabled:   l.boolean("MESSAGE_FILTER_ENABLED", true),
+		MessageFilterWordsFile: os.Getenv("MESSAGE_FILTER_WORDS_FILE"),
+
+		WSPingInterval:   l.duration("WS_PING_INTERVAL", DefaultWSPingInterval),
+		WSPongTimeout:    l.duration("WS_PONG_TIMEOUT", DefaultWSPongTimeout),
+		WSMaxMessageSize: int64(l.positiveInt("WS_MAX_MESSAGE_KB", DefaultWSMaxMessageSize/1024)) * 1024,
+
+		ChatroomCreateLimit:  l.positiveInt("CHATROOM_CREATE_LIMIT", DefaultChatroomCreateLimit),
+		ChatroomCreateWindow: l.duration("CHATROOM_CREATE_WINDOW", DefaultChatroomCreateWindow),
+		MaxChatroomsPerUser:  l.positiveInt("MAX_CHATROOMS_PER_USER", DefaultMaxChatroomsPerUser),
+		DefaultChatroomIDs:   l.list("DEFAULT_CHATROOM_IDS", nil),
+
+		ReadStatusReconcileEnabled:  l.boolean("READ_STATUS_RECONCILE_ENABLED", true),
+		ReadStatusReconcileInterval: l.duration("READ_STATUS_RECONCILE_INTERVAL", DefaultReadStatusReconcileInterval),
+		ReadStatusReconcileBatch:    l.positiveInt("READ_STATUS_RECONCILE_BATCH", DefaultReadStatusReconcileBatch),
+		ReadPointerTracking:         l.boolean("READ_POINTER_TRACKING", false),
+
+		SecurityHeadersEnabled: l.boolean("SECURITY_HEADERS_ENABLED", true),
+		ContentSecurityPolicy:  l.optionalStr("CONTENT_SECURITY_POLICY", DefaultContentSecurityPolicy),
+		FrameOptions:           strings.ToUpper(l.str("X_FRAME_OPTIONS", DefaultFrameOptions)),
+
+		PushStyles:         l.pushStyles("PUSH_NOTIFICATION_STYLES"),
+		FCMCredentialsFile: os.Getenv("FCM_CREDENTIALS_FILE"),
+
+		MessageEncryptionKey: l.aesKey("MESSAGE_ENCRYPTION_KEY"),
+	}
+
+	cfg.validate(l)
+
+	if len(l.problems) > 0 {
+		return nil, fmt.Errorf("invalid configuration: %s", strings.Join(l.problems, "; "))
+	}
+	return cfg, nil
+}
+
+// validate checks settings that depend on each other or have no usable default
+func (c *Config) validate(l *loader) {
+	if c.MongoURI == "" {
+		l.fail("MONGO_URI is required")
+	}
+	if c.MySQLURI == "" && (c.MySQLHost == "" || c.MySQLDatabase == "") {
+		l.fail("MYSQL_URI or MYSQL_HOST and MYSQL_DATABASE are required")
+	}
+	if c.JWTSecret == "" {
+		l.fail("JWT_SECRET is required")
+	}
+	if c.MySQLMaxIdleConns > c.MySQLMaxOpenConns {
+		l.fail(fmt.Sprintf("MYSQL_MAX_IDLE_CONNS (%d) must not exceed MYSQL_MAX_OPEN_CONNS (%d)", c.MySQLMaxIdleConns, c.MySQLMaxOpenConns))
+	}
+	if c.WSPingInterval >= c.WSPongTimeout {
+		l.fail(fmt.Sprintf("WS_PING_INTERVAL (%s) must be less than WS_PONG_TIMEOUT (%s)", c.WSPingInterval, c.WSPongTimeout))
+	}
+	if c.FrameOptions != "DENY" && c.FrameOptions != "SAMEORIGIN" {
+		l.fail(fmt.Sprintf("X_FRAME_OPTIONS must be DENY or SAMEORIGIN, got %q", c.FrameOptions))
+	}
+	if len(c.CORSOrigins) == 0 {
+		l.fail("CORS_ALLOWED_ORIGINS must list at least one origin")
+	}
+	for _, id := range c.DefaultChatroomIDs {
+		if _, err := hex.DecodeString(id); err != nil || len(id) != 24 {
+			l.fail(fmt.Sprintf("DEFAULT_CHATROOM_IDS must list chatroom IDs (24 hex characters), got %q", id))
+		}
+	}
+}
+
+// CloudinaryConfigured reports whether all Cloudinary credentials are set
+func (c *Config) CloudinaryConfigured() bool {
+	return c.CloudinaryCloudName != "" && c.CloudinaryAPIKey != "" && c.CloudinaryAPISecret != ""
+}
+
+// AllowsAnyOrigin reports whether CORS is open to every origin
+func (c *Config) AllowsAnyOrigin() bool {
+	for _, origin := range c.CORSOrigins {
+		if origin == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// loader parses environment values and collects the problems it finds
+type loader struct {
+	problems []string
+}
+
+func (l *loader) fail(problem string) {
+	l.problems = append(l.problems, problem)
+}
+
+func (l *loader) str(key, fallback string) string {
+	if value := strings.TrimSpace(os.Getenv(key)); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// optionalStr is like str but a variable that is set and empty means "none" rather than the fallback
+func (l *loader) optionalStr(key, fallback string) string {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+	return strings.TrimSpace(value)
+}
+
+// list reads a comma-separated value, ignoring empty entries
+func (l *loader) list(key string, fallback []string) []string {
+	value := os.Getenv(key)
+	if strings.TrimSpace(value) == "" {
+		return fallback
+	}
+
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (l *loader) positiveInt(key string, fallback int) int {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed <= 0 {
+		l.fail(fmt.Sprintf("%s must be a positive integer, got %q", key, value))
+		return fallback
+	}
+	return parsed
+}
+
+// duration reads a positive Go duration such as "25s" or "5m"
+func (l *loader) duration(key string, fallback time.Duration) time.Duration {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed <= 0 {
+		l.fail(fmt.Sprintf("%s must be a positive duration (e.g. \"30s\"), got %q", key, value))
+		return fallback
+	}
+	return parsed
+}
+
+func (l *loader) boolean(key string, fallback bool) bool {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		l.fail(fmt.Sprintf("%s must be true or false, got %q", key, value))
+		return fallback
+	}
+	return parsed
+}
+
+// aesKey reads a base64-encoded AES key, which must decode to 16, 24 or 32 bytes
+func (l *loader) aesKey(key string) []byte {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return nil
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		l.fail(fmt.Sprintf("%s must be base64-encoded", key))
+		return nil
+	}
+	switch len(decoded) {
+	case 16, 24, 32:
+		return decoded
+	default:
+		l.fail(fmt.Sprintf("%s must decode to 16, 24 or 32 bytes, got %d", key, len(decoded)))
+		return nil
+	}
+}
+
+// pushStyleKeys are the keys PUSH_NOTIFICATION_STYLES may configure
+var pushStyleKeys = map[string]bool{
+	PushStyleDefaultKey: true, PushStyleMentionKey: true,
+	"text": true, "picture": true, "audio": true, "video": true,
+	"text_and_picture": true, "text_and_audio": true, "text_and_video": true,
+}
+
+// pushStyles reads entries like "mention=default:high,audio=none:normal" (key=sound:priority).
+// A sound of "none" makes the notification silent.
+func (l *loader) pushStyles(key string) map[string]PushStyle {
+	styles := make(map[string]PushStyle)
+	for _, entry := range l.list(key, nil) {
+		name, value, ok := strings.Cut(entry, "=")
+		sound, priority, hasPriority := strings.Cut(value, ":")
+		name, sound, priority = strings.TrimSpace(name), strings.TrimSpace(sound), strings.TrimSpace(priority)
+		if !ok || !hasPriority || sound == "" {
+			l.fail(fmt.Sprintf("%s entries must look like type=sound:priority, got %q", key, entry))
+			continue
+		}
+		if !pushStyleKeys[name] {
+			l.fail(fmt.Sprintf("%s has unknown message type %q", key, name))
+			continue
+		}
+		switch priority {
+		case PushPriorityDefault, PushPriorityNormal, PushPriorityHigh:
+		default:
+			l.fail(fmt.Sprintf("%s priority for %q must be default, normal or high, got %q", key, name, priority))
+			continue
+		}
+		if sound == "none" {
+			sound = ""
+		}
+		styles[name] = PushStyle{Sound: sound, Priority: priority}
+	}
+	return styles
+}
@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ginchat/services"
+	"go.mongodb.org/mongo-driver/mongo"
+	"gorm.io/gorm"
+)
+
+// AdminController serves deployment-wide information to administrators
+type AdminController struct {
+	wsHub
+	StatsService *services.StatsService
+}
+
+// NewAdminController creates a new AdminController
+func NewAdminController(db *gorm.DB, mongodb *mongo.Database) *AdminController {
+	return &AdminController{
+		StatsService: services.NewStatsService(db, mongodb),
+	}
+}
+
+// GetSystemStats handles getting an overview of the system
+// @Summary Get system stats
+// @Description Totals for users, chatrooms and messages, messages sent in the last 24 hours, and current WebSocket users and connections. Chatroom and message totals are estimates. Admins only
+// @Tags admin
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} services.SystemStats "System stats"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 403 {object} utils.APIError "User is not an administrator"
+// @Failure 500 {object} utils.APIError "Internal server error"
+// @Router /admin/stats [get]
+func (ac *AdminController) GetSystemStats(c *gin.Context) {
+	stats, err := ac.StatsService.GetSystemStats()
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	// Live figures come from the WebSocket hub's connection map
+	stats.OnlineUsers, stats.WebSocketConnections = ac.hub().ConnectionStats()
+
+	c.JSON(http.StatusOK, stats)
+}
@@ -0,0 +1,47 @@
+package controllers_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ginchat/models"
+	"github.com/ginchat/services"
+	"github.com/ginchat/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSystemStats(t *testing.T) {
+	env := newAPIEnv(t)
+	alice, bob, root := env.user(t, "alice"), env.user(t, "bob"), env.admin(t, "root")
+	env.user(t, "carol")                               // Never connects
+	roomID := env.createRoom(t, alice, "General", bob) // Adds a "bob joined" system message
+	quietID := env.createRoom(t, alice, "Quiet")
+	env.send(t, alice, roomID, "one")
+	env.send(t, bob, roomID, "two")
+	env.send(t, alice, quietID, "three")
+	old := models.Message{ID: primitive.NewObjectID(), ChatroomID: primitive.NewObjectID(), SenderID: alice.ID, MessageType: "text", TextContent: "last week", SentAt: time.Now().Add(-7 * 24 * time.Hour)}
+	if _, err := env.Mongo.Collection("messages").InsertOne(context.Background(), old); err != nil {
+		t.Fatalf("insert old message: %v", err)
+	}
+
+	env.dial(t, alice, roomID)
+	env.dial(t, bob, "global_sidebar")
+	time.Sleep(1100 * time.Millisecond) // Each user may open one connection per second
+	env.dial(t, alice, "global_sidebar")
+
+	var stats services.SystemStats
+	expect(t, env.do(t, root, http.MethodGet, "/api/admin/stats", nil), http.StatusOK, &stats)
+	want := services.SystemStats{TotalUsers: 4, OnlineUsers: 2, TotalChatrooms: 2, TotalMessages: 5, MessagesLast24h: 4, WebSocketConnections: 3}
+	if stats != want {
+		t.Errorf("stats = %+v, want %+v", stats, want)
+	}
+
+	var got apiError
+	expect(t, env.do(t, alice, http.MethodGet, "/api/admin/stats", nil), http.StatusForbidden, &got)
+	if got.Code != utils.CodeForbidden {
+		t.Errorf("non-admin: code = %s, want %s", got.Code, utils.CodeForbidden)
+	}
+	expect(t, env.do(t, nil, http.MethodGet, "/api/admin/stats", nil), http.StatusUnauthorized, nil)
+}
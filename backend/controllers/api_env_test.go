@@ -0,0 +1,267 @@
+package controllers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ginchat/config"
+	"github.com/ginchat/internal/mongotest"
+	"github.com/ginchat/internal/sqltest"
+	"github.com/ginchat/models"
+	"github.com/ginchat/routes"
+	"github.com/ginchat/utils"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
+	"gorm.io/gorm"
+)
+
+// apiEnv serves the application's real routes over in-memory MySQL and MongoDB stand-ins
+type apiEnv struct {
+	Config  *config.Config
+	Router  *gin.Engine
+	DB      *gorm.DB
+	SQL     *sqltest.DB
+	Mongo   *mongo.Database
+	MongoDB *mongotest.Server
+
+	server *httptest.Server // Started on first dial
+}
+
+// apiUser is a stored user and a token to act as them
+type apiUser struct {
+	ID    uint
+	Name  string
+	Token string
+}
+
+// newAPIEnv loads the configuration from a minimal environment, lets configure adjust it, and sets up the routes
+func newAPIEnv(t *testing.T, configure ...func(*config.Config)) *apiEnv {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	t.Setenv("MONGO_URI", "mongodb://in-memory")
+	t.Setenv("MYSQL_URI", "in-memory")
+	t.Setenv("JWT_SECRET", "api-test-secret")
+	t.Setenv("MEDIA_LOCAL_DIR", t.TempDir())
+	t.Setenv("READ_STATUS_RECONCILE_ENABLED", "false")
+	t.Setenv("SECURITY_HEADERS_ENABLED", "false")
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("config.Load: %v", err)
+	}
+	for _, fn := range configure {
+		fn(cfg)
+	}
+	utils.ConfigureJWT(cfg.JWTSecret, cfg.JWTExpiration)
+
+	gdb, sqlDB := sqltest.Open(t, &models.User{}, &models.PushToken{}, &models.RevokedToken{}, &models.Session{})
+	mdb, server := mongotest.NewDatabase(t)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	router := gin.New()
+	routes.SetupRoutes(router, gdb, mdb, logger, cfg)
+
+	env := &apiEnv{Config: cfg, Router: router, DB: gdb, SQL: sqlDB, Mongo: mdb, MongoDB: server}
+	t.Cleanup(func() {
+		if env.server != nil {
+			env.server.Close()
+		}
+	})
+	return env
+}
+
+// user stores a user and issues them a token
+func (env *apiEnv) user(t *testing.T, username string) *apiUser {
+	t.Helper()
+	return env.userWithRole(t, username, models.UserRoleMember)
+}
+
+// admin stores an administrator and issues them a token
+func (env *apiEnv) admin(t *testing.T, username string) *apiUser {
+	t.Helper()
+	return env.userWithRole(t, username, models.UserRoleAdmin)
+}
+
+func (env *apiEnv) userWithRole(t *testing.T, username, role string) *apiUser {
+	t.Helper()
+	user := models.User{Username: username, Email: username + "@example.com", Password: "x", Role: role}
+	if err := env.DB.Create(&user).Error; err != nil {
+		t.Fatalf("create user %s: %v", username, err)
+	}
+	token, err := utils.GenerateJWT(user.UserID, user.Username, user.Email, role)
+	if err != nil {
+		t.Fatalf("GenerateJWT: %v", err)
+	}
+	return &apiUser{ID: user.UserID, Name: username, Token: token}
+}
+
+// do sends a request as user (nil for none) with body encoded as JSON, unless it is already a string
+func (env *apiEnv) do(t *testing.T, user *apiUser, method, path string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		reader = strings.NewReader(b)
+	default:
+		raw, err := json.Marshal(b)
+		if err != nil {
+			t.Fatalf("encode body: %v", err)
+		}
+		reader = bytes.NewReader(raw)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	if reader != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if user != nil {
+		req.Header.Set("Authorization", "Bearer "+user.Token)
+	}
+	w := httptest.NewRecorder()
+	env.Router.ServeHTTP(w, req)
+	return w
+}
+
+// expect fails the test unless the response has the given status, and decodes its body into out (if not nil)
+func expect(t *testing.T, w *httptest.ResponseRecorder, status int, out any) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("status = %d, want %d; body %s", w.Code, status, w.Body.String())
+	}
+	if out != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			t.Fatalf("decode %s: %v", w.Body.String(), err)
+		}
+	}
+}
+
+// createRoom creates a chatroom as owner through the API and has the other users join it
+func (env *apiEnv) createRoom(t *testing.T, owner *apiUser, name string, members ...*apiUser) string {
+	t.Helper()
+	var created struct {
+		Chatroom models.ChatroomResponse `json:"chatroom"`
+	}
+	expect(t, env.do(t, owner, http.MethodPost, "/api/chatrooms", map[string]string{"name": name}), http.StatusCreated, &created)
+	for _, member := range members {
+		env.join(t, member, created.Chatroom.ID)
+	}
+	return created.Chatroom.ID
+}
+
+// join adds user to the chatroom through the API
+func (env *apiEnv) join(t *testing.T, user *apiUser, chatroomID string) {
+	t.Helper()
+	expect(t, env.do(t, user, http.MethodPost, "/api/chatrooms/"+chatroomID+"/join", nil), http.StatusOK, nil)
+}
+
+// send posts a text message and returns its ID
+func (env *apiEnv) send(t *testing.T, user *apiUser, chatroomID, text string) string {
+	t.Helper()
+	var sent struct {
+		Message struct {
+			ID string `json:"id"`
+		} `json:"message"`
+	}
+	body := map[string]string{"message_type": "text", "text_content": text}
+	expect(t, env.do(t, user, http.MethodPost, "/api/chatrooms/"+chatroomID+"/messages", body), http.StatusCreated, &sent)
+	if sent.Message.ID == "" {
+		t.Fatalf("send response has no message ID")
+	}
+	return sent.Message.ID
+}
+
+// socketEvent is a decoded server frame
+type socketEvent struct {
+	Type       string          `json:"type"`
+	ChatroomID string          `json:"chatroom_id"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// apiSocket is a client WebSocket whose frames are read in the background (a gorilla connection
+// can't be read again after a read times out)
+type apiSocket struct {
+	*websocket.Conn
+	events chan socketEvent
+}
+
+// dial opens a WebSocket as user, viewing roomID (a chatroom ID or "global_sidebar"), and reads the connected frame
+func (env *apiEnv) dial(t *testing.T, user *apiUser, roomID string) *apiSocket {
+	t.Helper()
+	if env.server == nil {
+		env.server = httptest.NewServer(env.Router)
+	}
+	url := "ws" + strings.TrimPrefix(env.server.URL, "http") + "/api/ws?token=" + user.Token + "&room_id=" + roomID
+	conn, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		t.Fatalf("dial as %s: %v (status %d)", user.Name, err, status)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var connected socketEvent
+	if err := conn.ReadJSON(&connected); err != nil || connected.Type != "connected" {
+		t.Fatalf("first frame for %s = %+v, %v; want connected", user.Name, connected, err)
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	socket := &apiSocket{Conn: conn, events: make(chan socketEvent, 256)}
+	go func() {
+		defer close(socket.events)
+		for {
+			var event socketEvent
+			if err := conn.ReadJSON(&event); err != nil {
+				return
+			}
+			socket.events <- event
+		}
+	}()
+	return socket
+}
+
+// collect returns the frames that arrive within wait, grouped by type
+func (s *apiSocket) collect(wait time.Duration) map[string][]socketEvent {
+	events := map[string][]socketEvent{}
+	timeout := time.After(wait)
+	for {
+		select {
+		case event, ok := <-s.events:
+			if !ok {
+				return events
+			}
+			events[event.Type] = append(events[event.Type], event)
+		case <-timeout:
+			return events
+		}
+	}
+}
+
+// await returns the next frame of the given type, skipping others, and fails the test if none arrives in time
+func (s *apiSocket) await(t *testing.T, eventType string) socketEvent {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case event, ok := <-s.events:
+			if !ok {
+				t.Fatalf("socket closed while waiting for %s", eventType)
+			}
+			if event.Type == eventType {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("no %s frame within 2s", eventType)
+		}
+	}
+}
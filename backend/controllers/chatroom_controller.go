@@ -1,45 +1,111 @@
 package controllers
 
 import (
+	"log"
+	"math"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/ginchat/models"
 	"github.com/ginchat/services"
 	"github.com/ginchat/utils"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"gorm.io/gorm"
 )
 
 // ChatroomController handles chatroom-related requests
 type ChatroomController struct {
+	wsHub
 	ChatroomService *services.ChatroomService
 	MessageService  *services.MessageService
+	UserService     *services.UserService
+	createLimiter   *utils.WindowLimiter // Per-user chatroom creation limit (nil means unlimited)
+	membershipLimit int                  // Most chatrooms a user may belong to (0 means unlimited)
 }
 
 // NewChatroomController creates a new ChatroomController
-func NewChatroomController(db *gorm.DB, mongodb *mongo.Database) *ChatroomController {
-	chatroomService := services.NewChatroomService(mongodb)
-	userService := services.NewUserService(db)
-	cloudinaryService, _ := services.NewCloudinaryService() // Ignore error for now, will be nil if not configured
-	readStatusService := services.NewMessageReadStatusService(mongodb, chatroomService, userService)
-	messageService := services.NewMessageService(mongodb, chatroomService, cloudinaryService, readStatusService)
+func NewChatroomController(chatroomService *services.ChatroomService, messageService *services.MessageService, userService *services.UserService) *ChatroomController {
 	return &ChatroomController{
 		ChatroomService: chatroomService,
 		MessageService:  messageService,
+		UserService:     userService,
 	}
 }
 
+// SetCreationLimit limits each user to limit new chatrooms per window. Admins are not limited.
+func (cc *ChatroomController) SetCreationLimit(limit int, window time.Duration) {
+	cc.createLimiter = utils.NewWindowLimiter(limit, window)
+}
+
+// SetMembershipLimit caps how many chatrooms each user may be a member of. Admins are not limited.
+func (cc *ChatroomController) SetMembershipLimit(limit int) {
+	cc.membershipLimit = limit
+}
+
+// membershipLimitFor returns the membership cap for the requesting user (0 for admins, who are exempt)
+func (cc *ChatroomController) membershipLimitFor(c *gin.Context) int {
+	if role, _ := c.Get("role"); role == models.UserRoleAdmin {
+		return 0
+	}
+	return cc.membershipLimit
+}
+
 // CreateChatroomRequest represents the request body for creating a chatroom
 type CreateChatroomRequest struct {
-	Name     string `json:"name" binding:"required,min=3,max=100" example:"General Chat"` // The name of the chatroom
-	Password string `json:"password" example:"secret123"`                                 // Optional password for the chatroom
+	Name        string `json:"name" binding:"required,min=3,max=100" example:"General Chat"` // The name of the chatroom
+	Description string `json:"description" binding:"max=500" example:"Say hi here"`          // Optional description of the chatroom
+	Topic       string `json:"topic" binding:"max=100" example:"Weekend plans"`              // Optional topic shown in the chat header
+	Password    string `json:"password" example:"secret123"`                                 // Optional password for the chatroom
+}
+
+// UpdateChatroomRequest represents the request body for updating a chatroom's details.
+// Omitted (null) fields are left unchanged; an empty string clears the field.
+type UpdateChatroomRequest struct {
+	Name              *string   `json:"name" binding:"omitempty,min=3,max=100" example:"Weekend plans"`                                   // New name (optional); renaming adds a notice to the transcript
+	Description       *string   `json:"description" binding:"omitempty,max=500" example:"Say hi here"`                                    // New description (optional)
+	Topic             *string   `json:"topic" binding:"omitempty,max=100" example:"Weekend plans"`                                        // New topic (optional)
+	FilterPolicy      *string   `json:"filter_policy" binding:"omitempty,oneof=mask reject off" example:"reject" enums:"mask,reject,off"` // What to do with messages containing banned words (optional)
+	AllowedMediaTypes *[]string `json:"allowed_media_types" binding:"omitempty,dive,oneof=image audio video" example:"image"`             // Media types members may send; empty allows all (optional, creator only)
+	ReadReceipts      *bool     `json:"read_receipts_enabled" example:"false"`                                                            // Show who read each message (optional, creator only)
+	EditWindowMinutes *int      `json:"edit_window_minutes" binding:"omitempty,min=0,max=10080" example:"15"`                             // Minutes messages stay editable, 0 for no limit (optional, creator only)
+}
+
+// SetMemberRoleRequest represents the request body for changing a member's chatroom role
+type SetMemberRoleRequest struct {
+	Role string `json:"role" binding:"required,oneof=member readonly admin" example:"readonly" enums:"member,readonly,admin"` // The member's new room role
 }
 
 // JoinChatroomByCodeRequest represents the request body for joining a chatroom by code
 type JoinChatroomByCodeRequest struct {
-	RoomCode string `json:"room_code" binding:"required,len=6" example:"ABC123"` // The 6-character room code
-	Password string `json:"password" example:"secret123"`                        // Password if the room is protected
+	RoomCode string `json:"room_code" binding:"required" example:"ABC123"` // The 6-character room code (case and surrounding spaces are ignored)
+	Password string `json:"password" example:"secret123"`                  // Password if the room is protected
+}
+
+// JoinChatroomsBatchRequest represents the request body for joining several chatrooms by code at once
+type JoinChatroomsBatchRequest struct {
+	Rooms []JoinChatroomByCodeRequest `json:"rooms" binding:"required,min=1,max=20,dive"` // Room codes (and passwords) to join
+}
+
+// Per-room outcomes of a batch join
+const (
+	BatchJoinJoined        = "joined"
+	BatchJoinAlreadyMember = "already_member"
+	BatchJoinWrongPassword = "wrong_password"
+	BatchJoinNotFound      = "not_found"
+	BatchJoinInvalidCode   = "invalid_code"
+	BatchJoinLimitReached  = "limit_reached"
+	BatchJoinFailed        = "failed"
+)
+
+// BatchJoinResult is the outcome of joining one room in a batch
+type BatchJoinResult struct {
+	RoomCode   string                   `json:"room_code" example:"ABC123"`
+	Status     string                   `json:"status" example:"joined" enums:"joined,already_member,wrong_password,not_found,invalid_code,limit_reached,failed"`
+	ChatroomID string                   `json:"chatroom_id,omitempty"`
+	Chatroom   *models.ChatroomResponse `json:"chatroom,omitempty"` // Set when the room was joined
 }
 
 // CreateChatroom handles chatroom creation
@@ -51,37 +117,46 @@ type JoinChatroomByCodeRequest struct {
 // @Security ApiKeyAuth
 // @Param chatroom body CreateChatroomRequest true "Chatroom information"
 // @Success 201 {object} map[string]models.ChatroomResponse "Chatroom created successfully"
-// @Failure 400 {object} map[string]string "Invalid request body"
-// @Failure 401 {object} map[string]string "User not authenticated"
-// @Failure 409 {object} map[string]string "Chatroom with this name already exists"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Failure 400 {object} utils.APIError "Invalid request body"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 409 {object} utils.APIError "The user already created a chatroom with this name"
+// @Failure 429 {object} utils.APIError "Too many chatrooms created recently"
+// @Failure 500 {object} utils.APIError "Internal server error"
 // @Router /chatrooms [post]
 func (cc *ChatroomController) CreateChatroom(c *gin.Context) {
 	var req CreateChatroomRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": utils.FormatValidationError(err)})
+		respondErrorMessage(c, http.StatusBadRequest, utils.FormatValidationError(err))
 		return
 	}
 
 	// Get user ID from context (set by auth middleware)
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Please log in to continue"})
+		respondErrorMessage(c, http.StatusUnauthorized, "Please log in to continue")
 		return
 	}
 	username, _ := c.Get("username")
 
+	// Anti-spam cooldown: too many new rooms in the window gets a 429 until the oldest one ages out
+	if role, _ := c.Get("role"); cc.createLimiter != nil && role != models.UserRoleAdmin {
+		if allowed, retryAfter := cc.createLimiter.Allow(userID.(uint)); !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			respondErrorMessage(c, http.StatusTooManyRequests, "You're creating chat rooms too quickly. Please try again later")
+			return
+		}
+	}
+
 	// Create chatroom using the service
-	chatroom, err := cc.ChatroomService.CreateChatroom(req.Name, userID.(uint), username.(string), req.Password)
+	chatroom, err := cc.ChatroomService.CreateChatroom(req.Name, req.Description, req.Topic, userID.(uint), username.(string), req.Password, cc.membershipLimitFor(c))
 	if err != nil {
-		if err.Error() == "chatroom with this name already exists" {
-			c.JSON(http.StatusConflict, gin.H{"error": utils.FormatServiceError(err)})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": utils.FormatServiceError(err)})
-		}
+		respondError(c, err)
 		return
 	}
 
+	// The creator's other devices should show the new room straight away
+	cc.hub().BroadcastSelfSync(userID.(uint), SelfSyncEvent{Action: SelfSyncChatroomJoined, ChatroomID: chatroom.ID.Hex()}, nil)
+
 	// Return chatroom data
 	c.JSON(http.StatusCreated, gin.H{
 		"chatroom": chatroom.ToResponse(),
@@ -90,27 +165,81 @@ func (cc *ChatroomController) CreateChatroom(c *gin.Context) {
 
 // GetChatrooms handles getting all chatrooms
 // @Summary Get all chatrooms
-// @Description Retrieve a list of all available chatrooms
+// @Description Retrieve a list of all available chatrooms. Without query parameters every chatroom is returned.
+// @Description Passing q, limit, offset or exclude_joined returns a page of chatrooms ordered by name, for room discovery
 // @Tags chatrooms
 // @Accept json
 // @Produce json
 // @Security ApiKeyAuth
+// @Param q query string false "Case-insensitive partial match on the chatroom name"
+// @Param limit query int false "Chatrooms per page" default(20) minimum(1) maximum(100)
+// @Param offset query int false "Chatrooms to skip" default(0)
+// @Param exclude_joined query bool false "Leave out chatrooms you are already a member of"
 // @Success 200 {object} map[string][]models.ChatroomResponse "List of chatrooms"
-// @Failure 401 {object} map[string]string "User not authenticated"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Failure 400 {object} utils.APIError "Invalid pagination parameters or search too long"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 500 {object} utils.APIError "Internal server error"
 // @Router /chatrooms [get]
 func (cc *ChatroomController) GetChatrooms(c *gin.Context) {
 	// Get user ID from context (set by auth middleware)
-	_, exists := c.Get("user_id")
+	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Please log in to continue"})
+		respondErrorMessage(c, http.StatusUnauthorized, "Please log in to continue")
+		return
+	}
+
+	// Any discovery parameter switches to the paged search; the plain list stays as it was
+	name, hasName := c.GetQuery("q")
+	limitParam, hasLimit := c.GetQuery("limit")
+	offsetParam, hasOffset := c.GetQuery("offset")
+	excludeJoined := c.Query("exclude_joined") == "true"
+	if hasName || hasLimit || hasOffset || excludeJoined {
+		query := services.ChatroomSearchQuery{Name: strings.TrimSpace(name), Limit: services.DefaultChatroomPageSize}
+		if hasLimit {
+			limit, err := strconv.Atoi(limitParam)
+			if err != nil || limit <= 0 {
+				respondErrorMessage(c, http.StatusBadRequest, "Limit must be a positive number")
+				return
+			}
+			query.Limit = limit
+		}
+		if hasOffset {
+			offset, err := strconv.Atoi(offsetParam)
+			if err != nil || offset < 0 {
+				respondErrorMessage(c, http.StatusBadRequest, "Offset must be zero or a positive number")
+				return
+			}
+			query.Offset = offset
+		}
+		if excludeJoined {
+			query.ExcludeMember = userID.(uint)
+		}
+
+		chatrooms, total, err := cc.ChatroomService.SearchChatrooms(query)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		response := make([]models.ChatroomResponse, 0, len(chatrooms))
+		for _, chatroom := range chatrooms {
+			response = append(response, chatroom.ToResponse())
+		}
+		limit := min(query.Limit, services.MaxChatroomPageSize)
+		c.JSON(http.StatusOK, gin.H{
+			"chatrooms": response,
+			"total":     total,
+			"limit":     limit,
+			"offset":    query.Offset,
+			"has_more":  int64(query.Offset+len(chatrooms)) < total,
+		})
 		return
 	}
 
 	// Get all chatrooms using the service
 	chatrooms, err := cc.ChatroomService.GetChatrooms()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": utils.FormatServiceError(err)})
+		respondError(c, err)
 		return
 	}
 
@@ -132,32 +261,68 @@ func (cc *ChatroomController) GetChatrooms(c *gin.Context) {
 // @Accept json
 // @Produce json
 // @Security ApiKeyAuth
+// @Param sort query string false "Order by latest message (recent) or put rooms with unread messages first (unread_first)" Enums(recent, unread_first)
+// @Param sorted query bool false "Deprecated: same as sort=recent"
 // @Success 200 {object} map[string][]models.ChatroomResponse "List of user's chatrooms"
-// @Failure 401 {object} map[string]string "User not authenticated"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Failure 400 {object} utils.APIError "Invalid sort mode"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 500 {object} utils.APIError "Internal server error"
 // @Router /chatrooms/user [get]
 func (cc *ChatroomController) GetChatroomsByUserID(c *gin.Context) {
 	// Get user ID from context (set by auth middleware)
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		respondErrorMessage(c, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
-	// Check if client wants sorted results
-	sorted := c.Query("sorted")
-	if sorted == "true" {
+	// Check if client wants sorted results (sorted=true is the older spelling of sort=recent)
+	sortMode := c.Query("sort")
+	if sortMode == "" && c.Query("sorted") == "true" {
+		sortMode = services.ChatroomSortRecent
+	}
+	if sortMode != "" {
+		if !services.IsValidChatroomSort(sortMode) {
+			respondErrorMessage(c, http.StatusBadRequest, "sort must be 'recent' or 'unread_first'")
+			return
+		}
+
 		// Use optimized sorted method
-		chatrooms, err := cc.ChatroomService.GetUserChatroomsSortedByLatestMessage(userID.(uint))
+		chatrooms, err := cc.ChatroomService.GetUserChatroomsSortedByLatestMessage(userID.(uint), sortMode)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			respondError(c, err)
 			return
 		}
 
+		// Pins are per user; a failure here only loses the pinned flags
+		pinnedAt, err := cc.ChatroomService.GetPinnedChatrooms(userID.(uint))
+		if err != nil {
+			log.Printf("Failed to load pinned chatrooms for user %d: %v", userID.(uint), err)
+		}
+
 		// Convert to response format
-		var response []any
+		sortedResponses := make([]models.ChatroomWithLatestMessageResponse, 0, len(chatrooms))
 		for _, chatroom := range chatrooms {
-			response = append(response, chatroom.ToResponse())
+			chatroomResponse := chatroom.ToResponse()
+			if t, ok := pinnedAt[chatroom.ID]; ok {
+				chatroomResponse.Pinned = true
+				chatroomResponse.PinnedAt = &t
+			}
+			sortedResponses = append(sortedResponses, chatroomResponse)
+		}
+
+		// Pinned rooms first (newest pin first); the rest keep the aggregation's order
+		sort.SliceStable(sortedResponses, func(i, j int) bool {
+			a, b := sortedResponses[i], sortedResponses[j]
+			if a.Pinned != b.Pinned {
+				return a.Pinned
+			}
+			return a.Pinned && a.PinnedAt.After(*b.PinnedAt)
+		})
+
+		var response []any
+		for _, chatroomResponse := range sortedResponses {
+			response = append(response, chatroomResponse)
 		}
 
 		c.JSON(http.StatusOK, gin.H{
@@ -169,14 +334,24 @@ func (cc *ChatroomController) GetChatroomsByUserID(c *gin.Context) {
 	// Legacy method - get user's joined chatrooms using the service
 	chatrooms, err := cc.ChatroomService.GetUserChatrooms(userID.(uint))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, err)
 		return
 	}
 
+	pinnedAt, err := cc.ChatroomService.GetPinnedChatrooms(userID.(uint))
+	if err != nil {
+		log.Printf("Failed to load pinned chatrooms for user %d: %v", userID.(uint), err)
+	}
+
 	// Convert to response format
 	var response []any
 	for _, chatroom := range chatrooms {
-		response = append(response, chatroom.ToResponse())
+		chatroomResponse := chatroom.ToResponse()
+		if t, ok := pinnedAt[chatroom.ID]; ok {
+			chatroomResponse.Pinned = true
+			chatroomResponse.PinnedAt = &t
+		}
+		response = append(response, chatroomResponse)
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -184,59 +359,100 @@ func (cc *ChatroomController) GetChatroomsByUserID(c *gin.Context) {
 	})
 }
 
+// MembershipUsage is how many chatrooms a user belongs to, against their cap
+type MembershipUsage struct {
+	Count     int64 `json:"count" example:"12"`
+	Limit     *int  `json:"limit" example:"200"`               // Null when the user is not capped (admins)
+	Remaining *int  `json:"remaining,omitempty" example:"188"` // Rooms the user can still join; omitted when not capped
+}
+
+// GetMembershipUsage handles getting the user's chatroom count and membership cap
+// @Summary Get chatroom membership usage
+// @Description Return how many chatrooms you belong to and the most you may belong to. Joining or creating a room fails once you are at the cap; admins are not capped
+// @Tags chatrooms
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} MembershipUsage "Room count and cap"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 500 {object} utils.APIError "Internal server error"
+// @Router /chatrooms/user/limit [get]
+func (cc *ChatroomController) GetMembershipUsage(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondErrorMessage(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	count, err := cc.ChatroomService.CountUserChatrooms(userID.(uint))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	usage := MembershipUsage{Count: count}
+	if limit := cc.membershipLimitFor(c); limit > 0 {
+		remaining := max(limit-int(count), 0)
+		usage.Limit = &limit
+		usage.Remaining = &remaining
+	}
+	c.JSON(http.StatusOK, usage)
+}
+
 // GetChatroomByID handles getting a specific chatroom by ID
 // @Summary Get a chatroom by ID
-// @Description Retrieve a specific chatroom by its ID
+// @Description Retrieve a specific chatroom by its ID. Members get the full chatroom; non-members only get the public view (name, has_password, member_count)
 // @Tags chatrooms
 // @Accept json
 // @Produce json
 // @Security ApiKeyAuth
 // @Param id path string true "Chatroom ID" example:"60d5f8b8e6b5f0b3e8b4b5b3"
-// @Success 200 {object} map[string]models.ChatroomResponse "Chatroom details"
-// @Failure 400 {object} map[string]string "Invalid chatroom ID"
-// @Failure 401 {object} map[string]string "User not authenticated"
-// @Failure 404 {object} map[string]string "Chatroom not found"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Success 200 {object} map[string]models.ChatroomResponse "Chatroom details (members) or models.ChatroomPublicResponse (non-members)"
+// @Failure 400 {object} utils.APIError "Invalid chatroom ID"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 404 {object} utils.APIError "Chatroom not found"
+// @Failure 500 {object} utils.APIError "Internal server error"
 // @Router /chatrooms/{id} [get]
 func (cc *ChatroomController) GetChatroomByID(c *gin.Context) {
 	// Get chatroom ID from URL
 	chatroomID, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chatroom ID"})
+		respondErrorMessage(c, http.StatusBadRequest, "Invalid chatroom ID")
 		return
 	}
 
 	// Get user ID from context (set by auth middleware)
-	_, exists := c.Get("user_id")
+	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		respondErrorMessage(c, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
 	// Get chatroom using the service
 	chatroom, err := cc.ChatroomService.GetChatroomByID(chatroomID)
 	if err != nil {
-		if err.Error() == "chatroom not found" {
-			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		}
+		respondError(c, err)
 		return
 	}
 
-	// Optional: Check if user is a member of the chatroom
-	// Comment out the following lines if you want to allow non-members to view chatroom info
-	/*
-		isMember := cc.ChatroomService.IsMember(chatroom, userID.(uint))
-		if !isMember {
-			c.JSON(http.StatusForbidden, gin.H{"error": "User is not a member of this chatroom"})
-			return
-		}
-	*/
+	// Non-members only see the public view so private rooms' membership can't be enumerated
+	if !cc.ChatroomService.IsMember(chatroom, userID.(uint)) {
+		c.JSON(http.StatusOK, gin.H{
+			"chatroom":  chatroom.ToPublicResponse(),
+			"is_member": false,
+		})
+		return
+	}
+
+	// Show when each member was last active, for those who share it; the room is still usable without it
+	response := chatroom.ToResponse()
+	if lastSeen, err := cc.UserService.GetLastSeen(memberIDs(chatroom)); err == nil {
+		response.Members = withLastSeen(response.Members, lastSeen)
+	}
 
 	// Return chatroom data
 	c.JSON(http.StatusOK, gin.H{
-		"chatroom": chatroom.ToResponse(),
+		"chatroom":  response,
+		"is_member": true,
 	})
 }
 
@@ -249,94 +465,369 @@ func (cc *ChatroomController) GetChatroomByID(c *gin.Context) {
 // @Security ApiKeyAuth
 // @Param id path string true "Chatroom ID" example:"60d5f8b8e6b5f0b3e8b4b5b3"
 // @Success 200 {object} map[string]string "Joined chatroom successfully"
-// @Failure 400 {object} map[string]string "Invalid chatroom ID"
-// @Failure 401 {object} map[string]string "User not authenticated"
-// @Failure 404 {object} map[string]string "Chatroom not found"
-// @Failure 409 {object} map[string]string "User is already a member of this chatroom"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Failure 400 {object} utils.APIError "Invalid chatroom ID"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 404 {object} utils.APIError "Chatroom not found"
+// @Failure 409 {object} utils.APIError "User is already a member of this chatroom"
+// @Failure 500 {object} utils.APIError "Internal server error"
 // @Router /chatrooms/{id}/join [post]
 func (cc *ChatroomController) JoinChatroom(c *gin.Context) {
 	// Get chatroom ID from URL
 	chatroomID, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chatroom ID"})
+		respondErrorMessage(c, http.StatusBadRequest, "Invalid chatroom ID")
 		return
 	}
 
 	// Get user ID from context (set by auth middleware)
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		respondErrorMessage(c, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 	username, _ := c.Get("username")
 
 	// Join chatroom using the service
-	err = cc.ChatroomService.JoinChatroom(chatroomID, userID.(uint), username.(string))
+	err = cc.ChatroomService.JoinChatroom(chatroomID, userID.(uint), username.(string), cc.membershipLimitFor(c))
 	if err != nil {
-		if err.Error() == "chatroom not found" {
-			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
-		} else if err.Error() == "user is already a member of this chatroom" {
-			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		}
+		respondError(c, err)
 		return
 	}
 
+	if chatroom, err := cc.ChatroomService.GetChatroomByID(chatroomID); err == nil {
+		cc.notifyMembershipChange(models.SystemEventMemberJoined, chatroom, userID.(uint), username.(string))
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Joined chatroom successfully"})
 }
 
+// LeaveChatroom handles leaving a chatroom
+// @Summary Leave a chatroom
+// @Description Leave a chatroom the user is a member of (the creator can't leave; they delete the chatroom instead)
+// @Tags chatrooms
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Chatroom ID"
+// @Success 200 {object} map[string]string "Left chatroom successfully"
+// @Failure 400 {object} utils.APIError "Invalid chatroom ID or creator tried to leave"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 403 {object} utils.APIError "User is not a member of this chatroom"
+// @Failure 404 {object} utils.APIError "Chatroom not found"
+// @Failure 500 {object} utils.APIError "Internal server error"
+// @Router /chatrooms/{id}/leave [post]
+func (cc *ChatroomController) LeaveChatroom(c *gin.Context) {
+	// Get chatroom ID from URL
+	chatroomID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, "Invalid chatroom ID")
+		return
+	}
+
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondErrorMessage(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+	username, _ := c.Get("username")
+
+	err = cc.ChatroomService.LeaveChatroom(chatroomID, userID.(uint))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	// Connections still viewing the room were admitted while the user was a member
+	cc.hub().RemoveFromRoom(chatroomID.Hex(), userID.(uint))
+
+	if chatroom, err := cc.ChatroomService.GetChatroomByID(chatroomID); err == nil {
+		cc.notifyMembershipChange(models.SystemEventMemberLeft, chatroom, userID.(uint), username.(string))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Left chatroom successfully"})
+}
+
+// GetMembership handles checking the authenticated user's membership in a chatroom
+// @Summary Get membership status
+// @Description Cheap "am I in this room?" check for rendering join/leave UI, without loading the member list
+// @Tags chatrooms
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Chatroom ID"
+// @Success 200 {object} models.ChatroomMembership "Membership status"
+// @Failure 400 {object} utils.APIError "Invalid chatroom ID"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 404 {object} utils.APIError "Chatroom not found"
+// @Failure 500 {object} utils.APIError "Internal server error"
+// @Router /chatrooms/{id}/membership [get]
+func (cc *ChatroomController) GetMembership(c *gin.Context) {
+	// Get chatroom ID from URL
+	chatroomID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, "Invalid chatroom ID")
+		return
+	}
+
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondErrorMessage(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	membership, err := cc.ChatroomService.GetMembership(chatroomID, userID.(uint))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, membership)
+}
+
+// PinChatroom handles pinning a chatroom to the top of the user's sidebar
+// @Summary Pin a chatroom
+// @Description Pin a chatroom for the authenticated user only. Pinned rooms are flagged in listings and sorted first by /chatrooms/user?sorted=true
+// @Tags chatrooms
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Chatroom ID"
+// @Success 200 {object} map[string]interface{} "Chatroom pinned"
+// @Failure 400 {object} utils.APIError "Invalid chatroom ID"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 403 {object} utils.APIError "User is not a member of this chatroom"
+// @Failure 404 {object} utils.APIError "Chatroom not found"
+// @Failure 500 {object} utils.APIError "Internal server error"
+// @Router /chatrooms/{id}/pin [post]
+func (cc *ChatroomController) PinChatroom(c *gin.Context) {
+	// Get chatroom ID from URL
+	chatroomID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, "Invalid chatroom ID")
+		return
+	}
+
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondErrorMessage(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	pin, err := cc.ChatroomService.PinChatroom(chatroomID, userID.(uint))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"chatroom_id": chatroomID.Hex(),
+		"pinned":      true,
+		"pinned_at":   pin.PinnedAt,
+	})
+}
+
+// UnpinChatroom handles removing a chatroom pin
+// @Summary Unpin a chatroom
+// @Description Remove the authenticated user's pin from a chatroom (no-op if it wasn't pinned)
+// @Tags chatrooms
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Chatroom ID"
+// @Success 200 {object} map[string]interface{} "Chatroom unpinned"
+// @Failure 400 {object} utils.APIError "Invalid chatroom ID"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 500 {object} utils.APIError "Internal server error"
+// @Router /chatrooms/{id}/pin [delete]
+func (cc *ChatroomController) UnpinChatroom(c *gin.Context) {
+	// Get chatroom ID from URL
+	chatroomID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, "Invalid chatroom ID")
+		return
+	}
+
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondErrorMessage(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	if err := cc.ChatroomService.UnpinChatroom(chatroomID, userID.(uint)); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"chatroom_id": chatroomID.Hex(),
+		"pinned":      false,
+	})
+}
+
+// notifyMembershipChange sends a member_joined/member_left event with the room's new member count
+// and records the change in the transcript as a system message
+func (cc *ChatroomController) notifyMembershipChange(eventType string, chatroom *models.Chatroom, userID uint, username string) {
+	hub := cc.hub()
+	memberData := map[string]any{
+		"chatroom_id":  chatroom.ID.Hex(),
+		"user_id":      userID,
+		"username":     username,
+		"member_count": len(chatroom.Members),
+	}
+	if eventType == models.SystemEventMemberLeft {
+		hub.BroadcastMemberLeft(chatroom.ID.Hex(), memberData, memberIDs(chatroom))
+		hub.BroadcastSelfSync(userID, SelfSyncEvent{Action: SelfSyncChatroomLeft, ChatroomID: chatroom.ID.Hex()}, nil)
+	} else {
+		hub.BroadcastMemberJoined(chatroom.ID.Hex(), memberData, memberIDs(chatroom))
+		hub.BroadcastSelfSync(userID, SelfSyncEvent{Action: SelfSyncChatroomJoined, ChatroomID: chatroom.ID.Hex()}, nil)
+	}
+
+	cc.recordSystemEvent(chatroom, models.SystemEvent{
+		Type:     eventType,
+		UserID:   userID,
+		Username: username,
+	})
+}
+
+// recordSystemEvent adds a system message for event to the chatroom's transcript and broadcasts it
+func (cc *ChatroomController) recordSystemEvent(chatroom *models.Chatroom, event models.SystemEvent) {
+	message, err := cc.MessageService.CreateSystemMessage(chatroom.ID, event)
+	if err != nil {
+		log.Printf("Failed to record %s notice in chatroom %s: %v", event.Type, chatroom.ID.Hex(), err)
+		return
+	}
+	cc.hub().BroadcastNewMessage(chatroom.ID.Hex(), message.ToResponse(), memberIDs(chatroom))
+}
+
 // JoinChatroomByCode handles joining a chatroom using room code
 // @Summary Join a chatroom by room code
-// @Description Join a chatroom using its 6-character room code and optional password
+// @Description Join a chatroom using its 6-character room code and optional password. The code is trimmed and uppercased before lookup.
+// @Description With idempotent=true, joining a room you already belong to succeeds with already_member set (the password is still checked), which suits deep links; otherwise it is a 409
 // @Tags chatrooms
 // @Accept json
 // @Produce json
 // @Security ApiKeyAuth
 // @Param request body JoinChatroomByCodeRequest true "Room code and password"
-// @Success 200 {object} map[string]models.ChatroomResponse "Joined chatroom successfully"
-// @Failure 400 {object} map[string]string "Invalid request body"
-// @Failure 401 {object} map[string]string "User not authenticated"
-// @Failure 403 {object} map[string]string "Incorrect password"
-// @Failure 404 {object} map[string]string "Room not found"
-// @Failure 409 {object} map[string]string "User is already a member of this chatroom"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Param idempotent query bool false "Treat already being a member as success"
+// @Success 200 {object} map[string]interface{} "Joined chatroom successfully (or already a member, in idempotent mode)"
+// @Failure 400 {object} utils.APIError "Invalid request body or malformed room code"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 403 {object} utils.APIError "Incorrect password"
+// @Failure 404 {object} utils.APIError "Room not found"
+// @Failure 409 {object} utils.APIError "User is already a member of this chatroom"
+// @Failure 500 {object} utils.APIError "Internal server error"
 // @Router /chatrooms/join [post]
 func (cc *ChatroomController) JoinChatroomByCode(c *gin.Context) {
 	var req JoinChatroomByCodeRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": utils.FormatValidationError(err)})
+		respondErrorMessage(c, http.StatusBadRequest, utils.FormatValidationError(err))
 		return
 	}
 
 	// Get user ID from context (set by auth middleware)
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		respondErrorMessage(c, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 	username, _ := c.Get("username")
 
 	// Join chatroom using the service
-	chatroom, err := cc.ChatroomService.JoinChatroomByCode(req.RoomCode, req.Password, userID.(uint), username.(string))
+	chatroom, err := cc.ChatroomService.JoinChatroomByCode(req.RoomCode, req.Password, userID.(uint), username.(string), cc.membershipLimitFor(c))
 	if err != nil {
-		switch err.Error() {
-		case "room not found":
-			c.JSON(http.StatusNotFound, gin.H{"error": "Room not found. Please check the room code."})
-		case "incorrect password":
-			c.JSON(http.StatusForbidden, gin.H{"error": "Incorrect password"})
-		case "user is already a member of this chatroom":
-			c.JSON(http.StatusConflict, gin.H{"error": "You are already a member of this chatroom"})
-		default:
-			c.JSON(http.StatusInternalServerError, gin.H{"error": utils.FormatServiceError(err)})
+		// Deep links can be opened repeatedly; in idempotent mode an existing membership is a success
+		if c.Query("idempotent") == "true" && err.Error() == "user is already a member of this chatroom" {
+			existing, lookupErr := cc.ChatroomService.GetChatroomByRoomCode(req.RoomCode)
+			if lookupErr == nil {
+				c.JSON(http.StatusOK, gin.H{
+					"message":        "Already a member of this chatroom",
+					"chatroom":       existing.ToResponse(),
+					"already_member": true,
+				})
+				return
+			}
 		}
+		respondError(c, err)
 		return
 	}
 
+	cc.notifyMembershipChange(models.SystemEventMemberJoined, chatroom, userID.(uint), username.(string))
+
 	c.JSON(http.StatusOK, gin.H{
-		"message":  "Joined chatroom successfully",
-		"chatroom": chatroom.ToResponse(),
+		"message":        "Joined chatroom successfully",
+		"chatroom":       chatroom.ToResponse(),
+		"already_member": false,
+	})
+}
+
+// JoinChatroomsBatch handles joining several chatrooms by room code in one request
+// @Summary Join multiple chatrooms by room code
+// @Description Try to join each room in the list and report a per-room result (joined, already_member, wrong_password, not_found, invalid_code, limit_reached, failed). Rooms the user already belongs to are skipped without failing the batch.
+// @Tags chatrooms
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body JoinChatroomsBatchRequest true "Room codes and passwords"
+// @Success 200 {object} map[string]interface{} "Per-room results"
+// @Failure 400 {object} utils.APIError "Invalid request body"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Router /chatrooms/join-batch [post]
+func (cc *ChatroomController) JoinChatroomsBatch(c *gin.Context) {
+	var req JoinChatroomsBatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, utils.FormatValidationError(err))
+		return
+	}
+
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondErrorMessage(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+	username, _ := c.Get("username")
+
+	membershipLimit := cc.membershipLimitFor(c)
+	results := make([]BatchJoinResult, 0, len(req.Rooms))
+	joined := 0
+	for _, room := range req.Rooms {
+		result := BatchJoinResult{RoomCode: room.RoomCode}
+
+		chatroom, err := cc.ChatroomService.JoinChatroomByCode(room.RoomCode, room.Password, userID.(uint), username.(string), membershipLimit)
+		if err != nil {
+			switch err.Error() {
+			case "room not found":
+				result.Status = BatchJoinNotFound
+			case "invalid room code format":
+				result.Status = BatchJoinInvalidCode
+			case "incorrect password":
+				result.Status = BatchJoinWrongPassword
+			case "chatroom membership limit reached":
+				result.Status = BatchJoinLimitReached
+			case "user is already a member of this chatroom":
+				result.Status = BatchJoinAlreadyMember
+				if existing, lookupErr := cc.ChatroomService.GetChatroomByRoomCode(room.RoomCode); lookupErr == nil {
+					result.ChatroomID = existing.ID.Hex()
+				}
+			default:
+				result.Status = BatchJoinFailed
+			}
+			results = append(results, result)
+			continue
+		}
+
+		cc.notifyMembershipChange(models.SystemEventMemberJoined, chatroom, userID.(uint), username.(string))
+
+		response := chatroom.ToResponse()
+		result.Status = BatchJoinJoined
+		result.ChatroomID = chatroom.ID.Hex()
+		result.Chatroom = &response
+		results = append(results, result)
+		joined++
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"results": results,
+		"joined":  joined,
 	})
 }
 
@@ -347,41 +838,276 @@ func (cc *ChatroomController) JoinChatroomByCode(c *gin.Context) {
 // @Produce json
 // @Param id path string true "Chatroom ID"
 // @Success 200 {object} map[string]string "Chatroom deleted successfully"
-// @Failure 400 {object} map[string]string "Bad request"
-// @Failure 401 {object} map[string]string "Unauthorized"
-// @Failure 403 {object} map[string]string "Forbidden"
-// @Failure 404 {object} map[string]string "Chatroom not found"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Failure 401 {object} utils.APIError "Unauthorized"
+// @Failure 403 {object} utils.APIError "Forbidden"
+// @Failure 404 {object} utils.APIError "Chatroom not found"
+// @Failure 500 {object} utils.APIError "Internal server error"
 // @Security BearerAuth
 // @Router /api/chatrooms/{id} [delete]
 func (cc *ChatroomController) DeleteChatroom(c *gin.Context) {
 	// Get chatroom ID from URL
 	chatroomID, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Please provide a valid chatroom ID"})
+		respondErrorMessage(c, http.StatusBadRequest, "Please provide a valid chatroom ID")
 		return
 	}
 
 	// Get user ID from context (set by auth middleware)
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Please log in to continue"})
+		respondErrorMessage(c, http.StatusUnauthorized, "Please log in to continue")
 		return
 	}
 
 	// Delete chatroom using the service
 	err = cc.ChatroomService.DeleteChatroom(chatroomID, userID.(uint), cc.MessageService)
 	if err != nil {
-		switch err.Error() {
-		case "chatroom not found":
-			c.JSON(http.StatusNotFound, gin.H{"error": utils.FormatServiceError(err)})
-		case "only the creator can delete this chatroom":
-			c.JSON(http.StatusForbidden, gin.H{"error": "You can only delete chatrooms that you created"})
-		default:
-			c.JSON(http.StatusInternalServerError, gin.H{"error": utils.FormatServiceError(err)})
-		}
+		respondError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Chatroom deleted successfully"})
 }
+
+// ClearChatroom handles clearing a chatroom's message history
+// @Summary Clear a chatroom's messages
+// @Description Delete all messages (and media) in a chatroom while keeping the room and its members (only creator can clear)
+// @Tags chatrooms
+// @Produce json
+// @Param id path string true "Chatroom ID"
+// @Success 200 {object} map[string]string "Chatroom cleared successfully"
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Failure 401 {object} utils.APIError "Unauthorized"
+// @Failure 403 {object} utils.APIError "Forbidden"
+// @Failure 404 {object} utils.APIError "Chatroom not found"
+// @Failure 500 {object} utils.APIError "Internal server error"
+// @Security BearerAuth
+// @Router /api/chatrooms/{id}/clear [post]
+func (cc *ChatroomController) ClearChatroom(c *gin.Context) {
+	// Get chatroom ID from URL
+	chatroomID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, "Please provide a valid chatroom ID")
+		return
+	}
+
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondErrorMessage(c, http.StatusUnauthorized, "Please log in to continue")
+		return
+	}
+
+	// Clear chatroom messages using the service
+	err = cc.ChatroomService.ClearChatroomMessages(chatroomID, userID.(uint), cc.MessageService)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	// Tell the members' clients to empty their message views
+	cc.hub().BroadcastChatroomCleared(chatroomID.Hex(), map[string]any{
+		"chatroom_id": chatroomID.Hex(),
+		"cleared_by":  userID.(uint),
+	}, roomRecipients(cc.ChatroomService, chatroomID, userID.(uint)))
+
+	c.JSON(http.StatusOK, gin.H{"message": "Chatroom cleared successfully"})
+}
+
+// SetMemberRole handles changing a member's role in a chatroom
+// @Summary Set a member's chatroom role
+// @Description Set a member's role to member, readonly (can't post) or admin (can delete others' messages). Only the creator can change roles
+// @Tags chatrooms
+// @Accept json
+// @Produce json
+// @Param id path string true "Chatroom ID"
+// @Param user_id path int true "Member user ID"
+// @Param request body SetMemberRoleRequest true "New role"
+// @Success 200 {object} map[string]interface{} "Member role updated successfully"
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Failure 401 {object} utils.APIError "Unauthorized"
+// @Failure 403 {object} utils.APIError "Forbidden"
+// @Failure 404 {object} utils.APIError "Chatroom or member not found"
+// @Failure 500 {object} utils.APIError "Internal server error"
+// @Security BearerAuth
+// @Router /api/chatrooms/{id}/members/{user_id}/role [put]
+func (cc *ChatroomController) SetMemberRole(c *gin.Context) {
+	// Get chatroom ID from URL
+	chatroomID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, "Please provide a valid chatroom ID")
+		return
+	}
+
+	// Get target user ID from URL
+	targetUserID, err := strconv.ParseUint(c.Param("user_id"), 10, 32)
+	if err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, "Please provide a valid user ID")
+		return
+	}
+
+	var req SetMemberRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, utils.FormatValidationError(err))
+		return
+	}
+
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondErrorMessage(c, http.StatusUnauthorized, "Please log in to continue")
+		return
+	}
+
+	err = cc.ChatroomService.SetMemberRole(chatroomID, userID.(uint), uint(targetUserID), req.Role)
+	if err != nil {
+		apiErr := utils.ServiceAPIError(err)
+		if err.Error() == "user is not a member of this chatroom" {
+			apiErr = apiErr.WithStatus(http.StatusNotFound) // Here it's the target user who isn't a member
+		}
+		respondError(c, apiErr)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Member role updated successfully",
+		"user_id": uint(targetUserID),
+		"role":    req.Role,
+	})
+}
+
+// UpdateChatroom handles updating a chatroom's name, description and topic
+// @Summary Update chatroom details
+// @Description Update a chatroom's name, description, topic and settings (only chatroom admins can update; allowed media types and read receipts are creator only). Broadcasts a chatroom_updated event, and a rename also adds a system message to the transcript
+// @Tags chatrooms
+// @Accept json
+// @Produce json
+// @Param id path string true "Chatroom ID"
+// @Param request body UpdateChatroomRequest true "Chatroom details"
+// @Success 200 {object} map[string]models.ChatroomResponse "Chatroom updated successfully"
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Failure 401 {object} utils.APIError "Unauthorized"
+// @Failure 403 {object} utils.APIError "Forbidden"
+// @Failure 404 {object} utils.APIError "Chatroom not found"
+// @Failure 500 {object} utils.APIError "Internal server error"
+// @Security BearerAuth
+// @Router /api/chatrooms/{id} [put]
+func (cc *ChatroomController) UpdateChatroom(c *gin.Context) {
+	var req UpdateChatroomRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, utils.FormatValidationError(err))
+		return
+	}
+
+	// Get chatroom ID from URL
+	chatroomID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, "Please provide a valid chatroom ID")
+		return
+	}
+
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondErrorMessage(c, http.StatusUnauthorized, "Please log in to continue")
+		return
+	}
+
+	chatroom, renamedFrom, err := cc.ChatroomService.UpdateChatroomDetails(chatroomID, userID.(uint), req.Name, req.Description, req.Topic, req.FilterPolicy, req.AllowedMediaTypes, req.ReadReceipts, req.EditWindowMinutes)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	// Let connected clients refresh the chat header and sidebar
+	cc.hub().BroadcastChatroomUpdated(chatroomID.Hex(), map[string]any{
+		"chatroom_id":           chatroomID.Hex(),
+		"name":                  chatroom.Name,
+		"description":           chatroom.Description,
+		"topic":                 chatroom.Topic,
+		"filter_policy":         chatroom.GetFilterPolicy(),
+		"allowed_media_types":   chatroom.GetAllowedMediaTypes(),
+		"read_receipts_enabled": chatroom.ReadReceiptsEnabled(),
+		"edit_window_minutes":   chatroom.EditWindowMinutes,
+		"updated_by":            userID.(uint),
+	}, memberIDs(chatroom))
+
+	if renamedFrom != "" {
+		username, _ := c.Get("username")
+		cc.recordSystemEvent(chatroom, models.SystemEvent{
+			Type:         models.SystemEventChatroomRenamed,
+			UserID:       userID.(uint),
+			Username:     username.(string),
+			Name:         chatroom.Name,
+			PreviousName: renamedFrom,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"chatroom": chatroom.ToResponse(),
+	})
+}
+
+// GetChatroomInfo handles getting a chatroom's creation info and stats
+// @Summary Get chatroom info
+// @Description Creation date, creator, member and message counts and password flag for a room's info panel (members only)
+// @Tags chatrooms
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Chatroom ID"
+// @Success 200 {object} services.ChatroomInfo "Chatroom info"
+// @Failure 400 {object} utils.APIError "Invalid chatroom ID"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 403 {object} utils.APIError "User is not a member of this chatroom"
+// @Failure 404 {object} utils.APIError "Chatroom not found"
+// @Failure 500 {object} utils.APIError "Internal server error"
+// @Router /chatrooms/{id}/info [get]
+func (cc *ChatroomController) GetChatroomInfo(c *gin.Context) {
+	chatroomID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, "Please provide a valid chatroom ID")
+		return
+	}
+
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondErrorMessage(c, http.StatusUnauthorized, "Please log in to continue")
+		return
+	}
+
+	info, err := cc.ChatroomService.GetChatroomInfo(chatroomID, userID.(uint))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	// The creator may have renamed themselves (or left, taking their member entry with them)
+	if creator, err := cc.UserService.GetUserByID(info.CreatedBy); err == nil {
+		info.CreatedByName = creator.Username
+	}
+
+	c.JSON(http.StatusOK, info)
+}
+
+// memberIDs lists the user IDs of a chatroom's members
+func memberIDs(chatroom *models.Chatroom) []uint {
+	ids := make([]uint, 0, len(chatroom.Members))
+	for _, member := range chatroom.Members {
+		ids = append(ids, member.UserID)
+	}
+	return ids
+}
+
+// withLastSeen returns a copy of members with LastSeenAt filled in from lastSeen
+func withLastSeen(members []models.ChatroomMember, lastSeen map[uint]time.Time) []models.ChatroomMember {
+	result := make([]models.ChatroomMember, len(members))
+	for i, member := range members {
+		if seen, ok := lastSeen[member.UserID]; ok {
+			member.LastSeenAt = &seen
+		}
+		result[i] = member
+	}
+	return result
+}
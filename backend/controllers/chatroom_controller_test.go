@@ -0,0 +1,1022 @@
+package controllers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ginchat/config"
+	"github.com/ginchat/controllers"
+	"github.com/ginchat/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestGetChatroomByIDMemberAndPublicViews(t *testing.T) {
+	env := newAPIEnv(t)
+	alice, bob, mallory := env.user(t, "alice"), env.user(t, "bob"), env.user(t, "mallory")
+	roomID := env.createRoom(t, alice, "Private plans", bob)
+
+	t.Run("member gets the full view", func(t *testing.T) {
+		var body struct {
+			IsMember bool `json:"is_member"`
+			Chatroom struct {
+				RoomCode string `json:"room_code"`
+				Members  []struct {
+					UserID uint `json:"user_id"`
+				} `json:"members"`
+			} `json:"chatroom"`
+		}
+		expect(t, env.do(t, bob, http.MethodGet, "/api/chatrooms/"+roomID, nil), http.StatusOK, &body)
+		if !body.IsMember || len(body.Chatroom.Members) != 2 || body.Chatroom.RoomCode == "" {
+			t.Fatalf("member view = %+v", body)
+		}
+	})
+
+	t.Run("non-member gets only the public view", func(t *testing.T) {
+		var body struct {
+			IsMember bool                       `json:"is_member"`
+			Chatroom map[string]json.RawMessage `json:"chatroom"`
+		}
+		expect(t, env.do(t, mallory, http.MethodGet, "/api/chatrooms/"+roomID, nil), http.StatusOK, &body)
+		if body.IsMember {
+			t.Fatal("non-member reported as a member")
+		}
+		for _, hidden := range []string{"members", "room_code", "created_by", "description"} {
+			if _, ok := body.Chatroom[hidden]; ok {
+				t.Errorf("public view exposes %q", hidden)
+			}
+		}
+		if string(body.Chatroom["name"]) != `"Private plans"` || string(body.Chatroom["member_count"]) != "2" {
+			t.Errorf("public view = %v, want the name and member count", body.Chatroom)
+		}
+	})
+
+	t.Run("unknown room is a 404", func(t *testing.T) {
+		expect(t, env.do(t, bob, http.MethodGet, "/api/chatrooms/000000000000000000000000", nil), http.StatusNotFound, nil)
+	})
+}
+
+func TestClearChatroom(t *testing.T) {
+	env := newAPIEnv(t)
+	alice, bob, mallory := env.user(t, "alice"), env.user(t, "bob"), env.user(t, "mallory")
+	roomID := env.createRoom(t, alice, "Cleared room", bob)
+	otherID := env.createRoom(t, mallory, "Other room", bob)
+	env.send(t, alice, roomID, "one")
+	read := env.send(t, alice, roomID, "two")
+	expect(t, env.do(t, bob, http.MethodPost, "/api/messages/"+read+"/mark-read", nil), http.StatusOK, nil)
+	env.send(t, mallory, otherID, "elsewhere")
+
+	inRoom := env.dial(t, alice, roomID)
+	sidebar := env.dial(t, bob, "global_sidebar")
+	outsider := env.dial(t, mallory, "global_sidebar")
+
+	roomMessages := func(chatroomID string) int {
+		n := 0
+		for _, doc := range env.MongoDB.Documents("messages") {
+			if id, _ := doc.Map()["chatroom_id"].(primitive.ObjectID); id.Hex() == chatroomID {
+				n++
+			}
+		}
+		return n
+	}
+	before, otherBefore := roomMessages(roomID), roomMessages(otherID)
+
+	t.Run("only the creator may clear", func(t *testing.T) {
+		expect(t, env.do(t, bob, http.MethodPost, "/api/chatrooms/"+roomID+"/clear", nil), http.StatusForbidden, nil)
+		if n := roomMessages(roomID); n != before {
+			t.Fatalf("a refused clear left %d messages, want %d", n, before)
+		}
+	})
+
+	expect(t, env.do(t, alice, http.MethodPost, "/api/chatrooms/"+roomID+"/clear", nil), http.StatusOK, nil)
+
+	t.Run("messages and read state are removed for this room only", func(t *testing.T) {
+		for _, collection := range []string{"messages", "message_read_status", "user_last_read"} {
+			for _, doc := range env.MongoDB.Documents(collection) {
+				if chatroomID, _ := doc.Map()["chatroom_id"].(primitive.ObjectID); chatroomID.Hex() == roomID {
+					t.Errorf("%s still has %v", collection, doc)
+				}
+			}
+		}
+		if n := roomMessages(otherID); n != otherBefore {
+			t.Errorf("the other room has %d messages, want %d", n, otherBefore)
+		}
+		var count struct {
+			UnreadCount int64 `json:"unread_count"`
+		}
+		expect(t, env.do(t, bob, http.MethodGet, "/api/chatrooms/"+roomID+"/unread-count", nil), http.StatusOK, &count)
+		if count.UnreadCount != 0 {
+			t.Errorf("bob's unread count after clearing = %d, want 0", count.UnreadCount)
+		}
+	})
+
+	t.Run("chatroom_cleared reaches each member connection once", func(t *testing.T) {
+		if n := len(inRoom.collect(300 * time.Millisecond)["chatroom_cleared"]); n != 1 {
+			t.Errorf("creator viewing the room got %d chatroom_cleared, want 1", n)
+		}
+		if n := len(sidebar.collect(100 * time.Millisecond)["chatroom_cleared"]); n != 1 {
+			t.Errorf("member on the sidebar got %d chatroom_cleared, want 1", n)
+		}
+		if n := len(outsider.collect(100 * time.Millisecond)["chatroom_cleared"]); n != 0 {
+			t.Errorf("non-member got %d chatroom_cleared, want 0", n)
+		}
+	})
+}
+
+func TestChatroomDescriptionAndTopic(t *testing.T) {
+	env := newAPIEnv(t)
+	alice, bob, mallory := env.user(t, "alice"), env.user(t, "bob"), env.user(t, "mallory")
+
+	type details struct {
+		Chatroom struct {
+			ID          string `json:"id"`
+			Description string `json:"description"`
+			Topic       string `json:"topic"`
+		} `json:"chatroom"`
+	}
+	var created details
+	expect(t, env.do(t, alice, http.MethodPost, "/api/chatrooms", map[string]string{
+		"name": "Planning", "description": "Trips and outings", "topic": "Weekend plans",
+	}), http.StatusCreated, &created)
+	roomID := created.Chatroom.ID
+	if created.Chatroom.Description != "Trips and outings" || created.Chatroom.Topic != "Weekend plans" {
+		t.Fatalf("created room has description %q, topic %q", created.Chatroom.Description, created.Chatroom.Topic)
+	}
+	env.join(t, bob, roomID)
+
+	inRoom := env.dial(t, alice, roomID)
+	sidebar := env.dial(t, bob, "global_sidebar")
+	outsider := env.dial(t, mallory, "global_sidebar")
+
+	t.Run("invalid updates are refused", func(t *testing.T) {
+		expect(t, env.do(t, bob, http.MethodPut, "/api/chatrooms/"+roomID, map[string]string{"topic": "Mine now"}), http.StatusForbidden, nil)
+		expect(t, env.do(t, alice, http.MethodPut, "/api/chatrooms/"+roomID, map[string]string{"topic": strings.Repeat("x", 101)}), http.StatusBadRequest, nil)
+		expect(t, env.do(t, alice, http.MethodPut, "/api/chatrooms/"+roomID, map[string]string{"description": strings.Repeat("x", 501)}), http.StatusBadRequest, nil)
+	})
+
+	t.Run("updating the topic keeps the description", func(t *testing.T) {
+		var updated details
+		expect(t, env.do(t, alice, http.MethodPut, "/api/chatrooms/"+roomID, map[string]string{"topic": "Ski trip"}), http.StatusOK, &updated)
+		if updated.Chatroom.Topic != "Ski trip" || updated.Chatroom.Description != "Trips and outings" {
+			t.Errorf("after update: description %q, topic %q", updated.Chatroom.Description, updated.Chatroom.Topic)
+		}
+		var fetched details
+		expect(t, env.do(t, bob, http.MethodGet, "/api/chatrooms/"+roomID, nil), http.StatusOK, &fetched)
+		if fetched.Chatroom.Topic != "Ski trip" {
+			t.Errorf("members see topic %q, want the update", fetched.Chatroom.Topic)
+		}
+	})
+
+	t.Run("chatroom_updated reaches each member connection once and no one else", func(t *testing.T) {
+		updates := inRoom.collect(300 * time.Millisecond)["chatroom_updated"]
+		if len(updates) != 1 {
+			t.Fatalf("creator viewing the room got %d chatroom_updated, want 1", len(updates))
+		}
+		var data struct {
+			Topic       string `json:"topic"`
+			Description string `json:"description"`
+		}
+		if err := json.Unmarshal(updates[0].Data, &data); err != nil || data.Topic != "Ski trip" || data.Description != "Trips and outings" {
+			t.Errorf("chatroom_updated data = %s", updates[0].Data)
+		}
+		if n := len(sidebar.collect(100 * time.Millisecond)["chatroom_updated"]); n != 1 {
+			t.Errorf("member on the sidebar got %d chatroom_updated, want 1", n)
+		}
+		if n := len(outsider.collect(100 * time.Millisecond)["chatroom_updated"]); n != 0 {
+			t.Errorf("non-member got %d chatroom_updated, want 0", n)
+		}
+	})
+
+	t.Run("an empty string clears a field", func(t *testing.T) {
+		var cleared details
+		expect(t, env.do(t, alice, http.MethodPut, "/api/chatrooms/"+roomID, map[string]string{"description": ""}), http.StatusOK, &cleared)
+		if cleared.Chatroom.Description != "" || cleared.Chatroom.Topic != "Ski trip" {
+			t.Errorf("after clearing: description %q, topic %q", cleared.Chatroom.Description, cleared.Chatroom.Topic)
+		}
+	})
+}
+
+func TestJoinChatroomsBatchMixedResults(t *testing.T) {
+	env := newAPIEnv(t)
+	alice, bob := env.user(t, "alice"), env.user(t, "bob")
+
+	roomCode := func(name, password string, members ...*apiUser) (string, string) {
+		var created struct {
+			Chatroom struct {
+				ID       string `json:"id"`
+				RoomCode string `json:"room_code"`
+			} `json:"chatroom"`
+		}
+		expect(t, env.do(t, alice, http.MethodPost, "/api/chatrooms", map[string]string{"name": name, "password": password}), http.StatusCreated, &created)
+		for _, member := range members {
+			env.join(t, member, created.Chatroom.ID)
+		}
+		return created.Chatroom.ID, created.Chatroom.RoomCode
+	}
+	openID, open := roomCode("Open room", "")
+	lockedID, locked := roomCode("Locked room", "letmein")
+	_, secret := roomCode("Secret room", "hunter22")
+	memberID, member := roomCode("Already in", "", bob)
+
+	type result struct {
+		RoomCode   string `json:"room_code"`
+		Status     string `json:"status"`
+		ChatroomID string `json:"chatroom_id"`
+	}
+	var body struct {
+		Results []result `json:"results"`
+		Joined  int      `json:"joined"`
+	}
+	expect(t, env.do(t, bob, http.MethodPost, "/api/chatrooms/join-batch", map[string]any{
+		"rooms": []map[string]string{
+			{"room_code": " " + strings.ToLower(open)}, // Typed loosely, as codes often are
+			{"room_code": locked, "password": "letmein"},
+			{"room_code": secret, "password": "wrong"},
+			{"room_code": member},
+			{"room_code": "ZZZZZZ"},
+			{"room_code": "nope"},
+		},
+	}), http.StatusOK, &body)
+
+	want := []result{
+		{Status: "joined", ChatroomID: openID},
+		{Status: "joined", ChatroomID: lockedID},
+		{Status: "wrong_password"},
+		{Status: "already_member", ChatroomID: memberID},
+		{Status: "not_found"},
+		{Status: "invalid_code"},
+	}
+	if len(body.Results) != len(want) {
+		t.Fatalf("got %d results, want %d: %+v", len(body.Results), len(want), body.Results)
+	}
+	for i, got := range body.Results {
+		if got.Status != want[i].Status || got.ChatroomID != want[i].ChatroomID {
+			t.Errorf("result %d (%s) = %s %q, want %s %q", i, got.RoomCode, got.Status, got.ChatroomID, want[i].Status, want[i].ChatroomID)
+		}
+	}
+	if body.Joined != 2 {
+		t.Errorf("joined = %d, want 2", body.Joined)
+	}
+
+	// Only the joined rooms were added to
+	var rooms struct {
+		Chatrooms []struct {
+			ID string `json:"id"`
+		} `json:"chatrooms"`
+	}
+	expect(t, env.do(t, bob, http.MethodGet, "/api/chatrooms/user", nil), http.StatusOK, &rooms)
+	got := map[string]bool{}
+	for _, room := range rooms.Chatrooms {
+		got[room.ID] = true
+	}
+	if len(got) != 3 || !got[openID] || !got[lockedID] || !got[memberID] {
+		t.Errorf("bob is in %v, want the open, locked and existing rooms", got)
+	}
+
+	expect(t, env.do(t, bob, http.MethodPost, "/api/chatrooms/join-batch", map[string]any{"rooms": []any{}}), http.StatusBadRequest, nil)
+}
+
+func TestMemberJoinedAndLeftEvents(t *testing.T) {
+	env := newAPIEnv(t)
+	alice, bob, carol, mallory := env.user(t, "alice"), env.user(t, "bob"), env.user(t, "carol"), env.user(t, "mallory")
+	var created struct {
+		Chatroom struct {
+			ID       string `json:"id"`
+			RoomCode string `json:"room_code"`
+		} `json:"chatroom"`
+	}
+	expect(t, env.do(t, alice, http.MethodPost, "/api/chatrooms", map[string]string{"name": "Lobby"}), http.StatusCreated, &created)
+	roomID := created.Chatroom.ID
+	env.join(t, bob, roomID)
+
+	inRoom := env.dial(t, alice, roomID)
+	sidebar := env.dial(t, bob, "global_sidebar")
+	outsider := env.dial(t, mallory, "global_sidebar")
+
+	type memberEvent struct {
+		ChatroomID  string `json:"chatroom_id"`
+		UserID      uint   `json:"user_id"`
+		Username    string `json:"username"`
+		MemberCount int    `json:"member_count"`
+	}
+	// check expects exactly one event of the given type on each member connection and none for the outsider
+	check := func(t *testing.T, eventType string, want memberEvent) {
+		t.Helper()
+		for name, socket := range map[string]*apiSocket{"member viewing the room": inRoom, "member on the sidebar": sidebar} {
+			events := socket.collect(300 * time.Millisecond)[eventType]
+			if len(events) != 1 {
+				t.Errorf("%s got %d %s, want 1", name, len(events), eventType)
+				continue
+			}
+			var got memberEvent
+			if err := json.Unmarshal(events[0].Data, &got); err != nil || got != want || events[0].ChatroomID != roomID {
+				t.Errorf("%s got %s for %s, want %+v", name, events[0].Data, events[0].ChatroomID, want)
+			}
+		}
+		if n := len(outsider.collect(100 * time.Millisecond)[eventType]); n != 0 {
+			t.Errorf("non-member got %d %s, want 0", n, eventType)
+		}
+	}
+
+	t.Run("join by code", func(t *testing.T) {
+		expect(t, env.do(t, carol, http.MethodPost, "/api/chatrooms/join", map[string]string{"room_code": created.Chatroom.RoomCode}), http.StatusOK, nil)
+		check(t, "member_joined", memberEvent{ChatroomID: roomID, UserID: carol.ID, Username: "carol", MemberCount: 3})
+	})
+
+	t.Run("leave", func(t *testing.T) {
+		expect(t, env.do(t, carol, http.MethodPost, "/api/chatrooms/"+roomID+"/leave", nil), http.StatusOK, nil)
+		check(t, "member_left", memberEvent{ChatroomID: roomID, UserID: carol.ID, Username: "carol", MemberCount: 2})
+	})
+
+	t.Run("join by ID", func(t *testing.T) {
+		env.join(t, carol, roomID)
+		check(t, "member_joined", memberEvent{ChatroomID: roomID, UserID: carol.ID, Username: "carol", MemberCount: 3})
+	})
+}
+
+func TestLeavingStopsRoomMessages(t *testing.T) {
+	env := newAPIEnv(t)
+	alice, bob := env.user(t, "alice"), env.user(t, "bob")
+	roomID := env.createRoom(t, alice, "General", bob)
+
+	// bob keeps viewing the room after leaving it from another device
+	viewer := env.dial(t, bob, roomID)
+	expect(t, env.do(t, bob, http.MethodPost, "/api/chatrooms/"+roomID+"/leave", nil), http.StatusOK, nil)
+	viewer.collect(300 * time.Millisecond)
+
+	env.send(t, alice, roomID, "not for bob")
+	events := viewer.collect(300 * time.Millisecond)
+	for _, eventType := range []string{"new_message", "sidebar_update"} {
+		if n := len(events[eventType]); n != 0 {
+			t.Errorf("former member got %d %s after leaving", n, eventType)
+		}
+	}
+}
+
+func TestPinnedChatroomsSortFirst(t *testing.T) {
+	env := newAPIEnv(t)
+	alice, bob := env.user(t, "alice"), env.user(t, "bob")
+	first := env.createRoom(t, alice, "First", bob)
+	second := env.createRoom(t, alice, "Second", bob)
+	third := env.createRoom(t, alice, "Third", bob)
+	for _, roomID := range []string{first, second, third} {
+		env.send(t, alice, roomID, "hello") // The third room has the latest message
+		time.Sleep(5 * time.Millisecond)    // Message times are stored to the millisecond
+	}
+
+	type pinState struct {
+		ID       string     `json:"id"`
+		Pinned   bool       `json:"pinned"`
+		PinnedAt *time.Time `json:"pinned_at"`
+	}
+	list := func(t *testing.T, user *apiUser, query string) []pinState {
+		t.Helper()
+		var body struct {
+			Chatrooms []pinState `json:"chatrooms"`
+		}
+		expect(t, env.do(t, user, http.MethodGet, "/api/chatrooms/user"+query, nil), http.StatusOK, &body)
+		return body.Chatrooms
+	}
+	order := func(rooms []pinState) []string {
+		ids := make([]string, len(rooms))
+		for i, room := range rooms {
+			ids[i] = room.ID
+		}
+		return ids
+	}
+	pin := func(t *testing.T, roomID string) time.Time {
+		t.Helper()
+		var body struct {
+			PinnedAt time.Time `json:"pinned_at"`
+		}
+		expect(t, env.do(t, alice, http.MethodPost, "/api/chatrooms/"+roomID+"/pin", nil), http.StatusOK, &body)
+		return body.PinnedAt
+	}
+
+	firstPinned := pin(t, first)
+	time.Sleep(5 * time.Millisecond) // Pin times are stored to the millisecond
+	pin(t, second)
+
+	t.Run("pinned rooms come first, newest pin first", func(t *testing.T) {
+		got := order(list(t, alice, "?sort=recent"))
+		if want := []string{second, first, third}; strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("order = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("pinning again keeps the original pin time", func(t *testing.T) {
+		if again := pin(t, first); !again.Equal(firstPinned) {
+			t.Errorf("re-pin moved pinned_at from %s to %s", firstPinned, again)
+		}
+	})
+
+	t.Run("unsorted listing carries the flags", func(t *testing.T) {
+		for _, room := range list(t, alice, "") {
+			if wantPinned := room.ID != third; room.Pinned != wantPinned || (room.PinnedAt != nil) != wantPinned {
+				t.Errorf("room %s pinned=%v pinned_at=%v, want pinned=%v", room.ID, room.Pinned, room.PinnedAt, wantPinned)
+			}
+		}
+	})
+
+	t.Run("pins are per user", func(t *testing.T) {
+		for _, room := range list(t, bob, "?sort=recent") {
+			if room.Pinned {
+				t.Errorf("bob sees alice's pin on %s", room.ID)
+			}
+		}
+	})
+
+	t.Run("unpin", func(t *testing.T) {
+		expect(t, env.do(t, alice, http.MethodDelete, "/api/chatrooms/"+second+"/pin", nil), http.StatusOK, nil)
+		got := order(list(t, alice, "?sort=recent"))
+		if want := []string{first, third, second}; strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("order after unpin = %v, want %v", got, want)
+		}
+		// Unpinning again is a no-op
+		expect(t, env.do(t, alice, http.MethodDelete, "/api/chatrooms/"+second+"/pin", nil), http.StatusOK, nil)
+	})
+
+	t.Run("non-members can't pin", func(t *testing.T) {
+		mallory := env.user(t, "mallory")
+		expect(t, env.do(t, mallory, http.MethodPost, "/api/chatrooms/"+first+"/pin", nil), http.StatusForbidden, nil)
+	})
+}
+
+func TestResponsesNeverIncludePasswords(t *testing.T) {
+	env := newAPIEnv(t)
+	alice := env.user(t, "alice")
+
+	var created struct {
+		Chatroom struct {
+			ID string `json:"id"`
+		} `json:"chatroom"`
+	}
+	w := env.do(t, alice, http.MethodPost, "/api/chatrooms", map[string]string{"name": "Locked", "password": "letmein"})
+	expect(t, w, http.StatusCreated, &created)
+	bodies := map[string]string{"create chatroom": w.Body.String()}
+	for name, path := range map[string]string{
+		"get chatroom":       "/api/chatrooms/" + created.Chatroom.ID,
+		"list chatrooms":     "/api/chatrooms",
+		"user chatrooms":     "/api/chatrooms/user",
+		"sorted chatrooms":   "/api/chatrooms/user?sort=recent",
+		"chatroom info":      "/api/chatrooms/" + created.Chatroom.ID + "/info",
+		"chatroom directory": "/api/chatrooms?search=Lock",
+	} {
+		w := env.do(t, alice, http.MethodGet, path, nil)
+		expect(t, w, http.StatusOK, nil)
+		bodies[name] = w.Body.String()
+	}
+
+	for name, body := range bodies {
+		if strings.Contains(body, `"password"`) || strings.Contains(body, "letmein") || strings.Contains(body, "$2a$") {
+			t.Errorf("%s response leaks the password: %s", name, body)
+		}
+	}
+}
+
+func TestGetMembership(t *testing.T) {
+	env := newAPIEnv(t)
+	alice, bob, mallory := env.user(t, "alice"), env.user(t, "bob"), env.user(t, "mallory")
+	roomID := env.createRoom(t, alice, "Membership", bob)
+
+	for _, tc := range []struct {
+		name     string
+		user     *apiUser
+		isMember bool
+		role     string
+	}{
+		{"creator is an admin", alice, true, models.ChatroomRoleAdmin},
+		{"joined user is a member", bob, true, models.ChatroomRoleMember},
+		{"outsider is not a member", mallory, false, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := env.do(t, tc.user, http.MethodGet, "/api/chatrooms/"+roomID+"/membership", nil)
+			var membership models.ChatroomMembership
+			expect(t, w, http.StatusOK, &membership)
+			if membership.IsMember != tc.isMember || membership.Role != tc.role {
+				t.Errorf("membership = %s, want is_member=%v role=%q", w.Body, tc.isMember, tc.role)
+			}
+			if hasJoinedAt := membership.JoinedAt != nil; hasJoinedAt != tc.isMember {
+				t.Errorf("joined_at present = %v, want %v", hasJoinedAt, tc.isMember)
+			}
+		})
+	}
+
+	t.Run("unknown room is a 404", func(t *testing.T) {
+		expect(t, env.do(t, bob, http.MethodGet, "/api/chatrooms/000000000000000000000000/membership", nil), http.StatusNotFound, nil)
+	})
+}
+
+// expoCounter answers Expo push requests with a ticket per token, counts them and records the notification
+// display each token was sent with
+type expoCounter struct {
+	base         http.RoundTripper
+	unregistered map[string]bool // Tokens answered with a DeviceNotRegistered error instead of an ok ticket
+	requests     atomic.Int32
+
+	mu       sync.Mutex
+	displays map[string]string
+}
+
+func (e *expoCounter) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != "exp.host" {
+		return e.base.RoundTrip(req)
+	}
+	e.requests.Add(1)
+	var message struct {
+		To   []string `json:"to"`
+		Data struct {
+			NotificationDisplay string `json:"notificationDisplay"`
+		} `json:"data"`
+	}
+	tickets := []map[string]any{}
+	if err := json.NewDecoder(req.Body).Decode(&message); err == nil {
+		e.mu.Lock()
+		if e.displays == nil {
+			e.displays = map[string]string{}
+		}
+		for _, token := range message.To {
+			e.displays[token] = message.Data.NotificationDisplay
+			if e.unregistered[token] {
+				tickets = append(tickets, map[string]any{"status": "error", "message": "not a registered push token", "details": map[string]string{"error": "DeviceNotRegistered"}})
+			} else {
+				tickets = append(tickets, map[string]any{"status": "ok"})
+			}
+		}
+		e.mu.Unlock()
+	}
+	body, _ := json.Marshal(map[string]any{"data": tickets})
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+// sentTo returns the notification display the token was last sent with, if any
+func (e *expoCounter) sentTo(token string) (display string, ok bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	display, ok = e.displays[token]
+	return display, ok
+}
+
+// transcript returns the chatroom's messages as user sees them, oldest first
+func (env *apiEnv) transcript(t *testing.T, user *apiUser, chatroomID string) []models.MessageResponse {
+	t.Helper()
+	var body struct {
+		Messages []models.MessageResponse `json:"messages"`
+	}
+	expect(t, env.do(t, user, http.MethodGet, "/api/chatrooms/"+chatroomID+"/messages", nil), http.StatusOK, &body)
+	slices.SortFunc(body.Messages, func(a, b models.MessageResponse) int { return a.SentAt.Compare(b.SentAt) })
+	return body.Messages
+}
+
+func TestJoinAddsSystemMessage(t *testing.T) {
+	expo := &expoCounter{base: http.DefaultTransport}
+	http.DefaultTransport = expo
+	t.Cleanup(func() { http.DefaultTransport = expo.base })
+
+	env := newAPIEnv(t)
+	alice, bob := env.user(t, "alice"), env.user(t, "bob")
+	if err := env.DB.Create(&models.PushToken{UserID: alice.ID, Token: "ExponentPushToken[alice]", Platform: "ios", IsActive: true}).Error; err != nil {
+		t.Fatalf("create push token: %v", err)
+	}
+	roomID := env.createRoom(t, alice, "Lobby")
+	env.join(t, bob, roomID)
+
+	messages := env.transcript(t, alice, roomID)
+	if len(messages) != 1 {
+		t.Fatalf("transcript has %d messages, want the join notice", len(messages))
+	}
+	notice := messages[0]
+	if notice.MessageType != models.MessageTypeSystem || notice.SenderID != models.SystemSenderID || notice.TextContent != "bob joined the room" {
+		t.Errorf("notice = %+v, want a system message saying bob joined", notice)
+	}
+	if event := notice.SystemEvent; event == nil || event.Type != models.SystemEventMemberJoined || event.UserID != bob.ID {
+		t.Errorf("system_event = %+v, want member_joined for bob", event)
+	}
+
+	var unread struct {
+		UnreadCount int `json:"unread_count"`
+	}
+	expect(t, env.do(t, alice, http.MethodGet, "/api/chatrooms/"+roomID+"/unread-count", nil), http.StatusOK, &unread)
+	if unread.UnreadCount != 0 {
+		t.Errorf("join notice left %d unread, want 0", unread.UnreadCount)
+	}
+	time.Sleep(50 * time.Millisecond) // Pushes are sent in the background
+	if sent := expo.requests.Load(); sent != 0 {
+		t.Errorf("join notice sent %d pushes, want none", sent)
+	}
+
+	// A real message from bob does notify alice, so the counter above would have seen a push
+	env.send(t, bob, roomID, "hi")
+	for deadline := time.Now().Add(time.Second); expo.requests.Load() == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("bob's message was never pushed")
+		}
+	}
+}
+
+func TestRenameAddsSystemMessage(t *testing.T) {
+	env := newAPIEnv(t)
+	alice, bob := env.user(t, "alice"), env.user(t, "bob")
+	roomID := env.createRoom(t, alice, "General", bob)
+	env.createRoom(t, alice, "Taken")
+	rename := func(user *apiUser, name string) *httptest.ResponseRecorder {
+		return env.do(t, user, http.MethodPut, "/api/chatrooms/"+roomID, map[string]string{"name": name})
+	}
+	notices := func() []models.MessageResponse {
+		var renames []models.MessageResponse
+		for _, message := range env.transcript(t, alice, roomID) {
+			if message.SystemEvent != nil && message.SystemEvent.Type == models.SystemEventChatroomRenamed {
+				renames = append(renames, message)
+			}
+		}
+		return renames
+	}
+
+	var updated struct {
+		Chatroom models.ChatroomResponse `json:"chatroom"`
+	}
+	expect(t, rename(alice, "  Weekend plans "), http.StatusOK, &updated)
+	if updated.Chatroom.Name != "Weekend plans" {
+		t.Errorf("name = %q, want it trimmed to %q", updated.Chatroom.Name, "Weekend plans")
+	}
+	renames := notices()
+	if len(renames) != 1 {
+		t.Fatalf("%d rename notices, want 1", len(renames))
+	}
+	if event := renames[0].SystemEvent; event.Name != "Weekend plans" || event.PreviousName != "General" || event.UserID != alice.ID {
+		t.Errorf("system_event = %+v, want alice renaming General to Weekend plans", event)
+	}
+	if renames[0].TextContent != `alice renamed the room to "Weekend plans"` {
+		t.Errorf("notice text = %q", renames[0].TextContent)
+	}
+
+	t.Run("renaming to the current name adds no notice", func(t *testing.T) {
+		expect(t, rename(alice, "Weekend plans"), http.StatusOK, nil)
+		if n := len(notices()); n != 1 {
+			t.Errorf("%d rename notices, want still 1", n)
+		}
+	})
+
+	t.Run("names stay unique among the creator's rooms", func(t *testing.T) {
+		expect(t, rename(alice, "Taken"), http.StatusConflict, nil)
+	})
+
+	t.Run("only admins can rename", func(t *testing.T) {
+		expect(t, rename(bob, "Bob's room"), http.StatusForbidden, nil)
+	})
+}
+
+func TestChatroomSortModes(t *testing.T) {
+	env := newAPIEnv(t)
+	alice, bob := env.user(t, "alice"), env.user(t, "bob")
+	names := []string{"Alpha", "Bravo", "Charlie", "Delta"}
+	rooms := map[string]string{}
+	for _, name := range names {
+		rooms[name] = env.createRoom(t, alice, name, bob)
+	}
+	for _, name := range names {
+		env.send(t, alice, rooms[name], "hello") // Delta has the latest message, Alpha the oldest
+		time.Sleep(5 * time.Millisecond)         // Message times are stored to the millisecond
+	}
+	for _, name := range []string{"Bravo", "Delta"} {
+		expect(t, env.do(t, bob, http.MethodPost, "/api/chatrooms/"+rooms[name]+"/mark-all-read", nil), http.StatusOK, nil)
+	}
+
+	order := func(t *testing.T, query string) string {
+		t.Helper()
+		var body struct {
+			Chatrooms []struct {
+				Name string `json:"name"`
+			} `json:"chatrooms"`
+		}
+		expect(t, env.do(t, bob, http.MethodGet, "/api/chatrooms/user"+query, nil), http.StatusOK, &body)
+		got := make([]string, len(body.Chatrooms))
+		for i, room := range body.Chatrooms {
+			got[i] = room.Name
+		}
+		return strings.Join(got, ",")
+	}
+
+	tests := []struct{ query, want string }{
+		{"?sort=recent", "Delta,Charlie,Bravo,Alpha"},
+		{"?sorted=true", "Delta,Charlie,Bravo,Alpha"},
+		{"?sort=unread_first", "Charlie,Alpha,Delta,Bravo"},
+	}
+	for _, tt := range tests {
+		if got := order(t, tt.query); got != tt.want {
+			t.Errorf("%s: order = %s, want %s", tt.query, got, tt.want)
+		}
+	}
+	expect(t, env.do(t, bob, http.MethodGet, "/api/chatrooms/user?sort=oldest", nil), http.StatusBadRequest, nil)
+}
+
+func TestChatroomCreationCooldown(t *testing.T) {
+	const window = 300 * time.Millisecond
+	env := newAPIEnv(t, func(cfg *config.Config) {
+		cfg.ChatroomCreateLimit, cfg.ChatroomCreateWindow = 2, window
+	})
+	alice, bob, root := env.user(t, "alice"), env.user(t, "bob"), env.admin(t, "root")
+	create := func(user *apiUser, name string) *httptest.ResponseRecorder {
+		return env.do(t, user, http.MethodPost, "/api/chatrooms", map[string]any{"name": name})
+	}
+
+	started := time.Now()
+	expect(t, create(alice, "Room one"), http.StatusCreated, nil)
+	expect(t, create(alice, "Room two"), http.StatusCreated, nil)
+	w := create(alice, "Room three")
+	var body apiError
+	expect(t, w, http.StatusTooManyRequests, &body)
+	if body.Code != "RATE_LIMITED" || w.Header().Get("Retry-After") == "" {
+		t.Errorf("over the limit: code %q, Retry-After %q; want RATE_LIMITED with a Retry-After", body.Code, w.Header().Get("Retry-After"))
+	}
+
+	// The limit is per user, and admins have none
+	expect(t, create(bob, "Bob's room"), http.StatusCreated, nil)
+	for i := range 3 {
+		expect(t, create(root, fmt.Sprintf("Admin room %d", i)), http.StatusCreated, nil)
+	}
+
+	time.Sleep(window - time.Since(started) + 50*time.Millisecond) // Until both of alice's rooms have aged out
+	expect(t, create(alice, "Room three"), http.StatusCreated, nil)
+}
+
+func TestGetChatroomInfo(t *testing.T) {
+	env := newAPIEnv(t)
+	alice, bob, mallory := env.user(t, "alice"), env.user(t, "bob"), env.user(t, "mallory")
+	var created struct {
+		Chatroom models.ChatroomResponse `json:"chatroom"`
+	}
+	before := time.Now().Add(-time.Second)
+	expect(t, env.do(t, alice, http.MethodPost, "/api/chatrooms", map[string]string{"name": "Locked", "password": "secret123"}), http.StatusCreated, &created)
+	roomID := created.Chatroom.ID
+	expect(t, env.do(t, bob, http.MethodPost, "/api/chatrooms/join", map[string]string{"room_code": created.Chatroom.RoomCode, "password": "secret123"}), http.StatusOK, nil)
+	env.send(t, alice, roomID, "one")
+	env.send(t, bob, roomID, "two")
+	// The creator renamed themselves since creating the room
+	expect(t, env.do(t, alice, http.MethodPut, "/api/users/profile", map[string]string{"username": "alicia"}), http.StatusOK, nil)
+
+	var info struct {
+		ChatroomID    string    `json:"chatroom_id"`
+		Name          string    `json:"name"`
+		CreatedAt     time.Time `json:"created_at"`
+		CreatedBy     uint      `json:"created_by"`
+		CreatedByName string    `json:"created_by_name"`
+		HasPassword   bool      `json:"has_password"`
+		MemberCount   int       `json:"member_count"`
+		MessageCount  int64     `json:"message_count"`
+	}
+	expect(t, env.do(t, bob, http.MethodGet, "/api/chatrooms/"+roomID+"/info", nil), http.StatusOK, &info)
+	if info.ChatroomID != roomID || info.Name != "Locked" || info.CreatedBy != alice.ID || info.CreatedByName != "alicia" {
+		t.Errorf("info = %+v, want Locked created by alice under the new name", info)
+	}
+	if info.CreatedAt.Before(before) || info.CreatedAt.After(time.Now()) {
+		t.Errorf("created_at = %v, want the creation time", info.CreatedAt)
+	}
+	if !info.HasPassword || info.MemberCount != 2 {
+		t.Errorf("has_password = %t, member_count = %d; want true and 2", info.HasPassword, info.MemberCount)
+	}
+	if info.MessageCount != 3 { // Two texts and bob's join notice
+		t.Errorf("message_count = %d, want 3", info.MessageCount)
+	}
+
+	expect(t, env.do(t, mallory, http.MethodGet, "/api/chatrooms/"+roomID+"/info", nil), http.StatusForbidden, nil)
+	expect(t, env.do(t, bob, http.MethodGet, "/api/chatrooms/"+primitive.NewObjectID().Hex()+"/info", nil), http.StatusNotFound, nil)
+}
+
+func TestDiscoverChatroomsByName(t *testing.T) {
+	env := newAPIEnv(t)
+	alice, bob := env.user(t, "alice"), env.user(t, "bob")
+	env.createRoom(t, bob, "Hiking club")
+	env.createRoom(t, bob, "Bikes and hikes")
+	env.createRoom(t, bob, "HIKING photos", alice)
+	env.createRoom(t, bob, "Cooking")
+
+	type page struct {
+		Chatrooms []models.ChatroomResponse `json:"chatrooms"`
+		Total     int64                     `json:"total"`
+		HasMore   bool                      `json:"has_more"`
+	}
+	names := func(p page) []string {
+		var names []string
+		for _, chatroom := range p.Chatrooms {
+			names = append(names, chatroom.Name)
+		}
+		return names
+	}
+
+	var got page
+	expect(t, env.do(t, alice, http.MethodGet, "/api/chatrooms?q=hik", nil), http.StatusOK, &got)
+	if want := []string{"Bikes and hikes", "HIKING photos", "Hiking club"}; !slices.Equal(names(got), want) || got.Total != 3 || got.HasMore {
+		t.Errorf("q=hik = %v of %d (more %t), want %v", names(got), got.Total, got.HasMore, want)
+	}
+
+	got = page{}
+	expect(t, env.do(t, alice, http.MethodGet, "/api/chatrooms?q=hik&exclude_joined=true", nil), http.StatusOK, &got)
+	if want := []string{"Bikes and hikes", "Hiking club"}; !slices.Equal(names(got), want) {
+		t.Errorf("excluding joined rooms = %v, want %v", names(got), want)
+	}
+
+	got = page{}
+	expect(t, env.do(t, alice, http.MethodGet, "/api/chatrooms?q=hik&limit=2", nil), http.StatusOK, &got)
+	if len(got.Chatrooms) != 2 || got.Total != 3 || !got.HasMore {
+		t.Errorf("first page = %v of %d (more %t), want 2 of 3 with more", names(got), got.Total, got.HasMore)
+	}
+
+	expect(t, env.do(t, alice, http.MethodGet, "/api/chatrooms?q=hik&limit=0", nil), http.StatusBadRequest, nil)
+	expect(t, env.do(t, alice, http.MethodGet, "/api/chatrooms?q=hik&offset=-1", nil), http.StatusBadRequest, nil)
+}
+
+func TestMembershipLimit(t *testing.T) {
+	env := newAPIEnv(t, func(cfg *config.Config) { cfg.MaxChatroomsPerUser = 2 })
+	bob, root := env.user(t, "bob"), env.admin(t, "root")
+	var rooms []string
+	for _, name := range []string{"Room one", "Room two", "Room three", "Room four"} {
+		rooms = append(rooms, env.createRoom(t, root, name))
+	}
+	roomCode := func(roomID string) string {
+		var got struct {
+			Chatroom models.ChatroomResponse `json:"chatroom"`
+		}
+		expect(t, env.do(t, root, http.MethodGet, "/api/chatrooms/"+roomID, nil), http.StatusOK, &got)
+		return got.Chatroom.RoomCode
+	}
+	usage := func(user *apiUser) controllers.MembershipUsage {
+		var got controllers.MembershipUsage
+		expect(t, env.do(t, user, http.MethodGet, "/api/chatrooms/user/limit", nil), http.StatusOK, &got)
+		return got
+	}
+	limitReached := func(w *httptest.ResponseRecorder) {
+		t.Helper()
+		var got apiError
+		expect(t, w, http.StatusForbidden, &got)
+		if got.Code != "CHATROOM_LIMIT_REACHED" {
+			t.Errorf("code = %s, want CHATROOM_LIMIT_REACHED", got.Code)
+		}
+	}
+
+	env.join(t, bob, rooms[0])
+	expect(t, env.do(t, bob, http.MethodPost, "/api/chatrooms/join", map[string]string{"room_code": roomCode(rooms[1])}), http.StatusOK, nil)
+	if got := usage(bob); got.Count != 2 || got.Limit == nil || *got.Limit != 2 || got.Remaining == nil || *got.Remaining != 0 {
+		t.Errorf("usage at the cap = %+v", got)
+	}
+
+	t.Run("every join path is capped", func(t *testing.T) {
+		limitReached(env.do(t, bob, http.MethodPost, "/api/chatrooms/"+rooms[2]+"/join", nil))
+		limitReached(env.do(t, bob, http.MethodPost, "/api/chatrooms/join", map[string]string{"room_code": roomCode(rooms[2])}))
+		limitReached(env.do(t, bob, http.MethodPost, "/api/chatrooms", map[string]string{"name": "Bob's room"}))
+
+		var batch struct {
+			Results []controllers.BatchJoinResult `json:"results"`
+		}
+		expect(t, env.do(t, bob, http.MethodPost, "/api/chatrooms/join-batch", map[string]any{
+			"rooms": []map[string]string{{"room_code": roomCode(rooms[2])}, {"room_code": roomCode(rooms[3])}},
+		}), http.StatusOK, &batch)
+		for _, result := range batch.Results {
+			if result.Status != controllers.BatchJoinLimitReached {
+				t.Errorf("batch join of %s = %s, want %s", result.RoomCode, result.Status, controllers.BatchJoinLimitReached)
+			}
+		}
+		if got := usage(bob); got.Count != 2 {
+			t.Errorf("bob is in %d rooms after rejected joins, want 2", got.Count)
+		}
+	})
+
+	t.Run("leaving frees a slot", func(t *testing.T) {
+		expect(t, env.do(t, bob, http.MethodPost, "/api/chatrooms/"+rooms[0]+"/leave", nil), http.StatusOK, nil)
+		env.join(t, bob, rooms[2])
+		limitReached(env.do(t, bob, http.MethodPost, "/api/chatrooms/"+rooms[3]+"/join", nil))
+	})
+
+	t.Run("admins are exempt", func(t *testing.T) {
+		if got := usage(root); got.Count != 4 || got.Limit != nil || got.Remaining != nil {
+			t.Errorf("admin usage = %+v, want 4 rooms and no cap", got)
+		}
+	})
+}
+
+func TestJoinByRoomCodeNormalizesInput(t *testing.T) {
+	env := newAPIEnv(t)
+	alice, bob, carol := env.user(t, "alice"), env.user(t, "bob"), env.user(t, "carol")
+	var created struct {
+		Chatroom struct {
+			ID       string `json:"id"`
+			RoomCode string `json:"room_code"`
+		} `json:"chatroom"`
+	}
+	expect(t, env.do(t, alice, http.MethodPost, "/api/chatrooms", map[string]string{"name": "General"}), http.StatusCreated, &created)
+	code := created.Chatroom.RoomCode
+
+	join := func(user *apiUser, roomCode string, status int) apiError {
+		t.Helper()
+		var got apiError
+		expect(t, env.do(t, user, http.MethodPost, "/api/chatrooms/join", map[string]string{"room_code": roomCode}), status, &got)
+		return got
+	}
+	join(bob, strings.ToLower(code), http.StatusOK)
+	join(carol, "  "+code+"\t", http.StatusOK)
+
+	// Codes that can't exist are rejected before any lookup, with their own error
+	for _, malformed := range []string{"ABC12", "ABC1234", "ABC-12", "ÄBC123", "      "} {
+		if got := join(carol, malformed, http.StatusBadRequest); got.Code != "INVALID_ROOM_CODE" {
+			t.Errorf("joining %q: code = %s, want INVALID_ROOM_CODE", malformed, got.Code)
+		}
+	}
+	if got := join(carol, "zzzzzz", http.StatusNotFound); got.Code != "CHATROOM_NOT_FOUND" {
+		t.Errorf("a well-formed unknown code: code = %s, want CHATROOM_NOT_FOUND", got.Code)
+	}
+}
+
+func TestChatroomNamesAreUniquePerCreator(t *testing.T) {
+	env := newAPIEnv(t)
+	alice, bob := env.user(t, "alice"), env.user(t, "bob")
+	create := func(user *apiUser, name string) *httptest.ResponseRecorder {
+		return env.do(t, user, http.MethodPost, "/api/chatrooms", map[string]string{"name": name})
+	}
+	rename := func(user *apiUser, roomID, name string) *httptest.ResponseRecorder {
+		return env.do(t, user, http.MethodPut, "/api/chatrooms/"+roomID, map[string]string{"name": name})
+	}
+	taken := func(w *httptest.ResponseRecorder) {
+		t.Helper()
+		var got apiError
+		expect(t, w, http.StatusConflict, &got)
+		if got.Code != "CHATROOM_NAME_TAKEN" {
+			t.Errorf("code = %s, want CHATROOM_NAME_TAKEN", got.Code)
+		}
+	}
+
+	env.createRoom(t, alice, "General")
+	bobsGeneral := env.createRoom(t, bob, "General") // Another creator may reuse the name
+	bobsOther := env.createRoom(t, bob, "Other")
+	alicesOther := env.createRoom(t, alice, "Other")
+
+	taken(create(alice, "General"))
+	taken(create(bob, "General"))
+	taken(rename(alice, alicesOther, "General"))
+	taken(rename(bob, bobsOther, "General"))
+
+	// Renaming into a name only another creator uses is fine
+	expect(t, rename(bob, bobsGeneral, "Announcements"), http.StatusOK, nil)
+	expect(t, create(alice, "Announcements"), http.StatusCreated, nil)
+	expect(t, rename(bob, bobsOther, "General"), http.StatusOK, nil)
+}
+
+func TestIdempotentJoinByRoomCode(t *testing.T) {
+	env := newAPIEnv(t)
+	alice, bob := env.user(t, "alice"), env.user(t, "bob")
+	var created struct {
+		Chatroom models.ChatroomResponse `json:"chatroom"`
+	}
+	expect(t, env.do(t, alice, http.MethodPost, "/api/chatrooms", map[string]string{"name": "Book club", "password": "secret123"}), http.StatusCreated, &created)
+	room := created.Chatroom
+
+	type joined struct {
+		Chatroom      models.ChatroomResponse `json:"chatroom"`
+		AlreadyMember bool                    `json:"already_member"`
+	}
+	join := func(path, code, password string, status int) joined {
+		t.Helper()
+		var got joined
+		expect(t, env.do(t, bob, http.MethodPost, path, map[string]string{"room_code": code, "password": password}), status, &got)
+		return got
+	}
+	joinNotices := func() int {
+		t.Helper()
+		count := 0
+		for _, message := range env.transcript(t, alice, room.ID) {
+			if message.SystemEvent != nil && message.SystemEvent.Type == models.SystemEventMemberJoined {
+				count++
+			}
+		}
+		return count
+	}
+
+	if got := join("/api/chatrooms/join?idempotent=true", room.RoomCode, "secret123", http.StatusOK); got.AlreadyMember || got.Chatroom.ID != room.ID {
+		t.Errorf("first join = %+v, want the room with already_member false", got)
+	}
+
+	// A deep link opened again, even with the code typed differently, succeeds without joining twice
+	if got := join("/api/chatrooms/join?idempotent=true", " "+strings.ToLower(room.RoomCode), "secret123", http.StatusOK); !got.AlreadyMember || got.Chatroom.ID != room.ID {
+		t.Errorf("repeat join = %+v, want the room with already_member true", got)
+	}
+	if n := joinNotices(); n != 1 {
+		t.Errorf("%d join notices, want 1", n)
+	}
+
+	// The password is still checked, and without the flag a repeat join is a conflict
+	join("/api/chatrooms/join?idempotent=true", room.RoomCode, "wrong", http.StatusForbidden)
+	join("/api/chatrooms/join", room.RoomCode, "secret123", http.StatusConflict)
+}
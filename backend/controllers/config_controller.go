@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ClientConfig is the part of the server configuration clients need to validate input and
+// size their requests the same way the server will, instead of hardcoding the limits
+type ClientConfig struct {
+	Limits   ClientLimits   `json:"limits"`
+	Features ClientFeatures `json:"features"`
+}
+
+// ClientLimits are the server's size and count limits
+type ClientLimits struct {
+	MaxUploadSize                int64    `json:"max_upload_size" example:"10485760"`            // Largest accepted upload, in bytes
+	MaxAlbumAttachments          int      `json:"max_album_attachments" example:"10"`            // Files in one album message
+	MediaTypes                   []string `json:"media_types" example:"image,audio,video"`       // Kinds of media messages can carry
+	MessageHistoryMaxLimit       int      `json:"message_history_max_limit" example:"100"`       // Largest page of messages history endpoints return
+	MessageEditWindowMinutes     int      `json:"message_edit_window_minutes" example:"15"`      // Default edit window for rooms that don't set one (0 means no limit)
+	MaxChatroomDescriptionLength int      `json:"max_chatroom_description_length" example:"500"` // Characters
+	MaxChatroomTopicLength       int      `json:"max_chatroom_topic_length" example:"100"`       // Characters
+	MaxChatroomsPerUser          int      `json:"max_chatrooms_per_user" example:"200"`          // Rooms a user may belong to (admins are exempt)
+	ChatroomCreateLimit          int      `json:"chatroom_create_limit" example:"10"`            // Rooms a user may create per window
+	ChatroomCreateWindowSeconds  int      `json:"chatroom_create_window_seconds" example:"3600"` // Length of that window
+	WSMaxMessageSize             int64    `json:"ws_max_message_size" example:"65536"`           // Largest WebSocket frame the server reads, in bytes
+	WSPingIntervalSeconds        int      `json:"ws_ping_interval_seconds" example:"90"`         // How often the server pings; a heartbeat at least this often keeps the socket alive
+}
+
+// ClientFeatures are the optional features turned on for this server
+type ClientFeatures struct {
+	MessageFilter       bool `json:"message_filter" example:"true"`         // Banned words are masked or rejected, depending on the room
+	MessageEncryption   bool `json:"message_encryption" example:"false"`    // Message text is encrypted at rest
+	LocalMediaStorage   bool `json:"local_media_storage" example:"false"`   // Media URLs are relative /media paths instead of Cloudinary URLs
+	ReadPointerTracking bool `json:"read_pointer_tracking" example:"false"` // Reading a message also reads everything before it
+}
+
+// ConfigController serves the client configuration
+type ConfigController struct {
+	config ClientConfig
+}
+
+// NewConfigController creates a ConfigController serving the given configuration
+func NewConfigController(config ClientConfig) *ConfigController {
+	return &ConfigController{config: config}
+}
+
+// GetClientConfig handles returning the client configuration
+// @Summary Get client configuration
+// @Description Return the server's client-relevant limits and feature flags, so apps can validate input and size requests without hardcoding them. No authentication is required
+// @Tags config
+// @Produce json
+// @Success 200 {object} ClientConfig "Client configuration"
+// @Router /config [get]
+func (cc *ConfigController) GetClientConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, cc.config)
+}
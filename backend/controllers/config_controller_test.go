@@ -0,0 +1,57 @@
+package controllers_test
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ginchat/config"
+	"github.com/ginchat/controllers"
+)
+
+func TestClientConfigReflectsConfiguredLimits(t *testing.T) {
+	env := newAPIEnv(t, func(cfg *config.Config) {
+		cfg.MaxUploadSize = 2 << 20
+		cfg.MessageHistoryMaxLimit = 40
+		cfg.MessageEditWindow = 5 * time.Minute
+		cfg.MaxChatroomsPerUser = 25
+		cfg.ChatroomCreateLimit = 3
+		cfg.ChatroomCreateWindow = 30 * time.Minute
+		cfg.WSMaxMessageSize = 16 * 1024
+		cfg.WSPingInterval = 20 * time.Second
+		cfg.MessageFilterEnabled = false
+		cfg.MessageEncryptionKey = []byte("0123456789abcdef0123456789abcdef")
+		cfg.ReadPointerTracking = true
+	})
+
+	// No token is needed, since clients read the configuration before anyone signs in
+	var got controllers.ClientConfig
+	expect(t, env.do(t, nil, http.MethodGet, "/api/config", nil), http.StatusOK, &got)
+
+	want := controllers.ClientConfig{
+		Limits: controllers.ClientLimits{
+			MaxUploadSize:                2 << 20,
+			MaxAlbumAttachments:          10,
+			MediaTypes:                   []string{"image", "audio", "video"},
+			MessageHistoryMaxLimit:       40,
+			MessageEditWindowMinutes:     5,
+			MaxChatroomDescriptionLength: 500,
+			MaxChatroomTopicLength:       100,
+			MaxChatroomsPerUser:          25,
+			ChatroomCreateLimit:          3,
+			ChatroomCreateWindowSeconds:  1800,
+			WSMaxMessageSize:             16 * 1024,
+			WSPingIntervalSeconds:        20,
+		},
+		Features: controllers.ClientFeatures{
+			MessageFilter:       false,
+			MessageEncryption:   true,
+			LocalMediaStorage:   true,
+			ReadPointerTracking: true,
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("config = %+v\nwant     %+v", got, want)
+	}
+}
@@ -0,0 +1,19 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/ginchat/utils"
+)
+
+// respondError writes a service error using the standard APIError envelope.
+// Its status and code come from the central table in utils, so handlers don't switch on error strings.
+func respondError(c *gin.Context, err error) {
+	apiErr := utils.ServiceAPIError(err)
+	c.JSON(apiErr.Status, apiErr)
+}
+
+// respondErrorMessage writes an error raised by the handler itself (bad input, missing auth)
+// using the standard envelope with the generic code for the status
+func respondErrorMessage(c *gin.Context, status int, message string) {
+	c.JSON(status, utils.NewAPIError(status, utils.CodeForStatus(status), message))
+}
@@ -0,0 +1,68 @@
+package controllers_test
+
+import (
+	"net/http"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// apiError is the error envelope every handler responds with
+type apiError struct {
+	Code    string         `json:"code"`
+	Error   string         `json:"error"`
+	Details map[string]any `json:"details"`
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	env := newAPIEnv(t)
+	alice, bob := env.user(t, "alice"), env.user(t, "bob")
+	roomID := env.createRoom(t, alice, "General", bob)
+	messageID := env.send(t, alice, roomID, "hello")
+	unknownID := primitive.NewObjectID().Hex()
+
+	tests := []struct {
+		name         string
+		user         *apiUser
+		method, path string
+		body         any
+		status       int
+		code         string
+	}{
+		{"malformed login", nil, http.MethodPost, "/api/auth/login", `{"email":`, http.StatusBadRequest, "INVALID_REQUEST"},
+		{"wrong password", nil, http.MethodPost, "/api/auth/login", map[string]string{"email": "alice@example.com", "password": "wrong"}, http.StatusUnauthorized, "INVALID_CREDENTIALS"},
+		{"taken username", nil, http.MethodPost, "/api/auth/register", map[string]string{"username": "alice", "email": "other@example.com", "password": "Secret123!"}, http.StatusConflict, "USERNAME_TAKEN"},
+		{"invalid quiet hours", alice, http.MethodPut, "/api/users/quiet-hours", map[string]string{"start": "22:00", "end": "22:00", "timezone": "Asia/Singapore"}, http.StatusBadRequest, "INVALID_QUIET_HOURS"},
+		{"empty search", alice, http.MethodGet, "/api/users/search?q=", nil, http.StatusBadRequest, "SEARCH_QUERY_REQUIRED"},
+		{"bad message ID", alice, http.MethodGet, "/api/messages/not-an-id/read-status", nil, http.StatusBadRequest, "INVALID_REQUEST"},
+		{"unknown message", bob, http.MethodPost, "/api/messages/" + unknownID + "/mark-read", nil, http.StatusNotFound, "MESSAGE_NOT_FOUND"},
+		{"own message", alice, http.MethodPost, "/api/messages/" + messageID + "/mark-read", nil, http.StatusNotFound, "READ_STATUS_NOT_FOUND"},
+		{"unread-by as non-sender", bob, http.MethodGet, "/api/messages/" + messageID + "/unread-by", nil, http.StatusForbidden, "NOT_MESSAGE_SENDER"},
+		{"upload without file", alice, http.MethodPost, "/api/media/upload", nil, http.StatusBadRequest, "INVALID_REQUEST"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got apiError
+			expect(t, env.do(t, tt.user, tt.method, tt.path, tt.body), tt.status, &got)
+			if got.Code != tt.code || got.Error == "" {
+				t.Errorf("envelope = %+v, want code %s and a message", got, tt.code)
+			}
+		})
+	}
+}
+
+func TestInvalidPushTokenDetails(t *testing.T) {
+	env := newAPIEnv(t)
+	alice := env.user(t, "alice")
+
+	var got apiError
+	expect(t, env.do(t, alice, http.MethodPost, "/api/auth/push-token", map[string]string{
+		"token": "ExponentPushToken[abc", "platform": "android",
+	}), http.StatusBadRequest, &got)
+	if got.Code != "INVALID_PUSH_TOKEN" || got.Details["reason"] == nil || got.Details["token_length"] != float64(len("ExponentPushToken[abc")) {
+		t.Errorf("envelope = %+v, want INVALID_PUSH_TOKEN with the reason and token length", got)
+	}
+	if _, leaked := got.Details["token_preview"]; leaked {
+		t.Error("details echo the token back")
+	}
+}
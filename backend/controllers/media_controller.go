@@ -1,9 +1,12 @@
 package controllers
 
 import (
-	"log"
+	"errors"
+	"fmt"
 	"net/http"
+	"path"
 	"path/filepath"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/ginchat/services"
@@ -12,21 +15,18 @@ import (
 
 // MediaController handles media-related requests
 type MediaController struct {
-	CloudinaryService *services.CloudinaryService
+	MediaStore        services.MediaStore         // Where uploads go: Cloudinary, or local disk without it
+	CloudinaryService *services.CloudinaryService // Nil when Cloudinary isn't configured; only the proxy needs it
+	MessageService    *services.MessageService
 }
 
 // NewMediaController creates a new MediaController
-func NewMediaController() *MediaController {
-	cloudinaryService, err := services.NewCloudinaryService()
-	if err != nil {
-		log.Printf("Error initializing Cloudinary service: %v", err)
-		// Return a controller with nil service, we'll handle this in the handler
-		return &MediaController{
-			CloudinaryService: nil,
-		}
-	}
+func NewMediaController(mediaStore services.MediaStore, messageService *services.MessageService) *MediaController {
+	cloudinaryService, _ := mediaStore.(*services.CloudinaryService)
 	return &MediaController{
+		MediaStore:        mediaStore,
 		CloudinaryService: cloudinaryService,
+		MessageService:    messageService,
 	}
 }
 
@@ -37,7 +37,7 @@ type UploadMediaRequest struct {
 
 // UploadMedia handles uploading media files
 // @Summary Upload a media file
-// @Description Upload an image, audio, or video file for use in messages
+// @Description Upload an image, audio, or video file for use in messages. Files go to Cloudinary, or to local disk (served under /media) when Cloudinary isn't configured
 // @Tags media
 // @Accept multipart/form-data
 // @Produce json
@@ -45,49 +45,54 @@ type UploadMediaRequest struct {
 // @Param message_type formData string true "Message type (picture, audio, video, text_and_picture, text_and_audio, text_and_video)" Enums(picture, audio, video, text_and_picture, text_and_audio, text_and_video)
 // @Param file formData file true "Media file to upload"
 // @Success 201 {object} map[string]string "Media uploaded successfully"
-// @Failure 400 {object} map[string]string "Invalid request"
-// @Failure 401 {object} map[string]string "User not authenticated"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Failure 400 {object} utils.APIError "Invalid request"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 500 {object} utils.APIError "Internal server error"
+// @Failure 503 {object} utils.APIError "Too many uploads in progress"
 // @Router /media/upload [post]
 func (mc *MediaController) UploadMedia(c *gin.Context) {
-	// Check if Cloudinary service is initialized
-	if mc.CloudinaryService == nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "File upload service is temporarily unavailable. Please try again later"})
+	// Check if the media store is initialized
+	if mc.MediaStore == nil {
+		respondErrorMessage(c, http.StatusInternalServerError, "File upload service is temporarily unavailable. Please try again later")
 		return
 	}
 
 	// Get user ID from context (set by auth middleware)
 	_, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Please log in to continue"})
+		respondErrorMessage(c, http.StatusUnauthorized, "Please log in to continue")
 		return
 	}
 
 	// Parse form
 	var req UploadMediaRequest
 	if err := c.ShouldBind(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": utils.FormatValidationError(err)})
+		respondErrorMessage(c, http.StatusBadRequest, utils.FormatValidationError(err))
 		return
 	}
 
 	// Get the file
 	file, err := c.FormFile("file")
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Please select a file to upload"})
+		respondErrorMessage(c, http.StatusBadRequest, "Please select a file to upload")
 		return
 	}
 
 	// Determine media type from message type
 	mediaType := utils.GetMediaTypeFromMessageType(req.MessageType)
 	if mediaType == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Please select a valid message type"})
+		respondErrorMessage(c, http.StatusBadRequest, "Please select a valid message type")
 		return
 	}
 
-	// Upload the file to Cloudinary
-	mediaURL, err := mc.CloudinaryService.UploadFile(file, mediaType)
+	// Upload the file to the media store
+	mediaURL, err := mc.MediaStore.UploadFile(file, mediaType)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": utils.FormatMediaError(err)})
+		if _, known := utils.LookupServiceError(err); !known {
+			respondError(c, utils.NewAPIError(http.StatusInternalServerError, utils.CodeUploadFailed, utils.FormatMediaError(err)))
+			return
+		}
+		respondError(c, err) // e.g. a full upload queue is a retryable 503
 		return
 	}
 
@@ -99,6 +104,137 @@ func (mc *MediaController) UploadMedia(c *gin.Context) {
 	})
 }
 
+// ProxyMedia streams a Cloudinary media file through this server for clients that can't load it directly
+// @Summary Download media through the API
+// @Description Stream a media file from this app's Cloudinary account (same-origin download for clients behind strict CSPs). The user must be a member of a chatroom containing the media
+// @Tags media
+// @Produce octet-stream
+// @Security ApiKeyAuth
+// @Param url query string true "Cloudinary media URL"
+// @Success 200 {file} file "Media content"
+// @Failure 400 {object} utils.APIError "Invalid or foreign media URL"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 403 {object} utils.APIError "User cannot access this media"
+// @Failure 404 {object} utils.APIError "Media not found"
+// @Failure 502 {object} utils.APIError "Failed to fetch media"
+// @Router /media/proxy [get]
+func (mc *MediaController) ProxyMedia(c *gin.Context) {
+	// Check if Cloudinary service is initialized
+	if mc.CloudinaryService == nil {
+		respondErrorMessage(c, http.StatusInternalServerError, "File download service is temporarily unavailable. Please try again later")
+		return
+	}
+
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondErrorMessage(c, http.StatusUnauthorized, "Please log in to continue")
+		return
+	}
+
+	// Only proxy assets from our own cloud (prevents open proxy / SSRF)
+	mediaURL := c.Query("url")
+	if !mc.CloudinaryService.IsOwnedAssetURL(mediaURL) {
+		respondErrorMessage(c, http.StatusBadRequest, "Please provide a valid media URL")
+		return
+	}
+
+	// The user must be a member of a chatroom that contains this media
+	allowed, err := mc.MessageService.CanUserAccessMedia(mediaURL, userID.(uint))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if !allowed {
+		respondErrorMessage(c, http.StatusForbidden, "You don't have access to this media")
+		return
+	}
+
+	resp, err := mc.CloudinaryService.FetchFile(mediaURL)
+	if err != nil {
+		if errors.Is(err, services.ErrMediaNotFound) {
+			respondErrorMessage(c, http.StatusNotFound, "Media not found")
+		} else {
+			respondErrorMessage(c, http.StatusBadGateway, "Unable to download media. Please try again later")
+		}
+		return
+	}
+	defer resp.Body.Close()
+
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	// Media URLs are immutable (new uploads get new public IDs), so they can be cached privately
+	headers := map[string]string{
+		"Cache-Control":          "private, max-age=86400",
+		"Content-Disposition":    fmt.Sprintf("inline; filename=%q", path.Base(resp.Request.URL.Path)),
+		"X-Content-Type-Options": "nosniff",
+	}
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		headers["ETag"] = etag
+	}
+	if lastModified := resp.Header.Get("Last-Modified"); lastModified != "" {
+		headers["Last-Modified"] = lastModified
+	}
+
+	c.DataFromReader(http.StatusOK, resp.ContentLength, contentType, resp.Body, headers)
+}
+
+// UserMediaRequest holds the query parameters for listing the user's own media
+type UserMediaRequest struct {
+	Limit  int    `form:"limit" example:"50"`                    // Media messages per page (default 50)
+	Before string `form:"before" example:"2024-01-01T12:00:00Z"` // Only media sent before this time
+	Cursor string `form:"cursor"`                                // next_cursor from the previous page
+}
+
+// GetMyMedia lists the media the user has sent in all of their chatrooms
+// @Summary List my shared media
+// @Description List the files the user sent across every chatroom they still belong to, newest first, for a "your shared files" gallery. Album messages contribute one item per attachment. Rooms the user has left are excluded
+// @Tags media
+// @Produce json
+// @Security ApiKeyAuth
+// @Param limit query int false "Media messages per page" default(50) minimum(1) maximum(100)
+// @Param before query string false "Only media sent before this time (ISO 8601)" example:"2024-01-01T12:00:00Z"
+// @Param cursor query string false "next_cursor from the previous page"
+// @Success 200 {object} services.UserMediaResponse "The user's media"
+// @Failure 400 {object} utils.APIError "Invalid timestamp or cursor"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 500 {object} utils.APIError "Internal server error"
+// @Router /media/mine [get]
+func (mc *MediaController) GetMyMedia(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondErrorMessage(c, http.StatusUnauthorized, "Please log in to continue")
+		return
+	}
+
+	var req UserMediaRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, utils.FormatValidationError(err))
+		return
+	}
+
+	var before *time.Time
+	if req.Before != "" {
+		t, err := time.Parse(time.RFC3339, req.Before)
+		if err != nil {
+			respondErrorMessage(c, http.StatusBadRequest, "Invalid 'before' timestamp format. Use ISO 8601 format.")
+			return
+		}
+		before = &t
+	}
+
+	response, err := mc.MessageService.GetUserMedia(userID.(uint), req.Limit, before, req.Cursor)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
 // SetupMediaRoutes sets up routes for media handling
 func SetupMediaRoutes(router *gin.Engine, mediaController *MediaController) {
 	mediaGroup := router.Group("/api/media")
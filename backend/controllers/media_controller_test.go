@@ -0,0 +1,111 @@
+package controllers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ginchat/config"
+)
+
+// cloudinaryStub answers requests meant for res.cloudinary.com: one image exists, one was deleted after
+// it was shared (HEAD still finds it, GET doesn't), and everything else is a 404
+type cloudinaryStub struct {
+	server *httptest.Server
+	base   http.RoundTripper
+}
+
+func (s *cloudinaryStub) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host == "res.cloudinary.com" {
+		rewritten := req.Clone(req.Context())
+		rewritten.URL.Scheme = "http"
+		rewritten.URL.Host = strings.TrimPrefix(s.server.URL, "http://")
+		rewritten.Host = ""
+		return s.base.RoundTrip(rewritten)
+	}
+	return s.base.RoundTrip(req)
+}
+
+// stubCloudinary routes the default transport's Cloudinary traffic to a local server for the rest of the test
+func stubCloudinary(t *testing.T) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/demo/image/upload/v1/ginchat/images/gone.jpg" && r.Method == http.MethodHead {
+			return
+		}
+		if r.URL.Path != "/demo/image/upload/v1/ginchat/images/cat.jpg" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("ETag", `"cat-v1"`)
+		w.Write([]byte("jpeg bytes"))
+	}))
+	t.Cleanup(server.Close)
+
+	original := http.DefaultTransport
+	http.DefaultTransport = &cloudinaryStub{server: server, base: original}
+	t.Cleanup(func() { http.DefaultTransport = original })
+}
+
+func TestProxyMedia(t *testing.T) {
+	stubCloudinary(t)
+	env := newAPIEnv(t, func(cfg *config.Config) {
+		cfg.CloudinaryCloudName, cfg.CloudinaryAPIKey, cfg.CloudinaryAPISecret = "demo", "key", "secret"
+	})
+	alice, bob, mallory := env.user(t, "alice"), env.user(t, "bob"), env.user(t, "mallory")
+	roomID := env.createRoom(t, alice, "Photos", bob)
+
+	const owned = "https://res.cloudinary.com/demo/image/upload/v1/ginchat/images/cat.jpg"
+	expect(t, env.do(t, alice, http.MethodPost, "/api/chatrooms/"+roomID+"/messages", map[string]string{
+		"message_type": "picture", "media_url": owned,
+	}), http.StatusCreated, nil)
+	proxy := func(mediaURL string) string { return "/api/media/proxy?url=" + url.QueryEscape(mediaURL) }
+
+	t.Run("a member downloads owned media", func(t *testing.T) {
+		w := env.do(t, bob, http.MethodGet, proxy(owned), nil)
+		expect(t, w, http.StatusOK, nil)
+		if w.Body.String() != "jpeg bytes" {
+			t.Errorf("body = %q", w.Body.String())
+		}
+		for header, want := range map[string]string{
+			"Content-Type":           "image/jpeg",
+			"Cache-Control":          "private, max-age=86400",
+			"ETag":                   `"cat-v1"`,
+			"X-Content-Type-Options": "nosniff",
+			"Content-Disposition":    `inline; filename="cat.jpg"`,
+		} {
+			if got := w.Header().Get(header); got != want {
+				t.Errorf("%s = %q, want %q", header, got, want)
+			}
+		}
+	})
+
+	t.Run("foreign URLs are rejected", func(t *testing.T) {
+		for _, foreign := range []string{
+			"https://res.cloudinary.com/someone-else/image/upload/v1/cat.jpg",
+			"https://example.com/demo/image/upload/v1/ginchat/images/cat.jpg",
+			"http://res.cloudinary.com/demo/image/upload/v1/ginchat/images/cat.jpg",
+			"https://user@res.cloudinary.com/demo/image/upload/v1/ginchat/images/cat.jpg",
+			"https://res.cloudinary.com:8443/demo/image/upload/v1/ginchat/images/cat.jpg",
+			"http://169.254.169.254/latest/meta-data/",
+		} {
+			expect(t, env.do(t, bob, http.MethodGet, proxy(foreign), nil), http.StatusBadRequest, nil)
+		}
+	})
+
+	t.Run("media deleted from Cloudinary is not found", func(t *testing.T) {
+		const gone = "https://res.cloudinary.com/demo/image/upload/v1/ginchat/images/gone.jpg"
+		expect(t, env.do(t, alice, http.MethodPost, "/api/chatrooms/"+roomID+"/messages", map[string]string{
+			"message_type": "picture", "media_url": gone,
+		}), http.StatusCreated, nil)
+		expect(t, env.do(t, bob, http.MethodGet, proxy(gone), nil), http.StatusNotFound, nil)
+	})
+
+	t.Run("non-members and unshared media are refused", func(t *testing.T) {
+		expect(t, env.do(t, mallory, http.MethodGet, proxy(owned), nil), http.StatusForbidden, nil)
+		expect(t, env.do(t, bob, http.MethodGet, proxy("https://res.cloudinary.com/demo/image/upload/v1/ginchat/images/dog.jpg"), nil), http.StatusForbidden, nil)
+	})
+}
@@ -1,8 +1,10 @@
 package controllers
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
 	"strconv"
 	"time"
 
@@ -11,28 +13,34 @@ import (
 	"github.com/ginchat/services"
 	"github.com/ginchat/utils"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"gorm.io/gorm"
 )
 
 // MessageController handles message-related requests
 type MessageController struct {
+	wsHub
 	MessageService          *services.MessageService
 	PushNotificationService *services.PushNotificationService
 }
 
 // NewMessageController creates a new MessageController
-func NewMessageController(db *gorm.DB, mongodb *mongo.Database) *MessageController {
-	chatroomService := services.NewChatroomService(mongodb)
-	userService := services.NewUserService(db)
-	cloudinaryService, _ := services.NewCloudinaryService() // Ignore error for now, will be nil if not configured
-	readStatusService := services.NewMessageReadStatusService(mongodb, chatroomService, userService)
-	messageService := services.NewMessageService(mongodb, chatroomService, cloudinaryService, readStatusService)
-	pushNotificationService := services.NewPushNotificationService(db, mongodb)
-	return &MessageController{
+func NewMessageController(messageService *services.MessageService, pushNotificationService *services.PushNotificationService) *MessageController {
+	mc := &MessageController{
 		MessageService:          messageService,
 		PushNotificationService: pushNotificationService,
 	}
+	if pushNotificationService != nil {
+		pushNotificationService.OnTokenInvalidated(mc.notifyPushTokenInvalidated)
+	}
+	return mc
+}
+
+// notifyPushTokenInvalidated tells the owner's connected devices that a push token was dropped,
+// so the app holding it can re-register right away instead of on its next launch
+func (mc *MessageController) notifyPushTokenInvalidated(userID uint, token string) {
+	mc.hub().NotifyUsers([]uint{userID}, "push_token_invalidated", "", map[string]any{
+		"token":  token,
+		"reason": "device_not_registered",
+	})
 }
 
 // SendMessageRequest represents the request body for sending a text message
@@ -42,11 +50,12 @@ type SendMessageRequest struct {
 	MediaURL    string `json:"media_url" example:"/media/images/abc123.jpg"`                                                                                                                                                                 // URL of the media (required for picture, audio, video, text_and_picture, text_and_audio, text_and_video)
 }
 
-// UpdateMessageRequest represents the request body for updating a message
+// UpdateMessageRequest represents the request body for updating a message.
+// Omitted (null) fields are left unchanged; an empty string clears the field.
 type UpdateMessageRequest struct {
-	TextContent string `json:"text_content" example:"Updated message content"`                                                  // New text content of the message
-	MediaURL    string `json:"media_url" example:"https://res.cloudinary.com/your-cloud/image/upload/v123456789/new_image.jpg"` // New media URL (optional)
-	MessageType string `json:"message_type" example:"text_and_picture"`                                                         // New message type (optional, will be auto-determined if not provided)
+	TextContent *string `json:"text_content" example:"Updated message content"`                                                                                                                                                                            // New text content of the message (optional, omit to keep current text)
+	MediaURL    *string `json:"media_url" example:"https://res.cloudinary.com/your-cloud/image/upload/v123456789/new_image.jpg"`                                                                                                                           // New media URL (optional, omit to keep current media)
+	MessageType *string `json:"message_type" binding:"omitempty,oneof=text picture audio video text_and_picture text_and_audio text_and_video" example:"text_and_picture" enums:"text,picture,audio,video,text_and_picture,text_and_audio,text_and_video"` // New message type (optional, will be auto-determined if not provided)
 }
 
 // SendMessage handles sending a message to a chatroom
@@ -59,31 +68,31 @@ type UpdateMessageRequest struct {
 // @Param id path string true "Chatroom ID" example:"60d5f8b8e6b5f0b3e8b4b5b3"
 // @Param message body SendMessageRequest true "Message information"
 // @Success 201 {object} map[string]models.MessageResponse "Message sent successfully"
-// @Failure 400 {object} map[string]string "Invalid request body or chatroom ID"
-// @Failure 401 {object} map[string]string "User not authenticated"
-// @Failure 403 {object} map[string]string "User is not a member of this chatroom"
-// @Failure 404 {object} map[string]string "Chatroom not found"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Failure 400 {object} utils.APIError "Invalid request body or chatroom ID"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 403 {object} utils.APIError "User is not a member of this chatroom"
+// @Failure 404 {object} utils.APIError "Chatroom not found"
+// @Failure 500 {object} utils.APIError "Internal server error"
 // @Router /chatrooms/{id}/messages [post]
 // @Notes For media messages, first upload the media using the /api/media/upload endpoint, then use the returned media_url in this request
 func (mc *MessageController) SendMessage(c *gin.Context) {
 	var req SendMessageRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": utils.FormatValidationError(err)})
+		respondErrorMessage(c, http.StatusBadRequest, utils.FormatValidationError(err))
 		return
 	}
 
 	// Get chatroom ID from URL
 	chatroomID, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Please provide a valid chat room ID"})
+		respondErrorMessage(c, http.StatusBadRequest, "Please provide a valid chat room ID")
 		return
 	}
 
 	// Get user ID from context (set by auth middleware)
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Please log in to continue"})
+		respondErrorMessage(c, http.StatusUnauthorized, "Please log in to continue")
 		return
 	}
 	username, _ := c.Get("username")
@@ -91,23 +100,113 @@ func (mc *MessageController) SendMessage(c *gin.Context) {
 	// Send message using the service
 	message, err := mc.MessageService.SendMessage(chatroomID, userID.(uint), username.(string), req.MessageType, req.TextContent, req.MediaURL)
 	if err != nil {
-		switch err.Error() {
-		case "chatroom not found":
-			c.JSON(http.StatusNotFound, gin.H{"error": utils.FormatServiceError(err)})
-		case "user is not a member of this chatroom":
-			c.JSON(http.StatusForbidden, gin.H{"error": utils.FormatServiceError(err)})
-		case "text content is required for text messages",
-			"media URL is required for media messages",
-			"text content is required for combined messages",
-			"media URL is required for combined messages",
-			"invalid message type":
-			c.JSON(http.StatusBadRequest, gin.H{"error": utils.FormatServiceError(err)})
-		default:
-			c.JSON(http.StatusInternalServerError, gin.H{"error": utils.FormatServiceError(err)})
+		respondError(c, err)
+		return
+	}
+
+	// Broadcast and notify members
+	messageResponse := mc.notifyNewMessage(message, chatroomID, userID.(uint), username.(string))
+
+	// Return message data
+	c.JSON(http.StatusCreated, gin.H{
+		"message": messageResponse,
+	})
+}
+
+// SendMessageWithMediaRequest represents the multipart form for sending a message with a file
+type SendMessageWithMediaRequest struct {
+	TextContent string `form:"text_content" example:"Look at this!"` // Optional caption; the message type becomes text_and_<media> when present
+}
+
+// SendMessageWithMedia handles uploading one or more files and sending them as a message in one request
+// @Summary Send a message with media
+// @Description Upload media (and optional text) and create the message in one step. A single file gives a message whose type is inferred from the file; several files (up to 10) are sent together as one album message. If an upload fails no message is created
+// @Tags messages
+// @Accept multipart/form-data
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Chatroom ID" example:"60d5f8b8e6b5f0b3e8b4b5b3"
+// @Param file formData file false "Media file (image, audio or video)"
+// @Param files formData file false "Several media files to send as an album (repeat the field for each file, at most 10)"
+// @Param text_content formData string false "Optional text content"
+// @Success 201 {object} map[string]models.MessageResponse "Message sent successfully"
+// @Failure 400 {object} utils.APIError "Invalid file, form or chatroom ID"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 403 {object} utils.APIError "User is not a member of this chatroom or is read-only"
+// @Failure 404 {object} utils.APIError "Chatroom not found"
+// @Failure 500 {object} utils.APIError "Internal server error"
+// @Failure 503 {object} utils.APIError "Too many uploads in progress"
+// @Router /chatrooms/{id}/messages/with-media [post]
+func (mc *MessageController) SendMessageWithMedia(c *gin.Context) {
+	var req SendMessageWithMediaRequest
+	if err := c.ShouldBind(&req); err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, utils.FormatValidationError(err))
+		return
+	}
+
+	// Get the files: "file" for a single upload, repeated "files" for an album
+	form, err := c.MultipartForm()
+	if err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, "Please select a file to upload")
+		return
+	}
+	files := append(form.File["file"], form.File["files"]...)
+	if len(files) == 0 {
+		respondErrorMessage(c, http.StatusBadRequest, "Please select a file to upload")
+		return
+	}
+
+	// Get chatroom ID from URL
+	chatroomID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, "Please provide a valid chat room ID")
+		return
+	}
+
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondErrorMessage(c, http.StatusUnauthorized, "Please log in to continue")
+		return
+	}
+	username, _ := c.Get("username")
+
+	// Upload and send message using the service
+	message, err := mc.MessageService.SendMessageWithMedia(chatroomID, userID.(uint), username.(string), req.TextContent, files)
+	if err != nil {
+		if _, known := utils.LookupServiceError(err); !known && errors.Is(err, services.ErrMediaUpload) {
+			respondError(c, utils.NewAPIError(http.StatusInternalServerError, utils.CodeUploadFailed, utils.FormatMediaError(err)))
+			return
 		}
+		respondError(c, err)
 		return
 	}
 
+	// Broadcast and notify members
+	messageResponse := mc.notifyNewMessage(message, chatroomID, userID.(uint), username.(string))
+
+	// Return message data
+	c.JSON(http.StatusCreated, gin.H{
+		"message": messageResponse,
+	})
+}
+
+// SendSocketMessage sends a message received over WebSocket through the same path as SendMessage
+// (persist, broadcast, unread counts, push). It implements MessageSender for the WebSocket controller.
+func (mc *MessageController) SendSocketMessage(chatroomID primitive.ObjectID, userID uint, username, messageType, textContent, mediaURL string) (*models.Message, error) {
+	message, err := mc.MessageService.SendMessage(chatroomID, userID, username, messageType, textContent, mediaURL)
+	if err != nil {
+		return nil, err
+	}
+
+	mc.notifyNewMessage(message, chatroomID, userID, username)
+
+	return message, nil
+}
+
+// notifyNewMessage broadcasts a newly sent message over WebSocket, pushes unread counts to members
+// and sends push notifications. It returns the message response (with read status) for the HTTP reply.
+func (mc *MessageController) notifyNewMessage(message *models.Message, chatroomID primitive.ObjectID, userID uint, username string) models.MessageResponse {
 	// Broadcast the new message to all connected clients with read status
 	messageResponse := message.ToResponse()
 
@@ -119,8 +218,9 @@ func (mc *MessageController) SendMessage(c *gin.Context) {
 		}
 	}
 
-	// Check if GlobalWebSocketController is available
-	if GlobalWebSocketController != nil {
+	// Broadcast through the WebSocket hub, if one is running
+	hub := mc.hub()
+	if hub != nil {
 		// Get read status for the message (without auto-marking)
 		if mc.MessageService.ReadStatusSvc != nil {
 			readStatus, err := mc.MessageService.ReadStatusSvc.GetMessageReadStatus(message.ID)
@@ -129,84 +229,103 @@ func (mc *MessageController) SendMessage(c *gin.Context) {
 			}
 		}
 
-		GlobalWebSocketController.BroadcastNewMessage(chatroomID.Hex(), messageResponse)
-
 		// Also send unread count updates to all chatroom members for sidebar updates
 		chatroom, err := mc.MessageService.ChatSvc.GetChatroomByID(chatroomID)
 		if err == nil {
+			hub.BroadcastNewMessage(chatroomID.Hex(), messageResponse, memberIDs(chatroom))
+		} else {
+			hub.BroadcastNewMessage(chatroomID.Hex(), messageResponse, nil) // Room viewers still get the message
+		}
+		if err == nil && mc.MessageService.ReadStatusSvc != nil {
 			fmt.Printf("Sending unread count updates to %d chatroom members\n", len(chatroom.Members))
 			for _, member := range chatroom.Members {
 				// Skip the sender (they don't get unread count for their own message)
-				if member.UserID != userID.(uint) {
+				if member.UserID != userID {
 					unreadCounts, err := mc.MessageService.ReadStatusSvc.GetUnreadCountForUser(member.UserID)
 					if err == nil {
 						fmt.Printf("Broadcasting unread count update to user %d\n", member.UserID)
-						BroadcastUnreadCountUpdateGlobal(member.UserID, unreadCounts)
+						hub.BroadcastUnreadCountUpdate(member.UserID, unreadCounts)
 					} else {
 						fmt.Printf("Failed to get unread counts for user %d: %v\n", member.UserID, err)
 					}
 				}
 			}
-		} else {
+		} else if err != nil {
 			fmt.Printf("Failed to get chatroom for unread count updates: %v\n", err)
 		}
 	} else {
-		fmt.Println("Warning: GlobalWebSocketController is nil, cannot broadcast message")
+		fmt.Println("Warning: no WebSocket controller available, cannot broadcast message")
 	}
 
 	// Send push notification in background
-	fmt.Printf("DEBUG: PushNotificationService is nil: %v\n", mc.PushNotificationService == nil)
 	if mc.PushNotificationService != nil {
-		fmt.Printf("DEBUG: Starting push notification goroutine for message in chatroom %s\n", chatroomID.Hex())
 		go func() {
-			// Get chatroom for notification
-			chatroom, err := mc.MessageService.ChatSvc.GetChatroomByID(chatroomID)
-			if err != nil {
-				fmt.Printf("Failed to get chatroom for push notification: %v\n", err)
-				return
-			}
-
-			// Prepare message content for notification
-			messageContent := req.TextContent
-			if messageContent == "" {
-				// For media messages without text, use a generic message
-				switch req.MessageType {
-				case "picture":
-					messageContent = "📷 Photo"
-				case "audio":
-					messageContent = "🎵 Audio"
-				case "video":
-					messageContent = "🎥 Video"
-				default:
-					messageContent = "📎 Media"
-				}
-			}
-
-			fmt.Printf("DEBUG: Sending push notification for chatroom %s, sender %d, content: %s\n",
-				chatroomID.Hex(), userID.(uint), messageContent)
-
-			// Send notification
-			err = mc.PushNotificationService.SendMessageNotification(
-				chatroomID.Hex(),
-				userID.(uint),
-				username.(string),
-				messageContent,
-				chatroom.Name,
-			)
-			if err != nil {
+			if _, err := mc.sendPushNotification(message); err != nil {
 				fmt.Printf("Failed to send push notification: %v\n", err)
 			} else {
 				fmt.Printf("Push notification sent successfully for chatroom %s\n", chatroomID.Hex())
 			}
 		}()
-	} else {
-		fmt.Printf("DEBUG: PushNotificationService is nil, skipping push notification\n")
 	}
 
-	// Return message data
-	c.JSON(http.StatusCreated, gin.H{
-		"message": messageResponse,
-	})
+	return messageResponse
+}
+
+// sendPushNotification sends the push notification for a message to the other members of its chatroom.
+// Members active in the app get an in-app hint, and @everyone / @here mentions are resolved against the
+// live connections. Returns the number of push tokens targeted.
+func (mc *MessageController) sendPushNotification(message *models.Message) (int, error) {
+	// Get chatroom for notification
+	chatroom, err := mc.MessageService.ChatSvc.GetChatroomByID(message.ChatroomID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get chatroom for push notification: %w", err)
+	}
+
+	// Prepare message content for notification
+	messageContent := message.TextContent
+	if messageContent == "" {
+		// For media messages without text, use a generic message
+		switch message.MessageType {
+		case "picture":
+			messageContent = "📷 Photo"
+		case "audio":
+			messageContent = "🎵 Audio"
+		case "video":
+			messageContent = "🎥 Video"
+		default:
+			messageContent = "📎 Media"
+		}
+	}
+
+	// Recipients using the app right now get an in-app banner hint instead of a system notification
+	hub := mc.hub()
+	activeUsers := make(map[uint]bool)
+	for _, member := range chatroom.Members {
+		if member.UserID != message.SenderID && hub.IsUserRecentlyActive(member.UserID) {
+			activeUsers[member.UserID] = true
+		}
+	}
+
+	// Resolve @everyone / @here to the members they notify
+	var mentionTargets *services.MentionTargets
+	if mentions := services.ParseRoomMentions(message.TextContent); mentions.Everyone || mentions.Here {
+		var connectedUsers []uint
+		if mentions.Here {
+			connectedUsers = hub.GetConnectedUsersInRoom(message.ChatroomID.Hex())
+		}
+		mentionTargets = services.ResolveRoomMentions(mentions, chatroom, message.SenderID, connectedUsers)
+	}
+
+	return mc.PushNotificationService.SendMessageNotification(
+		message.ChatroomID.Hex(),
+		message.SenderID,
+		message.SenderName,
+		messageContent,
+		message.MessageType,
+		chatroom.Name,
+		activeUsers,
+		mentionTargets,
+	)
 }
 
 // GetMessages handles getting messages from a chatroom
@@ -219,29 +338,29 @@ func (mc *MessageController) SendMessage(c *gin.Context) {
 // @Param id path string true "Chatroom ID" example:"60d5f8b8e6b5f0b3e8b4b5b3"
 // @Param limit query int false "Maximum number of messages to retrieve" default(50) minimum(1) maximum(100)
 // @Success 200 {object} map[string][]models.MessageResponse "List of messages"
-// @Failure 400 {object} map[string]string "Invalid chatroom ID"
-// @Failure 401 {object} map[string]string "User not authenticated"
-// @Failure 403 {object} map[string]string "User is not a member of this chatroom"
-// @Failure 404 {object} map[string]string "Chatroom not found"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Failure 400 {object} utils.APIError "Invalid chatroom ID"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 403 {object} utils.APIError "User is not a member of this chatroom"
+// @Failure 404 {object} utils.APIError "Chatroom not found"
+// @Failure 500 {object} utils.APIError "Internal server error"
 // @Router /chatrooms/{id}/messages [get]
 func (mc *MessageController) GetMessages(c *gin.Context) {
 	// Get chatroom ID from URL
 	chatroomID, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chatroom ID"})
+		respondErrorMessage(c, http.StatusBadRequest, "Invalid chatroom ID")
 		return
 	}
 
 	// Get user ID from context (set by auth middleware)
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		respondErrorMessage(c, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
 	// Get limit from query parameters
-	limit := 50 // Default limit
+	limit := services.DefaultMessageLimit
 	if limitParam := c.Query("limit"); limitParam != "" {
 		// Try to parse the limit parameter
 		parsedLimit, err := strconv.Atoi(limitParam)
@@ -249,28 +368,24 @@ func (mc *MessageController) GetMessages(c *gin.Context) {
 			limit = parsedLimit
 		}
 	}
+	limit = mc.MessageService.ClampMessageLimit(limit)
 
 	// Get messages with read status using the service
 	messages, err := mc.MessageService.GetMessagesWithReadStatus(chatroomID, userID.(uint), limit)
 	if err != nil {
-		if err.Error() == "chatroom not found" {
-			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
-		} else if err.Error() == "user is not a member of this chatroom" {
-			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		}
+		respondError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"messages": messages,
+		"limit":    limit,
 	})
 }
 
 // UpdateMessage handles updating a message
 // @Summary Update a message
-// @Description Update the content and/or media of an existing message (only sender can update)
+// @Description Update the content and/or media of an existing message (only sender can update, within the chatroom's edit window unless they are a chatroom admin). Omitted fields are kept, so media can be swapped without resending text.
 // @Tags messages
 // @Accept json
 // @Produce json
@@ -278,45 +393,38 @@ func (mc *MessageController) GetMessages(c *gin.Context) {
 // @Param messageId path string true "Message ID"
 // @Param message body UpdateMessageRequest true "Updated message data"
 // @Success 200 {object} map[string]interface{} "Updated message"
-// @Failure 400 {object} map[string]string "Bad request"
-// @Failure 401 {object} map[string]string "Unauthorized"
-// @Failure 403 {object} map[string]string "Forbidden"
-// @Failure 404 {object} map[string]string "Message not found"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Failure 401 {object} utils.APIError "Unauthorized"
+// @Failure 403 {object} utils.APIError "Forbidden"
+// @Failure 404 {object} utils.APIError "Message not found"
+// @Failure 500 {object} utils.APIError "Internal server error"
 // @Security BearerAuth
 // @Router /api/chatrooms/{id}/messages/{messageId} [put]
 func (mc *MessageController) UpdateMessage(c *gin.Context) {
 	var req UpdateMessageRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": utils.FormatValidationError(err)})
+		respondErrorMessage(c, http.StatusBadRequest, utils.FormatValidationError(err))
 		return
 	}
 
 	// Get message ID from URL
 	messageID, err := primitive.ObjectIDFromHex(c.Param("messageId"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Please provide a valid message ID"})
+		respondErrorMessage(c, http.StatusBadRequest, "Please provide a valid message ID")
 		return
 	}
 
 	// Get user ID from context (set by auth middleware)
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Please log in to continue"})
+		respondErrorMessage(c, http.StatusUnauthorized, "Please log in to continue")
 		return
 	}
 
 	// Update message using the service
 	message, err := mc.MessageService.UpdateMessage(messageID, userID.(uint), req.TextContent, req.MediaURL, req.MessageType)
 	if err != nil {
-		switch err.Error() {
-		case "message not found":
-			c.JSON(http.StatusNotFound, gin.H{"error": utils.FormatServiceError(err)})
-		case "user is not the sender of this message":
-			c.JSON(http.StatusForbidden, gin.H{"error": "You can only update your own messages"})
-		default:
-			c.JSON(http.StatusInternalServerError, gin.H{"error": utils.FormatServiceError(err)})
-		}
+		respondError(c, err)
 		return
 	}
 
@@ -331,77 +439,83 @@ func (mc *MessageController) UpdateMessage(c *gin.Context) {
 		}
 	}
 
-	// Check if GlobalWebSocketController is available
-	if GlobalWebSocketController != nil {
-		BroadcastMessageUpdatedGlobal(message.ChatroomID.Hex(), messageResponse)
-	}
+	// Notify the room's members of the edit
+	mc.hub().BroadcastMessageUpdated(message.ChatroomID.Hex(), messageResponse, roomRecipients(mc.MessageService.ChatSvc, message.ChatroomID, userID.(uint)))
 
 	c.JSON(http.StatusOK, gin.H{"message": messageResponse})
 }
 
 // DeleteMessage handles deleting a message
 // @Summary Delete a message
-// @Description Delete a message and its associated media (only sender can delete)
+// @Description Delete a message and its associated media (only the sender or a chatroom admin can delete)
 // @Tags messages
 // @Produce json
 // @Param id path string true "Chatroom ID"
 // @Param messageId path string true "Message ID"
 // @Success 200 {object} map[string]string "Message deleted successfully"
-// @Failure 400 {object} map[string]string "Bad request"
-// @Failure 401 {object} map[string]string "Unauthorized"
-// @Failure 403 {object} map[string]string "Forbidden"
-// @Failure 404 {object} map[string]string "Message not found"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Failure 401 {object} utils.APIError "Unauthorized"
+// @Failure 403 {object} utils.APIError "Forbidden"
+// @Failure 404 {object} utils.APIError "Message not found"
+// @Failure 500 {object} utils.APIError "Internal server error"
 // @Security BearerAuth
 // @Router /api/chatrooms/{id}/messages/{messageId} [delete]
 func (mc *MessageController) DeleteMessage(c *gin.Context) {
 	// Get message ID from URL
 	messageID, err := primitive.ObjectIDFromHex(c.Param("messageId"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Please provide a valid message ID"})
+		respondErrorMessage(c, http.StatusBadRequest, "Please provide a valid message ID")
 		return
 	}
 
 	// Get user ID from context (set by auth middleware)
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Please log in to continue"})
-		return
-	}
-
-	// Get chatroom ID from URL for WebSocket broadcasting
-	chatroomID, err := primitive.ObjectIDFromHex(c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Please provide a valid chatroom ID"})
+		respondErrorMessage(c, http.StatusUnauthorized, "Please log in to continue")
 		return
 	}
 
 	// Delete message using the service
-	err = mc.MessageService.DeleteMessage(messageID, userID.(uint))
+	message, err := mc.MessageService.DeleteMessage(messageID, userID.(uint))
 	if err != nil {
-		switch err.Error() {
-		case "message not found":
-			c.JSON(http.StatusNotFound, gin.H{"error": utils.FormatServiceError(err)})
-		case "user is not the sender of this message":
-			c.JSON(http.StatusForbidden, gin.H{"error": "You can only delete your own messages"})
-		default:
-			c.JSON(http.StatusInternalServerError, gin.H{"error": utils.FormatServiceError(err)})
+		apiErr := utils.ServiceAPIError(err)
+		if err.Error() == "user is not the sender of this message" {
+			apiErr = apiErr.WithMessage("You can only delete your own messages unless you are a chatroom admin")
 		}
+		respondError(c, apiErr)
 		return
 	}
 
-	// Broadcast the message deletion to all connected clients
-	if GlobalWebSocketController != nil {
-		deleteData := map[string]any{
-			"message_id":  messageID.Hex(),
-			"chatroom_id": chatroomID.Hex(),
-		}
-		BroadcastMessageDeletedGlobal(chatroomID.Hex(), deleteData)
-	}
+	// Broadcast the message deletion to the members of the room it was in, whatever the URL says
+	chatroomID := message.ChatroomID
+	mc.hub().BroadcastMessageDeleted(chatroomID.Hex(), map[string]any{
+		"message_id":  messageID.Hex(),
+		"chatroom_id": chatroomID.Hex(),
+	}, roomRecipients(mc.MessageService.ChatSvc, chatroomID, userID.(uint)))
+	mc.hub().BroadcastSelfSync(userID.(uint), SelfSyncEvent{
+		Action:     SelfSyncMessageDeleted,
+		ChatroomID: chatroomID.Hex(),
+		MessageIDs: []string{messageID.Hex()},
+	}, nil)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Message deleted successfully"})
 }
 
+// roomRecipients lists the users who should hear about a change in the chatroom: its current
+// members plus the acting user, who may have left but still shows the room in their sidebar.
+// If the chatroom can't be loaded only the acting user is returned.
+func roomRecipients(chatroomService *services.ChatroomService, chatroomID primitive.ObjectID, actorID uint) []uint {
+	chatroom, err := chatroomService.GetChatroomByID(chatroomID)
+	if err != nil {
+		return []uint{actorID}
+	}
+	ids := memberIDs(chatroom)
+	if !slices.Contains(ids, actorID) {
+		ids = append(ids, actorID)
+	}
+	return ids
+}
+
 // PaginatedMessagesRequest represents the request for paginated messages
 type PaginatedMessagesRequest struct {
 	Limit  int    `form:"limit" json:"limit" example:"50"`                     // Number of messages to retrieve (default: 50)
@@ -430,41 +544,36 @@ type PaginatedMessagesResponse struct {
 // @Param before query string false "Get messages before this timestamp (ISO 8601)" example:"2024-01-01T12:00:00Z"
 // @Param after query string false "Get messages after this timestamp (ISO 8601)" example:"2024-01-01T12:00:00Z"
 // @Success 200 {object} PaginatedMessagesResponse "Paginated messages with metadata"
-// @Failure 400 {object} map[string]string "Invalid request parameters"
-// @Failure 401 {object} map[string]string "User not authenticated"
-// @Failure 403 {object} map[string]string "User is not a member of this chatroom"
-// @Failure 404 {object} map[string]string "Chatroom not found"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Failure 400 {object} utils.APIError "Invalid request parameters"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 403 {object} utils.APIError "User is not a member of this chatroom"
+// @Failure 404 {object} utils.APIError "Chatroom not found"
+// @Failure 500 {object} utils.APIError "Internal server error"
 // @Router /chatrooms/{id}/messages/paginated [get]
 func (mc *MessageController) GetMessagesPaginated(c *gin.Context) {
 	// Get chatroom ID from URL
 	chatroomID, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chatroom ID"})
+		respondErrorMessage(c, http.StatusBadRequest, "Invalid chatroom ID")
 		return
 	}
 
 	// Get user ID from context (set by auth middleware)
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		respondErrorMessage(c, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
 	// Parse query parameters
 	var req PaginatedMessagesRequest
 	if err := c.ShouldBindQuery(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": utils.FormatValidationError(err)})
+		respondErrorMessage(c, http.StatusBadRequest, utils.FormatValidationError(err))
 		return
 	}
 
-	// Set default limit
-	if req.Limit <= 0 {
-		req.Limit = 50
-	}
-	if req.Limit > 100 {
-		req.Limit = 100
-	}
+	// Set default limit and cap
+	req.Limit = mc.MessageService.ClampMessageLimit(req.Limit)
 
 	// Parse timestamps if provided
 	var beforeTime, afterTime *time.Time
@@ -472,7 +581,7 @@ func (mc *MessageController) GetMessagesPaginated(c *gin.Context) {
 		if t, err := time.Parse(time.RFC3339, req.Before); err == nil {
 			beforeTime = &t
 		} else {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid 'before' timestamp format. Use ISO 8601 format."})
+			respondErrorMessage(c, http.StatusBadRequest, "Invalid 'before' timestamp format. Use ISO 8601 format.")
 			return
 		}
 	}
@@ -480,7 +589,7 @@ func (mc *MessageController) GetMessagesPaginated(c *gin.Context) {
 		if t, err := time.Parse(time.RFC3339, req.After); err == nil {
 			afterTime = &t
 		} else {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid 'after' timestamp format. Use ISO 8601 format."})
+			respondErrorMessage(c, http.StatusBadRequest, "Invalid 'after' timestamp format. Use ISO 8601 format.")
 			return
 		}
 	}
@@ -488,14 +597,237 @@ func (mc *MessageController) GetMessagesPaginated(c *gin.Context) {
 	// Get paginated messages using the service
 	response, err := mc.MessageService.GetMessagesPaginated(chatroomID, userID.(uint), req.Limit, beforeTime, afterTime)
 	if err != nil {
-		switch err.Error() {
-		case "chatroom not found":
-			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
-		case "user is not a member of this chatroom":
-			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
-		default:
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// SenderMessagesRequest holds the query parameters for listing one sender's messages
+type SenderMessagesRequest struct {
+	Limit  int    `form:"limit" example:"50"`                    // Messages per page (default 50)
+	Before string `form:"before" example:"2024-01-01T12:00:00Z"` // Only messages sent before this time; use next_cursor from the previous page
+}
+
+// GetMessagesBySender handles listing one participant's messages in a chatroom
+// @Summary Get a sender's messages in a chatroom
+// @Description List the messages one user sent in a chatroom, with read status. Each page is in chronological order and the first page holds the newest messages; pass next_cursor as before to load older ones. Works for senders who have since left the room
+// @Tags messages
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Chatroom ID"
+// @Param senderId path int true "Sender's user ID"
+// @Param limit query int false "Messages per page" default(50) minimum(1) maximum(100)
+// @Param before query string false "Only messages sent before this time (ISO 8601)" example:"2024-01-01T12:00:00Z"
+// @Success 200 {object} services.SenderMessagesResponse "The sender's messages"
+// @Failure 400 {object} utils.APIError "Invalid chatroom ID, sender ID or timestamp"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 403 {object} utils.APIError "User is not a member of this chatroom"
+// @Failure 404 {object} utils.APIError "Chatroom not found"
+// @Failure 500 {object} utils.APIError "Internal server error"
+// @Router /chatrooms/{id}/messages/by-sender/{senderId} [get]
+func (mc *MessageController) GetMessagesBySender(c *gin.Context) {
+	chatroomID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, "Invalid chatroom ID")
+		return
+	}
+
+	senderID, err := strconv.ParseUint(c.Param("senderId"), 10, 64)
+	if err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, "Invalid sender ID")
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondErrorMessage(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req SenderMessagesRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, utils.FormatValidationError(err))
+		return
+	}
+
+	var before *time.Time
+	if req.Before != "" {
+		t, err := time.Parse(time.RFC3339, req.Before)
+		if err != nil {
+			respondErrorMessage(c, http.StatusBadRequest, "Invalid 'before' timestamp format. Use ISO 8601 format.")
+			return
 		}
+		before = &t
+	}
+
+	response, err := mc.MessageService.GetMessagesBySender(chatroomID, uint(senderID), userID.(uint), req.Limit, before)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// MessageRangeRequest holds the query parameters for listing messages between two times
+type MessageRangeRequest struct {
+	From   string `form:"from" binding:"required" example:"2024-01-02T00:00:00Z"` // Start of the range (inclusive)
+	To     string `form:"to" binding:"required" example:"2024-01-03T00:00:00Z"`   // End of the range (inclusive)
+	Limit  int    `form:"limit" example:"50"`                                     // Messages per page (default 50)
+	Cursor string `form:"cursor"`                                                 // next_cursor from the previous page
+}
+
+// GetMessagesInRange handles listing a chatroom's messages sent within a date range
+// @Summary Get messages in a date range
+// @Description List the messages sent in a chatroom between from and to (inclusive, at most 31 days apart), oldest first and with read status. If has_more is set, pass next_cursor as cursor (keeping from and to) to load the rest of the range
+// @Tags messages
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Chatroom ID"
+// @Param from query string true "Start of the range (ISO 8601)" example:"2024-01-02T00:00:00Z"
+// @Param to query string true "End of the range (ISO 8601)" example:"2024-01-03T00:00:00Z"
+// @Param limit query int false "Messages per page" default(50) minimum(1) maximum(100)
+// @Param cursor query string false "next_cursor from the previous page"
+// @Success 200 {object} services.MessageRangeResponse "Messages in the range"
+// @Failure 400 {object} utils.APIError "Invalid chatroom ID, timestamp, range or cursor"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 403 {object} utils.APIError "User is not a member of this chatroom"
+// @Failure 404 {object} utils.APIError "Chatroom not found"
+// @Failure 500 {object} utils.APIError "Internal server error"
+// @Router /chatrooms/{id}/messages/range [get]
+func (mc *MessageController) GetMessagesInRange(c *gin.Context) {
+	chatroomID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, "Invalid chatroom ID")
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondErrorMessage(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req MessageRangeRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, utils.FormatValidationError(err))
+		return
+	}
+
+	from, err := time.Parse(time.RFC3339, req.From)
+	if err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, "Invalid 'from' timestamp format. Use ISO 8601 format.")
+		return
+	}
+	to, err := time.Parse(time.RFC3339, req.To)
+	if err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, "Invalid 'to' timestamp format. Use ISO 8601 format.")
+		return
+	}
+
+	response, err := mc.MessageService.GetMessagesInRange(chatroomID, userID.(uint), from, to, req.Limit, req.Cursor)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// GetMessageCount handles getting the total number of messages in a chatroom
+// @Summary Count messages in a chatroom
+// @Description Return the total number of messages in a chatroom (e.g. for room info screens) without fetching any of them
+// @Tags messages
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Chatroom ID"
+// @Success 200 {object} map[string]int64 "Message count"
+// @Failure 400 {object} utils.APIError "Invalid chatroom ID"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 403 {object} utils.APIError "User is not a member of this chatroom"
+// @Failure 404 {object} utils.APIError "Chatroom not found"
+// @Failure 500 {object} utils.APIError "Internal server error"
+// @Router /chatrooms/{id}/message-count [get]
+func (mc *MessageController) GetMessageCount(c *gin.Context) {
+	// Get chatroom ID from URL
+	chatroomID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, "Invalid chatroom ID")
+		return
+	}
+
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondErrorMessage(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	count, err := mc.MessageService.CountMessages(chatroomID, userID.(uint))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"count": count})
+}
+
+// GetMessageContext handles getting the messages around a specific message
+// @Summary Get messages around a message
+// @Description Retrieve up to radius messages before and after a message (e.g. when jumping to a search result or pinned message), in chronological order with read status
+// @Tags messages
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Chatroom ID"
+// @Param messageId path string true "Anchor message ID"
+// @Param radius query int false "Messages to include on each side" default(20) minimum(1) maximum(100)
+// @Success 200 {object} services.MessageContextResponse "Messages around the anchor"
+// @Failure 400 {object} utils.APIError "Invalid request parameters"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 403 {object} utils.APIError "User is not a member of this chatroom"
+// @Failure 404 {object} utils.APIError "Chatroom or message not found"
+// @Failure 500 {object} utils.APIError "Internal server error"
+// @Router /chatrooms/{id}/messages/{messageId}/context [get]
+func (mc *MessageController) GetMessageContext(c *gin.Context) {
+	// Get chatroom ID from URL
+	chatroomID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, "Invalid chatroom ID")
+		return
+	}
+
+	// Get message ID from URL
+	messageID, err := primitive.ObjectIDFromHex(c.Param("messageId"))
+	if err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, "Invalid message ID")
+		return
+	}
+
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondErrorMessage(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	radius := 20
+	if radiusParam := c.Query("radius"); radiusParam != "" {
+		parsedRadius, err := strconv.Atoi(radiusParam)
+		if err != nil || parsedRadius <= 0 {
+			respondErrorMessage(c, http.StatusBadRequest, "Radius must be a positive number")
+			return
+		}
+		radius = parsedRadius
+	}
+	if radius > 100 {
+		radius = 100
+	}
+
+	response, err := mc.MessageService.GetMessagesAround(chatroomID, userID.(uint), messageID, radius)
+	if err != nil {
+		respondError(c, err)
 		return
 	}
 
@@ -504,16 +836,16 @@ func (mc *MessageController) GetMessagesPaginated(c *gin.Context) {
 
 // GetChatroomMedia gets all media messages from a chatroom
 // @Summary Get all media messages from a chatroom
-// @Description Retrieves all messages with media (images, videos, audio) from a specific chatroom
+// @Description Retrieves all messages with media (images, videos, audio) from a specific chatroom, including albums
 // @Tags messages
 // @Accept json
 // @Produce json
 // @Param id path string true "Chatroom ID"
 // @Success 200 {object} map[string]interface{} "success"
-// @Failure 400 {object} map[string]interface{} "error"
-// @Failure 401 {object} map[string]interface{} "error"
-// @Failure 403 {object} map[string]interface{} "error"
-// @Failure 500 {object} map[string]interface{} "error"
+// @Failure 400 {object} utils.APIError "error"
+// @Failure 401 {object} utils.APIError "error"
+// @Failure 403 {object} utils.APIError "error"
+// @Failure 500 {object} utils.APIError "error"
 // @Router /chatrooms/{id}/media [get]
 // @Security BearerAuth
 func (mc *MessageController) GetChatroomMedia(c *gin.Context) {
@@ -521,61 +853,250 @@ func (mc *MessageController) GetChatroomMedia(c *gin.Context) {
 	chatroomIDStr := c.Param("id")
 	chatroomID, err := primitive.ObjectIDFromHex(chatroomIDStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chatroom ID"})
+		respondErrorMessage(c, http.StatusBadRequest, "Invalid chatroom ID")
 		return
 	}
 
 	// Get user ID from JWT token
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		respondErrorMessage(c, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
 	// Check if chatroom exists and user is a member
 	chatroom, err := mc.MessageService.ChatSvc.GetChatroomByID(chatroomID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Chatroom not found"})
+		respondErrorMessage(c, http.StatusNotFound, "Chatroom not found")
 		return
 	}
 
 	// Check if user is a member of the chatroom
 	if !mc.MessageService.ChatSvc.IsMember(chatroom, userID.(uint)) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You are not a member of this chatroom"})
+		respondErrorMessage(c, http.StatusForbidden, "You are not a member of this chatroom")
 		return
 	}
 
 	// Get all media messages from the chatroom
 	messages, err := mc.MessageService.GetChatroomMedia(chatroomID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get media messages"})
+		respondErrorMessage(c, http.StatusInternalServerError, "Failed to get media messages")
 		return
 	}
 
-	// Convert to response format
-	var messageResponses []models.MessageResponse
-	for _, message := range messages {
-		// Convert message to response format manually
-		response := message.ToResponse()
+	// Convert to response format, with read status loaded for all of them at once
+	messageResponses := mc.MessageService.ResponsesWithReadStatus(messages)
 
-		// Get read status for the message
-		if mc.MessageService.ReadStatusSvc != nil {
-			readStatus, err := mc.MessageService.ReadStatusSvc.GetMessageReadStatus(message.ID)
-			if err == nil {
-				response.ReadStatus = readStatus
-			}
-		}
+	c.JSON(http.StatusOK, gin.H{
+		"messages": messageResponses,
+		"count":    len(messageResponses),
+	})
+}
+
+// GetChatroomMediaCounts handles counting the media in a chatroom by kind
+// @Summary Count media in a chatroom
+// @Description Return how many images, videos and audio files a chatroom has (e.g. "42 photos, 7 videos"), counting each item of an album, without fetching the media
+// @Tags messages
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Chatroom ID"
+// @Success 200 {object} services.MediaCountsResponse "Media counts"
+// @Failure 400 {object} utils.APIError "Invalid chatroom ID"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 403 {object} utils.APIError "User is not a member of this chatroom"
+// @Failure 404 {object} utils.APIError "Chatroom not found"
+// @Failure 500 {object} utils.APIError "Internal server error"
+// @Router /chatrooms/{id}/media/counts [get]
+func (mc *MessageController) GetChatroomMediaCounts(c *gin.Context) {
+	chatroomID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, "Invalid chatroom ID")
+		return
+	}
 
-		messageResponses = append(messageResponses, response)
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondErrorMessage(c, http.StatusUnauthorized, "User not authenticated")
+		return
 	}
 
-	// Ensure messageResponses is never nil
-	if messageResponses == nil {
-		messageResponses = []models.MessageResponse{}
+	counts, err := mc.MessageService.GetMediaCounts(chatroomID, userID.(uint))
+	if err != nil {
+		respondError(c, err)
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"messages": messageResponses,
-		"count":    len(messageResponses),
-	})
+	c.JSON(http.StatusOK, counts)
+}
+
+// ResendMessageNotification handles re-sending the push notification for a message
+// @Summary Re-send a message's push notification
+// @Description Re-run the push notification for a message, e.g. when the original push failed. Only the sender or a chatroom admin may do this. Recipients' quiet hours and in-app activity are respected as for a new message.
+// @Tags messages
+// @Produce json
+// @Security ApiKeyAuth
+// @Param message_id path string true "Message ID"
+// @Success 200 {object} map[string]int "Number of push tokens targeted"
+// @Failure 400 {object} utils.APIError "Invalid message ID or system message"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 403 {object} utils.APIError "User is not the sender or a chatroom admin"
+// @Failure 404 {object} utils.APIError "Message not found"
+// @Failure 500 {object} utils.APIError "Failed to send the notification"
+// @Failure 503 {object} utils.APIError "Push notifications are not configured"
+// @Router /messages/{message_id}/resend-notification [post]
+func (mc *MessageController) ResendMessageNotification(c *gin.Context) {
+	// Get message ID from URL
+	messageID, err := primitive.ObjectIDFromHex(c.Param("message_id"))
+	if err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, "Please provide a valid message ID")
+		return
+	}
+
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondErrorMessage(c, http.StatusUnauthorized, "Please log in to continue")
+		return
+	}
+
+	if mc.PushNotificationService == nil {
+		respondErrorMessage(c, http.StatusServiceUnavailable, "Push notifications are not available right now")
+		return
+	}
+
+	message, err := mc.MessageService.GetMessageForNotificationResend(messageID, userID.(uint))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	// Unlike a new message this runs synchronously, so the caller learns how many devices were targeted
+	targeted, err := mc.sendPushNotification(message)
+	if err != nil {
+		fmt.Printf("Failed to re-send push notification for message %s: %v\n", messageID.Hex(), err)
+		respondErrorMessage(c, http.StatusInternalServerError, "Failed to send the notification. Please try again")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tokens_targeted": targeted})
+}
+
+// SaveDraftRequest represents the request body for saving a message draft
+type SaveDraftRequest struct {
+	TextContent string `json:"text_content" example:"Hello, how are"`        // Text typed so far
+	MediaURL    string `json:"media_url" example:"/media/images/abc123.jpg"` // Uploaded media not sent yet (optional)
+}
+
+// SaveDraft handles saving the user's draft for a chatroom
+// @Summary Save a message draft
+// @Description Create or replace your unsent draft for a chatroom so it follows you to other devices. Drafts are private.
+// @Description Saving a draft with neither text nor media deletes it. The draft is cleared automatically when you send a message to the room
+// @Tags messages
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Chatroom ID"
+// @Param request body SaveDraftRequest true "Draft content"
+// @Success 200 {object} map[string]any "The saved draft (null when it was cleared)"
+// @Failure 400 {object} utils.APIError "Invalid chatroom ID, draft too long or media not hosted by this app"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 403 {object} utils.APIError "User is not a member of this chatroom"
+// @Failure 404 {object} utils.APIError "Chatroom not found"
+// @Failure 500 {object} utils.APIError "Internal server error"
+// @Router /chatrooms/{id}/draft [put]
+func (mc *MessageController) SaveDraft(c *gin.Context) {
+	chatroomID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, "Invalid chatroom ID")
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondErrorMessage(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req SaveDraftRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	draft, err := mc.MessageService.SaveDraft(chatroomID, userID.(uint), req.TextContent, req.MediaURL)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"draft": draft})
+}
+
+// GetDraft handles getting the user's draft for a chatroom
+// @Summary Get a message draft
+// @Description Get your unsent draft for a chatroom, or null if you have none
+// @Tags messages
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Chatroom ID"
+// @Success 200 {object} map[string]any "The draft, or null"
+// @Failure 400 {object} utils.APIError "Invalid chatroom ID"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 403 {object} utils.APIError "User is not a member of this chatroom"
+// @Failure 404 {object} utils.APIError "Chatroom not found"
+// @Failure 500 {object} utils.APIError "Internal server error"
+// @Router /chatrooms/{id}/draft [get]
+func (mc *MessageController) GetDraft(c *gin.Context) {
+	chatroomID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, "Invalid chatroom ID")
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondErrorMessage(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	draft, err := mc.MessageService.GetDraft(chatroomID, userID.(uint))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"draft": draft})
+}
+
+// DeleteDraft handles discarding the user's draft for a chatroom
+// @Summary Delete a message draft
+// @Description Discard your draft for a chatroom (no-op if you have none)
+// @Tags messages
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Chatroom ID"
+// @Success 200 {object} map[string]string "Draft deleted"
+// @Failure 400 {object} utils.APIError "Invalid chatroom ID"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 500 {object} utils.APIError "Internal server error"
+// @Router /chatrooms/{id}/draft [delete]
+func (mc *MessageController) DeleteDraft(c *gin.Context) {
+	chatroomID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, "Invalid chatroom ID")
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondErrorMessage(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	if err := mc.MessageService.DeleteDraft(chatroomID, userID.(uint)); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Draft deleted"})
 }
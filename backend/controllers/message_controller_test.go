@@ -0,0 +1,551 @@
+package controllers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ginchat/config"
+	"github.com/ginchat/controllers"
+	"github.com/ginchat/internal/mongotest"
+	"github.com/ginchat/internal/sqltest"
+	"github.com/ginchat/models"
+	"github.com/ginchat/services"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMessageHistoryLimitIsCapped(t *testing.T) {
+	const maxLimit = 5
+	env := newAPIEnv(t, func(cfg *config.Config) { cfg.MessageHistoryMaxLimit = maxLimit })
+	alice := env.user(t, "alice")
+	roomID := env.createRoom(t, alice, "Busy room")
+	for i := 0; i < maxLimit+3; i++ {
+		env.send(t, alice, roomID, "message "+strconv.Itoa(i))
+	}
+
+	var history struct {
+		Limit    int   `json:"limit"`
+		Messages []any `json:"messages"`
+	}
+	expect(t, env.do(t, alice, http.MethodGet, "/api/chatrooms/"+roomID+"/messages?limit=100000000", nil), http.StatusOK, &history)
+	if history.Limit != maxLimit || len(history.Messages) != maxLimit {
+		t.Errorf("absurd limit returned limit %d with %d messages, want both capped at %d", history.Limit, len(history.Messages), maxLimit)
+	}
+
+	expect(t, env.do(t, alice, http.MethodGet, "/api/chatrooms/"+roomID+"/messages?limit=2", nil), http.StatusOK, &history)
+	if history.Limit != 2 || len(history.Messages) != 2 {
+		t.Errorf("limit under the cap returned limit %d with %d messages, want 2", history.Limit, len(history.Messages))
+	}
+
+	var page struct {
+		Messages []any `json:"messages"`
+	}
+	expect(t, env.do(t, alice, http.MethodGet, "/api/chatrooms/"+roomID+"/messages/paginated?limit=100000000", nil), http.StatusOK, &page)
+	if len(page.Messages) != maxLimit {
+		t.Errorf("paginated page has %d messages, want %d", len(page.Messages), maxLimit)
+	}
+}
+
+func TestSendMessageRejectsForeignMediaURL(t *testing.T) {
+	env := newAPIEnv(t)
+	alice := env.user(t, "alice")
+	roomID := env.createRoom(t, alice, "Gallery")
+
+	body := map[string]string{"message_type": "picture", "media_url": "https://evil.example.com/cat.jpg"}
+	expect(t, env.do(t, alice, http.MethodPost, "/api/chatrooms/"+roomID+"/messages", body), http.StatusBadRequest, nil)
+}
+
+func TestGetMessageCount(t *testing.T) {
+	env := newAPIEnv(t)
+	alice, mallory := env.user(t, "alice"), env.user(t, "mallory")
+	roomID := env.createRoom(t, alice, "Counted")
+	otherRoomID := env.createRoom(t, alice, "Elsewhere")
+	for i := 0; i < 7; i++ {
+		env.send(t, alice, roomID, "message "+strconv.Itoa(i))
+	}
+	env.send(t, alice, otherRoomID, "not counted")
+
+	var body struct {
+		Count int64 `json:"count"`
+	}
+	expect(t, env.do(t, alice, http.MethodGet, "/api/chatrooms/"+roomID+"/message-count", nil), http.StatusOK, &body)
+	if body.Count != 7 {
+		t.Errorf("count = %d, want 7", body.Count)
+	}
+
+	expect(t, env.do(t, mallory, http.MethodGet, "/api/chatrooms/"+roomID+"/message-count", nil), http.StatusForbidden, nil)
+}
+
+func TestSendMessageWithoutWebSocketHub(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	// No hub is injected, and none may be left over from other tests
+	previous := controllers.GlobalWebSocketController
+	controllers.GlobalWebSocketController = nil
+	t.Cleanup(func() { controllers.GlobalWebSocketController = previous })
+
+	gdb, _ := sqltest.Open(t, &models.User{})
+	mdb, _ := mongotest.NewDatabase(t)
+	userService := services.NewUserService(gdb)
+	chatroomService := services.NewChatroomService(mdb, false, nil)
+	readStatusService := services.NewMessageReadStatusService(mdb, chatroomService, userService, false, config.DefaultReadStatusReconcileBatch)
+	messageService := services.NewMessageService(mdb, chatroomService, nil, readStatusService, nil, nil, 100, config.DefaultMessageEditWindow)
+	messageController := controllers.NewMessageController(messageService, nil)
+
+	alice := models.User{Username: "alice", Email: "alice@example.com", Password: "x"}
+	if err := gdb.Create(&alice).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	room, err := chatroomService.CreateChatroom("Offline", "", "", alice.UserID, alice.Username, "", 0)
+	if err != nil {
+		t.Fatalf("CreateChatroom: %v", err)
+	}
+
+	router := gin.New()
+	router.POST("/chatrooms/:id/messages", func(c *gin.Context) {
+		c.Set("user_id", alice.UserID)
+		c.Set("username", alice.Username)
+	}, messageController.SendMessage)
+
+	req := httptest.NewRequest(http.MethodPost, "/chatrooms/"+room.ID.Hex()+"/messages", strings.NewReader(`{"message_type":"text","text_content":"@here anyone?"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	expect(t, w, http.StatusCreated, nil)
+
+	if count, err := messageService.CountMessages(room.ID, alice.UserID); err != nil || count != 1 {
+		t.Errorf("stored messages = %d, %v; want 1", count, err)
+	}
+}
+
+func TestResendMessageNotification(t *testing.T) {
+	expo := &expoCounter{base: http.DefaultTransport}
+	http.DefaultTransport = expo
+	t.Cleanup(func() { http.DefaultTransport = expo.base })
+
+	env := newAPIEnv(t)
+	alice, bob, carol, dave, erin, frank := env.user(t, "alice"), env.user(t, "bob"), env.user(t, "carol"), env.user(t, "dave"), env.user(t, "erin"), env.user(t, "frank")
+	for _, user := range []*apiUser{alice, bob, carol, dave, erin, frank} {
+		if err := env.DB.Create(&models.PushToken{UserID: user.ID, Token: "ExponentPushToken[" + user.Name + "]", Platform: "ios", IsActive: true}).Error; err != nil {
+			t.Fatalf("create push token: %v", err)
+		}
+	}
+	roomID := env.createRoom(t, alice, "Team", bob, carol, dave, erin)
+
+	// carol muted the room; dave and erin are in quiet hours, but erin has the app open
+	muted := true
+	expect(t, env.do(t, carol, http.MethodPut, "/api/notifications/settings", map[string]any{
+		"chatrooms": []map[string]any{{"chatroom_id": roomID, "muted": &muted}},
+	}), http.StatusOK, nil)
+	now := time.Now().UTC()
+	quietHours := map[string]string{"start": now.Add(-time.Hour).Format("15:04"), "end": now.Add(time.Hour).Format("15:04"), "timezone": "UTC"}
+	for _, user := range []*apiUser{dave, erin} {
+		expect(t, env.do(t, user, http.MethodPut, "/api/users/quiet-hours", quietHours), http.StatusOK, nil)
+	}
+	env.dial(t, erin, "global_sidebar")
+
+	messageID := env.send(t, bob, roomID, "did everyone get this?")
+	path := "/api/messages/" + messageID + "/resend-notification"
+
+	expect(t, env.do(t, carol, http.MethodPost, path, nil), http.StatusForbidden, nil)
+	expect(t, env.do(t, frank, http.MethodPost, path, nil), http.StatusForbidden, nil)
+
+	for _, user := range []*apiUser{bob, alice} { // the sender, then a chatroom admin
+		var body struct {
+			TokensTargeted int `json:"tokens_targeted"`
+		}
+		expect(t, env.do(t, user, http.MethodPost, path, nil), http.StatusOK, &body)
+		if body.TokensTargeted != 2 {
+			t.Errorf("resend by %s targeted %d tokens, want alice and erin", user.Name, body.TokensTargeted)
+		}
+	}
+
+	want := map[*apiUser]string{alice: services.NotificationDisplaySystem, erin: services.NotificationDisplayInApp}
+	for _, user := range []*apiUser{alice, bob, carol, dave, erin, frank} {
+		display, sent := expo.sentTo("ExponentPushToken[" + user.Name + "]")
+		if wantDisplay, ok := want[user]; sent != ok || display != wantDisplay {
+			t.Errorf("push to %s: sent %t with display %q, want sent %t with %q", user.Name, sent, display, ok, wantDisplay)
+		}
+	}
+}
+
+func TestNewMessageIsDeliveredOncePerConnection(t *testing.T) {
+	env := newAPIEnv(t)
+	alice, bob, carol, mallory := env.user(t, "alice"), env.user(t, "bob"), env.user(t, "carol"), env.user(t, "mallory")
+	roomID := env.createRoom(t, alice, "General", bob, carol)
+	otherRoomID := env.createRoom(t, carol, "Elsewhere")
+
+	sender := env.dial(t, alice, roomID)
+	bobInRoom := env.dial(t, bob, roomID)
+	carolElsewhere := env.dial(t, carol, otherRoomID)
+	outsider := env.dial(t, mallory, "global_sidebar")
+	time.Sleep(1100 * time.Millisecond) // Each user may open one connection per second
+	bobSidebar := env.dial(t, bob, "global_sidebar")
+
+	messageID := env.send(t, alice, roomID, "hello")
+
+	for _, tc := range []struct {
+		name             string
+		socket           *apiSocket
+		messages, update int
+	}{
+		{"sender in the room", sender, 1, 0},
+		{"member in the room", bobInRoom, 1, 0},
+		{"same member on the sidebar", bobSidebar, 0, 1},
+		{"member in another room", carolElsewhere, 0, 1},
+		{"non-member", outsider, 0, 0},
+	} {
+		events := tc.socket.collect(300 * time.Millisecond)
+		if got := len(events["new_message"]); got != tc.messages {
+			t.Errorf("%s got %d new_message frames, want %d", tc.name, got, tc.messages)
+		}
+		if got := len(events["sidebar_update"]); got != tc.update {
+			t.Errorf("%s got %d sidebar_update frames, want %d", tc.name, got, tc.update)
+		}
+		for _, event := range events["sidebar_update"] {
+			var update controllers.SidebarUpdate
+			if err := json.Unmarshal(event.Data, &update); err != nil || update.MessageID != messageID || update.Preview != "hello" || event.ChatroomID != roomID {
+				t.Errorf("%s: sidebar_update = %+v (%v), want the message preview", tc.name, update, err)
+			}
+		}
+		if tc.messages == 0 && tc.update == 0 {
+			// Nothing at all about the message reaches users outside the room
+			for eventType, frames := range events {
+				for _, event := range frames {
+					if event.ChatroomID == roomID || strings.Contains(string(event.Data), messageID) || strings.Contains(string(event.Data), "hello") {
+						t.Errorf("%s got a %s frame about the message: %s", tc.name, eventType, event.Data)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestEditAndDeleteReachOnlyRoomMembers(t *testing.T) {
+	env := newAPIEnv(t)
+	alice, bob, carol, mallory := env.user(t, "alice"), env.user(t, "bob"), env.user(t, "carol"), env.user(t, "mallory")
+	roomID := env.createRoom(t, alice, "General", bob)
+	otherRoomID := env.createRoom(t, carol, "Elsewhere", mallory)
+	messageID := env.send(t, alice, roomID, "first draft")
+
+	inRoom := env.dial(t, alice, roomID)
+	bobSidebar := env.dial(t, bob, "global_sidebar")
+	carolElsewhere := env.dial(t, carol, otherRoomID)
+	outsider := env.dial(t, mallory, "global_sidebar")
+	sockets := []struct {
+		name   string
+		socket *apiSocket
+		member bool
+	}{
+		{"member in the room", inRoom, true},
+		{"member on the sidebar", bobSidebar, true},
+		{"non-member in another room", carolElsewhere, false},
+		{"non-member on the sidebar", outsider, false},
+	}
+
+	path := "/api/chatrooms/" + roomID + "/messages/" + messageID
+	for _, step := range []struct {
+		eventType string
+		do        func() *httptest.ResponseRecorder
+	}{
+		{"message_updated", func() *httptest.ResponseRecorder {
+			return env.do(t, alice, http.MethodPut, path, map[string]string{"text_content": "final wording"})
+		}},
+		{"message_deleted", func() *httptest.ResponseRecorder { return env.do(t, alice, http.MethodDelete, path, nil) }},
+	} {
+		expect(t, step.do(), http.StatusOK, nil)
+		for _, tc := range sockets {
+			events := tc.socket.collect(300 * time.Millisecond)
+			if got, want := len(events[step.eventType]), map[bool]int{true: 1, false: 0}[tc.member]; got != want {
+				t.Errorf("%s got %d %s frames, want %d", tc.name, got, step.eventType, want)
+			}
+			if tc.member {
+				continue
+			}
+			for eventType, frames := range events {
+				for _, event := range frames {
+					if event.ChatroomID == roomID || strings.Contains(string(event.Data), messageID) || strings.Contains(string(event.Data), "final wording") {
+						t.Errorf("%s got a %s frame about the message: %s", tc.name, eventType, event.Data)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestDeleteMessageBroadcastsToItsOwnRoom(t *testing.T) {
+	env := newAPIEnv(t)
+	alice, bob, carol := env.user(t, "alice"), env.user(t, "bob"), env.user(t, "carol")
+	roomID := env.createRoom(t, alice, "General", bob)
+	otherRoomID := env.createRoom(t, carol, "Elsewhere")
+	messageID := env.send(t, alice, roomID, "wrong room in the URL")
+
+	bobSidebar := env.dial(t, bob, "global_sidebar")
+	carolSidebar := env.dial(t, carol, "global_sidebar")
+
+	// The URL names a room the message isn't in; the recipients come from the message itself
+	expect(t, env.do(t, alice, http.MethodDelete, "/api/chatrooms/"+otherRoomID+"/messages/"+messageID, nil), http.StatusOK, nil)
+
+	deleted := bobSidebar.collect(300 * time.Millisecond)["message_deleted"]
+	if len(deleted) != 1 || deleted[0].ChatroomID != roomID {
+		t.Errorf("bob got %d message_deleted frames (%+v), want one for the message's room", len(deleted), deleted)
+	}
+	if frames := carolSidebar.collect(300 * time.Millisecond)["message_deleted"]; len(frames) != 0 {
+		t.Errorf("carol, a member of the room in the URL only, got %d message_deleted frames", len(frames))
+	}
+}
+
+func TestDrafts(t *testing.T) {
+	env := newAPIEnv(t)
+	alice, bob, mallory := env.user(t, "alice"), env.user(t, "bob"), env.user(t, "mallory")
+	roomID := env.createRoom(t, alice, "General", bob)
+	path := "/api/chatrooms/" + roomID + "/draft"
+
+	draftOf := func(user *apiUser) *models.MessageDraft {
+		t.Helper()
+		var got struct {
+			Draft *models.MessageDraft `json:"draft"`
+		}
+		expect(t, env.do(t, user, http.MethodGet, path, nil), http.StatusOK, &got)
+		return got.Draft
+	}
+	save := func(user *apiUser, text string) {
+		t.Helper()
+		expect(t, env.do(t, user, http.MethodPut, path, map[string]string{"text_content": text}), http.StatusOK, nil)
+	}
+
+	if draft := draftOf(alice); draft != nil {
+		t.Fatalf("draft before saving = %+v, want none", draft)
+	}
+
+	t.Run("upsert", func(t *testing.T) {
+		save(alice, "hello")
+		save(alice, "hello wor")
+		if draft := draftOf(alice); draft == nil || draft.TextContent != "hello wor" {
+			t.Errorf("draft = %+v, want the latest text", draft)
+		}
+		if stored := len(env.MongoDB.Documents("message_drafts")); stored != 1 {
+			t.Errorf("%d drafts stored, want 1", stored)
+		}
+	})
+
+	t.Run("private to each user", func(t *testing.T) {
+		if draft := draftOf(bob); draft != nil {
+			t.Errorf("bob sees draft %+v", draft)
+		}
+		save(bob, "bob's own")
+		if draft := draftOf(alice); draft == nil || draft.TextContent != "hello wor" {
+			t.Errorf("alice's draft = %+v after bob saved a draft", draft)
+		}
+		expect(t, env.do(t, mallory, http.MethodGet, path, nil), http.StatusForbidden, nil)
+		expect(t, env.do(t, mallory, http.MethodPut, path, map[string]string{"text_content": "hi"}), http.StatusForbidden, nil)
+	})
+
+	t.Run("sending clears only the sender's draft", func(t *testing.T) {
+		env.send(t, alice, roomID, "hello world")
+		if draft := draftOf(alice); draft != nil {
+			t.Errorf("alice's draft after sending = %+v, want none", draft)
+		}
+		if draft := draftOf(bob); draft == nil {
+			t.Error("bob's draft was cleared by alice's message")
+		}
+	})
+
+	t.Run("clearing", func(t *testing.T) {
+		save(bob, "")
+		if draft := draftOf(bob); draft != nil {
+			t.Errorf("saving empty text left draft %+v", draft)
+		}
+		save(alice, "again")
+		expect(t, env.do(t, alice, http.MethodDelete, path, nil), http.StatusOK, nil)
+		if draft := draftOf(alice); draft != nil {
+			t.Errorf("draft after delete = %+v", draft)
+		}
+		expect(t, env.do(t, alice, http.MethodDelete, path, nil), http.StatusOK, nil)
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		expect(t, env.do(t, alice, http.MethodPut, path, map[string]string{"text_content": strings.Repeat("x", 10001)}), http.StatusBadRequest, nil)
+		expect(t, env.do(t, alice, http.MethodPut, path, map[string]string{"media_url": "https://example.com/cat.jpg"}), http.StatusBadRequest, nil)
+	})
+}
+
+func TestGetMessagesBySender(t *testing.T) {
+	env := newAPIEnv(t)
+	alice, bob, carol, mallory := env.user(t, "alice"), env.user(t, "bob"), env.user(t, "carol"), env.user(t, "mallory")
+	roomID := env.createRoom(t, alice, "General", bob, carol)
+	otherRoomID := env.createRoom(t, bob, "Elsewhere")
+
+	var want []string
+	for i := range 5 {
+		want = append(want, env.send(t, bob, roomID, "bob "+strconv.Itoa(i)))
+		env.send(t, alice, roomID, "alice "+strconv.Itoa(i))
+	}
+	env.send(t, bob, otherRoomID, "not here")
+
+	type page struct {
+		Messages   []models.MessageResponse `json:"messages"`
+		HasMore    bool                     `json:"has_more"`
+		NextCursor *string                  `json:"next_cursor"`
+	}
+	path := "/api/chatrooms/" + roomID + "/messages/by-sender/" + strconv.FormatUint(uint64(bob.ID), 10)
+
+	// Pages go back in time, each in reading order
+	var got []string
+	query := "?limit=2"
+	for pages := 0; ; pages++ {
+		if pages == 5 {
+			t.Fatalf("still paging after %d pages", pages)
+		}
+		var p page
+		expect(t, env.do(t, carol, http.MethodGet, path+query, nil), http.StatusOK, &p)
+		var ids []string
+		for _, m := range p.Messages {
+			if m.SenderID != bob.ID || m.ChatroomID != roomID {
+				t.Errorf("message %s from %d in %s, want only bob's in the room", m.ID, m.SenderID, m.ChatroomID)
+			}
+			if len(m.ReadStatus) == 0 {
+				t.Errorf("message %s has no read status", m.ID)
+			}
+			ids = append(ids, m.ID)
+		}
+		got = append(ids, got...)
+		if !p.HasMore {
+			if p.NextCursor != nil {
+				t.Errorf("last page has next_cursor %q", *p.NextCursor)
+			}
+			break
+		}
+		if p.NextCursor == nil {
+			t.Fatalf("page with more has no next_cursor")
+		}
+		query = "?limit=2&before=" + url.QueryEscape(*p.NextCursor)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("bob's messages = %v, want %v", got, want)
+	}
+
+	for _, tt := range []struct {
+		user   *apiUser
+		path   string
+		status int
+	}{
+		{mallory, path, http.StatusForbidden},
+		{carol, "/api/chatrooms/" + roomID + "/messages/by-sender/bob", http.StatusBadRequest},
+		{carol, "/api/chatrooms/nope/messages/by-sender/1", http.StatusBadRequest},
+		{carol, path + "?before=yesterday", http.StatusBadRequest},
+	} {
+		expect(t, env.do(t, tt.user, http.MethodGet, tt.path, nil), tt.status, nil)
+	}
+}
+
+func TestGetMessagesInRange(t *testing.T) {
+	env := newAPIEnv(t)
+	alice, bob, mallory := env.user(t, "alice"), env.user(t, "bob"), env.user(t, "mallory")
+	roomID := env.createRoom(t, alice, "General", bob)
+
+	// Five messages an hour apart, starting last Tuesday at noon, except that the last two share a send time
+	start := time.Date(2024, 3, 5, 12, 0, 0, 0, time.UTC)
+	var ids []string
+	for i := range 5 {
+		id := env.send(t, alice, roomID, "message "+strconv.Itoa(i))
+		objectID, _ := primitive.ObjectIDFromHex(id)
+		sentAt := start.Add(time.Duration(min(i, 3)) * time.Hour)
+		if _, err := env.Mongo.Collection("messages").UpdateByID(context.Background(), objectID, bson.M{"$set": bson.M{"sent_at": sentAt}}); err != nil {
+			t.Fatalf("set sent_at: %v", err)
+		}
+		ids = append(ids, id)
+	}
+
+	type page struct {
+		Messages   []models.MessageResponse `json:"messages"`
+		HasMore    bool                     `json:"has_more"`
+		NextCursor *string                  `json:"next_cursor"`
+	}
+	get := func(user *apiUser, from, to time.Time, limit, cursor string, status int) page {
+		t.Helper()
+		query := url.Values{"from": {from.Format(time.RFC3339Nano)}, "to": {to.Format(time.RFC3339Nano)}, "limit": {limit}, "cursor": {cursor}}
+		var got page
+		expect(t, env.do(t, user, http.MethodGet, "/api/chatrooms/"+roomID+"/messages/range?"+query.Encode(), nil), status, &got)
+		return got
+	}
+	messageIDs := func(p page) []string {
+		var got []string
+		for _, m := range p.Messages {
+			got = append(got, m.ID)
+		}
+		return got
+	}
+
+	t.Run("bounded range", func(t *testing.T) {
+		// Both bounds are inclusive; the join notice from before start is left out
+		from, to := start.Add(time.Hour), start.Add(2*time.Hour)
+		got := get(bob, from, to, "", "", http.StatusOK)
+		if !slices.Equal(messageIDs(got), ids[1:3]) || got.HasMore {
+			t.Errorf("range = %v (has_more %t), want %v", messageIDs(got), got.HasMore, ids[1:3])
+		}
+		for _, m := range got.Messages {
+			if len(m.ReadStatus) == 0 {
+				t.Errorf("message %s has no read status", m.ID)
+			}
+		}
+
+	})
+
+	t.Run("paging through messages sent at the same time", func(t *testing.T) {
+		// One message per page, so a page boundary falls between the two sharing a send time
+		from, to := start.Add(time.Hour), start.Add(3*time.Hour)
+		var seen []string
+		cursor := ""
+		for range len(ids) {
+			got := get(bob, from, to, "1", cursor, http.StatusOK)
+			seen = append(seen, messageIDs(got)...)
+			if !got.HasMore {
+				break
+			}
+			if got.NextCursor == nil {
+				t.Fatal("has_more without next_cursor")
+			}
+			cursor = *got.NextCursor
+		}
+		if !slices.Equal(seen, ids[1:]) {
+			t.Errorf("paged through %v, want %v", seen, ids[1:])
+		}
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		for _, tt := range []struct {
+			name     string
+			user     *apiUser
+			from, to time.Time
+			status   int
+			code     string
+		}{
+			{"reversed", bob, start.Add(time.Hour), start, http.StatusBadRequest, "INVALID_DATE_RANGE"},
+			{"too long", bob, start, start.Add(services.MaxMessageRange + time.Hour), http.StatusBadRequest, "DATE_RANGE_TOO_LONG"},
+			{"non-member", mallory, start, start.Add(time.Hour), http.StatusForbidden, ""},
+		} {
+			var got apiError
+			query := url.Values{"from": {tt.from.Format(time.RFC3339)}, "to": {tt.to.Format(time.RFC3339)}}
+			expect(t, env.do(t, tt.user, http.MethodGet, "/api/chatrooms/"+roomID+"/messages/range?"+query.Encode(), nil), tt.status, &got)
+			if tt.code != "" && got.Code != tt.code {
+				t.Errorf("%s: code = %s, want %s", tt.name, got.Code, tt.code)
+			}
+		}
+		expect(t, env.do(t, bob, http.MethodGet, "/api/chatrooms/"+roomID+"/messages/range?from=last+tuesday&to=2024-03-06T00:00:00Z", nil), http.StatusBadRequest, nil)
+		expect(t, env.do(t, bob, http.MethodGet, "/api/chatrooms/"+roomID+"/messages/range?from=2024-03-05T00:00:00Z", nil), http.StatusBadRequest, nil)
+		var got apiError
+		expect(t, env.do(t, bob, http.MethodGet, "/api/chatrooms/"+roomID+"/messages/range?from=2024-03-05T00:00:00Z&to=2024-03-06T00:00:00Z&cursor=2024-03-05T13:00:00Z", nil), http.StatusBadRequest, &got)
+		if got.Code != "INVALID_CURSOR" {
+			t.Errorf("timestamp as cursor: code = %s, want INVALID_CURSOR", got.Code)
+		}
+	})
+}
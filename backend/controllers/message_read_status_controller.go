@@ -1,10 +1,13 @@
 package controllers
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/ginchat/models"
 	"github.com/ginchat/services"
 	"github.com/ginchat/utils"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -12,6 +15,7 @@ import (
 
 // MessageReadStatusController handles HTTP requests related to message read status
 type MessageReadStatusController struct {
+	wsHub
 	ReadStatusService *services.MessageReadStatusService
 }
 
@@ -24,52 +28,54 @@ func NewMessageReadStatusController(readStatusService *services.MessageReadStatu
 
 // MarkMessageAsReadRequest represents the request body for marking a message as read
 type MarkMessageAsReadRequest struct {
-	MessageID string `json:"message_id" binding:"required" example:"60d5f8b8e6b5f0b3e8b4b5b3"` // The ID of the message to mark as read
+	MessageID string     `json:"message_id" binding:"required" example:"60d5f8b8e6b5f0b3e8b4b5b3"` // The ID of the message to mark as read
+	ReadAt    *time.Time `json:"read_at,omitempty" example:"2024-01-01T00:00:00Z"`                 // When the user actually read it, for reads synced after being offline (defaults to now)
 }
 
 // MarkMessageAsRead handles marking a message as read by the authenticated user
 // @Summary Mark a message as read
-// @Description Mark a specific message as read by the authenticated user
+// @Description Mark a specific message as read by the authenticated user. An optional read_at records when it was actually read
+// @Description (e.g. while offline); it must not be in the future or before the message was sent
 // @Tags message-read-status
 // @Accept json
 // @Produce json
 // @Security ApiKeyAuth
 // @Param request body MarkMessageAsReadRequest true "Message ID to mark as read"
 // @Success 200 {object} map[string]string "Message marked as read successfully"
-// @Failure 400 {object} map[string]string "Invalid request body or message ID"
-// @Failure 401 {object} map[string]string "User not authenticated"
-// @Failure 404 {object} map[string]string "Message not found"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Failure 400 {object} utils.APIError "Invalid request body, message ID or read time"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 404 {object} utils.APIError "Message not found"
+// @Failure 500 {object} utils.APIError "Internal server error"
 // @Router /messages/read [post]
 func (c *MessageReadStatusController) MarkMessageAsRead(ctx *gin.Context) {
 	var req MarkMessageAsReadRequest
 	if err := ctx.ShouldBindJSON(&req); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": utils.FormatValidationError(err)})
+		respondErrorMessage(ctx, http.StatusBadRequest, utils.FormatValidationError(err))
 		return
 	}
 
 	// Get user ID from context (set by auth middleware)
 	userID, exists := ctx.Get("user_id")
 	if !exists {
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		respondErrorMessage(ctx, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
 	// Convert message ID to ObjectID
 	messageObjectID, err := primitive.ObjectIDFromHex(req.MessageID)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		respondErrorMessage(ctx, http.StatusBadRequest, "Invalid message ID")
 		return
 	}
 
 	// Mark message as read (optimized - returns chatroom ID to avoid extra query)
-	chatroomID, err := c.ReadStatusService.MarkMessageAsReadOptimized(messageObjectID, userID.(uint))
+	chatroomID, err := c.ReadStatusService.MarkMessageAsReadAt(messageObjectID, userID.(uint), req.ReadAt)
 	if err != nil {
 		if err.Error() == "read status not found" {
-			ctx.JSON(http.StatusNotFound, gin.H{"error": "Message not found or already read"})
+			respondError(ctx, utils.ServiceAPIError(err).WithMessage("Message not found or already read"))
 			return
 		}
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": utils.FormatServiceError(err)})
+		respondError(ctx, err)
 		return
 	}
 
@@ -77,24 +83,8 @@ func (c *MessageReadStatusController) MarkMessageAsRead(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, gin.H{"message": "Message marked as read successfully"})
 
 	// Handle WebSocket notifications asynchronously (non-blocking)
-	go func() {
-		// Get updated read status and broadcast
-		readStatus, err := c.ReadStatusService.GetMessageReadStatus(messageObjectID)
-		if err == nil {
-			// Broadcast read status update with user_id for filtering
-			BroadcastMessageReadGlobal(chatroomID.Hex(), map[string]any{
-				"message_id":  messageObjectID.Hex(),
-				"read_status": readStatus,
-				"user_id":     userID.(uint),
-			})
-		}
-
-		// Update unread counts for current user only (more efficient)
-		unreadCounts, err := c.ReadStatusService.GetUnreadCountForUser(userID.(uint))
-		if err == nil {
-			BroadcastUnreadCountUpdateGlobal(userID.(uint), unreadCounts)
-		}
-	}()
+	go c.broadcastMessageRead(chatroomID, messageObjectID, userID.(uint))
+	c.syncReadToDevices(userID.(uint), chatroomID, messageObjectID)
 }
 
 // GetUserLastReadForChatroom gets the last read message for a user in a specific chatroom
@@ -105,36 +95,36 @@ func (c *MessageReadStatusController) MarkMessageAsRead(ctx *gin.Context) {
 // @Security ApiKeyAuth
 // @Param id path string true "Chatroom ID"
 // @Success 200 {object} models.UserLastReadResponse "User's last read message information"
-// @Failure 400 {object} map[string]string "Invalid chatroom ID"
-// @Failure 401 {object} map[string]string "User not authenticated"
-// @Failure 404 {object} map[string]string "No read history found"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Failure 400 {object} utils.APIError "Invalid chatroom ID"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 404 {object} utils.APIError "No read history found"
+// @Failure 500 {object} utils.APIError "Internal server error"
 // @Router /chatrooms/{id}/last-read [get]
 func (c *MessageReadStatusController) GetUserLastReadForChatroom(ctx *gin.Context) {
 	// Get chatroom ID from URL parameter
 	chatroomIDStr := ctx.Param("id")
 	chatroomID, err := primitive.ObjectIDFromHex(chatroomIDStr)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chatroom ID"})
+		respondErrorMessage(ctx, http.StatusBadRequest, "Invalid chatroom ID")
 		return
 	}
 
 	// Get user ID from context (set by auth middleware)
 	userID, exists := ctx.Get("user_id")
 	if !exists {
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		respondErrorMessage(ctx, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
 	// Get user's last read message for the chatroom
 	lastRead, err := c.ReadStatusService.GetUserLastReadForChatroom(chatroomID, userID.(uint))
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": utils.FormatServiceError(err)})
+		respondError(ctx, err)
 		return
 	}
 
 	if lastRead == nil {
-		ctx.JSON(http.StatusNotFound, gin.H{"error": "No read history found for this chatroom"})
+		respondErrorMessage(ctx, http.StatusNotFound, "No read history found for this chatroom")
 		return
 	}
 
@@ -148,21 +138,73 @@ func (c *MessageReadStatusController) GetUserLastReadForChatroom(ctx *gin.Contex
 // @Produce json
 // @Security ApiKeyAuth
 // @Success 200 {array} models.ChatroomUnreadCount "Unread message counts for each chatroom"
-// @Failure 401 {object} map[string]string "User not authenticated"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 500 {object} utils.APIError "Internal server error"
 // @Router /messages/unread-counts [get]
 func (c *MessageReadStatusController) GetUnreadCountForUser(ctx *gin.Context) {
 	// Get user ID from context (set by auth middleware)
 	userID, exists := ctx.Get("user_id")
 	if !exists {
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		respondErrorMessage(ctx, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
 	// Get unread counts for all chatrooms
 	unreadCounts, err := c.ReadStatusService.GetUnreadCountForUser(userID.(uint))
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": utils.FormatServiceError(err)})
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, unreadCounts)
+}
+
+// GetUnreadCountForChatrooms gets unread message counts for a chosen set of chatrooms
+// @Summary Get unread message counts for some chatrooms
+// @Description Get unread message counts for just the listed chatrooms (e.g. the rooms in a folder) instead of every joined room.
+// @Description Chatrooms you don't belong to are left out of the result rather than causing an error
+// @Tags message-read-status
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body []string true "Array of chatroom IDs (at most 200)"
+// @Success 200 {array} models.ChatroomUnreadCount "Unread message counts for the listed chatrooms you belong to"
+// @Failure 400 {object} utils.APIError "Invalid request body, invalid chatroom ID or too many IDs"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 500 {object} utils.APIError "Internal server error"
+// @Router /messages/unread-counts/filter [post]
+func (c *MessageReadStatusController) GetUnreadCountForChatrooms(ctx *gin.Context) {
+	var chatroomIDStrs []string
+	if err := ctx.ShouldBindJSON(&chatroomIDStrs); err != nil {
+		respondErrorMessage(ctx, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	// Get user ID from context (set by auth middleware)
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		respondErrorMessage(ctx, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	if len(chatroomIDStrs) > services.MaxUnreadCountFilter {
+		respondErrorMessage(ctx, http.StatusBadRequest, "You can request unread counts for at most "+strconv.Itoa(services.MaxUnreadCountFilter)+" chatrooms at once")
+		return
+	}
+
+	chatroomIDs := make([]primitive.ObjectID, 0, len(chatroomIDStrs))
+	for _, idStr := range chatroomIDStrs {
+		chatroomID, err := primitive.ObjectIDFromHex(idStr)
+		if err != nil {
+			respondErrorMessage(ctx, http.StatusBadRequest, "Invalid chatroom ID: "+idStr)
+			return
+		}
+		chatroomIDs = append(chatroomIDs, chatroomID)
+	}
+
+	unreadCounts, err := c.ReadStatusService.GetUnreadCountForChatrooms(userID.(uint), chatroomIDs)
+	if err != nil {
+		respondError(ctx, err)
 		return
 	}
 
@@ -171,26 +213,63 @@ func (c *MessageReadStatusController) GetUnreadCountForUser(ctx *gin.Context) {
 
 // GetLatestMessagesForChatrooms gets the latest message for each chatroom the user has joined
 // @Summary Get latest messages for all chatrooms
-// @Description Get the latest message for each chatroom that the authenticated user has joined
+// @Description Get the latest message for each chatroom that the authenticated user has joined. Without query parameters every chatroom is returned as an array. Passing limit, cursor or unread_only returns a page of chatrooms ordered by latest activity instead
 // @Tags message-read-status
 // @Produce json
 // @Security ApiKeyAuth
+// @Param limit query int false "Chatrooms per page" default(50) minimum(1) maximum(200)
+// @Param cursor query string false "next_cursor from the previous page"
+// @Param unread_only query bool false "Only include chatrooms with unread messages"
 // @Success 200 {array} models.LatestChatMessage "Latest messages for each chatroom"
-// @Failure 401 {object} map[string]string "User not authenticated"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Success 200 {object} services.LatestMessagesPage "A page of chatrooms (when paging parameters are given)"
+// @Failure 400 {object} utils.APIError "Invalid paging parameters"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 500 {object} utils.APIError "Internal server error"
 // @Router /messages/latest [get]
 func (c *MessageReadStatusController) GetLatestMessagesForChatrooms(ctx *gin.Context) {
 	// Get user ID from context (set by auth middleware)
 	userID, exists := ctx.Get("user_id")
 	if !exists {
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		respondErrorMessage(ctx, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	// Paging parameters switch to the paginated response; without them the full array is kept for older clients
+	limitParam, hasLimit := ctx.GetQuery("limit")
+	cursor, hasCursor := ctx.GetQuery("cursor")
+	unreadOnlyParam, hasUnreadOnly := ctx.GetQuery("unread_only")
+	if hasLimit || hasCursor || hasUnreadOnly {
+		query := services.LatestMessagesQuery{Cursor: cursor}
+		if hasLimit {
+			limit, err := strconv.Atoi(limitParam)
+			if err != nil || limit <= 0 {
+				respondErrorMessage(ctx, http.StatusBadRequest, "Limit must be a positive number")
+				return
+			}
+			query.Limit = limit
+		}
+		if hasUnreadOnly {
+			unreadOnly, err := strconv.ParseBool(unreadOnlyParam)
+			if err != nil {
+				respondErrorMessage(ctx, http.StatusBadRequest, "unread_only must be true or false")
+				return
+			}
+			query.UnreadOnly = unreadOnly
+		}
+
+		page, err := c.ReadStatusService.GetLatestMessagesPage(userID.(uint), query)
+		if err != nil {
+			respondError(ctx, err)
+			return
+		}
+		ctx.JSON(http.StatusOK, page)
 		return
 	}
 
 	// Get latest messages for all chatrooms
 	latestMessages, err := c.ReadStatusService.GetLatestMessageForChatrooms(userID.(uint))
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": utils.FormatServiceError(err)})
+		respondError(ctx, err)
 		return
 	}
 
@@ -205,36 +284,96 @@ func (c *MessageReadStatusController) GetLatestMessagesForChatrooms(ctx *gin.Con
 // @Security ApiKeyAuth
 // @Param message_id path string true "Message ID"
 // @Success 200 {array} models.ReadInfo "Read status information for the message"
-// @Failure 400 {object} map[string]string "Invalid message ID"
-// @Failure 401 {object} map[string]string "User not authenticated"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Failure 400 {object} utils.APIError "Invalid message ID"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 500 {object} utils.APIError "Internal server error"
 // @Router /messages/{message_id}/read-status [get]
 func (c *MessageReadStatusController) GetMessageReadStatus(ctx *gin.Context) {
 	// Get message ID from URL parameter
 	messageIDStr := ctx.Param("message_id")
 	messageID, err := primitive.ObjectIDFromHex(messageIDStr)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		respondErrorMessage(ctx, http.StatusBadRequest, "Invalid message ID")
 		return
 	}
 
 	// Get user ID from context (set by auth middleware)
 	_, exists := ctx.Get("user_id")
 	if !exists {
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		respondErrorMessage(ctx, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
 	// Get read status for the message
 	readStatus, err := c.ReadStatusService.GetMessageReadStatus(messageID)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": utils.FormatServiceError(err)})
+		respondError(ctx, err)
 		return
 	}
 
 	ctx.JSON(http.StatusOK, readStatus)
 }
 
+// GetMessageReadStatusBatch gets read status for many messages in one request
+// @Summary Get read status for many messages
+// @Description Get read status information for up to 200 messages at once, keyed by message ID. Saves a page of messages from needing one read status request per message.
+// @Description Messages without read statuses (unknown, with no other recipients, or in chatrooms you aren't a member of) are left out; invalid IDs are reported in invalid_ids.
+// @Tags message-read-status
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body []string true "Array of message IDs (at most 200)"
+// @Success 200 {object} map[string]interface{} "read_status maps each message ID to its read status information"
+// @Failure 400 {object} utils.APIError "Invalid request body or too many IDs"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 500 {object} utils.APIError "Internal server error"
+// @Router /messages/read-status/batch [post]
+func (c *MessageReadStatusController) GetMessageReadStatusBatch(ctx *gin.Context) {
+	var messageIDs []string
+	if err := ctx.ShouldBindJSON(&messageIDs); err != nil {
+		respondErrorMessage(ctx, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		respondErrorMessage(ctx, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	if len(messageIDs) > services.MaxReadStatusBatch {
+		respondErrorMessage(ctx, http.StatusBadRequest, "You can get the read status of at most "+strconv.Itoa(services.MaxReadStatusBatch)+" messages at once")
+		return
+	}
+
+	validIDs := make([]primitive.ObjectID, 0, len(messageIDs))
+	invalidIDs := []string{}
+	for _, messageIDStr := range messageIDs {
+		messageID, err := primitive.ObjectIDFromHex(messageIDStr)
+		if err != nil {
+			invalidIDs = append(invalidIDs, messageIDStr)
+			continue
+		}
+		validIDs = append(validIDs, messageID)
+	}
+
+	statuses, err := c.ReadStatusService.GetMessageReadStatusBatchForUser(validIDs, userID.(uint))
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	readStatus := make(map[string][]models.ReadInfo, len(statuses))
+	for messageID, readInfos := range statuses {
+		readStatus[messageID.Hex()] = readInfos
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"read_status": readStatus,
+		"invalid_ids": invalidIDs,
+	})
+}
+
 // MarkMultipleMessagesAsRead handles marking multiple messages as read
 // @Summary Mark multiple messages as read
 // @Description Mark multiple messages as read by the authenticated user (useful for marking all messages in a chatroom as read)
@@ -242,52 +381,95 @@ func (c *MessageReadStatusController) GetMessageReadStatus(ctx *gin.Context) {
 // @Accept json
 // @Produce json
 // @Security ApiKeyAuth
-// @Param request body []string true "Array of message IDs to mark as read"
-// @Success 200 {object} map[string]interface{} "Results of marking messages as read"
-// @Failure 400 {object} map[string]string "Invalid request body"
-// @Failure 401 {object} map[string]string "User not authenticated"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Param request body []string true "Array of message IDs to mark as read (at most 500)"
+// @Param read_at query string false "When the messages were actually read (RFC3339), for reads synced after being offline. Must not be in the future or before any of them was sent"
+// @Success 200 {object} map[string]interface{} "Results of marking messages as read, with the IDs that were invalid, already read or not found"
+// @Failure 400 {object} utils.APIError "Invalid request body, read time or too many IDs"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 500 {object} utils.APIError "Internal server error"
 // @Router /messages/read-multiple [post]
 func (c *MessageReadStatusController) MarkMultipleMessagesAsRead(ctx *gin.Context) {
 	var messageIDs []string
 	if err := ctx.ShouldBindJSON(&messageIDs); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		respondErrorMessage(ctx, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
 	// Get user ID from context (set by auth middleware)
 	userID, exists := ctx.Get("user_id")
 	if !exists {
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		respondErrorMessage(ctx, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
-	successCount := 0
-	errorCount := 0
-	var errors []string
+	if len(messageIDs) > services.MaxMarkReadBatch {
+		respondErrorMessage(ctx, http.StatusBadRequest, "You can mark at most "+strconv.Itoa(services.MaxMarkReadBatch)+" messages as read at once")
+		return
+	}
 
-	// Mark each message as read
+	readAt, ok := readAtQuery(ctx)
+	if !ok {
+		return
+	}
+
+	// Validate every ID before touching anything, so a bad ID doesn't leave the batch half-applied
+	var validIDs []primitive.ObjectID
+	var invalidIDs []string
 	for _, messageIDStr := range messageIDs {
 		messageID, err := primitive.ObjectIDFromHex(messageIDStr)
 		if err != nil {
-			errorCount++
-			errors = append(errors, "Invalid message ID: "+messageIDStr)
+			invalidIDs = append(invalidIDs, messageIDStr)
 			continue
 		}
+		validIDs = append(validIDs, messageID)
+	}
 
-		err = c.ReadStatusService.MarkMessageAsRead(messageID, userID.(uint))
-		if err != nil {
-			errorCount++
-			errors = append(errors, "Failed to mark message "+messageIDStr+" as read: "+err.Error())
-		} else {
+	result, err := c.ReadStatusService.MarkMessagesAsReadAt(validIDs, userID.(uint), readAt)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	marked := make(map[primitive.ObjectID]bool, len(result.Marked))
+	for _, id := range result.Marked {
+		marked[id] = true
+	}
+	alreadyRead := make(map[primitive.ObjectID]bool, len(result.AlreadyRead))
+	for _, id := range result.AlreadyRead {
+		alreadyRead[id] = true
+	}
+
+	// Per-ID reporting, in request order
+	successCount := 0
+	var errors []string
+	alreadyReadIDs := []string{}
+	notFoundIDs := []string{}
+	for _, messageIDStr := range messageIDs {
+		messageID, err := primitive.ObjectIDFromHex(messageIDStr)
+		switch {
+		case err != nil:
+			errors = append(errors, "Invalid message ID: "+messageIDStr)
+		case marked[messageID]:
 			successCount++
+		case alreadyRead[messageID]:
+			alreadyReadIDs = append(alreadyReadIDs, messageIDStr)
+			errors = append(errors, "Failed to mark message "+messageIDStr+" as read: already read")
+		default:
+			notFoundIDs = append(notFoundIDs, messageIDStr)
+			errors = append(errors, "Failed to mark message "+messageIDStr+" as read: read status not found")
 		}
 	}
 
+	if invalidIDs == nil {
+		invalidIDs = []string{}
+	}
 	response := gin.H{
-		"success_count": successCount,
-		"error_count":   errorCount,
-		"total":         len(messageIDs),
+		"success_count":    successCount,
+		"error_count":      len(messageIDs) - successCount,
+		"total":            len(messageIDs),
+		"invalid_ids":      invalidIDs,     // Not valid ObjectIDs
+		"already_read_ids": alreadyReadIDs, // Were already read
+		"not_found_ids":    notFoundIDs,    // Unknown message, or you aren't a recipient
 	}
 
 	if len(errors) > 0 {
@@ -295,44 +477,141 @@ func (c *MessageReadStatusController) MarkMultipleMessagesAsRead(ctx *gin.Contex
 	}
 
 	ctx.JSON(http.StatusOK, response)
+
+	if len(result.Marked) > 0 {
+		markedIDs := make([]string, 0, len(result.Marked))
+		for _, id := range result.Marked {
+			markedIDs = append(markedIDs, id.Hex())
+		}
+		c.hub().BroadcastSelfSync(userID.(uint), SelfSyncEvent{Action: SelfSyncRead, MessageIDs: markedIDs}, nil)
+	}
 }
 
 // GetMessageReadByWho gets detailed information about who has read a specific message
 // @Summary Get detailed read status for a message
-// @Description Get detailed information about who has read a specific message and when
+// @Description Get detailed information about who has read a specific message and when.
+// @Description Without limit/offset the full list is returned; with either one a page is returned along with the total count.
 // @Tags message-read-status
 // @Produce json
 // @Security ApiKeyAuth
 // @Param message_id path string true "Message ID"
+// @Param limit query int false "Number of read statuses per page (default: 50, max: 200)"
+// @Param offset query int false "Number of read statuses to skip (default: 0)"
 // @Success 200 {array} models.MessageReadStatusResponse "Detailed read status information"
-// @Failure 400 {object} map[string]string "Invalid message ID"
-// @Failure 401 {object} map[string]string "User not authenticated"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Failure 400 {object} utils.APIError "Invalid message ID or pagination parameters"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 500 {object} utils.APIError "Internal server error"
 // @Router /messages/{message_id}/read-by-who [get]
 func (c *MessageReadStatusController) GetMessageReadByWho(ctx *gin.Context) {
 	// Get message ID from URL parameter
 	messageIDStr := ctx.Param("message_id")
 	messageID, err := primitive.ObjectIDFromHex(messageIDStr)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		respondErrorMessage(ctx, http.StatusBadRequest, "Invalid message ID")
 		return
 	}
 
 	// Get user ID from context (set by auth middleware)
 	_, exists := ctx.Get("user_id")
 	if !exists {
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		respondErrorMessage(ctx, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
-	// Get detailed read status for the message
-	readStatuses, err := c.ReadStatusService.GetMessageReadByWho(messageID)
+	limitParam, hasLimit := ctx.GetQuery("limit")
+	offsetParam, hasOffset := ctx.GetQuery("offset")
+
+	// Keep the original behavior (full list) when no pagination params are given
+	if !hasLimit && !hasOffset {
+		readStatuses, err := c.ReadStatusService.GetMessageReadByWho(messageID)
+		if err != nil {
+			respondError(ctx, err)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, readStatuses)
+		return
+	}
+
+	limit := 50 // Default page size
+	if hasLimit {
+		limit, err = strconv.Atoi(limitParam)
+		if err != nil || limit <= 0 {
+			respondErrorMessage(ctx, http.StatusBadRequest, "Limit must be a positive number")
+			return
+		}
+		if limit > 200 {
+			limit = 200
+		}
+	}
+
+	offset := 0
+	if hasOffset {
+		offset, err = strconv.Atoi(offsetParam)
+		if err != nil || offset < 0 {
+			respondErrorMessage(ctx, http.StatusBadRequest, "Offset must be zero or a positive number")
+			return
+		}
+	}
+
+	// Get one page of read statuses for the message
+	readStatuses, total, err := c.ReadStatusService.GetMessageReadByWhoPaginated(messageID, limit, offset)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": utils.FormatServiceError(err)})
+		respondError(ctx, err)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, readStatuses)
+	ctx.JSON(http.StatusOK, gin.H{
+		"read_statuses": readStatuses,
+		"total":         total,
+		"limit":         limit,
+		"offset":        offset,
+		"has_more":      int64(offset+len(readStatuses)) < total,
+	})
+}
+
+// GetMessageUnreadBy gets the recipients who have not read a specific message yet
+// @Summary Get who has not read a message
+// @Description Get the list of recipients who have not read a message yet (only the sender can view this)
+// @Tags message-read-status
+// @Produce json
+// @Security ApiKeyAuth
+// @Param message_id path string true "Message ID"
+// @Success 200 {array} models.ReadInfo "Recipients who have not read the message"
+// @Failure 400 {object} utils.APIError "Invalid message ID"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 403 {object} utils.APIError "User is not the sender of this message"
+// @Failure 404 {object} utils.APIError "Message not found"
+// @Failure 500 {object} utils.APIError "Internal server error"
+// @Router /messages/{message_id}/unread-by [get]
+func (c *MessageReadStatusController) GetMessageUnreadBy(ctx *gin.Context) {
+	// Get message ID from URL parameter
+	messageIDStr := ctx.Param("message_id")
+	messageID, err := primitive.ObjectIDFromHex(messageIDStr)
+	if err != nil {
+		respondErrorMessage(ctx, http.StatusBadRequest, "Invalid message ID")
+		return
+	}
+
+	// Get user ID from context (set by auth middleware)
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		respondErrorMessage(ctx, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	// Get recipients who have not read the message
+	unreadRecipients, err := c.ReadStatusService.GetUnreadRecipients(messageID, userID.(uint))
+	if err != nil {
+		if err.Error() == "user is not the sender of this message" {
+			respondError(ctx, utils.ServiceAPIError(err).WithMessage("Only the sender can see who has not read this message"))
+			return
+		}
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, unreadRecipients)
 }
 
 // MarkAllMessagesInChatroomAsRead marks all messages in a chatroom as read for the authenticated user
@@ -343,30 +622,30 @@ func (c *MessageReadStatusController) GetMessageReadByWho(ctx *gin.Context) {
 // @Security ApiKeyAuth
 // @Param id path string true "Chatroom ID"
 // @Success 200 {object} map[string]string "All messages marked as read successfully"
-// @Failure 400 {object} map[string]string "Invalid chatroom ID"
-// @Failure 401 {object} map[string]string "User not authenticated"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Failure 400 {object} utils.APIError "Invalid chatroom ID"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 500 {object} utils.APIError "Internal server error"
 // @Router /chatrooms/{id}/mark-all-read [post]
 func (c *MessageReadStatusController) MarkAllMessagesInChatroomAsRead(ctx *gin.Context) {
 	// Get chatroom ID from URL parameter
 	chatroomIDStr := ctx.Param("id")
 	chatroomID, err := primitive.ObjectIDFromHex(chatroomIDStr)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chatroom ID"})
+		respondErrorMessage(ctx, http.StatusBadRequest, "Invalid chatroom ID")
 		return
 	}
 
 	// Get user ID from context (set by auth middleware)
 	userID, exists := ctx.Get("user_id")
 	if !exists {
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		respondErrorMessage(ctx, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
 	// Mark all messages as read (optimized - no need to get unread messages first)
 	err = c.ReadStatusService.MarkAllMessagesInChatroomAsRead(chatroomID, userID.(uint))
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": utils.FormatServiceError(err)})
+		respondError(ctx, err)
 		return
 	}
 
@@ -374,22 +653,55 @@ func (c *MessageReadStatusController) MarkAllMessagesInChatroomAsRead(ctx *gin.C
 	ctx.JSON(http.StatusOK, gin.H{"message": "All messages marked as read successfully"})
 
 	// Handle WebSocket notifications asynchronously (non-blocking) with debounce
+	go c.broadcastChatroomRead(chatroomID, userID.(uint))
+	c.hub().BroadcastSelfSync(userID.(uint), SelfSyncEvent{Action: SelfSyncRead, ChatroomID: chatroomID.Hex(), ReadAll: true}, nil)
+}
+
+// MarkAllChatroomsAsRead marks every message in every chatroom as read for the authenticated user
+// @Summary Mark all chatrooms as read
+// @Description Mark all unread messages across all of the authenticated user's chatrooms as read
+// @Tags message-read-status
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} map[string]interface{} "All chatrooms marked as read successfully"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 500 {object} utils.APIError "Internal server error"
+// @Router /messages/mark-all-read [post]
+func (c *MessageReadStatusController) MarkAllChatroomsAsRead(ctx *gin.Context) {
+	// Get user ID from context (set by auth middleware)
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		respondErrorMessage(ctx, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	chatroomIDs, err := c.ReadStatusService.MarkAllChatroomsAsRead(userID.(uint))
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":         "All chatrooms marked as read successfully",
+		"chatrooms_count": len(chatroomIDs),
+	})
+
+	// Handle WebSocket notifications asynchronously (non-blocking)
 	go func() {
-		// Add a small delay to prevent rapid-fire WebSocket events
-		time.Sleep(100 * time.Millisecond)
-
-		// Send a single bulk read status update instead of individual messages
-		BroadcastMessageReadGlobal(chatroomID.Hex(), map[string]any{
-			"type":        "bulk_read",
-			"chatroom_id": chatroomID.Hex(),
-			"user_id":     userID.(uint),
-			"read_all":    true,
-		})
+		for _, chatroomID := range chatroomIDs {
+			c.broadcastReadToMembers(chatroomID, map[string]any{
+				"type":        "bulk_read",
+				"chatroom_id": chatroomID.Hex(),
+				"user_id":     userID.(uint),
+				"read_all":    true,
+			})
+			c.hub().BroadcastSelfSync(userID.(uint), SelfSyncEvent{Action: SelfSyncRead, ChatroomID: chatroomID.Hex(), ReadAll: true}, nil)
+		}
 
-		// Update unread counts for current user only (more efficient)
+		// Push the refreshed (now zero) unread counts to the user's devices
 		unreadCounts, err := c.ReadStatusService.GetUnreadCountForUser(userID.(uint))
 		if err == nil {
-			BroadcastUnreadCountUpdateGlobal(userID.(uint), unreadCounts)
+			c.hub().BroadcastUnreadCountUpdate(userID.(uint), unreadCounts)
 		}
 	}()
 }
@@ -402,42 +714,102 @@ func (c *MessageReadStatusController) MarkAllMessagesInChatroomAsRead(ctx *gin.C
 // @Security ApiKeyAuth
 // @Param id path string true "Chatroom ID"
 // @Success 200 {object} models.MessageResponse "First unread message"
-// @Failure 400 {object} map[string]string "Invalid chatroom ID"
-// @Failure 401 {object} map[string]string "User not authenticated"
-// @Failure 404 {object} map[string]string "No unread messages found"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Failure 400 {object} utils.APIError "Invalid chatroom ID"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 403 {object} utils.APIError "User is not a member of this chatroom"
+// @Failure 404 {object} utils.APIError "No unread messages found"
+// @Failure 500 {object} utils.APIError "Internal server error"
 // @Router /chatrooms/{id}/first-unread [get]
 func (c *MessageReadStatusController) GetFirstUnreadMessageInChatroom(ctx *gin.Context) {
 	// Get chatroom ID from URL parameter
 	chatroomIDStr := ctx.Param("id")
 	chatroomID, err := primitive.ObjectIDFromHex(chatroomIDStr)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chatroom ID"})
+		respondErrorMessage(ctx, http.StatusBadRequest, "Invalid chatroom ID")
 		return
 	}
 
 	// Get user ID from context (set by auth middleware)
 	userID, exists := ctx.Get("user_id")
 	if !exists {
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		respondErrorMessage(ctx, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	if err := c.requireMember(chatroomID, userID.(uint)); err != nil {
+		respondError(ctx, err)
 		return
 	}
 
 	// Get first unread message
 	message, err := c.ReadStatusService.GetFirstUnreadMessageInChatroom(chatroomID, userID.(uint))
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": utils.FormatServiceError(err)})
+		respondError(ctx, err)
 		return
 	}
 
 	if message == nil {
-		ctx.JSON(http.StatusNotFound, gin.H{"error": "No unread messages found"})
+		respondErrorMessage(ctx, http.StatusNotFound, "No unread messages found")
 		return
 	}
 
 	ctx.JSON(http.StatusOK, message.ToResponse())
 }
 
+// GetFirstUnreadWithContext gets the first unread message with the messages just before it
+// @Summary Jump to first unread message
+// @Description Get the authenticated user's first unread message in a chatroom, preceded by up to `before` earlier messages, plus the unread count.
+// @Description Messages are oldest first and first_unread_id marks where the unread divider goes. With nothing unread, the latest messages are returned and first_unread_id is omitted
+// @Tags message-read-status
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Chatroom ID"
+// @Param before query int false "Messages of context before the first unread one" default(10) minimum(0) maximum(50)
+// @Success 200 {object} services.UnreadContextResponse "First unread message with context"
+// @Failure 400 {object} utils.APIError "Invalid chatroom ID or before parameter"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 403 {object} utils.APIError "User is not a member of the chatroom"
+// @Failure 404 {object} utils.APIError "Chatroom not found"
+// @Failure 500 {object} utils.APIError "Internal server error"
+// @Router /chatrooms/{id}/first-unread/context [get]
+func (c *MessageReadStatusController) GetFirstUnreadWithContext(ctx *gin.Context) {
+	chatroomID, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		respondErrorMessage(ctx, http.StatusBadRequest, "Invalid chatroom ID")
+		return
+	}
+
+	before := services.DefaultUnreadContextSize
+	if beforeParam, ok := ctx.GetQuery("before"); ok {
+		parsed, err := strconv.Atoi(beforeParam)
+		if err != nil || parsed < 0 {
+			respondErrorMessage(ctx, http.StatusBadRequest, "Before must be zero or a positive number")
+			return
+		}
+		before = min(parsed, services.MaxUnreadContextSize)
+	}
+
+	// Get user ID from context (set by auth middleware)
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		respondErrorMessage(ctx, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	if err := c.requireMember(chatroomID, userID.(uint)); err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	result, err := c.ReadStatusService.GetFirstUnreadWithContext(chatroomID, userID.(uint), before)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, result)
+}
+
 // GetUnreadCountForChatroom gets unread message count for a specific chatroom
 // @Summary Get unread count for specific chatroom
 // @Description Get unread message count for the authenticated user in a specific chatroom
@@ -446,30 +818,36 @@ func (c *MessageReadStatusController) GetFirstUnreadMessageInChatroom(ctx *gin.C
 // @Security ApiKeyAuth
 // @Param id path string true "Chatroom ID"
 // @Success 200 {object} map[string]int64 "Unread message count"
-// @Failure 400 {object} map[string]string "Invalid chatroom ID"
-// @Failure 401 {object} map[string]string "User not authenticated"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Failure 400 {object} utils.APIError "Invalid chatroom ID"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 403 {object} utils.APIError "User is not a member of this chatroom"
+// @Failure 500 {object} utils.APIError "Internal server error"
 // @Router /chatrooms/{id}/unread-count [get]
 func (c *MessageReadStatusController) GetUnreadCountForChatroom(ctx *gin.Context) {
 	// Get chatroom ID from URL parameter
 	chatroomIDStr := ctx.Param("id")
 	chatroomID, err := primitive.ObjectIDFromHex(chatroomIDStr)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chatroom ID"})
+		respondErrorMessage(ctx, http.StatusBadRequest, "Invalid chatroom ID")
 		return
 	}
 
 	// Get user ID from context (set by auth middleware)
 	userID, exists := ctx.Get("user_id")
 	if !exists {
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		respondErrorMessage(ctx, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	if err := c.requireMember(chatroomID, userID.(uint)); err != nil {
+		respondError(ctx, err)
 		return
 	}
 
 	// Get unread count for the chatroom
 	count, err := c.ReadStatusService.GetUnreadCountForChatroom(chatroomID, userID.(uint))
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": utils.FormatServiceError(err)})
+		respondError(ctx, err)
 		return
 	}
 
@@ -483,35 +861,41 @@ func (c *MessageReadStatusController) GetUnreadCountForChatroom(ctx *gin.Context
 // @Produce json
 // @Security ApiKeyAuth
 // @Param message_id path string true "Message ID"
+// @Param read_at query string false "When the message was actually read (RFC3339), for reads synced after being offline. Must not be in the future or before the message was sent"
 // @Success 200 {object} map[string]interface{} "success"
-// @Failure 400 {object} map[string]string "Invalid message ID"
-// @Failure 401 {object} map[string]string "User not authenticated"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Failure 400 {object} utils.APIError "Invalid message ID or read time"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 500 {object} utils.APIError "Internal server error"
 // @Router /messages/{message_id}/mark-read [post]
 func (c *MessageReadStatusController) MarkSingleMessageAsRead(ctx *gin.Context) {
 	// Get message ID from URL parameter
 	messageIDStr := ctx.Param("message_id")
 	messageID, err := primitive.ObjectIDFromHex(messageIDStr)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		respondErrorMessage(ctx, http.StatusBadRequest, "Invalid message ID")
 		return
 	}
 
 	// Get user ID from context (set by auth middleware)
 	userID, exists := ctx.Get("user_id")
 	if !exists {
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		respondErrorMessage(ctx, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	readAt, ok := readAtQuery(ctx)
+	if !ok {
 		return
 	}
 
 	// Mark the message as read using the optimized method
-	chatroomID, err := c.ReadStatusService.MarkMessageAsReadOptimized(messageID, userID.(uint))
+	chatroomID, err := c.ReadStatusService.MarkMessageAsReadAt(messageID, userID.(uint), readAt)
 	if err != nil {
 		if err.Error() == "read status not found" {
-			ctx.JSON(http.StatusNotFound, gin.H{"error": "Message not found or already read"})
+			respondError(ctx, utils.ServiceAPIError(err).WithMessage("Message not found or already read"))
 			return
 		}
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": utils.FormatServiceError(err)})
+		respondError(ctx, err)
 		return
 	}
 
@@ -523,22 +907,141 @@ func (c *MessageReadStatusController) MarkSingleMessageAsRead(ctx *gin.Context)
 	})
 
 	// Handle WebSocket notifications asynchronously (non-blocking)
-	go func() {
-		// Get updated read status and broadcast
-		readStatus, err := c.ReadStatusService.GetMessageReadStatus(messageID)
-		if err == nil {
-			// Broadcast read status update with user_id for filtering
-			BroadcastMessageReadGlobal(chatroomID.Hex(), map[string]any{
-				"message_id":  messageID.Hex(),
-				"read_status": readStatus,
-				"user_id":     userID.(uint),
-			})
-		}
+	go c.broadcastMessageRead(chatroomID, messageID, userID.(uint))
+	c.syncReadToDevices(userID.(uint), chatroomID, messageID)
+}
 
-		// Update unread counts for current user only (more efficient)
-		unreadCounts, err := c.ReadStatusService.GetUnreadCountForUser(userID.(uint))
-		if err == nil {
-			BroadcastUnreadCountUpdateGlobal(userID.(uint), unreadCounts)
-		}
-	}()
+// readAtQuery parses the optional read_at query parameter, writing a 400 and returning false if it's malformed
+func readAtQuery(ctx *gin.Context) (*time.Time, bool) {
+	value := ctx.Query("read_at")
+	if value == "" {
+		return nil, true
+	}
+	readAt, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		respondErrorMessage(ctx, http.StatusBadRequest, "Invalid 'read_at' timestamp format. Use ISO 8601 format.")
+		return nil, false
+	}
+	return &readAt, true
+}
+
+// broadcastMessageRead sends the message's updated read status to the room and the reader's new unread counts
+func (c *MessageReadStatusController) broadcastMessageRead(chatroomID, messageID primitive.ObjectID, userID uint) {
+	// Get updated read status and broadcast (not at all in rooms with read receipts off)
+	readStatus, err := c.ReadStatusService.GetMessageReadStatus(messageID)
+	if err == nil {
+		// Broadcast read status update with user_id for filtering
+		c.broadcastReadToMembers(chatroomID, map[string]any{
+			"message_id":  messageID.Hex(),
+			"read_status": readStatus,
+			"user_id":     userID,
+		})
+	}
+
+	// Update unread counts for current user only (more efficient)
+	unreadCounts, err := c.ReadStatusService.GetUnreadCountForUser(userID)
+	if err == nil {
+		c.hub().BroadcastUnreadCountUpdate(userID, unreadCounts)
+	}
+}
+
+// broadcastChatroomRead sends a single bulk_read event for the room and the reader's new unread counts
+func (c *MessageReadStatusController) broadcastChatroomRead(chatroomID primitive.ObjectID, userID uint) {
+	// Add a small delay to prevent rapid-fire WebSocket events
+	time.Sleep(100 * time.Millisecond)
+
+	// Send a single bulk read status update instead of individual messages
+	c.broadcastReadToMembers(chatroomID, map[string]any{
+		"type":        "bulk_read",
+		"chatroom_id": chatroomID.Hex(),
+		"user_id":     userID,
+		"read_all":    true,
+	})
+
+	// Update unread counts for current user only (more efficient)
+	unreadCounts, err := c.ReadStatusService.GetUnreadCountForUser(userID)
+	if err == nil {
+		c.hub().BroadcastUnreadCountUpdate(userID, unreadCounts)
+	}
+}
+
+// broadcastReadToMembers sends a message_read event to the chatroom's members, unless read receipts are off there
+func (c *MessageReadStatusController) broadcastReadToMembers(chatroomID primitive.ObjectID, readData map[string]any) {
+	chatroom, err := c.ReadStatusService.ChatroomService.GetChatroomByID(chatroomID)
+	if err != nil || !chatroom.ReadReceiptsEnabled() {
+		return
+	}
+	c.hub().BroadcastMessageRead(chatroomID.Hex(), readData, memberIDs(chatroom))
+}
+
+// syncReadToDevices tells the reader's other devices a message was read over the REST API
+func (c *MessageReadStatusController) syncReadToDevices(userID uint, chatroomID, messageID primitive.ObjectID) {
+	c.hub().BroadcastSelfSync(userID, SelfSyncEvent{
+		Action:     SelfSyncRead,
+		ChatroomID: chatroomID.Hex(),
+		MessageIDs: []string{messageID.Hex()},
+	}, nil)
+}
+
+// requireMember returns an error unless the user is a member of the chatroom
+func (c *MessageReadStatusController) requireMember(chatroomID primitive.ObjectID, userID uint) error {
+	membership, err := c.ReadStatusService.ChatroomService.GetMembership(chatroomID, userID)
+	if err != nil {
+		return err
+	}
+	if !membership.IsMember {
+		return errors.New("user is not a member of this chatroom")
+	}
+	return nil
+}
+
+// MarkSocketMessageRead marks a message read for a mark_read event received over the socket.
+// Only the user's own read status is updated, so a message they didn't receive comes back as not found.
+func (c *MessageReadStatusController) MarkSocketMessageRead(chatroomID, messageID primitive.ObjectID, userID uint) error {
+	if err := c.requireMember(chatroomID, userID); err != nil {
+		return err
+	}
+
+	messageChatroomID, err := c.ReadStatusService.MarkMessageAsReadOptimized(messageID, userID)
+	if err != nil {
+		return err
+	}
+
+	go c.broadcastMessageRead(messageChatroomID, messageID, userID)
+	return nil
+}
+
+// MarkSocketChatroomRead marks everything in a chatroom read for a chatroom-wide mark_read event
+func (c *MessageReadStatusController) MarkSocketChatroomRead(chatroomID primitive.ObjectID, userID uint) error {
+	if err := c.requireMember(chatroomID, userID); err != nil {
+		return err
+	}
+
+	if err := c.ReadStatusService.MarkAllMessagesInChatroomAsRead(chatroomID, userID); err != nil {
+		return err
+	}
+
+	go c.broadcastChatroomRead(chatroomID, userID)
+	return nil
+}
+
+// ReconcileReadStatus prunes orphaned read-status and last-read records on demand
+// @Summary Prune orphaned read statuses
+// @Description Remove read-status rows whose message or chatroom no longer exists, and last-read records for deleted chatrooms. The same job also runs periodically in the background. Admins only
+// @Tags message-read-status
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} services.ReconcileResult "Number of records pruned"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 403 {object} utils.APIError "User is not an administrator"
+// @Failure 500 {object} utils.APIError "Internal server error"
+// @Router /admin/read-status/reconcile [post]
+func (c *MessageReadStatusController) ReconcileReadStatus(ctx *gin.Context) {
+	result, err := c.ReadStatusService.ReconcileOrphanedRecords()
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, result)
 }
@@ -0,0 +1,539 @@
+package controllers_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ginchat/config"
+	"github.com/ginchat/models"
+	"github.com/ginchat/services"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestGetMessageReadByWhoPaging(t *testing.T) {
+	for _, pointerTracking := range []bool{false, true} {
+		t.Run(fmt.Sprintf("pointer_tracking=%v", pointerTracking), func(t *testing.T) {
+			env := newAPIEnv(t, func(cfg *config.Config) { cfg.ReadPointerTracking = pointerTracking })
+			owner := env.user(t, "owner")
+			var members []*apiUser
+			for i := 1; i <= 5; i++ {
+				members = append(members, env.user(t, fmt.Sprintf("member%d", i)))
+			}
+			roomID := env.createRoom(t, owner, "Paging", members...)
+			messageID := env.send(t, owner, roomID, "hello")
+			for _, member := range members[:3] {
+				expect(t, env.do(t, member, http.MethodPost, "/api/messages/"+messageID+"/mark-read", nil), http.StatusOK, nil)
+			}
+			path := "/api/messages/" + messageID + "/read-by-who"
+
+			// Without pagination params the full list comes back as a bare array
+			var all []models.MessageReadStatusResponse
+			expect(t, env.do(t, owner, http.MethodGet, path, nil), http.StatusOK, &all)
+			if len(all) != len(members) {
+				t.Fatalf("full list has %d statuses, want %d", len(all), len(members))
+			}
+
+			type page struct {
+				ReadStatuses []models.MessageReadStatusResponse `json:"read_statuses"`
+				Total        int64                              `json:"total"`
+				Limit        int                                `json:"limit"`
+				Offset       int                                `json:"offset"`
+				HasMore      bool                               `json:"has_more"`
+			}
+			seen := map[uint]bool{}
+			for offset, wantLen, wantMore := 0, 2, true; offset < len(members); offset += 2 {
+				if offset == 4 {
+					wantLen, wantMore = 1, false
+				}
+				var got page
+				expect(t, env.do(t, owner, http.MethodGet, fmt.Sprintf("%s?limit=2&offset=%d", path, offset), nil), http.StatusOK, &got)
+				if got.Total != int64(len(members)) || got.Limit != 2 || got.Offset != offset {
+					t.Errorf("offset %d: total %d, limit %d, offset %d; want %d, 2, %d", offset, got.Total, got.Limit, got.Offset, len(members), offset)
+				}
+				if len(got.ReadStatuses) != wantLen || got.HasMore != wantMore {
+					t.Errorf("offset %d: %d statuses, has_more %v; want %d, %v", offset, len(got.ReadStatuses), got.HasMore, wantLen, wantMore)
+				}
+				for _, status := range got.ReadStatuses {
+					if seen[status.RecipientID] {
+						t.Errorf("recipient %d appears on more than one page", status.RecipientID)
+					}
+					seen[status.RecipientID] = true
+				}
+			}
+			if len(seen) != len(members) {
+				t.Errorf("pages covered %d recipients, want %d", len(seen), len(members))
+			}
+
+			// Past the end is an empty page that still reports the total
+			var past page
+			expect(t, env.do(t, owner, http.MethodGet, path+"?offset=10", nil), http.StatusOK, &past)
+			if len(past.ReadStatuses) != 0 || past.Total != int64(len(members)) || past.Limit != 50 {
+				t.Errorf("past the end: %d statuses, total %d, limit %d", len(past.ReadStatuses), past.Total, past.Limit)
+			}
+
+			for _, query := range []string{"?limit=0", "?limit=abc", "?offset=-1"} {
+				expect(t, env.do(t, owner, http.MethodGet, path+query, nil), http.StatusBadRequest, nil)
+			}
+		})
+	}
+}
+
+func TestMarkAllChatroomsAsRead(t *testing.T) {
+	for _, pointerTracking := range []bool{false, true} {
+		t.Run(fmt.Sprintf("pointer_tracking=%v", pointerTracking), func(t *testing.T) {
+			env := newAPIEnv(t, func(cfg *config.Config) { cfg.ReadPointerTracking = pointerTracking })
+			alice, bob := env.user(t, "alice"), env.user(t, "bob")
+			var rooms []string
+			for i := 1; i <= 3; i++ {
+				roomID := env.createRoom(t, alice, fmt.Sprintf("Room %d", i), bob)
+				for j := 0; j < i; j++ {
+					env.send(t, alice, roomID, "unread")
+				}
+				rooms = append(rooms, roomID)
+			}
+			env.send(t, bob, rooms[0], "alice hasn't read this")
+
+			unread := func(user *apiUser) map[string]int64 {
+				var counts []models.ChatroomUnreadCount
+				expect(t, env.do(t, user, http.MethodGet, "/api/messages/unread-counts", nil), http.StatusOK, &counts)
+				byRoom := map[string]int64{}
+				for _, count := range counts {
+					byRoom[count.ChatroomID] = count.UnreadCount
+				}
+				return byRoom
+			}
+			if before := unread(bob); before[rooms[0]] != 1 || before[rooms[1]] != 2 || before[rooms[2]] != 3 {
+				t.Fatalf("unread before = %v, want 1, 2 and 3", before)
+			}
+
+			sidebar := env.dial(t, bob, "global_sidebar")
+			expect(t, env.do(t, bob, http.MethodPost, "/api/messages/mark-all-read", nil), http.StatusOK, nil)
+
+			for roomID, count := range unread(bob) {
+				if count != 0 {
+					t.Errorf("room %s still has %d unread", roomID, count)
+				}
+			}
+			if after := unread(alice); after[rooms[0]] != 1 {
+				t.Errorf("alice's unread in room 1 = %d, want 1 (only bob read everything)", after[rooms[0]])
+			}
+
+			// The zeroed counts are pushed to bob's devices
+			updates := sidebar.collect(500 * time.Millisecond)["unread_count_update"]
+			if len(updates) == 0 {
+				t.Fatal("no unread_count_update after marking everything read")
+			}
+			var pushed []models.ChatroomUnreadCount
+			if err := json.Unmarshal(updates[len(updates)-1].Data, &pushed); err != nil {
+				t.Fatalf("decode %s: %v", updates[len(updates)-1].Data, err)
+			}
+			if len(pushed) != len(rooms) {
+				t.Errorf("pushed counts for %d rooms, want %d", len(pushed), len(rooms))
+			}
+			for _, count := range pushed {
+				if count.UnreadCount != 0 {
+					t.Errorf("pushed %d unread for %s, want 0", count.UnreadCount, count.ChatroomName)
+				}
+			}
+		})
+	}
+}
+
+func TestMessageReadReachesOnlyMembers(t *testing.T) {
+	env := newAPIEnv(t)
+	alice, bob, mallory := env.user(t, "alice"), env.user(t, "bob"), env.user(t, "mallory")
+	roomID := env.createRoom(t, alice, "General", bob)
+	env.createRoom(t, mallory, "Mallory's")
+	first := env.send(t, alice, roomID, "one")
+
+	sender := env.dial(t, alice, "global_sidebar")
+	reader := env.dial(t, bob, roomID)
+	outsider := env.dial(t, mallory, "global_sidebar")
+
+	for _, data := range []map[string]any{{"message_id": first}, {"all": true}} {
+		if err := reader.WriteJSON(map[string]any{"type": "mark_read", "chatroom_id": roomID, "data": data}); err != nil {
+			t.Fatalf("write mark_read: %v", err)
+		}
+		reader.await(t, "mark_read_ack")
+		sender.await(t, "message_read")
+	}
+	if events := outsider.collect(300 * time.Millisecond); len(events["message_read"]) != 0 {
+		t.Errorf("a non-member got %d message_read events", len(events["message_read"]))
+	}
+}
+
+func TestMarkReadOverWebSocket(t *testing.T) {
+	env := newAPIEnv(t)
+	alice, bob := env.user(t, "alice"), env.user(t, "bob")
+	roomID := env.createRoom(t, alice, "General", bob)
+	otherRoomID := env.createRoom(t, alice, "Elsewhere")
+	first := env.send(t, alice, roomID, "one")
+	env.send(t, alice, roomID, "two")
+	env.send(t, alice, roomID, "three")
+	elsewhere := env.send(t, alice, otherRoomID, "not for bob")
+
+	sender := env.dial(t, alice, roomID)
+	reader := env.dial(t, bob, roomID)
+	unread := func(t *testing.T) int {
+		t.Helper()
+		var body struct {
+			UnreadCount int `json:"unread_count"`
+		}
+		expect(t, env.do(t, bob, http.MethodGet, "/api/chatrooms/"+roomID+"/unread-count", nil), http.StatusOK, &body)
+		return body.UnreadCount
+	}
+	markRead := func(t *testing.T, chatroomID string, data map[string]any) {
+		t.Helper()
+		if err := reader.WriteJSON(map[string]any{"type": "mark_read", "chatroom_id": chatroomID, "data": data}); err != nil {
+			t.Fatalf("write mark_read: %v", err)
+		}
+	}
+
+	t.Run("single message", func(t *testing.T) {
+		markRead(t, roomID, map[string]any{"message_id": first})
+		reader.await(t, "mark_read_ack")
+
+		var read struct {
+			MessageID string `json:"message_id"`
+			UserID    uint   `json:"user_id"`
+		}
+		if err := json.Unmarshal(sender.await(t, "message_read").Data, &read); err != nil || read.MessageID != first || read.UserID != bob.ID {
+			t.Errorf("message_read = %+v (%v), want bob reading the first message", read, err)
+		}
+		if got := unread(t); got != 2 {
+			t.Errorf("unread after one mark_read = %d, want 2", got)
+		}
+	})
+
+	t.Run("whole chatroom", func(t *testing.T) {
+		markRead(t, roomID, map[string]any{"all": true})
+		reader.await(t, "mark_read_ack")
+
+		var read struct {
+			Type    string `json:"type"`
+			UserID  uint   `json:"user_id"`
+			ReadAll bool   `json:"read_all"`
+		}
+		if err := json.Unmarshal(sender.await(t, "message_read").Data, &read); err != nil || read.Type != "bulk_read" || !read.ReadAll || read.UserID != bob.ID {
+			t.Errorf("message_read = %+v (%v), want bob's bulk read", read, err)
+		}
+		if got := unread(t); got != 0 {
+			t.Errorf("unread after marking the room read = %d, want 0", got)
+		}
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		for _, tt := range []struct {
+			chatroomID string
+			data       map[string]any
+		}{
+			{roomID, map[string]any{"message_id": "not-an-id"}},
+			{roomID, map[string]any{"message_id": elsewhere}}, // bob is not a recipient of a message in a room they aren't in
+			{otherRoomID, map[string]any{"all": true}},        // nor a member of that room
+			{otherRoomID, map[string]any{"message_id": elsewhere}},
+		} {
+			markRead(t, tt.chatroomID, tt.data)
+			reader.await(t, "mark_read_nack")
+		}
+	})
+}
+
+func TestMarkMultipleMessagesAsReadReportsEachID(t *testing.T) {
+	env := newAPIEnv(t)
+	alice, bob := env.user(t, "alice"), env.user(t, "bob")
+	roomID := env.createRoom(t, alice, "General", bob)
+	read := env.send(t, alice, roomID, "one")
+	unread := env.send(t, alice, roomID, "two")
+	own := env.send(t, bob, roomID, "three")
+	expect(t, env.do(t, bob, http.MethodPost, "/api/messages/"+read+"/mark-read", nil), http.StatusOK, nil)
+
+	var body struct {
+		SuccessCount   int      `json:"success_count"`
+		ErrorCount     int      `json:"error_count"`
+		Total          int      `json:"total"`
+		InvalidIDs     []string `json:"invalid_ids"`
+		AlreadyReadIDs []string `json:"already_read_ids"`
+		NotFoundIDs    []string `json:"not_found_ids"`
+		Errors         []string `json:"errors"`
+	}
+	expect(t, env.do(t, bob, http.MethodPost, "/api/messages/read-multiple", []string{unread, "nope", read, own}), http.StatusOK, &body)
+	if body.SuccessCount != 1 || body.ErrorCount != 3 || body.Total != 4 {
+		t.Errorf("counts = %d ok, %d failed of %d; want 1, 3 of 4", body.SuccessCount, body.ErrorCount, body.Total)
+	}
+	if fmt.Sprint(body.InvalidIDs, body.AlreadyReadIDs, body.NotFoundIDs) != fmt.Sprint([]string{"nope"}, []string{read}, []string{own}) {
+		t.Errorf("invalid %v, already read %v, not found %v; want [nope], [%s], [%s]", body.InvalidIDs, body.AlreadyReadIDs, body.NotFoundIDs, read, own)
+	}
+	if len(body.Errors) != 3 {
+		t.Errorf("errors = %v, want one per failed ID", body.Errors)
+	}
+}
+
+func TestReconcileReadStatusEndpoint(t *testing.T) {
+	env := newAPIEnv(t)
+	alice, root := env.user(t, "alice"), env.admin(t, "root")
+	orphan := models.MessageReadStatus{ID: primitive.NewObjectID(), MessageID: primitive.NewObjectID(), ChatroomID: primitive.NewObjectID(), SenderID: root.ID, RecipientID: alice.ID}
+	if _, err := env.Mongo.Collection("message_read_status").InsertOne(context.Background(), orphan); err != nil {
+		t.Fatalf("insert orphan: %v", err)
+	}
+
+	expect(t, env.do(t, alice, http.MethodPost, "/api/admin/read-status/reconcile", nil), http.StatusForbidden, nil)
+	if left := len(env.MongoDB.Documents("message_read_status")); left != 1 {
+		t.Fatalf("%d read statuses left after a rejected run, want 1", left)
+	}
+
+	var result struct {
+		ReadStatusesPruned int64 `json:"read_statuses_pruned"`
+		LastReadsPruned    int64 `json:"last_reads_pruned"`
+	}
+	expect(t, env.do(t, root, http.MethodPost, "/api/admin/read-status/reconcile", nil), http.StatusOK, &result)
+	if result.ReadStatusesPruned != 1 || result.LastReadsPruned != 0 {
+		t.Errorf("result = %+v, want the one orphan pruned", result)
+	}
+	if left := len(env.MongoDB.Documents("message_read_status")); left != 0 {
+		t.Errorf("%d read statuses left, want 0", left)
+	}
+}
+
+func TestFilteredUnreadCountsEndpoint(t *testing.T) {
+	env := newAPIEnv(t)
+	alice, bob := env.user(t, "alice"), env.user(t, "bob")
+	joined := env.createRoom(t, alice, "General", bob)
+	notJoined := env.createRoom(t, alice, "Alice only")
+	env.send(t, alice, joined, "hi")
+	env.send(t, alice, notJoined, "hi")
+	const path = "/api/messages/unread-counts/filter"
+
+	var counts []models.ChatroomUnreadCount
+	expect(t, env.do(t, bob, http.MethodPost, path, []string{joined, notJoined, primitive.NewObjectID().Hex()}), http.StatusOK, &counts)
+	if len(counts) != 1 || counts[0].ChatroomID != joined || counts[0].UnreadCount != 1 {
+		t.Errorf("counts = %+v, want only the joined room, with 1 unread", counts)
+	}
+	expect(t, env.do(t, bob, http.MethodPost, path, []string{}), http.StatusOK, &counts)
+	if len(counts) != 0 {
+		t.Errorf("counts for no rooms = %+v", counts)
+	}
+
+	tooMany := make([]string, services.MaxUnreadCountFilter+1)
+	for i := range tooMany {
+		tooMany[i] = primitive.NewObjectID().Hex()
+	}
+	for _, body := range []any{[]string{joined, "nope"}, tooMany, map[string]string{"id": joined}} {
+		expect(t, env.do(t, bob, http.MethodPost, path, body), http.StatusBadRequest, nil)
+	}
+}
+
+func TestReadReceiptsOff(t *testing.T) {
+	for _, pointerTracking := range []bool{false, true} {
+		t.Run(fmt.Sprintf("pointer_tracking=%v", pointerTracking), func(t *testing.T) {
+			env := newAPIEnv(t, func(cfg *config.Config) { cfg.ReadPointerTracking = pointerTracking })
+			alice, bob, carol := env.user(t, "alice"), env.user(t, "bob"), env.user(t, "carol")
+			roomID := env.createRoom(t, alice, "Private", bob, carol)
+			receipts := func(user *apiUser, enabled bool, status int) {
+				t.Helper()
+				expect(t, env.do(t, user, http.MethodPut, "/api/chatrooms/"+roomID, map[string]bool{"read_receipts_enabled": enabled}), status, nil)
+			}
+			receipts(bob, false, http.StatusForbidden) // Only the creator decides
+			receipts(alice, false, http.StatusOK)
+
+			messageID := env.send(t, alice, roomID, "did you see this?")
+			sender := env.dial(t, alice, roomID)
+			expect(t, env.do(t, bob, http.MethodPost, "/api/messages/"+messageID+"/mark-read", nil), http.StatusOK, nil)
+			if events := sender.collect(300 * time.Millisecond); len(events["message_read"]) != 0 {
+				t.Errorf("got %d message_read events with read receipts off", len(events["message_read"]))
+			}
+
+			// Nobody can tell who read it
+			var readStatus []models.ReadInfo
+			expect(t, env.do(t, alice, http.MethodGet, "/api/messages/"+messageID+"/read-status", nil), http.StatusOK, &readStatus)
+			var readByWho []models.MessageReadStatusResponse
+			expect(t, env.do(t, alice, http.MethodGet, "/api/messages/"+messageID+"/read-by-who", nil), http.StatusOK, &readByWho)
+			var unreadBy []models.ReadInfo
+			expect(t, env.do(t, alice, http.MethodGet, "/api/messages/"+messageID+"/unread-by", nil), http.StatusOK, &unreadBy)
+			var messages struct {
+				Messages []models.MessageResponse `json:"messages"`
+			}
+			expect(t, env.do(t, alice, http.MethodGet, "/api/chatrooms/"+roomID+"/messages", nil), http.StatusOK, &messages)
+			if len(readStatus) != 0 || len(readByWho) != 0 || len(unreadBy) != 0 {
+				t.Errorf("read details leaked: read-status %v, read-by-who %v, unread-by %v", readStatus, readByWho, unreadBy)
+			}
+			for _, message := range messages.Messages {
+				if len(message.ReadStatus) != 0 {
+					t.Errorf("message %q lists read status %v", message.TextContent, message.ReadStatus)
+				}
+			}
+
+			// Unread counts are still tracked
+			unread := func(user *apiUser) int64 {
+				t.Helper()
+				var count struct {
+					UnreadCount int64 `json:"unread_count"`
+				}
+				expect(t, env.do(t, user, http.MethodGet, "/api/chatrooms/"+roomID+"/unread-count", nil), http.StatusOK, &count)
+				return count.UnreadCount
+			}
+			if bobUnread, carolUnread := unread(bob), unread(carol); bobUnread != 0 || carolUnread != 1 {
+				t.Errorf("unread: bob %d, carol %d; want 0 and 1", bobUnread, carolUnread)
+			}
+
+			// Turning receipts back on shows the reads that happened meanwhile
+			receipts(alice, true, http.StatusOK)
+			expect(t, env.do(t, alice, http.MethodGet, "/api/messages/"+messageID+"/read-status", nil), http.StatusOK, &readStatus)
+			read := map[string]bool{}
+			for _, info := range readStatus {
+				read[info.Username] = info.IsRead
+			}
+			if len(read) != 2 || !read["bob"] || read["carol"] {
+				t.Errorf("read status = %v, want bob read and carol not", read)
+			}
+			expect(t, env.do(t, carol, http.MethodPost, "/api/messages/"+messageID+"/mark-read", nil), http.StatusOK, nil)
+			if events := sender.collect(300 * time.Millisecond); len(events["message_read"]) == 0 {
+				t.Error("no message_read event with read receipts back on")
+			}
+		})
+	}
+}
+
+func TestFirstUnreadWithContext(t *testing.T) {
+	for _, pointerTracking := range []bool{false, true} {
+		t.Run(fmt.Sprintf("pointer_tracking=%v", pointerTracking), func(t *testing.T) {
+			env := newAPIEnv(t, func(cfg *config.Config) { cfg.ReadPointerTracking = pointerTracking })
+			alice, bob, mallory := env.user(t, "alice"), env.user(t, "bob"), env.user(t, "mallory")
+			roomID := env.createRoom(t, alice, "General", bob)
+			for i := range 4 {
+				env.send(t, alice, roomID, fmt.Sprintf("old %d", i))
+			}
+			expect(t, env.do(t, bob, http.MethodPost, "/api/chatrooms/"+roomID+"/mark-all-read", nil), http.StatusOK, nil)
+			firstUnread := env.send(t, alice, roomID, "new 0")
+			env.send(t, alice, roomID, "new 1")
+			env.send(t, alice, roomID, "new 2")
+
+			jump := func(user *apiUser, query string) (services.UnreadContextResponse, []string) {
+				t.Helper()
+				var got services.UnreadContextResponse
+				expect(t, env.do(t, user, http.MethodGet, "/api/chatrooms/"+roomID+"/first-unread/context"+query, nil), http.StatusOK, &got)
+				texts := make([]string, len(got.Messages))
+				for i, message := range got.Messages {
+					texts[i] = message.TextContent
+				}
+				return got, texts
+			}
+
+			t.Run("with unread", func(t *testing.T) {
+				got, texts := jump(bob, "?before=2")
+				if !slices.Equal(texts, []string{"old 2", "old 3", "new 0"}) || got.FirstUnreadID != firstUnread || got.UnreadCount != 3 || !got.HasMoreBefore {
+					t.Errorf("jump = %+v %v, want two messages of context then new 0, 3 unread, more before", got, texts)
+				}
+				if _, texts := jump(bob, "?before=0"); !slices.Equal(texts, []string{"new 0"}) {
+					t.Errorf("before=0 = %v, want only the first unread message", texts)
+				}
+				// The "bob joined" notice is the oldest message
+				if got, texts := jump(bob, ""); len(texts) != 6 || texts[4] != "old 3" || got.HasMoreBefore {
+					t.Errorf("default context = %v (more before %v), want the whole history", texts, got.HasMoreBefore)
+				}
+			})
+
+			t.Run("without unread", func(t *testing.T) {
+				got, texts := jump(alice, "?before=2")
+				if !slices.Equal(texts, []string{"new 1", "new 2"}) || got.FirstUnreadID != "" || got.UnreadCount != 0 || !got.HasMoreBefore {
+					t.Errorf("jump = %+v %v, want the latest two messages and no divider", got, texts)
+				}
+			})
+
+			expect(t, env.do(t, mallory, http.MethodGet, "/api/chatrooms/"+roomID+"/first-unread/context", nil), http.StatusForbidden, nil)
+			expect(t, env.do(t, bob, http.MethodGet, "/api/chatrooms/"+roomID+"/first-unread/context?before=-1", nil), http.StatusBadRequest, nil)
+		})
+	}
+}
+
+func TestGetUnreadCountsOverWebSocket(t *testing.T) {
+	env := newAPIEnv(t)
+	alice, bob := env.user(t, "alice"), env.user(t, "bob")
+	general := env.createRoom(t, alice, "General", bob)
+	random := env.createRoom(t, alice, "Random", bob)
+	env.send(t, alice, general, "one")
+	env.send(t, alice, general, "two")
+	env.send(t, alice, random, "three")
+
+	phone := env.dial(t, bob, "global_sidebar")
+	sender := env.dial(t, alice, general)
+	time.Sleep(1100 * time.Millisecond) // Each user may open one connection per second
+	web := env.dial(t, bob, random)
+	for _, socket := range []*apiSocket{phone, web, sender} {
+		socket.collect(200 * time.Millisecond) // Settle anything pushed while connecting
+	}
+	request := func() {
+		t.Helper()
+		if err := phone.WriteJSON(map[string]any{"type": "get_unread_counts"}); err != nil {
+			t.Fatalf("write get_unread_counts: %v", err)
+		}
+	}
+
+	request()
+	var counts []models.ChatroomUnreadCount
+	if err := json.Unmarshal(phone.await(t, "unread_count_update").Data, &counts); err != nil {
+		t.Fatalf("decode unread_count_update: %v", err)
+	}
+	byRoom := map[string]int64{}
+	for _, count := range counts {
+		byRoom[count.ChatroomID] = count.UnreadCount
+	}
+	if len(byRoom) != 2 || byRoom[general] != 2 || byRoom[random] != 1 {
+		t.Errorf("counts = %v, want 2 in General and 1 in Random", byRoom)
+	}
+	// Only the asking connection is answered, and only once
+	if events := phone.collect(200 * time.Millisecond); len(events["unread_count_update"]) != 0 {
+		t.Errorf("asking connection got %d more updates", len(events["unread_count_update"]))
+	}
+	for name, socket := range map[string]*apiSocket{"bob's other connection": web, "alice": sender} {
+		if events := socket.collect(100 * time.Millisecond); len(events["unread_count_update"]) != 0 {
+			t.Errorf("%s got the snapshot", name)
+		}
+	}
+
+	// Snapshots recompute every room, so only three may be asked for in a burst
+	for range 4 {
+		request()
+	}
+	events := phone.collect(300 * time.Millisecond)
+	if updates, nacks := len(events["unread_count_update"]), len(events["unread_counts_nack"]); updates != 2 || nacks != 2 {
+		t.Errorf("got %d updates and %d nacks for four more requests, want 2 and 2", updates, nacks)
+	}
+}
+
+func TestSingleRoomUnreadEndpointsRequireMembership(t *testing.T) {
+	for _, pointerTracking := range []bool{false, true} {
+		t.Run(fmt.Sprintf("pointer_tracking=%v", pointerTracking), func(t *testing.T) {
+			env := newAPIEnv(t, func(cfg *config.Config) { cfg.ReadPointerTracking = pointerTracking })
+			alice, bob, mallory := env.user(t, "alice"), env.user(t, "bob"), env.user(t, "mallory")
+			roomID := env.createRoom(t, alice, "General", bob)
+			env.send(t, alice, roomID, "hello")
+
+			var count struct {
+				UnreadCount int64 `json:"unread_count"`
+			}
+			expect(t, env.do(t, bob, http.MethodGet, "/api/chatrooms/"+roomID+"/unread-count", nil), http.StatusOK, &count)
+			if count.UnreadCount != 1 {
+				t.Errorf("bob's unread count = %d, want 1", count.UnreadCount)
+			}
+			var first models.MessageResponse
+			expect(t, env.do(t, bob, http.MethodGet, "/api/chatrooms/"+roomID+"/first-unread", nil), http.StatusOK, &first)
+			if first.TextContent != "hello" {
+				t.Errorf("bob's first unread = %q, want hello", first.TextContent)
+			}
+
+			// A non-member learns nothing about the room's history, whichever way reads are tracked
+			for _, path := range []string{"/unread-count", "/first-unread"} {
+				var got apiError
+				expect(t, env.do(t, mallory, http.MethodGet, "/api/chatrooms/"+roomID+path, nil), http.StatusForbidden, &got)
+				if got.Code != "NOT_A_MEMBER" {
+					t.Errorf("%s for a non-member: code %q, want NOT_A_MEMBER", path, got.Code)
+				}
+			}
+		})
+	}
+}
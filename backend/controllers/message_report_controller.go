@@ -0,0 +1,188 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ginchat/services"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MessageReportController handles message reports and their review by administrators
+type MessageReportController struct {
+	wsHub
+	ReportService   *services.MessageReportService
+	ChatroomService *services.ChatroomService
+}
+
+// NewMessageReportController creates a new MessageReportController
+func NewMessageReportController(mongodb *mongo.Database, chatroomService *services.ChatroomService) *MessageReportController {
+	return &MessageReportController{
+		ReportService:   services.NewMessageReportService(mongodb, chatroomService),
+		ChatroomService: chatroomService,
+	}
+}
+
+// ReportMessageRequest represents the request body for reporting a message
+type ReportMessageRequest struct {
+	Reason string `json:"reason" binding:"required" example:"Spam links"` // Why the message breaks the rules (max 500 characters)
+}
+
+// ResolveReportRequest represents the request body for closing a report
+type ResolveReportRequest struct {
+	Status string `json:"status" binding:"required" example:"resolved" enums:"resolved,dismissed"` // resolved when action was taken, dismissed otherwise
+}
+
+// ReportMessage handles reporting a message
+// @Summary Report a message
+// @Description Flag a message for administrator review. You must be a member of the message's chatroom, and you can't report your own or system messages.
+// @Description Each user can report a message once. The chatroom's admins and creator receive a message_reported WebSocket event
+// @Tags messages
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param message_id path string true "Message ID"
+// @Param request body ReportMessageRequest true "Report reason"
+// @Success 201 {object} models.MessageReport "Report filed"
+// @Failure 400 {object} utils.APIError "Invalid message ID, missing or too long reason, own or system message"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 403 {object} utils.APIError "User is not a member of the chatroom"
+// @Failure 404 {object} utils.APIError "Message not found"
+// @Failure 409 {object} utils.APIError "Message already reported by this user"
+// @Failure 500 {object} utils.APIError "Internal server error"
+// @Router /messages/{message_id}/report [post]
+func (rc *MessageReportController) ReportMessage(c *gin.Context) {
+	messageID, err := primitive.ObjectIDFromHex(c.Param("message_id"))
+	if err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, "Please provide a valid message ID")
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondErrorMessage(c, http.StatusUnauthorized, "Please log in to continue")
+		return
+	}
+	username := c.GetString("username")
+
+	var req ReportMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, "Please give a reason for the report")
+		return
+	}
+
+	report, chatroom, err := rc.ReportService.ReportMessage(messageID, userID.(uint), username, req.Reason)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	// Let the room's moderators know without waiting for an administrator to check the queue
+	rc.hub().NotifyUsers(rc.ChatroomService.GetAdminIDs(chatroom), "message_reported", chatroom.ID.Hex(), map[string]any{
+		"report_id":     report.ID.Hex(),
+		"message_id":    messageID.Hex(),
+		"chatroom_id":   chatroom.ID.Hex(),
+		"chatroom_name": chatroom.Name,
+		"reporter_id":   report.ReporterID,
+		"reason":        report.Reason,
+	})
+
+	c.JSON(http.StatusCreated, report)
+}
+
+// ListReports handles listing message reports for administrators
+// @Summary List message reports
+// @Description Get a page of message reports, newest first. Admins only
+// @Tags admin
+// @Produce json
+// @Security ApiKeyAuth
+// @Param status query string false "Only reports with this status" Enums(open, resolved, dismissed)
+// @Param limit query int false "Reports per page" default(20) minimum(1) maximum(100)
+// @Param offset query int false "Reports to skip" default(0)
+// @Success 200 {object} map[string]any "Reports with total, limit, offset and has_more"
+// @Failure 400 {object} utils.APIError "Invalid status or pagination parameters"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 403 {object} utils.APIError "User is not an administrator"
+// @Failure 500 {object} utils.APIError "Internal server error"
+// @Router /admin/reports [get]
+func (rc *MessageReportController) ListReports(c *gin.Context) {
+	limit := services.DefaultReportPageSize
+	if limitParam, ok := c.GetQuery("limit"); ok {
+		parsed, err := strconv.Atoi(limitParam)
+		if err != nil || parsed <= 0 {
+			respondErrorMessage(c, http.StatusBadRequest, "Limit must be a positive number")
+			return
+		}
+		limit = min(parsed, services.MaxReportPageSize)
+	}
+	offset := 0
+	if offsetParam, ok := c.GetQuery("offset"); ok {
+		parsed, err := strconv.Atoi(offsetParam)
+		if err != nil || parsed < 0 {
+			respondErrorMessage(c, http.StatusBadRequest, "Offset must be zero or a positive number")
+			return
+		}
+		offset = parsed
+	}
+
+	reports, total, err := rc.ReportService.ListReports(c.Query("status"), limit, offset)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"reports":  reports,
+		"total":    total,
+		"limit":    limit,
+		"offset":   offset,
+		"has_more": int64(offset+len(reports)) < total,
+	})
+}
+
+// ResolveReport handles closing a message report
+// @Summary Resolve a message report
+// @Description Close an open report as resolved (action taken) or dismissed. Admins only
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param report_id path string true "Report ID"
+// @Param request body ResolveReportRequest true "New report status"
+// @Success 200 {object} models.MessageReport "Updated report"
+// @Failure 400 {object} utils.APIError "Invalid report ID or status"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 403 {object} utils.APIError "User is not an administrator"
+// @Failure 404 {object} utils.APIError "Report not found"
+// @Failure 409 {object} utils.APIError "Report is already closed"
+// @Failure 500 {object} utils.APIError "Internal server error"
+// @Router /admin/reports/{report_id} [put]
+func (rc *MessageReportController) ResolveReport(c *gin.Context) {
+	reportID, err := primitive.ObjectIDFromHex(c.Param("report_id"))
+	if err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, "Please provide a valid report ID")
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondErrorMessage(c, http.StatusUnauthorized, "Please log in to continue")
+		return
+	}
+
+	var req ResolveReportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, "Please provide the new report status")
+		return
+	}
+
+	report, err := rc.ReportService.ResolveReport(reportID, userID.(uint), req.Status)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
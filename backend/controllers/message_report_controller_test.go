@@ -0,0 +1,113 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ginchat/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMessageReports(t *testing.T) {
+	env := newAPIEnv(t)
+	alice, bob, carol, dave := env.user(t, "alice"), env.user(t, "bob"), env.user(t, "carol"), env.user(t, "dave")
+	mallory, root := env.user(t, "mallory"), env.admin(t, "root")
+	roomID := env.createRoom(t, alice, "General", bob, carol, dave)
+	spam := env.send(t, bob, roomID, "buy now")
+	moderator := env.dial(t, alice, "global_sidebar")
+
+	report := func(user *apiUser, messageID, reason string, status int) models.MessageReport {
+		t.Helper()
+		var got models.MessageReport
+		expect(t, env.do(t, user, http.MethodPost, "/api/messages/"+messageID+"/report", map[string]string{"reason": reason}), status, &got)
+		return got
+	}
+	var first models.MessageReport
+
+	t.Run("report", func(t *testing.T) {
+		first = report(carol, spam, "  spam link  ", http.StatusCreated)
+		if first.ReporterID != carol.ID || first.SenderID != bob.ID || first.Reason != "spam link" || first.Status != models.ReportStatusOpen {
+			t.Errorf("report = %+v", first)
+		}
+
+		// The room's admins hear about it straight away
+		var notified struct {
+			ReportID  string `json:"report_id"`
+			MessageID string `json:"message_id"`
+		}
+		if err := json.Unmarshal(moderator.await(t, "message_reported").Data, &notified); err != nil || notified.ReportID != first.ID.Hex() || notified.MessageID != spam {
+			t.Errorf("message_reported = %+v (%v), want the new report", notified, err)
+		}
+	})
+
+	t.Run("duplicate", func(t *testing.T) {
+		var got apiError
+		expect(t, env.do(t, carol, http.MethodPost, "/api/messages/"+spam+"/report", map[string]string{"reason": "again"}), http.StatusConflict, &got)
+		if got.Code != "ALREADY_REPORTED" {
+			t.Errorf("code = %s, want ALREADY_REPORTED", got.Code)
+		}
+		// Another member may still report it
+		report(dave, spam, "scam", http.StatusCreated)
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		for _, tt := range []struct {
+			user      *apiUser
+			messageID string
+			reason    string
+			status    int
+		}{
+			{bob, spam, "mine", http.StatusBadRequest},                             // Own message
+			{mallory, spam, "not my room", http.StatusForbidden},                   // Not a member
+			{carol, primitive.NewObjectID().Hex(), "gone", http.StatusNotFound},    // Unknown message
+			{dave, env.send(t, alice, roomID, "hi"), "   ", http.StatusBadRequest}, // Blank reason
+			{dave, env.send(t, alice, roomID, "hi"), strings.Repeat("x", 501), http.StatusBadRequest},
+		} {
+			expect(t, env.do(t, tt.user, http.MethodPost, "/api/messages/"+tt.messageID+"/report", map[string]string{"reason": tt.reason}), tt.status, nil)
+		}
+	})
+
+	type page struct {
+		Reports []models.MessageReport `json:"reports"`
+		Total   int64                  `json:"total"`
+		HasMore bool                   `json:"has_more"`
+	}
+
+	t.Run("admin listing", func(t *testing.T) {
+		expect(t, env.do(t, alice, http.MethodGet, "/api/admin/reports", nil), http.StatusForbidden, nil)
+
+		var all page
+		expect(t, env.do(t, root, http.MethodGet, "/api/admin/reports", nil), http.StatusOK, &all)
+		if all.Total != 2 || len(all.Reports) != 2 || all.Reports[0].ReporterID != dave.ID || all.Reports[1].ID != first.ID {
+			t.Errorf("reports = %+v, want dave's then carol's", all)
+		}
+		var one page
+		expect(t, env.do(t, root, http.MethodGet, "/api/admin/reports?limit=1", nil), http.StatusOK, &one)
+		if len(one.Reports) != 1 || one.Total != 2 || !one.HasMore {
+			t.Errorf("first page = %+v, want 1 of 2 with more", one)
+		}
+		expect(t, env.do(t, root, http.MethodGet, "/api/admin/reports?status=pending", nil), http.StatusBadRequest, nil)
+	})
+
+	t.Run("resolve", func(t *testing.T) {
+		path := "/api/admin/reports/" + first.ID.Hex()
+		expect(t, env.do(t, alice, http.MethodPut, path, map[string]string{"status": "resolved"}), http.StatusForbidden, nil)
+		expect(t, env.do(t, root, http.MethodPut, path, map[string]string{"status": "open"}), http.StatusBadRequest, nil)
+
+		var resolved models.MessageReport
+		expect(t, env.do(t, root, http.MethodPut, path, map[string]string{"status": "resolved"}), http.StatusOK, &resolved)
+		if resolved.Status != models.ReportStatusResolved || resolved.ResolvedBy == nil || *resolved.ResolvedBy != root.ID || resolved.ResolvedAt == nil {
+			t.Errorf("resolved report = %+v", resolved)
+		}
+		expect(t, env.do(t, root, http.MethodPut, path, map[string]string{"status": "dismissed"}), http.StatusConflict, nil)
+		expect(t, env.do(t, root, http.MethodPut, "/api/admin/reports/"+primitive.NewObjectID().Hex(), map[string]string{"status": "dismissed"}), http.StatusNotFound, nil)
+
+		var open page
+		expect(t, env.do(t, root, http.MethodGet, "/api/admin/reports?status=open", nil), http.StatusOK, &open)
+		if open.Total != 1 || open.Reports[0].ReporterID != dave.ID {
+			t.Errorf("open reports = %+v, want only dave's", open)
+		}
+	})
+}
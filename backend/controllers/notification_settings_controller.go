@@ -0,0 +1,123 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ginchat/models"
+	"github.com/ginchat/services"
+	"github.com/ginchat/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"gorm.io/gorm"
+)
+
+// NotificationSettingsController serves all of a user's notification settings in one place
+type NotificationSettingsController struct {
+	SettingsService *services.NotificationSettingsService
+}
+
+// NewNotificationSettingsController creates a new NotificationSettingsController
+func NewNotificationSettingsController(db *gorm.DB, mongodb *mongo.Database, userService *services.UserService) *NotificationSettingsController {
+	return &NotificationSettingsController{
+		SettingsService: services.NewNotificationSettingsService(db, mongodb, userService),
+	}
+}
+
+// ChatroomMuteRequest sets whether one chatroom is muted
+type ChatroomMuteRequest struct {
+	ChatroomID string `json:"chatroom_id" binding:"required" example:"60d5f8b8e6b5f0b3e8b4b5b3"`
+	Muted      *bool  `json:"muted" binding:"required" example:"true"`
+}
+
+// UpdateNotificationSettingsRequest represents the request body for a bulk notification settings update.
+// Omitted fields keep their current value.
+type UpdateNotificationSettingsRequest struct {
+	NotificationPreview *string                  `json:"notification_preview,omitempty" binding:"omitempty,oneof=full sender_only hidden" example:"sender_only" enums:"full,sender_only,hidden"`
+	QuietHours          *UpdateQuietHoursRequest `json:"quiet_hours,omitempty"`                                // New do-not-disturb window
+	ClearQuietHours     bool                     `json:"clear_quiet_hours,omitempty" example:"false"`          // Turn quiet hours off; can't be combined with quiet_hours
+	Chatrooms           []ChatroomMuteRequest    `json:"chatrooms,omitempty" binding:"omitempty,max=500,dive"` // Only the listed chatrooms change
+}
+
+// GetNotificationSettings handles getting all of the user's notification settings
+// @Summary Get notification settings
+// @Description Get the notification preview mode, quiet hours and the mute state of every chatroom the user belongs to in one response
+// @Tags notifications
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} services.NotificationSettings "Notification settings"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 404 {object} utils.APIError "User not found"
+// @Failure 500 {object} utils.APIError "Internal server error"
+// @Router /notifications/settings [get]
+func (nc *NotificationSettingsController) GetNotificationSettings(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondErrorMessage(c, http.StatusUnauthorized, "Please log in to continue")
+		return
+	}
+
+	settings, err := nc.SettingsService.GetSettings(userID.(uint))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+// UpdateNotificationSettings handles changing several notification settings at once
+// @Summary Update notification settings
+// @Description Change the preview mode, quiet hours and any number of chatrooms' mute state in one request. Nothing is saved if any part is invalid. Returns the full settings afterwards
+// @Tags notifications
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body UpdateNotificationSettingsRequest true "Settings to change"
+// @Success 200 {object} services.NotificationSettings "Updated notification settings"
+// @Failure 400 {object} utils.APIError "Invalid settings or nothing to change"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 403 {object} utils.APIError "User is not a member of a listed chatroom"
+// @Failure 404 {object} utils.APIError "User not found"
+// @Failure 500 {object} utils.APIError "Internal server error"
+// @Router /notifications/settings [put]
+func (nc *NotificationSettingsController) UpdateNotificationSettings(c *gin.Context) {
+	var req UpdateNotificationSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, utils.FormatValidationError(err))
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondErrorMessage(c, http.StatusUnauthorized, "Please log in to continue")
+		return
+	}
+
+	update := services.NotificationSettingsUpdate{
+		NotificationPreview: req.NotificationPreview,
+		ClearQuietHours:     req.ClearQuietHours,
+	}
+	if req.QuietHours != nil {
+		update.QuietHours = &models.QuietHours{Start: req.QuietHours.Start, End: req.QuietHours.End, Timezone: req.QuietHours.Timezone}
+	}
+	if len(req.Chatrooms) > 0 {
+		update.Chatrooms = make(map[primitive.ObjectID]bool, len(req.Chatrooms))
+		for _, chatroom := range req.Chatrooms {
+			chatroomID, err := primitive.ObjectIDFromHex(chatroom.ChatroomID)
+			if err != nil {
+				respondErrorMessage(c, http.StatusBadRequest, "Invalid chatroom ID")
+				return
+			}
+			update.Chatrooms[chatroomID] = *chatroom.Muted // A repeated chatroom keeps its last entry
+		}
+	}
+
+	settings, err := nc.SettingsService.UpdateSettings(userID.(uint), update)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
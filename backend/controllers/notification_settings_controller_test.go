@@ -0,0 +1,107 @@
+package controllers_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/ginchat/models"
+	"github.com/ginchat/services"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestNotificationSettings(t *testing.T) {
+	env := newAPIEnv(t)
+	alice, bob := env.user(t, "alice"), env.user(t, "bob")
+	betaID := env.createRoom(t, alice, "Beta", bob)
+	alphaID := env.createRoom(t, alice, "Alpha", bob)
+	gammaID := env.createRoom(t, alice, "Gamma")
+	const path = "/api/notifications/settings"
+
+	get := func(user *apiUser) services.NotificationSettings {
+		t.Helper()
+		var settings services.NotificationSettings
+		expect(t, env.do(t, user, http.MethodGet, path, nil), http.StatusOK, &settings)
+		return settings
+	}
+	// muted returns the mute state by chatroom name
+	muted := func(settings services.NotificationSettings) map[string]bool {
+		byName := map[string]bool{}
+		for _, chatroom := range settings.Chatrooms {
+			byName[chatroom.ChatroomName] = chatroom.Muted
+		}
+		return byName
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		settings := get(bob)
+		if settings.NotificationPreview != models.NotificationPreviewFull || settings.QuietHours != nil {
+			t.Errorf("settings = %+v, want full previews and no quiet hours", settings)
+		}
+		if len(settings.Chatrooms) != 2 || settings.Chatrooms[0].ChatroomID != alphaID || settings.Chatrooms[1].ChatroomID != betaID {
+			t.Errorf("chatrooms = %+v, want Alpha then Beta", settings.Chatrooms)
+		}
+		if got := muted(settings); got["Alpha"] || got["Beta"] {
+			t.Errorf("muted = %v, want nothing muted", got)
+		}
+	})
+
+	t.Run("bulk update", func(t *testing.T) {
+		var updated services.NotificationSettings
+		expect(t, env.do(t, bob, http.MethodPut, path, map[string]any{
+			"notification_preview": "sender_only",
+			"quiet_hours":          map[string]string{"start": "22:00", "end": "07:00", "timezone": "Europe/Paris"},
+			"chatrooms":            []map[string]any{{"chatroom_id": alphaID, "muted": true}},
+		}), http.StatusOK, &updated)
+		settings := get(bob)
+		for _, got := range []services.NotificationSettings{updated, settings} {
+			if got.NotificationPreview != "sender_only" || got.QuietHours == nil || *got.QuietHours != (models.QuietHours{Start: "22:00", End: "07:00", Timezone: "Europe/Paris"}) {
+				t.Errorf("settings = %+v, want sender_only previews and the new quiet hours", got)
+			}
+			if m := muted(got); !m["Alpha"] || m["Beta"] {
+				t.Errorf("muted = %v, want only Alpha", m)
+			}
+		}
+		// Only bob's own membership is muted
+		if m := muted(get(alice)); m["Alpha"] {
+			t.Error("bob's mute changed alice's setting")
+		}
+	})
+
+	t.Run("rejected updates change nothing", func(t *testing.T) {
+		for _, tt := range []struct {
+			body   map[string]any
+			status int
+		}{
+			{map[string]any{"notification_preview": "hidden", "chatrooms": []map[string]any{{"chatroom_id": betaID, "muted": true}, {"chatroom_id": gammaID, "muted": true}}}, http.StatusForbidden},
+			{map[string]any{"notification_preview": "hidden", "chatrooms": []map[string]any{{"chatroom_id": primitive.NewObjectID().Hex(), "muted": true}}}, http.StatusForbidden},
+			{map[string]any{"notification_preview": "hidden", "chatrooms": []map[string]any{{"chatroom_id": "nope", "muted": true}}}, http.StatusBadRequest},
+			{map[string]any{"notification_preview": "hidden", "chatrooms": []map[string]any{{"chatroom_id": betaID}}}, http.StatusBadRequest}, // muted is required
+			{map[string]any{"notification_preview": "loud"}, http.StatusBadRequest},
+			{map[string]any{"quiet_hours": map[string]string{"start": "25:00", "end": "07:00", "timezone": "UTC"}}, http.StatusBadRequest},
+			{map[string]any{"quiet_hours": map[string]string{"start": "22:00", "end": "07:00", "timezone": "UTC"}, "clear_quiet_hours": true}, http.StatusBadRequest},
+			{map[string]any{}, http.StatusBadRequest},
+		} {
+			expect(t, env.do(t, bob, http.MethodPut, path, tt.body), tt.status, nil)
+		}
+		settings := get(bob)
+		if settings.NotificationPreview != "sender_only" || settings.QuietHours == nil || settings.QuietHours.Start != "22:00" {
+			t.Errorf("settings = %+v, want them unchanged", settings)
+		}
+		if m := muted(settings); !m["Alpha"] || m["Beta"] {
+			t.Errorf("muted = %v, want only Alpha still", m)
+		}
+	})
+
+	t.Run("clear quiet hours and unmute", func(t *testing.T) {
+		var updated services.NotificationSettings
+		expect(t, env.do(t, bob, http.MethodPut, path, map[string]any{
+			"clear_quiet_hours": true,
+			"chatrooms":         []map[string]any{{"chatroom_id": alphaID, "muted": false}},
+		}), http.StatusOK, &updated)
+		if updated.QuietHours != nil || updated.NotificationPreview != "sender_only" || muted(updated)["Alpha"] {
+			t.Errorf("settings = %+v, want quiet hours off, Alpha unmuted and the preview kept", updated)
+		}
+	})
+
+	expect(t, env.do(t, nil, http.MethodGet, path, nil), http.StatusUnauthorized, nil)
+}
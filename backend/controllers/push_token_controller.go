@@ -2,8 +2,8 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"strings"
 
@@ -12,6 +12,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // PushTokenController handles push token operations
@@ -33,109 +34,87 @@ func NewPushTokenController(db *gorm.DB) *PushTokenController {
 
 // RegisterPushToken registers a new push token for the user
 // @Summary Register push token
-// @Description Register a push notification token for the authenticated user
+// @Description Register a push notification token for the authenticated user. Registration is idempotent: the token is unique, so registering it again updates the existing row,
+// @Description and a token last registered by another account (same device, different login) is transferred to this user. The user's other tokens are deactivated
 // @Tags push-tokens
 // @Accept json
 // @Produce json
 // @Param request body PushTokenRequest true "Push token registration request"
-// @Success 200 {object} map[string]string "Push token updated successfully"
+// @Success 200 {object} map[string]string "Token already known: reactivated, or transferred from another account"
 // @Success 201 {object} map[string]string "Push token registered successfully"
-// @Failure 400 {object} map[string]string "Bad request"
-// @Failure 401 {object} map[string]string "Unauthorized"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Failure 401 {object} utils.APIError "Unauthorized"
+// @Failure 500 {object} utils.APIError "Internal server error"
 // @Router /auth/push-token [post]
 // @Security BearerAuth
 func (ptc *PushTokenController) RegisterPushToken(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		respondErrorMessage(c, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
 	var req PushTokenRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		log.Printf("DEBUG: Failed to bind JSON for user %d: %v", userID.(uint), err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": utils.FormatValidationError(err)})
+		respondErrorMessage(c, http.StatusBadRequest, utils.FormatValidationError(err))
 		return
 	}
 
-	// Debug: Log the registration attempt with more details
-	log.Printf("DEBUG: Push token registration attempt - User ID: %v, Token length: %d, Platform: %s",
-		userID, len(req.Token), req.Platform)
-	log.Printf("DEBUG: Full token received: %s", req.Token)
-	log.Printf("DEBUG: Token is empty: %t", req.Token == "")
-	log.Printf("DEBUG: Token is null/undefined: %t", req.Token == "null" || req.Token == "undefined")
-
 	// Simplified validation - only check basic Expo token format
 	if err := validateBasicExpoToken(req.Token); err != nil {
-		log.Printf("DEBUG: Token validation failed for user %d: %v", userID.(uint), err)
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error":         "Invalid push token format",
-			"message":       err.Error(),
-			"token_length":  len(req.Token),
-			"token_preview": req.Token[:min(50, len(req.Token))],
-		})
+		respondError(c, invalidPushTokenError(req.Token, err))
 		return
 	}
 
 	// Convert device info to JSON
 	deviceInfoJSON, _ := json.Marshal(req.DeviceInfo)
-
-	// Rest of your existing code remains the same...
-	var existingToken models.PushToken
-	result := ptc.DB.Where("user_id = ? AND token = ?", userID.(uint), req.Token).First(&existingToken)
-
-	if result.Error == nil {
-		// Token exists, first deactivate all other tokens for this user
-		if err := ptc.DB.Model(&models.PushToken{}).Where("user_id = ? AND id != ?", userID.(uint), existingToken.ID).Update("is_active", false).Error; err != nil {
-			log.Printf("DEBUG: Failed to deactivate other tokens for user %d: %v", userID.(uint), err)
-		} else {
-			log.Printf("DEBUG: Deactivated other tokens for user %d", userID.(uint))
+	uid := userID.(uint)
+
+	// The token identifies the physical device, so registration is an upsert keyed by it.
+	// If another account registered this device before (a device handoff), ownership moves to this user.
+	var previousOwner uint
+	created := false
+	err := ptc.DB.Transaction(func(tx *gorm.DB) error {
+		var existing models.PushToken
+		switch err := tx.Where("token = ?", req.Token).First(&existing).Error; {
+		case err == nil:
+			previousOwner = existing.UserID
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			created = true
+		default:
+			return err
 		}
 
-		// Reactivate and update this token
-		existingToken.Platform = req.Platform
-		existingToken.DeviceInfo = deviceInfoJSON
-		existingToken.IsActive = true
-
-		if err := ptc.DB.Save(&existingToken).Error; err != nil {
-			log.Printf("DEBUG: Failed to update existing push token: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update push token"})
-			return
+		// Notifications go only to the device the user registered most recently
+		if err := tx.Model(&models.PushToken{}).Where("user_id = ? AND token <> ?", uid, req.Token).Update("is_active", false).Error; err != nil {
+			return err
 		}
 
-		log.Printf("DEBUG: Reactivated existing push token for user %d", userID.(uint))
-		c.JSON(http.StatusOK, gin.H{"message": "Push token reactivated successfully"})
+		pushToken := models.PushToken{
+			UserID:     uid,
+			Token:      req.Token,
+			Platform:   req.Platform,
+			DeviceInfo: deviceInfoJSON,
+			IsActive:   true,
+		}
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "token"}},
+			DoUpdates: clause.AssignmentColumns([]string{"user_id", "platform", "device_info", "is_active", "updated_at"}),
+		}).Create(&pushToken).Error
+	})
+	if err != nil {
+		respondErrorMessage(c, http.StatusInternalServerError, "Failed to register push token")
 		return
 	}
 
-	// Token doesn't exist, deactivate all existing tokens for this user first
-	if err := ptc.DB.Model(&models.PushToken{}).Where("user_id = ?", userID.(uint)).Update("is_active", false).Error; err != nil {
-		log.Printf("DEBUG: Failed to deactivate existing tokens for user %d: %v", userID.(uint), err)
-	} else {
-		log.Printf("DEBUG: Deactivated all existing tokens for user %d", userID.(uint))
-	}
-
-	// Create new token
-	pushToken := models.PushToken{
-		UserID:     userID.(uint),
-		Token:      req.Token,
-		Platform:   req.Platform,
-		DeviceInfo: deviceInfoJSON,
-		IsActive:   true,
-	}
-
-	if err := ptc.DB.Create(&pushToken).Error; err != nil {
-		log.Printf("DEBUG: Failed to create push token in database: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "Failed to register push token",
-			"details": err.Error(),
-		})
-		return
+	switch {
+	case created:
+		c.JSON(http.StatusCreated, gin.H{"message": "Push token registered successfully"})
+	case previousOwner != uid:
+		c.JSON(http.StatusOK, gin.H{"message": "Push token transferred to this account"})
+	default:
+		c.JSON(http.StatusOK, gin.H{"message": "Push token reactivated successfully"})
 	}
-
-	log.Printf("DEBUG: Push token registered successfully for user %d", userID.(uint))
-	c.JSON(http.StatusCreated, gin.H{"message": "Push token registered successfully"})
 }
 
 // Helper function to validate basic Expo token format
@@ -184,22 +163,22 @@ func min(a, b int) int {
 // @Produce json
 // @Param request body PushTokenRequest true "Push token update request"
 // @Success 200 {object} map[string]string "Push token updated successfully"
-// @Failure 400 {object} map[string]string "Bad request"
-// @Failure 401 {object} map[string]string "Unauthorized"
-// @Failure 404 {object} map[string]string "Push token not found"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Failure 401 {object} utils.APIError "Unauthorized"
+// @Failure 404 {object} utils.APIError "Push token not found"
+// @Failure 500 {object} utils.APIError "Internal server error"
 // @Router /auth/push-token [put]
 // @Security BearerAuth
 func (ptc *PushTokenController) UpdatePushToken(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		respondErrorMessage(c, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
 	var req PushTokenRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": utils.FormatValidationError(err)})
+		respondErrorMessage(c, http.StatusBadRequest, utils.FormatValidationError(err))
 		return
 	}
 
@@ -207,7 +186,7 @@ func (ptc *PushTokenController) UpdatePushToken(c *gin.Context) {
 
 	var pushToken models.PushToken
 	if err := ptc.DB.Where("user_id = ? AND token = ?", userID.(uint), req.Token).First(&pushToken).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Push token not found"})
+		respondErrorMessage(c, http.StatusNotFound, "Push token not found")
 		return
 	}
 
@@ -216,7 +195,7 @@ func (ptc *PushTokenController) UpdatePushToken(c *gin.Context) {
 	pushToken.IsActive = true
 
 	if err := ptc.DB.Save(&pushToken).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update push token"})
+		respondErrorMessage(c, http.StatusInternalServerError, "Failed to update push token")
 		return
 	}
 
@@ -229,50 +208,50 @@ func (ptc *PushTokenController) UpdatePushToken(c *gin.Context) {
 // @Tags push-tokens
 // @Produce json
 // @Success 200 {object} map[string]string "Push token removed successfully"
-// @Failure 401 {object} map[string]string "Unauthorized"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Failure 401 {object} utils.APIError "Unauthorized"
+// @Failure 500 {object} utils.APIError "Internal server error"
 // @Router /auth/push-token [delete]
 // @Security BearerAuth
 func (ptc *PushTokenController) RemovePushToken(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		respondErrorMessage(c, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
 	// Deactivate all tokens for this user
 	if err := ptc.DB.Model(&models.PushToken{}).Where("user_id = ?", userID.(uint)).Update("is_active", false).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove push token"})
+		respondErrorMessage(c, http.StatusInternalServerError, "Failed to remove push token")
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Push token removed successfully"})
 }
 
+// invalidPushTokenError describes why a push token was rejected in the error details
+func invalidPushTokenError(token string, err error) *utils.APIError {
+	apiErr := utils.NewAPIError(http.StatusBadRequest, "INVALID_PUSH_TOKEN", "Invalid push token format")
+	apiErr.Details = gin.H{
+		"reason":       err.Error(),
+		"token_length": len(token),
+	}
+	return apiErr
+}
+
 // TestTokenValidation is a temporary endpoint to test token validation without auth
 func (ptc *PushTokenController) TestTokenValidation(c *gin.Context) {
 	var req PushTokenRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": utils.FormatValidationError(err)})
+		respondErrorMessage(c, http.StatusBadRequest, utils.FormatValidationError(err))
 		return
 	}
 
-	log.Printf("DEBUG: Test token validation - Token length: %d, Platform: %s", len(req.Token), req.Platform)
-	log.Printf("DEBUG: Test token received: %s", req.Token)
-
 	// Test validation
 	if err := validateBasicExpoToken(req.Token); err != nil {
-		log.Printf("DEBUG: Test token validation failed: %v", err)
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error":         "Invalid push token format",
-			"message":       err.Error(),
-			"token_length":  len(req.Token),
-			"token_preview": req.Token[:min(50, len(req.Token))],
-		})
+		respondError(c, invalidPushTokenError(req.Token, err))
 		return
 	}
 
-	log.Printf("DEBUG: Test token validation passed")
 	c.JSON(http.StatusOK, gin.H{
 		"message":      "Token validation passed",
 		"token_length": len(req.Token),
@@ -0,0 +1,134 @@
+package controllers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ginchat/models"
+)
+
+func TestRegisterPushTokenIsKeyedByToken(t *testing.T) {
+	env := newAPIEnv(t)
+	alice, bob := env.user(t, "alice"), env.user(t, "bob")
+	const phone, tablet = "ExponentPushToken[phone-secret]", "ExponentPushToken[tablet-secret]"
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	register := func(user *apiUser, token, platform string, status int, message string) {
+		t.Helper()
+		var got struct {
+			Message string `json:"message"`
+		}
+		expect(t, env.do(t, user, http.MethodPost, "/api/auth/push-token", map[string]string{"token": token, "platform": platform}), status, &got)
+		if got.Message != message {
+			t.Errorf("%s registering %s: message = %q, want %q", user.Name, token, got.Message, message)
+		}
+	}
+	// tokens returns every stored token row, by token
+	tokens := func() map[string]models.PushToken {
+		t.Helper()
+		var rows []models.PushToken
+		if err := env.DB.Find(&rows).Error; err != nil {
+			t.Fatalf("load push tokens: %v", err)
+		}
+		byToken := map[string]models.PushToken{}
+		for _, row := range rows {
+			if _, dup := byToken[row.Token]; dup {
+				t.Errorf("token %s is stored twice", row.Token)
+			}
+			byToken[row.Token] = row
+		}
+		return byToken
+	}
+	expectOwner := func(token string, owner *apiUser, active bool) {
+		t.Helper()
+		row, ok := tokens()[token]
+		if !ok || row.UserID != owner.ID || row.IsActive != active {
+			t.Errorf("%s = %+v, want owned by %s with active=%t", token, row, owner.Name, active)
+		}
+	}
+
+	register(alice, phone, "ios", http.StatusCreated, "Push token registered successfully")
+	expectOwner(phone, alice, true)
+
+	t.Run("same user again", func(t *testing.T) {
+		register(alice, phone, "android", http.StatusOK, "Push token reactivated successfully")
+		if rows := tokens(); len(rows) != 1 || rows[phone].Platform != "android" {
+			t.Errorf("rows = %+v, want the one row updated", rows)
+		}
+		expectOwner(phone, alice, true)
+	})
+
+	t.Run("same user, new device", func(t *testing.T) {
+		register(alice, tablet, "ios", http.StatusCreated, "Push token registered successfully")
+		expectOwner(phone, alice, false)
+		expectOwner(tablet, alice, true)
+	})
+
+	t.Run("another user on the same device", func(t *testing.T) {
+		register(bob, phone, "ios", http.StatusOK, "Push token transferred to this account")
+		expectOwner(phone, bob, true)
+		expectOwner(tablet, alice, true) // Bob's registration leaves alice's other devices alone
+		if rows := tokens(); len(rows) != 2 {
+			t.Errorf("%d rows, want 2", len(rows))
+		}
+	})
+
+	t.Run("handed back", func(t *testing.T) {
+		register(alice, phone, "ios", http.StatusOK, "Push token transferred to this account")
+		expectOwner(phone, alice, true)
+		expectOwner(tablet, alice, false)
+	})
+
+	expect(t, env.do(t, nil, http.MethodPost, "/api/auth/test-token", map[string]string{"token": phone, "platform": "ios"}), http.StatusOK, nil)
+	if strings.Contains(logs.String(), "secret") {
+		t.Errorf("push tokens were logged:\n%s", logs.String())
+	}
+}
+
+func TestPushTokenInvalidatedEvent(t *testing.T) {
+	const stale, fresh = "ExponentPushToken[bob-uninstalled]", "ExponentPushToken[carol-phone]"
+	expo := &expoCounter{base: http.DefaultTransport, unregistered: map[string]bool{stale: true}}
+	http.DefaultTransport = expo
+	t.Cleanup(func() { http.DefaultTransport = expo.base })
+
+	env := newAPIEnv(t)
+	alice, bob, carol := env.user(t, "alice"), env.user(t, "bob"), env.user(t, "carol")
+	expect(t, env.do(t, bob, http.MethodPost, "/api/auth/push-token", map[string]string{"token": stale, "platform": "ios"}), http.StatusCreated, nil)
+	expect(t, env.do(t, carol, http.MethodPost, "/api/auth/push-token", map[string]string{"token": fresh, "platform": "android"}), http.StatusCreated, nil)
+	roomID := env.createRoom(t, alice, "General", bob, carol)
+	bobSocket, carolSocket := env.dial(t, bob, "global_sidebar"), env.dial(t, carol, "global_sidebar")
+
+	env.send(t, alice, roomID, "anyone there?")
+
+	// Expo reports bob's token as unregistered, so bob's connected app is told to register again
+	event := bobSocket.await(t, "push_token_invalidated")
+	var data struct {
+		Token  string `json:"token"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal(event.Data, &data); err != nil || data.Token != stale || data.Reason != "device_not_registered" {
+		t.Errorf("event data = %s (%v), want the invalidated token and device_not_registered", event.Data, err)
+	}
+	var row models.PushToken
+	if err := env.DB.Where("token = ?", stale).First(&row).Error; err != nil || row.IsActive {
+		t.Errorf("bob's token = %+v, %v; want it deactivated", row, err)
+	}
+	if got := carolSocket.collect(200 * time.Millisecond)["push_token_invalidated"]; len(got) != 0 {
+		t.Errorf("carol, whose token is fine, got %d push_token_invalidated events", len(got))
+	}
+
+	// The deactivated token isn't sent to again, so the event isn't repeated
+	env.send(t, alice, roomID, "hello again")
+	if got := bobSocket.collect(200 * time.Millisecond)["push_token_invalidated"]; len(got) != 0 {
+		t.Errorf("bob got %d more push_token_invalidated events after the token was deactivated", len(got))
+	}
+}
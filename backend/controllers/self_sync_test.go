@@ -0,0 +1,90 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+// selfSync is the data of a self_sync frame
+type selfSync struct {
+	Action     string   `json:"action"`
+	ChatroomID string   `json:"chatroom_id"`
+	MessageIDs []string `json:"message_ids"`
+	ReadAll    bool     `json:"read_all"`
+}
+
+// awaitSelfSync returns the data of the next self_sync frame on socket
+func awaitSelfSync(t *testing.T, socket *apiSocket) selfSync {
+	t.Helper()
+	var event selfSync
+	if err := json.Unmarshal(socket.await(t, "self_sync").Data, &event); err != nil {
+		t.Fatalf("decode self_sync: %v", err)
+	}
+	return event
+}
+
+func TestSelfSyncReachesTheUsersOtherConnections(t *testing.T) {
+	env := newAPIEnv(t)
+	alice, bob := env.user(t, "alice"), env.user(t, "bob")
+	roomID := env.createRoom(t, alice, "General", bob)
+	otherRoomID := env.createRoom(t, alice, "Elsewhere")
+	first := env.send(t, alice, roomID, "one")
+	env.send(t, alice, roomID, "two")
+
+	phone := env.dial(t, bob, roomID)
+	time.Sleep(1100 * time.Millisecond) // Each user may open one connection per second
+	web := env.dial(t, bob, "global_sidebar")
+	other := env.dial(t, alice, roomID)
+
+	t.Run("read on one device", func(t *testing.T) {
+		if err := phone.WriteJSON(map[string]any{"type": "mark_read", "chatroom_id": roomID, "data": map[string]any{"message_id": first}}); err != nil {
+			t.Fatalf("write mark_read: %v", err)
+		}
+		phone.await(t, "mark_read_ack")
+		if event := awaitSelfSync(t, web); event.Action != "read" || event.ChatroomID != roomID || !slices.Equal(event.MessageIDs, []string{first}) {
+			t.Errorf("self_sync = %+v, want the read message", event)
+		}
+		// The device that acted already knows
+		if events := phone.collect(100 * time.Millisecond); len(events["self_sync"]) != 0 {
+			t.Errorf("acting connection got %d self_sync frames", len(events["self_sync"]))
+		}
+	})
+
+	t.Run("read over REST", func(t *testing.T) {
+		expect(t, env.do(t, bob, http.MethodPost, "/api/chatrooms/"+roomID+"/mark-all-read", nil), http.StatusOK, nil)
+		for _, socket := range []*apiSocket{phone, web} {
+			if event := awaitSelfSync(t, socket); event.Action != "read" || event.ChatroomID != roomID || !event.ReadAll {
+				t.Errorf("self_sync = %+v, want the whole room read", event)
+			}
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		own := env.send(t, bob, roomID, "oops")
+		expect(t, env.do(t, bob, http.MethodDelete, "/api/chatrooms/"+roomID+"/messages/"+own, nil), http.StatusOK, nil)
+		for _, socket := range []*apiSocket{phone, web} {
+			if event := awaitSelfSync(t, socket); event.Action != "message_deleted" || !slices.Equal(event.MessageIDs, []string{own}) {
+				t.Errorf("self_sync = %+v, want the deleted message", event)
+			}
+		}
+	})
+
+	t.Run("join and leave", func(t *testing.T) {
+		env.join(t, bob, otherRoomID)
+		if event := awaitSelfSync(t, web); event.Action != "chatroom_joined" || event.ChatroomID != otherRoomID {
+			t.Errorf("self_sync = %+v, want the joined room", event)
+		}
+		expect(t, env.do(t, bob, http.MethodPost, "/api/chatrooms/"+otherRoomID+"/leave", nil), http.StatusOK, nil)
+		if event := awaitSelfSync(t, web); event.Action != "chatroom_left" || event.ChatroomID != otherRoomID {
+			t.Errorf("self_sync = %+v, want the room left", event)
+		}
+	})
+
+	// Other users never see another user's sync events
+	if events := other.collect(100 * time.Millisecond); len(events["self_sync"]) != 0 {
+		t.Errorf("alice got %d of bob's self_sync frames", len(events["self_sync"]))
+	}
+}
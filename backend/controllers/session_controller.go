@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ginchat/models"
+	"github.com/ginchat/services"
+	"gorm.io/gorm"
+)
+
+// SessionController lets users see the devices they are signed in on and sign them out remotely
+type SessionController struct {
+	wsHub
+	SessionService *services.SessionService
+}
+
+// NewSessionController creates a new SessionController
+func NewSessionController(db *gorm.DB, userService *services.UserService) *SessionController {
+	return &SessionController{
+		SessionService: services.NewSessionService(db, userService),
+	}
+}
+
+// ListSessions handles listing the user's signed-in devices
+// @Summary List sessions
+// @Description List the user's active sessions, most recently active first. The session making the request has current set to true. Only tokens issued since session tracking was added are listed
+// @Tags auth
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} map[string][]models.SessionResponse "Active sessions"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 500 {object} utils.APIError "Internal server error"
+// @Router /auth/sessions [get]
+func (sc *SessionController) ListSessions(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondErrorMessage(c, http.StatusUnauthorized, "Please log in to continue")
+		return
+	}
+
+	sessions, err := sc.SessionService.ListSessions(userID.(uint))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	currentTokenID := c.GetString("token_id")
+	responses := make([]models.SessionResponse, 0, len(sessions))
+	for i := range sessions {
+		responses = append(responses, sessions[i].ToResponse(currentTokenID))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": responses})
+}
+
+// RevokeSession handles signing one of the user's devices out
+// @Summary Revoke a session
+// @Description Sign out one of the user's sessions. Its token is revoked immediately and any WebSocket connections opened with it are closed. Revoking the last session logs the user out
+// @Tags auth
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Session ID"
+// @Success 200 {object} map[string]interface{} "Session revoked"
+// @Failure 400 {object} utils.APIError "Invalid session ID"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 404 {object} utils.APIError "Session not found"
+// @Failure 500 {object} utils.APIError "Internal server error"
+// @Router /auth/sessions/{id} [delete]
+func (sc *SessionController) RevokeSession(c *gin.Context) {
+	sessionID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, "Invalid session ID")
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondErrorMessage(c, http.StatusUnauthorized, "Please log in to continue")
+		return
+	}
+
+	session, err := sc.SessionService.RevokeSession(userID.(uint), uint(sessionID))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	// A revoked token can't send anything new, but open sockets would keep receiving events
+	sc.hub().CloseTokenConnections(session.UserID, session.TokenID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Session revoked",
+		"current": session.TokenID == c.GetString("token_id"),
+	})
+}
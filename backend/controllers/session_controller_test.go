@@ -0,0 +1,116 @@
+package controllers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ginchat/models"
+)
+
+func TestSessionsListAndRevoke(t *testing.T) {
+	env := newAPIEnv(t)
+	bob := env.user(t, "bob")
+
+	// signIn registers or logs alice in from a device and returns a user acting with that device's token
+	signIn := func(path, device string, status int) *apiUser {
+		t.Helper()
+		body := `{"username":"alice","email":"alice@example.com","password":"Sunflower#42"}`
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", device)
+		w := httptest.NewRecorder()
+		env.Router.ServeHTTP(w, req)
+		var got struct {
+			User  models.UserResponse `json:"user"`
+			Token string              `json:"token"`
+		}
+		expect(t, w, status, &got)
+		return &apiUser{ID: got.User.UserID, Name: "alice on " + device, Token: got.Token}
+	}
+	list := func(user *apiUser) []models.SessionResponse {
+		t.Helper()
+		var got struct {
+			Sessions []models.SessionResponse `json:"sessions"`
+		}
+		expect(t, env.do(t, user, http.MethodGet, "/api/auth/sessions", nil), http.StatusOK, &got)
+		return got.Sessions
+	}
+
+	phone := signIn("/api/auth/register", "phone", http.StatusCreated)
+	tablet := signIn("/api/auth/login", "tablet", http.StatusOK)
+
+	var phoneSession, tabletSession models.SessionResponse
+	t.Run("list", func(t *testing.T) {
+		// An hour-old session becomes the most recent as soon as it is used
+		if err := env.DB.Model(&models.Session{}).Where("user_agent = ?", "phone").Update("last_active_at", time.Now().Add(-time.Hour)).Error; err != nil {
+			t.Fatalf("backdate phone session: %v", err)
+		}
+		sessions := list(phone)
+		if len(sessions) != 2 || sessions[0].UserAgent != "phone" || !sessions[0].Current || sessions[1].UserAgent != "tablet" || sessions[1].Current {
+			t.Fatalf("phone's sessions = %+v, want phone (current) then tablet", sessions)
+		}
+		if time.Since(sessions[0].LastActiveAt) > time.Minute {
+			t.Errorf("phone last_active_at = %v, want the listing request itself", sessions[0].LastActiveAt)
+		}
+		phoneSession, tabletSession = sessions[0], sessions[1]
+
+		if got := list(bob); len(got) != 0 {
+			t.Errorf("bob's sessions = %+v, want none", got)
+		}
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		expect(t, env.do(t, tablet, http.MethodDelete, "/api/auth/sessions/abc", nil), http.StatusBadRequest, nil)
+		expect(t, env.do(t, tablet, http.MethodDelete, "/api/auth/sessions/999999", nil), http.StatusNotFound, nil)
+		expect(t, env.do(t, bob, http.MethodDelete, "/api/auth/sessions/"+strconv.FormatUint(uint64(phoneSession.ID), 10), nil), http.StatusNotFound, nil)
+		expect(t, env.do(t, nil, http.MethodGet, "/api/auth/sessions", nil), http.StatusUnauthorized, nil)
+	})
+
+	t.Run("revoke another device", func(t *testing.T) {
+		socket := env.dial(t, phone, "global_sidebar")
+
+		var revoked struct {
+			Current bool `json:"current"`
+		}
+		expect(t, env.do(t, tablet, http.MethodDelete, "/api/auth/sessions/"+strconv.FormatUint(uint64(phoneSession.ID), 10), nil), http.StatusOK, &revoked)
+		if revoked.Current {
+			t.Errorf("revoking the phone from the tablet reported current = true")
+		}
+
+		expect(t, env.do(t, phone, http.MethodGet, "/api/auth/sessions", nil), http.StatusUnauthorized, nil)
+		if sessions := list(tablet); len(sessions) != 1 || sessions[0].ID != tabletSession.ID || !sessions[0].Current {
+			t.Errorf("tablet's sessions = %+v, want only the tablet", sessions)
+		}
+
+		// The phone's open socket is closed rather than left receiving events
+		timeout := time.After(2 * time.Second)
+		for open := true; open; {
+			select {
+			case _, open = <-socket.events:
+			case <-timeout:
+				t.Fatalf("phone's socket still open after its session was revoked")
+			}
+		}
+	})
+
+	t.Run("revoke the last session", func(t *testing.T) {
+		var revoked struct {
+			Current bool `json:"current"`
+		}
+		expect(t, env.do(t, tablet, http.MethodDelete, "/api/auth/sessions/"+strconv.FormatUint(uint64(tabletSession.ID), 10), nil), http.StatusOK, &revoked)
+		if !revoked.Current {
+			t.Errorf("revoking the tablet from itself reported current = false")
+		}
+		expect(t, env.do(t, tablet, http.MethodGet, "/api/auth/sessions", nil), http.StatusUnauthorized, nil)
+
+		// With no sessions left alice is logged out, so signing in again works
+		laptop := signIn("/api/auth/login", "laptop", http.StatusOK)
+		if sessions := list(laptop); len(sessions) != 1 || sessions[0].UserAgent != "laptop" {
+			t.Errorf("laptop's sessions = %+v, want only the laptop", sessions)
+		}
+	})
+}
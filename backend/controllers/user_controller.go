@@ -2,13 +2,16 @@ package controllers
 
 import (
 	"fmt"
+	"math"
 	"math/rand"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/ginchat/models"
 	"github.com/ginchat/services"
 	"github.com/ginchat/utils"
 	"github.com/sirupsen/logrus"
@@ -17,13 +20,25 @@ import (
 
 // UserController handles user-related requests
 type UserController struct {
-	UserService *services.UserService
+	UserService    *services.UserService
+	MessageService *services.MessageService
+	SessionService *services.SessionService
+	searchLimiter  *utils.WindowLimiter // Slows down username enumeration through search
 }
 
+// Each user may run at most userSearchLimit searches per userSearchWindow
+const (
+	userSearchLimit  = 30
+	userSearchWindow = time.Minute
+)
+
 // NewUserController creates a new UserController
-func NewUserController(db *gorm.DB, userService *services.UserService) *UserController {
+func NewUserController(db *gorm.DB, userService *services.UserService, messageService *services.MessageService) *UserController {
 	return &UserController{
-		UserService: userService,
+		UserService:    userService,
+		MessageService: messageService,
+		SessionService: services.NewSessionService(db, userService),
+		searchLimiter:  utils.NewWindowLimiter(userSearchLimit, userSearchWindow),
 	}
 }
 
@@ -48,39 +63,34 @@ type LoginRequest struct {
 // @Produce json
 // @Param user body RegisterRequest true "User Registration Data"
 // @Success 201 {object} map[string]interface{} "User created successfully"
-// @Failure 400 {object} map[string]interface{} "Invalid input"
-// @Failure 409 {object} map[string]interface{} "User already exists"
-// @Failure 500 {object} map[string]interface{} "Server error"
+// @Failure 400 {object} utils.APIError "Invalid input"
+// @Failure 409 {object} utils.APIError "User already exists"
+// @Failure 500 {object} utils.APIError "Server error"
 // @Router /auth/register [post]
 func (uc *UserController) Register(c *gin.Context) {
 	var req RegisterRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": utils.FormatValidationError(err)})
+		respondErrorMessage(c, http.StatusBadRequest, utils.FormatValidationError(err))
 		return
 	}
 
 	// Validate password strength
 	if err := validatePasswordStrength(req.Password); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondErrorMessage(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
 	// Register user using the user service
-	user, err := uc.UserService.Register(req.Username, req.Email, req.Password, "member")
+	user, err := uc.UserService.Register(req.Username, req.Email, req.Password, models.UserRoleMember)
 	if err != nil {
-		errMsg := err.Error()
-		if errMsg == "user with this email already exists" || errMsg == "user with this username already exists" {
-			c.JSON(http.StatusConflict, gin.H{"error": utils.FormatServiceError(err)})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": utils.FormatServiceError(err)})
-		}
+		respondError(c, err)
 		return
 	}
 
 	// Generate JWT token
-	token, err := utils.GenerateJWT(user.UserID, user.Username, user.Email, user.Role)
+	token, err := uc.issueToken(c, user)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to complete registration. Please try again"})
+		respondErrorMessage(c, http.StatusInternalServerError, "Unable to complete registration. Please try again")
 		return
 	}
 
@@ -102,14 +112,14 @@ func (uc *UserController) Register(c *gin.Context) {
 // @Produce json
 // @Param user body LoginRequest true "User Login Data"
 // @Success 200 {object} map[string]interface{} "Login successful"
-// @Failure 400 {object} map[string]interface{} "Invalid input"
-// @Failure 401 {object} map[string]interface{} "Invalid credentials"
-// @Failure 500 {object} map[string]interface{} "Server error"
+// @Failure 400 {object} utils.APIError "Invalid input"
+// @Failure 401 {object} utils.APIError "Invalid credentials"
+// @Failure 500 {object} utils.APIError "Server error"
 // @Router /auth/login [post]
 func (uc *UserController) Login(c *gin.Context) {
 	var req LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": utils.FormatValidationError(err)})
+		respondErrorMessage(c, http.StatusBadRequest, utils.FormatValidationError(err))
 		return
 	}
 
@@ -119,20 +129,14 @@ func (uc *UserController) Login(c *gin.Context) {
 		// Add a small delay to prevent timing attacks
 		time.Sleep(time.Duration(100+rand.Intn(100)) * time.Millisecond)
 
-		// Check for specific error types
-		if err.Error() == "this user is already logged in on another device" {
-			c.JSON(http.StatusConflict, gin.H{"error": utils.FormatServiceError(err)})
-		} else {
-			// Invalid credentials or other errors
-			c.JSON(http.StatusUnauthorized, gin.H{"error": utils.FormatServiceError(err)})
-		}
+		respondError(c, err)
 		return
 	}
 
 	// Generate JWT token
-	token, err := utils.GenerateJWT(user.UserID, user.Username, user.Email, user.Role)
+	token, err := uc.issueToken(c, user)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to complete login. Please try again"})
+		respondErrorMessage(c, http.StatusInternalServerError, "Unable to complete login. Please try again")
 		return
 	}
 
@@ -154,31 +158,35 @@ func (uc *UserController) Login(c *gin.Context) {
 // @Produce json
 // @Security ApiKeyAuth
 // @Success 200 {object} map[string]interface{} "Logout successful"
-// @Failure 401 {object} map[string]interface{} "User not authenticated"
-// @Failure 404 {object} map[string]interface{} "User not found"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 404 {object} utils.APIError "User not found"
 // @Router /auth/logout [post]
 func (uc *UserController) Logout(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": utils.FormatAuthError(fmt.Errorf("user not authenticated"))})
+		respondErrorMessage(c, http.StatusUnauthorized, utils.FormatAuthError(fmt.Errorf("user not authenticated")))
 		return
 	}
 
 	// Convert userID to uint
 	userIDUint, ok := userID.(uint)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": utils.FormatAuthError(fmt.Errorf("invalid user ID"))})
+		respondErrorMessage(c, http.StatusInternalServerError, utils.FormatAuthError(fmt.Errorf("invalid user ID")))
 		return
 	}
 
 	// Logout user using the user service
 	err := uc.UserService.Logout(userIDUint)
 	if err != nil {
-		if err.Error() == "user not found" {
-			c.JSON(http.StatusNotFound, gin.H{"error": utils.FormatServiceError(err)})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": utils.FormatServiceError(err)})
-		}
+		respondError(c, err)
+		return
+	}
+
+	// Revoke the token used for this request so it can't be reused until it expires
+	tokenID := c.GetString("token_id")
+	expiresAt := time.Unix(c.GetInt64("token_expires_at"), 0)
+	if err := uc.UserService.RevokeToken(tokenID, userIDUint, expiresAt); err != nil {
+		respondError(c, err)
 		return
 	}
 
@@ -193,9 +201,9 @@ func (uc *UserController) Logout(c *gin.Context) {
 // @Produce json
 // @Param request body map[string]string true "Email of user to force logout"
 // @Success 200 {object} map[string]interface{} "User force logged out successfully"
-// @Failure 400 {object} map[string]interface{} "Invalid request"
-// @Failure 404 {object} map[string]interface{} "User not found"
-// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Failure 400 {object} utils.APIError "Invalid request"
+// @Failure 404 {object} utils.APIError "User not found"
+// @Failure 500 {object} utils.APIError "Internal server error"
 // @Router /auth/force-logout [post]
 func (uc *UserController) ForceLogout(c *gin.Context) {
 	var req struct {
@@ -203,21 +211,21 @@ func (uc *UserController) ForceLogout(c *gin.Context) {
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": utils.FormatValidationError(err)})
+		respondErrorMessage(c, http.StatusBadRequest, utils.FormatValidationError(err))
 		return
 	}
 
 	// Find and force logout user by email using UserService
 	user, err := uc.UserService.GetUserByEmail(req.Email)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		respondErrorMessage(c, http.StatusNotFound, "User not found")
 		return
 	}
 
 	// Force logout the user
 	err = uc.UserService.Logout(user.UserID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": utils.FormatServiceError(err)})
+		respondError(c, err)
 		return
 	}
 
@@ -228,6 +236,342 @@ func (uc *UserController) ForceLogout(c *gin.Context) {
 	})
 }
 
+// UpdateProfileRequest represents the request body for updating the user's profile
+type UpdateProfileRequest struct {
+	Username string `json:"username" binding:"required,min=3,max=50" example:"johndoe"` // New username
+}
+
+// UpdateProfile godoc
+// @Summary Update profile
+// @Description Update the authenticated user's username. Returns a new token since the old one carries the previous username
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body UpdateProfileRequest true "Profile data"
+// @Success 200 {object} map[string]interface{} "Profile updated"
+// @Failure 400 {object} utils.APIError "Invalid input"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 404 {object} utils.APIError "User not found"
+// @Failure 409 {object} utils.APIError "Username already taken"
+// @Failure 500 {object} utils.APIError "Internal server error"
+// @Router /users/profile [put]
+func (uc *UserController) UpdateProfile(c *gin.Context) {
+	var req UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, utils.FormatValidationError(err))
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondErrorMessage(c, http.StatusUnauthorized, "Please log in to continue")
+		return
+	}
+
+	oldUsername, _ := c.Get("username")
+
+	user, err := uc.UserService.UpdateProfile(userID.(uint), strings.TrimSpace(req.Username))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	// Refresh the sender name on old messages in the background so the response isn't blocked
+	if oldUsername != user.Username {
+		go func(userID uint, username string) {
+			if err := uc.MessageService.RefreshSenderName(userID, username); err != nil {
+				logrus.WithFields(logrus.Fields{
+					"user_id": userID,
+					"error":   err.Error(),
+				}).Warn("Failed to refresh sender name after rename")
+			}
+		}(user.UserID, user.Username)
+	}
+
+	// Issue a new token with the updated username
+	token, err := uc.issueToken(c, user)
+	if err != nil {
+		respondErrorMessage(c, http.StatusInternalServerError, "Profile updated but failed to refresh token. Please log in again")
+		return
+	}
+
+	logUserActivity(c, user.UserID, "User updated profile")
+
+	c.JSON(http.StatusOK, gin.H{
+		"user":  uc.UserService.ToResponse(user),
+		"token": token,
+	})
+}
+
+// UpdateNotificationPreviewRequest represents the request body for updating the notification preview mode
+type UpdateNotificationPreviewRequest struct {
+	NotificationPreview string `json:"notification_preview" binding:"required,oneof=full sender_only hidden" example:"sender_only" enums:"full,sender_only,hidden"` // How much of a message push notifications show
+}
+
+// GetNotificationPreview godoc
+// @Summary Get notification preview mode
+// @Description Get how much message content the authenticated user's push notifications show
+// @Tags users
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} map[string]string "Current notification preview mode"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 404 {object} utils.APIError "User not found"
+// @Router /users/notification-preview [get]
+func (uc *UserController) GetNotificationPreview(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondErrorMessage(c, http.StatusUnauthorized, "Please log in to continue")
+		return
+	}
+
+	mode, err := uc.UserService.GetNotificationPreview(userID.(uint))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"notification_preview": mode})
+}
+
+// UpdateNotificationPreview godoc
+// @Summary Update notification preview mode
+// @Description Set whether push notifications show the full message, only the sender, or nothing
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body UpdateNotificationPreviewRequest true "Notification preview mode"
+// @Success 200 {object} map[string]string "Notification preview mode updated"
+// @Failure 400 {object} utils.APIError "Invalid preview mode"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 404 {object} utils.APIError "User not found"
+// @Failure 500 {object} utils.APIError "Internal server error"
+// @Router /users/notification-preview [put]
+func (uc *UserController) UpdateNotificationPreview(c *gin.Context) {
+	var req UpdateNotificationPreviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, utils.FormatValidationError(err))
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondErrorMessage(c, http.StatusUnauthorized, "Please log in to continue")
+		return
+	}
+
+	err := uc.UserService.UpdateNotificationPreview(userID.(uint), req.NotificationPreview)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":              "Notification preview updated successfully",
+		"notification_preview": req.NotificationPreview,
+	})
+}
+
+// SearchUsers godoc
+// @Summary Search users by username
+// @Description Find users whose username contains q, with prefix matches first, e.g. to start a chat or mention someone. Returns only id, username and avatar. Limited to 30 searches per minute per user
+// @Tags users
+// @Produce json
+// @Security ApiKeyAuth
+// @Param q query string true "Part of a username"
+// @Param limit query int false "Maximum results (default 10, max 25)"
+// @Param exclude_self query bool false "Leave the requesting user out of the results"
+// @Success 200 {object} map[string]interface{} "Matching users"
+// @Failure 400 {object} utils.APIError "Missing or too long query"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 429 {object} utils.APIError "Too many searches"
+// @Failure 500 {object} utils.APIError "Internal server error"
+// @Router /users/search [get]
+func (uc *UserController) SearchUsers(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondErrorMessage(c, http.StatusUnauthorized, "Please log in to continue")
+		return
+	}
+
+	if allowed, retryAfter := uc.searchLimiter.Allow(userID.(uint)); !allowed {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		respondErrorMessage(c, http.StatusTooManyRequests, "Too many searches. Please wait a moment and try again")
+		return
+	}
+
+	limit := services.DefaultUserSearchLimit
+	if limitStr := c.Query("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed <= 0 {
+			respondErrorMessage(c, http.StatusBadRequest, "Limit must be a positive number")
+			return
+		}
+		limit = parsed
+	}
+
+	var excludeUserID uint
+	if c.Query("exclude_self") == "true" {
+		excludeUserID = userID.(uint)
+	}
+
+	users, err := uc.UserService.SearchUsers(c.Query("q"), limit, excludeUserID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	results := make([]models.UserSummary, 0, len(users))
+	for i := range users {
+		results = append(results, users[i].ToSummary())
+	}
+	c.JSON(http.StatusOK, gin.H{"users": results})
+}
+
+// UpdateLastSeenSharingRequest represents the request body for the last seen privacy setting
+type UpdateLastSeenSharingRequest struct {
+	ShareLastSeen *bool `json:"share_last_seen" binding:"required" example:"false"` // Whether other users can see when you were last active
+}
+
+// UpdateLastSeenSharing godoc
+// @Summary Update last seen sharing
+// @Description Choose whether other users see when you were last active. When off, last_seen_at is null in your profile and in chatroom member lists
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body UpdateLastSeenSharingRequest true "Last seen sharing"
+// @Success 200 {object} map[string]interface{} "Last seen sharing updated"
+// @Failure 400 {object} utils.APIError "Invalid request body"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 404 {object} utils.APIError "User not found"
+// @Failure 500 {object} utils.APIError "Internal server error"
+// @Router /users/last-seen-sharing [put]
+func (uc *UserController) UpdateLastSeenSharing(c *gin.Context) {
+	var req UpdateLastSeenSharingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, utils.FormatValidationError(err))
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondErrorMessage(c, http.StatusUnauthorized, "Please log in to continue")
+		return
+	}
+
+	if err := uc.UserService.UpdateShareLastSeen(userID.(uint), *req.ShareLastSeen); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":         "Last seen sharing updated successfully",
+		"share_last_seen": *req.ShareLastSeen,
+	})
+}
+
+// UpdateQuietHoursRequest represents the request body for setting quiet hours
+type UpdateQuietHoursRequest struct {
+	Start    string `json:"start" binding:"required" example:"22:00"`             // "HH:MM", 24-hour clock
+	End      string `json:"end" binding:"required" example:"07:00"`               // "HH:MM"; earlier than start for a window that crosses midnight
+	Timezone string `json:"timezone" binding:"required" example:"Asia/Singapore"` // IANA timezone name
+}
+
+// GetQuietHours godoc
+// @Summary Get quiet hours
+// @Description Get the authenticated user's do-not-disturb window (null when off)
+// @Tags users
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} map[string]interface{} "Current quiet hours"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 404 {object} utils.APIError "User not found"
+// @Router /users/quiet-hours [get]
+func (uc *UserController) GetQuietHours(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondErrorMessage(c, http.StatusUnauthorized, "Please log in to continue")
+		return
+	}
+
+	quietHours, err := uc.UserService.GetQuietHours(userID.(uint))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"quiet_hours": quietHours})
+}
+
+// UpdateQuietHours godoc
+// @Summary Set quiet hours
+// @Description Set a daily window during which push notifications are not sent. Messages are still stored and delivered in-app
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body UpdateQuietHoursRequest true "Quiet hours window"
+// @Success 200 {object} map[string]interface{} "Quiet hours updated"
+// @Failure 400 {object} utils.APIError "Invalid times or timezone"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 404 {object} utils.APIError "User not found"
+// @Failure 500 {object} utils.APIError "Internal server error"
+// @Router /users/quiet-hours [put]
+func (uc *UserController) UpdateQuietHours(c *gin.Context) {
+	var req UpdateQuietHoursRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondErrorMessage(c, http.StatusBadRequest, utils.FormatValidationError(err))
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondErrorMessage(c, http.StatusUnauthorized, "Please log in to continue")
+		return
+	}
+
+	quietHours := models.QuietHours{Start: req.Start, End: req.End, Timezone: req.Timezone}
+	err := uc.UserService.UpdateQuietHours(userID.(uint), quietHours)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Quiet hours updated successfully",
+		"quiet_hours": quietHours,
+	})
+}
+
+// ClearQuietHours godoc
+// @Summary Clear quiet hours
+// @Description Turn quiet hours off so push notifications are sent at any time
+// @Tags users
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} map[string]string "Quiet hours cleared"
+// @Failure 401 {object} utils.APIError "User not authenticated"
+// @Failure 404 {object} utils.APIError "User not found"
+// @Failure 500 {object} utils.APIError "Internal server error"
+// @Router /users/quiet-hours [delete]
+func (uc *UserController) ClearQuietHours(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondErrorMessage(c, http.StatusUnauthorized, "Please log in to continue")
+		return
+	}
+
+	if err := uc.UserService.ClearQuietHours(userID.(uint)); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Quiet hours cleared successfully"})
+}
+
 // validatePasswordStrength checks if a password meets the minimum security requirements
 func validatePasswordStrength(password string) error {
 	if len(password) < 8 {
@@ -266,6 +610,24 @@ func validatePasswordStrength(password string) error {
 	return nil
 }
 
+// issueToken generates a JWT for the user and records it as a session for the requesting device.
+// A failure to record the session is logged but doesn't fail the request.
+func (uc *UserController) issueToken(c *gin.Context, user *models.User) (string, error) {
+	issued, err := utils.IssueJWT(user.UserID, user.Username, user.Email, user.Role)
+	if err != nil {
+		return "", err
+	}
+
+	if err := uc.SessionService.CreateSession(user.UserID, issued, c.Request.UserAgent(), c.ClientIP()); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"user_id": user.UserID,
+			"error":   err.Error(),
+		}).Warn("Failed to record session")
+	}
+
+	return issued.Token, nil
+}
+
 // logUserActivity logs user activities for auditing purposes
 func logUserActivity(c *gin.Context, userID uint, activity string) {
 	// Get client IP
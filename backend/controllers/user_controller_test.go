@@ -0,0 +1,171 @@
+package controllers_test
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ginchat/models"
+	"github.com/ginchat/utils"
+	"gorm.io/gorm"
+)
+
+func TestConcurrentRegistrationSameEmail(t *testing.T) {
+	env := newAPIEnv(t)
+	const attempts = 2
+
+	// Hold each registration right after its "email taken?" lookup until all of them have done it, so
+	// they all pass that check and only the unique index can stop the duplicates
+	var arrived sync.WaitGroup
+	arrived.Add(attempts)
+	var lookups atomic.Int32
+	env.DB.Callback().Query().After("gorm:query").Register("test:race_registrations", func(tx *gorm.DB) {
+		if strings.Contains(tx.Statement.SQL.String(), "email = ") && lookups.Add(1) <= attempts {
+			arrived.Done()
+			arrived.Wait()
+		}
+	})
+
+	codes := make(chan int, attempts)
+	var done sync.WaitGroup
+	for i := range attempts {
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			// Same address in different case, since emails are normalized before saving
+			email := "Racer@Example.com"
+			if i%2 == 1 {
+				email = "racer@example.COM"
+			}
+			w := env.do(t, nil, http.MethodPost, "/api/auth/register", map[string]string{
+				"username": fmt.Sprintf("racer%d", i),
+				"email":    email,
+				"password": "Secret123!",
+			})
+			codes <- w.Code
+		}()
+	}
+	done.Wait()
+	close(codes)
+
+	count := map[int]int{}
+	for code := range codes {
+		count[code]++
+	}
+	if count[http.StatusCreated] != 1 || count[http.StatusConflict] != attempts-1 {
+		t.Errorf("status counts = %v, want one 201 and %d 409", count, attempts-1)
+	}
+	var stored int64
+	env.DB.Model(&models.User{}).Where("email = ?", "racer@example.com").Count(&stored)
+	if stored != 1 {
+		t.Errorf("%d users stored with the email, want 1", stored)
+	}
+}
+
+func TestLogoutRevokesToken(t *testing.T) {
+	env := newAPIEnv(t)
+	alice := env.user(t, "alice")
+	socketPath := func(user *apiUser) string { return "/api/ws?room_id=global_sidebar&token=" + user.Token }
+
+	expect(t, env.do(t, alice, http.MethodGet, "/api/chatrooms/user", nil), http.StatusOK, nil)
+	expect(t, env.do(t, alice, http.MethodPost, "/api/auth/logout", nil), http.StatusOK, nil)
+
+	t.Run("the revoked token is rejected", func(t *testing.T) {
+		expect(t, env.do(t, alice, http.MethodGet, "/api/chatrooms/user", nil), http.StatusUnauthorized, nil)
+		expect(t, env.do(t, nil, http.MethodGet, socketPath(alice), nil), http.StatusUnauthorized, nil)
+	})
+
+	t.Run("a fresh token is accepted", func(t *testing.T) {
+		token, err := utils.GenerateJWT(alice.ID, alice.Name, alice.Name+"@example.com", models.UserRoleMember)
+		if err != nil {
+			t.Fatalf("GenerateJWT: %v", err)
+		}
+		fresh := &apiUser{ID: alice.ID, Name: alice.Name, Token: token}
+		expect(t, env.do(t, fresh, http.MethodGet, "/api/chatrooms/user", nil), http.StatusOK, nil)
+		env.dial(t, fresh, "global_sidebar")
+	})
+}
+
+func TestLastSeenInMemberView(t *testing.T) {
+	env := newAPIEnv(t)
+	alice, bob := env.user(t, "alice"), env.user(t, "bob")
+	roomID := env.createRoom(t, alice, "General", bob)
+
+	memberLastSeen := func() map[uint]*time.Time {
+		var got struct {
+			Chatroom models.ChatroomResponse `json:"chatroom"`
+		}
+		expect(t, env.do(t, alice, http.MethodGet, "/api/chatrooms/"+roomID, nil), http.StatusOK, &got)
+		lastSeen := map[uint]*time.Time{}
+		for _, member := range got.Chatroom.Members {
+			lastSeen[member.UserID] = member.LastSeenAt
+		}
+		return lastSeen
+	}
+	if seen := memberLastSeen()[bob.ID]; seen != nil {
+		t.Errorf("bob was never active but was last seen at %v", seen)
+	}
+
+	// Connecting counts as activity and is saved in the background
+	before := time.Now().Add(-time.Second)
+	env.dial(t, bob, roomID)
+	deadline := time.Now().Add(2 * time.Second)
+	for memberLastSeen()[bob.ID] == nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if seen := memberLastSeen()[bob.ID]; seen == nil || seen.Before(before) {
+		t.Fatalf("bob's last seen after connecting = %v, want about now", seen)
+	}
+
+	var updated struct {
+		ShareLastSeen bool `json:"share_last_seen"`
+	}
+	expect(t, env.do(t, bob, http.MethodPut, "/api/users/last-seen-sharing", map[string]bool{"share_last_seen": false}), http.StatusOK, &updated)
+	if updated.ShareLastSeen {
+		t.Error("response still shares last seen")
+	}
+	if seen := memberLastSeen()[bob.ID]; seen != nil {
+		t.Errorf("bob opted out but was last seen at %v", seen)
+	}
+
+	expect(t, env.do(t, bob, http.MethodPut, "/api/users/last-seen-sharing", map[string]bool{"share_last_seen": true}), http.StatusOK, nil)
+	if memberLastSeen()[bob.ID] == nil {
+		t.Error("bob's last seen is hidden after opting back in")
+	}
+	expect(t, env.do(t, bob, http.MethodPut, "/api/users/last-seen-sharing", map[string]any{}), http.StatusBadRequest, nil)
+}
+
+func TestSearchUsersEndpoint(t *testing.T) {
+	env := newAPIEnv(t)
+	alice, bob := env.user(t, "alice"), env.user(t, "bob")
+	env.user(t, "alicia")
+
+	var got struct {
+		Users []map[string]any `json:"users"`
+	}
+	expect(t, env.do(t, alice, http.MethodGet, "/api/users/search?q=ali&exclude_self=true", nil), http.StatusOK, &got)
+	if len(got.Users) != 1 || got.Users[0]["username"] != "alicia" {
+		t.Errorf("users = %v, want only alicia", got.Users)
+	}
+	if _, leaked := got.Users[0]["email"]; leaked {
+		t.Error("search results include the email")
+	}
+	expect(t, env.do(t, nil, http.MethodGet, "/api/users/search?q=ali", nil), http.StatusUnauthorized, nil)
+	expect(t, env.do(t, alice, http.MethodGet, "/api/users/search?q=ali&limit=-1", nil), http.StatusBadRequest, nil)
+
+	// Searching is limited per user, so enumerating usernames is slow
+	for range 28 {
+		expect(t, env.do(t, alice, http.MethodGet, "/api/users/search?q=b", nil), http.StatusOK, nil)
+	}
+	w := env.do(t, alice, http.MethodGet, "/api/users/search?q=b", nil)
+	var limited apiError
+	expect(t, w, http.StatusTooManyRequests, &limited)
+	if limited.Code != "RATE_LIMITED" || w.Header().Get("Retry-After") == "" {
+		t.Errorf("envelope = %+v with Retry-After %q, want RATE_LIMITED with a retry time", limited, w.Header().Get("Retry-After"))
+	}
+	expect(t, env.do(t, bob, http.MethodGet, "/api/users/search?q=a", nil), http.StatusOK, nil)
+}
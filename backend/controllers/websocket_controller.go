@@ -2,41 +2,158 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
+	"net"
 	"net/http"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/ginchat/models"
 	"github.com/ginchat/utils"
 	"github.com/gorilla/websocket"
 	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-// SafeWebSocketConn wraps a WebSocket connection with a mutex for thread-safe writes
+// SafeWebSocketConn wraps a WebSocket connection with a per-connection send queue.
+// Broadcasts only enqueue, and a single writer goroutine drains the queue, so one slow
+// client can't hold up delivery to everyone else. A client whose queue stays full for
+// evictAfter is evicted.
 type SafeWebSocketConn struct {
-	conn *websocket.Conn
-	mu   sync.Mutex
+	conn       *websocket.Conn
+	mu         sync.Mutex // Serializes writes on conn (writer goroutine and control frames)
+	userID     uint
+	tokenID    string // jti of the token the connection authenticated with
+	logger     *logrus.Logger
+	send       chan outboundMessage
+	closed     chan struct{}
+	closeOnce  sync.Once
+	fullMu     sync.Mutex
+	fullSince  time.Time     // When the send queue was first found full; zero while there is room
+	evictAfter time.Duration // How long the queue may stay full before the client is evicted
 }
 
-// NewSafeWebSocketConn creates a new thread-safe WebSocket connection wrapper
-func NewSafeWebSocketConn(conn *websocket.Conn) *SafeWebSocketConn {
-	return &SafeWebSocketConn{
-		conn: conn,
+// outboundMessage is a frame waiting in a connection's send queue
+type outboundMessage struct {
+	messageType int
+	data        []byte
+}
+
+// Send queue errors
+var (
+	errSendQueueFull    = errors.New("send queue full")
+	errConnectionClosed = errors.New("connection closed")
+)
+
+// NewSafeWebSocketConn wraps conn and starts its writer goroutine
+func NewSafeWebSocketConn(conn *websocket.Conn, userID uint, tokenID string, logger *logrus.Logger, evictAfter time.Duration) *SafeWebSocketConn {
+	s := &SafeWebSocketConn{
+		conn:       conn,
+		userID:     userID,
+		tokenID:    tokenID,
+		logger:     logger,
+		send:       make(chan outboundMessage, sendQueueSize),
+		closed:     make(chan struct{}),
+		evictAfter: evictAfter,
 	}
+	go s.writePump()
+	return s
 }
 
-// WriteMessage safely writes a message to the WebSocket connection
+// WriteMessage queues a message for the connection without blocking.
+// It fails if the queue is full, and evicts the client once the queue has stayed full for evictAfter.
 func (s *SafeWebSocketConn) WriteMessage(messageType int, data []byte) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	return s.conn.WriteMessage(messageType, data)
+	select {
+	case <-s.closed:
+		return errConnectionClosed
+	default:
+	}
+
+	select {
+	case s.send <- outboundMessage{messageType: messageType, data: data}:
+		s.fullMu.Lock()
+		s.fullSince = time.Time{}
+		s.fullMu.Unlock()
+		return nil
+	default:
+	}
+
+	s.fullMu.Lock()
+	if s.fullSince.IsZero() {
+		s.fullSince = time.Now()
+	}
+	stalledFor := time.Since(s.fullSince)
+	s.fullMu.Unlock()
+
+	if stalledFor >= s.evictAfter {
+		s.evict(stalledFor)
+	}
+	return errSendQueueFull
+}
+
+// QueueDepth returns the number of messages waiting to be written
+func (s *SafeWebSocketConn) QueueDepth() int {
+	return len(s.send)
+}
+
+// writePump writes queued messages until the connection is closed or a write fails
+func (s *SafeWebSocketConn) writePump() {
+	for {
+		select {
+		case <-s.closed:
+			return
+		case msg := <-s.send:
+			s.mu.Lock()
+			s.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			err := s.conn.WriteMessage(msg.messageType, msg.data)
+			s.mu.Unlock()
+			if err != nil {
+				// Closing makes the reader fail too, which runs the normal disconnect cleanup
+				s.Close()
+				return
+			}
+		}
+	}
+}
+
+// evict closes a connection that stopped draining its send queue
+func (s *SafeWebSocketConn) evict(stalledFor time.Duration) {
+	select {
+	case <-s.closed:
+		return // Already closed
+	default:
+	}
+
+	utils.WebSocketSlowClientsEvictedTotal.Inc()
+	if s.logger != nil {
+		s.logger.Warnf("Evicting slow WebSocket client for user %d: send queue full for %s", s.userID, stalledFor.Round(time.Millisecond))
+	}
+	s.Close()
 }
 
-// Close safely closes the WebSocket connection
+// Close stops the writer goroutine and closes the underlying connection.
+// It doesn't wait for an in-flight write, so a stalled client can always be dropped.
 func (s *SafeWebSocketConn) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		err = s.conn.Close()
+	})
+	return err
+}
+
+// WriteClose sends a close frame with the given code and reason
+func (s *SafeWebSocketConn) WriteClose(code int, reason string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	return s.conn.Close()
+	return s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(writeWait))
+}
+
+// SetReadLimit caps the size of inbound messages; a larger one fails the read and the connection is closed
+// with a message-too-big close frame
+func (s *SafeWebSocketConn) SetReadLimit(limit int64) {
+	s.conn.SetReadLimit(limit)
 }
 
 // ReadMessage reads a message from the WebSocket connection (no mutex needed for reads)
@@ -44,6 +161,25 @@ func (s *SafeWebSocketConn) ReadMessage() (messageType int, p []byte, err error)
 	return s.conn.ReadMessage()
 }
 
+// WritePing sends a ping control frame with a write deadline.
+// Control frames may be written concurrently with WriteMessage, but we still take
+// the mutex so a ping never interleaves with a data frame on slow connections.
+func (s *SafeWebSocketConn) WritePing() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(writeWait))
+}
+
+// SetReadDeadline sets the read deadline on the underlying connection (reader goroutine only)
+func (s *SafeWebSocketConn) SetReadDeadline(t time.Time) error {
+	return s.conn.SetReadDeadline(t)
+}
+
+// SetPongHandler sets the handler for pong messages (invoked from the reader goroutine)
+func (s *SafeWebSocketConn) SetPongHandler(h func(appData string) error) {
+	s.conn.SetPongHandler(h)
+}
+
 // WebSocketController handles WebSocket connections
 type WebSocketController struct {
 	clients               map[uint]map[*SafeWebSocketConn]bool
@@ -53,19 +189,129 @@ type WebSocketController struct {
 	logger                *logrus.Logger
 	connectionAttempts    map[uint]time.Time
 	connectionAttemptsMux sync.RWMutex
+	pingInterval          time.Duration
+	pongTimeout           time.Duration
+	maxMessageSize        int64         // Largest inbound frame accepted, in bytes
+	slowClientEvictAfter  time.Duration // How long a client's send queue may stay full before it is disconnected
+	pendingUnread         map[uint]any  // Latest unread count update waiting to be flushed, per user
+	pendingUnreadMux      sync.Mutex
+	messageSender         MessageSender // Persists chat messages sent over the socket
+	readMarker            ReadMarker    // Handles mark_read events sent over the socket
+	unreadCounter         UnreadCounter // Answers get_unread_counts requests
+	lastActivity          map[uint]time.Time
+	lastActivityMux       sync.RWMutex
+	presence              PresenceRecorder                // Saves users' last seen time
+	membership            MembershipChecker               // Guards joining a chatroom's room; nil skips the check
+	revocations           TokenRevocationChecker          // Rejects revoked tokens; nil skips the check
+	lastSeenSaved         map[uint]time.Time              // When each user's last seen was last saved; guarded by lastActivityMux
+	sentMessages          map[sentMessageKey]*sentMessage // Recent chat_message results by client idempotency key
+	sentMessagesMux       sync.Mutex
+}
+
+// MessageSender persists a chat message and fans it out to the room (implemented by MessageController)
+type MessageSender interface {
+	SendSocketMessage(chatroomID primitive.ObjectID, userID uint, username, messageType, textContent, mediaURL string) (*models.Message, error)
 }
 
-// Global WebSocket controller instance for broadcasting messages
+// SetMessageSender sets the sender used to persist chat_message events received over the socket
+func (wsc *WebSocketController) SetMessageSender(sender MessageSender) {
+	wsc.messageSender = sender
+}
+
+// ReadMarker marks messages read for mark_read events and broadcasts the resulting updates
+// (implemented by MessageReadStatusController)
+type ReadMarker interface {
+	MarkSocketMessageRead(chatroomID, messageID primitive.ObjectID, userID uint) error
+	MarkSocketChatroomRead(chatroomID primitive.ObjectID, userID uint) error
+}
+
+// SetReadMarker sets the handler for mark_read events received over the socket
+func (wsc *WebSocketController) SetReadMarker(marker ReadMarker) {
+	wsc.readMarker = marker
+}
+
+// UnreadCounter computes a user's unread counts per chatroom (implemented by services.MessageReadStatusService)
+type UnreadCounter interface {
+	GetUnreadCountForUser(userID uint) ([]models.ChatroomUnreadCount, error)
+}
+
+// SetUnreadCounter sets where get_unread_counts requests received over the socket get their counts
+func (wsc *WebSocketController) SetUnreadCounter(counter UnreadCounter) {
+	wsc.unreadCounter = counter
+}
+
+// PresenceRecorder saves when a user was last active (implemented by services.UserService)
+type PresenceRecorder interface {
+	UpdateHeartbeat(userID uint) error
+}
+
+// SetPresenceRecorder sets where socket activity is saved as the user's last seen time
+func (wsc *WebSocketController) SetPresenceRecorder(recorder PresenceRecorder) {
+	wsc.presence = recorder
+}
+
+// MembershipChecker looks up whether a user belongs to a chatroom (implemented by services.ChatroomService)
+type MembershipChecker interface {
+	GetMembership(chatroomID primitive.ObjectID, userID uint) (*models.ChatroomMembership, error)
+}
+
+// SetMembershipChecker sets the check that keeps users out of rooms for chatrooms they aren't members of
+func (wsc *WebSocketController) SetMembershipChecker(checker MembershipChecker) {
+	wsc.membership = checker
+}
+
+// TokenRevocationChecker reports whether a token has been revoked (implemented by services.UserService)
+type TokenRevocationChecker interface {
+	IsTokenRevoked(tokenID string) bool
+}
+
+// SetTokenRevocationChecker sets the check that keeps revoked (e.g. logged-out) tokens from connecting
+func (wsc *WebSocketController) SetTokenRevocationChecker(checker TokenRevocationChecker) {
+	wsc.revocations = checker
+}
+
+// Global WebSocket controller instance for broadcasting messages.
+// Controllers prefer one injected with SetWebSocketController and only fall back to this.
 var GlobalWebSocketController *WebSocketController
 
-// NewWebSocketController creates a new WebSocketController
-func NewWebSocketController(logger *logrus.Logger) *WebSocketController {
+// wsHub gives a controller access to the WebSocket hub. Embed it and call hub() to broadcast:
+// every broadcast method is safe on a nil *WebSocketController, so a controller with no hub
+// (e.g. in tests, or before the hub exists at startup) simply skips real-time updates.
+type wsHub struct {
+	ws *WebSocketController
+}
+
+// SetWebSocketController injects the hub used for broadcasts instead of GlobalWebSocketController
+func (h *wsHub) SetWebSocketController(wsc *WebSocketController) {
+	h.ws = wsc
+}
+
+// hub returns the injected controller, or the global one if none was injected (may be nil)
+func (h *wsHub) hub() *WebSocketController {
+	if h.ws != nil {
+		return h.ws
+	}
+	return GlobalWebSocketController
+}
+
+// NewWebSocketController creates a new WebSocketController.
+// pingInterval must be shorter than pongTimeout; config.Load enforces this.
+// Inbound messages larger than maxMessageSize bytes close the connection.
+func NewWebSocketController(logger *logrus.Logger, pingInterval, pongTimeout time.Duration, maxMessageSize int64) *WebSocketController {
 	controller := &WebSocketController{
-		clients:            make(map[uint]map[*SafeWebSocketConn]bool),
-		rooms:              make(map[string]map[*SafeWebSocketConn]bool),
-		broadcast:          make(chan []byte),
-		logger:             logger,
-		connectionAttempts: make(map[uint]time.Time),
+		clients:              make(map[uint]map[*SafeWebSocketConn]bool),
+		rooms:                make(map[string]map[*SafeWebSocketConn]bool),
+		broadcast:            make(chan []byte),
+		logger:               logger,
+		connectionAttempts:   make(map[uint]time.Time),
+		pendingUnread:        make(map[uint]any),
+		lastActivity:         make(map[uint]time.Time),
+		lastSeenSaved:        make(map[uint]time.Time),
+		sentMessages:         make(map[sentMessageKey]*sentMessage),
+		pingInterval:         pingInterval,
+		pongTimeout:          pongTimeout,
+		maxMessageSize:       maxMessageSize,
+		slowClientEvictAfter: defaultSlowClientEvictAfter,
 	}
 
 	// Start broadcast handler
@@ -74,6 +320,9 @@ func NewWebSocketController(logger *logrus.Logger) *WebSocketController {
 	// Start a goroutine to periodically clean up old connection attempts
 	go controller.cleanupConnectionAttempts()
 
+	// Publish the deepest send queue for the metrics endpoint
+	go controller.reportQueueDepth()
+
 	// Set the global instance
 	GlobalWebSocketController = controller
 
@@ -87,6 +336,49 @@ type WebSocketMessage struct {
 	Data       any    `json:"data"`
 }
 
+// ChatMessagePayload is the data of a chat_message event sent by a client
+type ChatMessagePayload struct {
+	ClientMessageID string `json:"client_message_id"` // Client-generated idempotency key, echoed back in the ack
+	MessageType     string `json:"message_type"`      // Defaults to text
+	TextContent     string `json:"text_content"`
+	MediaURL        string `json:"media_url"`
+}
+
+// MarkReadPayload is the data of a mark_read event sent by a client: either one message or the whole chatroom
+type MarkReadPayload struct {
+	MessageID string `json:"message_id"` // Message to mark read
+	All       bool   `json:"all"`        // Mark every message in the chatroom read instead
+}
+
+// Actions carried by self_sync events
+const (
+	SelfSyncRead           = "read"            // Messages, or a whole chatroom when read_all is set, were marked read
+	SelfSyncMessageDeleted = "message_deleted" // The user deleted a message
+	SelfSyncChatroomJoined = "chatroom_joined" // The user created or joined a chatroom
+	SelfSyncChatroomLeft   = "chatroom_left"   // The user left a chatroom
+)
+
+// SelfSyncEvent is the data of a self_sync event. It goes only to the acting user's own connections,
+// so their other devices can mirror an action without waiting for room events or refetching.
+type SelfSyncEvent struct {
+	Action     string    `json:"action" example:"read"`
+	ChatroomID string    `json:"chatroom_id,omitempty" example:"60d5f8b8e6b5f0b3e8b4b5b3"`
+	MessageIDs []string  `json:"message_ids,omitempty"`
+	ReadAll    bool      `json:"read_all,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// SidebarUpdate is the data of a sidebar_update event: enough for a chat list to show a room's latest
+// message without the full message, which only connections viewing the room receive
+type SidebarUpdate struct {
+	MessageID   string    `json:"message_id" example:"60d5f8b8e6b5f0b3e8b4b5b3"`
+	SenderID    uint      `json:"sender_id" example:"1"`
+	SenderName  string    `json:"sender_name" example:"johndoe"`
+	MessageType string    `json:"message_type" example:"text"`
+	Preview     string    `json:"preview" example:"Hello, how are you?"`
+	SentAt      time.Time `json:"sent_at"`
+}
+
 // WebSocket connection upgrader
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
@@ -98,7 +390,32 @@ var upgrader = websocket.Upgrader{
 
 // Rate limiting constants
 const (
-	connectionCooldown = 1 * time.Second // Increased to 1 second to prevent connection storms
+	connectionCooldown   = 1 * time.Second        // Increased to 1 second to prevent connection storms
+	unreadCoalesceWindow = 200 * time.Millisecond // Rapid unread count updates for a user collapse into one send per window
+	recentActivityWindow = 60 * time.Second       // A user who sent anything over a socket within this window counts as active in the app
+	lastSeenSaveInterval = 60 * time.Second       // Socket activity updates the stored last seen time at most this often per user
+)
+
+// Inbound message rate limits, per connection
+const (
+	inboundBurst           = 20 // chat_message, typing and other frames a client may send back to back
+	inboundRatePerSecond   = 10
+	heartbeatBurst         = 5 // heartbeats have their own bucket so a chatty client can't starve its keep-alive
+	heartbeatRatePerSecond = 1
+	maxThrottledMessages   = 50 // dropped messages tolerated within throttleResetWindow before the connection is closed
+	unreadSnapshotBurst    = 3  // get_unread_counts recomputes every room's count, so it gets a much smaller allowance
+	unreadSnapshotPerMin   = 6
+	throttleResetWindow    = 10 * time.Second
+)
+
+// writeWait is the time allowed to write a single frame
+const writeWait = 10 * time.Second
+
+// Outbound backpressure, per connection
+const (
+	sendQueueSize               = 256             // Messages buffered for a client before new ones are dropped
+	defaultSlowClientEvictAfter = 5 * time.Second // A client whose queue stays full this long is disconnected
+	queueDepthReportInterval    = 5 * time.Second
 )
 
 // HandleConnection handles a WebSocket connection
@@ -107,31 +424,46 @@ func (wsc *WebSocketController) HandleConnection(c *gin.Context) {
 	// Always get token from query param
 	token := c.Query("token")
 	if token == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "No token provided"})
+		respondErrorMessage(c, http.StatusUnauthorized, "No token provided")
 		return
 	}
 
 	// Validate token
 	claims, err := utils.ValidateJWT(token)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+	if err != nil || (wsc.revocations != nil && wsc.revocations.IsTokenRevoked(claims.Id)) {
+		respondErrorMessage(c, http.StatusUnauthorized, "Invalid or expired token")
 		return
 	}
 	uid := claims.UserID
 
 	// Apply rate limiting for connection attempts
 	if !wsc.canConnect(uid) {
-		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many connection attempts, please wait"})
+		respondErrorMessage(c, http.StatusTooManyRequests, "Too many connection attempts, please wait")
 		return
 	}
 
 	// Get room ID
 	roomID := c.Query("room_id")
 	if roomID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No room ID provided"})
+		respondErrorMessage(c, http.StatusBadRequest, "No room ID provided")
 		return
 	}
 
+	// A chatroom's room receives its full messages, so only members may join it.
+	// Other rooms (e.g. global_sidebar) only get per-user events.
+	if chatroomID, err := primitive.ObjectIDFromHex(roomID); err == nil && wsc.membership != nil {
+		membership, err := wsc.membership.GetMembership(chatroomID, uid)
+		if err != nil {
+			apiErr := utils.ServiceAPIError(err)
+			c.JSON(apiErr.Status, apiErr)
+			return
+		}
+		if !membership.IsMember {
+			respondErrorMessage(c, http.StatusForbidden, "You are not a member of this chat room")
+			return
+		}
+	}
+
 	// Allow multiple connections per user (don't close existing connections)
 	// This allows both mobile app (chat room) and web app (sidebar) to connect simultaneously
 
@@ -143,7 +475,8 @@ func (wsc *WebSocketController) HandleConnection(c *gin.Context) {
 	}
 
 	// Wrap in SafeWebSocketConn
-	conn := NewSafeWebSocketConn(rawConn)
+	conn := NewSafeWebSocketConn(rawConn, uid, claims.Id, wsc.logger, wsc.slowClientEvictAfter)
+	conn.SetReadLimit(wsc.maxMessageSize)
 
 	// Register client
 	wsc.clientsMux.Lock()
@@ -158,6 +491,8 @@ func (wsc *WebSocketController) HandleConnection(c *gin.Context) {
 	}
 	wsc.rooms[roomID][conn] = true
 	wsc.clientsMux.Unlock()
+	utils.WebSocketConnections.Inc()
+	wsc.markActive(uid)
 
 	wsc.logger.Infof("User %d (chat room connection) connected to room %s via token-based WebSocket", uid, roomID)
 
@@ -186,6 +521,7 @@ func (wsc *WebSocketController) HandleConnection(c *gin.Context) {
 			delete(connections, conn)
 			if len(connections) == 0 {
 				delete(wsc.clients, uid)
+				wsc.clearActivity(uid)
 			}
 		}
 		// Remove from room map
@@ -197,26 +533,73 @@ func (wsc *WebSocketController) HandleConnection(c *gin.Context) {
 		}
 		wsc.clientsMux.Unlock()
 		conn.Close()
+		utils.WebSocketConnections.Dec()
 		wsc.logger.Infof("User %d (chat room connection) disconnected from room %s", uid, roomID)
 	}()
 
+	// Dead-connection detection: every pong or inbound message pushes the read deadline
+	// forward. A half-open connection stops answering pings, the deadline passes,
+	// ReadMessage fails and the deferred cleanup above removes it from the maps.
+	conn.SetReadDeadline(time.Now().Add(wsc.pongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsc.pongTimeout))
+	})
+
 	// Start ping-pong to keep connection alive
-	go wsc.pingClient(conn, uid)
+	done := make(chan struct{})
+	defer close(done)
+	go wsc.pingClient(conn, uid, done)
+
+	// Inbound rate limiting: excess frames are dropped, sustained flooding closes the connection
+	inboundLimiter := utils.NewTokenBucket(inboundBurst, inboundRatePerSecond)
+	heartbeatLimiter := utils.NewTokenBucket(heartbeatBurst, heartbeatRatePerSecond)
+	unreadSnapshotLimiter := utils.NewTokenBucket(unreadSnapshotBurst, unreadSnapshotPerMin/60.0)
+	throttled := 0
+	throttleWindowStart := time.Now()
 
 	// Handle incoming messages
 	for {
 		_, message, err := conn.ReadMessage()
 		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				wsc.logger.Warnf("User %d connection to room %s timed out waiting for pong, reaping", uid, roomID)
+			} else if errors.Is(err, websocket.ErrReadLimit) {
+				// gorilla/websocket has already sent a 1009 (message too big) close frame
+				wsc.logger.Warnf("User %d sent a message over %d bytes to room %s, closing", uid, wsc.maxMessageSize, roomID)
+			}
 			break
 		}
+		conn.SetReadDeadline(time.Now().Add(wsc.pongTimeout))
 
-		// Process message
+		// Process message (unparseable frames still count against the limit)
 		var msg WebSocketMessage
-		if err := json.Unmarshal(message, &msg); err != nil {
+		parseErr := json.Unmarshal(message, &msg)
+
+		limiter := inboundLimiter
+		if parseErr == nil && msg.Type == "heartbeat" {
+			limiter = heartbeatLimiter
+		}
+		if !limiter.Allow() {
+			if time.Since(throttleWindowStart) > throttleResetWindow {
+				throttled = 0
+				throttleWindowStart = time.Now()
+			}
+			throttled++
+			if throttled == 1 {
+				wsc.logger.Warnf("User %d is sending too many messages to room %s, throttling", uid, roomID)
+			}
+			if throttled > maxThrottledMessages {
+				wsc.logger.Warnf("User %d kept flooding room %s, closing connection", uid, roomID)
+				conn.WriteClose(websocket.ClosePolicyViolation, "rate limit exceeded")
+				break
+			}
 			continue
 		}
 
-		// Process message directly (removed duplicate prevention for simplicity)
+		if parseErr != nil {
+			continue
+		}
+		wsc.markActive(uid)
 
 		// Handle different message types
 		switch msg.Type {
@@ -230,18 +613,319 @@ func (wsc *WebSocketController) HandleConnection(c *gin.Context) {
 			heartbeatJSON, _ := json.Marshal(heartbeatMsg)
 			conn.WriteMessage(websocket.TextMessage, heartbeatJSON)
 		case "chat_message":
-			// Broadcast message only to the specified room
-			if msg.ChatroomID != "" {
-				wsc.clientsMux.RLock()
-				if clients, ok := wsc.rooms[msg.ChatroomID]; ok {
-					for client := range clients {
-						client.WriteMessage(websocket.TextMessage, message)
-					}
-				}
-				wsc.clientsMux.RUnlock()
+			// Persist through the message service and acknowledge to the sender
+			wsc.handleChatMessage(conn, uid, claims.Username, msg.ChatroomID, message)
+		case "mark_read":
+			// Read receipts without a REST round trip; the usual message_read and unread count updates follow
+			wsc.handleMarkRead(conn, uid, msg.ChatroomID, message)
+		case "get_unread_counts":
+			// Fresh snapshot for this connection only, e.g. when the app returns to the foreground
+			if !unreadSnapshotLimiter.Allow() {
+				wsc.replyUnreadCountsError(conn, "Too many unread count requests. Please wait a moment")
+				continue
 			}
+			wsc.handleGetUnreadCounts(conn, uid)
+		}
+	}
+}
+
+// handleChatMessage persists a chat_message sent over the socket and replies with an ack
+// (assigned message ID and sent_at) or a nack, both echoing the client's idempotency key.
+// The stored message reaches the room through the normal new_message broadcast.
+func (wsc *WebSocketController) handleChatMessage(conn *SafeWebSocketConn, uid uint, username, chatroomID string, raw []byte) {
+	var event struct {
+		Data ChatMessagePayload `json:"data"`
+	}
+	parseErr := json.Unmarshal(raw, &event)
+	payload := event.Data
+
+	reply := func(msgType string, data map[string]any) {
+		data["client_message_id"] = payload.ClientMessageID
+		replyJSON, _ := json.Marshal(WebSocketMessage{
+			Type:       msgType,
+			ChatroomID: chatroomID,
+			Data:       data,
+		})
+		conn.WriteMessage(websocket.TextMessage, replyJSON)
+	}
+
+	if parseErr != nil {
+		reply("nack", map[string]any{"error": "Please send a valid chat message"})
+		return
+	}
+
+	if wsc.messageSender == nil {
+		reply("nack", map[string]any{"error": "Sending messages over WebSocket is not available, please use the REST API"})
+		return
+	}
+
+	roomID, err := primitive.ObjectIDFromHex(chatroomID)
+	if err != nil {
+		reply("nack", map[string]any{"error": "Please provide a valid chat room ID"})
+		return
+	}
+
+	if payload.MessageType == "" {
+		payload.MessageType = "text"
+	}
+
+	// A resend of a message that was already stored (e.g. after a reconnect ate the ack) gets the
+	// original ack instead of creating the message twice
+	var sent *sentMessage
+	if payload.ClientMessageID != "" {
+		var original *sentMessage
+		sent, original = wsc.claimClientMessage(uid, payload.ClientMessageID)
+		if original != nil {
+			reply("ack", map[string]any{
+				"message_id": original.messageID,
+				"sent_at":    original.sentAt,
+			})
+			return
 		}
 	}
+
+	message, err := wsc.messageSender.SendSocketMessage(roomID, uid, username, payload.MessageType, payload.TextContent, payload.MediaURL)
+	if err != nil {
+		wsc.releaseClientMessage(uid, payload.ClientMessageID, sent)
+		wsc.logger.Warnf("Failed to send WebSocket chat message from user %d to room %s: %v", uid, chatroomID, err)
+		reply("nack", map[string]any{"error": utils.FormatServiceError(err)})
+		return
+	}
+	if sent != nil {
+		sent.finish(message.ID.Hex(), message.SentAt)
+	}
+
+	reply("ack", map[string]any{
+		"message_id": message.ID.Hex(),
+		"sent_at":    message.SentAt,
+	})
+}
+
+// sentMessageKeyTTL is how long a chat_message's client_message_id is remembered for replaying its ack
+const sentMessageKeyTTL = 10 * time.Minute
+
+// sentMessageKey identifies a chat_message by its sender and client-generated idempotency key
+type sentMessageKey struct {
+	userID          uint
+	clientMessageID string
+}
+
+// sentMessage is the outcome of a chat_message, kept so a resend with the same key can be answered
+// with the original ack. done is closed once the message is stored (or the attempt is given up).
+type sentMessage struct {
+	done      chan struct{}
+	messageID string
+	sentAt    time.Time
+	at        time.Time
+}
+
+func (m *sentMessage) isDone() bool {
+	select {
+	case <-m.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// finish records the stored message and wakes any resends waiting on it
+func (m *sentMessage) finish(messageID string, sentAt time.Time) {
+	m.messageID = messageID
+	m.sentAt = sentAt
+	m.at = time.Now()
+	close(m.done)
+}
+
+// claimClientMessage either reserves the key for a new send (returning the entry to finish) or, if a
+// message was already stored under it, returns that one. A resend arriving while the first attempt is
+// still in flight waits for it; if that attempt fails, the resend gets to try instead.
+func (wsc *WebSocketController) claimClientMessage(userID uint, clientMessageID string) (claimed, original *sentMessage) {
+	key := sentMessageKey{userID: userID, clientMessageID: clientMessageID}
+	for {
+		wsc.sentMessagesMux.Lock()
+		existing, ok := wsc.sentMessages[key]
+		if !ok || (existing.isDone() && time.Since(existing.at) > sentMessageKeyTTL) {
+			claimed = &sentMessage{done: make(chan struct{})}
+			wsc.sentMessages[key] = claimed
+			wsc.sentMessagesMux.Unlock()
+			return claimed, nil
+		}
+		wsc.sentMessagesMux.Unlock()
+
+		<-existing.done
+		if existing.messageID != "" {
+			return nil, existing
+		}
+	}
+}
+
+// releaseClientMessage gives up a claimed key after sending failed, so the client can retry with it
+func (wsc *WebSocketController) releaseClientMessage(userID uint, clientMessageID string, claimed *sentMessage) {
+	if claimed == nil {
+		return
+	}
+	wsc.sentMessagesMux.Lock()
+	key := sentMessageKey{userID: userID, clientMessageID: clientMessageID}
+	if wsc.sentMessages[key] == claimed {
+		delete(wsc.sentMessages, key)
+	}
+	wsc.sentMessagesMux.Unlock()
+	claimed.at = time.Now()
+	close(claimed.done)
+}
+
+// handleMarkRead handles a mark_read event and replies with mark_read_ack or mark_read_nack
+func (wsc *WebSocketController) handleMarkRead(conn *SafeWebSocketConn, uid uint, chatroomID string, raw []byte) {
+	var event struct {
+		Data MarkReadPayload `json:"data"`
+	}
+	_ = json.Unmarshal(raw, &event)
+	payload := event.Data
+
+	reply := func(msgType string, data map[string]any) {
+		data["message_id"] = payload.MessageID
+		data["all"] = payload.All
+		replyJSON, _ := json.Marshal(WebSocketMessage{
+			Type:       msgType,
+			ChatroomID: chatroomID,
+			Data:       data,
+		})
+		conn.WriteMessage(websocket.TextMessage, replyJSON)
+	}
+
+	if wsc.readMarker == nil {
+		reply("mark_read_nack", map[string]any{"error": "Marking messages read over WebSocket is not available, please use the REST API"})
+		return
+	}
+
+	roomID, err := primitive.ObjectIDFromHex(chatroomID)
+	if err != nil {
+		reply("mark_read_nack", map[string]any{"error": "Please provide a valid chat room ID"})
+		return
+	}
+
+	syncEvent := SelfSyncEvent{Action: SelfSyncRead, ChatroomID: chatroomID}
+	if payload.All {
+		err = wsc.readMarker.MarkSocketChatroomRead(roomID, uid)
+		syncEvent.ReadAll = true
+	} else {
+		messageID, parseErr := primitive.ObjectIDFromHex(payload.MessageID)
+		if parseErr != nil {
+			reply("mark_read_nack", map[string]any{"error": "Please provide a valid message ID"})
+			return
+		}
+		err = wsc.readMarker.MarkSocketMessageRead(roomID, messageID, uid)
+		syncEvent.MessageIDs = []string{payload.MessageID}
+	}
+	if err != nil {
+		wsc.logger.Warnf("Failed to mark messages read over WebSocket for user %d in room %s: %v", uid, chatroomID, err)
+		reply("mark_read_nack", map[string]any{"error": utils.FormatServiceError(err)})
+		return
+	}
+
+	reply("mark_read_ack", map[string]any{})
+	wsc.BroadcastSelfSync(uid, syncEvent, conn)
+}
+
+// handleGetUnreadCounts sends the user's current unread counts as an unread_count_update to the requesting
+// connection only, bypassing the coalescing used for pushed updates
+func (wsc *WebSocketController) handleGetUnreadCounts(conn *SafeWebSocketConn, uid uint) {
+	if wsc.unreadCounter == nil {
+		wsc.replyUnreadCountsError(conn, "Unread counts over WebSocket are not available, please use the REST API")
+		return
+	}
+
+	unreadCounts, err := wsc.unreadCounter.GetUnreadCountForUser(uid)
+	if err != nil {
+		wsc.logger.Warnf("Failed to get unread counts over WebSocket for user %d: %v", uid, err)
+		wsc.replyUnreadCountsError(conn, utils.FormatServiceError(err))
+		return
+	}
+
+	replyJSON, err := json.Marshal(WebSocketMessage{
+		Type: "unread_count_update",
+		Data: unreadCounts,
+	})
+	if err != nil {
+		wsc.logger.Errorf("Failed to marshal WebSocket message: %v", err)
+		return
+	}
+	conn.WriteMessage(websocket.TextMessage, replyJSON)
+}
+
+// replyUnreadCountsError tells the connection its get_unread_counts request failed
+func (wsc *WebSocketController) replyUnreadCountsError(conn *SafeWebSocketConn, message string) {
+	replyJSON, _ := json.Marshal(WebSocketMessage{
+		Type: "unread_counts_nack",
+		Data: map[string]any{"error": message},
+	})
+	conn.WriteMessage(websocket.TextMessage, replyJSON)
+}
+
+// markActive records that the user just did something over a socket (connect, heartbeat, message).
+// The user's last seen time is saved at most once per lastSeenSaveInterval.
+func (wsc *WebSocketController) markActive(uid uint) {
+	now := time.Now()
+	wsc.lastActivityMux.Lock()
+	wsc.lastActivity[uid] = now
+	save := now.Sub(wsc.lastSeenSaved[uid]) >= lastSeenSaveInterval
+	if save {
+		wsc.lastSeenSaved[uid] = now
+	}
+	wsc.lastActivityMux.Unlock()
+
+	if save {
+		wsc.saveLastSeen(uid)
+	}
+}
+
+// clearActivity forgets a user's activity once their last connection closes,
+// saving the last seen time so it reflects when they went offline
+func (wsc *WebSocketController) clearActivity(uid uint) {
+	wsc.lastActivityMux.Lock()
+	delete(wsc.lastActivity, uid)
+	delete(wsc.lastSeenSaved, uid)
+	wsc.lastActivityMux.Unlock()
+
+	wsc.saveLastSeen(uid)
+}
+
+// saveLastSeen writes the user's last seen time in the background
+func (wsc *WebSocketController) saveLastSeen(uid uint) {
+	if wsc.presence == nil {
+		return
+	}
+	go func() {
+		if err := wsc.presence.UpdateHeartbeat(uid); err != nil {
+			wsc.logger.Warnf("Failed to save last seen for user %d: %v", uid, err)
+		}
+	}()
+}
+
+// IsUserRecentlyActive reports whether the user has an open connection and was active within recentActivityWindow.
+// Pongs don't count, since clients answer pings even when the app is in the background.
+func (wsc *WebSocketController) IsUserRecentlyActive(uid uint) bool {
+	if wsc == nil {
+		return false
+	}
+
+	wsc.clientsMux.RLock()
+	_, connected := wsc.clients[uid]
+	wsc.clientsMux.RUnlock()
+	if !connected {
+		return false
+	}
+
+	wsc.lastActivityMux.RLock()
+	lastActivity, ok := wsc.lastActivity[uid]
+	wsc.lastActivityMux.RUnlock()
+
+	return ok && time.Since(lastActivity) < recentActivityWindow
+}
+
+// IsUserRecentlyActiveGlobal checks recent activity using the global controller
+func IsUserRecentlyActiveGlobal(uid uint) bool {
+	return GlobalWebSocketController.IsUserRecentlyActive(uid)
 }
 
 // canConnect checks if a user can connect (rate limiting)
@@ -276,18 +960,50 @@ func (wsc *WebSocketController) cleanupConnectionAttempts() {
 		}
 		wsc.connectionAttemptsMux.Unlock()
 
-		// Cleanup completed (removed processed messages tracking for simplicity)
+		wsc.sentMessagesMux.Lock()
+		for key, sent := range wsc.sentMessages {
+			if sent.isDone() && now.Sub(sent.at) > sentMessageKeyTTL {
+				delete(wsc.sentMessages, key)
+			}
+		}
+		wsc.sentMessagesMux.Unlock()
 	}
 }
 
-// pingClient sends periodic pings to keep the connection alive
-func (wsc *WebSocketController) pingClient(conn *SafeWebSocketConn, _ uint) {
-	ticker := time.NewTicker(90 * time.Second) // Increased to 90 seconds to avoid conflicts
+// reportQueueDepth periodically records the deepest send queue across all connections
+func (wsc *WebSocketController) reportQueueDepth() {
+	ticker := time.NewTicker(queueDepthReportInterval)
 	defer ticker.Stop()
 
 	for range ticker.C {
-		if err := conn.WriteMessage(websocket.PingMessage, []byte{}); err != nil {
+		maxDepth := 0
+		wsc.clientsMux.RLock()
+		for _, connections := range wsc.clients {
+			for conn := range connections {
+				if depth := conn.QueueDepth(); depth > maxDepth {
+					maxDepth = depth
+				}
+			}
+		}
+		wsc.clientsMux.RUnlock()
+
+		utils.WebSocketSendQueueMaxDepth.Set(float64(maxDepth))
+	}
+}
+
+// pingClient sends periodic pings to keep the connection alive until done is closed
+func (wsc *WebSocketController) pingClient(conn *SafeWebSocketConn, _ uint, done <-chan struct{}) {
+	ticker := time.NewTicker(wsc.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
 			return
+		case <-ticker.C:
+			if err := conn.WritePing(); err != nil {
+				return
+			}
 		}
 	}
 }
@@ -307,7 +1023,9 @@ func (wsc *WebSocketController) handleBroadcasts() {
 			wsc.clientsMux.RLock()
 			if clients, ok := wsc.rooms[msg.ChatroomID]; ok {
 				for client := range clients {
-					client.WriteMessage(websocket.TextMessage, message)
+					if err := client.WriteMessage(websocket.TextMessage, message); err != nil {
+						utils.WebSocketBroadcastErrorsTotal.Inc()
+					}
 				}
 			}
 			wsc.clientsMux.RUnlock()
@@ -315,44 +1033,54 @@ func (wsc *WebSocketController) handleBroadcasts() {
 	}
 }
 
-// BroadcastNewMessage broadcasts a new message to clients in a specific chatroom
-func (wsc *WebSocketController) BroadcastNewMessage(chatroomID string, message any) {
+// BroadcastNewMessage delivers a new message once to every connection viewing the chatroom, as new_message.
+// Members' connections elsewhere (other rooms, the global sidebar) get a lightweight sidebar_update instead,
+// so no connection sees the same message twice and the full message never leaves the room's members.
+func (wsc *WebSocketController) BroadcastNewMessage(chatroomID string, message models.MessageResponse, memberIDs []uint) {
 	if wsc == nil {
 		return // Safety check
 	}
 
-	// Create WebSocket message
-	wsMessage := WebSocketMessage{
+	jsonMessage, err := json.Marshal(WebSocketMessage{
 		Type:       "new_message",
 		ChatroomID: chatroomID,
 		Data:       message,
+	})
+	if err != nil {
+		wsc.logger.Errorf("Failed to marshal WebSocket message: %v", err)
+		return
 	}
-
-	// Marshal to JSON
-	jsonMessage, err := json.Marshal(wsMessage)
+	sidebarMessage, err := json.Marshal(WebSocketMessage{
+		Type:       "sidebar_update",
+		ChatroomID: chatroomID,
+		Data: SidebarUpdate{
+			MessageID:   message.ID,
+			SenderID:    message.SenderID,
+			SenderName:  message.SenderName,
+			MessageType: message.MessageType,
+			Preview:     message.Preview(),
+			SentAt:      message.SentAt,
+		},
+	})
 	if err != nil {
 		wsc.logger.Errorf("Failed to marshal WebSocket message: %v", err)
 		return
 	}
 
-	// Send to broadcast channel for room-specific broadcasting
+	// Room-specific delivery of the full message
 	wsc.broadcast <- jsonMessage
 
-	// Also send to all connected users for sidebar updates
 	wsc.clientsMux.RLock()
-	for userID, connections := range wsc.clients {
-		for conn := range connections {
-			func() {
-				defer func() {
-					if r := recover(); r != nil {
-						wsc.logger.Errorf("Panic while broadcasting new message to user %d: %v", userID, r)
-					}
-				}()
-				err := conn.WriteMessage(websocket.TextMessage, jsonMessage)
-				if err != nil {
-					wsc.logger.Errorf("Failed to send new message notification to user %d: %v", userID, err)
-				}
-			}()
+	inRoom := wsc.rooms[chatroomID]
+	for _, userID := range memberIDs {
+		for conn := range wsc.clients[userID] {
+			if inRoom[conn] {
+				continue // Already gets new_message through the room
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, sidebarMessage); err != nil {
+				utils.WebSocketBroadcastErrorsTotal.Inc()
+				wsc.logger.Errorf("Failed to send sidebar update to user %d: %v", userID, err)
+			}
 		}
 	}
 	wsc.clientsMux.RUnlock()
@@ -361,67 +1089,43 @@ func (wsc *WebSocketController) BroadcastNewMessage(chatroomID string, message a
 }
 
 // BroadcastNewMessageGlobal is a helper function to broadcast a message using the global controller
-func BroadcastNewMessageGlobal(chatroomID string, message any) {
+func BroadcastNewMessageGlobal(chatroomID string, message models.MessageResponse, memberIDs []uint) {
 	if GlobalWebSocketController != nil {
-		GlobalWebSocketController.BroadcastNewMessage(chatroomID, message)
+		GlobalWebSocketController.BroadcastNewMessage(chatroomID, message, memberIDs)
 	}
 }
 
-// BroadcastMessageRead broadcasts message read status updates to ALL connected clients (like new messages)
-func (wsc *WebSocketController) BroadcastMessageRead(chatroomID string, readData any) {
+// BroadcastMessageRead sends a message_read event to the chatroom's members, once per connection.
+// Read status says who read what, so non-members viewing the room get nothing.
+func (wsc *WebSocketController) BroadcastMessageRead(chatroomID string, readData any, memberIDs []uint) {
 	if wsc == nil {
 		return // Safety check
 	}
 
-	// Create WebSocket message
-	wsMessage := WebSocketMessage{
+	jsonMessage, err := json.Marshal(WebSocketMessage{
 		Type:       "message_read",
 		ChatroomID: chatroomID,
 		Data:       readData,
-	}
-
-	// Marshal to JSON
-	jsonMessage, err := json.Marshal(wsMessage)
+	})
 	if err != nil {
 		wsc.logger.Errorf("Failed to marshal WebSocket message: %v", err)
 		return
 	}
 
-	// FIXED: Send read status updates to ALL connected users (like new messages)
-	// This ensures message senders receive read status updates even if they're not in the chatroom
-	wsc.clientsMux.RLock()
-	totalConnections := 0
-	for userID, connections := range wsc.clients {
-		for conn := range connections {
-			func() {
-				defer func() {
-					if r := recover(); r != nil {
-						wsc.logger.Errorf("Panic while broadcasting read status to user %d: %v", userID, r)
-					}
-				}()
-				err := conn.WriteMessage(websocket.TextMessage, jsonMessage)
-				if err != nil {
-					wsc.logger.Errorf("Failed to send read status update to user %d: %v", userID, err)
-				} else {
-					totalConnections++
-				}
-			}()
-		}
-	}
-	wsc.clientsMux.RUnlock()
-
-	wsc.logger.Infof("Broadcasted message read status to ALL users (%d connections) for chatroom %s", totalConnections, chatroomID)
+	wsc.sendToMembers(memberIDs, jsonMessage, "read status update")
+	wsc.logger.Infof("Broadcasted message read status to chatroom %s", chatroomID)
 }
 
 // BroadcastMessageReadGlobal is a helper function to broadcast message read status using the global controller
-func BroadcastMessageReadGlobal(chatroomID string, readData any) {
+func BroadcastMessageReadGlobal(chatroomID string, readData any, memberIDs []uint) {
 	if GlobalWebSocketController != nil {
-		GlobalWebSocketController.BroadcastMessageRead(chatroomID, readData)
+		GlobalWebSocketController.BroadcastMessageRead(chatroomID, readData, memberIDs)
 	}
 }
 
-// BroadcastMessageUpdated broadcasts message update events to clients in a specific chatroom
-func (wsc *WebSocketController) BroadcastMessageUpdated(chatroomID string, messageData any) {
+// BroadcastMessageUpdated sends a message_updated event to the chatroom's viewers and to its members'
+// connections outside the room, so users who are not members never see it
+func (wsc *WebSocketController) BroadcastMessageUpdated(chatroomID string, messageData any, memberIDs []uint) {
 	if wsc == nil {
 		return // Safety check
 	}
@@ -443,37 +1147,22 @@ func (wsc *WebSocketController) BroadcastMessageUpdated(chatroomID string, messa
 	// Send to broadcast channel for room-specific broadcasting
 	wsc.broadcast <- jsonMessage
 
-	// Also send to all connected users for sidebar updates
-	wsc.clientsMux.RLock()
-	for userID, connections := range wsc.clients {
-		for conn := range connections {
-			func() {
-				defer func() {
-					if r := recover(); r != nil {
-						wsc.logger.Errorf("Panic while broadcasting message update to user %d: %v", userID, r)
-					}
-				}()
-				err := conn.WriteMessage(websocket.TextMessage, jsonMessage)
-				if err != nil {
-					wsc.logger.Errorf("Failed to send message update notification to user %d: %v", userID, err)
-				}
-			}()
-		}
-	}
-	wsc.clientsMux.RUnlock()
+	// Members elsewhere still need it for their sidebars
+	wsc.sendToMembersOutsideRoom(chatroomID, memberIDs, jsonMessage, "message update")
 
 	wsc.logger.Infof("Broadcasted message update to chatroom %s", chatroomID)
 }
 
 // BroadcastMessageUpdatedGlobal is a helper function to broadcast message updates using the global controller
-func BroadcastMessageUpdatedGlobal(chatroomID string, messageData any) {
+func BroadcastMessageUpdatedGlobal(chatroomID string, messageData any, memberIDs []uint) {
 	if GlobalWebSocketController != nil {
-		GlobalWebSocketController.BroadcastMessageUpdated(chatroomID, messageData)
+		GlobalWebSocketController.BroadcastMessageUpdated(chatroomID, messageData, memberIDs)
 	}
 }
 
-// BroadcastMessageDeleted broadcasts message deletion events to clients in a specific chatroom
-func (wsc *WebSocketController) BroadcastMessageDeleted(chatroomID string, deleteData any) {
+// BroadcastMessageDeleted sends a message_deleted event to the chatroom's viewers and to its members'
+// connections outside the room, so users who are not members never see it
+func (wsc *WebSocketController) BroadcastMessageDeleted(chatroomID string, deleteData any, memberIDs []uint) {
 	if wsc == nil {
 		return // Safety check
 	}
@@ -495,41 +1184,190 @@ func (wsc *WebSocketController) BroadcastMessageDeleted(chatroomID string, delet
 	// Send to broadcast channel for room-specific broadcasting
 	wsc.broadcast <- jsonMessage
 
-	// Also send to all connected users for sidebar updates
+	// Members elsewhere still need it for their sidebars
+	wsc.sendToMembersOutsideRoom(chatroomID, memberIDs, jsonMessage, "message deletion")
+
+	wsc.logger.Infof("Broadcasted message deletion to chatroom %s", chatroomID)
+}
+
+// BroadcastMessageDeletedGlobal is a helper function to broadcast message deletions using the global controller
+func BroadcastMessageDeletedGlobal(chatroomID string, deleteData any, memberIDs []uint) {
+	if GlobalWebSocketController != nil {
+		GlobalWebSocketController.BroadcastMessageDeleted(chatroomID, deleteData, memberIDs)
+	}
+}
+
+// sendToMembersOutsideRoom writes payload to every connection of the given members that is not
+// currently viewing the chatroom; viewers already receive it through the room broadcast.
+func (wsc *WebSocketController) sendToMembersOutsideRoom(chatroomID string, memberIDs []uint, payload []byte, what string) {
 	wsc.clientsMux.RLock()
-	for userID, connections := range wsc.clients {
-		for conn := range connections {
-			func() {
-				defer func() {
-					if r := recover(); r != nil {
-						wsc.logger.Errorf("Panic while broadcasting message deletion to user %d: %v", userID, r)
-					}
-				}()
-				err := conn.WriteMessage(websocket.TextMessage, jsonMessage)
-				if err != nil {
-					wsc.logger.Errorf("Failed to send message deletion notification to user %d: %v", userID, err)
-				}
-			}()
+	defer wsc.clientsMux.RUnlock()
+
+	inRoom := wsc.rooms[chatroomID]
+	for _, userID := range memberIDs {
+		for conn := range wsc.clients[userID] {
+			if inRoom[conn] {
+				continue
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+				utils.WebSocketBroadcastErrorsTotal.Inc()
+				wsc.logger.Errorf("Failed to send %s to user %d: %v", what, userID, err)
+			}
 		}
 	}
-	wsc.clientsMux.RUnlock()
+}
 
-	wsc.logger.Infof("Broadcasted message deletion to chatroom %s", chatroomID)
+// sendToMembers writes payload once to every connection of the given members, whichever room it is viewing.
+// Connections of other users, including non-members viewing the chatroom, get nothing.
+func (wsc *WebSocketController) sendToMembers(memberIDs []uint, payload []byte, what string) {
+	wsc.clientsMux.RLock()
+	defer wsc.clientsMux.RUnlock()
+
+	for _, userID := range memberIDs {
+		for conn := range wsc.clients[userID] {
+			if err := conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+				utils.WebSocketBroadcastErrorsTotal.Inc()
+				wsc.logger.Errorf("Failed to send %s to user %d: %v", what, userID, err)
+			}
+		}
+	}
 }
 
-// BroadcastMessageDeletedGlobal is a helper function to broadcast message deletions using the global controller
-func BroadcastMessageDeletedGlobal(chatroomID string, deleteData any) {
+// BroadcastChatroomCleared tells the chatroom's members, once per connection, that its message history was cleared
+func (wsc *WebSocketController) BroadcastChatroomCleared(chatroomID string, clearData any, memberIDs []uint) {
+	if wsc == nil {
+		return // Safety check
+	}
+
+	jsonMessage, err := json.Marshal(WebSocketMessage{
+		Type:       "chatroom_cleared",
+		ChatroomID: chatroomID,
+		Data:       clearData,
+	})
+	if err != nil {
+		wsc.logger.Errorf("Failed to marshal WebSocket message: %v", err)
+		return
+	}
+
+	wsc.sendToMembers(memberIDs, jsonMessage, "chatroom_cleared")
+	wsc.logger.Infof("Broadcasted chatroom_cleared to chatroom %s", chatroomID)
+}
+
+// BroadcastChatroomClearedGlobal is a helper function to broadcast chatroom clears using the global controller
+func BroadcastChatroomClearedGlobal(chatroomID string, clearData any, memberIDs []uint) {
+	if GlobalWebSocketController != nil {
+		GlobalWebSocketController.BroadcastChatroomCleared(chatroomID, clearData, memberIDs)
+	}
+}
+
+// BroadcastChatroomUpdated tells the chatroom's members, once per connection, that its details (description, topic) changed.
+// The details aren't public, so non-members viewing the room get nothing.
+func (wsc *WebSocketController) BroadcastChatroomUpdated(chatroomID string, chatroomData any, memberIDs []uint) {
+	if wsc == nil {
+		return // Safety check
+	}
+
+	jsonMessage, err := json.Marshal(WebSocketMessage{
+		Type:       "chatroom_updated",
+		ChatroomID: chatroomID,
+		Data:       chatroomData,
+	})
+	if err != nil {
+		wsc.logger.Errorf("Failed to marshal WebSocket message: %v", err)
+		return
+	}
+
+	wsc.sendToMembers(memberIDs, jsonMessage, "chatroom_updated")
+	wsc.logger.Infof("Broadcasted chatroom_updated to chatroom %s", chatroomID)
+}
+
+// BroadcastChatroomUpdatedGlobal is a helper function to broadcast chatroom updates using the global controller
+func BroadcastChatroomUpdatedGlobal(chatroomID string, chatroomData any, memberIDs []uint) {
+	if GlobalWebSocketController != nil {
+		GlobalWebSocketController.BroadcastChatroomUpdated(chatroomID, chatroomData, memberIDs)
+	}
+}
+
+// BroadcastMemberJoined tells the chatroom's members, once per connection, that a user joined it.
+// Members viewing other rooms or the sidebar get it too, so member counts stay current.
+func (wsc *WebSocketController) BroadcastMemberJoined(chatroomID string, memberData any, memberIDs []uint) {
+	wsc.broadcastMembershipEvent("member_joined", chatroomID, memberData, memberIDs)
+}
+
+// BroadcastMemberJoinedGlobal is a helper function to broadcast member joins using the global controller
+func BroadcastMemberJoinedGlobal(chatroomID string, memberData any, memberIDs []uint) {
 	if GlobalWebSocketController != nil {
-		GlobalWebSocketController.BroadcastMessageDeleted(chatroomID, deleteData)
+		GlobalWebSocketController.BroadcastMemberJoined(chatroomID, memberData, memberIDs)
+	}
+}
+
+// BroadcastMemberLeft tells the chatroom's remaining members, once per connection, that a user left it
+func (wsc *WebSocketController) BroadcastMemberLeft(chatroomID string, memberData any, memberIDs []uint) {
+	wsc.broadcastMembershipEvent("member_left", chatroomID, memberData, memberIDs)
+}
+
+// BroadcastMemberLeftGlobal is a helper function to broadcast member leaves using the global controller
+func BroadcastMemberLeftGlobal(chatroomID string, memberData any, memberIDs []uint) {
+	if GlobalWebSocketController != nil {
+		GlobalWebSocketController.BroadcastMemberLeft(chatroomID, memberData, memberIDs)
+	}
+}
+
+// broadcastMembershipEvent sends a member_joined/member_left event to the given members only;
+// who is in a room isn't public, so non-members viewing it get nothing
+func (wsc *WebSocketController) broadcastMembershipEvent(eventType, chatroomID string, memberData any, memberIDs []uint) {
+	if wsc == nil {
+		return // Safety check
+	}
+
+	jsonMessage, err := json.Marshal(WebSocketMessage{
+		Type:       eventType,
+		ChatroomID: chatroomID,
+		Data:       memberData,
+	})
+	if err != nil {
+		wsc.logger.Errorf("Failed to marshal WebSocket message: %v", err)
+		return
 	}
+
+	wsc.sendToMembers(memberIDs, jsonMessage, eventType)
+	wsc.logger.Infof("Broadcasted %s to chatroom %s", eventType, chatroomID)
 }
 
-// BroadcastUnreadCountUpdate broadcasts unread count updates to a specific user
+// BroadcastUnreadCountUpdate broadcasts unread count updates to a specific user.
+// Updates arriving within unreadCoalesceWindow are collapsed and only the latest value is sent.
 func (wsc *WebSocketController) BroadcastUnreadCountUpdate(userID uint, unreadData any) {
 	if wsc == nil {
 		return // Safety check
 	}
 
+	// Keep only the latest value; the first update in a window schedules the flush
+	wsc.pendingUnreadMux.Lock()
+	_, scheduled := wsc.pendingUnread[userID]
+	wsc.pendingUnread[userID] = unreadData
+	wsc.pendingUnreadMux.Unlock()
+
+	if !scheduled {
+		time.AfterFunc(unreadCoalesceWindow, func() {
+			wsc.flushUnreadCountUpdate(userID)
+		})
+	}
+}
+
+// flushUnreadCountUpdate sends the latest pending unread count update for a user
+func (wsc *WebSocketController) flushUnreadCountUpdate(userID uint) {
+	wsc.pendingUnreadMux.Lock()
+	unreadData, ok := wsc.pendingUnread[userID]
+	delete(wsc.pendingUnread, userID)
+	wsc.pendingUnreadMux.Unlock()
+
+	if ok {
+		wsc.sendUnreadCountUpdate(userID, unreadData)
+	}
+}
+
+// sendUnreadCountUpdate writes an unread count update to all of a user's connections
+func (wsc *WebSocketController) sendUnreadCountUpdate(userID uint, unreadData any) {
 	// Create WebSocket message
 	wsMessage := WebSocketMessage{
 		Type: "unread_count_update",
@@ -543,13 +1381,14 @@ func (wsc *WebSocketController) BroadcastUnreadCountUpdate(userID uint, unreadDa
 		return
 	}
 
-	// Send directly to user's connections (all rooms including sidebar)
+	// Send to each of the user's connections once, whichever room it is viewing (global_sidebar included)
 	wsc.clientsMux.RLock()
 	if connections, ok := wsc.clients[userID]; ok {
 		wsc.logger.Infof("Broadcasting unread count update to user %d (%d connections)", userID, len(connections))
 		for conn := range connections {
 			err := conn.WriteMessage(websocket.TextMessage, jsonMessage)
 			if err != nil {
+				utils.WebSocketBroadcastErrorsTotal.Inc()
 				wsc.logger.Errorf("Failed to send unread count update to user %d: %v", userID, err)
 			}
 		}
@@ -557,24 +1396,6 @@ func (wsc *WebSocketController) BroadcastUnreadCountUpdate(userID uint, unreadDa
 		wsc.logger.Warnf("No WebSocket connections found for user %d", userID)
 	}
 
-	// Also broadcast to global sidebar room for mobile apps
-	if clients, ok := wsc.rooms["global_sidebar"]; ok {
-		wsc.logger.Infof("Broadcasting unread count update to global_sidebar room (%d connections)", len(clients))
-		for conn := range clients {
-			// Check if this connection belongs to the target user
-			for connUserID, userConnections := range wsc.clients {
-				if connUserID == userID {
-					if _, hasConn := userConnections[conn]; hasConn {
-						err := conn.WriteMessage(websocket.TextMessage, jsonMessage)
-						if err != nil {
-							wsc.logger.Errorf("Failed to send unread count update to sidebar for user %d: %v", userID, err)
-						}
-						break
-					}
-				}
-			}
-		}
-	}
 	wsc.clientsMux.RUnlock()
 }
 
@@ -585,6 +1406,132 @@ func BroadcastUnreadCountUpdateGlobal(userID uint, unreadData any) {
 	}
 }
 
+// BroadcastSelfSync sends a self_sync event to every connection of userID except origin,
+// the connection the action came from (nil for actions made over the REST API)
+func (wsc *WebSocketController) BroadcastSelfSync(userID uint, event SelfSyncEvent, origin *SafeWebSocketConn) {
+	if wsc == nil {
+		return // Safety check
+	}
+
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	jsonMessage, err := json.Marshal(WebSocketMessage{
+		Type:       "self_sync",
+		ChatroomID: event.ChatroomID,
+		Data:       event,
+	})
+	if err != nil {
+		wsc.logger.Errorf("Failed to marshal WebSocket message: %v", err)
+		return
+	}
+
+	wsc.clientsMux.RLock()
+	defer wsc.clientsMux.RUnlock()
+	for conn := range wsc.clients[userID] {
+		if conn == origin {
+			continue
+		}
+		if err := conn.WriteMessage(websocket.TextMessage, jsonMessage); err != nil {
+			utils.WebSocketBroadcastErrorsTotal.Inc()
+			wsc.logger.Errorf("Failed to send self_sync %s to user %d: %v", event.Action, userID, err)
+		}
+	}
+}
+
+// NotifyUsers sends an event to every connection of the given users, whichever room they are viewing
+func (wsc *WebSocketController) NotifyUsers(userIDs []uint, eventType, chatroomID string, data any) {
+	if wsc == nil {
+		return // Safety check
+	}
+
+	jsonMessage, err := json.Marshal(WebSocketMessage{
+		Type:       eventType,
+		ChatroomID: chatroomID,
+		Data:       data,
+	})
+	if err != nil {
+		wsc.logger.Errorf("Failed to marshal WebSocket message: %v", err)
+		return
+	}
+
+	wsc.clientsMux.RLock()
+	defer wsc.clientsMux.RUnlock()
+	for _, userID := range userIDs {
+		for conn := range wsc.clients[userID] {
+			if err := conn.WriteMessage(websocket.TextMessage, jsonMessage); err != nil {
+				utils.WebSocketBroadcastErrorsTotal.Inc()
+				wsc.logger.Errorf("Failed to send %s to user %d: %v", eventType, userID, err)
+			}
+		}
+	}
+}
+
+// CloseTokenConnections closes every connection the user opened with the given token, e.g. after its session is revoked.
+// Closing makes each reader fail, which runs the normal disconnect cleanup.
+func (wsc *WebSocketController) CloseTokenConnections(userID uint, tokenID string) {
+	if wsc == nil || tokenID == "" {
+		return
+	}
+
+	wsc.clientsMux.RLock()
+	var conns []*SafeWebSocketConn
+	for conn := range wsc.clients[userID] {
+		if conn.tokenID == tokenID {
+			conns = append(conns, conn)
+		}
+	}
+	wsc.clientsMux.RUnlock()
+
+	for _, conn := range conns {
+		conn.WriteClose(websocket.ClosePolicyViolation, "session revoked")
+		conn.Close()
+	}
+	if len(conns) > 0 {
+		wsc.logger.Infof("Closed %d WebSocket connection(s) for revoked session of user %d", len(conns), userID)
+	}
+}
+
+// RemoveFromRoom takes a user's connections out of a chatroom's room when they stop being a member,
+// so they no longer receive its full messages. The connections stay open for per-user events.
+func (wsc *WebSocketController) RemoveFromRoom(roomID string, userID uint) {
+	if wsc == nil {
+		return
+	}
+
+	wsc.clientsMux.Lock()
+	defer wsc.clientsMux.Unlock()
+
+	clients, ok := wsc.rooms[roomID]
+	if !ok {
+		return
+	}
+	for conn := range wsc.clients[userID] {
+		delete(clients, conn)
+	}
+	if len(clients) == 0 {
+		delete(wsc.rooms, roomID)
+	}
+}
+
+// ConnectionStats returns how many users are connected and how many connections they have open in total
+func (wsc *WebSocketController) ConnectionStats() (users, connections int) {
+	if wsc == nil {
+		return 0, 0
+	}
+
+	wsc.clientsMux.RLock()
+	defer wsc.clientsMux.RUnlock()
+
+	for _, userConnections := range wsc.clients {
+		if len(userConnections) > 0 {
+			users++
+			connections += len(userConnections)
+		}
+	}
+	return users, connections
+}
+
 // GetConnectedUsersInRoom returns a list of user IDs currently connected to a specific room
 func (wsc *WebSocketController) GetConnectedUsersInRoom(roomID string) []uint {
 	if wsc == nil {
@@ -0,0 +1,453 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ginchat/models"
+	"github.com/ginchat/utils"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+	utils.ConfigureJWT("controllers-test-secret", time.Hour)
+}
+
+// newTestHub starts a WebSocket controller behind an httptest server on /ws
+func newTestHub(t *testing.T, pingInterval, pongTimeout time.Duration) (*WebSocketController, *httptest.Server) {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	wsc := NewWebSocketController(logger, pingInterval, pongTimeout, 64*1024)
+
+	router := gin.New()
+	router.GET("/ws", wsc.HandleConnection)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return wsc, server
+}
+
+// testToken issues a JWT for a test user
+func testToken(t *testing.T, userID uint, username string) string {
+	t.Helper()
+	token, err := utils.GenerateJWT(userID, username, username+"@example.com", "member")
+	if err != nil {
+		t.Fatalf("GenerateJWT: %v", err)
+	}
+	return token
+}
+
+// dialRaw connects userID to roomID and consumes the "connected" frame. Nothing reads the connection
+// afterwards, so it doesn't answer pings either.
+func dialRaw(t *testing.T, wsc *WebSocketController, server *httptest.Server, userID uint, roomID string) *websocket.Conn {
+	t.Helper()
+	// Tests open several connections per user back to back, so skip the reconnect cooldown
+	wsc.connectionAttemptsMux.Lock()
+	delete(wsc.connectionAttempts, userID)
+	wsc.connectionAttemptsMux.Unlock()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?token=" + testToken(t, userID, "user") + "&room_id=" + roomID
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial as user %d: %v", userID, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var connected testEvent
+	if err := conn.ReadJSON(&connected); err != nil || connected.Type != "connected" {
+		t.Fatalf("first frame = %+v, %v; want connected", connected, err)
+	}
+	conn.SetReadDeadline(time.Time{})
+	return conn
+}
+
+// dialSocket is dialRaw with a background reader, which also answers pings
+func dialSocket(t *testing.T, wsc *WebSocketController, server *httptest.Server, userID uint, roomID string) *testSocket {
+	t.Helper()
+	return readSocket(dialRaw(t, wsc, server, userID, roomID))
+}
+
+// testEvent is a decoded server frame
+type testEvent struct {
+	Type       string          `json:"type"`
+	ChatroomID string          `json:"chatroom_id"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// testSocket is a client connection whose frames are read in the background. A gorilla connection
+// can't be read again after a read times out, so tests wait on the events channel instead.
+type testSocket struct {
+	*websocket.Conn
+	events chan testEvent
+}
+
+// readSocket starts reading conn in the background; set any ping handler before calling it
+func readSocket(conn *websocket.Conn) *testSocket {
+	s := &testSocket{Conn: conn, events: make(chan testEvent, 256)}
+	go func() {
+		defer close(s.events)
+		for {
+			var event testEvent
+			if err := conn.ReadJSON(&event); err != nil {
+				return
+			}
+			s.events <- event
+		}
+	}()
+	return s
+}
+
+// collect returns the frames that arrive within wait, grouped by type
+func (s *testSocket) collect(wait time.Duration) map[string][]testEvent {
+	events := map[string][]testEvent{}
+	timeout := time.After(wait)
+	for {
+		select {
+		case event, ok := <-s.events:
+			if !ok {
+				return events
+			}
+			events[event.Type] = append(events[event.Type], event)
+		case <-timeout:
+			return events
+		}
+	}
+}
+
+// waitFor polls cond until it holds or a second passes
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSilentConnectionIsReaped(t *testing.T) {
+	wsc, server := newTestHub(t, 50*time.Millisecond, 200*time.Millisecond)
+
+	// A client that never reads never answers pings, like a half-open connection
+	dialRaw(t, wsc, server, 1, "global_sidebar")
+	if users, connections := wsc.ConnectionStats(); users != 1 || connections != 1 {
+		t.Fatalf("after connect: %d users, %d connections", users, connections)
+	}
+
+	waitFor(t, "the silent connection to be reaped", func() bool {
+		_, connections := wsc.ConnectionStats()
+		return connections == 0
+	})
+}
+
+func TestAnsweringConnectionIsKept(t *testing.T) {
+	wsc, server := newTestHub(t, 50*time.Millisecond, 200*time.Millisecond)
+	socket := dialSocket(t, wsc, server, 1, "global_sidebar")
+
+	// Reading lets the client answer pings with pongs, which extend the read deadline
+	socket.collect(500 * time.Millisecond)
+	if _, connections := wsc.ConnectionStats(); connections != 1 {
+		t.Fatalf("a connection answering pings was dropped")
+	}
+}
+
+func TestPingsFollowConfiguredInterval(t *testing.T) {
+	const interval = 40 * time.Millisecond
+	wsc, server := newTestHub(t, interval, time.Second)
+	conn := dialRaw(t, wsc, server, 1, "global_sidebar")
+
+	var mu sync.Mutex
+	var pings []time.Time
+	conn.SetPingHandler(func(data string) error {
+		mu.Lock()
+		pings = append(pings, time.Now())
+		mu.Unlock()
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	readSocket(conn).collect(10 * interval)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(pings) < 5 {
+		t.Fatalf("got %d pings in %s, want about 10", len(pings), 10*interval)
+	}
+	average := pings[len(pings)-1].Sub(pings[0]) / time.Duration(len(pings)-1)
+	if average < interval*3/4 || average > interval*2 {
+		t.Errorf("pings came every %s on average, want about %s", average, interval)
+	}
+}
+
+func TestUnreadCountUpdatesCoalesce(t *testing.T) {
+	wsc, server := newTestHub(t, time.Minute, 2*time.Minute)
+	sidebar := dialSocket(t, wsc, server, 1, "global_sidebar")
+	room := dialSocket(t, wsc, server, 1, "64b000000000000000000001")
+
+	// 50 updates in a burst, well inside one coalescing window
+	for i := 1; i <= 50; i++ {
+		wsc.BroadcastUnreadCountUpdate(1, map[string]int{"unread_count": i})
+	}
+
+	for name, socket := range map[string]*testSocket{"sidebar": sidebar, "room": room} {
+		updates := socket.collect(3 * unreadCoalesceWindow)["unread_count_update"]
+		if len(updates) != 1 {
+			t.Fatalf("%s connection got %d unread count updates, want 1", name, len(updates))
+		}
+		var latest struct {
+			UnreadCount int `json:"unread_count"`
+		}
+		if err := json.Unmarshal(updates[0].Data, &latest); err != nil || latest.UnreadCount != 50 {
+			t.Errorf("%s connection got %s, want the latest value (50)", name, updates[0].Data)
+		}
+	}
+
+	// A later update starts a new window and is delivered too
+	wsc.BroadcastUnreadCountUpdate(1, map[string]int{"unread_count": 51})
+	if updates := sidebar.collect(3 * unreadCoalesceWindow)["unread_count_update"]; len(updates) != 1 {
+		t.Errorf("got %d updates after the window, want 1", len(updates))
+	}
+}
+
+// countingSender stores chat messages in memory after delay, optionally failing the first failFirst sends
+type countingSender struct {
+	mu        sync.Mutex
+	sends     int
+	failFirst int
+	delay     time.Duration
+}
+
+func (s *countingSender) SendSocketMessage(chatroomID primitive.ObjectID, userID uint, username, messageType, textContent, mediaURL string) (*models.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sends++
+	if s.sends <= s.failFirst {
+		return nil, errors.New("failed to send message")
+	}
+	time.Sleep(s.delay)
+	return &models.Message{ID: primitive.NewObjectID(), ChatroomID: chatroomID, SenderID: userID, SentAt: time.Now()}, nil
+}
+
+func (s *countingSender) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sends
+}
+
+// chatReply is the data of an ack or nack
+type chatReply struct {
+	ClientMessageID string `json:"client_message_id"`
+	MessageID       string `json:"message_id"`
+	Error           string `json:"error"`
+}
+
+// sendChat writes a chat_message frame with the given data and returns the ack or nack that answers it
+func sendChat(t *testing.T, socket *testSocket, roomID, data string) (string, chatReply) {
+	t.Helper()
+	frame := `{"type":"chat_message","chatroom_id":"` + roomID + `","data":` + data + `}`
+	if err := socket.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+		t.Fatalf("write chat_message: %v", err)
+	}
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case event, ok := <-socket.events:
+			if !ok {
+				t.Fatal("connection closed before the reply")
+			}
+			if event.Type != "ack" && event.Type != "nack" {
+				continue
+			}
+			var reply chatReply
+			if err := json.Unmarshal(event.Data, &reply); err != nil {
+				t.Fatalf("decode %s: %v", event.Data, err)
+			}
+			return event.Type, reply
+		case <-timeout:
+			t.Fatal("no ack or nack for chat_message")
+		}
+	}
+}
+
+func TestChatMessageAckRoundTrip(t *testing.T) {
+	const roomID = "64b000000000000000000001"
+	wsc, server := newTestHub(t, time.Minute, 2*time.Minute)
+	// Slow enough that concurrent duplicates overlap with the first send
+	sender := &countingSender{failFirst: 1, delay: 20 * time.Millisecond}
+	wsc.SetMessageSender(sender)
+	socket := dialSocket(t, wsc, server, 1, roomID)
+
+	t.Run("a failed send can be retried with the same key", func(t *testing.T) {
+		if kind, reply := sendChat(t, socket, roomID, `{"client_message_id":"retry-1","text_content":"hi"}`); kind != "nack" || reply.ClientMessageID != "retry-1" {
+			t.Fatalf("first attempt got %s %+v, want a nack echoing the key", kind, reply)
+		}
+		if kind, reply := sendChat(t, socket, roomID, `{"client_message_id":"retry-1","text_content":"hi"}`); kind != "ack" || reply.MessageID == "" {
+			t.Fatalf("retry got %s %+v, want an ack", kind, reply)
+		}
+	})
+
+	t.Run("a resend replays the original ack", func(t *testing.T) {
+		before := sender.count()
+		kind, first := sendChat(t, socket, roomID, `{"client_message_id":"key-1","text_content":"hello"}`)
+		if kind != "ack" || first.MessageID == "" || first.ClientMessageID != "key-1" {
+			t.Fatalf("got %s %+v, want an ack with the message ID and key", kind, first)
+		}
+		kind, again := sendChat(t, socket, roomID, `{"client_message_id":"key-1","text_content":"hello"}`)
+		if kind != "ack" || again.MessageID != first.MessageID {
+			t.Errorf("resend got %s %+v, want the original ack for %s", kind, again, first.MessageID)
+		}
+		if sends := sender.count() - before; sends != 1 {
+			t.Errorf("message was stored %d times, want 1", sends)
+		}
+
+		// Another key is another message
+		if _, other := sendChat(t, socket, roomID, `{"client_message_id":"key-2","text_content":"hello"}`); other.MessageID == first.MessageID {
+			t.Error("a different key got the same message")
+		}
+	})
+
+	t.Run("concurrent resends from another connection store once", func(t *testing.T) {
+		other := dialSocket(t, wsc, server, 1, roomID)
+		before := sender.count()
+		for _, s := range []*testSocket{socket, other} {
+			frame := `{"type":"chat_message","chatroom_id":"` + roomID + `","data":{"client_message_id":"key-3","text_content":"once"}}`
+			if err := s.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+				t.Fatalf("write chat_message: %v", err)
+			}
+		}
+		acks := map[string]bool{}
+		for _, s := range []*testSocket{socket, other} {
+			for _, event := range s.collect(200 * time.Millisecond)["ack"] {
+				var reply chatReply
+				json.Unmarshal(event.Data, &reply)
+				acks[reply.MessageID] = true
+			}
+		}
+		if sends := sender.count() - before; sends != 1 || len(acks) != 1 {
+			t.Errorf("stored %d times with %d distinct acked IDs, want 1 and 1", sends, len(acks))
+		}
+	})
+
+	t.Run("malformed data gets a nack", func(t *testing.T) {
+		before := sender.count()
+		if kind, reply := sendChat(t, socket, roomID, `{"client_message_id":"bad","text_content":5}`); kind != "nack" || reply.Error == "" {
+			t.Errorf("got %s %+v, want a nack with an error", kind, reply)
+		}
+		if sender.count() != before {
+			t.Error("malformed data was sent")
+		}
+	})
+}
+
+func TestInboundFloodIsThrottled(t *testing.T) {
+	const roomID = "64b000000000000000000001"
+	wsc, server := newTestHub(t, time.Minute, 2*time.Minute)
+	wsc.SetMessageSender(&countingSender{})
+	flooder := dialSocket(t, wsc, server, 1, roomID)
+	polite := dialSocket(t, wsc, server, 2, roomID)
+
+	chat := []byte(`{"type":"chat_message","chatroom_id":"` + roomID + `","data":{"text_content":"spam"}}`)
+	heartbeat := []byte(`{"type":"heartbeat"}`)
+
+	// A burst just over the bucket: the excess is dropped, but heartbeats have their own allowance
+	for i := 0; i < inboundBurst+5; i++ {
+		flooder.WriteMessage(websocket.TextMessage, chat)
+	}
+	flooder.WriteMessage(websocket.TextMessage, heartbeat)
+	events := flooder.collect(300 * time.Millisecond)
+	if acks := len(events["ack"]); acks < inboundBurst || acks >= inboundBurst+5 {
+		t.Errorf("burst of %d got %d acks, want about %d", inboundBurst+5, acks, inboundBurst)
+	}
+	if len(events["heartbeat_ack"]) != 1 {
+		t.Error("heartbeat was dropped along with the flood")
+	}
+
+	// Sustained abuse closes the connection with a policy violation
+	for i := 0; i < 2*maxThrottledMessages; i++ {
+		if flooder.WriteMessage(websocket.TextMessage, chat) != nil {
+			break
+		}
+	}
+	flooder.collect(time.Second)
+	var closeErr *websocket.CloseError
+	if _, _, err := flooder.NextReader(); !errors.As(err, &closeErr) || closeErr.Code != websocket.ClosePolicyViolation {
+		t.Errorf("flooding connection ended with %v, want a policy violation close", err)
+	}
+
+	// The other client was never affected
+	for i := 0; i < 3; i++ {
+		if kind, _ := sendChat(t, polite, roomID, `{"text_content":"hello"}`); kind != "ack" {
+			t.Fatalf("well-behaved client got %s", kind)
+		}
+	}
+	if users, _ := wsc.ConnectionStats(); users != 1 {
+		t.Errorf("%d users still connected, want only the well-behaved one", users)
+	}
+}
+
+func TestStalledClientIsEvicted(t *testing.T) {
+	wsc, server := newTestHub(t, time.Minute, 2*time.Minute)
+	wsc.slowClientEvictAfter = 200 * time.Millisecond
+	dialRaw(t, wsc, server, 1, "global_sidebar") // Never reads, so its writer stalls once the socket buffers fill
+	reader := dialSocket(t, wsc, server, 2, "global_sidebar")
+	go func() {
+		for range reader.events {
+		}
+	}()
+	evictedBefore := testutil.ToFloat64(utils.WebSocketSlowClientsEvictedTotal)
+
+	// Large frames fill the kernel buffers quickly, then the send queue
+	payload := map[string]string{"filler": strings.Repeat("x", 32*1024)}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		wsc.NotifyUsers([]uint{1, 2}, "filler", "", payload)
+		if users, _ := wsc.ConnectionStats(); users == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("stalled client was never evicted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if evicted := testutil.ToFloat64(utils.WebSocketSlowClientsEvictedTotal) - evictedBefore; evicted != 1 {
+		t.Errorf("evictions counted = %v, want 1", evicted)
+	}
+	wsc.clientsMux.RLock()
+	_, readerKept := wsc.clients[2]
+	wsc.clientsMux.RUnlock()
+	if !readerKept {
+		t.Error("the reading client was disconnected along with the stalled one")
+	}
+}
+
+func TestOversizedFrameClosesConnection(t *testing.T) {
+	wsc, server := newTestHub(t, time.Minute, 2*time.Minute)
+	conn := dialRaw(t, wsc, server, 1, "global_sidebar")
+
+	frame := `{"type":"chat_message","data":{"text_content":"` + strings.Repeat("x", 64*1024) + `"}}`
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+		t.Fatalf("write oversized frame: %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err := conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Fatalf("read after oversized frame: %v, want a message-too-big close", err)
+	}
+	waitFor(t, "the oversized connection to be cleaned up", func() bool {
+		_, connections := wsc.ConnectionStats()
+		return connections == 0
+	})
+}
@@ -0,0 +1,431 @@
+package mongotest
+
+import (
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// pipeline runs aggregation stages over docs. vars holds $lookup "let" variables.
+func (s *Server) pipeline(docs []bson.D, stages bson.A, vars map[string]any) ([]bson.D, error) {
+	for _, raw := range stages {
+		stage := asDoc(raw)
+		if len(stage) != 1 {
+			return nil, &commandFailure{code: 40323, message: "a pipeline stage specification object must contain exactly one field"}
+		}
+		var err error
+		docs, err = s.stage(docs, stage[0].Key, stage[0].Value, vars)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return docs, nil
+}
+
+func (s *Server) stage(docs []bson.D, name string, spec any, vars map[string]any) ([]bson.D, error) {
+	switch name {
+	case "$match":
+		filter := asDoc(spec)
+		var out []bson.D
+		for _, doc := range docs {
+			ok, err := matches(doc, filter, vars)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				out = append(out, doc)
+			}
+		}
+		return out, nil
+	case "$sort":
+		return sortDocs(docs, asDoc(spec)), nil
+	case "$limit":
+		n, _ := toInt(spec)
+		if int64(len(docs)) > n {
+			docs = docs[:n]
+		}
+		return docs, nil
+	case "$project":
+		return projectAll(docs, asDoc(spec), vars)
+	case "$addFields", "$set":
+		out := make([]bson.D, 0, len(docs))
+		for _, doc := range docs {
+			next := doc
+			for _, e := range asDoc(spec) {
+				v, err := evalExpr(doc, e.Value, vars)
+				if err != nil {
+					return nil, err
+				}
+				if v == missing {
+					continue
+				}
+				if next, err = setPath(next, e.Key, v); err != nil {
+					return nil, err
+				}
+			}
+			out = append(out, next)
+		}
+		return out, nil
+	case "$group":
+		return group(docs, asDoc(spec), vars)
+	case "$unwind":
+		return unwind(docs, spec)
+	case "$lookup":
+		return s.lookupStage(docs, asDoc(spec), vars)
+	}
+	return nil, unsupported("aggregation stage %s", name)
+}
+
+// sortDocs stably sorts by a {field: 1|-1} spec
+func sortDocs(docs []bson.D, spec bson.D) []bson.D {
+	out := append([]bson.D{}, docs...)
+	sort.SliceStable(out, func(i, j int) bool {
+		return lessBySpec(out[i], out[j], spec)
+	})
+	return out
+}
+
+func lessBySpec(a, b bson.D, spec bson.D) bool {
+	for _, key := range spec {
+		dir, _ := toInt(key.Value)
+		if c := compare(sortKey(a, key.Key, dir), sortKey(b, key.Key, dir)); c != 0 {
+			if dir < 0 {
+				return c > 0
+			}
+			return c < 0
+		}
+	}
+	return false
+}
+
+// sortKey is the value a document sorts by: for arrays, the smallest element ascending and largest descending
+func sortKey(doc bson.D, path string, dir int64) any {
+	values := resolvePath(doc, strings.Split(path, "."))
+	var best any = missing
+	for _, v := range candidates(values) {
+		if _, isArr := v.(bson.A); isArr && len(values) == 1 && len(v.(bson.A)) > 0 {
+			continue
+		}
+		if best == missing || (dir >= 0 && compare(v, best) < 0) || (dir < 0 && compare(v, best) > 0) {
+			best = v
+		}
+	}
+	if best == missing {
+		return nil
+	}
+	return best
+}
+
+func projectAll(docs []bson.D, spec bson.D, vars map[string]any) ([]bson.D, error) {
+	out := make([]bson.D, 0, len(docs))
+	for _, doc := range docs {
+		projected, err := project(doc, spec, vars)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, projected)
+	}
+	return out, nil
+}
+
+// project applies a $project (or find projection) spec: inclusion with optional computed fields
+func project(doc bson.D, spec bson.D, vars map[string]any) (bson.D, error) {
+	if len(spec) == 0 {
+		return doc, nil
+	}
+	for _, e := range spec {
+		if e.Key != "_id" && isExclusion(e.Value) {
+			return nil, unsupported("exclusion projection of %s", e.Key)
+		}
+	}
+
+	out := bson.D{}
+	includeID := true
+	if v, ok := lookup(spec, "_id"); ok && isExclusion(v) {
+		includeID = false
+	}
+	if id, ok := lookup(doc, "_id"); ok && includeID {
+		if v, custom := lookup(spec, "_id"); !custom || isInclusion(v) {
+			out = append(out, bson.E{Key: "_id", Value: id})
+		}
+	}
+	for _, e := range spec {
+		if e.Key == "_id" && (isExclusion(e.Value) || isInclusion(e.Value)) {
+			continue
+		}
+		var v any
+		if isInclusion(e.Value) {
+			found, ok := lookupPath(doc, e.Key)
+			if !ok {
+				continue
+			}
+			v = found
+		} else if op, ok := projectionOperator(e.Value); ok {
+			var err error
+			if v, err = applyProjectionOperator(doc, e.Key, op); err != nil {
+				return nil, err
+			}
+			if v == missing {
+				continue
+			}
+		} else {
+			var err error
+			if v, err = evalExpr(doc, e.Value, vars); err != nil {
+				return nil, err
+			}
+			if v == missing {
+				continue
+			}
+		}
+		var err error
+		if out, err = setPath(out, e.Key, v); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// projectionOperator reports whether a projection value is the $elemMatch find projection operator
+func projectionOperator(v any) (bson.E, bool) {
+	spec := asDoc(v)
+	if len(spec) != 1 || spec[0].Key != "$elemMatch" {
+		return bson.E{}, false
+	}
+	return spec[0], true
+}
+
+// applyProjectionOperator keeps the first array element matching $elemMatch
+func applyProjectionOperator(doc bson.D, path string, op bson.E) (any, error) {
+	value, ok := lookupPath(doc, path)
+	list, isArray := value.(bson.A)
+	if !ok || !isArray {
+		return missing, nil
+	}
+
+	filter := bson.D{{Key: "v", Value: bson.D{{Key: "$elemMatch", Value: op.Value}}}}
+	for _, elem := range list {
+		// Test each element on its own by wrapping it in a one-element array
+		matched, err := matches(bson.D{{Key: "v", Value: bson.A{elem}}}, filter, nil)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			return bson.A{elem}, nil
+		}
+	}
+	return missing, nil
+}
+
+func isExclusion(v any) bool {
+	switch x := v.(type) {
+	case bool:
+		return !x
+	case int32, int64, int, float64:
+		return toFloat(x) == 0
+	}
+	return false
+}
+
+func isInclusion(v any) bool {
+	switch x := v.(type) {
+	case bool:
+		return x
+	case int32, int64, int, float64:
+		return toFloat(x) != 0
+	}
+	return false
+}
+
+type groupState struct {
+	id     any
+	fields bson.D
+}
+
+func group(docs []bson.D, spec bson.D, vars map[string]any) ([]bson.D, error) {
+	idExpr, _ := lookup(spec, "_id")
+	var groups []*groupState
+	for _, doc := range docs {
+		id, err := evalExpr(doc, idExpr, vars)
+		if err != nil {
+			return nil, err
+		}
+		if id == missing {
+			id = nil
+		}
+		var g *groupState
+		for _, existing := range groups {
+			if equal(existing.id, id) {
+				g = existing
+				break
+			}
+		}
+		if g == nil {
+			g = &groupState{id: id}
+			groups = append(groups, g)
+		}
+		for _, acc := range spec {
+			if acc.Key == "_id" {
+				continue
+			}
+			if err := accumulate(g, doc, acc, vars); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	out := make([]bson.D, 0, len(groups))
+	for _, g := range groups {
+		result := bson.D{{Key: "_id", Value: g.id}}
+		for _, acc := range spec {
+			if acc.Key == "_id" {
+				continue
+			}
+			v, _ := lookup(g.fields, acc.Key)
+			result = append(result, bson.E{Key: acc.Key, Value: v})
+		}
+		out = append(out, result)
+	}
+	return out, nil
+}
+
+func accumulate(g *groupState, doc bson.D, acc bson.E, vars map[string]any) error {
+	spec := asDoc(acc.Value)
+	if len(spec) != 1 {
+		return &commandFailure{code: 40238, message: "the field '" + acc.Key + "' must specify one accumulator"}
+	}
+	op := spec[0].Key
+	v, err := evalExpr(doc, spec[0].Value, vars)
+	if err != nil {
+		return err
+	}
+	current, started := lookup(g.fields, acc.Key)
+	put := func(value any) {
+		for i, f := range g.fields {
+			if f.Key == acc.Key {
+				g.fields[i].Value = value
+				return
+			}
+		}
+		g.fields = append(g.fields, bson.E{Key: acc.Key, Value: value})
+	}
+
+	switch op {
+	case "$sum":
+		if !started {
+			current = int32(0)
+		}
+		if isNumber(v) {
+			current = sumValues([]any{current, v})
+		}
+		put(current)
+	case "$min", "$max":
+		if isNullish(v) {
+			if !started {
+				put(nil)
+			}
+			return nil
+		}
+		if !started || current == nil || (op == "$min" && compare(v, current) < 0) || (op == "$max" && compare(v, current) > 0) {
+			put(v)
+		}
+	case "$first":
+		if !started {
+			if v == missing {
+				v = nil
+			}
+			put(v)
+		}
+	default:
+		return unsupported("accumulator %s", op)
+	}
+	return nil
+}
+
+func unwind(docs []bson.D, spec any) ([]bson.D, error) {
+	path, preserve := "", false
+	switch x := spec.(type) {
+	case string:
+		path = x
+	default:
+		d := asDoc(x)
+		p, _ := lookup(d, "path")
+		path, _ = p.(string)
+		keep, _ := lookup(d, "preserveNullAndEmptyArrays")
+		preserve = truthy(keep)
+	}
+	path = strings.TrimPrefix(path, "$")
+	var out []bson.D
+	for _, doc := range docs {
+		v, ok := lookupPath(doc, path)
+		arr, isArr := v.(bson.A)
+		switch {
+		case !ok || isNullish(v) || (isArr && len(arr) == 0):
+			if preserve {
+				out = append(out, doc)
+			}
+		case !isArr:
+			out = append(out, doc)
+		default:
+			for _, elem := range arr {
+				next, err := setPath(doc, path, elem)
+				if err != nil {
+					return nil, err
+				}
+				out = append(out, next)
+			}
+		}
+	}
+	return out, nil
+}
+
+func (s *Server) lookupStage(docs []bson.D, spec bson.D, vars map[string]any) ([]bson.D, error) {
+	from, _ := lookup(spec, "from")
+	as, _ := lookup(spec, "as")
+	let, _ := lookup(spec, "let")
+	sub, hasPipeline := lookup(spec, "pipeline")
+	if _, hasLocal := lookup(spec, "localField"); hasLocal {
+		return nil, unsupported("$lookup with localField")
+	}
+
+	var foreign []bson.D
+	if coll := s.collections[from.(string)]; coll != nil {
+		foreign = coll.docs
+	}
+
+	out := make([]bson.D, 0, len(docs))
+	for _, doc := range docs {
+		matched := foreign
+		if hasPipeline {
+			subVars := map[string]any{}
+			for k, v := range vars {
+				subVars[k] = v
+			}
+			for _, e := range asDoc(let) {
+				v, err := evalExpr(doc, e.Value, vars)
+				if err != nil {
+					return nil, err
+				}
+				if v == missing {
+					v = nil
+				}
+				subVars[e.Key] = v
+			}
+			var err error
+			if matched, err = s.pipeline(foreign, sub.(bson.A), subVars); err != nil {
+				return nil, err
+			}
+		}
+
+		arr := bson.A{}
+		for _, m := range matched {
+			arr = append(arr, m)
+		}
+		next, err := setPath(doc, as.(string), arr)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, next)
+	}
+	return out, nil
+}
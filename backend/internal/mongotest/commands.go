@@ -0,0 +1,437 @@
+package mongotest
+
+import (
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// collection is one collection's documents, in insertion order, and its unique indexes
+type collection struct {
+	docs    []bson.D
+	indexes []index
+}
+
+type index struct {
+	name   string
+	keys   bson.D
+	unique bool
+}
+
+func (s *Server) collection(name string) *collection {
+	coll := s.collections[name]
+	if coll == nil {
+		coll = &collection{}
+		s.collections[name] = coll
+	}
+	return coll
+}
+
+func (s *Server) execute(name, collName string, cmd bson.D) (bson.D, error) {
+	switch name {
+	case "find":
+		return s.find(collName, cmd)
+	case "aggregate":
+		return s.aggregate(collName, cmd)
+	case "count":
+		return s.count(collName, cmd)
+	case "distinct":
+		return s.distinct(collName, cmd)
+	case "insert":
+		return s.insert(collName, cmd)
+	case "update":
+		return s.update(collName, cmd)
+	case "delete":
+		return s.delete(collName, cmd)
+	case "findAndModify":
+		return s.findAndModify(collName, cmd)
+	case "createIndexes":
+		return s.createIndexes(collName, cmd)
+	case "ping", "endSessions", "killCursors", "hello", "isMaster", "buildInfo":
+		return bson.D{}, nil
+	}
+	return nil, unsupported("command %s", name)
+}
+
+func field(cmd bson.D, key string) any {
+	v, _ := lookup(cmd, key)
+	return v
+}
+
+func cursorReply(collName string, docs []bson.D) bson.D {
+	batch := bson.A{}
+	for _, doc := range docs {
+		batch = append(batch, doc)
+	}
+	return bson.D{{Key: "cursor", Value: bson.D{
+		{Key: "id", Value: int64(0)},
+		{Key: "ns", Value: "ginchat_test." + collName},
+		{Key: "firstBatch", Value: batch},
+	}}}
+}
+
+// matching returns the stored documents (not copies) matching filter, with their positions
+func (c *collection) matching(filter bson.D) ([]int, error) {
+	var positions []int
+	for i, doc := range c.docs {
+		ok, err := matches(doc, filter, nil)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			positions = append(positions, i)
+		}
+	}
+	return positions, nil
+}
+
+func (s *Server) find(collName string, cmd bson.D) (bson.D, error) {
+	coll := s.collection(collName)
+	positions, err := coll.matching(asDoc(field(cmd, "filter")))
+	if err != nil {
+		return nil, err
+	}
+	docs := make([]bson.D, len(positions))
+	for i, p := range positions {
+		docs[i] = cloneDoc(coll.docs[p])
+	}
+	if sortSpec := asDoc(field(cmd, "sort")); len(sortSpec) > 0 {
+		docs = sortDocs(docs, sortSpec)
+	}
+	if skip, ok := toInt(field(cmd, "skip")); ok && skip > 0 {
+		if skip >= int64(len(docs)) {
+			docs = nil
+		} else {
+			docs = docs[skip:]
+		}
+	}
+	if limit, ok := toInt(field(cmd, "limit")); ok && limit != 0 {
+		if limit < 0 {
+			limit = -limit
+		}
+		if limit < int64(len(docs)) {
+			docs = docs[:limit]
+		}
+	}
+	if projection := asDoc(field(cmd, "projection")); len(projection) > 0 {
+		if docs, err = projectAll(docs, projection, nil); err != nil {
+			return nil, err
+		}
+	}
+	return cursorReply(collName, docs), nil
+}
+
+func (s *Server) aggregate(collName string, cmd bson.D) (bson.D, error) {
+	coll := s.collection(collName)
+	docs := make([]bson.D, len(coll.docs))
+	for i, doc := range coll.docs {
+		docs[i] = cloneDoc(doc)
+	}
+	stages, _ := field(cmd, "pipeline").(bson.A)
+	out, err := s.pipeline(docs, stages, nil)
+	if err != nil {
+		return nil, err
+	}
+	return cursorReply(collName, out), nil
+}
+
+func (s *Server) count(collName string, cmd bson.D) (bson.D, error) {
+	positions, err := s.collection(collName).matching(asDoc(field(cmd, "query")))
+	if err != nil {
+		return nil, err
+	}
+	return bson.D{{Key: "n", Value: int32(len(positions))}}, nil
+}
+
+func (s *Server) distinct(collName string, cmd bson.D) (bson.D, error) {
+	coll := s.collection(collName)
+	positions, err := coll.matching(asDoc(field(cmd, "query")))
+	if err != nil {
+		return nil, err
+	}
+	key, _ := field(cmd, "key").(string)
+	values := bson.A{}
+	for _, p := range positions {
+		for _, v := range resolvePath(coll.docs[p], strings.Split(key, ".")) {
+			elems := []any{v}
+			if arr, ok := v.(bson.A); ok {
+				elems = arr
+			}
+			for _, elem := range elems {
+				duplicate := false
+				for _, existing := range values {
+					if equal(existing, elem) {
+						duplicate = true
+						break
+					}
+				}
+				if !duplicate {
+					values = append(values, elem)
+				}
+			}
+		}
+	}
+	return bson.D{{Key: "values", Value: values}}, nil
+}
+
+// writeError is one entry of a write command's writeErrors
+func writeError(i int, err error) bson.D {
+	code := int32(8000)
+	if failure, ok := err.(*commandFailure); ok {
+		code = failure.code
+	}
+	return bson.D{{Key: "index", Value: int32(i)}, {Key: "code", Value: code}, {Key: "errmsg", Value: err.Error()}}
+}
+
+func (s *Server) insert(collName string, cmd bson.D) (bson.D, error) {
+	coll := s.collection(collName)
+	docs, _ := field(cmd, "documents").(bson.A)
+	ordered := true
+	if v, ok := lookup(cmd, "ordered"); ok {
+		ordered = truthy(v)
+	}
+
+	inserted := 0
+	var writeErrors bson.A
+	for i, raw := range docs {
+		doc := cloneDoc(asDoc(raw))
+		if _, ok := lookup(doc, "_id"); !ok {
+			doc = append(bson.D{{Key: "_id", Value: primitive.NewObjectID()}}, doc...)
+		}
+		if err := coll.checkUnique(doc, -1, collName); err != nil {
+			writeErrors = append(writeErrors, writeError(i, err))
+			if ordered {
+				break
+			}
+			continue
+		}
+		coll.docs = append(coll.docs, doc)
+		inserted++
+	}
+	reply := bson.D{{Key: "n", Value: int32(inserted)}}
+	if writeErrors != nil {
+		reply = append(reply, bson.E{Key: "writeErrors", Value: writeErrors})
+	}
+	return reply, nil
+}
+
+// updateOne applies one update statement and reports what happened
+type updateResult struct {
+	matched, modified int
+	upsertedID        any
+	before, after     bson.D
+}
+
+func (s *Server) updateOne(collName string, query bson.D, update any, arrayFilters bson.A, multi, upsert bool) (updateResult, error) {
+	coll := s.collection(collName)
+	positions, err := coll.matching(query)
+	if err != nil {
+		return updateResult{}, err
+	}
+	if !multi && len(positions) > 1 {
+		positions = positions[:1]
+	}
+
+	var result updateResult
+	if len(positions) == 0 {
+		if !upsert {
+			return result, nil
+		}
+		seed, err := upsertSeed(query)
+		if err != nil {
+			return result, err
+		}
+		doc, err := applyUpdate(seed, update, query, arrayFilters, true)
+		if err != nil {
+			return result, err
+		}
+		if _, ok := lookup(doc, "_id"); !ok {
+			doc = append(bson.D{{Key: "_id", Value: primitive.NewObjectID()}}, doc...)
+		}
+		if err := coll.checkUnique(doc, -1, collName); err != nil {
+			return result, err
+		}
+		coll.docs = append(coll.docs, doc)
+		result.upsertedID, _ = lookup(doc, "_id")
+		result.after = doc
+		return result, nil
+	}
+
+	for _, p := range positions {
+		before := coll.docs[p]
+		after, err := applyUpdate(before, update, query, arrayFilters, false)
+		if err != nil {
+			return result, err
+		}
+		if err := coll.checkUnique(after, p, collName); err != nil {
+			return result, err
+		}
+		result.matched++
+		if compare(before, after) != 0 || len(before) != len(after) {
+			result.modified++
+			coll.docs[p] = after
+		}
+		result.before, result.after = before, coll.docs[p]
+	}
+	return result, nil
+}
+
+func (s *Server) update(collName string, cmd bson.D) (bson.D, error) {
+	statements, _ := field(cmd, "updates").(bson.A)
+	n, modified := 0, 0
+	var upserted, writeErrors bson.A
+	for i, raw := range statements {
+		stmt := asDoc(raw)
+		arrayFilters, _ := field(stmt, "arrayFilters").(bson.A)
+		result, err := s.updateOne(collName, asDoc(field(stmt, "q")), field(stmt, "u"), arrayFilters,
+			truthy(field(stmt, "multi")), truthy(field(stmt, "upsert")))
+		if err != nil {
+			writeErrors = append(writeErrors, writeError(i, err))
+			break
+		}
+		n += result.matched
+		modified += result.modified
+		if result.upsertedID != nil {
+			n++
+			upserted = append(upserted, bson.D{{Key: "index", Value: int32(i)}, {Key: "_id", Value: result.upsertedID}})
+		}
+	}
+	reply := bson.D{{Key: "n", Value: int32(n)}, {Key: "nModified", Value: int32(modified)}}
+	if upserted != nil {
+		reply = append(reply, bson.E{Key: "upserted", Value: upserted})
+	}
+	if writeErrors != nil {
+		reply = append(reply, bson.E{Key: "writeErrors", Value: writeErrors})
+	}
+	return reply, nil
+}
+
+func (s *Server) delete(collName string, cmd bson.D) (bson.D, error) {
+	coll := s.collection(collName)
+	statements, _ := field(cmd, "deletes").(bson.A)
+	n := 0
+	for _, raw := range statements {
+		stmt := asDoc(raw)
+		positions, err := coll.matching(asDoc(field(stmt, "q")))
+		if err != nil {
+			return nil, err
+		}
+		if limit, _ := toInt(field(stmt, "limit")); limit == 1 && len(positions) > 1 {
+			positions = positions[:1]
+		}
+		remove := map[int]bool{}
+		for _, p := range positions {
+			remove[p] = true
+		}
+		kept := coll.docs[:0:0]
+		for i, doc := range coll.docs {
+			if !remove[i] {
+				kept = append(kept, doc)
+			}
+		}
+		coll.docs = kept
+		n += len(positions)
+	}
+	return bson.D{{Key: "n", Value: int32(n)}}, nil
+}
+
+func (s *Server) findAndModify(collName string, cmd bson.D) (bson.D, error) {
+	if truthy(field(cmd, "remove")) || len(asDoc(field(cmd, "sort"))) > 0 {
+		return nil, unsupported("findAndModify with remove or sort")
+	}
+	projection := asDoc(field(cmd, "fields"))
+
+	arrayFilters, _ := field(cmd, "arrayFilters").(bson.A)
+	result, err := s.updateOne(collName, asDoc(field(cmd, "query")), field(cmd, "update"), arrayFilters, false, truthy(field(cmd, "upsert")))
+	if err != nil {
+		return nil, err
+	}
+	n := result.matched
+	if result.upsertedID != nil {
+		n = 1
+	}
+	lastError := bson.D{
+		{Key: "n", Value: int32(n)},
+		{Key: "updatedExisting", Value: result.matched > 0},
+	}
+	if result.upsertedID != nil {
+		lastError = append(lastError, bson.E{Key: "upserted", Value: result.upsertedID})
+	}
+	var value any
+	switch {
+	case truthy(field(cmd, "new")) && result.after != nil:
+		value = result.after
+	case result.matched > 0:
+		value = result.before
+	}
+
+	if doc, ok := value.(bson.D); ok {
+		projected, err := project(cloneDoc(doc), projection, nil)
+		if err != nil {
+			return nil, err
+		}
+		value = projected
+	}
+	return bson.D{{Key: "lastErrorObject", Value: lastError}, {Key: "value", Value: value}}, nil
+}
+
+func (s *Server) createIndexes(collName string, cmd bson.D) (bson.D, error) {
+	coll := s.collection(collName)
+	specs, _ := field(cmd, "indexes").(bson.A)
+	before := len(coll.indexes) + 1
+	for _, raw := range specs {
+		spec := asDoc(raw)
+		idx := index{keys: asDoc(field(spec, "key")), unique: truthy(field(spec, "unique"))}
+		idx.name, _ = field(spec, "name").(string) // The driver always names indexes
+		replaced := false
+		for i, existing := range coll.indexes {
+			if existing.name == idx.name {
+				coll.indexes[i] = idx
+				replaced = true
+			}
+		}
+		if !replaced {
+			coll.indexes = append(coll.indexes, idx)
+		}
+	}
+	return bson.D{
+		{Key: "numIndexesBefore", Value: int32(before)},
+		{Key: "numIndexesAfter", Value: int32(len(coll.indexes) + 1)},
+	}, nil
+}
+
+// checkUnique rejects doc if it collides on _id or a unique index with a document other than the one at self
+func (c *collection) checkUnique(doc bson.D, self int, collName string) error {
+	id, _ := lookup(doc, "_id")
+	indexes := append([]index{{name: "_id_", keys: bson.D{{Key: "_id", Value: 1}}, unique: true}}, c.indexes...)
+	for _, idx := range indexes {
+		if !idx.unique {
+			continue
+		}
+		key := indexKey(doc, idx.keys)
+		for i, other := range c.docs {
+			if i == self {
+				continue
+			}
+			if compare(indexKey(other, idx.keys), key) == 0 {
+				return &commandFailure{code: 11000, message: fmt.Sprintf(
+					"E11000 duplicate key error collection: ginchat_test.%s index: %s dup key: %v (_id %v)", collName, idx.name, key, id)}
+			}
+		}
+	}
+	return nil
+}
+
+func indexKey(doc bson.D, keys bson.D) bson.A {
+	key := bson.A{}
+	for _, k := range keys {
+		v, ok := lookupPath(doc, k.Key)
+		if !ok {
+			v = nil
+		}
+		key = append(key, v)
+	}
+	return key
+}
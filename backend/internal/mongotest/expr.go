@@ -0,0 +1,267 @@
+package mongotest
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// evalExpr evaluates an aggregation expression against doc
+func evalExpr(doc bson.D, expr any, vars map[string]any) (any, error) {
+	switch x := expr.(type) {
+	case string:
+		if strings.HasPrefix(x, "$$") {
+			return variable(doc, x[2:], vars)
+		}
+		if strings.HasPrefix(x, "$") {
+			return exprPath(doc, strings.Split(x[1:], ".")), nil
+		}
+		return x, nil
+	case bson.D, bson.M:
+		d := asDoc(x)
+		if len(d) == 1 && strings.HasPrefix(d[0].Key, "$") {
+			return evalOperator(doc, d[0].Key, d[0].Value, vars)
+		}
+		out := bson.D{}
+		for _, e := range d {
+			v, err := evalExpr(doc, e.Value, vars)
+			if err != nil {
+				return nil, err
+			}
+			if v != missing {
+				out = append(out, bson.E{Key: e.Key, Value: v})
+			}
+		}
+		return out, nil
+	}
+	return expr, nil
+}
+
+func variable(doc bson.D, name string, vars map[string]any) (any, error) {
+	parts := strings.Split(name, ".")
+	var v any
+	switch parts[0] {
+	case "ROOT", "CURRENT":
+		v = doc
+	default:
+		found, ok := vars[parts[0]]
+		if !ok {
+			return nil, &commandFailure{code: 17276, message: "use of undefined variable: " + parts[0]}
+		}
+		v = found
+	}
+	return exprPath(v, parts[1:]), nil
+}
+
+// exprPath follows a field path the way expressions do: over an array it maps to each element's value
+func exprPath(v any, parts []string) any {
+	if len(parts) == 0 {
+		return v
+	}
+	switch cur := v.(type) {
+	case bson.D:
+		next, ok := lookup(cur, parts[0])
+		if !ok {
+			return missing
+		}
+		return exprPath(next, parts[1:])
+	case bson.A:
+		out := bson.A{}
+		for _, elem := range cur {
+			if !isDoc(elem) {
+				continue
+			}
+			if r := exprPath(asDoc(elem), parts); r != missing {
+				out = append(out, r)
+			}
+		}
+		return out
+	}
+	return missing
+}
+
+func evalArgs(doc bson.D, arg any, vars map[string]any) ([]any, error) {
+	list, ok := arg.(bson.A)
+	if !ok {
+		list = bson.A{arg}
+	}
+	out := make([]any, len(list))
+	for i, elem := range list {
+		v, err := evalExpr(doc, elem, vars)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+func evalOperator(doc bson.D, op string, arg any, vars map[string]any) (any, error) {
+	if op == "$cond" {
+		return evalCond(doc, arg, vars)
+	}
+	args, err := evalArgs(doc, arg, vars)
+	if err != nil {
+		return nil, err
+	}
+	need := func(n int) error {
+		if len(args) != n {
+			return &commandFailure{code: 16020, message: fmt.Sprintf("expression %s takes exactly %d arguments", op, n)}
+		}
+		return nil
+	}
+
+	switch op {
+	case "$eq", "$ne", "$gt", "$gte", "$lt", "$lte":
+		if err := need(2); err != nil {
+			return nil, err
+		}
+		a, b := args[0], args[1]
+		if a == missing {
+			a = nil
+		}
+		if b == missing {
+			b = nil
+		}
+		c := compare(a, b)
+		switch op {
+		case "$eq":
+			return c == 0, nil
+		case "$ne":
+			return c != 0, nil
+		case "$gt":
+			return c > 0, nil
+		case "$gte":
+			return c >= 0, nil
+		case "$lt":
+			return c < 0, nil
+		}
+		return c <= 0, nil
+	case "$and":
+		for _, a := range args {
+			if !truthy(a) {
+				return false, nil
+			}
+		}
+		return true, nil
+	case "$ifNull":
+		for _, a := range args {
+			if !isNullish(a) {
+				return a, nil
+			}
+		}
+		return nil, nil
+	case "$size":
+		arr, ok := args[0].(bson.A)
+		if !ok {
+			return nil, &commandFailure{code: 17124, message: "the argument to $size must be an array"}
+		}
+		return int32(len(arr)), nil
+	case "$arrayElemAt":
+		if err := need(2); err != nil {
+			return nil, err
+		}
+		arr, ok := args[0].(bson.A)
+		if !ok {
+			return missing, nil
+		}
+		i, _ := toInt(args[1])
+		if i < 0 {
+			i += int64(len(arr))
+		}
+		if i < 0 || i >= int64(len(arr)) {
+			return missing, nil
+		}
+		return arr[i], nil
+	case "$add":
+		var date *primitive.DateTime
+		numbers := make([]any, 0, len(args))
+		for _, a := range args {
+			switch v := a.(type) {
+			case primitive.DateTime:
+				if date != nil {
+					return nil, &commandFailure{code: 16612, message: "only one date allowed in an $add expression"}
+				}
+				date = &v
+			default:
+				if isNullish(v) {
+					return nil, nil
+				}
+				if !isNumber(v) {
+					return nil, &commandFailure{code: 16554, message: "$add only supports numeric or date types"}
+				}
+				numbers = append(numbers, v)
+			}
+		}
+		sum := sumValues(numbers)
+		if date == nil {
+			return sum, nil
+		}
+		ms, ok := toInt(sum)
+		if !ok {
+			ms = int64(math.Round(toFloat(sum)))
+		}
+		return *date + primitive.DateTime(ms), nil
+	case "$indexOfArray":
+		if err := need(2); err != nil { // No start/end bounds
+			return nil, err
+		}
+		arr, ok := args[0].(bson.A)
+		if !ok {
+			return nil, nil
+		}
+		for i, elem := range arr {
+			if compare(elem, args[1]) == 0 {
+				return int32(i), nil
+			}
+		}
+		return int32(-1), nil
+	}
+	return nil, unsupported("expression operator %s", op)
+}
+
+func evalCond(doc bson.D, arg any, vars map[string]any) (any, error) {
+	d := asDoc(arg)
+	ifExpr, _ := lookup(d, "if")
+	thenExpr, _ := lookup(d, "then")
+	elseExpr, _ := lookup(d, "else")
+	test, err := evalExpr(doc, ifExpr, vars)
+	if err != nil {
+		return nil, err
+	}
+	if truthy(test) {
+		return evalExpr(doc, thenExpr, vars)
+	}
+	return evalExpr(doc, elseExpr, vars)
+}
+
+func sumValues(values []any) any {
+	var intSum int64
+	var floatSum float64
+	isFloat, isLong := false, false
+	for _, v := range values {
+		switch n := v.(type) {
+		case int32:
+			intSum += int64(n)
+		case int64:
+			intSum += n
+			isLong = true
+		case int:
+			intSum += int64(n)
+			isLong = true
+		case float64:
+			floatSum += n
+			isFloat = true
+		}
+	}
+	switch {
+	case isFloat:
+		return floatSum + float64(intSum)
+	case isLong || intSum > int64(^uint32(0)>>1) || intSum < -int64(^uint32(0)>>1)-1:
+		return intSum
+	}
+	return int32(intSum)
+}
@@ -0,0 +1,179 @@
+package mongotest
+
+import (
+	"regexp"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// matches reports whether doc satisfies a query filter. vars holds $lookup "let" variables for $expr.
+func matches(doc bson.D, filter bson.D, vars map[string]any) (bool, error) {
+	for _, e := range filter {
+		ok, err := matchElement(doc, e, vars)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchElement(doc bson.D, e bson.E, vars map[string]any) (bool, error) {
+	switch e.Key {
+	case "$and", "$or":
+		clauses, ok := e.Value.(bson.A)
+		if !ok {
+			return false, &commandFailure{code: 2, message: e.Key + " must be an array"}
+		}
+		for _, clause := range clauses {
+			ok, err := matches(doc, asDoc(clause), vars)
+			if err != nil {
+				return false, err
+			}
+			switch {
+			case e.Key == "$and" && !ok:
+				return false, nil
+			case e.Key == "$or" && ok:
+				return true, nil
+			}
+		}
+		return e.Key != "$or", nil
+	case "$expr":
+		v, err := evalExpr(doc, e.Value, vars)
+		if err != nil {
+			return false, err
+		}
+		return truthy(v), nil
+	}
+	if strings.HasPrefix(e.Key, "$") {
+		return false, unsupported("query operator %s", e.Key)
+	}
+	return matchField(doc, e.Key, e.Value)
+}
+
+// isOperatorDoc reports whether a filter value is {$op: ...} rather than a literal document
+func isOperatorDoc(v any) bool {
+	doc := asDoc(v)
+	return len(doc) > 0 && strings.HasPrefix(doc[0].Key, "$")
+}
+
+func matchField(doc bson.D, path string, cond any) (bool, error) {
+	values := resolvePath(doc, strings.Split(path, "."))
+	if !isOperatorDoc(cond) {
+		if re, ok := cond.(primitive.Regex); ok {
+			return matchRegex(values, re.Pattern, re.Options)
+		}
+		return matchEq(values, cond), nil
+	}
+	for _, op := range asDoc(cond) {
+		ok, err := matchOperator(values, op)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// candidates are the values a comparison is tried against: each reached value and, for arrays, their elements
+func candidates(values []any) []any {
+	var out []any
+	for _, v := range values {
+		out = append(out, v)
+		if arr, ok := v.(bson.A); ok {
+			out = append(out, arr...)
+		}
+	}
+	return out
+}
+
+func matchEq(values []any, target any) bool {
+	if len(values) == 0 {
+		return isNullish(target)
+	}
+	for _, v := range candidates(values) {
+		if equal(v, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchOperator(values []any, op bson.E) (bool, error) {
+	switch op.Key {
+	case "$ne":
+		return !matchEq(values, op.Value), nil
+	case "$in", "$nin":
+		list, ok := op.Value.(bson.A)
+		if !ok {
+			return false, &commandFailure{code: 2, message: op.Key + " needs an array"}
+		}
+		found := false
+		for _, target := range list {
+			if matchEq(values, target) {
+				found = true
+				break
+			}
+		}
+		return found == (op.Key == "$in"), nil
+	case "$gt", "$gte", "$lt", "$lte":
+		for _, v := range candidates(values) {
+			if typeRank(v) != typeRank(op.Value) {
+				continue
+			}
+			c := compare(v, op.Value)
+			if (op.Key == "$gt" && c > 0) || (op.Key == "$gte" && c >= 0) ||
+				(op.Key == "$lt" && c < 0) || (op.Key == "$lte" && c <= 0) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "$exists":
+		return (len(values) > 0) == truthy(op.Value), nil
+	case "$elemMatch":
+		sub := asDoc(op.Value)
+		for _, v := range values {
+			arr, ok := v.(bson.A)
+			if !ok {
+				continue
+			}
+			for _, elem := range arr {
+				if !isDoc(elem) {
+					continue
+				}
+				ok, err := matches(asDoc(elem), sub, nil)
+				if err != nil {
+					return false, err
+				}
+				if ok {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+	}
+	return false, unsupported("query operator %s", op.Key)
+}
+
+func matchRegex(values []any, pattern, options string) (bool, error) {
+	flags := ""
+	for _, o := range options {
+		switch o {
+		case 'i', 'm', 's':
+			flags += string(o)
+		}
+	}
+	if flags != "" {
+		pattern = "(?" + flags + ")" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return false, &commandFailure{code: 51091, message: "invalid regular expression: " + err.Error()}
+	}
+	for _, v := range candidates(values) {
+		if s, ok := v.(string); ok && re.MatchString(s) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,236 @@
+package mongotest
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type member struct {
+	UserID uint   `bson:"user_id"`
+	Role   string `bson:"role"`
+}
+
+type room struct {
+	ID      primitive.ObjectID `bson:"_id,omitempty"`
+	Name    string             `bson:"name"`
+	Members []member           `bson:"members"`
+}
+
+func TestCRUDThroughDriver(t *testing.T) {
+	db, server := NewDatabase(t)
+	ctx := context.Background()
+	rooms := db.Collection("chatrooms")
+
+	res, err := rooms.InsertOne(ctx, room{Name: "general", Members: []member{{UserID: 1, Role: "admin"}}})
+	if err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	id := res.InsertedID.(primitive.ObjectID)
+
+	update, err := rooms.UpdateOne(ctx,
+		bson.M{"_id": id, "members.user_id": bson.M{"$ne": uint(2)}},
+		bson.M{"$push": bson.M{"members": member{UserID: 2, Role: "member"}}})
+	if err != nil || update.ModifiedCount != 1 {
+		t.Fatalf("conditional push: %+v, %v", update, err)
+	}
+	if _, err := rooms.UpdateOne(ctx,
+		bson.M{"_id": id, "members.user_id": uint(2)},
+		bson.M{"$set": bson.M{"members.$.role": "readonly"}}); err != nil {
+		t.Fatalf("positional set: %v", err)
+	}
+
+	var got room
+	if err := rooms.FindOne(ctx, bson.M{"members": bson.M{"$elemMatch": bson.M{"user_id": uint(2), "role": "readonly"}}}).Decode(&got); err != nil {
+		t.Fatalf("find by elemMatch: %v", err)
+	}
+	if len(got.Members) != 2 || got.Members[1].Role != "readonly" {
+		t.Fatalf("members = %+v", got.Members)
+	}
+	if err := rooms.FindOne(ctx, bson.M{"name": "missing"}).Err(); err != mongo.ErrNoDocuments {
+		t.Fatalf("missing doc error = %v", err)
+	}
+
+	n, err := rooms.CountDocuments(ctx, bson.M{"members.user_id": bson.M{"$in": bson.A{uint(1), uint(9)}}})
+	if err != nil || n != 1 {
+		t.Fatalf("count = %d, %v", n, err)
+	}
+
+	cursor, err := rooms.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$project", Value: bson.M{"size": bson.M{"$size": "$members"}}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "total": bson.M{"$sum": "$size"}}}},
+	})
+	if err != nil {
+		t.Fatalf("aggregate: %v", err)
+	}
+	var totals []struct {
+		Total int `bson:"total"`
+	}
+	if err := cursor.All(ctx, &totals); err != nil || len(totals) != 1 || totals[0].Total != 2 {
+		t.Fatalf("totals = %+v, %v", totals, err)
+	}
+	if len(server.Commands()) == 0 {
+		t.Fatal("commands were not recorded")
+	}
+}
+
+func TestUniqueIndexAndUpsert(t *testing.T) {
+	db, _ := NewDatabase(t)
+	ctx := context.Background()
+	coll := db.Collection("pointers")
+
+	if _, err := coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "chatroom_id", Value: 1}},
+		Options: options.Index().SetUnique(true).SetName("user_room_idx"),
+	}); err != nil {
+		t.Fatalf("create index: %v", err)
+	}
+	if _, err := coll.InsertOne(ctx, bson.M{"user_id": 1, "chatroom_id": "a"}); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	_, err := coll.InsertOne(ctx, bson.M{"user_id": 1, "chatroom_id": "a"})
+	if !mongo.IsDuplicateKeyError(err) {
+		t.Fatalf("duplicate insert error = %v", err)
+	}
+
+	res, err := coll.UpdateOne(ctx, bson.M{"user_id": 2, "chatroom_id": "a"},
+		bson.M{"$set": bson.M{"count": 1}, "$setOnInsert": bson.M{"created": true}},
+		options.Update().SetUpsert(true))
+	if err != nil || res.UpsertedCount != 1 {
+		t.Fatalf("upsert: %+v, %v", res, err)
+	}
+	var doc bson.M
+	if err := coll.FindOne(ctx, bson.M{"user_id": 2}).Decode(&doc); err != nil {
+		t.Fatalf("find upserted: %v", err)
+	}
+	if doc["chatroom_id"] != "a" || doc["created"] != true {
+		t.Fatalf("upserted doc = %v", doc)
+	}
+}
+
+func TestIncrement(t *testing.T) {
+	db, _ := NewDatabase(t)
+	ctx := context.Background()
+	coll := db.Collection("counters")
+
+	// A guarded upsert creates the counter, increments it below the bound and collides on _id at the bound
+	guarded := func() error {
+		_, err := coll.UpdateOne(ctx, bson.M{"_id": 1, "count": bson.M{"$lt": 2}},
+			bson.M{"$inc": bson.M{"count": 1}}, options.Update().SetUpsert(true))
+		return err
+	}
+	for i := 0; i < 2; i++ {
+		if err := guarded(); err != nil {
+			t.Fatalf("increment %d: %v", i, err)
+		}
+	}
+	if err := guarded(); !mongo.IsDuplicateKeyError(err) {
+		t.Fatalf("increment past the bound: error = %v", err)
+	}
+
+	if _, err := coll.UpdateOne(ctx, bson.M{"_id": 1}, bson.M{"$inc": bson.M{"count": -1}}); err != nil {
+		t.Fatalf("decrement: %v", err)
+	}
+	var doc struct {
+		Count int `bson:"count"`
+	}
+	if err := coll.FindOne(ctx, bson.M{"_id": 1}).Decode(&doc); err != nil || doc.Count != 1 {
+		t.Fatalf("count = %d, %v; want 1", doc.Count, err)
+	}
+
+	if _, err := coll.UpdateOne(ctx, bson.M{"_id": 1}, bson.M{"$inc": bson.M{"count": "one"}}); err == nil {
+		t.Fatal("incrementing by a string succeeded")
+	}
+}
+
+func TestProjectionOperators(t *testing.T) {
+	db, _ := NewDatabase(t)
+	coll := db.Collection("rooms")
+	ctx := context.Background()
+	_, err := coll.InsertOne(ctx, bson.M{"_id": 1, "members": bson.A{
+		bson.M{"user_id": 1, "role": "admin"},
+		bson.M{"user_id": 2, "role": "member"},
+		bson.M{"user_id": 3, "role": "member"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got struct {
+		Members []struct {
+			UserID int `bson:"user_id"`
+		} `bson:"members"`
+	}
+	opts := options.FindOne().SetProjection(bson.M{"members": bson.M{"$elemMatch": bson.M{"user_id": 2}}})
+	if err := coll.FindOne(ctx, bson.M{"_id": 1}, opts).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Members) != 1 || got.Members[0].UserID != 2 {
+		t.Errorf("$elemMatch projection = %+v, want only user 2", got.Members)
+	}
+
+	got.Members = nil
+	opts = options.FindOne().SetProjection(bson.M{"members": bson.M{"$elemMatch": bson.M{"user_id": 9}}})
+	if err := coll.FindOne(ctx, bson.M{"_id": 1}, opts).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Members) != 0 {
+		t.Errorf("$elemMatch with no match = %+v, want the field left out", got.Members)
+	}
+
+	// Operators the services don't use fail loudly instead of projecting the wrong fields
+	opts = options.FindOne().SetProjection(bson.M{"members": bson.M{"$slice": -2}})
+	if err := coll.FindOne(ctx, bson.M{"_id": 1}, opts).Err(); err == nil || !strings.Contains(err.Error(), "unsupported") {
+		t.Errorf("$slice projection error = %v, want unsupported", err)
+	}
+}
+
+func TestArrayAndDateExpressions(t *testing.T) {
+	db, _ := NewDatabase(t)
+	coll := db.Collection("rooms")
+	ctx := context.Background()
+	joined := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	_, err := coll.InsertOne(ctx, bson.M{"_id": 1, "joined_at": joined, "members": bson.A{
+		bson.M{"user_id": 1, "role": "admin"},
+		bson.M{"user_id": 2, "role": "member"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cursor, err := coll.Aggregate(ctx, []bson.M{{"$project": bson.M{
+		"second": bson.M{"$indexOfArray": bson.A{"$members.user_id", 2}},
+		"absent": bson.M{"$indexOfArray": bson.A{"$members.user_id", 9}},
+		"role": bson.M{"$arrayElemAt": bson.A{
+			"$members.role",
+			bson.M{"$indexOfArray": bson.A{"$members.user_id", 2}},
+		}},
+		"before": bson.M{"$add": bson.A{"$joined_at", -1}},
+		"sum":    bson.M{"$add": bson.A{1, 2, 3}},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []struct {
+		Second int32     `bson:"second"`
+		Absent int32     `bson:"absent"`
+		Role   string    `bson:"role"`
+		Before time.Time `bson:"before"`
+		Sum    int32     `bson:"sum"`
+	}
+	if err := cursor.All(ctx, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Second != 1 || got[0].Absent != -1 || got[0].Role != "member" {
+		t.Fatalf("$indexOfArray = %+v, want second 1, absent -1, role member", got)
+	}
+	if !got[0].Before.Equal(joined.Add(-time.Millisecond)) || got[0].Sum != 6 {
+		t.Errorf("$add = %v and %d, want a millisecond before %v and 6", got[0].Before, got[0].Sum, joined)
+	}
+}
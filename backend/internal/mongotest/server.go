@@ -0,0 +1,297 @@
+// Package mongotest runs an in-memory stand-in for MongoDB behind the real driver, so service and
+// controller tests can use *mongo.Database without a server. It implements the commands and the
+// query, update and aggregation operators the services use; anything else fails loudly with an
+// "unsupported" error rather than returning wrong results.
+//
+// It exists so `go test ./...` needs nothing but Go: the driver has no in-memory mode, and CI and
+// contributors' machines have no mongod or container runtime to start one. Because it speaks
+// OP_MSG, the services run through the same driver code as in production, and the recorded
+// commands let tests assert what reached the database. When a service starts using a new command
+// or operator, add it here together with a test.
+package mongotest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/address"
+	"go.mongodb.org/mongo-driver/mongo/description"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+	"go.mongodb.org/mongo-driver/x/mongo/driver"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/wiremessage"
+)
+
+// Command is a command the server received
+type Command struct {
+	Name       string
+	Collection string
+	Body       bson.D
+}
+
+// Server is the in-memory deployment. Each command runs under one lock, so single-document
+// updates are atomic the way they are on a real server.
+type Server struct {
+	mu          sync.Mutex
+	collections map[string]*collection
+	commands    []Command
+	failures    map[string]error
+	updates     chan description.Topology
+}
+
+// NewDatabase returns a database whose client talks to a fresh Server
+func NewDatabase(t testing.TB) (*mongo.Database, *Server) {
+	t.Helper()
+	server := &Server{collections: map[string]*collection{}, failures: map[string]error{}}
+	clientOpts := options.Client()
+	clientOpts.Deployment = server
+
+	client, err := mongo.Connect(context.Background(), clientOpts)
+	if err != nil {
+		t.Fatalf("connect to in-memory mongo: %v", err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+	return client.Database("ginchat_test"), server
+}
+
+// Commands returns the commands received since the last call and forgets them
+func (s *Server) Commands() []Command {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	commands := s.commands
+	s.commands = nil
+	return commands
+}
+
+// FailCommand makes every later command with this name on collection fail with err
+func (s *Server) FailCommand(name, collection string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[name+"/"+collection] = err
+}
+
+// Documents returns a copy of every document in a collection, in insertion order
+func (s *Server) Documents(name string) []bson.D {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	coll := s.collections[name]
+	if coll == nil {
+		return nil
+	}
+	docs := make([]bson.D, len(coll.docs))
+	for i, doc := range coll.docs {
+		docs[i] = cloneDoc(doc)
+	}
+	return docs
+}
+
+// run executes one command and returns the reply document
+func (s *Server) run(cmd bson.D) bson.D {
+	if len(cmd) == 0 {
+		return commandError(fmt.Errorf("empty command"))
+	}
+	name := cmd[0].Key
+	collName, _ := cmd[0].Value.(string)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commands = append(s.commands, Command{Name: name, Collection: collName, Body: cmd})
+	if err := s.failures[name+"/"+collName]; err != nil {
+		return commandError(err)
+	}
+
+	reply, err := s.execute(name, collName, cmd)
+	if err != nil {
+		return commandError(err)
+	}
+	return append(reply, bson.E{Key: "ok", Value: 1.0})
+}
+
+// commandFailure is an error with a server error code
+type commandFailure struct {
+	code    int32
+	message string
+}
+
+func (e *commandFailure) Error() string { return e.message }
+
+func unsupported(format string, args ...any) error {
+	return &commandFailure{code: 115, message: "mongotest: unsupported " + fmt.Sprintf(format, args...)}
+}
+
+func commandError(err error) bson.D {
+	code := int32(8000)
+	var failure *commandFailure
+	if errors.As(err, &failure) {
+		code = failure.code
+	}
+	return bson.D{
+		{Key: "ok", Value: 0.0},
+		{Key: "errmsg", Value: err.Error()},
+		{Key: "code", Value: code},
+	}
+}
+
+var serverDescription = func() description.Server {
+	sessionTimeout := int64(30)
+	return description.Server{
+		Addr:                     address.Address("127.0.0.1:27017"),
+		CanonicalAddr:            address.Address("127.0.0.1:27017"),
+		MaxDocumentSize:          16777216,
+		MaxMessageSize:           48000000,
+		MaxBatchCount:            100000,
+		SessionTimeoutMinutesPtr: &sessionTimeout,
+		Kind:                     description.RSPrimary,
+		WireVersion:              &description.VersionRange{Max: topology.SupportedWireVersions.Max},
+	}
+}()
+
+// driver.Deployment, driver.Server and driver.Subscriber
+
+func (s *Server) SelectServer(context.Context, description.ServerSelector) (driver.Server, error) {
+	return s, nil
+}
+
+func (s *Server) Kind() description.TopologyKind { return description.Single }
+
+func (s *Server) Connection(context.Context) (driver.Connection, error) {
+	return &connection{server: s}, nil
+}
+
+func (s *Server) RTTMonitor() driver.RTTMonitor { return zeroRTTMonitor{} }
+
+func (s *Server) Connect() error { return nil }
+
+func (s *Server) Disconnect(context.Context) error { return nil }
+
+func (s *Server) Subscribe() (*driver.Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.updates == nil {
+		sessionTimeout := int64(30)
+		s.updates = make(chan description.Topology, 1)
+		s.updates <- description.Topology{SessionTimeoutMinutesPtr: &sessionTimeout}
+	}
+	return &driver.Subscription{Updates: s.updates}, nil
+}
+
+func (s *Server) Unsubscribe(*driver.Subscription) error { return nil }
+
+// connection runs each request as it is written and hands back the reply on the next read
+type connection struct {
+	server *Server
+	reply  []byte
+}
+
+func (c *connection) WriteWireMessage(_ context.Context, wm []byte) error {
+	cmd, requestID, err := parseRequest(wm)
+	var reply bson.D
+	if err != nil {
+		reply = commandError(err)
+	} else {
+		reply = c.server.run(cmd)
+	}
+	doc, err := bson.Marshal(reply)
+	if err != nil {
+		return err
+	}
+
+	var dst []byte
+	idx, dst := wiremessage.AppendHeaderStart(dst, wiremessage.NextRequestID(), requestID, wiremessage.OpMsg)
+	dst = wiremessage.AppendMsgFlags(dst, 0)
+	dst = wiremessage.AppendMsgSectionType(dst, wiremessage.SingleDocument)
+	dst = append(dst, doc...)
+	c.reply = bsoncore.UpdateLength(dst, idx, int32(len(dst[idx:])))
+	return nil
+}
+
+func (c *connection) ReadWireMessage(context.Context) ([]byte, error) {
+	if c.reply == nil {
+		return nil, errors.New("mongotest: read without a request")
+	}
+	reply := c.reply
+	c.reply = nil
+	return reply, nil
+}
+
+func (c *connection) Description() description.Server { return serverDescription }
+func (c *connection) Close() error                    { return nil }
+func (c *connection) ID() string                      { return "mongotest" }
+func (c *connection) ServerConnectionID() *int64      { id := int64(1); return &id }
+func (c *connection) DriverConnectionID() uint64      { return 0 }
+func (c *connection) Address() address.Address        { return serverDescription.Addr }
+func (c *connection) Stale() bool                     { return false }
+func (c *connection) OIDCTokenGenID() uint64          { return 0 }
+func (c *connection) SetOIDCTokenGenID(uint64)        {}
+
+// parseRequest decodes an OP_MSG into one command document, folding document sequences
+// (how the driver sends insert/update/delete batches) back into array fields
+func parseRequest(wm []byte) (bson.D, int32, error) {
+	_, requestID, _, opcode, rem, ok := wiremessage.ReadHeader(wm)
+	if !ok || opcode != wiremessage.OpMsg {
+		return nil, 0, errors.New("mongotest: expected an OP_MSG request")
+	}
+	flags, rem, ok := wiremessage.ReadMsgFlags(rem)
+	if !ok {
+		return nil, requestID, errors.New("mongotest: malformed message flags")
+	}
+	if flags&wiremessage.ChecksumPresent != 0 {
+		rem = rem[:len(rem)-4]
+	}
+
+	var cmd bson.D
+	for len(rem) > 0 {
+		var stype wiremessage.SectionType
+		stype, rem, ok = wiremessage.ReadMsgSectionType(rem)
+		if !ok {
+			return nil, requestID, errors.New("mongotest: malformed section")
+		}
+		switch stype {
+		case wiremessage.SingleDocument:
+			var raw bsoncore.Document
+			raw, rem, ok = wiremessage.ReadMsgSectionSingleDocument(rem)
+			if !ok {
+				return nil, requestID, errors.New("mongotest: malformed body")
+			}
+			var body bson.D
+			if err := bson.Unmarshal(raw, &body); err != nil {
+				return nil, requestID, err
+			}
+			cmd = append(body, cmd...)
+		case wiremessage.DocumentSequence:
+			var identifier string
+			var raws []bsoncore.Document
+			identifier, raws, rem, ok = wiremessage.ReadMsgSectionDocumentSequence(rem)
+			if !ok {
+				return nil, requestID, errors.New("mongotest: malformed document sequence")
+			}
+			docs := bson.A{}
+			for _, raw := range raws {
+				var doc bson.D
+				if err := bson.Unmarshal(raw, &doc); err != nil {
+					return nil, requestID, err
+				}
+				docs = append(docs, doc)
+			}
+			cmd = append(cmd, bson.E{Key: identifier, Value: docs})
+		default:
+			return nil, requestID, errors.New("mongotest: unknown section type")
+		}
+	}
+	return cmd, requestID, nil
+}
+
+// zeroRTTMonitor reports no round-trip times
+type zeroRTTMonitor struct{}
+
+func (zeroRTTMonitor) EWMA() time.Duration { return 0 }
+func (zeroRTTMonitor) Min() time.Duration  { return 0 }
+func (zeroRTTMonitor) P90() time.Duration  { return 0 }
+func (zeroRTTMonitor) Stats() string       { return "" }
@@ -0,0 +1,218 @@
+package mongotest
+
+import (
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// applyUpdate returns doc with an update applied. query resolves the positional "$" operator;
+// inserting is true when the update creates an upserted document (enabling $setOnInsert).
+func applyUpdate(doc bson.D, update any, query bson.D, arrayFilters bson.A, inserting bool) (bson.D, error) {
+	spec := asDoc(update)
+	if len(spec) == 0 || !strings.HasPrefix(spec[0].Key, "$") {
+		return nil, unsupported("update that isn't a document of update operators")
+	}
+
+	out := doc
+	for _, op := range spec {
+		if op.Key == "$setOnInsert" && !inserting {
+			continue
+		}
+		for _, field := range asDoc(op.Value) {
+			paths, err := expandPath(out, field.Key, query, arrayFilters)
+			if err != nil {
+				return nil, err
+			}
+			for _, path := range paths {
+				if out, err = applyOperator(out, op.Key, path, field.Value); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return out, nil
+}
+
+// expandPath resolves "$" and "$[identifier]" segments to concrete paths
+func expandPath(doc bson.D, path string, query bson.D, arrayFilters bson.A) ([]string, error) {
+	parts := strings.Split(path, ".")
+	prefixes := [][]string{nil}
+	for _, part := range parts {
+		if !strings.HasPrefix(part, "$") {
+			for i := range prefixes {
+				prefixes[i] = append(prefixes[i], part)
+			}
+			continue
+		}
+		var next [][]string
+		for _, prefix := range prefixes {
+			arrPath := strings.Join(prefix, ".")
+			v, _ := lookupPath(doc, arrPath)
+			arr, _ := v.(bson.A)
+			indexes, err := positionalIndexes(part, arrPath, arr, query, arrayFilters)
+			if err != nil {
+				return nil, err
+			}
+			for _, i := range indexes {
+				next = append(next, append(append([]string{}, prefix...), strconv.Itoa(i)))
+			}
+		}
+		prefixes = next
+	}
+	paths := make([]string, len(prefixes))
+	for i, prefix := range prefixes {
+		paths[i] = strings.Join(prefix, ".")
+	}
+	return paths, nil
+}
+
+func positionalIndexes(part, arrPath string, arr bson.A, query bson.D, arrayFilters bson.A) ([]int, error) {
+	switch {
+	case part == "$":
+		for i, elem := range arr {
+			ok, err := elementMatchesQuery(arrPath, elem, query)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				return []int{i}, nil
+			}
+		}
+		return nil, &commandFailure{code: 2, message: "The positional operator did not find the match needed from the query."}
+	case strings.HasPrefix(part, "$[") && strings.HasSuffix(part, "]"):
+		identifier := part[2 : len(part)-1]
+		var filter bson.D
+		for _, raw := range arrayFilters {
+			for _, e := range asDoc(raw) {
+				if e.Key == identifier || strings.HasPrefix(e.Key, identifier+".") {
+					filter = append(filter, e)
+				}
+			}
+		}
+		if filter == nil {
+			return nil, &commandFailure{code: 2, message: "No array filter found for identifier '" + identifier + "'"}
+		}
+		var indexes []int
+		for i, elem := range arr {
+			ok, err := matches(bson.D{{Key: identifier, Value: elem}}, filter, nil)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				indexes = append(indexes, i)
+			}
+		}
+		return indexes, nil
+	}
+	return nil, unsupported("update path segment %s", part)
+}
+
+// elementMatchesQuery reports whether one array element satisfies the query's conditions on that array
+func elementMatchesQuery(arrPath string, elem any, query bson.D) (bool, error) {
+	var conditions bson.D
+	collect := func(filter bson.D) {
+		for _, e := range filter {
+			if e.Key == arrPath || strings.HasPrefix(e.Key, arrPath+".") {
+				conditions = append(conditions, e)
+			}
+		}
+	}
+	collect(query)
+	if and, ok := lookup(query, "$and"); ok {
+		for _, clause := range and.(bson.A) {
+			collect(asDoc(clause))
+		}
+	}
+	if len(conditions) == 0 {
+		return false, nil
+	}
+	probe := bson.D{}
+	var err error
+	if probe, err = setPath(probe, arrPath, bson.A{elem}); err != nil {
+		return false, err
+	}
+	return matches(probe, conditions, nil)
+}
+
+func applyOperator(doc bson.D, op, path string, arg any) (bson.D, error) {
+	current, exists := lookupPath(doc, path)
+	switch op {
+	case "$set", "$setOnInsert":
+		return setPath(doc, path, cloneValue(arg))
+	case "$push":
+		arr, ok := current.(bson.A)
+		if exists && !ok {
+			return nil, &commandFailure{code: 2, message: "The field '" + path + "' must be an array"}
+		}
+		return setPath(doc, path, append(append(bson.A{}, arr...), cloneValue(arg)))
+	case "$inc":
+		if !isNumber(arg) || (exists && !isNumber(current)) {
+			return nil, &commandFailure{code: 14, message: "Cannot increment " + path + " with a non-numeric value"}
+		}
+		if !exists {
+			return setPath(doc, path, arg)
+		}
+		return setPath(doc, path, sumValues([]any{current, arg}))
+	case "$pull":
+		cond := asDoc(arg)
+		if cond == nil || isOperatorDoc(cond) {
+			return nil, unsupported("$pull from %s other than by a document condition", path)
+		}
+		arr, ok := current.(bson.A)
+		if !ok {
+			return doc, nil
+		}
+		out := bson.A{}
+		for _, elem := range arr {
+			remove := false
+			if isDoc(elem) {
+				var err error
+				if remove, err = matches(asDoc(elem), cond, nil); err != nil {
+					return nil, err
+				}
+			}
+			if !remove {
+				out = append(out, elem)
+			}
+		}
+		return setPath(doc, path, out)
+	}
+	return nil, unsupported("update operator %s", op)
+}
+
+// upsertSeed is the document an upsert starts from: the query's equality conditions
+func upsertSeed(query bson.D) (bson.D, error) {
+	doc := bson.D{}
+	var add func(filter bson.D) error
+	add = func(filter bson.D) error {
+		for _, e := range filter {
+			if e.Key == "$and" {
+				for _, clause := range e.Value.(bson.A) {
+					if err := add(asDoc(clause)); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if strings.HasPrefix(e.Key, "$") {
+				continue
+			}
+			value := e.Value
+			if isOperatorDoc(value) {
+				eq, ok := lookup(asDoc(value), "$eq")
+				if !ok {
+					continue
+				}
+				value = eq
+			}
+			var err error
+			if doc, err = setPath(doc, e.Key, cloneValue(value)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return doc, add(query)
+}
@@ -0,0 +1,393 @@
+package mongotest
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// missing marks a path that resolved to nothing, which MongoDB treats differently from null
+type missingValue struct{}
+
+var missing = missingValue{}
+
+// typeRank orders values of different types the way MongoDB's comparison order does
+func typeRank(v any) int {
+	switch v.(type) {
+	case primitive.MinKey:
+		return 0
+	case missingValue, nil, primitive.Null, primitive.Undefined:
+		return 1
+	case int32, int64, int, float64, primitive.Decimal128:
+		return 2
+	case string, primitive.Symbol:
+		return 3
+	case bson.D, bson.M:
+		return 4
+	case bson.A:
+		return 5
+	case primitive.Binary:
+		return 6
+	case primitive.ObjectID:
+		return 7
+	case bool:
+		return 8
+	case primitive.DateTime:
+		return 9
+	case primitive.Timestamp:
+		return 10
+	case primitive.Regex:
+		return 11
+	case primitive.MaxKey:
+		return 13
+	}
+	return 12
+}
+
+func isNumber(v any) bool {
+	switch v.(type) {
+	case int32, int64, int, float64:
+		return true
+	}
+	return false
+}
+
+func toFloat(v any) float64 {
+	switch n := v.(type) {
+	case int32:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case int:
+		return float64(n)
+	case float64:
+		return n
+	}
+	return math.NaN()
+}
+
+func toInt(v any) (int64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case int:
+		return int64(n), true
+	case float64:
+		if n == math.Trunc(n) {
+			return int64(n), true
+		}
+	}
+	return 0, false
+}
+
+// compare orders two values by MongoDB's BSON comparison rules
+func compare(a, b any) int {
+	ra, rb := typeRank(a), typeRank(b)
+	if ra != rb {
+		return cmpInt(ra, rb)
+	}
+	switch x := a.(type) {
+	case int32, int64, int, float64:
+		ia, okA := toInt(a)
+		ib, okB := toInt(b)
+		if okA && okB {
+			return cmpInt64(ia, ib)
+		}
+		fa, fb := toFloat(a), toFloat(b)
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(x, stringOf(b))
+	case primitive.Symbol:
+		return strings.Compare(string(x), stringOf(b))
+	case bson.D:
+		return compareDocs(x, asDoc(b))
+	case bson.M:
+		return compareDocs(asDoc(x), asDoc(b))
+	case bson.A:
+		y := b.(bson.A)
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if c := compare(x[i], y[i]); c != 0 {
+				return c
+			}
+		}
+		return cmpInt(len(x), len(y))
+	case primitive.Binary:
+		return bytes.Compare(x.Data, b.(primitive.Binary).Data)
+	case primitive.ObjectID:
+		y := b.(primitive.ObjectID)
+		return bytes.Compare(x[:], y[:])
+	case bool:
+		y := b.(bool)
+		switch {
+		case x == y:
+			return 0
+		case !x:
+			return -1
+		}
+		return 1
+	case primitive.DateTime:
+		return cmpInt64(int64(x), int64(b.(primitive.DateTime)))
+	case primitive.Timestamp:
+		y := b.(primitive.Timestamp)
+		if x.T != y.T {
+			return cmpInt64(int64(x.T), int64(y.T))
+		}
+		return cmpInt64(int64(x.I), int64(y.I))
+	case primitive.Regex:
+		return strings.Compare(x.Pattern+"/"+x.Options, b.(primitive.Regex).Pattern+"/"+b.(primitive.Regex).Options)
+	}
+	return 0
+}
+
+func stringOf(v any) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case primitive.Symbol:
+		return string(s)
+	}
+	return ""
+}
+
+func compareDocs(a, b bson.D) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := compare(a[i].Value, b[i].Value); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a[i].Key, b[i].Key); c != 0 {
+			return c
+		}
+	}
+	return cmpInt(len(a), len(b))
+}
+
+func cmpInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func cmpInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// equal reports whether two values are equal under MongoDB rules (numbers compare across types)
+func equal(a, b any) bool {
+	return typeRank(a) == typeRank(b) && compare(a, b) == 0
+}
+
+// asDoc converts a document value to bson.D, or returns nil if v isn't a document
+func asDoc(v any) bson.D {
+	switch d := v.(type) {
+	case bson.D:
+		return d
+	case bson.M:
+		keys := make([]string, 0, len(d))
+		for k := range d {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		doc := make(bson.D, 0, len(d))
+		for _, k := range keys {
+			doc = append(doc, bson.E{Key: k, Value: d[k]})
+		}
+		return doc
+	}
+	return nil
+}
+
+func isDoc(v any) bool {
+	switch v.(type) {
+	case bson.D, bson.M:
+		return true
+	}
+	return false
+}
+
+// lookup returns the value of a top-level field
+func lookup(doc bson.D, key string) (any, bool) {
+	for _, e := range doc {
+		if e.Key == key {
+			return e.Value, true
+		}
+	}
+	return nil, false
+}
+
+// lookupPath follows a dotted path without expanding arrays (numeric parts index into them)
+func lookupPath(v any, path string) (any, bool) {
+	for _, part := range strings.Split(path, ".") {
+		switch cur := v.(type) {
+		case bson.D:
+			next, ok := lookup(cur, part)
+			if !ok {
+				return nil, false
+			}
+			v = next
+		case bson.A:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(cur) {
+				return nil, false
+			}
+			v = cur[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// resolvePath returns every value a query path reaches, descending into arrays of documents
+// the way MongoDB does (so "members.user_id" reaches each member's user_id)
+func resolvePath(v any, parts []string) []any {
+	if len(parts) == 0 {
+		return []any{v}
+	}
+	switch cur := v.(type) {
+	case bson.D:
+		next, ok := lookup(cur, parts[0])
+		if !ok {
+			return nil
+		}
+		return resolvePath(next, parts[1:])
+	case bson.A:
+		var out []any
+		if i, err := strconv.Atoi(parts[0]); err == nil {
+			if i >= 0 && i < len(cur) {
+				out = append(out, resolvePath(cur[i], parts[1:])...)
+			}
+		}
+		for _, elem := range cur {
+			if isDoc(elem) {
+				out = append(out, resolvePath(asDoc(elem), parts)...)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// setPath returns doc with the dotted path set to value, creating documents along the way
+func setPath(doc bson.D, path string, value any) (bson.D, error) {
+	out, err := setIn(doc, strings.Split(path, "."), value)
+	if err != nil {
+		return nil, err
+	}
+	return out.(bson.D), nil
+}
+
+func setIn(v any, parts []string, value any) (any, error) {
+	if len(parts) == 0 {
+		return value, nil
+	}
+	switch cur := v.(type) {
+	case bson.D:
+		out := cloneShallow(cur)
+		for i, e := range out {
+			if e.Key == parts[0] {
+				next, err := setIn(e.Value, parts[1:], value)
+				if err != nil {
+					return nil, err
+				}
+				out[i].Value = next
+				return out, nil
+			}
+		}
+		next, err := setIn(bson.D{}, parts[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		return append(out, bson.E{Key: parts[0], Value: next}), nil
+	case bson.A:
+		i, err := strconv.Atoi(parts[0])
+		if err != nil || i < 0 {
+			return nil, unsupported("path %q into an array", strings.Join(parts, "."))
+		}
+		out := append(bson.A{}, cur...)
+		for len(out) <= i {
+			out = append(out, nil)
+		}
+		next, err := setIn(out[i], parts[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = next
+		return out, nil
+	case nil:
+		return setIn(bson.D{}, parts, value)
+	}
+	return nil, &commandFailure{code: 28, message: "cannot create field " + parts[0] + " in a non-document"}
+}
+
+func cloneShallow(doc bson.D) bson.D {
+	return append(bson.D{}, doc...)
+}
+
+// cloneDoc deep-copies a document so callers can't alias the stored copy
+func cloneDoc(doc bson.D) bson.D {
+	return cloneValue(doc).(bson.D)
+}
+
+func cloneValue(v any) any {
+	switch cur := v.(type) {
+	case bson.D:
+		out := make(bson.D, len(cur))
+		for i, e := range cur {
+			out[i] = bson.E{Key: e.Key, Value: cloneValue(e.Value)}
+		}
+		return out
+	case bson.M:
+		return cloneValue(asDoc(cur))
+	case bson.A:
+		out := make(bson.A, len(cur))
+		for i, elem := range cur {
+			out[i] = cloneValue(elem)
+		}
+		return out
+	}
+	return v
+}
+
+// truthy is the aggregation notion of true: everything but false, null, missing and zero
+func truthy(v any) bool {
+	switch x := v.(type) {
+	case nil, missingValue, primitive.Null, primitive.Undefined:
+		return false
+	case bool:
+		return x
+	case int32, int64, int, float64:
+		return toFloat(x) != 0
+	}
+	return true
+}
+
+func isNullish(v any) bool {
+	switch v.(type) {
+	case nil, missingValue, primitive.Null, primitive.Undefined:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,677 @@
+package sqltest
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// table is one table's rows plus what the schema says about them
+type table struct {
+	name      string
+	columns   []string
+	defaults  map[string]any
+	autoInc   string
+	nextID    int64
+	uniques   []uniqueKey
+	rows      []map[string]any
+	hasColumn map[string]bool
+}
+
+type uniqueKey struct {
+	name    string
+	columns []string
+}
+
+func (t *table) clone() *table {
+	copied := *t
+	copied.rows = make([]map[string]any, len(t.rows))
+	for i, row := range t.rows {
+		copied.rows[i] = cloneRow(row)
+	}
+	return &copied
+}
+
+func cloneRow(row map[string]any) map[string]any {
+	out := make(map[string]any, len(row))
+	for k, v := range row {
+		out[k] = v
+	}
+	return out
+}
+
+// normalize converts a driver value to how the engine stores it (MySQL keeps booleans as integers)
+func normalize(v any) any {
+	switch x := v.(type) {
+	case bool:
+		if x {
+			return int64(1)
+		}
+		return int64(0)
+	case []byte:
+		return append([]byte{}, x...)
+	case int:
+		return int64(x)
+	case int32:
+		return int64(x)
+	case uint64:
+		return int64(x)
+	}
+	return v
+}
+
+// evalContext is what an expression sees: the current row, the statement's arguments and,
+// for COUNT(*), the rows it counts
+type evalContext struct {
+	row   map[string]any
+	args  []driver.NamedValue
+	group []map[string]any
+}
+
+func (c *evalContext) eval(e expr) (any, error) {
+	switch x := e.(type) {
+	case literal:
+		return x.value, nil
+	case paramRef:
+		if x.index >= len(c.args) {
+			return nil, fmt.Errorf("sqltest: missing argument %d", x.index+1)
+		}
+		return normalize(c.args[x.index].Value), nil
+	case columnRef:
+		return c.row[x.name], nil
+	case binaryOp:
+		return c.evalBinary(x)
+	case inOp:
+		v, err := c.eval(x.inner)
+		if err != nil || v == nil {
+			return nil, err
+		}
+		found := false
+		for _, item := range x.list {
+			w, err := c.eval(item)
+			if err != nil {
+				return nil, err
+			}
+			if cmp, ok := compareValues(v, w); ok && cmp == 0 {
+				found = true
+				break
+			}
+		}
+		return boolValue(found), nil
+	case likeOp:
+		return c.evalLike(x)
+	case caseOp:
+		for i, when := range x.whens {
+			v, err := c.eval(when)
+			if err != nil {
+				return nil, err
+			}
+			if truthy(v) {
+				return c.eval(x.thens[i])
+			}
+		}
+		if x.elseExpr == nil {
+			return nil, nil
+		}
+		return c.eval(x.elseExpr)
+	case funcCall:
+		return c.evalCall(x)
+	}
+	return nil, fmt.Errorf("sqltest: cannot evaluate %T", e)
+}
+
+func (c *evalContext) evalBinary(x binaryOp) (any, error) {
+	left, err := c.eval(x.left)
+	if err != nil {
+		return nil, err
+	}
+	if x.op == "AND" {
+		if left != nil && !truthy(left) {
+			return int64(0), nil
+		}
+		right, err := c.eval(x.right)
+		if err != nil {
+			return nil, err
+		}
+		if right != nil && !truthy(right) {
+			return int64(0), nil
+		}
+		if left == nil || right == nil {
+			return nil, nil
+		}
+		return int64(1), nil
+	}
+	right, err := c.eval(x.right)
+	if err != nil {
+		return nil, err
+	}
+	cmp, ok := compareValues(left, right)
+	if !ok {
+		return nil, nil
+	}
+	switch x.op {
+	case "=":
+		return boolValue(cmp == 0), nil
+	case "<>":
+		return boolValue(cmp != 0), nil
+	case "<":
+		return boolValue(cmp < 0), nil
+	case ">":
+		return boolValue(cmp > 0), nil
+	}
+	return nil, fmt.Errorf("sqltest: unknown operator %s", x.op)
+}
+
+func (c *evalContext) evalLike(x likeOp) (any, error) {
+	v, err := c.eval(x.inner)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	pattern, err := c.eval(x.pattern)
+	if err != nil || pattern == nil {
+		return nil, err
+	}
+	var re strings.Builder
+	re.WriteString("(?is)^")
+	p := toString(pattern)
+	for i := 0; i < len(p); i++ {
+		switch {
+		case p[i] == '\\' && i+1 < len(p):
+			i++
+			re.WriteString(regexp.QuoteMeta(string(p[i])))
+		case p[i] == '%':
+			re.WriteString(".*")
+		case p[i] == '_':
+			re.WriteString(".")
+		default:
+			re.WriteString(regexp.QuoteMeta(string(p[i])))
+		}
+	}
+	re.WriteString("$")
+	matched, err := regexp.MatchString(re.String(), toString(v))
+	if err != nil {
+		return nil, err
+	}
+	return boolValue(matched), nil
+}
+
+func (c *evalContext) evalCall(x funcCall) (any, error) {
+	switch {
+	case x.name == "COUNT" && x.star && c.group != nil:
+		return int64(len(c.group)), nil
+	case x.name == "VALUES" && len(x.args) == 1:
+		// ON DUPLICATE KEY UPDATE col = VALUES(col): the value the insert tried to write
+		if ref, ok := x.args[0].(columnRef); ok {
+			return c.row["\x00new."+ref.name], nil
+		}
+	}
+	return nil, fmt.Errorf("sqltest: unsupported function %s", x.name)
+}
+
+func boolValue(b bool) any {
+	if b {
+		return int64(1)
+	}
+	return int64(0)
+}
+
+func truthy(v any) bool {
+	switch x := v.(type) {
+	case nil:
+		return false
+	case int64:
+		return x != 0
+	}
+	return true
+}
+
+func toNumber(v any) (float64, bool) {
+	switch x := v.(type) {
+	case int64:
+		return float64(x), true
+	case float64:
+		return x, true
+	case string:
+		f, err := strconv.ParseFloat(x, 64)
+		return f, err == nil
+	case []byte:
+		f, err := strconv.ParseFloat(string(x), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func toString(v any) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case []byte:
+		return string(x)
+	case time.Time:
+		return x.Format("2006-01-02 15:04:05.999999")
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+func toTime(v any) (time.Time, bool) {
+	switch x := v.(type) {
+	case time.Time:
+		return x, true
+	case string, []byte:
+		for _, layout := range []string{"2006-01-02 15:04:05.999999", time.RFC3339Nano, "2006-01-02"} {
+			if t, err := time.Parse(layout, toString(x)); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// compareValues orders two non-NULL values; strings compare case-insensitively like MySQL's default collation
+func compareValues(a, b any) (int, bool) {
+	if a == nil || b == nil {
+		return 0, false
+	}
+	if ta, ok := a.(time.Time); ok {
+		tb, ok := toTime(b)
+		if !ok {
+			return 0, false
+		}
+		return ta.Compare(tb), true
+	}
+	if tb, ok := b.(time.Time); ok {
+		ta, ok := toTime(a)
+		if !ok {
+			return 0, false
+		}
+		return ta.Compare(tb), true
+	}
+	_, aNum := a.(int64)
+	_, aFloat := a.(float64)
+	_, bNum := b.(int64)
+	_, bFloat := b.(float64)
+	if aNum || aFloat || bNum || bFloat {
+		x, okA := toNumber(a)
+		y, okB := toNumber(b)
+		if !okA || !okB {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+	return strings.Compare(strings.ToLower(toString(a)), strings.ToLower(toString(b))), true
+}
+
+func (db *DB) table(name string) (*table, error) {
+	t := db.tables[name]
+	if t == nil {
+		return nil, fmt.Errorf("sqltest: table %s doesn't exist (pass its model to Open)", name)
+	}
+	return t, nil
+}
+
+func (db *DB) filter(t *table, where expr, args []driver.NamedValue) ([]int, error) {
+	var positions []int
+	for i, row := range t.rows {
+		if where != nil {
+			v, err := (&evalContext{row: row, args: args}).eval(where)
+			if err != nil {
+				return nil, err
+			}
+			if !truthy(v) {
+				continue
+			}
+		}
+		positions = append(positions, i)
+	}
+	return positions, nil
+}
+
+func limitValue(e expr, args []driver.NamedValue) (int, error) {
+	if e == nil {
+		return -1, nil
+	}
+	v, err := (&evalContext{args: args}).eval(e)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := toNumber(v)
+	return int(n), nil
+}
+
+func sortRows(rows []map[string]any, order []orderItem, args []driver.NamedValue) error {
+	var sortErr error
+	sort.SliceStable(rows, func(i, j int) bool {
+		for _, item := range order {
+			a, err := (&evalContext{row: rows[i], args: args}).eval(item.expr)
+			if err != nil {
+				sortErr = err
+				return false
+			}
+			b, err := (&evalContext{row: rows[j], args: args}).eval(item.expr)
+			if err != nil {
+				sortErr = err
+				return false
+			}
+			cmp, ok := compareValues(a, b)
+			if !ok {
+				// NULLs sort first ascending
+				switch {
+				case a == nil && b != nil:
+					cmp = -1
+				case a != nil && b == nil:
+					cmp = 1
+				default:
+					cmp = 0
+				}
+			}
+			if cmp != 0 {
+				if item.desc {
+					return cmp > 0
+				}
+				return cmp < 0
+			}
+		}
+		return false
+	})
+	return sortErr
+}
+
+type resultSet struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (db *DB) query(stmt *selectStmt, args []driver.NamedValue) (*resultSet, error) {
+	t, err := db.table(stmt.table)
+	if err != nil {
+		return nil, err
+	}
+	positions, err := db.filter(t, stmt.where, args)
+	if err != nil {
+		return nil, err
+	}
+	rows := make([]map[string]any, len(positions))
+	for i, p := range positions {
+		rows[i] = t.rows[p]
+	}
+
+	aggregate := false
+	for _, item := range stmt.items {
+		if call, ok := item.expr.(funcCall); ok && call.name == "COUNT" {
+			aggregate = true
+		}
+	}
+
+	// Each output row is evaluated against a source row and, for COUNT(*), all the matching rows
+	type output struct {
+		row   map[string]any
+		group []map[string]any
+	}
+	var outputs []output
+	if aggregate {
+		out := output{group: append([]map[string]any{}, rows...)}
+		if len(rows) > 0 {
+			out.row = rows[0]
+		}
+		outputs = append(outputs, out)
+	} else {
+		if err := sortRows(rows, stmt.orderBy, args); err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			outputs = append(outputs, output{row: row})
+		}
+	}
+
+	limit, err := limitValue(stmt.limit, args)
+	if err != nil {
+		return nil, err
+	}
+	if limit >= 0 && limit < len(outputs) {
+		outputs = outputs[:limit]
+	}
+
+	result := &resultSet{}
+	for _, item := range stmt.items {
+		switch {
+		case item.star:
+			result.columns = append(result.columns, t.columns...)
+		default:
+			result.columns = append(result.columns, columnName(item.expr))
+		}
+	}
+	for _, out := range outputs {
+		var values []driver.Value
+		for _, item := range stmt.items {
+			if item.star {
+				for _, col := range t.columns {
+					values = append(values, out.row[col])
+				}
+				continue
+			}
+			v, err := (&evalContext{row: out.row, args: args, group: out.group}).eval(item.expr)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		result.rows = append(result.rows, values)
+	}
+	return result, nil
+}
+
+func columnName(e expr) string {
+	switch x := e.(type) {
+	case columnRef:
+		return x.name
+	case funcCall:
+		return strings.ToLower(x.name) + "(*)"
+	}
+	return "?column?"
+}
+
+// duplicate reports the unique key that row collides on with a row other than the one at self
+func (t *table) duplicate(row map[string]any, self int) *uniqueKey {
+	for k := range t.uniques {
+		key := &t.uniques[k]
+		for i, other := range t.rows {
+			if i == self {
+				continue
+			}
+			same := true
+			for _, col := range key.columns {
+				cmp, ok := compareValues(row[col], other[col])
+				if !ok || cmp != 0 {
+					same = false
+					break
+				}
+			}
+			if same {
+				return key
+			}
+		}
+	}
+	return nil
+}
+
+func duplicateError(t *table, key *uniqueKey, row map[string]any) error {
+	var values []string
+	for _, col := range key.columns {
+		values = append(values, toString(row[col]))
+	}
+	return &mysql.MySQLError{Number: 1062, Message: fmt.Sprintf("Duplicate entry '%s' for key '%s.%s'", strings.Join(values, "-"), t.name, key.name)}
+}
+
+type execResult struct {
+	lastInsertID int64
+	affected     int64
+}
+
+func (db *DB) insert(stmt *insertStmt, args []driver.NamedValue) (execResult, error) {
+	t, err := db.table(stmt.table)
+	if err != nil {
+		return execResult{}, err
+	}
+	var result execResult
+	for _, values := range stmt.rows {
+		row := map[string]any{}
+		for col, def := range t.defaults {
+			row[col] = def
+		}
+		for i, col := range stmt.columns {
+			if !t.hasColumn[col] {
+				return result, fmt.Errorf("sqltest: unknown column %s.%s", t.name, col)
+			}
+			v, err := (&evalContext{args: args}).eval(values[i])
+			if err != nil {
+				return result, err
+			}
+			row[col] = v
+		}
+		if t.autoInc != "" {
+			if id, ok := toNumber(row[t.autoInc]); !ok || id == 0 {
+				t.nextID++
+				row[t.autoInc] = t.nextID
+			} else if int64(id) > t.nextID {
+				t.nextID = int64(id)
+			}
+		}
+
+		if key := t.duplicate(row, -1); key != nil {
+			if stmt.onUpdate == nil {
+				return result, duplicateError(t, key, row)
+			}
+			changed, err := db.upsertExisting(t, row, stmt.onUpdate, args)
+			if err != nil {
+				return result, err
+			}
+			if changed {
+				result.affected += 2
+			}
+			continue
+		}
+		t.rows = append(t.rows, row)
+		result.affected++
+		if t.autoInc != "" && result.lastInsertID == 0 {
+			result.lastInsertID, _ = row[t.autoInc].(int64)
+		}
+	}
+	return result, nil
+}
+
+// upsertExisting applies ON DUPLICATE KEY UPDATE to the row the insert collided with
+func (db *DB) upsertExisting(t *table, inserted map[string]any, sets []assignment, args []driver.NamedValue) (bool, error) {
+	key := t.duplicate(inserted, -1)
+	for i, existing := range t.rows {
+		same := true
+		for _, col := range key.columns {
+			if cmp, ok := compareValues(inserted[col], existing[col]); !ok || cmp != 0 {
+				same = false
+				break
+			}
+		}
+		if !same {
+			continue
+		}
+		ctx := cloneRow(existing)
+		for col, v := range inserted {
+			ctx["\x00new."+col] = v
+		}
+		updated := cloneRow(existing)
+		for _, set := range sets {
+			v, err := (&evalContext{row: ctx, args: args}).eval(set.value)
+			if err != nil {
+				return false, err
+			}
+			updated[set.column] = v
+		}
+		changed := !sameRow(existing, updated)
+		t.rows[i] = updated
+		return changed, nil
+	}
+	return false, nil
+}
+
+func sameRow(a, b map[string]any) bool {
+	for k, v := range b {
+		if cmp, ok := compareValues(a[k], v); ok && cmp == 0 {
+			continue
+		}
+		if a[k] == nil && v == nil {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+func (db *DB) update(stmt *updateStmt, args []driver.NamedValue) (execResult, error) {
+	t, err := db.table(stmt.table)
+	if err != nil {
+		return execResult{}, err
+	}
+	positions, err := db.filter(t, stmt.where, args)
+	if err != nil {
+		return execResult{}, err
+	}
+
+	var result execResult
+	for _, p := range positions {
+		updated := cloneRow(t.rows[p])
+		for _, set := range stmt.sets {
+			if !t.hasColumn[set.column] {
+				return result, fmt.Errorf("sqltest: unknown column %s.%s", t.name, set.column)
+			}
+			v, err := (&evalContext{row: t.rows[p], args: args}).eval(set.value)
+			if err != nil {
+				return result, err
+			}
+			updated[set.column] = v
+		}
+		if key := t.duplicate(updated, p); key != nil {
+			return result, duplicateError(t, key, updated)
+		}
+		if !sameRow(t.rows[p], updated) {
+			result.affected++
+		}
+		t.rows[p] = updated
+	}
+	return result, nil
+}
+
+func (db *DB) delete(stmt *deleteStmt, args []driver.NamedValue) (execResult, error) {
+	t, err := db.table(stmt.table)
+	if err != nil {
+		return execResult{}, err
+	}
+	positions, err := db.filter(t, stmt.where, args)
+	if err != nil {
+		return execResult{}, err
+	}
+	remove := map[int]bool{}
+	for _, p := range positions {
+		remove[p] = true
+	}
+	kept := make([]map[string]any, 0, len(t.rows))
+	for i, row := range t.rows {
+		if !remove[i] {
+			kept = append(kept, row)
+		}
+	}
+	t.rows = kept
+	return execResult{affected: int64(len(positions))}, nil
+}
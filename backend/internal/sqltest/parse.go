@@ -0,0 +1,585 @@
+package sqltest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The parser covers the MySQL that GORM generates for this app's queries: single-table SELECT
+// (with WHERE, ORDER BY, LIMIT and COUNT(*)), INSERT with ON DUPLICATE KEY UPDATE, UPDATE and
+// DELETE. Anything else fails to parse rather than being half-understood.
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokKeyword
+	tokNumber
+	tokParam
+	tokSymbol
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+var keywords = map[string]bool{
+	"SELECT": true, "FROM": true, "WHERE": true, "AND": true, "IN": true, "NULL": true, "LIKE": true,
+	"ORDER": true, "BY": true, "ASC": true, "DESC": true, "LIMIT": true, "INSERT": true, "INTO": true,
+	"VALUES": true, "UPDATE": true, "SET": true, "DELETE": true, "ON": true, "DUPLICATE": true,
+	"KEY": true, "CASE": true, "WHEN": true, "THEN": true, "ELSE": true, "END": true,
+}
+
+func tokenize(query string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case unicode.IsSpace(rune(c)):
+			i++
+		case c == '`':
+			end := strings.IndexByte(query[i+1:], '`')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated identifier")
+			}
+			tokens = append(tokens, token{tokIdent, query[i+1 : i+1+end]})
+			i += end + 2
+		case c == '?':
+			tokens = append(tokens, token{tokParam, "?"})
+			i++
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(query) && query[j] >= '0' && query[j] <= '9' {
+				j++
+			}
+			tokens = append(tokens, token{tokNumber, query[i:j]})
+			i = j
+		case c == '_' || unicode.IsLetter(rune(c)):
+			j := i
+			for j < len(query) && (query[j] == '_' || unicode.IsLetter(rune(query[j])) || unicode.IsDigit(rune(query[j]))) {
+				j++
+			}
+			word := query[i:j]
+			if keywords[strings.ToUpper(word)] {
+				tokens = append(tokens, token{tokKeyword, strings.ToUpper(word)})
+			} else {
+				tokens = append(tokens, token{tokIdent, word})
+			}
+			i = j
+		default:
+			for _, op := range []string{"<>", "=", "<", ">", "(", ")", ",", "*", ".", ";"} {
+				if strings.HasPrefix(query[i:], op) {
+					tokens = append(tokens, token{tokSymbol, op})
+					i += len(op)
+					goto next
+				}
+			}
+			return nil, fmt.Errorf("unexpected character %q", c)
+		next:
+		}
+	}
+	return append(tokens, token{kind: tokEOF}), nil
+}
+
+// expr is a parsed SQL expression
+type expr interface{}
+
+type (
+	columnRef struct{ name string }
+	paramRef  struct{ index int }
+	literal   struct{ value any }
+	binaryOp  struct {
+		op          string
+		left, right expr
+	}
+	inOp struct {
+		inner expr
+		list  []expr
+	}
+	likeOp struct{ inner, pattern expr }
+	caseOp struct {
+		whens, thens []expr
+		elseExpr     expr
+	}
+	funcCall struct {
+		name string
+		args []expr
+		star bool
+	}
+)
+
+type selectItem struct {
+	expr expr
+	star bool
+}
+
+type orderItem struct {
+	expr expr
+	desc bool
+}
+
+type selectStmt struct {
+	items   []selectItem
+	table   string
+	where   expr
+	orderBy []orderItem
+	limit   expr
+}
+
+type assignment struct {
+	column string
+	value  expr
+}
+
+type insertStmt struct {
+	table    string
+	columns  []string
+	rows     [][]expr
+	onUpdate []assignment
+}
+
+type updateStmt struct {
+	table string
+	sets  []assignment
+	where expr
+}
+
+type deleteStmt struct {
+	table string
+	where expr
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	params int
+}
+
+func parse(query string) (any, int, error) {
+	tokens, err := tokenize(query)
+	if err != nil {
+		return nil, 0, err
+	}
+	p := &parser{tokens: tokens}
+	var stmt any
+	switch {
+	case p.peekKeyword("SELECT"):
+		stmt, err = p.parseSelect()
+	case p.peekKeyword("INSERT"):
+		stmt, err = p.parseInsert()
+	case p.peekKeyword("UPDATE"):
+		stmt, err = p.parseUpdate()
+	case p.peekKeyword("DELETE"):
+		stmt, err = p.parseDelete()
+	default:
+		return nil, 0, fmt.Errorf("sqltest: unsupported statement %q", query)
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("sqltest: %v in %q", err, query)
+	}
+	p.acceptSymbol(";")
+	if p.peek().kind != tokEOF {
+		return nil, 0, fmt.Errorf("sqltest: unexpected %q in %q", p.peek().text, query)
+	}
+	return stmt, p.params, nil
+}
+
+func (p *parser) peek() token { return p.tokens[p.pos] }
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) peekKeyword(kw string) bool {
+	t := p.peek()
+	return t.kind == tokKeyword && t.text == kw
+}
+
+func (p *parser) acceptKeyword(kw string) bool {
+	if p.peekKeyword(kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectKeyword(kw string) error {
+	if !p.acceptKeyword(kw) {
+		return fmt.Errorf("expected %s, got %q", kw, p.peek().text)
+	}
+	return nil
+}
+
+func (p *parser) acceptSymbol(sym string) bool {
+	t := p.peek()
+	if t.kind == tokSymbol && t.text == sym {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectSymbol(sym string) error {
+	if !p.acceptSymbol(sym) {
+		return fmt.Errorf("expected %q, got %q", sym, p.peek().text)
+	}
+	return nil
+}
+
+func (p *parser) identifier() (string, error) {
+	t := p.next()
+	if t.kind != tokIdent {
+		return "", fmt.Errorf("expected identifier, got %q", t.text)
+	}
+	// table.column keeps only the column
+	for p.acceptSymbol(".") {
+		next := p.next()
+		if next.kind != tokIdent {
+			return "", fmt.Errorf("expected identifier after '.', got %q", next.text)
+		}
+		t = next
+	}
+	return t.text, nil
+}
+
+func (p *parser) parseSelect() (*selectStmt, error) {
+	p.next()
+	stmt := &selectStmt{}
+	for {
+		item := selectItem{}
+		if p.acceptSymbol("*") {
+			item.star = true
+		} else {
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			item.expr = e
+		}
+		stmt.items = append(stmt.items, item)
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	table, err := p.identifier()
+	if err != nil {
+		return nil, err
+	}
+	stmt.table = table
+	if p.acceptKeyword("WHERE") {
+		if stmt.where, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	if stmt.orderBy, err = p.parseOrderBy(); err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("LIMIT") {
+		if stmt.limit, err = p.parsePrimary(); err != nil {
+			return nil, err
+		}
+	}
+	return stmt, nil
+}
+
+func (p *parser) parseOrderBy() ([]orderItem, error) {
+	if !p.acceptKeyword("ORDER") {
+		return nil, nil
+	}
+	if err := p.expectKeyword("BY"); err != nil {
+		return nil, err
+	}
+	var items []orderItem
+	for {
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		item := orderItem{expr: e}
+		if p.acceptKeyword("DESC") {
+			item.desc = true
+		} else {
+			p.acceptKeyword("ASC")
+		}
+		items = append(items, item)
+		if !p.acceptSymbol(",") {
+			return items, nil
+		}
+	}
+}
+
+func (p *parser) parseInsert() (*insertStmt, error) {
+	p.next()
+	if err := p.expectKeyword("INTO"); err != nil {
+		return nil, err
+	}
+	table, err := p.identifier()
+	if err != nil {
+		return nil, err
+	}
+	stmt := &insertStmt{table: table}
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	for {
+		col, err := p.identifier()
+		if err != nil {
+			return nil, err
+		}
+		stmt.columns = append(stmt.columns, col)
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("VALUES"); err != nil {
+		return nil, err
+	}
+	for {
+		if err := p.expectSymbol("("); err != nil {
+			return nil, err
+		}
+		var row []expr
+		for {
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			row = append(row, e)
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+		stmt.rows = append(stmt.rows, row)
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+	if p.acceptKeyword("ON") {
+		for _, kw := range []string{"DUPLICATE", "KEY", "UPDATE"} {
+			if err := p.expectKeyword(kw); err != nil {
+				return nil, err
+			}
+		}
+		if stmt.onUpdate, err = p.parseAssignments(); err != nil {
+			return nil, err
+		}
+	}
+	return stmt, nil
+}
+
+func (p *parser) parseAssignments() ([]assignment, error) {
+	var sets []assignment
+	for {
+		col, err := p.identifier()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol("="); err != nil {
+			return nil, err
+		}
+		value, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, assignment{column: col, value: value})
+		if !p.acceptSymbol(",") {
+			return sets, nil
+		}
+	}
+}
+
+func (p *parser) parseUpdate() (*updateStmt, error) {
+	p.next()
+	table, err := p.identifier()
+	if err != nil {
+		return nil, err
+	}
+	stmt := &updateStmt{table: table}
+	if err := p.expectKeyword("SET"); err != nil {
+		return nil, err
+	}
+	if stmt.sets, err = p.parseAssignments(); err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("WHERE") {
+		if stmt.where, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	return stmt, nil
+}
+
+func (p *parser) parseDelete() (*deleteStmt, error) {
+	p.next()
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	table, err := p.identifier()
+	if err != nil {
+		return nil, err
+	}
+	stmt := &deleteStmt{table: table}
+	if p.acceptKeyword("WHERE") {
+		if stmt.where, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	return stmt, nil
+}
+
+func (p *parser) parseExpr() (expr, error) {
+	left, err := p.parseComparison()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("AND") {
+		right, err := p.parseComparison()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryOp{op: "AND", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseComparison() (expr, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	if t.kind == tokSymbol {
+		switch t.text {
+		case "=", "<>", "<", ">":
+			p.next()
+			right, err := p.parsePrimary()
+			if err != nil {
+				return nil, err
+			}
+			return binaryOp{op: t.text, left: left, right: right}, nil
+		}
+	}
+	switch {
+	case p.acceptKeyword("IN"):
+		if err := p.expectSymbol("("); err != nil {
+			return nil, err
+		}
+		var list []expr
+		for !p.acceptSymbol(")") {
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, e)
+			p.acceptSymbol(",")
+		}
+		return inOp{inner: left, list: list}, nil
+	case p.acceptKeyword("LIKE"):
+		pattern, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		return likeOp{inner: left, pattern: pattern}, nil
+	}
+	return left, nil
+}
+
+func (p *parser) parsePrimary() (expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokParam:
+		p.params++
+		return paramRef{index: p.params - 1}, nil
+	case tokNumber:
+		n, err := strconv.ParseInt(t.text, 10, 64)
+		return literal{n}, err
+	case tokKeyword:
+		switch t.text {
+		case "NULL":
+			return literal{nil}, nil
+		case "CASE":
+			return p.parseCase()
+		case "VALUES":
+			return p.parseCall("VALUES")
+		}
+	case tokSymbol:
+		if t.text == "(" {
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			return e, p.expectSymbol(")")
+		}
+	case tokIdent:
+		if p.peek().kind == tokSymbol && p.peek().text == "(" {
+			return p.parseCall(t.text)
+		}
+		p.pos--
+		name, err := p.identifier()
+		return columnRef{name: name}, err
+	}
+	return nil, fmt.Errorf("unexpected %q", t.text)
+}
+
+func (p *parser) parseCall(name string) (expr, error) {
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	call := funcCall{name: strings.ToUpper(name)}
+	if p.acceptSymbol("*") {
+		call.star = true
+		return call, p.expectSymbol(")")
+	}
+	for !p.acceptSymbol(")") {
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		call.args = append(call.args, e)
+		p.acceptSymbol(",")
+	}
+	return call, nil
+}
+
+func (p *parser) parseCase() (expr, error) {
+	c := caseOp{}
+	for p.acceptKeyword("WHEN") {
+		when, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("THEN"); err != nil {
+			return nil, err
+		}
+		then, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		c.whens = append(c.whens, when)
+		c.thens = append(c.thens, then)
+	}
+	if p.acceptKeyword("ELSE") {
+		var err error
+		if c.elseExpr, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	return c, p.expectKeyword("END")
+}
@@ -0,0 +1,263 @@
+// Package sqltest is an in-memory stand-in for the MySQL database behind GORM, for tests. Tables come
+// from the models passed to Open (columns, auto-increment keys, defaults and unique indexes), and
+// the engine runs the single-table statements GORM's MySQL dialect generates. Unique violations
+// are reported as MySQL error 1062, so gorm.ErrDuplicatedKey translation works as in production.
+//
+// It exists so `go test ./...` needs nothing but Go, with no MySQL server or container. SQLite
+// would be the usual substitute, but GORM's SQLite dialect writes different SQL (ON CONFLICT
+// instead of ON DUPLICATE KEY UPDATE) and reports duplicates differently, so the statements the
+// services depend on would go untested. Statements outside what the services generate fail to
+// parse; extend the parser together with a test when a service needs more.
+package sqltest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+)
+
+// DB is the in-memory database. Statements run one at a time; a transaction holds the database
+// until it commits or rolls back, which makes transactions serializable.
+type DB struct {
+	mu         sync.Mutex
+	txMu       sync.Mutex
+	tables     map[string]*table
+	statements []string
+}
+
+// Open returns a GORM handle on a fresh database with a table for each model
+func Open(t testing.TB, models ...any) (*gorm.DB, *DB) {
+	t.Helper()
+	db := &DB{tables: map[string]*table{}}
+	for _, model := range models {
+		if err := db.addTable(model); err != nil {
+			t.Fatalf("sqltest: %v", err)
+		}
+	}
+
+	sqlDB := sql.OpenDB(&connector{db: db})
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{Conn: sqlDB, SkipInitializeWithVersion: true}), &gorm.Config{
+		TranslateError: true,
+		Logger:         logger.Discard,
+	})
+	if err != nil {
+		t.Fatalf("sqltest: open gorm: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	return gormDB, db
+}
+
+func (db *DB) addTable(model any) error {
+	sch, err := schema.Parse(model, &sync.Map{}, schema.NamingStrategy{})
+	if err != nil {
+		return err
+	}
+	t := &table{name: sch.Table, defaults: map[string]any{}, hasColumn: map[string]bool{}}
+	for _, field := range sch.Fields {
+		if field.DBName == "" {
+			continue
+		}
+		t.columns = append(t.columns, field.DBName)
+		t.hasColumn[field.DBName] = true
+		if field.PrimaryKey {
+			t.uniques = append(t.uniques, uniqueKey{name: "PRIMARY", columns: []string{field.DBName}})
+			if field.AutoIncrement {
+				t.autoInc = field.DBName
+			}
+		}
+		if field.Unique {
+			t.uniques = append(t.uniques, uniqueKey{name: field.DBName, columns: []string{field.DBName}})
+		}
+		if field.HasDefaultValue && field.DefaultValue != "" && !field.AutoIncrement {
+			t.defaults[field.DBName] = defaultValue(field)
+		}
+	}
+	for _, idx := range sch.ParseIndexes() {
+		if idx.Class != "UNIQUE" {
+			continue
+		}
+		key := uniqueKey{name: idx.Name}
+		for _, opt := range idx.Fields {
+			key.columns = append(key.columns, opt.DBName)
+		}
+		t.uniques = append(t.uniques, key)
+	}
+	db.tables[t.name] = t
+	return nil
+}
+
+// defaultValue converts a gorm default tag to a stored value
+func defaultValue(field *schema.Field) any {
+	raw := strings.Trim(field.DefaultValue, "'")
+	switch field.DataType {
+	case schema.Bool:
+		if b, err := strconv.ParseBool(raw); err == nil {
+			return normalize(b)
+		}
+	}
+	return raw
+}
+
+// Statements returns the SQL run since the last call and forgets it
+func (db *DB) Statements() []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	statements := db.statements
+	db.statements = nil
+	return statements
+}
+
+func (db *DB) run(query string, args []driver.NamedValue) (*resultSet, execResult, error) {
+	stmt, params, err := parse(query)
+	if err != nil {
+		return nil, execResult{}, err
+	}
+	if params != len(args) {
+		return nil, execResult{}, fmt.Errorf("sqltest: %q takes %d arguments, got %d", query, params, len(args))
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.statements = append(db.statements, query)
+	switch s := stmt.(type) {
+	case *selectStmt:
+		rows, err := db.query(s, args)
+		return rows, execResult{}, err
+	case *insertStmt:
+		result, err := db.insert(s, args)
+		return nil, result, err
+	case *updateStmt:
+		result, err := db.update(s, args)
+		return nil, result, err
+	case *deleteStmt:
+		result, err := db.delete(s, args)
+		return nil, result, err
+	}
+	return nil, execResult{}, fmt.Errorf("sqltest: unsupported statement %q", query)
+}
+
+func (db *DB) snapshot() map[string]*table {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	copied := make(map[string]*table, len(db.tables))
+	for name, t := range db.tables {
+		copied[name] = t.clone()
+	}
+	return copied
+}
+
+func (db *DB) restore(tables map[string]*table) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.tables = tables
+}
+
+// database/sql driver plumbing
+
+type connector struct{ db *DB }
+
+func (c *connector) Connect(context.Context) (driver.Conn, error) { return &conn{db: c.db}, nil }
+func (c *connector) Driver() driver.Driver                        { return sqlDriver{} }
+
+type sqlDriver struct{}
+
+func (sqlDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("sqltest: use sqltest.Open")
+}
+
+type conn struct {
+	db *DB
+	tx *tx
+}
+
+func (c *conn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("sqltest: prepared statements aren't supported")
+}
+func (c *conn) Close() error              { return nil }
+func (c *conn) Begin() (driver.Tx, error) { return c.BeginTx(context.Background(), driver.TxOptions{}) }
+
+func (c *conn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	c.db.txMu.Lock()
+	c.tx = &tx{conn: c, saved: c.db.snapshot()}
+	return c.tx, nil
+}
+
+func (c *conn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	_, result, err := c.db.run(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return sqlResult(result), nil
+}
+
+func (c *conn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	result, _, err := c.db.run(query, args)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		result = &resultSet{}
+	}
+	return &rows{result: result}, nil
+}
+
+// CheckNamedValue lets the models' uint keys through as int64
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	if v, ok := nv.Value.(uint); ok {
+		nv.Value = int64(v)
+		return nil
+	}
+	return driver.ErrSkip
+}
+
+type tx struct {
+	conn  *conn
+	saved map[string]*table
+}
+
+func (t *tx) Commit() error {
+	t.conn.tx = nil
+	t.conn.db.txMu.Unlock()
+	return nil
+}
+
+func (t *tx) Rollback() error {
+	t.conn.db.restore(t.saved)
+	t.conn.tx = nil
+	t.conn.db.txMu.Unlock()
+	return nil
+}
+
+type sqlResult execResult
+
+func (r sqlResult) LastInsertId() (int64, error) { return r.lastInsertID, nil }
+func (r sqlResult) RowsAffected() (int64, error) { return r.affected, nil }
+
+type rows struct {
+	result *resultSet
+	pos    int
+}
+
+func (r *rows) Columns() []string { return r.result.columns }
+func (r *rows) Close() error      { return nil }
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.result.rows) {
+		return io.EOF
+	}
+	copy(dest, r.result.rows[r.pos])
+	r.pos++
+	return nil
+}
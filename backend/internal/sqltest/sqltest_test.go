@@ -0,0 +1,106 @@
+package sqltest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type account struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement"`
+	Email     string    `gorm:"size:100;not null;unique"`
+	Name      string    `gorm:"size:50"`
+	Role      string    `gorm:"size:20;default:member"`
+	Active    bool      `gorm:"default:true"`
+	CreatedAt time.Time `gorm:""`
+}
+
+func TestGormRoundTrip(t *testing.T) {
+	gdb, _ := Open(t, &account{})
+
+	alice := account{Email: "alice@example.com", Name: "alice"}
+	if err := gdb.Create(&alice).Error; err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	bob := account{Email: "bob@example.com", Name: "bob", Role: "admin"}
+	if err := gdb.Create(&bob).Error; err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if alice.ID != 1 || bob.ID != 2 {
+		t.Fatalf("ids = %d, %d", alice.ID, bob.ID)
+	}
+
+	var found account
+	if err := gdb.Where("email = ?", "ALICE@example.com").First(&found).Error; err != nil {
+		t.Fatalf("first: %v", err)
+	}
+	if found.Role != "member" || !found.Active {
+		t.Fatalf("defaults not applied: %+v", found)
+	}
+
+	dup := account{Email: "alice@example.com"}
+	if err := gdb.Create(&dup).Error; !errors.Is(err, gorm.ErrDuplicatedKey) {
+		t.Fatalf("duplicate create error = %v", err)
+	}
+
+	var names []account
+	if err := gdb.Select("id", "name").Where("name LIKE ? AND id <> ?", "%o%", 0).Order("name DESC").Limit(5).Find(&names).Error; err != nil {
+		t.Fatalf("find: %v", err)
+	}
+	if len(names) != 1 || names[0].Name != "bob" {
+		t.Fatalf("names = %+v", names)
+	}
+
+	// Prefix matches first, the way user search orders results
+	byPrefix := clause.OrderBy{Expression: clause.Expr{SQL: "CASE WHEN name LIKE ? THEN 0 ELSE 1 END, name", Vars: []any{"b%"}}}
+	if err := gdb.Where("name LIKE ?", "%").Clauses(byPrefix).Find(&names).Error; err != nil {
+		t.Fatalf("find ordered by case: %v", err)
+	}
+	if len(names) != 2 || names[0].Name != "bob" || names[1].Name != "alice" {
+		t.Fatalf("names = %+v", names)
+	}
+
+	if err := gdb.Model(&account{}).Where("id IN ?", []uint{1, 2}).Update("active", false).Error; err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	var active int64
+	gdb.Model(&account{}).Where("active = ?", true).Count(&active)
+	if active != 0 {
+		t.Fatalf("active = %d", active)
+	}
+
+	upsert := account{Email: "bob@example.com", Name: "robert"}
+	err := gdb.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "email"}},
+		DoUpdates: clause.AssignmentColumns([]string{"name"}),
+	}).Create(&upsert).Error
+	if err != nil {
+		t.Fatalf("upsert: %v", err)
+	}
+	var updated account
+	gdb.First(&updated, bob.ID)
+	if updated.Name != "robert" || updated.Active {
+		t.Fatalf("upsert didn't update: %+v", updated)
+	}
+
+	err = gdb.Transaction(func(tx *gorm.DB) error {
+		tx.Where("id = ?", alice.ID).Delete(&account{})
+		return errors.New("roll back")
+	})
+	if err == nil {
+		t.Fatal("expected the transaction error")
+	}
+	var total int64
+	gdb.Model(&account{}).Count(&total)
+	if total != 2 {
+		t.Fatalf("rollback left %d rows, want 2", total)
+	}
+
+	// SQL the services don't generate fails instead of being half-understood
+	if err := gdb.Where("name = ? OR name = ?", "alice", "bob").Find(&names).Error; err == nil {
+		t.Fatal("expected OR to be rejected")
+	}
+}
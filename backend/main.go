@@ -9,9 +9,13 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/ginchat/config"
+	"github.com/ginchat/middleware"
 	"github.com/ginchat/models"
 	"github.com/ginchat/routes"
+	"github.com/ginchat/utils"
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
@@ -56,9 +60,35 @@ func initLogger() {
 	logger.SetLevel(logrus.InfoLevel)
 }
 
-func initMySQL() {
+// maxDBConnectRetryDelay caps the backoff between database connection attempts
+const maxDBConnectRetryDelay = 30 * time.Second
+
+// connectWithRetry calls connect up to attempts times, doubling the delay between tries (capped at maxDBConnectRetryDelay).
+// Databases often come up after the app in orchestrated deployments, so a failed first attempt isn't fatal.
+func connectWithRetry(name string, attempts int, delay time.Duration, connect func() error) error {
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = connect(); err == nil {
+			return nil
+		}
+		if attempt == attempts {
+			break
+		}
+
+		logger.Warnf("Failed to connect to %s (attempt %d/%d): %v; retrying in %s", name, attempt, attempts, err, delay)
+		time.Sleep(delay)
+
+		delay *= 2
+		if delay > maxDBConnectRetryDelay {
+			delay = maxDBConnectRetryDelay
+		}
+	}
+	return err
+}
+
+func initMySQL(cfg *config.Config) {
 	// Check if we have a full URI
-	mysqlURI := os.Getenv("MYSQL_URI")
+	mysqlURI := cfg.MySQLURI
 	var dsn string
 
 	if mysqlURI != "" {
@@ -112,40 +142,45 @@ func initMySQL() {
 	} else {
 		// Fallback to individual parameters
 		dsn = fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local",
-			os.Getenv("MYSQL_USER"),
-			os.Getenv("MYSQL_PASSWORD"),
-			os.Getenv("MYSQL_HOST"),
-			os.Getenv("MYSQL_PORT"),
-			os.Getenv("MYSQL_DATABASE"),
+			cfg.MySQLUser,
+			cfg.MySQLPassword,
+			cfg.MySQLHost,
+			cfg.MySQLPort,
+			cfg.MySQLDatabase,
 		)
 
 		// Add SSL if required
-		if os.Getenv("MYSQL_SSL_MODE") == "REQUIRED" {
+		if cfg.MySQLSSLMode == "REQUIRED" {
 			dsn += "&tls=skip-verify"
 		}
 	}
 
-	var err error
-	mysqlDB, err = gorm.Open(mysql.Open(dsn), &gorm.Config{})
+	// gorm.Open pings the server, so a database that isn't up yet fails here and is retried
+	err := connectWithRetry("MySQL", cfg.DBConnectAttempts, cfg.DBConnectRetryDelay, func() error {
+		var err error
+		// TranslateError turns MySQL duplicate-key errors into gorm.ErrDuplicatedKey for the services
+		mysqlDB, err = gorm.Open(mysql.Open(dsn), &gorm.Config{TranslateError: true})
+		return err
+	})
 	if err != nil {
 		logger.Fatalf("Failed to connect to MySQL: %v", err)
 	}
 
+	// Connection pool tuning
+	sqlDB, err := mysqlDB.DB()
+	if err != nil {
+		logger.Fatalf("Failed to get MySQL connection pool: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(cfg.MySQLMaxOpenConns)
+	sqlDB.SetMaxIdleConns(cfg.MySQLMaxIdleConns)
+	sqlDB.SetConnMaxLifetime(cfg.MySQLConnMaxLifetime)
+
 	logger.Info("Connected to MySQL database successfully")
 }
 
-func initMongoDB() {
-	// Get MongoDB URI from environment variables
-	mongoURI := os.Getenv("MONGO_URI")
-	if mongoURI == "" {
-		logger.Fatal("MONGO_URI environment variable is not set")
-	}
-
-	// Get database name from environment variables
-	dbName := os.Getenv("MONGO_DATABASE")
-	if dbName == "" {
-		dbName = "ginchat"
-	}
+func initMongoDB(cfg *config.Config) {
+	mongoURI := cfg.MongoURI
+	dbName := cfg.MongoDatabase
 
 	logger.Infof("Connecting to MongoDB Atlas with database: %s", dbName)
 
@@ -179,31 +214,50 @@ func initMongoDB() {
 	// Apply the URI
 	clientOptions.ApplyURI(mongoURI)
 
-	// Connect to MongoDB
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	// Connect to MongoDB and ping it, retrying while the server isn't reachable yet
+	var client *mongo.Client
+	err := connectWithRetry("MongoDB", cfg.DBConnectAttempts, cfg.DBConnectRetryDelay, func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
 
-	client, err := mongo.Connect(ctx, clientOptions)
-	if err != nil {
-		logger.Fatalf("Failed to connect to MongoDB: %v", err)
-	}
+		var err error
+		client, err = mongo.Connect(ctx, clientOptions)
+		if err != nil {
+			return err
+		}
 
-	// Ping the database to verify connection
-	err = client.Ping(ctx, nil)
+		// Ping the database to verify connection
+		if err := client.Ping(ctx, nil); err != nil {
+			client.Disconnect(context.Background())
+			return fmt.Errorf("ping failed: %w", err)
+		}
+		return nil
+	})
 	if err != nil {
-		logger.Fatalf("Failed to ping MongoDB: %v", err)
+		logger.Fatalf("Failed to connect to MongoDB: %v", err)
 	}
 
 	mongoDB = client.Database(dbName)
 	logger.Info("Connected to MongoDB Atlas database successfully")
 }
 
-func setupRouter() *gin.Engine {
+func setupRouter(cfg *config.Config) *gin.Engine {
 	r := gin.Default()
 
+	allowedOrigins := make(map[string]bool, len(cfg.CORSOrigins))
+	for _, origin := range cfg.CORSOrigins {
+		allowedOrigins[origin] = true
+	}
+
 	// CORS middleware
 	r.Use(func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+		if cfg.AllowsAnyOrigin() {
+			c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+		} else if origin := c.GetHeader("Origin"); allowedOrigins[origin] {
+			// Echo back only listed origins; others get no CORS headers and are blocked by the browser
+			c.Writer.Header().Set("Access-Control-Allow-Origin", origin)
+			c.Writer.Header().Add("Vary", "Origin")
+		}
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
 		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
@@ -216,9 +270,16 @@ func setupRouter() *gin.Engine {
 		c.Next()
 	})
 
+	if cfg.SecurityHeadersEnabled {
+		r.Use(middleware.SecurityHeaders(cfg.ContentSecurityPolicy, cfg.FrameOptions))
+	}
+
 	// Swagger documentation
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
+	// Prometheus metrics
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
 	return r
 }
 
@@ -232,17 +293,55 @@ func initDatabase() {
 		}
 		logger.Info("User model migrated successfully")
 
-		// Then migrate PushToken model
+		// Then migrate PushToken model; the token became unique, so older duplicate rows go first
+		preparePushTokenUniqueIndex()
 		err = mysqlDB.AutoMigrate(&models.PushToken{})
 		if err != nil {
 			logger.Fatalf("Failed to migrate PushToken model: %v", err)
 		}
 		logger.Info("PushToken model migrated successfully")
 
+		err = mysqlDB.AutoMigrate(&models.RevokedToken{})
+		if err != nil {
+			logger.Fatalf("Failed to migrate RevokedToken model: %v", err)
+		}
+		logger.Info("RevokedToken model migrated successfully")
+
+		err = mysqlDB.AutoMigrate(&models.Session{})
+		if err != nil {
+			logger.Fatalf("Failed to migrate Session model: %v", err)
+		}
+		logger.Info("Session model migrated successfully")
+
 		logger.Info("All MySQL models migrated successfully")
 	}
 }
 
+// preparePushTokenUniqueIndex removes duplicate push token rows, keeping the most recently updated one,
+// and drops the old non-unique token index so AutoMigrate can add the unique one
+func preparePushTokenUniqueIndex() {
+	migrator := mysqlDB.Migrator()
+	if !migrator.HasTable(&models.PushToken{}) {
+		return
+	}
+
+	result := mysqlDB.Exec(`DELETE older FROM push_tokens older
+		JOIN push_tokens newer ON older.token = newer.token
+			AND (older.updated_at < newer.updated_at OR (older.updated_at = newer.updated_at AND older.id < newer.id))`)
+	if result.Error != nil {
+		logger.Fatalf("Failed to remove duplicate push tokens: %v", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		logger.Infof("Removed %d duplicate push token rows", result.RowsAffected)
+	}
+
+	if migrator.HasIndex(&models.PushToken{}, "idx_token_hash") {
+		if err := migrator.DropIndex(&models.PushToken{}, "idx_token_hash"); err != nil {
+			logger.Fatalf("Failed to drop old push token index: %v", err)
+		}
+	}
+}
+
 func main() {
 	// Load environment variables
 	if err := godotenv.Load(); err != nil {
@@ -252,25 +351,27 @@ func main() {
 	// Initialize components
 	initLogger()
 
+	// Load and validate configuration once; everything below reads from cfg
+	cfg, err := config.Load()
+	if err != nil {
+		logger.Fatal(err)
+	}
+	utils.ConfigureJWT(cfg.JWTSecret, cfg.JWTExpiration)
+
 	// Initialize database connections
-	initMySQL()
-	initMongoDB()
+	initMySQL(cfg)
+	initMongoDB(cfg)
 	initDatabase()
 
 	// Setup router
-	r := setupRouter()
+	r := setupRouter(cfg)
 
 	// Setup routes
-	routes.SetupRoutes(r, mysqlDB, mongoDB, logger)
+	routes.SetupRoutes(r, mysqlDB, mongoDB, logger, cfg)
 
 	// Start server
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
-
-	logger.Infof("Server starting on port %s", port)
-	if err := r.Run(":" + port); err != nil {
+	logger.Infof("Server starting on port %s", cfg.Port)
+	if err := r.Run(":" + cfg.Port); err != nil {
 		logger.Fatalf("Failed to start server: %v", err)
 	}
 }
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ginchat/config"
+	"github.com/ginchat/controllers"
+	"github.com/ginchat/internal/mongotest"
+	"github.com/ginchat/models"
+	"github.com/ginchat/services"
+	"github.com/ginchat/utils"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// scrapeMetrics fetches /metrics from the router
+func scrapeMetrics(t *testing.T, r *gin.Engine) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /metrics = %d", w.Code)
+	}
+	return w.Body.String()
+}
+
+// metricValue returns the value of one series (e.g. `name` or `name{label="x"}`) in a scrape, 0 if absent
+func metricValue(t *testing.T, body, series string) float64 {
+	t.Helper()
+	for _, line := range strings.Split(body, "\n") {
+		if value, ok := strings.CutPrefix(line, series+" "); ok {
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				t.Fatalf("parse %s: %v", line, err)
+			}
+			return v
+		}
+	}
+	return 0
+}
+
+func TestMetricsEndpointCountsOperations(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	utils.ConfigureJWT("main-test-secret", time.Hour)
+	r := setupRouter(&config.Config{})
+
+	db, _ := mongotest.NewDatabase(t)
+	chatroomService := services.NewChatroomService(db, false, nil)
+	readStatusService := services.NewMessageReadStatusService(db, chatroomService, nil, false, 100)
+	messageService := services.NewMessageService(db, chatroomService, nil, readStatusService, nil, nil, 100, config.DefaultMessageEditWindow)
+
+	chatroomID := primitive.NewObjectID()
+	_, err := db.Collection("chatrooms").InsertOne(context.Background(), models.Chatroom{
+		ID:        chatroomID,
+		Name:      "General",
+		CreatedBy: 1,
+		Members: []models.ChatroomMember{
+			{UserID: 1, Username: "alice", Role: models.ChatroomRoleAdmin},
+			{UserID: 2, Username: "bob", Role: models.ChatroomRoleMember},
+		},
+	})
+	if err != nil {
+		t.Fatalf("seed chatroom: %v", err)
+	}
+
+	const (
+		sent        = "ginchat_messages_sent_total"
+		markedRead  = `ginchat_read_status_operations_total{operation="mark_read"}`
+		connections = "ginchat_websocket_connections"
+	)
+	before := scrapeMetrics(t, r)
+	for _, name := range []string{sent, "ginchat_push_notifications_sent_total", connections, "ginchat_websocket_broadcast_errors_total"} {
+		if !strings.Contains(before, "# TYPE "+name+" ") {
+			t.Errorf("/metrics doesn't expose %s", name)
+		}
+	}
+
+	// A message sent and read, and a socket opened through the real call sites
+	message, err := messageService.SendMessage(chatroomID, 1, "alice", "text", "hello", "")
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	if err := readStatusService.MarkMessageAsRead(message.ID, 2); err != nil {
+		t.Fatalf("MarkMessageAsRead: %v", err)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	wsc := controllers.NewWebSocketController(logger, time.Minute, time.Minute, 64*1024)
+	wsRouter := gin.New()
+	wsRouter.GET("/ws", wsc.HandleConnection)
+	server := httptest.NewServer(wsRouter)
+	defer server.Close()
+	token, err := utils.GenerateJWT(1, "alice", "alice@example.com", "member")
+	if err != nil {
+		t.Fatalf("GenerateJWT: %v", err)
+	}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws?token="+token+"&room_id=global_sidebar", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := conn.ReadMessage(); err != nil {
+		t.Fatalf("read connected frame: %v", err)
+	}
+
+	after := scrapeMetrics(t, r)
+	for _, series := range []string{sent, markedRead, connections} {
+		if delta := metricValue(t, after, series) - metricValue(t, before, series); delta != 1 {
+			t.Errorf("%s went up by %v, want 1", series, delta)
+		}
+	}
+	if strings.Contains(after, chatroomID.Hex()) {
+		t.Error("/metrics has a per-room label")
+	}
+}
+
+func TestConnectWithRetry(t *testing.T) {
+	logger = logrus.New()
+	logger.SetOutput(io.Discard)
+
+	t.Run("succeeds after failures", func(t *testing.T) {
+		calls := 0
+		err := connectWithRetry("test", 5, time.Millisecond, func() error {
+			calls++
+			if calls <= 2 {
+				return errors.New("connection refused")
+			}
+			return nil
+		})
+		if err != nil || calls != 3 {
+			t.Errorf("got %v after %d attempts, want success on attempt 3", err, calls)
+		}
+	})
+
+	t.Run("gives up after the last attempt", func(t *testing.T) {
+		calls := 0
+		refused := errors.New("connection refused")
+		err := connectWithRetry("test", 4, time.Millisecond, func() error {
+			calls++
+			return refused
+		})
+		if !errors.Is(err, refused) || calls != 4 {
+			t.Errorf("got %v after %d attempts, want the connector's error after 4", err, calls)
+		}
+	})
+}
+
+func TestSecurityHeadersToggle(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for _, enabled := range []bool{true, false} {
+		r := setupRouter(&config.Config{
+			SecurityHeadersEnabled: enabled,
+			ContentSecurityPolicy:  config.DefaultContentSecurityPolicy,
+			FrameOptions:           config.DefaultFrameOptions,
+		})
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+		want := map[string]string{"X-Content-Type-Options": "", "X-Frame-Options": "", "Content-Security-Policy": ""}
+		if enabled {
+			want = map[string]string{"X-Content-Type-Options": "nosniff", "X-Frame-Options": "DENY", "Content-Security-Policy": config.DefaultContentSecurityPolicy}
+		}
+		for name, value := range want {
+			if got := w.Header().Get(name); got != value {
+				t.Errorf("enabled=%t: %s = %q, want %q", enabled, name, got, value)
+			}
+		}
+	}
+}
@@ -5,16 +5,29 @@ import (
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/ginchat/models"
 	"github.com/ginchat/utils"
 )
 
-// AuthMiddleware is a middleware for authenticating users using JWT
-func AuthMiddleware() gin.HandlerFunc {
+// TokenRevocationChecker reports whether a token has been revoked (e.g. by logging out)
+type TokenRevocationChecker interface {
+	IsTokenRevoked(tokenID string) bool
+}
+
+// SessionActivityRecorder keeps a session's last activity time current.
+// TouchSession runs on every authenticated request before the handler, so it must be cheap.
+type SessionActivityRecorder interface {
+	TouchSession(tokenID string)
+}
+
+// AuthMiddleware is a middleware for authenticating users using JWT.
+// Tokens revocations reports as revoked are rejected; sessions, if not nil, is told the token ID of every authenticated request.
+func AuthMiddleware(revocations TokenRevocationChecker, sessions SessionActivityRecorder) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get Authorization header
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Please log in to continue"})
+			c.JSON(http.StatusUnauthorized, utils.NewAPIError(http.StatusUnauthorized, utils.CodeUnauthorized, "Please log in to continue"))
 			c.Abort()
 			return
 		}
@@ -22,7 +35,7 @@ func AuthMiddleware() gin.HandlerFunc {
 		// Check if the header has the Bearer prefix
 		parts := strings.Split(authHeader, " ")
 		if len(parts) != 2 || parts[0] != "Bearer" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication failed. Please log in again"})
+			c.JSON(http.StatusUnauthorized, utils.NewAPIError(http.StatusUnauthorized, utils.CodeUnauthorized, "Authentication failed. Please log in again"))
 			c.Abort()
 			return
 		}
@@ -32,8 +45,8 @@ func AuthMiddleware() gin.HandlerFunc {
 
 		// Validate token
 		claims, err := utils.ValidateJWT(tokenString)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Your session has expired. Please log in again"})
+		if err != nil || (revocations != nil && revocations.IsTokenRevoked(claims.Id)) {
+			c.JSON(http.StatusUnauthorized, utils.NewAPIError(http.StatusUnauthorized, utils.CodeUnauthorized, "Your session has expired. Please log in again"))
 			c.Abort()
 			return
 		}
@@ -43,6 +56,25 @@ func AuthMiddleware() gin.HandlerFunc {
 		c.Set("username", claims.Username)
 		c.Set("email", claims.Email)
 		c.Set("role", claims.Role)
+		c.Set("token_id", claims.Id)
+		c.Set("token_expires_at", claims.ExpiresAt)
+
+		if sessions != nil {
+			sessions.TouchSession(claims.Id)
+		}
+
+		c.Next()
+	}
+}
+
+// AdminOnly rejects requests from users without the global admin role. It must run after AuthMiddleware.
+func AdminOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if role, _ := c.Get("role"); role != models.UserRoleAdmin {
+			c.JSON(http.StatusForbidden, utils.NewAPIError(http.StatusForbidden, utils.CodeForbidden, "This action requires an administrator"))
+			c.Abort()
+			return
+		}
 
 		c.Next()
 	}
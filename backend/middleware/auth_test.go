@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ginchat/utils"
+)
+
+// sessionRecorder records touched token IDs and which tokens count as revoked
+type sessionRecorder struct {
+	revoked map[string]bool
+	touched []string
+}
+
+func (r *sessionRecorder) IsTokenRevoked(tokenID string) bool { return r.revoked[tokenID] }
+func (r *sessionRecorder) TouchSession(tokenID string)        { r.touched = append(r.touched, tokenID) }
+
+func TestAuthMiddlewareTouchesSessionBeforeHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	utils.ConfigureJWT("middleware-test-secret", time.Hour)
+	valid, err := utils.IssueJWT(1, "alice", "alice@example.com", "member")
+	if err != nil {
+		t.Fatalf("IssueJWT: %v", err)
+	}
+	revoked, err := utils.IssueJWT(1, "alice", "alice@example.com", "member")
+	if err != nil {
+		t.Fatalf("IssueJWT: %v", err)
+	}
+
+	recorder := &sessionRecorder{revoked: map[string]bool{revoked.ID: true}}
+	var touchedInHandler []string
+	r := gin.New()
+	r.Use(AuthMiddleware(recorder, recorder))
+	r.GET("/api/ping", func(c *gin.Context) {
+		touchedInHandler = append([]string(nil), recorder.touched...)
+		c.String(http.StatusOK, "pong")
+	})
+	serve := func(header string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/ping", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := serve("Bearer " + valid.Token); w.Code != http.StatusOK {
+		t.Fatalf("valid token: status = %d, want 200", w.Code)
+	}
+	// The touch has already happened by the time the handler runs, not at some later point
+	if len(touchedInHandler) != 1 || touchedInHandler[0] != valid.ID {
+		t.Errorf("touched before handler = %v, want [%s]", touchedInHandler, valid.ID)
+	}
+
+	for _, header := range []string{"", "Bearer " + revoked.Token, "Bearer not-a-jwt", "Token " + valid.Token} {
+		w := serve(header)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: status = %d, want 401", header, w.Code)
+		}
+		// Same envelope as every other API error
+		var body utils.APIError
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Code != utils.CodeUnauthorized || body.Message == "" {
+			t.Errorf("Authorization %q: body = %s, want an %s error", header, w.Body.String(), utils.CodeUnauthorized)
+		}
+	}
+	if len(recorder.touched) != 1 {
+		t.Errorf("touched = %v, want only the valid request's token", recorder.touched)
+	}
+}
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	serve := func(csp, path string) http.Header {
+		r := gin.New()
+		r.Use(SecurityHeaders(csp, "SAMEORIGIN"))
+		r.GET("/api/ping", func(c *gin.Context) { c.String(http.StatusOK, "pong") })
+		r.GET("/swagger/*any", func(c *gin.Context) { c.String(http.StatusOK, "docs") })
+		r.GET("/api/fail", func(c *gin.Context) { c.AbortWithStatus(http.StatusInternalServerError) })
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Header()
+	}
+
+	for _, path := range []string{"/api/ping", "/api/fail", "/not-found"} {
+		header := serve("default-src 'self'", path)
+		for name, want := range map[string]string{
+			"X-Content-Type-Options":  "nosniff",
+			"X-Frame-Options":         "SAMEORIGIN",
+			"Referrer-Policy":         "strict-origin-when-cross-origin",
+			"Content-Security-Policy": "default-src 'self'",
+		} {
+			if got := header.Get(name); got != want {
+				t.Errorf("%s: %s = %q, want %q", path, name, got, want)
+			}
+		}
+	}
+
+	if got := serve("", "/api/ping"); got.Get("Content-Security-Policy") != "" || got.Get("X-Content-Type-Options") != "nosniff" {
+		t.Errorf("empty policy: headers = %v, want the other headers without a CSP", got)
+	}
+	if got := serve("default-src 'self'", "/swagger/index.html"); got.Get("Content-Security-Policy") != "" || got.Get("X-Frame-Options") != "SAMEORIGIN" {
+		t.Errorf("swagger: headers = %v, want no CSP but the other headers", got)
+	}
+}
@@ -1,44 +1,179 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Chatroom field length limits
+const (
+	MinChatroomNameLength        = 3
+	MaxChatroomNameLength        = 100
+	MaxChatroomDescriptionLength = 500
+	MaxChatroomTopicLength       = 100
+)
+
+// Chatroom message filter policies (what happens to messages containing banned words)
+const (
+	ChatroomFilterMask   = "mask"   // Banned words are replaced with asterisks (default)
+	ChatroomFilterReject = "reject" // Messages with banned words are refused
+	ChatroomFilterOff    = "off"    // No filtering in this room
+)
+
+// IsValidChatroomFilterPolicy checks if the value is a supported filter policy
+func IsValidChatroomFilterPolicy(policy string) bool {
+	switch policy {
+	case ChatroomFilterMask, ChatroomFilterReject, ChatroomFilterOff:
+		return true
+	default:
+		return false
+	}
+}
+
+// Media types a chatroom can allow (same values as utils.MediaType)
+const (
+	ChatroomMediaImage = "image"
+	ChatroomMediaAudio = "audio"
+	ChatroomMediaVideo = "video"
+)
+
+// IsValidChatroomMediaType checks if the value is a media type a chatroom can allow
+func IsValidChatroomMediaType(mediaType string) bool {
+	switch mediaType {
+	case ChatroomMediaImage, ChatroomMediaAudio, ChatroomMediaVideo:
+		return true
+	default:
+		return false
+	}
+}
+
+// MaxMessageEditWindowMinutes is the longest edit window a room can set (one week); 0 means no limit
+const MaxMessageEditWindowMinutes = 7 * 24 * 60
+
 // Chatroom represents a chat room in the system
 type Chatroom struct {
-	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	Name        string             `bson:"name" json:"name"`
-	RoomCode    string             `bson:"room_code" json:"room_code"`
-	Password    string             `bson:"password,omitempty" json:"-"` // Don't include in JSON response
-	HasPassword bool               `bson:"has_password" json:"has_password"`
-	CreatedBy   uint               `bson:"created_by" json:"created_by"`
-	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
-	Members     []ChatroomMember   `bson:"members" json:"members"`
+	ID                primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name              string             `bson:"name" json:"name"`
+	Description       string             `bson:"description,omitempty" json:"description,omitempty"`
+	Topic             string             `bson:"topic,omitempty" json:"topic,omitempty"`
+	FilterPolicy      string             `bson:"filter_policy,omitempty" json:"filter_policy,omitempty"`                 // Empty means mask
+	AllowedMediaTypes []string           `bson:"allowed_media_types,omitempty" json:"allowed_media_types,omitempty"`     // Media types members may send; empty allows all
+	ReadReceipts      *bool              `bson:"read_receipts_enabled,omitempty" json:"read_receipts_enabled,omitempty"` // Whether members see who read a message; nil means enabled
+	EditWindowMinutes *int               `bson:"edit_window_minutes,omitempty" json:"edit_window_minutes,omitempty"`     // How long messages stay editable; nil uses the server default, 0 means forever
+	RoomCode          string             `bson:"room_code" json:"room_code"`
+	Password          string             `bson:"password,omitempty" json:"-"` // Don't include in JSON response
+	HasPassword       bool               `bson:"has_password" json:"has_password"`
+	CreatedBy         uint               `bson:"created_by" json:"created_by"`
+	CreatedAt         time.Time          `bson:"created_at" json:"created_at"`
+	Members           []ChatroomMember   `bson:"members" json:"members"`
 }
 
 // ChatroomResponse is a struct for returning chatroom data
 type ChatroomResponse struct {
-	ID          string           `json:"id" example:"60d5f8b8e6b5f0b3e8b4b5b3"` // The unique identifier of the chatroom
-	Name        string           `json:"name" example:"General Chat"`           // The name of the chatroom
-	RoomCode    string           `json:"room_code" example:"ABC123"`            // The room code for joining
-	HasPassword bool             `json:"has_password" example:"true"`           // Whether the room has a password
-	CreatedBy   uint             `json:"created_by" example:"1"`                // The ID of the user who created the chatroom
-	CreatedAt   time.Time        `json:"created_at"`                            // The timestamp when the chatroom was created
-	Members     []ChatroomMember `json:"members"`                               // The list of members in the chatroom
+	ID                string           `json:"id" example:"60d5f8b8e6b5f0b3e8b4b5b3"`                // The unique identifier of the chatroom
+	Name              string           `json:"name" example:"General Chat"`                          // The name of the chatroom
+	Description       string           `json:"description" example:"Say hi here"`                    // Longer description of the chatroom
+	Topic             string           `json:"topic" example:"Weekend plans"`                        // Current topic shown in the chat header
+	FilterPolicy      string           `json:"filter_policy" example:"mask" enums:"mask,reject,off"` // What happens to messages with banned words
+	AllowedMediaTypes []string         `json:"allowed_media_types" example:"image"`                  // Media types members may send (empty means all)
+	ReadReceipts      bool             `json:"read_receipts_enabled" example:"true"`                 // Whether per-member read details are shown
+	EditWindowMinutes *int             `json:"edit_window_minutes" example:"15"`                     // Minutes after sending that a message can be edited; 0 means no limit, null uses the server default
+	RoomCode          string           `json:"room_code" example:"ABC123"`                           // The room code for joining
+	HasPassword       bool             `json:"has_password" example:"true"`                          // Whether the room has a password
+	CreatedBy         uint             `json:"created_by" example:"1"`                               // The ID of the user who created the chatroom
+	CreatedAt         time.Time        `json:"created_at"`                                           // The timestamp when the chatroom was created
+	Members           []ChatroomMember `json:"members"`                                              // The list of members in the chatroom
+	Pinned            bool             `json:"pinned" example:"false"`                               // Whether the requesting user pinned this chatroom
+	PinnedAt          *time.Time       `json:"pinned_at,omitempty"`                                  // When the requesting user pinned it (newest pins sort first)
 }
 
 // ToResponse converts a Chatroom to a ChatroomResponse
 func (c *Chatroom) ToResponse() ChatroomResponse {
 	return ChatroomResponse{
+		ID:                c.ID.Hex(),
+		Name:              c.Name,
+		Description:       c.Description,
+		Topic:             c.Topic,
+		FilterPolicy:      c.GetFilterPolicy(),
+		AllowedMediaTypes: c.GetAllowedMediaTypes(),
+		ReadReceipts:      c.ReadReceiptsEnabled(),
+		EditWindowMinutes: c.EditWindowMinutes,
+		RoomCode:          c.RoomCode,
+		HasPassword:       c.HasPassword,
+		CreatedBy:         c.CreatedBy,
+		CreatedAt:         c.CreatedAt,
+		Members:           c.Members,
+	}
+}
+
+// GetAllowedMediaTypes returns the media types members may send; never nil, empty means all
+func (c *Chatroom) GetAllowedMediaTypes() []string {
+	if c.AllowedMediaTypes == nil {
+		return []string{}
+	}
+	return c.AllowedMediaTypes
+}
+
+// AllowsMediaType reports whether members may send media of this type here
+func (c *Chatroom) AllowsMediaType(mediaType string) bool {
+	if len(c.AllowedMediaTypes) == 0 {
+		return true
+	}
+	for _, allowed := range c.AllowedMediaTypes {
+		if allowed == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// GetFilterPolicy returns the room's message filter policy, defaulting to mask
+func (c *Chatroom) GetFilterPolicy() string {
+	if c.FilterPolicy == "" {
+		return ChatroomFilterMask
+	}
+	return c.FilterPolicy
+}
+
+// ReadReceiptsEnabled reports whether members can see who read each message (on unless turned off)
+func (c *Chatroom) ReadReceiptsEnabled() bool {
+	return c.ReadReceipts == nil || *c.ReadReceipts
+}
+
+// MessageEditWindow returns how long after sending a message may be edited, or defaultWindow if the room
+// doesn't set its own; 0 means there is no limit
+func (c *Chatroom) MessageEditWindow(defaultWindow time.Duration) time.Duration {
+	if c.EditWindowMinutes == nil {
+		return defaultWindow
+	}
+	return time.Duration(*c.EditWindowMinutes) * time.Minute
+}
+
+// MarshalJSON encodes a Chatroom as its ChatroomResponse, so the stored document
+// (including the password) is never serialized even if a handler forgets ToResponse
+func (c Chatroom) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.ToResponse())
+}
+
+// ChatroomPublicResponse is the minimal chatroom view shown to non-members
+type ChatroomPublicResponse struct {
+	ID          string `json:"id" example:"60d5f8b8e6b5f0b3e8b4b5b3"` // The unique identifier of the chatroom
+	Name        string `json:"name" example:"General Chat"`           // The name of the chatroom
+	Topic       string `json:"topic" example:"Weekend plans"`         // Current topic of the chatroom
+	HasPassword bool   `json:"has_password" example:"true"`           // Whether the room has a password
+	MemberCount int    `json:"member_count" example:"5"`              // The number of members in the chatroom
+}
+
+// ToPublicResponse converts a Chatroom to a ChatroomPublicResponse (no room code or member list)
+func (c *Chatroom) ToPublicResponse() ChatroomPublicResponse {
+	return ChatroomPublicResponse{
 		ID:          c.ID.Hex(),
 		Name:        c.Name,
-		RoomCode:    c.RoomCode,
+		Topic:       c.Topic,
 		HasPassword: c.HasPassword,
-		CreatedBy:   c.CreatedBy,
-		CreatedAt:   c.CreatedAt,
-		Members:     c.Members,
+		MemberCount: len(c.Members),
 	}
 }
 
@@ -68,24 +203,35 @@ func (c *Chatroom) CheckPassword(password string) bool {
 type ChatroomWithLatestMessage struct {
 	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
 	Name          string             `bson:"name" json:"name"`
+	Topic         string             `bson:"topic,omitempty" json:"topic,omitempty"`
 	RoomCode      string             `bson:"room_code" json:"room_code"`
 	HasPassword   bool               `bson:"has_password" json:"has_password"`
 	CreatedBy     uint               `bson:"created_by" json:"created_by"`
 	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
 	Members       []ChatroomMember   `bson:"members" json:"members"`
 	LatestMessage *Message           `bson:"latest_message,omitempty" json:"latest_message,omitempty"`
+	HasUnread     bool               `bson:"has_unread" json:"has_unread"` // Whether the requesting user has unread messages here
 }
 
 // ChatroomWithLatestMessageResponse is the response format for sorted chatrooms
 type ChatroomWithLatestMessageResponse struct {
 	ID            string             `json:"id"`
 	Name          string             `json:"name"`
+	Topic         string             `json:"topic,omitempty"`
 	RoomCode      string             `json:"room_code"`
 	HasPassword   bool               `json:"has_password"`
 	CreatedBy     uint               `json:"created_by"`
 	CreatedAt     time.Time          `json:"created_at"`
 	Members       []ChatroomMember   `json:"members"`
 	LatestMessage *LatestMessageInfo `json:"last_message,omitempty"`
+	HasUnread     bool               `json:"has_unread"`          // Whether the requesting user has unread messages here
+	Pinned        bool               `json:"pinned"`              // Whether the requesting user pinned this chatroom
+	PinnedAt      *time.Time         `json:"pinned_at,omitempty"` // Pin order: pinned rooms are listed first, newest pin first
+}
+
+// MarshalJSON encodes a ChatroomWithLatestMessage as its response format
+func (c ChatroomWithLatestMessage) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.ToResponse())
 }
 
 // LatestMessageInfo contains simplified latest message information
@@ -101,29 +247,18 @@ func (c *ChatroomWithLatestMessage) ToResponse() ChatroomWithLatestMessageRespon
 	response := ChatroomWithLatestMessageResponse{
 		ID:          c.ID.Hex(),
 		Name:        c.Name,
+		Topic:       c.Topic,
 		RoomCode:    c.RoomCode,
 		HasPassword: c.HasPassword,
 		CreatedBy:   c.CreatedBy,
 		CreatedAt:   c.CreatedAt,
 		Members:     c.Members,
+		HasUnread:   c.HasUnread,
 	}
 
 	// Add latest message info if available
 	if c.LatestMessage != nil {
-		content := c.LatestMessage.TextContent
-		if content == "" && c.LatestMessage.MediaURL != "" {
-			// Show media type if no text content
-			switch c.LatestMessage.MessageType {
-			case "picture", "text_and_picture":
-				content = "[Image]"
-			case "video", "text_and_video":
-				content = "[Video]"
-			case "audio", "text_and_audio":
-				content = "[Audio]"
-			default:
-				content = "[Media]"
-			}
-		}
+		content := c.LatestMessage.Preview()
 		if content == "" {
 			content = "New message"
 		}
@@ -4,9 +4,35 @@ import (
 	"time"
 )
 
+// Chatroom member roles
+const (
+	ChatroomRoleMember   = "member"   // Can read and post
+	ChatroomRoleReadonly = "readonly" // Can read but not post (e.g. announcement rooms)
+	ChatroomRoleAdmin    = "admin"    // Can post and moderate other members' messages
+)
+
 // ChatroomMember represents a user in a chatroom
 type ChatroomMember struct {
-	UserID   uint      `bson:"user_id" json:"user_id" example:"1"`         // The ID of the user
-	Username string    `bson:"username" json:"username" example:"johndoe"` // The username of the user
-	JoinedAt time.Time `bson:"joined_at" json:"joined_at"`                 // The timestamp when the user joined the chatroom
+	UserID     uint       `bson:"user_id" json:"user_id" example:"1"`                    // The ID of the user
+	Username   string     `bson:"username" json:"username" example:"johndoe"`            // The username of the user
+	Role       string     `bson:"role,omitempty" json:"role,omitempty" example:"member"` // The member's room role (empty means member)
+	JoinedAt   time.Time  `bson:"joined_at" json:"joined_at"`                            // The timestamp when the user joined the chatroom
+	LastSeenAt *time.Time `bson:"-" json:"last_seen_at,omitempty"`                       // Filled in from the user's profile for member views; omitted when not shared
+	Muted      bool       `bson:"muted,omitempty" json:"-"`                              // Member turned off push notifications for this chatroom; private to them
+}
+
+// ChatroomMembership is a user's membership status in a single chatroom
+type ChatroomMembership struct {
+	IsMember bool       `json:"is_member" example:"true"`
+	Role     string     `json:"role,omitempty" example:"member" enums:"member,readonly,admin"` // Empty when not a member
+	JoinedAt *time.Time `json:"joined_at,omitempty"`                                           // Null when not a member
+}
+
+// IsValidChatroomRole reports whether role is a supported chatroom member role
+func IsValidChatroomRole(role string) bool {
+	switch role {
+	case ChatroomRoleMember, ChatroomRoleReadonly, ChatroomRoleAdmin:
+		return true
+	}
+	return false
 }
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"encoding/json"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -8,31 +10,146 @@ import (
 
 // Message represents a message in a chatroom
 type Message struct {
-	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	ChatroomID  primitive.ObjectID `bson:"chatroom_id" json:"chatroom_id"`
-	SenderID    uint               `bson:"sender_id" json:"sender_id"`
-	SenderName  string             `bson:"sender_name" json:"sender_name"`
-	MessageType string             `bson:"message_type" json:"message_type" example:"text" enums:"text,picture,audio,video,text_and_picture,text_and_audio,text_and_video"` // Type of message: text, picture, audio, video, text_and_picture, text_and_audio, text_and_video
-	TextContent string             `bson:"text_content,omitempty" json:"text_content,omitempty" example:"Hello, how are you?"`                                              // Text content of the message
-	MediaURL    string             `bson:"media_url,omitempty" json:"media_url,omitempty" example:"https://example.com/image.jpg"`                                          // URL of the media
-	SentAt      time.Time          `bson:"sent_at" json:"sent_at"`                                                                                                          // Timestamp when the message was sent
-	Edited      bool               `bson:"edited" json:"edited"`                                                                                                            // Whether the message has been edited
-	EditedAt    *time.Time         `bson:"edited_at,omitempty" json:"edited_at,omitempty"`                                                                                  // Timestamp when the message was last edited (nil if never edited)
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ChatroomID    primitive.ObjectID `bson:"chatroom_id" json:"chatroom_id"`
+	SenderID      uint               `bson:"sender_id" json:"sender_id"`
+	SenderName    string             `bson:"sender_name" json:"sender_name"`
+	MessageType   string             `bson:"message_type" json:"message_type" example:"text" enums:"text,picture,audio,video,text_and_picture,text_and_audio,text_and_video,album,system"` // Type of message: text, picture, audio, video, text_and_picture, text_and_audio, text_and_video, album, or system for server notices
+	TextContent   string             `bson:"text_content,omitempty" json:"text_content,omitempty" example:"Hello, how are you?"`                                                           // Text content of the message
+	TextEncrypted bool               `bson:"text_encrypted,omitempty" json:"-"`                                                                                                            // Whether TextContent is stored encrypted (see services.EncryptionService)
+	MediaURL      string             `bson:"media_url,omitempty" json:"media_url,omitempty" example:"https://example.com/image.jpg"`                                                       // URL of the media
+	MediaKind     string             `bson:"media_kind,omitempty" json:"media_kind,omitempty" example:"image" enums:"image,audio,video"`                                                   // Kind of the attached media, set when the media is attached (empty on messages stored before it existed)
+	Attachments   []Attachment       `bson:"attachments,omitempty" json:"attachments,omitempty"`                                                                                           // Media items of an album message (MediaURL is empty on albums)
+	SentAt        time.Time          `bson:"sent_at" json:"sent_at"`                                                                                                                       // Timestamp when the message was sent
+	Edited        bool               `bson:"edited" json:"edited"`                                                                                                                         // Whether the message has been edited
+	EditedAt      *time.Time         `bson:"edited_at,omitempty" json:"edited_at,omitempty"`                                                                                               // Timestamp when the message was last edited (nil if never edited)
+	SystemEvent   *SystemEvent       `bson:"system_event,omitempty" json:"system_event,omitempty"`                                                                                         // What a system message records (nil for user messages)
 }
 
+// Album messages carry several media items in Attachments instead of a single MediaURL
+const (
+	MessageTypeAlbum    = "album"
+	MaxAlbumAttachments = 10
+)
+
+// Attachment is one media item of an album message
+type Attachment struct {
+	URL       string `bson:"url" json:"url" example:"https://example.com/image.jpg"`                 // URL of the media
+	MediaKind string `bson:"media_kind" json:"media_kind" example:"image" enums:"image,audio,video"` // Kind of the media
+}
+
+// MediaURLs returns every media URL the message references: its single media and any album attachments
+func (m *Message) MediaURLs() []string {
+	var urls []string
+	if m.MediaURL != "" {
+		urls = append(urls, m.MediaURL)
+	}
+	for _, attachment := range m.Attachments {
+		urls = append(urls, attachment.URL)
+	}
+	return urls
+}
+
+// System messages are notices the server adds to the transcript, such as "alice joined the room".
+// They have no sender, so SenderID is SystemSenderID.
+const (
+	MessageTypeSystem = "system"
+	SystemSenderID    = 0
+)
+
+// System event types (they match the WebSocket event sent alongside the message)
+const (
+	SystemEventMemberJoined    = "member_joined"
+	SystemEventMemberLeft      = "member_left"
+	SystemEventChatroomRenamed = "chatroom_renamed"
+)
+
+// SystemEvent is the structured payload of a system message
+type SystemEvent struct {
+	Type         string `bson:"type" json:"type" example:"member_joined" enums:"member_joined,member_left,chatroom_renamed"`
+	UserID       uint   `bson:"user_id" json:"user_id" example:"1"`                                       // The member the event is about (who renamed the room, for chatroom_renamed)
+	Username     string `bson:"username" json:"username" example:"alice"`                                 // Their username at the time of the event
+	Name         string `bson:"name,omitempty" json:"name,omitempty" example:"Weekend plans"`             // The room's new name (chatroom_renamed only)
+	PreviousName string `bson:"previous_name,omitempty" json:"previous_name,omitempty" example:"General"` // The room's old name (chatroom_renamed only)
+}
+
+// Text renders the event as a human-readable notice
+func (e *SystemEvent) Text() string {
+	switch e.Type {
+	case SystemEventMemberJoined:
+		return e.Username + " joined the room"
+	case SystemEventMemberLeft:
+		return e.Username + " left the room"
+	case SystemEventChatroomRenamed:
+		return e.Username + " renamed the room to \"" + e.Name + "\""
+	default:
+		return ""
+	}
+}
+
+// IsSystem reports whether the message is a server-generated notice
+func (m *Message) IsSystem() bool {
+	return m.MessageType == MessageTypeSystem
+}
+
+// UndecryptableText replaces message text that is encrypted at rest but can't be decrypted (e.g. the key changed)
+const UndecryptableText = "[encrypted message]"
+
 // MessageResponse is a struct for returning message data
 type MessageResponse struct {
-	ID          string     `json:"id" example:"60d5f8b8e6b5f0b3e8b4b5b3"`                                                                       // Unique identifier of the message
-	ChatroomID  string     `json:"chatroom_id" example:"60d5f8b8e6b5f0b3e8b4b5b4"`                                                              // ID of the chatroom where the message was sent
-	SenderID    uint       `json:"sender_id" example:"1"`                                                                                       // ID of the user who sent the message
-	SenderName  string     `json:"sender_name" example:"johndoe"`                                                                               // Username of the sender
-	MessageType string     `json:"message_type" example:"text" enums:"text,picture,audio,video,text_and_picture,text_and_audio,text_and_video"` // Type of message
-	TextContent string     `json:"text_content,omitempty" example:"Hello, how are you?"`                                                        // Text content of the message
-	MediaURL    string     `json:"media_url,omitempty" example:"https://example.com/image.jpg"`                                                 // URL of the media
-	SentAt      time.Time  `json:"sent_at" example:"2023-01-01T12:00:00Z"`                                                                      // Timestamp when the message was sent
-	Edited      bool       `json:"edited" example:"false"`                                                                                      // Whether the message has been edited
-	EditedAt    *time.Time `json:"edited_at,omitempty" example:"2023-01-01T12:05:00Z"`                                                          // Timestamp when the message was last edited (null if never edited)
-	ReadStatus  []ReadInfo `json:"read_status,omitempty"`                                                                                       // Read status for each chatroom member
+	ID          string       `json:"id" example:"60d5f8b8e6b5f0b3e8b4b5b3"`                                                                                    // Unique identifier of the message
+	ChatroomID  string       `json:"chatroom_id" example:"60d5f8b8e6b5f0b3e8b4b5b4"`                                                                           // ID of the chatroom where the message was sent
+	SenderID    uint         `json:"sender_id" example:"1"`                                                                                                    // ID of the user who sent the message
+	SenderName  string       `json:"sender_name" example:"johndoe"`                                                                                            // Username of the sender
+	MessageType string       `json:"message_type" example:"text" enums:"text,picture,audio,video,text_and_picture,text_and_audio,text_and_video,album,system"` // Type of message
+	TextContent string       `json:"text_content,omitempty" example:"Hello, how are you?"`                                                                     // Text content of the message
+	MediaURL    string       `json:"media_url,omitempty" example:"https://example.com/image.jpg"`                                                              // URL of the media
+	MediaKind   string       `json:"media_kind,omitempty" example:"image" enums:"image,audio,video"`                                                           // Kind of the attached media
+	Attachments []Attachment `json:"attachments,omitempty"`                                                                                                    // Media items of an album message
+	SentAt      time.Time    `json:"sent_at" example:"2023-01-01T12:00:00Z"`                                                                                   // Timestamp when the message was sent
+	Edited      bool         `json:"edited" example:"false"`                                                                                                   // Whether the message has been edited
+	EditedAt    *time.Time   `json:"edited_at,omitempty" example:"2023-01-01T12:05:00Z"`                                                                       // Timestamp when the message was last edited (null if never edited)
+	ReadStatus  []ReadInfo   `json:"read_status,omitempty"`                                                                                                    // Read status for each chatroom member
+	SystemEvent *SystemEvent `json:"system_event,omitempty"`                                                                                                   // Set on system messages (message_type "system")
+}
+
+// MessagePreviewMaxLength is the longest text preview, in characters, before it is cut with "..."
+const MessagePreviewMaxLength = 100
+
+// Preview returns a short, display-ready summary of the message for chat lists and notifications:
+// its text truncated to MessagePreviewMaxLength, or a label such as "[Image]" or "[Album]" for media without text.
+// It is empty for a message with neither.
+func (m *Message) Preview() string {
+	if text := strings.TrimSpace(m.TextContent); text != "" {
+		runes := []rune(text)
+		if len(runes) > MessagePreviewMaxLength {
+			return string(runes[:MessagePreviewMaxLength-3]) + "..."
+		}
+		return text
+	}
+	if m.MessageType == MessageTypeAlbum && len(m.Attachments) > 0 {
+		return "[Album]"
+	}
+	if m.MediaURL == "" {
+		return ""
+	}
+
+	switch m.MessageType {
+	case "picture", "text_and_picture":
+		return "[Image]"
+	case "video", "text_and_video":
+		return "[Video]"
+	case "audio", "text_and_audio":
+		return "[Audio]"
+	default:
+		return "[Media]"
+	}
+}
+
+// Preview returns the same summary as Message.Preview, for a message that was already converted to a response
+func (r *MessageResponse) Preview() string {
+	message := Message{MessageType: r.MessageType, TextContent: r.TextContent, MediaURL: r.MediaURL, Attachments: r.Attachments}
+	return message.Preview()
 }
 
 // ToResponse converts a Message to a MessageResponse
@@ -45,8 +162,16 @@ func (m *Message) ToResponse() MessageResponse {
 		MessageType: m.MessageType,
 		TextContent: m.TextContent,
 		MediaURL:    m.MediaURL,
+		MediaKind:   m.MediaKind,
+		Attachments: m.Attachments,
+		SystemEvent: m.SystemEvent,
 		SentAt:      m.SentAt,
 		Edited:      m.Edited,
 		EditedAt:    m.EditedAt,
 	}
 }
+
+// MarshalJSON encodes a Message as a MessageResponse (hex IDs, no bson-only fields)
+func (m Message) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.ToResponse())
+}
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MessageDraft is one user's unsent message in a chatroom, kept server-side so it follows them across devices.
+// There is at most one draft per user and chatroom, and only its owner can see it.
+type MessageDraft struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	UserID        uint               `bson:"user_id" json:"-"`                                                                  // Owner of the draft
+	ChatroomID    primitive.ObjectID `bson:"chatroom_id" json:"chatroom_id" example:"60d5f8b8e6b5f0b3e8b4b5b4"`                 // Chatroom the draft is for
+	TextContent   string             `bson:"text_content,omitempty" json:"text_content,omitempty" example:"Hello, how are"`     // Text typed so far (encrypted at rest like message text)
+	TextEncrypted bool               `bson:"text_encrypted,omitempty" json:"-"`                                                 // Whether TextContent is stored encrypted
+	MediaURL      string             `bson:"media_url,omitempty" json:"media_url,omitempty" example:"/media/images/abc123.jpg"` // Media uploaded but not sent yet
+	UpdatedAt     time.Time          `bson:"updated_at" json:"updated_at"`                                                      // When the draft was last saved
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -44,6 +45,11 @@ func (mrs *MessageReadStatus) ToResponse() MessageReadStatusResponse {
 	}
 }
 
+// MarshalJSON encodes a MessageReadStatus as its response DTO
+func (mrs MessageReadStatus) MarshalJSON() ([]byte, error) {
+	return json.Marshal(mrs.ToResponse())
+}
+
 // UserLastRead represents the last read message for a user in a chatroom
 type UserLastRead struct {
 	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
@@ -51,9 +57,19 @@ type UserLastRead struct {
 	UserID     uint               `bson:"user_id" json:"user_id"`           // ID of the user
 	MessageID  primitive.ObjectID `bson:"message_id" json:"message_id"`     // ID of the last read message
 	ReadAt     time.Time          `bson:"read_at" json:"read_at"`           // Timestamp when the message was read
+	SentAt     *time.Time         `bson:"sent_at,omitempty" json:"sent_at,omitempty"` // When the last read message was sent (missing on older records)
 	UpdatedAt  time.Time          `bson:"updated_at" json:"updated_at"`     // Timestamp when this record was last updated
 }
 
+// ReadUpTo is the send time up to which the user has read the chatroom. Records written before
+// SentAt was stored fall back to ReadAt, since nothing newer than that could have been read.
+func (ulr *UserLastRead) ReadUpTo() time.Time {
+	if ulr.SentAt != nil {
+		return *ulr.SentAt
+	}
+	return ulr.ReadAt
+}
+
 // UserLastReadResponse is a struct for returning user last read data
 type UserLastReadResponse struct {
 	ID         string    `json:"id" example:"60d5f8b8e6b5f0b3e8b4b5b3"`
@@ -76,11 +92,18 @@ func (ulr *UserLastRead) ToResponse() UserLastReadResponse {
 	}
 }
 
+// MarshalJSON encodes a UserLastRead as its response DTO
+func (ulr UserLastRead) MarshalJSON() ([]byte, error) {
+	return json.Marshal(ulr.ToResponse())
+}
+
 // ChatroomUnreadCount represents unread message count for a user in a chatroom
 type ChatroomUnreadCount struct {
-	ChatroomID   string `json:"chatroom_id" example:"60d5f8b8e6b5f0b3e8b4b5b4"`
-	ChatroomName string `json:"chatroom_name" example:"General Chat"`
-	UnreadCount  int64  `json:"unread_count" example:"5"`
+	ChatroomID           string `json:"chatroom_id" example:"60d5f8b8e6b5f0b3e8b4b5b4"`
+	ChatroomName         string `json:"chatroom_name" example:"General Chat"`
+	UnreadCount          int64  `json:"unread_count" example:"5"`
+	LastReadMessageID    string `json:"last_read_message_id,omitempty" example:"60d5f8b8e6b5f0b3e8b4b5b5"`    // The user's last read message (empty if never read)
+	FirstUnreadMessageID string `json:"first_unread_message_id,omitempty" example:"60d5f8b8e6b5f0b3e8b4b5b6"` // Where to place the "new messages" divider (empty if nothing unread)
 }
 
 // LatestChatMessage represents the latest message in a chatroom
@@ -92,6 +115,7 @@ type LatestChatMessage struct {
 	MessageType  string     `json:"message_type" example:"text"`
 	TextContent  string     `json:"text_content,omitempty" example:"Hello, how are you?"`
 	MediaURL     string     `json:"media_url,omitempty" example:"https://example.com/image.jpg"`
+	Preview      string     `json:"preview" example:"Hello, how are you?"` // Display-ready summary: truncated text or a media label like "[Image]"
 	SentAt       time.Time  `json:"sent_at" example:"2023-01-01T12:00:00Z"`
 	ReadStatus   []ReadInfo `json:"read_status"` // Read status for each member
 }
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Message report statuses
+const (
+	ReportStatusOpen      = "open"      // Waiting for an administrator
+	ReportStatusResolved  = "resolved"  // Action was taken
+	ReportStatusDismissed = "dismissed" // No action needed
+)
+
+// IsValidReportResolution reports whether status is a state an open report can be moved to
+func IsValidReportResolution(status string) bool {
+	return status == ReportStatusResolved || status == ReportStatusDismissed
+}
+
+// IsValidReportStatus reports whether status is a known report status
+func IsValidReportStatus(status string) bool {
+	return status == ReportStatusOpen || IsValidReportResolution(status)
+}
+
+// MessageReport is a user's report of a message that breaks the community rules.
+// A user can report a given message only once (unique on message_id + reporter_id).
+type MessageReport struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	MessageID    primitive.ObjectID `bson:"message_id" json:"message_id"`                         // The reported message
+	ChatroomID   primitive.ObjectID `bson:"chatroom_id" json:"chatroom_id"`                       // Chatroom the message was sent in
+	SenderID     uint               `bson:"sender_id" json:"sender_id"`                           // Author of the reported message
+	ReporterID   uint               `bson:"reporter_id" json:"reporter_id"`                       // User who filed the report
+	ReporterName string             `bson:"reporter_name" json:"reporter_name"`                   // Username of the reporter at report time
+	Reason       string             `bson:"reason" json:"reason"`                                 // Why the message was reported
+	Status       string             `bson:"status" json:"status" enums:"open,resolved,dismissed"` // open until an administrator resolves or dismisses it
+	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`                         // When the report was filed
+	ResolvedBy   *uint              `bson:"resolved_by,omitempty" json:"resolved_by,omitempty"`   // Administrator who closed the report
+	ResolvedAt   *time.Time         `bson:"resolved_at,omitempty" json:"resolved_at,omitempty"`   // When the report was closed
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestMessagePreview(t *testing.T) {
+	long := strings.Repeat("é", MessagePreviewMaxLength+20) // Multi-byte, so cutting by bytes would split a rune
+	album := []Attachment{{URL: "https://media.test/a.jpg", MediaKind: "image"}}
+
+	for _, tc := range []struct {
+		name    string
+		message Message
+		want    string
+	}{
+		{"text", Message{MessageType: "text", TextContent: "  hello  "}, "hello"},
+		{"long text is cut", Message{MessageType: "text", TextContent: long}, strings.Repeat("é", MessagePreviewMaxLength-3) + "..."},
+		{"text wins over media", Message{MessageType: "text_and_picture", TextContent: "look", MediaURL: "https://media.test/a.jpg"}, "look"},
+		{"picture", Message{MessageType: "picture", MediaURL: "https://media.test/a.jpg"}, "[Image]"},
+		{"video", Message{MessageType: "video", MediaURL: "https://media.test/b.mp4"}, "[Video]"},
+		{"audio with a blank caption", Message{MessageType: "text_and_audio", TextContent: " ", MediaURL: "https://media.test/c.mp3"}, "[Audio]"},
+		{"album", Message{MessageType: MessageTypeAlbum, Attachments: album}, "[Album]"},
+		{"unknown media type", Message{MessageType: "sticker", MediaURL: "https://media.test/d.webp"}, "[Media]"},
+		{"empty", Message{MessageType: "text"}, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := tc.message.Preview()
+			if got != tc.want {
+				t.Errorf("Preview() = %q, want %q", got, tc.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("Preview() = %q is not valid UTF-8", got)
+			}
+			response := tc.message.ToResponse()
+			if fromResponse := response.Preview(); fromResponse != got {
+				t.Errorf("MessageResponse.Preview() = %q, want %q like Message.Preview", fromResponse, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,15 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PinnedChatroom marks a chatroom as pinned to the top of one user's sidebar
+type PinnedChatroom struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID     uint               `bson:"user_id" json:"user_id"`         // ID of the user who pinned the chatroom
+	ChatroomID primitive.ObjectID `bson:"chatroom_id" json:"chatroom_id"` // Reference to the pinned chatroom
+	PinnedAt   time.Time          `bson:"pinned_at" json:"pinned_at"`     // When the chatroom was pinned (newest pins sort first)
+}
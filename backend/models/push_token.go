@@ -3,6 +3,7 @@ package models
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
@@ -12,7 +13,7 @@ import (
 type PushToken struct {
 	ID         uint            `json:"id" gorm:"primaryKey"`
 	UserID     uint            `json:"user_id" gorm:"not null;index;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
-	Token      string          `json:"token" gorm:"not null;size:255;index:idx_token_hash,type:hash"`
+	Token      string          `json:"token" gorm:"not null;size:255;uniqueIndex:idx_push_token"` // One row per device token
 	Platform   string          `json:"platform" gorm:"not null;size:20"`
 	DeviceInfo json.RawMessage `json:"device_info" gorm:"type:json"`
 	IsActive   bool            `json:"is_active" gorm:"default:true;index"`
@@ -20,6 +21,25 @@ type PushToken struct {
 	UpdatedAt  time.Time       `json:"updated_at"`
 }
 
+// Push providers a token can be delivered through
+const (
+	PushProviderExpo = "expo"
+	PushProviderFCM  = "fcm"
+)
+
+// Provider returns the push provider for the token. A platform naming a provider wins; otherwise
+// ExponentPushToken[...] / ExpoPushToken[...] tokens go to Expo and any other token is treated as an FCM registration token.
+func (pt *PushToken) Provider() string {
+	switch strings.ToLower(pt.Platform) {
+	case PushProviderExpo, PushProviderFCM:
+		return strings.ToLower(pt.Platform)
+	}
+	if strings.HasPrefix(pt.Token, "ExponentPushToken[") || strings.HasPrefix(pt.Token, "ExpoPushToken[") {
+		return PushProviderExpo
+	}
+	return PushProviderFCM
+}
+
 // DeviceInfo represents device information for push tokens
 type DeviceInfo struct {
 	DeviceType  string `json:"device_type"`
@@ -0,0 +1,22 @@
+package models
+
+import "testing"
+
+func TestPushTokenProvider(t *testing.T) {
+	for _, tc := range []struct {
+		token, platform, want string
+	}{
+		{"ExponentPushToken[abc]", "ios", PushProviderExpo},
+		{"ExpoPushToken[abc]", "android", PushProviderExpo},
+		{"dQw4w9WgXcQ:APA91bHun4MxP5egoKMwt2KZFBaFUH", "android", PushProviderFCM},
+		{"dQw4w9WgXcQ:APA91bHun4MxP5egoKMwt2KZFBaFUH", "", PushProviderFCM},
+		// A platform naming the provider overrides the token's format
+		{"ExponentPushToken[abc]", "FCM", PushProviderFCM},
+		{"dQw4w9WgXcQ:APA91bHun4MxP5egoKMwt2KZFBaFUH", "expo", PushProviderExpo},
+	} {
+		token := PushToken{Token: tc.token, Platform: tc.platform}
+		if got := token.Provider(); got != tc.want {
+			t.Errorf("Provider(%q on %q) = %q, want %q", tc.token, tc.platform, got, tc.want)
+		}
+	}
+}
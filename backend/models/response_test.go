@@ -0,0 +1,106 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// internalKeys must never appear in JSON sent to clients
+var internalKeys = []string{"password", "is_login", "heartbeat", "last_login_at", "quiet_hours_start", "_id"}
+
+// jsonKeys collects every object key in a decoded JSON value
+func jsonKeys(value any, keys map[string]bool) {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, child := range v {
+			keys[strings.ToLower(key)] = true
+			jsonKeys(child, keys)
+		}
+	case []any:
+		for _, child := range v {
+			jsonKeys(child, keys)
+		}
+	}
+}
+
+func TestStorageModelsEncodeAsResponses(t *testing.T) {
+	now := time.Now()
+	login := CustomTime{Time: now}
+	message := Message{
+		ID:          primitive.NewObjectID(),
+		ChatroomID:  primitive.NewObjectID(),
+		SenderID:    1,
+		SenderName:  "alice",
+		MessageType: "text",
+		TextContent: "hello",
+		SentAt:      now,
+	}
+	chatroom := Chatroom{
+		ID:          primitive.NewObjectID(),
+		Name:        "Locked",
+		RoomCode:    "ABC123",
+		Password:    "$2a$10$hash",
+		HasPassword: true,
+		CreatedBy:   1,
+		Members:     []ChatroomMember{{UserID: 1, Username: "alice", Role: ChatroomRoleAdmin}},
+	}
+	user := User{
+		UserID:          1,
+		Username:        "alice",
+		Email:           "alice@example.com",
+		Password:        "$2a$10$hash",
+		IsLogin:         true,
+		LastLoginAt:     &login,
+		Heartbeat:       &login,
+		QuietHoursStart: "22:00",
+		QuietHoursEnd:   "07:00",
+	}
+
+	for _, tc := range []struct {
+		name  string
+		value any
+		want  []string // Keys the response format has
+	}{
+		{"user", user, []string{"user_id", "username", "quiet_hours"}},
+		{"user pointer", &user, []string{"user_id"}},
+		{"users in a map", map[string]any{"users": []User{user}}, []string{"user_id"}},
+		{"chatroom", chatroom, []string{"id", "has_password", "room_code"}},
+		{"chatroom pointer", &chatroom, []string{"id"}},
+		{"chatroom with latest message", ChatroomWithLatestMessage{ID: chatroom.ID, Name: "Locked", LatestMessage: &message}, []string{"id", "last_message"}},
+		{"message", message, []string{"id", "chatroom_id", "text_content"}},
+		{"messages", []*Message{&message}, []string{"id"}},
+		{"read status", MessageReadStatus{ID: primitive.NewObjectID(), MessageID: message.ID, ChatroomID: message.ChatroomID}, []string{"id", "message_id"}},
+		{"last read", UserLastRead{ID: primitive.NewObjectID(), ChatroomID: message.ChatroomID, MessageID: message.ID}, []string{"id", "message_id"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			raw, err := json.Marshal(tc.value)
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			var decoded any
+			if err := json.Unmarshal(raw, &decoded); err != nil {
+				t.Fatalf("unmarshal %s: %v", raw, err)
+			}
+			keys := map[string]bool{}
+			jsonKeys(decoded, keys)
+
+			for _, key := range internalKeys {
+				if keys[key] {
+					t.Errorf("%s has internal key %q", raw, key)
+				}
+			}
+			for _, key := range tc.want {
+				if !keys[key] {
+					t.Errorf("%s is missing %q; not encoded as its response format?", raw, key)
+				}
+			}
+			if strings.Contains(string(raw), "$2a$10$hash") || strings.Contains(string(raw), "$oid") {
+				t.Errorf("%s leaks a password hash or extended JSON", raw)
+			}
+		})
+	}
+}
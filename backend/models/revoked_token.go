@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// RevokedToken records a JWT that must no longer be accepted (e.g. after logout).
+// Rows are only needed until the token would have expired anyway.
+type RevokedToken struct {
+	TokenID   string    `json:"token_id" gorm:"primaryKey;size:64"` // The token's jti claim
+	UserID    uint      `json:"user_id" gorm:"not null;index"`
+	ExpiresAt time.Time `json:"expires_at" gorm:"not null;index"`
+	CreatedAt time.Time `json:"created_at"`
+}
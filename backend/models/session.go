@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+// Session is a signed-in device: one row per issued token, so a user can see where they are signed in
+// and sign a device out remotely. The row is removed when its token is revoked.
+type Session struct {
+	ID           uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	TokenID      string    `json:"-" gorm:"size:64;not null;uniqueIndex"` // The token's jti claim
+	UserID       uint      `json:"user_id" gorm:"not null;index"`
+	UserAgent    string    `json:"user_agent" gorm:"size:255"`
+	IPAddress    string    `json:"ip_address" gorm:"size:45"`
+	CreatedAt    time.Time `json:"created_at"`
+	LastActiveAt time.Time `json:"last_active_at"`
+	ExpiresAt    time.Time `json:"expires_at" gorm:"not null;index"`
+}
+
+// SessionResponse is a session as shown to its owner
+type SessionResponse struct {
+	ID           uint      `json:"id" example:"12"`
+	UserAgent    string    `json:"user_agent" example:"GinChat/1.4 (iPhone; iOS 17.2)"` // Device the session was signed in from
+	IPAddress    string    `json:"ip_address" example:"203.0.113.7"`                    // Address at sign-in
+	CreatedAt    time.Time `json:"created_at"`
+	LastActiveAt time.Time `json:"last_active_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	Current      bool      `json:"current" example:"true"` // Whether this is the session making the request
+}
+
+// ToResponse converts a Session to a SessionResponse; currentTokenID marks the requesting session
+func (s *Session) ToResponse(currentTokenID string) SessionResponse {
+	return SessionResponse{
+		ID:           s.ID,
+		UserAgent:    s.UserAgent,
+		IPAddress:    s.IPAddress,
+		CreatedAt:    s.CreatedAt,
+		LastActiveAt: s.LastActiveAt,
+		ExpiresAt:    s.ExpiresAt,
+		Current:      currentTokenID != "" && s.TokenID == currentTokenID,
+	}
+}
@@ -2,6 +2,7 @@ package models
 
 import (
 	"database/sql/driver"
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -59,20 +60,31 @@ func (ct CustomTime) Value() (driver.Value, error) {
 	return ct.Time, nil
 }
 
+// Application-wide user roles (not to be confused with chatroom roles)
+const (
+	UserRoleMember = "member"
+	UserRoleAdmin  = "admin" // Exempt from per-user limits such as the chatroom creation cooldown
+)
+
 // User represents a user in the system
 type User struct {
-	UserID      uint        `gorm:"primaryKey;autoIncrement" json:"user_id"`
-	Username    string      `gorm:"size:50;not null;unique" json:"username"`
-	Email       string      `gorm:"size:100;not null;unique" json:"email"`
-	Password    string      `gorm:"size:255;not null" json:"-"` // Password is not exposed in JSON
-	Role        string      `gorm:"size:50;default:member" json:"role"`
-	IsLogin     bool        `gorm:"default:false" json:"is_login"`
-	LastLoginAt *CustomTime `json:"last_login_at"`
-	Heartbeat   *CustomTime `json:"heartbeat"`
-	Status      string      `gorm:"type:enum('online','offline','away');default:'offline'" json:"status"`
-	AvatarURL   string      `gorm:"size:255" json:"avatar_url"`
-	CreatedAt   CustomTime  `json:"created_at"`
-	UpdatedAt   CustomTime  `json:"updated_at"`
+	UserID              uint        `gorm:"primaryKey;autoIncrement" json:"user_id"`
+	Username            string      `gorm:"size:50;not null;unique" json:"username"` // Unique index; trimmed before saving
+	Email               string      `gorm:"size:100;not null;unique" json:"email"`   // Unique index; stored lowercased (see services.NormalizeEmail)
+	Password            string      `gorm:"size:255;not null" json:"-"`              // Password is not exposed in JSON
+	Role                string      `gorm:"size:50;default:member" json:"role"`
+	IsLogin             bool        `gorm:"default:false" json:"is_login"`
+	LastLoginAt         *CustomTime `json:"last_login_at"`
+	Heartbeat           *CustomTime `json:"heartbeat"`
+	Status              string      `gorm:"type:enum('online','offline','away');default:'offline'" json:"status"`
+	AvatarURL           string      `gorm:"size:255" json:"avatar_url"`
+	NotificationPreview string      `gorm:"size:20;default:full" json:"notification_preview"` // Push preview mode: full, sender_only, hidden
+	QuietHoursStart     string      `gorm:"size:5" json:"quiet_hours_start"`                  // "HH:MM"; empty when quiet hours are off
+	QuietHoursEnd       string      `gorm:"size:5" json:"quiet_hours_end"`                    // "HH:MM"; may be earlier than the start (window crosses midnight)
+	QuietHoursTimezone  string      `gorm:"size:64" json:"quiet_hours_timezone"`              // IANA name, e.g. "Asia/Kuala_Lumpur"
+	ShareLastSeen       bool        `gorm:"default:true" json:"share_last_seen"`              // When false, other users don't see the last seen time
+	CreatedAt           CustomTime  `json:"created_at"`
+	UpdatedAt           CustomTime  `json:"updated_at"`
 }
 
 // BeforeCreate is a GORM hook that sets the timestamps before creating a record
@@ -98,13 +110,149 @@ func (User) TableName() string {
 	return "users"
 }
 
+// Notification preview modes
+const (
+	NotificationPreviewFull       = "full"        // Show sender and message text
+	NotificationPreviewSenderOnly = "sender_only" // Show sender but hide message text
+	NotificationPreviewHidden     = "hidden"      // Show a generic notification only
+)
+
+// IsValidNotificationPreview checks if the value is a supported notification preview mode
+func IsValidNotificationPreview(mode string) bool {
+	switch mode {
+	case NotificationPreviewFull, NotificationPreviewSenderOnly, NotificationPreviewHidden:
+		return true
+	default:
+		return false
+	}
+}
+
+// QuietHours is a daily do-not-disturb window during which push notifications are suppressed
+type QuietHours struct {
+	Start    string `json:"start" example:"22:00"`             // "HH:MM" in Timezone
+	End      string `json:"end" example:"07:00"`               // "HH:MM" in Timezone; before Start when the window crosses midnight
+	Timezone string `json:"timezone" example:"Asia/Singapore"` // IANA timezone name
+}
+
+const quietHoursLayout = "15:04"
+
+// Validate checks the times are "HH:MM", differ, and the timezone is known
+func (q QuietHours) Validate() error {
+	start, err := time.Parse(quietHoursLayout, q.Start)
+	if err != nil {
+		return fmt.Errorf("invalid quiet hours start %q", q.Start)
+	}
+	end, err := time.Parse(quietHoursLayout, q.End)
+	if err != nil {
+		return fmt.Errorf("invalid quiet hours end %q", q.End)
+	}
+	if start.Equal(end) {
+		return fmt.Errorf("quiet hours start and end must differ")
+	}
+	if _, err := time.LoadLocation(q.Timezone); err != nil || q.Timezone == "" {
+		return fmt.Errorf("invalid quiet hours timezone %q", q.Timezone)
+	}
+	return nil
+}
+
+// Contains reports whether t falls inside the window (start inclusive, end exclusive) in the window's timezone.
+// An invalid window contains nothing.
+func (q QuietHours) Contains(t time.Time) bool {
+	if q.Validate() != nil {
+		return false
+	}
+	loc, _ := time.LoadLocation(q.Timezone)
+	start, _ := time.Parse(quietHoursLayout, q.Start)
+	end, _ := time.Parse(quietHoursLayout, q.End)
+
+	local := t.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+	startMinute := start.Hour()*60 + start.Minute()
+	endMinute := end.Hour()*60 + end.Minute()
+
+	if startMinute < endMinute {
+		return minute >= startMinute && minute < endMinute
+	}
+	// Crosses midnight, e.g. 22:00-07:00
+	return minute >= startMinute || minute < endMinute
+}
+
+// GetQuietHours returns the user's quiet hours, or nil when none are set
+func (u *User) GetQuietHours() *QuietHours {
+	if u.QuietHoursStart == "" || u.QuietHoursEnd == "" {
+		return nil
+	}
+	return &QuietHours{
+		Start:    u.QuietHoursStart,
+		End:      u.QuietHoursEnd,
+		Timezone: u.QuietHoursTimezone,
+	}
+}
+
+// LastSeenAt returns when the user was last active (latest heartbeat, falling back to the last login),
+// or nil when the user has opted out of sharing it or has never logged in
+func (u *User) LastSeenAt() *time.Time {
+	if !u.ShareLastSeen {
+		return nil
+	}
+	for _, seen := range []*CustomTime{u.Heartbeat, u.LastLoginAt} {
+		if seen != nil && !seen.Time.IsZero() {
+			lastSeen := seen.Time
+			return &lastSeen
+		}
+	}
+	return nil
+}
+
 // UserResponse is a struct for returning user data without sensitive information
 type UserResponse struct {
-	UserID    uint      `json:"user_id"`
-	Username  string    `json:"username"`
-	Email     string    `json:"email"`
-	Role      string    `json:"role"`
-	Status    string    `json:"status"`
-	AvatarURL string    `json:"avatar_url"`
-	CreatedAt time.Time `json:"created_at"`
+	UserID              uint        `json:"user_id"`
+	Username            string      `json:"username"`
+	Email               string      `json:"email"`
+	Role                string      `json:"role"`
+	Status              string      `json:"status"`
+	AvatarURL           string      `json:"avatar_url"`
+	CreatedAt           time.Time   `json:"created_at"`
+	NotificationPreview string      `json:"notification_preview"`
+	QuietHours          *QuietHours `json:"quiet_hours"` // Null when quiet hours are off
+	ShareLastSeen       bool        `json:"share_last_seen"`
+	LastSeenAt          *time.Time  `json:"last_seen_at"` // Null when the user doesn't share it
+}
+
+// ToResponse converts a User to a UserResponse without the password hash or login state
+func (u *User) ToResponse() UserResponse {
+	return UserResponse{
+		UserID:              u.UserID,
+		Username:            u.Username,
+		Email:               u.Email,
+		Role:                u.Role,
+		Status:              u.Status,
+		AvatarURL:           u.AvatarURL,
+		CreatedAt:           u.CreatedAt.Time,
+		NotificationPreview: u.NotificationPreview,
+		QuietHours:          u.GetQuietHours(),
+		ShareLastSeen:       u.ShareLastSeen,
+		LastSeenAt:          u.LastSeenAt(),
+	}
+}
+
+// UserSummary is the public view of a user, e.g. in search results: no email, role or settings
+type UserSummary struct {
+	UserID    uint   `json:"user_id" example:"7"`
+	Username  string `json:"username" example:"jane_doe"`
+	AvatarURL string `json:"avatar_url" example:"https://res.cloudinary.com/demo/image/upload/avatar.jpg"`
+}
+
+// ToSummary converts a User to its public UserSummary
+func (u *User) ToSummary() UserSummary {
+	return UserSummary{
+		UserID:    u.UserID,
+		Username:  u.Username,
+		AvatarURL: u.AvatarURL,
+	}
+}
+
+// MarshalJSON encodes a User as a UserResponse so a raw user row can't leak internal columns
+func (u User) MarshalJSON() ([]byte, error) {
+	return json.Marshal(u.ToResponse())
 }
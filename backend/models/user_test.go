@@ -0,0 +1,87 @@
+package models
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata" // Timezone cases shouldn't depend on the host's zoneinfo
+)
+
+func TestQuietHoursContains(t *testing.T) {
+	// at is a UTC time on a fixed day
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, time.March, 10, hour, minute, 0, 0, time.UTC)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		hours QuietHours
+		t     time.Time
+		want  bool
+	}{
+		{"inside a daytime window", QuietHours{"13:00", "15:00", "UTC"}, at(14, 0), true},
+		{"before a daytime window", QuietHours{"13:00", "15:00", "UTC"}, at(12, 59), false},
+		{"start is inclusive", QuietHours{"13:00", "15:00", "UTC"}, at(13, 0), true},
+		{"end is exclusive", QuietHours{"13:00", "15:00", "UTC"}, at(15, 0), false},
+		{"late evening in a window crossing midnight", QuietHours{"22:00", "07:00", "UTC"}, at(23, 30), true},
+		{"early morning in a window crossing midnight", QuietHours{"22:00", "07:00", "UTC"}, at(6, 59), true},
+		{"midday outside a window crossing midnight", QuietHours{"22:00", "07:00", "UTC"}, at(12, 0), false},
+		{"end of a window crossing midnight", QuietHours{"22:00", "07:00", "UTC"}, at(7, 0), false},
+		// 15:00 UTC is 23:00 in Kuala Lumpur (UTC+8) and 10:00 or 11:00 in New York
+		{"inside in the user's timezone", QuietHours{"22:00", "07:00", "Asia/Kuala_Lumpur"}, at(15, 0), true},
+		{"outside in the user's timezone", QuietHours{"22:00", "07:00", "Asia/Kuala_Lumpur"}, at(3, 0), false},
+		{"timezone behind UTC", QuietHours{"09:00", "12:00", "America/New_York"}, at(15, 0), true},
+		{"unknown timezone contains nothing", QuietHours{"00:00", "23:59", "Mars/Olympus"}, at(12, 0), false},
+		{"malformed time contains nothing", QuietHours{"10pm", "07:00", "UTC"}, at(23, 0), false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.hours.Contains(tc.t); got != tc.want {
+				t.Errorf("%+v.Contains(%s) = %v, want %v", tc.hours, tc.t.Format(time.RFC3339), got, tc.want)
+			}
+		})
+	}
+}
+
+func TestQuietHoursValidate(t *testing.T) {
+	for _, tc := range []struct {
+		hours QuietHours
+		valid bool
+	}{
+		{QuietHours{"22:00", "07:00", "Asia/Singapore"}, true},
+		{QuietHours{"25:00", "07:00", "UTC"}, false},
+		{QuietHours{"22:00", "", "UTC"}, false},
+		{QuietHours{"22:00", "22:00", "UTC"}, false},
+		{QuietHours{"22:00", "07:00", ""}, false},
+		{QuietHours{"22:00", "07:00", "Nowhere/City"}, false},
+	} {
+		if err := tc.hours.Validate(); (err == nil) != tc.valid {
+			t.Errorf("%+v.Validate() = %v, want valid=%v", tc.hours, err, tc.valid)
+		}
+	}
+}
+
+func TestUserLastSeenAt(t *testing.T) {
+	login := CustomTime{Time: time.Date(2024, time.March, 10, 9, 0, 0, 0, time.UTC)}
+	heartbeat := CustomTime{Time: login.Add(time.Hour)}
+	sameTime := func(a, b *time.Time) bool { return (a == nil) == (b == nil) && (a == nil || a.Equal(*b)) }
+
+	for _, tc := range []struct {
+		name string
+		user User
+		want *time.Time
+	}{
+		{"latest heartbeat", User{ShareLastSeen: true, Heartbeat: &heartbeat, LastLoginAt: &login}, &heartbeat.Time},
+		{"falls back to the last login", User{ShareLastSeen: true, LastLoginAt: &login}, &login.Time},
+		{"zero heartbeat falls back too", User{ShareLastSeen: true, Heartbeat: &CustomTime{}, LastLoginAt: &login}, &login.Time},
+		{"never active", User{ShareLastSeen: true}, nil},
+		{"opted out", User{ShareLastSeen: false, Heartbeat: &heartbeat, LastLoginAt: &login}, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.user.LastSeenAt(); !sameTime(got, tc.want) {
+				t.Errorf("LastSeenAt() = %v, want %v", got, tc.want)
+			}
+			if got := tc.user.ToResponse().LastSeenAt; !sameTime(got, tc.want) {
+				t.Errorf("response last_seen_at = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/ginchat/config"
 	"github.com/ginchat/controllers"
 	"github.com/ginchat/middleware"
+	"github.com/ginchat/models"
 	"github.com/ginchat/services"
 	"github.com/ginchat/utils"
 	"github.com/sirupsen/logrus"
@@ -14,24 +16,71 @@ import (
 )
 
 // SetupRoutes configures all the routes for the application
-func SetupRoutes(r *gin.Engine, db *gorm.DB, mongodb *mongo.Database, logger *logrus.Logger) {
-	// Create services
+func SetupRoutes(r *gin.Engine, db *gorm.DB, mongodb *mongo.Database, logger *logrus.Logger, cfg *config.Config) {
+	encryption := services.NewConfiguredEncryption(cfg.MessageEncryptionKey)
+	mongodb = services.WithMessageDecryption(mongodb, encryption) // Messages decrypt as they are decoded, so encrypted text is transparent to every query path
+	messageFilter := services.NewConfiguredMessageFilter(cfg.MessageFilterEnabled, cfg.MessageFilterWordsFile)
+
+	// Create services (built once and shared, so the media upload limit holds across controllers)
 	userService := services.NewUserService(db)
-	// Create chatroom and message services but comment them out until they're used
-	// chatroomService := services.NewChatroomService(mongodb)
-	// messageService := services.NewMessageService(mongodb, chatroomService)
+	chatroomService := services.NewChatroomService(mongodb, cfg.ReadPointerTracking, cfg.DefaultChatroomIDs)
+	userService.SetDefaultChatroomJoiner(chatroomService)
+	readStatusService := services.NewMessageReadStatusService(mongodb, chatroomService, userService, cfg.ReadPointerTracking, cfg.ReadStatusReconcileBatch)
+	mediaStore := services.NewMediaStore(cfg) // Cloudinary, or local disk when Cloudinary isn't configured
+	messageService := services.NewMessageService(mongodb, chatroomService, mediaStore, readStatusService, messageFilter, encryption, cfg.MessageHistoryMaxLimit, cfg.MessageEditWindow)
+	pushNotificationService := services.NewPushNotificationService(db, mongodb, services.NewConfiguredFCMSender(cfg.FCMCredentialsFile), cfg.PushStyles)
 
 	// Create controllers
-	userController := controllers.NewUserController(db, userService)
-	chatroomController := controllers.NewChatroomController(db, mongodb)
-	messageController := controllers.NewMessageController(db, mongodb)
-	// Use the messageService when the MessageController is updated to accept it
-	// messageController := controllers.NewMessageController(db, messageService)
-	websocketController := controllers.NewWebSocketController(logger)
+	userController := controllers.NewUserController(db, userService, messageService)
+	chatroomController := controllers.NewChatroomController(chatroomService, messageService, userService)
+	messageController := controllers.NewMessageController(messageService, pushNotificationService)
+	websocketController := controllers.NewWebSocketController(logger, cfg.WSPingInterval, cfg.WSPongTimeout, cfg.WSMaxMessageSize)
+	websocketController.SetMessageSender(messageController) // Persist chat_message events sent over the socket
+	websocketController.SetPresenceRecorder(userService)    // Socket activity keeps users' last seen time current
+	websocketController.SetMembershipChecker(chatroomService)
+	websocketController.SetTokenRevocationChecker(userService) // Logged-out tokens can't open sockets
+	chatroomController.SetWebSocketController(websocketController)
+	chatroomController.SetCreationLimit(cfg.ChatroomCreateLimit, cfg.ChatroomCreateWindow)
+	chatroomController.SetMembershipLimit(cfg.MaxChatroomsPerUser)
+	messageController.SetWebSocketController(websocketController)
 	pushTokenController := controllers.NewPushTokenController(db)
+	messageReportController := controllers.NewMessageReportController(mongodb, chatroomService)
+	messageReportController.SetWebSocketController(websocketController)
+	adminController := controllers.NewAdminController(db, mongodb)
+	notificationSettingsController := controllers.NewNotificationSettingsController(db, mongodb, userService)
+	sessionController := controllers.NewSessionController(db, userService)
+	sessionController.SetWebSocketController(websocketController)
+	adminController.SetWebSocketController(websocketController)
+
+	// Create media controller
+	mediaController := controllers.NewMediaController(mediaStore, messageService)
+	if dir := services.LocalMediaDir(mediaStore); dir != "" {
+		services.SetupMediaRoutes(r, dir) // Serves locally stored uploads under /media
+	}
 
-	// Create media controller with Cloudinary
-	mediaController := controllers.NewMediaController()
+	// Limits and feature flags clients read at startup instead of hardcoding them
+	configController := controllers.NewConfigController(controllers.ClientConfig{
+		Limits: controllers.ClientLimits{
+			MaxUploadSize:                cfg.MaxUploadSize,
+			MaxAlbumAttachments:          models.MaxAlbumAttachments,
+			MediaTypes:                   []string{models.ChatroomMediaImage, models.ChatroomMediaAudio, models.ChatroomMediaVideo},
+			MessageHistoryMaxLimit:       cfg.MessageHistoryMaxLimit,
+			MessageEditWindowMinutes:     int(cfg.MessageEditWindow.Minutes()),
+			MaxChatroomDescriptionLength: models.MaxChatroomDescriptionLength,
+			MaxChatroomTopicLength:       models.MaxChatroomTopicLength,
+			MaxChatroomsPerUser:          cfg.MaxChatroomsPerUser,
+			ChatroomCreateLimit:          cfg.ChatroomCreateLimit,
+			ChatroomCreateWindowSeconds:  int(cfg.ChatroomCreateWindow.Seconds()),
+			WSMaxMessageSize:             cfg.WSMaxMessageSize,
+			WSPingIntervalSeconds:        int(cfg.WSPingInterval.Seconds()),
+		},
+		Features: controllers.ClientFeatures{
+			MessageFilter:       messageFilter != nil,
+			MessageEncryption:   encryption != nil,
+			LocalMediaStorage:   services.LocalMediaDir(mediaStore) != "",
+			ReadPointerTracking: cfg.ReadPointerTracking,
+		},
+	})
 
 	// Health check endpoint
 	r.GET("/health", func(c *gin.Context) {
@@ -50,6 +99,9 @@ func SetupRoutes(r *gin.Engine, db *gorm.DB, mongodb *mongo.Database, logger *lo
 	// API routes
 	api := r.Group("/api")
 	{
+		// Client configuration (no auth required)
+		api.GET("/config", configController.GetClientConfig)
+
 		// Auth routes (no auth required)
 		auth := api.Group("/auth")
 		{
@@ -61,51 +113,110 @@ func SetupRoutes(r *gin.Engine, db *gorm.DB, mongodb *mongo.Database, logger *lo
 
 		// Protected routes (auth required)
 		protected := api.Group("/")
-		protected.Use(middleware.AuthMiddleware())
+		protected.Use(middleware.AuthMiddleware(userService, sessionController.SessionService)) // Keeps last_active_at current for the session list
 		{
 			// User routes
 			protected.POST("/auth/logout", userController.Logout)
+			protected.GET("/auth/sessions", sessionController.ListSessions)
+			protected.DELETE("/auth/sessions/:id", sessionController.RevokeSession)
 
 			// Push token routes
 			protected.POST("/auth/push-token", pushTokenController.RegisterPushToken)
 			protected.PUT("/auth/push-token", pushTokenController.UpdatePushToken)
 			protected.DELETE("/auth/push-token", pushTokenController.RemovePushToken)
 
+			// User profile and preference routes
+			protected.PUT("/users/profile", userController.UpdateProfile)
+			protected.GET("/users/notification-preview", userController.GetNotificationPreview)
+			protected.PUT("/users/notification-preview", userController.UpdateNotificationPreview)
+			protected.GET("/users/quiet-hours", userController.GetQuietHours)
+			protected.PUT("/users/quiet-hours", userController.UpdateQuietHours)
+			protected.DELETE("/users/quiet-hours", userController.ClearQuietHours)
+			protected.GET("/notifications/settings", notificationSettingsController.GetNotificationSettings)
+			protected.PUT("/notifications/settings", notificationSettingsController.UpdateNotificationSettings)
+			protected.PUT("/users/last-seen-sharing", userController.UpdateLastSeenSharing)
+			protected.GET("/users/search", userController.SearchUsers)
+
 			// Chatroom routes
 			protected.GET("/chatrooms", chatroomController.GetChatrooms)
 			protected.GET("/chatrooms/user", chatroomController.GetChatroomsByUserID)
+			protected.GET("/chatrooms/user/limit", chatroomController.GetMembershipUsage)
 			protected.GET("/chatrooms/:id", chatroomController.GetChatroomByID)
 			protected.POST("/chatrooms", chatroomController.CreateChatroom)
+			protected.PUT("/chatrooms/:id", chatroomController.UpdateChatroom)
 			protected.POST("/chatrooms/:id/join", chatroomController.JoinChatroom)
 			protected.POST("/chatrooms/join", chatroomController.JoinChatroomByCode)
+			protected.POST("/chatrooms/:id/leave", chatroomController.LeaveChatroom)
+			protected.GET("/chatrooms/:id/membership", chatroomController.GetMembership)
+			protected.GET("/chatrooms/:id/info", chatroomController.GetChatroomInfo)
+			protected.POST("/chatrooms/:id/pin", chatroomController.PinChatroom)
+			protected.DELETE("/chatrooms/:id/pin", chatroomController.UnpinChatroom)
+			protected.POST("/chatrooms/join-batch", chatroomController.JoinChatroomsBatch) // Onboarding: join several rooms at once
 			protected.DELETE("/chatrooms/:id", chatroomController.DeleteChatroom)
+			protected.POST("/chatrooms/:id/clear", chatroomController.ClearChatroom)
+			protected.PUT("/chatrooms/:id/members/:user_id/role", chatroomController.SetMemberRole)
 
 			// Message routes
 			protected.GET("/chatrooms/:id/messages", messageController.GetMessages)
 			protected.GET("/chatrooms/:id/messages/paginated", messageController.GetMessagesPaginated) // New paginated endpoint for mobile
 			protected.GET("/chatrooms/:id/media", messageController.GetChatroomMedia)                  // New endpoint to get all media from chatroom
+			protected.GET("/chatrooms/:id/media/counts", messageController.GetChatroomMediaCounts)
+			protected.GET("/chatrooms/:id/messages/:messageId/context", messageController.GetMessageContext)
+			protected.GET("/chatrooms/:id/messages/by-sender/:senderId", messageController.GetMessagesBySender)
+			protected.GET("/chatrooms/:id/messages/range", messageController.GetMessagesInRange)
+			protected.GET("/chatrooms/:id/message-count", messageController.GetMessageCount)
 			protected.POST("/chatrooms/:id/messages", messageController.SendMessage)
+			protected.POST("/chatrooms/:id/messages/with-media", messageController.SendMessageWithMedia) // Upload + send in one request
 			protected.PUT("/chatrooms/:id/messages/:messageId", messageController.UpdateMessage)
 			protected.DELETE("/chatrooms/:id/messages/:messageId", messageController.DeleteMessage)
+			protected.GET("/chatrooms/:id/draft", messageController.GetDraft)
+			protected.PUT("/chatrooms/:id/draft", messageController.SaveDraft)
+			protected.DELETE("/chatrooms/:id/draft", messageController.DeleteDraft)
 
 			// Message read status routes
-			messageReadStatusController := controllers.NewMessageReadStatusController(
-				services.NewMessageReadStatusService(mongodb, services.NewChatroomService(mongodb), services.NewUserService(db)),
-			)
+			if cfg.ReadStatusReconcileEnabled {
+				readStatusService.StartOrphanReconciler(cfg.ReadStatusReconcileInterval) // Prune rows left behind by deleted messages and chatrooms
+			}
+			if cfg.ReadPointerTracking {
+				readStatusService.StartLastReadBackfill() // Carry reads recorded as rows over to the pointers
+			}
+			messageReadStatusController := controllers.NewMessageReadStatusController(readStatusService)
+			messageReadStatusController.SetWebSocketController(websocketController)
+			websocketController.SetReadMarker(messageReadStatusController) // Handle mark_read events sent over the socket
+			websocketController.SetUnreadCounter(readStatusService)        // Answer get_unread_counts requests
 			protected.POST("/messages/read", messageReadStatusController.MarkMessageAsRead)
 			protected.POST("/messages/:message_id/mark-read", messageReadStatusController.MarkSingleMessageAsRead) // New endpoint for auto-read via WebSocket
 			protected.POST("/messages/read-multiple", messageReadStatusController.MarkMultipleMessagesAsRead)
+			protected.POST("/messages/mark-all-read", messageReadStatusController.MarkAllChatroomsAsRead)
 			protected.GET("/messages/unread-counts", messageReadStatusController.GetUnreadCountForUser)
+			protected.POST("/messages/unread-counts/filter", messageReadStatusController.GetUnreadCountForChatrooms)
 			protected.GET("/messages/latest", messageReadStatusController.GetLatestMessagesForChatrooms)
 			protected.GET("/messages/:message_id/read-status", messageReadStatusController.GetMessageReadStatus)
+			protected.POST("/messages/read-status/batch", messageReadStatusController.GetMessageReadStatusBatch)
 			protected.GET("/messages/:message_id/read-by-who", messageReadStatusController.GetMessageReadByWho)
+			protected.GET("/messages/:message_id/unread-by", messageReadStatusController.GetMessageUnreadBy)
+			protected.POST("/messages/:message_id/resend-notification", messageController.ResendMessageNotification)
+			protected.POST("/messages/:message_id/report", messageReportController.ReportMessage)
 			protected.GET("/chatrooms/:id/last-read", messageReadStatusController.GetUserLastReadForChatroom)
 			protected.POST("/chatrooms/:id/mark-all-read", messageReadStatusController.MarkAllMessagesInChatroomAsRead)
 			protected.GET("/chatrooms/:id/first-unread", messageReadStatusController.GetFirstUnreadMessageInChatroom)
+			protected.GET("/chatrooms/:id/first-unread/context", messageReadStatusController.GetFirstUnreadWithContext)
 			protected.GET("/chatrooms/:id/unread-count", messageReadStatusController.GetUnreadCountForChatroom)
 
 			// Media routes
 			protected.POST("/media/upload", mediaController.UploadMedia)
+			protected.GET("/media/proxy", mediaController.ProxyMedia)
+			protected.GET("/media/mine", mediaController.GetMyMedia)
+
+			// Admin routes (global admin role required)
+			admin := protected.Group("/admin")
+			admin.Use(middleware.AdminOnly())
+			{
+				admin.GET("/stats", adminController.GetSystemStats)
+				admin.POST("/read-status/reconcile", messageReadStatusController.ReconcileReadStatus)
+				admin.GET("/reports", messageReportController.ListReports)
+				admin.PUT("/reports/:report_id", messageReportController.ResolveReport)
+			}
 		}
 		// WebSocket route OUTSIDE protected group for both mobile and web (token + room_id)
 		api.GET("/ws", websocketController.HandleConnection)
@@ -115,13 +226,20 @@ func SetupRoutes(r *gin.Engine, db *gorm.DB, mongodb *mongo.Database, logger *lo
 	r.GET("/api/ws-debug", func(c *gin.Context) {
 		token := c.Query("token")
 		if token == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "No token provided"})
+			c.JSON(http.StatusBadRequest, utils.NewAPIError(http.StatusBadRequest, utils.CodeInvalidRequest, "No token provided"))
 			return
 		}
 
+		invalidToken := utils.NewAPIError(http.StatusUnauthorized, utils.CodeUnauthorized, "Invalid token")
 		claims, err := utils.ValidateJWT(token)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token", "details": err.Error()})
+			invalidToken.Details = err.Error()
+			c.JSON(http.StatusUnauthorized, invalidToken)
+			return
+		}
+		if userService.IsTokenRevoked(claims.Id) {
+			invalidToken.Details = "token has been revoked"
+			c.JSON(http.StatusUnauthorized, invalidToken)
 			return
 		}
 
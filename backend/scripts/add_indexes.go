@@ -98,6 +98,21 @@ func main() {
 		fmt.Println("✅ Created index: chatroom_sent_at_idx")
 	}
 
+	// Index for chronological reads with a stable tie-break (chatroom_id + sent_at + _id), e.g. loading unread messages
+	_, err = messagesColl.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "chatroom_id", Value: 1},
+			{Key: "sent_at", Value: -1},
+			{Key: "_id", Value: -1},
+		},
+		Options: options.Index().SetName("chatroom_sent_at_id_idx"),
+	})
+	if err != nil {
+		log.Printf("⚠️  Warning: Failed to create chatroom_sent_at_id_idx: %v", err)
+	} else {
+		fmt.Println("✅ Created index: chatroom_sent_at_id_idx")
+	}
+
 	// Index for sender information queries (sender_id + sent_at)
 	_, err = messagesColl.Indexes().CreateOne(context.Background(), mongo.IndexModel{
 		Keys: bson.D{
@@ -112,6 +127,19 @@ func main() {
 		fmt.Println("✅ Created index: sender_sent_at_idx")
 	}
 
+	// Index for recent message counts across all chatrooms (sent_at), used by the admin stats
+	_, err = messagesColl.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "sent_at", Value: -1},
+		},
+		Options: options.Index().SetName("sent_at_idx"),
+	})
+	if err != nil {
+		log.Printf("⚠️  Warning: Failed to create sent_at_idx: %v", err)
+	} else {
+		fmt.Println("✅ Created index: sent_at_idx")
+	}
+
 	// Add indexes for chatrooms collection
 	chatroomsColl := db.Collection("chatrooms")
 
@@ -141,6 +169,34 @@ func main() {
 		fmt.Println("✅ Created index: room_code_idx")
 	}
 
+	// Index for room discovery by name; a case-insensitive regex can't seek it,
+	// but scanning index keys is much cheaper than scanning every chatroom document
+	_, err = chatroomsColl.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "name", Value: 1},
+		},
+		Options: options.Index().SetName("name_idx"),
+	})
+	if err != nil {
+		log.Printf("⚠️  Warning: Failed to create name_idx: %v", err)
+	} else {
+		fmt.Println("✅ Created index: name_idx")
+	}
+
+	// Chatroom names are unique per creator, not globally
+	_, err = chatroomsColl.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "created_by", Value: 1},
+			{Key: "name", Value: 1},
+		},
+		Options: options.Index().SetName("creator_name_idx").SetUnique(true),
+	})
+	if err != nil {
+		log.Printf("⚠️  Warning: Failed to create creator_name_idx: %v", err)
+	} else {
+		fmt.Println("✅ Created index: creator_name_idx")
+	}
+
 	// Add indexes for user_last_read collection
 	userLastReadColl := db.Collection("user_last_read")
 
@@ -158,6 +214,64 @@ func main() {
 		fmt.Println("✅ Created index: user_chatroom_idx")
 	}
 
+	// Index for per-user chatroom pins (user_id + chatroom_id)
+	pinnedColl := db.Collection("pinned_chatrooms")
+	_, err = pinnedColl.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "user_id", Value: 1},
+			{Key: "chatroom_id", Value: 1},
+		},
+		Options: options.Index().SetName("user_pinned_chatroom_idx").SetUnique(true),
+	})
+	if err != nil {
+		log.Printf("⚠️  Warning: Failed to create user_pinned_chatroom_idx: %v", err)
+	} else {
+		fmt.Println("✅ Created index: user_pinned_chatroom_idx")
+	}
+
+	// One draft per user per chatroom
+	draftColl := db.Collection("message_drafts")
+	_, err = draftColl.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "user_id", Value: 1},
+			{Key: "chatroom_id", Value: 1},
+		},
+		Options: options.Index().SetName("user_chatroom_draft_idx").SetUnique(true),
+	})
+	if err != nil {
+		log.Printf("⚠️  Warning: Failed to create user_chatroom_draft_idx: %v", err)
+	} else {
+		fmt.Println("✅ Created index: user_chatroom_draft_idx")
+	}
+
+	// Message reports: one report per user per message, and the admin queue by status
+	reportColl := db.Collection("message_reports")
+	_, err = reportColl.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "message_id", Value: 1},
+			{Key: "reporter_id", Value: 1},
+		},
+		Options: options.Index().SetName("message_reporter_idx").SetUnique(true),
+	})
+	if err != nil {
+		log.Printf("⚠️  Warning: Failed to create message_reporter_idx: %v", err)
+	} else {
+		fmt.Println("✅ Created index: message_reporter_idx")
+	}
+
+	_, err = reportColl.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "status", Value: 1},
+			{Key: "created_at", Value: -1},
+		},
+		Options: options.Index().SetName("status_created_idx"),
+	})
+	if err != nil {
+		log.Printf("⚠️  Warning: Failed to create status_created_idx: %v", err)
+	} else {
+		fmt.Println("✅ Created index: status_created_idx")
+	}
+
 	fmt.Println("🎉 Database indexes optimization complete!")
 	fmt.Println("📊 Expected performance improvements:")
 	fmt.Println("   • Mark message as read: ~80% faster")
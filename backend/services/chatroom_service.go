@@ -3,7 +3,10 @@ package services
 import (
 	"context"
 	"errors"
+	"log"
 	"math/rand"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/ginchat/models"
@@ -15,28 +18,61 @@ import (
 
 // ChatroomService handles business logic related to chatrooms
 type ChatroomService struct {
-	MongoDB  *mongo.Database
-	ChatColl *mongo.Collection
+	MongoDB             *mongo.Database
+	ChatColl            *mongo.Collection
+	PinnedColl          *mongo.Collection
+	MembershipCountColl *mongo.Collection // How many chatrooms each user belongs to, keyed by user ID
+
+	readPointerTracking bool                 // Unread state from last-read pointers instead of per-recipient rows
+	defaultChatroomIDs  []primitive.ObjectID // Rooms every new user joins
+}
+
+// NewChatroomService creates a new ChatroomService. defaultChatroomIDs are the hex IDs of the rooms
+// new users join (DEFAULT_CHATROOM_IDS); invalid ones are skipped.
+func NewChatroomService(mongodb *mongo.Database, readPointerTracking bool, defaultChatroomIDs []string) *ChatroomService {
+	service := &ChatroomService{
+		MongoDB:             mongodb,
+		ChatColl:            mongodb.Collection("chatrooms"),
+		PinnedColl:          mongodb.Collection("pinned_chatrooms"),
+		MembershipCountColl: mongodb.Collection("chatroom_membership_counts"),
+		readPointerTracking: readPointerTracking,
+	}
+	for _, id := range defaultChatroomIDs {
+		if chatroomID, err := primitive.ObjectIDFromHex(id); err == nil {
+			service.defaultChatroomIDs = append(service.defaultChatroomIDs, chatroomID)
+		}
+	}
+	return service
 }
 
-// NewChatroomService creates a new ChatroomService
-func NewChatroomService(mongodb *mongo.Database) *ChatroomService {
-	return &ChatroomService{
-		MongoDB:  mongodb,
-		ChatColl: mongodb.Collection("chatrooms"),
+// Room codes are 6 uppercase letters or digits
+const (
+	roomCodeCharset = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	roomCodeLength  = 6
+)
+
+// NormalizeRoomCode trims and uppercases a room code as typed by a user, so "  abc123" finds ABC123.
+// It fails if the result is not 6 letters or digits, since no room could have that code.
+func NormalizeRoomCode(roomCode string) (string, error) {
+	code := strings.ToUpper(strings.TrimSpace(roomCode))
+	if len(code) != roomCodeLength {
+		return "", errors.New("invalid room code format")
+	}
+	for _, r := range code {
+		if !strings.ContainsRune(roomCodeCharset, r) {
+			return "", errors.New("invalid room code format")
+		}
 	}
+	return code, nil
 }
 
 // generateRoomCode generates a unique 6-character room code
 func (s *ChatroomService) generateRoomCode() (string, error) {
-	const charset = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	const codeLength = 6
-
 	for attempts := 0; attempts < 10; attempts++ {
 		// Generate random code
-		code := make([]byte, codeLength)
+		code := make([]byte, roomCodeLength)
 		for i := range code {
-			code[i] = charset[rand.Intn(len(charset))]
+			code[i] = roomCodeCharset[rand.Intn(len(roomCodeCharset))]
 		}
 		roomCode := string(code)
 
@@ -55,9 +91,25 @@ func (s *ChatroomService) generateRoomCode() (string, error) {
 }
 
 // CreateChatroom creates a new chatroom
-func (s *ChatroomService) CreateChatroom(name string, userID uint, username string, password string) (*models.Chatroom, error) {
-	// Check if chatroom with the same name already exists
-	count, err := s.ChatColl.CountDocuments(context.Background(), bson.M{"name": name}, options.Count())
+func (s *ChatroomService) CreateChatroom(name, description, topic string, userID uint, username string, password string, membershipLimit int) (*models.Chatroom, error) {
+	if err := validateChatroomDetails(description, topic); err != nil {
+		return nil, err
+	}
+
+	// The creator becomes a member, so creating counts against the membership cap
+	if err := s.reserveMembership(userID, membershipLimit); err != nil {
+		return nil, err
+	}
+	created := false
+	defer func() {
+		if !created {
+			s.releaseMemberships(userID)
+		}
+	}()
+
+	// Names only have to be unique among the creator's own rooms, so two users can each have a "General".
+	// creator_name_idx enforces this when two creates race.
+	count, err := s.ChatColl.CountDocuments(context.Background(), bson.M{"created_by": userID, "name": name}, options.Count())
 	if err != nil {
 		return nil, errors.New("failed to check chatroom existence")
 	}
@@ -73,15 +125,18 @@ func (s *ChatroomService) CreateChatroom(name string, userID uint, username stri
 
 	// Create new chatroom
 	chatroom := models.Chatroom{
-		ID:        primitive.NewObjectID(),
-		Name:      name,
-		RoomCode:  roomCode,
-		CreatedBy: userID,
-		CreatedAt: time.Now(),
+		ID:          primitive.NewObjectID(),
+		Name:        name,
+		Description: description,
+		Topic:       topic,
+		RoomCode:    roomCode,
+		CreatedBy:   userID,
+		CreatedAt:   time.Now(),
 		Members: []models.ChatroomMember{
 			{
 				UserID:   userID,
 				Username: username,
+				Role:     models.ChatroomRoleAdmin,
 				JoinedAt: time.Now(),
 			},
 		},
@@ -96,12 +151,133 @@ func (s *ChatroomService) CreateChatroom(name string, userID uint, username stri
 	// Save chatroom to MongoDB
 	_, err = s.ChatColl.InsertOne(context.Background(), chatroom)
 	if err != nil {
+		if mongo.IsDuplicateKeyError(err) && strings.Contains(err.Error(), "creator_name_idx") {
+			return nil, errors.New("chatroom with this name already exists")
+		}
 		return nil, errors.New("failed to create chatroom")
 	}
+	created = true
 
 	return &chatroom, nil
 }
 
+// UpdateChatroomDetails updates a chatroom's name, description, topic, message filter policy and other settings (only admins can update).
+// Nil fields are left unchanged and an empty description or topic clears the field.
+// renamedFrom is the room's previous name when the update renamed it, and empty otherwise.
+func (s *ChatroomService) UpdateChatroomDetails(chatroomID primitive.ObjectID, userID uint, name, description, topic, filterPolicy *string, allowedMediaTypes *[]string, readReceipts *bool, editWindowMinutes *int) (chatroom *models.Chatroom, renamedFrom string, err error) {
+	if name == nil && description == nil && topic == nil && filterPolicy == nil && allowedMediaTypes == nil && readReceipts == nil && editWindowMinutes == nil {
+		return nil, "", errors.New("no changes provided")
+	}
+	if name != nil {
+		trimmed := strings.TrimSpace(*name)
+		if length := len([]rune(trimmed)); length < models.MinChatroomNameLength || length > models.MaxChatroomNameLength {
+			return nil, "", errors.New("invalid chatroom name")
+		}
+		name = &trimmed
+	}
+
+	if filterPolicy != nil && !models.IsValidChatroomFilterPolicy(*filterPolicy) {
+		return nil, "", errors.New("invalid filter policy")
+	}
+	if allowedMediaTypes != nil {
+		for _, mediaType := range *allowedMediaTypes {
+			if !models.IsValidChatroomMediaType(mediaType) {
+				return nil, "", errors.New("invalid media type")
+			}
+		}
+	}
+	if editWindowMinutes != nil && (*editWindowMinutes < 0 || *editWindowMinutes > models.MaxMessageEditWindowMinutes) {
+		return nil, "", errors.New("invalid edit window")
+	}
+
+	// Check if chatroom exists
+	chatroom, err = s.GetChatroomByID(chatroomID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if s.GetMemberRole(chatroom, userID) != models.ChatroomRoleAdmin {
+		return nil, "", errors.New("only chatroom admins can update this chatroom")
+	}
+	// Restricting media affects what everyone can post, so it's the creator's call
+	if allowedMediaTypes != nil && chatroom.CreatedBy != userID {
+		return nil, "", errors.New("only the creator can change allowed media types")
+	}
+	if readReceipts != nil && chatroom.CreatedBy != userID {
+		return nil, "", errors.New("only the creator can change read receipts")
+	}
+	if editWindowMinutes != nil && chatroom.CreatedBy != userID {
+		return nil, "", errors.New("only the creator can change the edit window")
+	}
+
+	update := bson.M{}
+	if name != nil && *name != chatroom.Name {
+		// Names stay unique among the creator's rooms, like at creation
+		count, err := s.ChatColl.CountDocuments(context.Background(), bson.M{"created_by": chatroom.CreatedBy, "name": *name, "_id": bson.M{"$ne": chatroomID}})
+		if err != nil {
+			return nil, "", errors.New("failed to check chatroom existence")
+		}
+		if count > 0 {
+			return nil, "", errors.New("chatroom with this name already exists")
+		}
+		renamedFrom = chatroom.Name
+		chatroom.Name = *name
+		update["name"] = chatroom.Name
+	}
+	if description != nil {
+		chatroom.Description = *description
+		update["description"] = chatroom.Description
+	}
+	if topic != nil {
+		chatroom.Topic = *topic
+		update["topic"] = chatroom.Topic
+	}
+	if filterPolicy != nil {
+		chatroom.FilterPolicy = *filterPolicy
+		update["filter_policy"] = chatroom.FilterPolicy
+	}
+	if allowedMediaTypes != nil {
+		chatroom.AllowedMediaTypes = *allowedMediaTypes
+		update["allowed_media_types"] = chatroom.AllowedMediaTypes
+	}
+	if readReceipts != nil {
+		chatroom.ReadReceipts = readReceipts
+		update["read_receipts_enabled"] = *readReceipts
+	}
+	if editWindowMinutes != nil {
+		chatroom.EditWindowMinutes = editWindowMinutes
+		update["edit_window_minutes"] = *editWindowMinutes
+	}
+
+	if err := validateChatroomDetails(chatroom.Description, chatroom.Topic); err != nil {
+		return nil, "", err
+	}
+	if len(update) == 0 {
+		return chatroom, "", nil // Only a rename to the current name
+	}
+
+	_, err = s.ChatColl.UpdateOne(context.Background(), bson.M{"_id": chatroomID}, bson.M{"$set": update})
+	if mongo.IsDuplicateKeyError(err) && strings.Contains(err.Error(), "creator_name_idx") {
+		return nil, "", errors.New("chatroom with this name already exists")
+	}
+	if err != nil {
+		return nil, "", errors.New("failed to update chatroom")
+	}
+
+	return chatroom, renamedFrom, nil
+}
+
+// validateChatroomDetails checks the description and topic lengths
+func validateChatroomDetails(description, topic string) error {
+	if len([]rune(description)) > models.MaxChatroomDescriptionLength {
+		return errors.New("chatroom description is too long")
+	}
+	if len([]rune(topic)) > models.MaxChatroomTopicLength {
+		return errors.New("chatroom topic is too long")
+	}
+	return nil
+}
+
 // GetChatrooms retrieves all chatrooms
 func (s *ChatroomService) GetChatrooms() ([]models.Chatroom, error) {
 	// Find all chatrooms
@@ -120,6 +296,68 @@ func (s *ChatroomService) GetChatrooms() ([]models.Chatroom, error) {
 	return chatrooms, nil
 }
 
+// Chatroom search limits
+const (
+	DefaultChatroomPageSize = 20
+	MaxChatroomPageSize     = 100
+	maxChatroomSearchLength = 100 // Chatroom names are at most 100 characters
+)
+
+// ChatroomSearchQuery filters and pages SearchChatrooms
+type ChatroomSearchQuery struct {
+	Name          string // Case-insensitive partial match on the chatroom name; empty matches all
+	ExcludeMember uint   // When non-zero, chatrooms this user already belongs to are left out
+	Limit         int    // Clamped to 1..MaxChatroomPageSize
+	Offset        int
+}
+
+// SearchChatrooms returns one page of chatrooms matching query, ordered by name, along with the total match count
+func (s *ChatroomService) SearchChatrooms(query ChatroomSearchQuery) ([]models.Chatroom, int64, error) {
+	if len(query.Name) > maxChatroomSearchLength {
+		return nil, 0, errors.New("chatroom search is too long")
+	}
+	if query.Limit <= 0 {
+		query.Limit = DefaultChatroomPageSize
+	}
+	if query.Limit > MaxChatroomPageSize {
+		query.Limit = MaxChatroomPageSize
+	}
+	if query.Offset < 0 {
+		query.Offset = 0
+	}
+
+	filter := bson.M{}
+	if query.Name != "" {
+		// QuoteMeta so characters like "." or "(" in the search are matched literally
+		filter["name"] = primitive.Regex{Pattern: regexp.QuoteMeta(query.Name), Options: "i"}
+	}
+	if query.ExcludeMember != 0 {
+		filter["members.user_id"] = bson.M{"$ne": query.ExcludeMember}
+	}
+
+	ctx := context.Background()
+	total, err := s.ChatColl.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, errors.New("failed to get chatrooms")
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}).
+		SetSkip(int64(query.Offset)).
+		SetLimit(int64(query.Limit))
+	cursor, err := s.ChatColl.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, errors.New("failed to get chatrooms")
+	}
+	defer cursor.Close(ctx)
+
+	chatrooms := []models.Chatroom{}
+	if err := cursor.All(ctx, &chatrooms); err != nil {
+		return nil, 0, errors.New("failed to decode chatrooms")
+	}
+	return chatrooms, total, nil
+}
+
 // GetUserChatrooms retrieves chatrooms that a user has joined
 func (s *ChatroomService) GetUserChatrooms(userID uint) ([]models.Chatroom, error) {
 	// Find chatrooms where the user is a member
@@ -142,8 +380,29 @@ func (s *ChatroomService) GetUserChatrooms(userID uint) ([]models.Chatroom, erro
 	return chatrooms, nil
 }
 
-// GetUserChatroomsSortedByLatestMessage retrieves user's chatrooms sorted by latest message timestamp
-func (s *ChatroomService) GetUserChatroomsSortedByLatestMessage(userID uint) ([]models.ChatroomWithLatestMessage, error) {
+// Sort modes for the user's chatroom list
+const (
+	ChatroomSortRecent      = "recent"       // Latest message first
+	ChatroomSortUnreadFirst = "unread_first" // Rooms with unread messages first, then latest message first
+)
+
+// IsValidChatroomSort reports whether mode is a supported chatroom sort mode
+func IsValidChatroomSort(mode string) bool {
+	return mode == ChatroomSortRecent || mode == ChatroomSortUnreadFirst
+}
+
+// GetUserChatroomsSortedByLatestMessage retrieves user's chatrooms sorted by latest message timestamp.
+// With ChatroomSortUnreadFirst, rooms where the user has unread messages come before the rest.
+func (s *ChatroomService) GetUserChatroomsSortedByLatestMessage(userID uint, sortMode string) ([]models.ChatroomWithLatestMessage, error) {
+	if !IsValidChatroomSort(sortMode) {
+		return nil, errors.New("invalid sort mode")
+	}
+
+	sortStage := bson.D{{Key: "latest_message_time", Value: -1}}
+	if sortMode == ChatroomSortUnreadFirst {
+		sortStage = bson.D{{Key: "has_unread", Value: -1}, {Key: "latest_message_time", Value: -1}}
+	}
+
 	// Use MongoDB aggregation pipeline for efficient sorting
 	pipeline := []bson.M{
 		// Stage 1: Match chatrooms where user is a member
@@ -175,9 +434,14 @@ func (s *ChatroomService) GetUserChatroomsSortedByLatestMessage(userID uint) ([]
 				"as": "latest_message",
 			},
 		},
-		// Stage 3: Add latest message timestamp for sorting
+	}
+	// Stage 3: Look up whether the user has any unread message here (one is enough)
+	pipeline = append(pipeline, s.unreadLookupStages(userID)...)
+	pipeline = append(pipeline, []bson.M{
+		// Stage 4: Add latest message timestamp and unread flag for sorting
 		{
 			"$addFields": bson.M{
+				"has_unread": bson.M{"$gt": []interface{}{bson.M{"$size": "$unread_status"}, 0}},
 				"latest_message_time": bson.M{
 					"$cond": bson.M{
 						"if": bson.M{"$gt": []interface{}{bson.M{"$size": "$latest_message"}, 0}},
@@ -189,20 +453,22 @@ func (s *ChatroomService) GetUserChatroomsSortedByLatestMessage(userID uint) ([]
 				},
 			},
 		},
-		// Stage 4: Sort by latest message timestamp (descending)
+		// Stage 5: Sort by latest message timestamp (descending), unread rooms first if requested
 		{
-			"$sort": bson.M{"latest_message_time": -1},
+			"$sort": sortStage,
 		},
-		// Stage 5: Project final structure
+		// Stage 6: Project final structure
 		{
 			"$project": bson.M{
 				"_id":          1,
 				"name":         1,
+				"topic":        1,
 				"room_code":    1,
 				"has_password": 1,
 				"created_by":   1,
 				"created_at":   1,
 				"members":      1,
+				"has_unread":   1,
 				"latest_message": bson.M{
 					"$cond": bson.M{
 						"if": bson.M{"$gt": []interface{}{bson.M{"$size": "$latest_message"}, 0}},
@@ -214,7 +480,7 @@ func (s *ChatroomService) GetUserChatroomsSortedByLatestMessage(userID uint) ([]
 				},
 			},
 		},
-	}
+	}...)
 
 	cursor, err := s.ChatColl.Aggregate(context.Background(), pipeline)
 	if err != nil {
@@ -230,6 +496,149 @@ func (s *ChatroomService) GetUserChatroomsSortedByLatestMessage(userID uint) ([]
 	return results, nil
 }
 
+// unreadLookupStages adds an unread_status array to each chatroom that is non-empty when the user has
+// something unread there: an unread row from their current membership, or with pointer tracking any
+// message after their last-read pointer.
+func (s *ChatroomService) unreadLookupStages(userID uint) []bson.M {
+	if !s.readPointerTracking {
+		return []bson.M{{
+			"$lookup": bson.M{
+				"from": "message_read_status",
+				"let": bson.M{
+					"chatroom_id": "$_id",
+					"joined_at": bson.M{"$arrayElemAt": []interface{}{
+						"$members.joined_at",
+						bson.M{"$indexOfArray": []interface{}{"$members.user_id", userID}},
+					}},
+				},
+				"pipeline": []bson.M{
+					{
+						// Same as unreadRowsFilter
+						"$match": bson.M{
+							"$expr": bson.M{"$and": []interface{}{
+								bson.M{"$eq": []interface{}{"$chatroom_id", "$$chatroom_id"}},
+								bson.M{"$gte": []interface{}{"$created_at", "$$joined_at"}},
+							}},
+							"recipient_id": userID,
+							"is_read":      false,
+						},
+					},
+					{
+						"$limit": 1,
+					},
+				},
+				"as": "unread_status",
+			},
+		}}
+	}
+
+	return []bson.M{
+		{
+			"$lookup": bson.M{
+				"from": "user_last_read",
+				"let":  bson.M{"chatroom_id": "$_id"},
+				"pipeline": []bson.M{
+					{
+						"$match": bson.M{
+							"$expr":   bson.M{"$eq": []interface{}{"$chatroom_id", "$$chatroom_id"}},
+							"user_id": userID,
+						},
+					},
+					{
+						"$limit": 1,
+					},
+				},
+				"as": "last_read",
+			},
+		},
+		{
+			"$lookup": bson.M{
+				"from": "messages",
+				"let": bson.M{
+					"chatroom_id": "$_id",
+					// Same as UserLastRead.ReadUpTo; without a pointer, up to just before the user joined
+					"read_up_to": bson.M{"$ifNull": []interface{}{
+						bson.M{"$arrayElemAt": []interface{}{"$last_read.sent_at", 0}},
+						bson.M{"$ifNull": []interface{}{
+							bson.M{"$arrayElemAt": []interface{}{"$last_read.read_at", 0}},
+							bson.M{"$add": []interface{}{
+								bson.M{"$arrayElemAt": []interface{}{
+									"$members.joined_at",
+									bson.M{"$indexOfArray": []interface{}{"$members.user_id", userID}},
+								}},
+								-1, // Milliseconds
+							}},
+						}},
+					}},
+				},
+				"pipeline": []bson.M{
+					{
+						"$match": bson.M{
+							"$expr": bson.M{"$and": []interface{}{
+								bson.M{"$eq": []interface{}{"$chatroom_id", "$$chatroom_id"}},
+								bson.M{"$gt": []interface{}{"$sent_at", "$$read_up_to"}},
+							}},
+							"sender_id":    bson.M{"$ne": userID},
+							"message_type": bson.M{"$ne": models.MessageTypeSystem},
+						},
+					},
+					{
+						"$limit": 1,
+					},
+				},
+				"as": "unread_status",
+			},
+		},
+	}
+}
+
+// ChatroomInfo is the summary shown in a room's info panel
+type ChatroomInfo struct {
+	ChatroomID    string    `json:"chatroom_id" example:"60d5f8b8e6b5f0b3e8b4b5b3"`
+	Name          string    `json:"name" example:"General Chat"`
+	CreatedAt     time.Time `json:"created_at"`
+	CreatedBy     uint      `json:"created_by" example:"1"`
+	CreatedByName string    `json:"created_by_name" example:"john_doe"` // Empty if the creator can no longer be resolved
+	HasPassword   bool      `json:"has_password" example:"false"`
+	MemberCount   int       `json:"member_count" example:"5"`
+	MessageCount  int64     `json:"message_count" example:"1234"`
+}
+
+// GetChatroomInfo returns a chatroom's creation info and stats for one of its members.
+// Messages are counted, not loaded. CreatedByName comes from the creator's member entry;
+// callers with access to the users table should replace it with the current username.
+func (s *ChatroomService) GetChatroomInfo(chatroomID primitive.ObjectID, userID uint) (*ChatroomInfo, error) {
+	chatroom, err := s.GetChatroomByID(chatroomID)
+	if err != nil {
+		return nil, err
+	}
+	if !s.IsMember(chatroom, userID) {
+		return nil, errors.New("user is not a member of this chatroom")
+	}
+
+	messageCount, err := s.MongoDB.Collection("messages").CountDocuments(context.Background(), bson.M{"chatroom_id": chatroomID})
+	if err != nil {
+		return nil, errors.New("failed to count messages")
+	}
+
+	info := &ChatroomInfo{
+		ChatroomID:   chatroom.ID.Hex(),
+		Name:         chatroom.Name,
+		CreatedAt:    chatroom.CreatedAt,
+		CreatedBy:    chatroom.CreatedBy,
+		HasPassword:  chatroom.HasPassword,
+		MemberCount:  len(chatroom.Members),
+		MessageCount: messageCount,
+	}
+	for _, member := range chatroom.Members {
+		if member.UserID == chatroom.CreatedBy {
+			info.CreatedByName = member.Username
+			break
+		}
+	}
+	return info, nil
+}
+
 // GetChatroomByID retrieves a chatroom by ID
 func (s *ChatroomService) GetChatroomByID(chatroomID primitive.ObjectID) (*models.Chatroom, error) {
 	var chatroom models.Chatroom
@@ -240,18 +649,24 @@ func (s *ChatroomService) GetChatroomByID(chatroomID primitive.ObjectID) (*model
 	return &chatroom, nil
 }
 
-// GetChatroomByRoomCode retrieves a chatroom by room code
+// GetChatroomByRoomCode retrieves a chatroom by room code (normalized first, see NormalizeRoomCode)
 func (s *ChatroomService) GetChatroomByRoomCode(roomCode string) (*models.Chatroom, error) {
+	code, err := NormalizeRoomCode(roomCode)
+	if err != nil {
+		return nil, err
+	}
+
 	var chatroom models.Chatroom
-	err := s.ChatColl.FindOne(context.Background(), bson.M{"room_code": roomCode}).Decode(&chatroom)
+	err = s.ChatColl.FindOne(context.Background(), bson.M{"room_code": code}).Decode(&chatroom)
 	if err != nil {
 		return nil, errors.New("chatroom not found")
 	}
 	return &chatroom, nil
 }
 
-// JoinChatroom adds a user to a chatroom (legacy method using ID)
-func (s *ChatroomService) JoinChatroom(chatroomID primitive.ObjectID, userID uint, username string) error {
+// JoinChatroom adds a user to a chatroom (legacy method using ID).
+// membershipLimit caps how many chatrooms the user may belong to; 0 means no cap.
+func (s *ChatroomService) JoinChatroom(chatroomID primitive.ObjectID, userID uint, username string, membershipLimit int) error {
 	// Check if chatroom exists
 	chatroom, err := s.GetChatroomByID(chatroomID)
 	if err != nil {
@@ -265,31 +680,53 @@ func (s *ChatroomService) JoinChatroom(chatroomID primitive.ObjectID, userID uin
 		}
 	}
 
-	// Add user to chatroom members
-	_, err = s.ChatColl.UpdateOne(
-		context.Background(),
-		bson.M{"_id": chatroomID},
-		bson.M{
-			"$push": bson.M{
-				"members": models.ChatroomMember{
-					UserID:   userID,
-					Username: username,
-					JoinedAt: time.Now(),
-				},
-			},
-		},
-	)
-	if err != nil {
-		return errors.New("failed to join chatroom")
+	if err := s.reserveMembership(userID, membershipLimit); err != nil {
+		return err
+	}
+
+	if err := s.addMember(chatroomID, userID, username); err != nil {
+		s.releaseMemberships(userID)
+		return err
+	}
+
+	// Treat existing history as read for the new member (don't fail the join if this fails)
+	if err := s.markHistoryAsReadForNewMember(chatroomID, userID); err != nil {
+		log.Printf("Failed to mark chatroom %s history as read for new member %d: %v", chatroomID.Hex(), userID, err)
 	}
 
 	return nil
 }
 
-// JoinChatroomByCode adds a user to a chatroom using room code and password
-func (s *ChatroomService) JoinChatroomByCode(roomCode string, password string, userID uint, username string) (*models.Chatroom, error) {
+// JoinDefaultChatrooms adds a newly registered user to every room listed in DEFAULT_CHATROOM_IDS and returns the ones joined.
+// Like any join, the user's view of each room's existing history starts out read. Missing rooms are skipped
+// and other failures are logged, so a misconfigured room never blocks registration.
+func (s *ChatroomService) JoinDefaultChatrooms(userID uint, username string) []primitive.ObjectID {
+	var joined []primitive.ObjectID
+	for _, chatroomID := range s.defaultChatroomIDs {
+		if err := s.JoinChatroom(chatroomID, userID, username, 0); err != nil {
+			if err.Error() == "chatroom not found" {
+				log.Printf("Default chatroom %s does not exist, skipping it for user %d", chatroomID.Hex(), userID)
+			} else {
+				log.Printf("Failed to add user %d to default chatroom %s: %v", userID, chatroomID.Hex(), err)
+			}
+			continue
+		}
+		joined = append(joined, chatroomID)
+	}
+	return joined
+}
+
+// JoinChatroomByCode adds a user to a chatroom using room code and password.
+// membershipLimit works as in JoinChatroom.
+func (s *ChatroomService) JoinChatroomByCode(roomCode string, password string, userID uint, username string, membershipLimit int) (*models.Chatroom, error) {
+	// Reject malformed codes up front instead of reporting them as a missing room
+	code, err := NormalizeRoomCode(roomCode)
+	if err != nil {
+		return nil, err
+	}
+
 	// Find chatroom by room code
-	chatroom, err := s.GetChatroomByRoomCode(roomCode)
+	chatroom, err := s.GetChatroomByRoomCode(code)
 	if err != nil {
 		return nil, errors.New("room not found")
 	}
@@ -306,26 +743,168 @@ func (s *ChatroomService) JoinChatroomByCode(roomCode string, password string, u
 		}
 	}
 
-	// Add user to chatroom members
-	_, err = s.ChatColl.UpdateOne(
+	if err := s.reserveMembership(userID, membershipLimit); err != nil {
+		return nil, err
+	}
+
+	if err := s.addMember(chatroom.ID, userID, username); err != nil {
+		s.releaseMemberships(userID)
+		return nil, err
+	}
+
+	// Treat existing history as read for the new member (don't fail the join if this fails)
+	if err := s.markHistoryAsReadForNewMember(chatroom.ID, userID); err != nil {
+		log.Printf("Failed to mark chatroom %s history as read for new member %d: %v", chatroom.ID.Hex(), userID, err)
+	}
+
+	// Return updated chatroom
+	return s.GetChatroomByID(chatroom.ID)
+}
+
+// CountUserChatrooms returns how many chatrooms the user is a member of
+func (s *ChatroomService) CountUserChatrooms(userID uint) (int64, error) {
+	count, err := s.ChatColl.CountDocuments(context.Background(), bson.M{"members.user_id": userID})
+	if err != nil {
+		return 0, errors.New("failed to check chatroom membership")
+	}
+	return count, nil
+}
+
+// reserveMembership takes one of the user's membership slots before they are added to a chatroom, and fails
+// once they already belong to limit chatrooms (limit 0 means no cap). The slot is taken by a single $inc
+// on the user's counter that only matches below the cap, so concurrent joins can't overshoot it.
+// Uncapped joins are counted too, so the counter is still right if a cap applies later.
+func (s *ChatroomService) reserveMembership(userID uint, limit int) error {
+	ctx := context.Background()
+	filter := bson.M{"_id": userID}
+	if limit > 0 {
+		filter["count"] = bson.M{"$lt": limit}
+	}
+
+	reserve := func() (bool, error) {
+		result, err := s.MembershipCountColl.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"count": 1}})
+		if err != nil {
+			return false, errors.New("failed to check chatroom membership")
+		}
+		return result.MatchedCount == 1, nil
+	}
+
+	if reserved, err := reserve(); err != nil || reserved {
+		return err
+	}
+	// Either the user is at the cap or has no counter yet; seeding is a no-op for an existing counter
+	if err := s.seedMembershipCount(ctx, userID); err != nil {
+		return err
+	}
+	if reserved, err := reserve(); err != nil || reserved {
+		return err
+	}
+	return errors.New("chatroom membership limit reached")
+}
+
+// seedMembershipCount creates the user's counter from the chatrooms they already belong to, unless it exists
+func (s *ChatroomService) seedMembershipCount(ctx context.Context, userID uint) error {
+	count, err := s.CountUserChatrooms(userID)
+	if err != nil {
+		return err
+	}
+	_, err = s.MembershipCountColl.UpdateOne(ctx,
+		bson.M{"_id": userID},
+		bson.M{"$setOnInsert": bson.M{"count": count}},
+		options.Update().SetUpsert(true),
+	)
+	// A duplicate key means a concurrent join seeded it first
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return errors.New("failed to check chatroom membership")
+	}
+	return nil
+}
+
+// releaseMemberships gives back one membership slot for each user, after a leave, a deleted chatroom
+// or a join that didn't go through. It's best effort: a counter left too high only makes the cap stricter.
+func (s *ChatroomService) releaseMemberships(userIDs ...uint) {
+	if len(userIDs) == 0 {
+		return
+	}
+	_, err := s.MembershipCountColl.UpdateMany(context.Background(),
+		bson.M{"_id": bson.M{"$in": userIDs}, "count": bson.M{"$gt": 0}},
+		bson.M{"$inc": bson.M{"count": -1}},
+	)
+	if err != nil {
+		log.Printf("Failed to release chatroom memberships for users %v: %v", userIDs, err)
+	}
+}
+
+// addMember appends the user to the chatroom's members. The update only matches while the user isn't
+// a member yet, so two concurrent joins can't both push an entry: the loser gets the already-member error.
+func (s *ChatroomService) addMember(chatroomID primitive.ObjectID, userID uint, username string) error {
+	result, err := s.ChatColl.UpdateOne(
 		context.Background(),
-		bson.M{"_id": chatroom.ID},
+		bson.M{"_id": chatroomID, "members.user_id": bson.M{"$ne": userID}},
 		bson.M{
 			"$push": bson.M{
 				"members": models.ChatroomMember{
 					UserID:   userID,
 					Username: username,
+					Role:     models.ChatroomRoleMember,
 					JoinedAt: time.Now(),
 				},
 			},
 		},
 	)
 	if err != nil {
-		return nil, errors.New("failed to join chatroom")
+		return errors.New("failed to join chatroom")
+	}
+	if result.MatchedCount == 0 {
+		// Either someone else's join got there first or the chatroom was deleted in the meantime
+		if _, err := s.GetChatroomByID(chatroomID); err != nil {
+			return err
+		}
+		return errors.New("user is already a member of this chatroom")
 	}
+	return nil
+}
 
-	// Return updated chatroom
-	return s.GetChatroomByID(chatroom.ID)
+// markHistoryAsReadForNewMember points the user's last-read marker at the chatroom's latest message, so
+// first-unread lookups start from the join point instead of the start of the history. Unread counts
+// don't need this: they leave out messages sent before the user joined (see unreadRowsFilter and readHorizons).
+func (s *ChatroomService) markHistoryAsReadForNewMember(chatroomID primitive.ObjectID, userID uint) error {
+	ctx := context.Background()
+
+	var latest models.Message
+	opts := options.FindOne().SetProjection(bson.M{"_id": 1, "sent_at": 1}).SetSort(bson.D{{Key: "sent_at", Value: -1}, {Key: "_id", Value: -1}})
+	err := s.MongoDB.Collection("messages").FindOne(ctx, bson.M{"chatroom_id": chatroomID}, opts).Decode(&latest)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return errors.New("failed to get latest message")
+	}
+
+	// Point the user's last read marker at the latest existing message
+	now := time.Now()
+	_, err = s.MongoDB.Collection("user_last_read").UpdateOne(ctx,
+		bson.M{"chatroom_id": chatroomID, "user_id": userID},
+		bson.M{
+			"$set": bson.M{
+				"message_id": latest.ID,
+				"sent_at":    latest.SentAt,
+				"read_at":    now,
+				"updated_at": now,
+			},
+			"$setOnInsert": bson.M{
+				"_id":         primitive.NewObjectID(),
+				"chatroom_id": chatroomID,
+				"user_id":     userID,
+			},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return errors.New("failed to update user last read")
+	}
+
+	return nil
 }
 
 // LeaveChatroom removes a user from a chatroom
@@ -348,8 +927,13 @@ func (s *ChatroomService) LeaveChatroom(chatroomID primitive.ObjectID, userID ui
 		return errors.New("user is not a member of this chatroom")
 	}
 
+	// The creator is always an admin; they delete the room instead of leaving it
+	if chatroom.CreatedBy == userID {
+		return errors.New("the creator cannot leave this chatroom")
+	}
+
 	// Remove user from chatroom members
-	_, err = s.ChatColl.UpdateOne(
+	result, err := s.ChatColl.UpdateOne(
 		context.Background(),
 		bson.M{"_id": chatroomID},
 		bson.M{
@@ -361,6 +945,13 @@ func (s *ChatroomService) LeaveChatroom(chatroomID primitive.ObjectID, userID ui
 	if err != nil {
 		return errors.New("failed to leave chatroom")
 	}
+	// A concurrent leave may have pulled the member already; only one of them gives the slot back
+	if result.ModifiedCount == 1 {
+		s.releaseMemberships(userID)
+	}
+
+	// A room the user left shouldn't stay pinned in their sidebar
+	_ = s.UnpinChatroom(chatroomID, userID)
 
 	return nil
 }
@@ -375,6 +966,160 @@ func (s *ChatroomService) IsMember(chatroom *models.Chatroom, userID uint) bool
 	return false
 }
 
+// GetMembership returns the user's membership in a chatroom.
+// Only the creator and the user's own member entry are loaded, not the whole member list.
+func (s *ChatroomService) GetMembership(chatroomID primitive.ObjectID, userID uint) (*models.ChatroomMembership, error) {
+	opts := options.FindOne().SetProjection(bson.M{
+		"created_by": 1,
+		"members":    bson.M{"$elemMatch": bson.M{"user_id": userID}},
+	})
+
+	var chatroom models.Chatroom
+	err := s.ChatColl.FindOne(context.Background(), bson.M{"_id": chatroomID}, opts).Decode(&chatroom)
+	if err == mongo.ErrNoDocuments {
+		return nil, errors.New("chatroom not found")
+	}
+	if err != nil {
+		return nil, errors.New("failed to check chatroom membership")
+	}
+
+	if len(chatroom.Members) == 0 {
+		return &models.ChatroomMembership{IsMember: false}, nil
+	}
+
+	joinedAt := chatroom.Members[0].JoinedAt
+	return &models.ChatroomMembership{
+		IsMember: true,
+		Role:     s.GetMemberRole(&chatroom, userID),
+		JoinedAt: &joinedAt,
+	}, nil
+}
+
+// GetMemberRole returns the user's role in a chatroom, or an empty string if they aren't a member.
+// The creator is always an admin, and members stored before roles existed default to member.
+func (s *ChatroomService) GetMemberRole(chatroom *models.Chatroom, userID uint) string {
+	for _, member := range chatroom.Members {
+		if member.UserID != userID {
+			continue
+		}
+		if chatroom.CreatedBy == userID {
+			return models.ChatroomRoleAdmin
+		}
+		if member.Role == "" {
+			return models.ChatroomRoleMember
+		}
+		return member.Role
+	}
+	return ""
+}
+
+// GetAdminIDs returns the IDs of the chatroom's admins, the creator included
+func (s *ChatroomService) GetAdminIDs(chatroom *models.Chatroom) []uint {
+	var adminIDs []uint
+	for _, member := range chatroom.Members {
+		if s.GetMemberRole(chatroom, member.UserID) == models.ChatroomRoleAdmin {
+			adminIDs = append(adminIDs, member.UserID)
+		}
+	}
+	return adminIDs
+}
+
+// SetMemberRole changes a member's role in a chatroom (only creator can set roles)
+func (s *ChatroomService) SetMemberRole(chatroomID primitive.ObjectID, requesterID, targetUserID uint, role string) error {
+	if !models.IsValidChatroomRole(role) {
+		return errors.New("invalid chatroom role")
+	}
+
+	// Check if chatroom exists
+	chatroom, err := s.GetChatroomByID(chatroomID)
+	if err != nil {
+		return err
+	}
+
+	// Check if the requester is the creator of the chatroom
+	if chatroom.CreatedBy != requesterID {
+		return errors.New("only the creator can change member roles")
+	}
+
+	if targetUserID == chatroom.CreatedBy {
+		return errors.New("cannot change the creator's role")
+	}
+
+	if !s.IsMember(chatroom, targetUserID) {
+		return errors.New("user is not a member of this chatroom")
+	}
+
+	_, err = s.ChatColl.UpdateOne(
+		context.Background(),
+		bson.M{"_id": chatroomID, "members.user_id": targetUserID},
+		bson.M{"$set": bson.M{"members.$.role": role}},
+	)
+	if err != nil {
+		return errors.New("failed to update member role")
+	}
+
+	return nil
+}
+
+// PinChatroom pins a chatroom to the top of the user's sidebar (pinning again keeps the original pin time)
+func (s *ChatroomService) PinChatroom(chatroomID primitive.ObjectID, userID uint) (*models.PinnedChatroom, error) {
+	chatroom, err := s.GetChatroomByID(chatroomID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !s.IsMember(chatroom, userID) {
+		return nil, errors.New("user is not a member of this chatroom")
+	}
+
+	filter := bson.M{"user_id": userID, "chatroom_id": chatroomID}
+	update := bson.M{
+		"$setOnInsert": bson.M{
+			"_id":         primitive.NewObjectID(),
+			"user_id":     userID,
+			"chatroom_id": chatroomID,
+			"pinned_at":   time.Now(),
+		},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var pin models.PinnedChatroom
+	if err := s.PinnedColl.FindOneAndUpdate(context.Background(), filter, update, opts).Decode(&pin); err != nil {
+		return nil, errors.New("failed to pin chatroom")
+	}
+
+	return &pin, nil
+}
+
+// UnpinChatroom removes the user's pin from a chatroom (unpinning a room that isn't pinned is a no-op)
+func (s *ChatroomService) UnpinChatroom(chatroomID primitive.ObjectID, userID uint) error {
+	_, err := s.PinnedColl.DeleteOne(context.Background(), bson.M{"user_id": userID, "chatroom_id": chatroomID})
+	if err != nil {
+		return errors.New("failed to unpin chatroom")
+	}
+	return nil
+}
+
+// GetPinnedChatrooms returns when the user pinned each of their pinned chatrooms, keyed by chatroom ID
+func (s *ChatroomService) GetPinnedChatrooms(userID uint) (map[primitive.ObjectID]time.Time, error) {
+	cursor, err := s.PinnedColl.Find(context.Background(), bson.M{"user_id": userID})
+	if err != nil {
+		return nil, errors.New("failed to get pinned chatrooms")
+	}
+	defer cursor.Close(context.Background())
+
+	var pins []models.PinnedChatroom
+	if err := cursor.All(context.Background(), &pins); err != nil {
+		return nil, errors.New("failed to get pinned chatrooms")
+	}
+
+	pinnedAt := make(map[primitive.ObjectID]time.Time, len(pins))
+	for _, pin := range pins {
+		pinnedAt[pin.ChatroomID] = pin.PinnedAt
+	}
+	return pinnedAt, nil
+}
+
 // DeleteChatroom deletes a chatroom and all its messages (only creator can delete)
 func (s *ChatroomService) DeleteChatroom(chatroomID primitive.ObjectID, userID uint, messageService *MessageService) error {
 	// Check if chatroom exists
@@ -397,10 +1142,57 @@ func (s *ChatroomService) DeleteChatroom(chatroomID primitive.ObjectID, userID u
 	}
 
 	// Delete the chatroom
-	_, err = s.ChatColl.DeleteOne(context.Background(), bson.M{"_id": chatroomID})
+	result, err := s.ChatColl.DeleteOne(context.Background(), bson.M{"_id": chatroomID})
 	if err != nil {
 		return errors.New("failed to delete chatroom")
 	}
 
+	// Every member just lost a room, unless a concurrent delete already released them
+	if result.DeletedCount == 1 {
+		memberIDs := make([]uint, 0, len(chatroom.Members))
+		for _, member := range chatroom.Members {
+			memberIDs = append(memberIDs, member.UserID)
+		}
+		s.releaseMemberships(memberIDs...)
+	}
+
+	// Remove everyone's pins for the deleted room (best effort)
+	_, _ = s.PinnedColl.DeleteMany(context.Background(), bson.M{"chatroom_id": chatroomID})
+
+	return nil
+}
+
+// ClearChatroomMessages deletes all messages in a chatroom but keeps the room and its members (only creator can clear)
+func (s *ChatroomService) ClearChatroomMessages(chatroomID primitive.ObjectID, userID uint, messageService *MessageService) error {
+	// Check if chatroom exists
+	chatroom, err := s.GetChatroomByID(chatroomID)
+	if err != nil {
+		return err
+	}
+
+	// Check if the user is the creator of the chatroom
+	if chatroom.CreatedBy != userID {
+		return errors.New("only the creator can clear this chatroom")
+	}
+
+	// Delete all messages in the chatroom (including media)
+	if messageService != nil {
+		err = messageService.DeleteAllMessagesInChatroom(chatroomID)
+		if err != nil {
+			return errors.New("failed to delete chatroom messages")
+		}
+	}
+
+	// Delete read statuses and last read markers that pointed at the removed messages
+	_, err = s.MongoDB.Collection("message_read_status").DeleteMany(context.Background(), bson.M{"chatroom_id": chatroomID})
+	if err != nil {
+		return errors.New("failed to delete read statuses")
+	}
+
+	_, err = s.MongoDB.Collection("user_last_read").DeleteMany(context.Background(), bson.M{"chatroom_id": chatroomID})
+	if err != nil {
+		return errors.New("failed to delete read statuses")
+	}
+
 	return nil
 }
@@ -0,0 +1,309 @@
+package services
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestJoinTreatsHistoryAsRead(t *testing.T) {
+	for _, pointerTracking := range []bool{false, true} {
+		t.Run(fmt.Sprintf("pointer tracking %v", pointerTracking), func(t *testing.T) {
+			env := newTestEnv(t, pointerTracking)
+			alice, bob := env.createUser(t, "alice"), env.createUser(t, "bob")
+			room := env.createChatroom(t, "General", alice)
+			for i := 0; i < 5; i++ {
+				env.sendText(t, room, alice, fmt.Sprintf("before %d", i))
+			}
+
+			if _, err := env.Chatrooms.JoinChatroomByCode(room.RoomCode, "", bob.UserID, bob.Username, 0); err != nil {
+				t.Fatalf("JoinChatroomByCode: %v", err)
+			}
+			count, err := env.ReadStatus.GetUnreadCountForChatroom(room.ID, bob.UserID)
+			if err != nil {
+				t.Fatalf("GetUnreadCountForChatroom: %v", err)
+			}
+			if count != 0 {
+				t.Fatalf("unread right after joining = %d, want 0", count)
+			}
+
+			after := env.sendText(t, room, alice, "after 1")
+			env.sendText(t, room, alice, "after 2")
+			count, err = env.ReadStatus.GetUnreadCountForChatroom(room.ID, bob.UserID)
+			if err != nil {
+				t.Fatalf("GetUnreadCountForChatroom: %v", err)
+			}
+			if count != 2 {
+				t.Errorf("unread after two new messages = %d, want 2", count)
+			}
+
+			first, err := env.ReadStatus.GetFirstUnreadMessageInChatroom(room.ID, bob.UserID)
+			if err != nil || first == nil || first.ID != after.ID {
+				t.Errorf("first unread = %+v, %v; want \"after 1\"", first, err)
+			}
+
+			// History is left out of unread queries, not marked read row by row
+			if !pointerTracking {
+				if rows := len(env.MongoDB.Documents("message_read_status")); rows != 2 {
+					t.Errorf("got %d read status rows, want only the 2 for messages sent after joining", rows)
+				}
+			}
+		})
+	}
+}
+
+func TestRejoinKeepsReadStatuses(t *testing.T) {
+	env := newTestEnv(t, false)
+	alice, bob := env.createUser(t, "alice"), env.createUser(t, "bob")
+	room := env.createChatroom(t, "General", alice, bob)
+	read := env.sendText(t, room, alice, "one")
+	env.sendText(t, room, alice, "two")
+	if err := env.ReadStatus.MarkMessageAsRead(read.ID, bob.UserID); err != nil {
+		t.Fatalf("MarkMessageAsRead: %v", err)
+	}
+
+	if err := env.Chatrooms.LeaveChatroom(room.ID, bob.UserID); err != nil {
+		t.Fatalf("LeaveChatroom: %v", err)
+	}
+	time.Sleep(2 * time.Millisecond) // Times are stored to the millisecond; rejoin after the old rows were written
+	if err := env.Chatrooms.JoinChatroom(room.ID, bob.UserID, bob.Username, 0); err != nil {
+		t.Fatalf("JoinChatroom: %v", err)
+	}
+
+	if rows := len(env.MongoDB.Documents("message_read_status")); rows != 2 {
+		t.Errorf("got %d read status rows after rejoining, want 2 (no duplicates)", rows)
+	}
+	// "two" was never read, but its row is from before rejoining, and history counts as read
+	if count, _ := env.ReadStatus.GetUnreadCountForChatroom(room.ID, bob.UserID); count != 0 {
+		t.Errorf("unread after rejoining = %d, want 0", count)
+	}
+	if unread, _ := env.ReadStatus.GetUnreadMessagesInChatroom(room.ID, bob.UserID, false); len(unread) != 0 {
+		t.Errorf("unread messages after rejoining = %d, want none", len(unread))
+	}
+	counts, err := env.ReadStatus.GetUnreadCountForUser(bob.UserID)
+	if err != nil || len(counts) != 1 || counts[0].UnreadCount != 0 {
+		t.Errorf("unread counts after rejoining = %+v, %v; want 0", counts, err)
+	}
+	listed, err := env.Chatrooms.GetUserChatroomsSortedByLatestMessage(bob.UserID, ChatroomSortUnreadFirst)
+	if err != nil || len(listed) != 1 || listed[0].HasUnread {
+		t.Errorf("chatroom list after rejoining = %+v, %v; want the room without unread", listed, err)
+	}
+
+	newer := env.sendText(t, room, alice, "three")
+	if count, _ := env.ReadStatus.GetUnreadCountForChatroom(room.ID, bob.UserID); count != 1 {
+		t.Errorf("unread after a new message = %d, want 1", count)
+	}
+	if unread, _ := env.ReadStatus.GetUnreadMessagesInChatroom(room.ID, bob.UserID, false); len(unread) != 1 || unread[0].ID != newer.ID {
+		t.Errorf("unread messages after a new message = %+v, want only \"three\"", unread)
+	}
+}
+
+func TestConcurrentJoinsAddOneMember(t *testing.T) {
+	env := newTestEnv(t, false)
+	alice, bob := env.createUser(t, "alice"), env.createUser(t, "bob")
+
+	for round := 0; round < 20; round++ {
+		room := env.createChatroom(t, fmt.Sprintf("Room %d", round), alice)
+
+		errs := make(chan error, 2)
+		var start, done sync.WaitGroup
+		start.Add(1)
+		for range 2 {
+			done.Add(1)
+			go func() {
+				defer done.Done()
+				start.Wait()
+				errs <- env.Chatrooms.JoinChatroom(room.ID, bob.UserID, bob.Username, 0)
+			}()
+		}
+		start.Done()
+		done.Wait()
+		close(errs)
+
+		joined := 0
+		for err := range errs {
+			switch {
+			case err == nil:
+				joined++
+			case err.Error() != "user is already a member of this chatroom":
+				t.Fatalf("round %d: JoinChatroom: %v", round, err)
+			}
+		}
+		if joined != 1 {
+			t.Errorf("round %d: %d joins succeeded, want 1", round, joined)
+		}
+		assertMemberOnce(t, env, room.ID, bob.UserID)
+	}
+
+	// A join whose membership check read the chatroom before the other join landed still can't add a second entry
+	room := env.createChatroom(t, "Stale check", alice, bob)
+	if err := env.Chatrooms.addMember(room.ID, bob.UserID, bob.Username); err == nil || err.Error() != "user is already a member of this chatroom" {
+		t.Errorf("addMember for an existing member: err = %v, want already a member", err)
+	}
+	assertMemberOnce(t, env, room.ID, bob.UserID)
+}
+
+func TestConcurrentJoinsRespectMembershipLimit(t *testing.T) {
+	const limit = 3
+	env := newTestEnv(t, false)
+	alice, bob := env.createUser(t, "alice"), env.createUser(t, "bob")
+	// Bob's first room predates his membership counter, which starts from it
+	first := env.createChatroom(t, "Existing", alice, bob)
+
+	var rooms []primitive.ObjectID
+	for i := 0; i < 10; i++ {
+		rooms = append(rooms, env.createChatroom(t, fmt.Sprintf("Room %d", i), alice).ID)
+	}
+
+	errs := make(chan error, len(rooms))
+	var start, done sync.WaitGroup
+	start.Add(1)
+	for _, roomID := range rooms {
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			start.Wait()
+			errs <- env.Chatrooms.JoinChatroom(roomID, bob.UserID, bob.Username, limit)
+		}()
+	}
+	start.Done()
+	done.Wait()
+	close(errs)
+
+	joined := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			joined++
+		case err.Error() != "chatroom membership limit reached":
+			t.Fatalf("JoinChatroom: %v", err)
+		}
+	}
+	if joined != limit-1 {
+		t.Errorf("%d concurrent joins succeeded, want %d", joined, limit-1)
+	}
+	assertChatroomCount := func(want int64) {
+		t.Helper()
+		if count, err := env.Chatrooms.CountUserChatrooms(bob.UserID); err != nil || count != want {
+			t.Errorf("bob is in %d chatrooms (%v), want %d", count, err, want)
+		}
+	}
+	assertChatroomCount(limit)
+
+	// Leaving and deleted rooms give their slots back
+	if err := env.Chatrooms.LeaveChatroom(first.ID, bob.UserID); err != nil {
+		t.Fatalf("LeaveChatroom: %v", err)
+	}
+	var joinedRooms []primitive.ObjectID
+	for _, roomID := range rooms {
+		if err := env.Chatrooms.JoinChatroom(roomID, bob.UserID, bob.Username, limit); err == nil {
+			joinedRooms = append(joinedRooms, roomID)
+		}
+	}
+	if len(joinedRooms) != 1 {
+		t.Fatalf("joined %d rooms after leaving one, want 1", len(joinedRooms))
+	}
+	if err := env.Chatrooms.DeleteChatroom(joinedRooms[0], alice.UserID, nil); err != nil {
+		t.Fatalf("DeleteChatroom: %v", err)
+	}
+	if _, err := env.Chatrooms.CreateChatroom("Bob's room", "", "", bob.UserID, bob.Username, "", limit); err != nil {
+		t.Errorf("CreateChatroom after a room was deleted: %v", err)
+	}
+	if _, err := env.Chatrooms.CreateChatroom("Another", "", "", bob.UserID, bob.Username, "", limit); err == nil || err.Error() != "chatroom membership limit reached" {
+		t.Errorf("CreateChatroom at the cap: err = %v, want limit reached", err)
+	}
+	assertChatroomCount(limit)
+}
+
+// assertMemberOnce fails the test unless the user appears exactly once in the chatroom's members
+func assertMemberOnce(t *testing.T, env *testEnv, chatroomID primitive.ObjectID, userID uint) {
+	t.Helper()
+	chatroom, err := env.Chatrooms.GetChatroomByID(chatroomID)
+	if err != nil {
+		t.Fatalf("GetChatroomByID: %v", err)
+	}
+	entries := 0
+	for _, member := range chatroom.Members {
+		if member.UserID == userID {
+			entries++
+		}
+	}
+	if entries != 1 {
+		t.Errorf("user %d has %d member entries in %s, want 1", userID, entries, chatroom.Name)
+	}
+}
+
+func TestSearchChatrooms(t *testing.T) {
+	env := newTestEnv(t, false)
+	alice, bob := env.createUser(t, "alice"), env.createUser(t, "bob")
+	for _, name := range []string{"Rust", "golang", "Go Gophers", "C++ fans", "go.dev"} {
+		env.createChatroom(t, name, bob)
+	}
+	env.createChatroom(t, "Golf", bob, alice)
+
+	search := func(query ChatroomSearchQuery) ([]string, int64) {
+		t.Helper()
+		chatrooms, total, err := env.Chatrooms.SearchChatrooms(query)
+		if err != nil {
+			t.Fatalf("SearchChatrooms(%+v): %v", query, err)
+		}
+		names := make([]string, len(chatrooms))
+		for i, chatroom := range chatrooms {
+			names[i] = chatroom.Name
+		}
+		return names, total
+	}
+
+	for _, tc := range []struct {
+		query ChatroomSearchQuery
+		want  []string
+		total int64
+	}{
+		{ChatroomSearchQuery{Name: "GO"}, []string{"Go Gophers", "Golf", "go.dev", "golang"}, 4},
+		{ChatroomSearchQuery{Name: "lang"}, []string{"golang"}, 1},
+		{ChatroomSearchQuery{Name: "go."}, []string{"go.dev"}, 1},   // The dot is literal, not any character
+		{ChatroomSearchQuery{Name: "c++"}, []string{"C++ fans"}, 1}, // As are other regex characters
+		{ChatroomSearchQuery{Name: "python"}, []string{}, 0},
+		{ChatroomSearchQuery{Name: "go", ExcludeMember: alice.UserID}, []string{"Go Gophers", "go.dev", "golang"}, 3},
+		{ChatroomSearchQuery{ExcludeMember: bob.UserID}, []string{}, 0},
+		{ChatroomSearchQuery{Name: "go", Limit: 2}, []string{"Go Gophers", "Golf"}, 4},
+		{ChatroomSearchQuery{Name: "go", Limit: 2, Offset: 2}, []string{"go.dev", "golang"}, 4},
+		{ChatroomSearchQuery{Name: "go", Limit: 2, Offset: 4}, []string{}, 4},
+		{ChatroomSearchQuery{}, []string{"C++ fans", "Go Gophers", "Golf", "Rust", "go.dev", "golang"}, 6},
+	} {
+		if got, total := search(tc.query); !slices.Equal(got, tc.want) || total != tc.total {
+			t.Errorf("SearchChatrooms(%+v) = %v of %d, want %v of %d", tc.query, got, total, tc.want, tc.total)
+		}
+	}
+
+	if _, _, err := env.Chatrooms.SearchChatrooms(ChatroomSearchQuery{Name: strings.Repeat("a", 101)}); err == nil || err.Error() != "chatroom search is too long" {
+		t.Errorf("long search: err = %v", err)
+	}
+}
+
+func TestNormalizeRoomCode(t *testing.T) {
+	for input, want := range map[string]string{
+		"ABC123":     "ABC123",
+		"abc123":     "ABC123",
+		"  aBc123\n": "ABC123",
+		"ABC12":      "",
+		"ABC1234":    "",
+		"ABC 12":     "",
+		"ABC_12":     "",
+		"":           "",
+	} {
+		got, err := NormalizeRoomCode(input)
+		if want == "" {
+			if err == nil || err.Error() != "invalid room code format" {
+				t.Errorf("NormalizeRoomCode(%q) = %q, %v; want invalid room code format", input, got, err)
+			}
+		} else if err != nil || got != want {
+			t.Errorf("NormalizeRoomCode(%q) = %q, %v; want %q", input, got, err, want)
+		}
+	}
+}
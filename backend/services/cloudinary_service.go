@@ -3,11 +3,15 @@ package services
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io"
 	"mime/multipart"
+	"net/http"
 	"net/url"
-	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/cloudinary/cloudinary-go/v2"
 	"github.com/cloudinary/cloudinary-go/v2/api/uploader"
@@ -16,18 +20,19 @@ import (
 
 // CloudinaryService handles media file operations with Cloudinary
 type CloudinaryService struct {
-	Cld *cloudinary.Cloudinary
-}
+	Cld       *cloudinary.Cloudinary
+	CloudName string
 
-// NewCloudinaryService creates a new CloudinaryService
-func NewCloudinaryService() (*CloudinaryService, error) {
-	// Get Cloudinary credentials from environment variables
-	cloudName := os.Getenv("CLOUDINARY_CLOUD_NAME")
-	apiKey := os.Getenv("CLOUDINARY_API_KEY")
-	apiSecret := os.Getenv("CLOUDINARY_API_SECRET")
+	maxUploadSize      int64
+	uploadSlots        chan struct{} // One per in-flight upload, shared by everything holding this service
+	uploadQueueTimeout time.Duration
+}
 
+// NewCloudinaryService creates a new CloudinaryService from the CLOUDINARY_* and MEDIA_* settings.
+// Files over chunkSize are uploaded in chunks, and at most uploadConcurrency uploads run at once.
+func NewCloudinaryService(cloudName, apiKey, apiSecret string, maxUploadSize, chunkSize int64, uploadConcurrency int, uploadQueueTimeout time.Duration) (*CloudinaryService, error) {
 	if cloudName == "" || apiKey == "" || apiSecret == "" {
-		return nil, errors.New("missing Cloudinary credentials in environment variables")
+		return nil, errors.New("missing Cloudinary credentials in configuration")
 	}
 
 	// Create a new Cloudinary instance
@@ -36,8 +41,16 @@ func NewCloudinaryService() (*CloudinaryService, error) {
 		return nil, err
 	}
 
+	// Upload sends files larger than ChunkSize in chunks, since a single request for them (mostly videos)
+	// tends to time out. The uploader keeps its own copy of the configuration, so it is set there.
+	cld.Upload.Config.API.ChunkSize = chunkSize
+
 	return &CloudinaryService{
-		Cld: cld,
+		Cld:                cld,
+		CloudName:          cloudName,
+		maxUploadSize:      maxUploadSize,
+		uploadSlots:        make(chan struct{}, uploadConcurrency),
+		uploadQueueTimeout: uploadQueueTimeout,
 	}, nil
 }
 
@@ -55,11 +68,26 @@ func (s *CloudinaryService) GetCloudinaryFolder(mediaType utils.MediaType) strin
 	}
 }
 
+// acquireUploadSlot waits for one of the MEDIA_UPLOAD_CONCURRENCY upload slots so a burst of uploads can't
+// exceed Cloudinary's rate limits. It gives up after MEDIA_UPLOAD_QUEUE_TIMEOUT; call release when the upload ends.
+func (s *CloudinaryService) acquireUploadSlot() (release func(), err error) {
+	slots := s.uploadSlots
+	timer := time.NewTimer(s.uploadQueueTimeout)
+	defer timer.Stop()
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-timer.C:
+		return nil, errors.New("upload queue is full")
+	}
+}
+
 // UploadFile uploads a file to Cloudinary and returns the URL
 func (s *CloudinaryService) UploadFile(file *multipart.FileHeader, mediaType utils.MediaType) (string, error) {
-	// Validate file size (10MB max)
-	if file.Size > 10*1024*1024 {
-		return "", errors.New("file size exceeds the 10MB limit")
+	// Validate file size (MEDIA_MAX_UPLOAD_MB)
+	if file.Size > s.maxUploadSize {
+		return "", errors.New("file size exceeds the upload limit")
 	}
 
 	// Get the file extension
@@ -76,12 +104,12 @@ func (s *CloudinaryService) UploadFile(file *multipart.FileHeader, mediaType uti
 		return "", err
 	}
 
-	// Open the source file
-	src, err := file.Open()
+	// Wait for an upload slot; validation above doesn't need one
+	release, err := s.acquireUploadSlot()
 	if err != nil {
 		return "", err
 	}
-	defer src.Close()
+	defer release()
 
 	// Get the folder path
 	folder := s.GetCloudinaryFolder(mediaType)
@@ -99,8 +127,15 @@ func (s *CloudinaryService) UploadFile(file *multipart.FileHeader, mediaType uti
 		UniqueFilename: &uniqueFilename,
 	}
 
-	// Upload the file
-	uploadResult, err := s.Cld.Upload.Upload(context.Background(), src, uploadParams)
+	// Images go through an incoming transformation so the stored original is re-encoded
+	// without EXIF metadata (e.g. GPS location); a_exif applies the orientation first
+	if mediaType == utils.ImageMedia {
+		uploadParams.Transformation = "a_exif/fl_strip_profile"
+	}
+
+	// Passing the file header (not an opened reader) lets the uploader see the size and switch
+	// to a chunked upload above the configured threshold
+	uploadResult, err := s.Cld.Upload.Upload(context.Background(), file, uploadParams)
 	if err != nil {
 		return "", err
 	}
@@ -108,6 +143,80 @@ func (s *CloudinaryService) UploadFile(file *multipart.FileHeader, mediaType uti
 	return uploadResult.SecureURL, nil
 }
 
+// IsOwnedAssetURL reports whether mediaURL points at an asset in this app's Cloudinary cloud.
+// Only https://res.cloudinary.com/<cloud name>/... is accepted so the proxy can't be used to reach other hosts.
+func (s *CloudinaryService) IsOwnedAssetURL(mediaURL string) bool {
+	parsedURL, err := url.Parse(mediaURL)
+	if err != nil {
+		return false
+	}
+
+	if parsedURL.Scheme != "https" || parsedURL.User != nil || parsedURL.Port() != "" {
+		return false
+	}
+
+	if !strings.EqualFold(parsedURL.Hostname(), "res.cloudinary.com") {
+		return false
+	}
+
+	// Dot segments, literal or percent-encoded, would climb out of the cloud's folder once the path is
+	// normalised (/demo/../other/...), so only paths that are already clean are accepted
+	if strings.Contains(strings.ToLower(parsedURL.EscapedPath()), "%2e") || path.Clean(parsedURL.Path) != parsedURL.Path {
+		return false
+	}
+
+	return strings.HasPrefix(parsedURL.Path, "/"+s.CloudName+"/")
+}
+
+// mediaFetchClient fetches Cloudinary assets for the media proxy (redirects are not followed)
+var mediaFetchClient = &http.Client{
+	Timeout: 60 * time.Second,
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// ErrMediaNotFound is returned by FetchFile when Cloudinary has no such asset
+var ErrMediaNotFound = errors.New("media not found")
+
+// FetchFile opens an owned Cloudinary asset for streaming. The caller must close the returned response body.
+func (s *CloudinaryService) FetchFile(mediaURL string) (*http.Response, error) {
+	if !s.IsOwnedAssetURL(mediaURL) {
+		return nil, errors.New("invalid media URL")
+	}
+
+	resp, err := mediaFetchClient.Get(mediaURL)
+	if err != nil {
+		return nil, errors.New("failed to fetch media")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, ErrMediaNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch media: status %d", resp.StatusCode)
+	}
+
+	return resp, nil
+}
+
+// AssetExists reports whether an owned Cloudinary asset can currently be fetched
+func (s *CloudinaryService) AssetExists(mediaURL string) bool {
+	if !s.IsOwnedAssetURL(mediaURL) {
+		return false
+	}
+
+	resp, err := mediaFetchClient.Head(mediaURL)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode == http.StatusOK
+}
+
 // DeleteFile deletes a file from Cloudinary using its URL
 func (s *CloudinaryService) DeleteFile(mediaURL string) error {
 	if mediaURL == "" {
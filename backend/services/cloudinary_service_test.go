@@ -0,0 +1,226 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ginchat/config"
+	"github.com/ginchat/utils"
+)
+
+// uploadStub stands in for the Cloudinary upload API, recording the Content-Range of each request
+// (the uploader only sets it on chunked uploads) and how many requests were in flight at once
+type uploadStub struct {
+	mu          sync.Mutex
+	ranges      []string
+	inFlight    int
+	maxInFlight int
+	gate        chan struct{} // When set, each request waits to receive from it before answering
+}
+
+func (u *uploadStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u.mu.Lock()
+	u.ranges = append(u.ranges, r.Header.Get("Content-Range"))
+	u.inFlight++
+	u.maxInFlight = max(u.maxInFlight, u.inFlight)
+	u.mu.Unlock()
+
+	if u.gate != nil {
+		<-u.gate
+	}
+	u.mu.Lock()
+	u.inFlight--
+	u.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"secure_url": "https://res.cloudinary.com/demo/video/upload/v1/ginchat/video/test.mp4"}`))
+}
+
+// newStubbedCloudinaryService returns a CloudinaryService that chunks uploads over chunkSize and sends them to stub
+func newStubbedCloudinaryService(t *testing.T, stub *uploadStub, chunkSize int64) *CloudinaryService {
+	t.Helper()
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+
+	service, err := NewCloudinaryService("demo", "key", "secret", config.DefaultMaxUploadSize, chunkSize,
+		config.DefaultMediaUploadConcurrency, config.DefaultMediaUploadQueueTimeout)
+	if err != nil {
+		t.Fatalf("NewCloudinaryService: %v", err)
+	}
+	service.Cld.Upload.Config.API.UploadPrefix = server.URL
+	return service
+}
+
+// fileHeader builds a multipart file header holding size bytes, as gin would hand to UploadFile
+func fileHeader(t *testing.T, name string, size int) *multipart.FileHeader {
+	t.Helper()
+	return fileHeaderWith(t, name, bytes.Repeat([]byte{'x'}, size))
+}
+
+// fileHeaderWith builds a multipart file header holding content
+func fileHeaderWith(t *testing.T, name string, content []byte) *multipart.FileHeader {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(content)
+	writer.Close()
+
+	form, err := multipart.NewReader(&body, writer.Boundary()).ReadForm(int64(len(content)) * 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { form.RemoveAll() })
+	return form.File["file"][0]
+}
+
+func TestUploadFileChunksLargeFiles(t *testing.T) {
+	const chunkSize = 1024
+
+	t.Run("large file is sent in chunks", func(t *testing.T) {
+		stub := &uploadStub{}
+		service := newStubbedCloudinaryService(t, stub, chunkSize)
+
+		url, err := service.UploadFile(fileHeader(t, "clip.mp4", 2500), utils.VideoMedia)
+		if err != nil {
+			t.Fatalf("UploadFile: %v", err)
+		}
+		if url == "" {
+			t.Error("expected the uploaded URL")
+		}
+
+		want := []string{"bytes 0-1023/2500", "bytes 1024-2047/2500", "bytes 2048-2499/2500"}
+		if len(stub.ranges) != len(want) {
+			t.Fatalf("got %d upload requests %v, want %d chunks", len(stub.ranges), stub.ranges, len(want))
+		}
+		for i, contentRange := range want {
+			if stub.ranges[i] != contentRange {
+				t.Errorf("chunk %d: got Content-Range %q, want %q", i, stub.ranges[i], contentRange)
+			}
+		}
+	})
+
+	t.Run("small file is sent in one request", func(t *testing.T) {
+		stub := &uploadStub{}
+		service := newStubbedCloudinaryService(t, stub, chunkSize)
+
+		if _, err := service.UploadFile(fileHeader(t, "clip.mp4", 512), utils.VideoMedia); err != nil {
+			t.Fatalf("UploadFile: %v", err)
+		}
+		if len(stub.ranges) != 1 || stub.ranges[0] != "" {
+			t.Errorf("got upload requests with Content-Range %v, want a single unchunked request", stub.ranges)
+		}
+	})
+}
+
+func TestIsOwnedAssetURL(t *testing.T) {
+	service, err := NewCloudinaryService("demo", "key", "secret", config.DefaultMaxUploadSize, config.DefaultChunkedUploadThreshold,
+		config.DefaultMediaUploadConcurrency, config.DefaultMediaUploadQueueTimeout)
+	if err != nil {
+		t.Fatalf("NewCloudinaryService: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		url   string
+		owned bool
+	}{
+		{"asset in this cloud", "https://res.cloudinary.com/demo/image/upload/v1/ginchat/picture/cat.jpg", true},
+		{"host is case-insensitive", "https://RES.Cloudinary.com/demo/video/upload/v1/ginchat/video/clip.mp4", true},
+		{"external host", "https://evil.example.com/demo/image/upload/cat.jpg", false},
+		{"another cloud name", "https://res.cloudinary.com/other/image/upload/v1/cat.jpg", false},
+		{"cloud name prefix", "https://res.cloudinary.com/demo2/image/upload/v1/cat.jpg", false},
+		{"plain http", "http://res.cloudinary.com/demo/image/upload/v1/cat.jpg", false},
+		{"credentials in the URL", "https://user@res.cloudinary.com/demo/image/upload/v1/cat.jpg", false},
+		{"explicit port", "https://res.cloudinary.com:8443/demo/image/upload/v1/cat.jpg", false},
+		{"lookalike host", "https://res.cloudinary.com.evil.example.com/demo/image/upload/cat.jpg", false},
+		{"dot segments out of the cloud", "https://res.cloudinary.com/demo/../other/image/upload/v1/cat.jpg", false},
+		{"dot segment within the cloud", "https://res.cloudinary.com/demo/image/./upload/v1/cat.jpg", false},
+		{"percent-encoded dot segments", "https://res.cloudinary.com/demo/%2e%2e/other/image/upload/v1/cat.jpg", false},
+		{"upper-case percent-encoded dots", "https://res.cloudinary.com/demo/%2E%2E/other/image/upload/v1/cat.jpg", false},
+		{"percent-encoded slash", "https://res.cloudinary.com/demo/..%2fother/image/upload/v1/cat.jpg", false},
+		{"empty segment", "https://res.cloudinary.com/demo//image/upload/v1/cat.jpg", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := service.IsOwnedAssetURL(tc.url); got != tc.owned {
+				t.Errorf("IsOwnedAssetURL(%q) = %v, want %v", tc.url, got, tc.owned)
+			}
+		})
+	}
+}
+
+func TestUploadConcurrencyLimit(t *testing.T) {
+	t.Run("uploads beyond the limit wait their turn", func(t *testing.T) {
+		stub := &uploadStub{gate: make(chan struct{})}
+		service := newStubbedCloudinaryService(t, stub, config.DefaultChunkedUploadThreshold)
+		service.uploadSlots = make(chan struct{}, 2)
+		service.uploadQueueTimeout = 5 * time.Second
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 5)
+		for i := range 5 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := service.UploadFile(fileHeader(t, fmt.Sprintf("%d.jpg", i), 16), utils.ImageMedia)
+				errs <- err
+			}()
+		}
+		// Let the uploads through one at a time, giving queued ones the chance to pile in
+		for range 5 {
+			time.Sleep(20 * time.Millisecond)
+			stub.gate <- struct{}{}
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Errorf("UploadFile: %v", err)
+			}
+		}
+		if stub.maxInFlight != 2 {
+			t.Errorf("%d uploads ran at once, want the limit of 2", stub.maxInFlight)
+		}
+	})
+
+	t.Run("a saturated queue times out", func(t *testing.T) {
+		stub := &uploadStub{gate: make(chan struct{})}
+		service := newStubbedCloudinaryService(t, stub, config.DefaultChunkedUploadThreshold)
+		service.uploadSlots = make(chan struct{}, 1)
+		service.uploadQueueTimeout = 50 * time.Millisecond
+
+		first := make(chan error, 1)
+		go func() {
+			_, err := service.UploadFile(fileHeader(t, "first.jpg", 16), utils.ImageMedia)
+			first <- err
+		}()
+		time.Sleep(20 * time.Millisecond) // The first upload holds the only slot
+
+		_, err := service.UploadFile(fileHeader(t, "second.jpg", 16), utils.ImageMedia)
+		if err == nil || err.Error() != "upload queue is full" {
+			t.Fatalf("err = %v, want upload queue is full", err)
+		}
+		if apiErr := utils.ServiceAPIError(err); apiErr.Status != http.StatusServiceUnavailable || apiErr.Code != "UPLOAD_BUSY" {
+			t.Errorf("API error = %d %s, want 503 UPLOAD_BUSY", apiErr.Status, apiErr.Code)
+		}
+
+		stub.gate <- struct{}{}
+		if err := <-first; err != nil {
+			t.Fatalf("first upload: %v", err)
+		}
+		// The freed slot is available again
+		go func() { stub.gate <- struct{}{} }()
+		if _, err := service.UploadFile(fileHeader(t, "third.jpg", 16), utils.ImageMedia); err != nil {
+			t.Errorf("upload after the slot was freed: %v", err)
+		}
+	})
+}
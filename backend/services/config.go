@@ -0,0 +1,48 @@
+package services
+
+import (
+	"log"
+)
+
+// Startup builders for the optional pieces SetupRoutes shares between services. Each returns nil when
+// its feature is off, which the services treat as disabled.
+
+// NewConfiguredFCMSender returns the FCM sender for FCM_CREDENTIALS_FILE, or nil when it isn't set or can't be loaded
+func NewConfiguredFCMSender(credentialsFile string) *FCMSender {
+	if credentialsFile == "" {
+		return nil
+	}
+	sender, err := NewFCMSender(credentialsFile)
+	if err != nil {
+		log.Printf("FCM push disabled: %v", err)
+		return nil
+	}
+	return sender
+}
+
+// NewConfiguredEncryption returns the at-rest text encryption for MESSAGE_ENCRYPTION_KEY, or nil when no key is set.
+// An invalid key stops startup rather than silently storing plaintext.
+func NewConfiguredEncryption(key []byte) *EncryptionService {
+	if len(key) == 0 {
+		return nil
+	}
+	encryption, err := NewEncryptionService(key)
+	if err != nil {
+		log.Fatalf("Message encryption: %v", err)
+	}
+	return encryption
+}
+
+// NewConfiguredMessageFilter returns the banned-word filter built from MESSAGE_FILTER_WORDS_FILE.
+// Nil (no filtering) when the file isn't set, can't be read, or MESSAGE_FILTER_ENABLED is false.
+func NewConfiguredMessageFilter(enabled bool, wordsFile string) *MessageFilter {
+	if !enabled || wordsFile == "" {
+		return nil
+	}
+	filter, err := LoadMessageFilter(wordsFile)
+	if err != nil {
+		log.Printf("Message filter disabled: failed to load word list %s: %v", wordsFile, err)
+		return nil
+	}
+	return filter
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+
+	"github.com/ginchat/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/bsonrw"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// encryptedTextPrefix versions the ciphertext format. Whether a document's text is encrypted is recorded
+// in its text_encrypted field, never guessed from the text, so plaintext that happens to start with the
+// prefix is still read as plaintext.
+const encryptedTextPrefix = "enc:v1:"
+
+// EncryptionService encrypts message text at rest with AES-GCM
+type EncryptionService struct {
+	aead cipher.AEAD
+}
+
+// NewEncryptionService creates an EncryptionService from a 16, 24 or 32 byte key (AES-128/192/256)
+func NewEncryptionService(key []byte) (*EncryptionService, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up AES-GCM: %w", err)
+	}
+	return &EncryptionService{aead: aead}, nil
+}
+
+// Encrypt returns the stored form of plaintext: the prefix followed by base64(nonce || ciphertext).
+// Empty text stays empty so "no text" is still visible to queries.
+func (e *EncryptionService) Encrypt(plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	nonce := make([]byte, e.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", errors.New("failed to encrypt message")
+	}
+	sealed := e.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return encryptedTextPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptText returns the plaintext of a value returned by Encrypt
+func (e *EncryptionService) DecryptText(stored string) (string, error) {
+	encoded, ok := strings.CutPrefix(stored, encryptedTextPrefix)
+	if !ok {
+		return "", errors.New("malformed encrypted text")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < e.aead.NonceSize() {
+		return "", errors.New("malformed encrypted text")
+	}
+	nonce, ciphertext := sealed[:e.aead.NonceSize()], sealed[e.aead.NonceSize():]
+	plaintext, err := e.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", errors.New("failed to decrypt text")
+	}
+	return string(plaintext), nil
+}
+
+// encryptStoredText returns text as it should be written to the messages collection and whether that is
+// ciphertext: encrypted when at-rest encryption is configured, unchanged otherwise
+func (s *MessageService) encryptStoredText(text string) (stored string, encrypted bool, err error) {
+	if s.Encryption == nil || text == "" {
+		return text, false, nil
+	}
+	stored, err = s.Encryption.Encrypt(text)
+	return stored, err == nil, err
+}
+
+// decryptStoredText returns the plaintext of text stored encrypted, or UndecryptableText when it can't be
+// decrypted (no key configured, or a different key)
+func decryptStoredText(encryption *EncryptionService, stored string) (string, error) {
+	if encryption == nil {
+		return models.UndecryptableText, errors.New("message encryption is not configured")
+	}
+	text, err := encryption.DecryptText(stored)
+	if err != nil {
+		return models.UndecryptableText, err
+	}
+	return text, nil
+}
+
+// messageDecoder decodes Message documents and decrypts the text of those stored encrypted
+type messageDecoder struct {
+	encryption *EncryptionService // nil when encryption isn't configured
+}
+
+var messageType = reflect.TypeOf(models.Message{})
+
+// DecodeValue implements bsoncodec.ValueDecoder
+func (d messageDecoder) DecodeValue(_ bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+	if !val.CanSet() || val.Type() != messageType {
+		return bsoncodec.ValueDecoderError{Name: "messageDecoder", Types: []reflect.Type{messageType}, Received: val}
+	}
+	if vr.Type() == bsontype.Null {
+		val.Set(reflect.Zero(messageType))
+		return vr.ReadNull()
+	}
+
+	raw, err := bsonrw.Copier{}.CopyDocumentToBytes(vr)
+	if err != nil {
+		return err
+	}
+	var message models.Message
+	if err := bson.Unmarshal(raw, &message); err != nil {
+		return err
+	}
+	if message.TextEncrypted {
+		text, err := decryptStoredText(d.encryption, message.TextContent)
+		if err != nil {
+			log.Printf("Failed to decrypt message %s: %v", message.ID.Hex(), err)
+		}
+		message.TextContent = text
+	}
+	val.Set(reflect.ValueOf(message))
+	return nil
+}
+
+// WithMessageDecryption returns db set up to decrypt message text as Message documents are decoded, so every
+// read path (finds, aggregations, embedded latest messages) sees plaintext whether or not a document was
+// stored encrypted. With nil encryption, encrypted text reads as UndecryptableText.
+func WithMessageDecryption(db *mongo.Database, encryption *EncryptionService) *mongo.Database {
+	registry := bson.NewRegistry()
+	registry.RegisterTypeDecoder(messageType, messageDecoder{encryption: encryption})
+	return db.Client().Database(db.Name(), options.Database().SetRegistry(registry))
+}
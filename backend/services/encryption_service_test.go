@@ -0,0 +1,176 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ginchat/config"
+	"github.com/ginchat/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func newTestEncryption(t *testing.T, keyByte byte) *EncryptionService {
+	t.Helper()
+	encryption, err := NewEncryptionService(bytes.Repeat([]byte{keyByte}, 32))
+	if err != nil {
+		t.Fatalf("NewEncryptionService: %v", err)
+	}
+	return encryption
+}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	encryption := newTestEncryption(t, 1)
+
+	for _, text := range []string{"hello", "enc:v1:looks encrypted but isn't", strings.Repeat("long ", 1000), "emoji 🎉"} {
+		stored, err := encryption.Encrypt(text)
+		if err != nil {
+			t.Fatalf("Encrypt(%q): %v", text, err)
+		}
+		if !strings.HasPrefix(stored, encryptedTextPrefix) || strings.Contains(stored, text) {
+			t.Errorf("Encrypt(%q) = %q, want prefixed ciphertext", text, stored)
+		}
+		if again, _ := encryption.Encrypt(text); again == stored {
+			t.Errorf("encrypting %q twice gave the same ciphertext; nonces must differ", text)
+		}
+		if got, err := encryption.DecryptText(stored); err != nil || got != text {
+			t.Errorf("DecryptText(Encrypt(%q)) = %q, %v", text, got, err)
+		}
+	}
+
+	if stored, err := encryption.Encrypt(""); err != nil || stored != "" {
+		t.Errorf("Encrypt(\"\") = %q, %v; want empty text left empty", stored, err)
+	}
+	if _, err := NewEncryptionService([]byte("short")); err == nil {
+		t.Error("NewEncryptionService accepted a 5 byte key")
+	}
+}
+
+func TestDecryptRejectsWrongKeyAndTampering(t *testing.T) {
+	encryption := newTestEncryption(t, 1)
+	stored, err := encryption.Encrypt("secret")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+
+	if _, err := newTestEncryption(t, 2).DecryptText(stored); err == nil {
+		t.Error("decrypting with the wrong key succeeded")
+	}
+	tampered := stored[:len(stored)-2] + "AA"
+	if tampered == stored {
+		tampered = stored[:len(stored)-2] + "BB"
+	}
+	for _, bad := range []string{tampered, "plain text", encryptedTextPrefix + "not base64!", encryptedTextPrefix} {
+		if _, err := encryption.DecryptText(bad); err == nil {
+			t.Errorf("DecryptText(%q) succeeded", bad)
+		}
+	}
+}
+
+// encryptedTestEnv is a testEnv whose messages are encrypted with encryption and read through the decrypting database
+func encryptedTestEnv(t *testing.T, encryption *EncryptionService) *testEnv {
+	t.Helper()
+	env := newTestEnv(t, false)
+	env.Mongo = WithMessageDecryption(env.Mongo, encryption)
+	env.Chatrooms = NewChatroomService(env.Mongo, false, nil)
+	env.ReadStatus = NewMessageReadStatusService(env.Mongo, env.Chatrooms, env.Users, false, config.DefaultReadStatusReconcileBatch)
+	env.Messages = NewMessageService(env.Mongo, env.Chatrooms, nil, env.ReadStatus, nil, encryption, 100, config.DefaultMessageEditWindow)
+	return env
+}
+
+func TestMessagesEncryptedAtRest(t *testing.T) {
+	encryption := newTestEncryption(t, 1)
+	env := encryptedTestEnv(t, encryption)
+	alice, bob := env.createUser(t, "alice"), env.createUser(t, "bob")
+	room := env.createChatroom(t, "General", alice, bob)
+
+	// A message stored before encryption was enabled, whose plaintext happens to look like ciphertext
+	legacy := models.Message{
+		ID: primitive.NewObjectID(), ChatroomID: room.ID, SenderID: bob.UserID, SenderName: bob.Username,
+		MessageType: "text", TextContent: encryptedTextPrefix + "not really ciphertext", SentAt: time.Now().Add(-time.Minute),
+	}
+	if _, err := env.Messages.MsgColl.InsertOne(context.Background(), legacy); err != nil {
+		t.Fatalf("insert legacy message: %v", err)
+	}
+	sent := env.sendText(t, room, alice, "top secret")
+	editedText := "still secret"
+	edited, err := env.Messages.UpdateMessage(sent.ID, alice.UserID, &editedText, nil, nil)
+	if err != nil {
+		t.Fatalf("UpdateMessage: %v", err)
+	}
+	if sent.TextContent != "top secret" || edited.TextContent != "still secret" {
+		t.Errorf("returned text = %q then %q, want plaintext", sent.TextContent, edited.TextContent)
+	}
+
+	for _, doc := range env.MongoDB.Documents("messages") {
+		raw, _ := bson.Marshal(doc)
+		var stored models.Message
+		if err := bson.Unmarshal(raw, &stored); err != nil {
+			t.Fatalf("decode stored message: %v", err)
+		}
+		switch stored.ID {
+		case sent.ID:
+			if !stored.TextEncrypted || strings.Contains(stored.TextContent, "secret") {
+				t.Errorf("stored text = %q (encrypted %t), want ciphertext", stored.TextContent, stored.TextEncrypted)
+			}
+		case legacy.ID:
+			if stored.TextEncrypted {
+				t.Error("legacy message is flagged as encrypted")
+			}
+		}
+	}
+
+	messages, err := env.Messages.GetMessages(room.ID, bob.UserID, 10)
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	got := map[primitive.ObjectID]string{}
+	for _, message := range messages {
+		got[message.ID] = message.TextContent
+	}
+	if got[sent.ID] != "still secret" || got[legacy.ID] != legacy.TextContent {
+		t.Errorf("read back %q and %q, want the edited plaintext and the legacy text unchanged", got[sent.ID], got[legacy.ID])
+	}
+
+	// Embedded messages decode through the same path
+	chatrooms, err := env.Chatrooms.GetUserChatroomsSortedByLatestMessage(bob.UserID, ChatroomSortRecent)
+	if err != nil {
+		t.Fatalf("GetUserChatroomsSortedByLatestMessage: %v", err)
+	}
+	if len(chatrooms) != 1 || chatrooms[0].LatestMessage == nil || chatrooms[0].LatestMessage.TextContent != "still secret" {
+		t.Errorf("latest message = %+v, want the decrypted text", chatrooms)
+	}
+
+	// Readers with another key, or none, see a placeholder instead of ciphertext
+	for name, other := range map[string]*EncryptionService{"wrong key": newTestEncryption(t, 2), "no key": nil} {
+		var message models.Message
+		if err := WithMessageDecryption(env.Mongo, other).Collection("messages").FindOne(context.Background(), bson.M{"_id": sent.ID}).Decode(&message); err != nil {
+			t.Fatalf("%s: FindOne: %v", name, err)
+		}
+		if message.TextContent != models.UndecryptableText {
+			t.Errorf("%s: text = %q, want %q", name, message.TextContent, models.UndecryptableText)
+		}
+	}
+}
+
+func TestDraftsEncryptedAtRest(t *testing.T) {
+	env := encryptedTestEnv(t, newTestEncryption(t, 1))
+	alice := env.createUser(t, "alice")
+	room := env.createChatroom(t, "General", alice)
+
+	if _, err := env.Messages.SaveDraft(room.ID, alice.UserID, "half written", ""); err != nil {
+		t.Fatalf("SaveDraft: %v", err)
+	}
+	for _, doc := range env.MongoDB.Documents("message_drafts") {
+		if text, _ := doc.Map()["text_content"].(string); strings.Contains(text, "half") {
+			t.Errorf("stored draft text = %q, want ciphertext", text)
+		}
+	}
+	draft, err := env.Messages.GetDraft(room.ID, alice.UserID)
+	if err != nil || draft == nil || draft.TextContent != "half written" {
+		t.Errorf("GetDraft = %+v, %v; want the decrypted draft", draft, err)
+	}
+}
@@ -0,0 +1,244 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/ginchat/config"
+	"github.com/ginchat/utils"
+)
+
+const (
+	fcmScope           = "https://www.googleapis.com/auth/firebase.messaging"
+	fcmDefaultTokenURI = "https://oauth2.googleapis.com/token"
+)
+
+// fcmCredentials is the part of a Firebase service account key file the sender needs
+type fcmCredentials struct {
+	ProjectID   string `json:"project_id"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// FCMSender sends notifications through the Firebase Cloud Messaging HTTP v1 API.
+// It authenticates as a service account, caching the access token until shortly before it expires.
+type FCMSender struct {
+	projectID   string
+	clientEmail string
+	tokenURI    string
+	privateKey  *rsa.PrivateKey
+	client      *http.Client
+
+	mu          sync.Mutex
+	accessToken string
+	expiresAt   time.Time
+}
+
+// NewFCMSender creates an FCMSender from a Firebase service account key file
+func NewFCMSender(credentialsFile string) (*FCMSender, error) {
+	raw, err := os.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read FCM credentials: %w", err)
+	}
+
+	var creds fcmCredentials
+	if err := json.Unmarshal(raw, &creds); err != nil {
+		return nil, fmt.Errorf("failed to parse FCM credentials: %w", err)
+	}
+	if creds.ProjectID == "" || creds.ClientEmail == "" || creds.PrivateKey == "" {
+		return nil, errors.New("FCM credentials must include project_id, client_email and private_key")
+	}
+	if creds.TokenURI == "" {
+		creds.TokenURI = fcmDefaultTokenURI
+	}
+
+	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(creds.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid FCM private key: %w", err)
+	}
+
+	return &FCMSender{
+		projectID:   creds.ProjectID,
+		clientEmail: creds.ClientEmail,
+		tokenURI:    creds.TokenURI,
+		privateKey:  key,
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// fcmMessage is the body of a v1 messages:send request
+type fcmMessage struct {
+	Message struct {
+		Token        string            `json:"token"`
+		Notification fcmNotification   `json:"notification"`
+		Data         map[string]string `json:"data,omitempty"`
+		Android      fcmAndroid        `json:"android"`
+		APNS         fcmAPNS           `json:"apns"`
+	} `json:"message"`
+}
+
+// fcmNotification is the visible part of an FCM message
+type fcmNotification struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+}
+
+// fcmAndroid carries Android delivery options
+type fcmAndroid struct {
+	Priority     string `json:"priority"`
+	Notification struct {
+		Sound string `json:"sound,omitempty"`
+	} `json:"notification"`
+}
+
+// fcmAPNS carries the options FCM forwards to Apple Push Notification service
+type fcmAPNS struct {
+	Headers map[string]string `json:"headers"`
+	Payload struct {
+		APS struct {
+			Sound string `json:"sound,omitempty"`
+		} `json:"aps"`
+	} `json:"payload"`
+}
+
+// errFCMUnregistered is returned by send when FCM no longer knows the token (HTTP 404, UNREGISTERED)
+var errFCMUnregistered = errors.New("fcm token is not registered")
+
+// Send delivers the notification to each token; the v1 API has no multicast, so it makes one request per token.
+// Every token is attempted and the last failure is returned. Unregistered tokens are reported, not counted as failures.
+func (f *FCMSender) Send(tokens []string, title, body string, data map[string]interface{}, style config.PushStyle) ([]string, error) {
+	accessToken, err := f.token()
+	if err != nil {
+		return nil, err
+	}
+
+	// FCM data values must be strings
+	stringData := make(map[string]string, len(data))
+	for key, value := range data {
+		stringData[key] = fmt.Sprint(value)
+	}
+
+	var sendErr error
+	var unregistered []string
+	sent := 0
+	for _, token := range tokens {
+		var message fcmMessage
+		message.Message.Token = token
+		message.Message.Notification = fcmNotification{Title: title, Body: body}
+		message.Message.Data = stringData
+		message.Message.Android.Priority = "NORMAL"
+		message.Message.APNS.Headers = map[string]string{"apns-priority": "5"}
+		if style.Priority == config.PushPriorityHigh {
+			message.Message.Android.Priority = "HIGH"
+			message.Message.APNS.Headers["apns-priority"] = "10"
+		}
+		message.Message.Android.Notification.Sound = style.Sound
+		message.Message.APNS.Payload.APS.Sound = style.Sound
+
+		if err := f.send(accessToken, message); err != nil {
+			if errors.Is(err, errFCMUnregistered) {
+				unregistered = append(unregistered, token)
+				continue
+			}
+			log.Printf("FCM push error for token %s...: %v", token[:min(len(token), 10)], err)
+			sendErr = err
+			continue
+		}
+		sent++
+	}
+
+	utils.PushNotificationsSentTotal.Add(float64(sent))
+	log.Printf("Successfully sent FCM notification to %d of %d tokens", sent, len(tokens))
+	return unregistered, sendErr
+}
+
+// send posts one message to the FCM v1 API
+func (f *FCMSender) send(accessToken string, message fcmMessage) error {
+	jsonData, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification: %w", err)
+	}
+
+	endpoint := fmt.Sprintf("https://fcm.googleapis.com/v1/projects/%s/messages:send", f.projectID)
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to build notification request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send notification: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errFCMUnregistered
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fcm API returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// token returns a cached OAuth access token, exchanging a freshly signed service account assertion when it is about to expire
+func (f *FCMSender) token() (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.accessToken != "" && time.Now().Before(f.expiresAt) {
+		return f.accessToken, nil
+	}
+
+	now := time.Now()
+	assertion, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   f.clientEmail,
+		"scope": fcmScope,
+		"aud":   f.tokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}).SignedString(f.privateKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign FCM assertion: %w", err)
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	resp, err := f.client.Post(f.tokenURI, "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to get FCM access token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("FCM token endpoint returned status %d", resp.StatusCode)
+	}
+
+	var tokenResp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil || tokenResp.AccessToken == "" {
+		return "", errors.New("failed to parse FCM access token")
+	}
+
+	// Refresh a minute early so a token never expires mid-send
+	f.accessToken = tokenResp.AccessToken
+	f.expiresAt = now.Add(time.Duration(tokenResp.ExpiresIn)*time.Second - time.Minute)
+	return f.accessToken, nil
+}
@@ -1,11 +1,14 @@
 package services
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 
@@ -13,22 +16,26 @@ import (
 	"github.com/ginchat/utils"
 )
 
-// MediaService handles media file operations
+// MediaService stores media files on local disk and serves them under /media.
+// It is the MediaStore used when Cloudinary isn't configured.
 type MediaService struct {
 	BasePath string
 	BaseURL  string
+
+	maxUploadSize int64
 }
 
 // NewMediaService creates a new MediaService
-func NewMediaService(basePath, baseURL string) *MediaService {
+func NewMediaService(basePath, baseURL string, maxUploadSize int64) *MediaService {
 	// Create media directories if they don't exist
 	os.MkdirAll(filepath.Join(basePath, "uploads/images"), os.ModePerm)
 	os.MkdirAll(filepath.Join(basePath, "uploads/audio"), os.ModePerm)
 	os.MkdirAll(filepath.Join(basePath, "uploads/video"), os.ModePerm)
 
 	return &MediaService{
-		BasePath: basePath,
-		BaseURL:  baseURL,
+		BasePath:      basePath,
+		BaseURL:       baseURL,
+		maxUploadSize: maxUploadSize,
 	}
 }
 
@@ -48,9 +55,9 @@ func (s *MediaService) GetMediaFolder(mediaType utils.MediaType) string {
 
 // UploadFile uploads a file to the appropriate folder and returns the URL
 func (s *MediaService) UploadFile(file *multipart.FileHeader, mediaType utils.MediaType) (string, error) {
-	// Validate file size (10MB max)
-	if file.Size > 10*1024*1024 {
-		return "", errors.New("file size exceeds the 10MB limit")
+	// Validate file size (MEDIA_MAX_UPLOAD_MB)
+	if file.Size > s.maxUploadSize {
+		return "", errors.New("file size exceeds the upload limit")
 	}
 
 	// Get the file extension
@@ -81,6 +88,16 @@ func (s *MediaService) UploadFile(file *multipart.FileHeader, mediaType utils.Me
 	}
 	defer src.Close()
 
+	// Images are re-encoded so EXIF metadata (e.g. GPS location) isn't published
+	var content io.Reader = src
+	if mediaType == utils.ImageMedia {
+		cleaned, err := utils.StripImageMetadata(src, ext)
+		if err != nil {
+			return "", err
+		}
+		content = bytes.NewReader(cleaned)
+	}
+
 	// Create the destination file
 	dst, err := os.Create(filePath)
 	if err != nil {
@@ -89,7 +106,7 @@ func (s *MediaService) UploadFile(file *multipart.FileHeader, mediaType utils.Me
 	defer dst.Close()
 
 	// Copy the file content
-	if _, err = io.Copy(dst, src); err != nil {
+	if _, err = io.Copy(dst, content); err != nil {
 		return "", err
 	}
 
@@ -99,6 +116,69 @@ func (s *MediaService) UploadFile(file *multipart.FileHeader, mediaType utils.Me
 	return mediaURL, nil
 }
 
+// localPath maps a URL from UploadFile back to the file on disk. Only /media/<images|audio|video>/<file>
+// is accepted, so a URL can never reach outside the media folders.
+func (s *MediaService) localPath(mediaURL string) (string, bool) {
+	if s.BaseURL != "" {
+		if !strings.HasPrefix(mediaURL, s.BaseURL+"/") {
+			return "", false
+		}
+		mediaURL = strings.TrimPrefix(mediaURL, s.BaseURL)
+	}
+
+	parsedURL, err := url.Parse(mediaURL)
+	if err != nil || parsedURL.Scheme != "" || parsedURL.Host != "" || strings.Contains(mediaURL, "..") {
+		return "", false
+	}
+
+	parts := strings.Split(strings.TrimPrefix(parsedURL.Path, "/"), "/")
+	if len(parts) != 3 || parts[0] != "media" || parts[2] == "" || path.Clean(parsedURL.Path) != parsedURL.Path {
+		return "", false
+	}
+
+	var mediaType utils.MediaType
+	switch parts[1] {
+	case "images":
+		mediaType = utils.ImageMedia
+	case "audio":
+		mediaType = utils.AudioMedia
+	case "video":
+		mediaType = utils.VideoMedia
+	default:
+		return "", false
+	}
+
+	return filepath.Join(s.GetMediaFolder(mediaType), parts[2]), true
+}
+
+// IsOwnedAssetURL reports whether mediaURL is a file this service stored
+func (s *MediaService) IsOwnedAssetURL(mediaURL string) bool {
+	_, ok := s.localPath(mediaURL)
+	return ok
+}
+
+// AssetExists reports whether an owned file is still on disk
+func (s *MediaService) AssetExists(mediaURL string) bool {
+	filePath, ok := s.localPath(mediaURL)
+	if !ok {
+		return false
+	}
+	info, err := os.Stat(filePath)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// DeleteFile removes a stored file. URLs this service doesn't own and files that are already gone are ignored.
+func (s *MediaService) DeleteFile(mediaURL string) error {
+	filePath, ok := s.localPath(mediaURL)
+	if !ok {
+		return nil
+	}
+	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
 // getMediaTypeFolder returns the folder name for a specific media type
 func (s *MediaService) getMediaTypeFolder(mediaType utils.MediaType) string {
 	switch mediaType {
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ginchat/config"
+	"github.com/ginchat/utils"
+)
+
+// jpegWithGPS encodes a small JPEG and inserts an APP1 EXIF segment carrying a GPS marker right after SOI
+func jpegWithGPS(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	for x := 0; x < 16; x++ {
+		img.Set(x, x%8, color.RGBA{R: 200, A: 255})
+	}
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, img, nil); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	payload := append([]byte("Exif\x00\x00"), []byte("GPSLatitude=51.5007;GPSLongitude=-0.1246")...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	segment = append(segment, payload...)
+
+	raw := encoded.Bytes()
+	out := append([]byte{}, raw[:2]...) // SOI
+	out = append(out, segment...)
+	return append(out, raw[2:]...)
+}
+
+// storedFile reads back the file a local media URL points at
+func storedFile(t *testing.T, service *MediaService, mediaURL string) []byte {
+	t.Helper()
+	path, ok := service.localPath(mediaURL)
+	if !ok {
+		t.Fatalf("%s is not a local media URL", mediaURL)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read stored file: %v", err)
+	}
+	return data
+}
+
+func TestLocalUploadStripsImageMetadata(t *testing.T) {
+	service := NewMediaService(t.TempDir(), "", config.DefaultMaxUploadSize)
+
+	original := jpegWithGPS(t)
+	if _, err := jpeg.Decode(bytes.NewReader(original)); err != nil {
+		t.Fatalf("test image with EXIF doesn't decode: %v", err)
+	}
+
+	mediaURL, err := service.UploadFile(fileHeaderWith(t, "holiday.jpg", original), utils.ImageMedia)
+	if err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+	stored := storedFile(t, service, mediaURL)
+	if bytes.Contains(stored, []byte("Exif\x00\x00")) || bytes.Contains(stored, []byte("GPSLatitude")) {
+		t.Error("stored image still carries the EXIF segment")
+	}
+	img, err := jpeg.Decode(bytes.NewReader(stored))
+	if err != nil {
+		t.Fatalf("stored image doesn't decode: %v", err)
+	}
+	if size := img.Bounds().Size(); size != (image.Point{X: 16, Y: 8}) {
+		t.Errorf("stored image is %v, want 16x8", size)
+	}
+
+	t.Run("other media is stored as uploaded", func(t *testing.T) {
+		audio := []byte("ID3 not really audio, but Exif\x00\x00 bytes must survive")
+		mediaURL, err := service.UploadFile(fileHeaderWith(t, "note.mp3", audio), utils.AudioMedia)
+		if err != nil {
+			t.Fatalf("UploadFile: %v", err)
+		}
+		if stored := storedFile(t, service, mediaURL); !bytes.Equal(stored, audio) {
+			t.Errorf("audio changed on upload: %q", stored)
+		}
+	})
+
+	t.Run("a corrupt image is rejected and nothing is stored", func(t *testing.T) {
+		if _, err := service.UploadFile(fileHeaderWith(t, "broken.jpg", []byte("not a jpeg")), utils.ImageMedia); err == nil {
+			t.Fatal("corrupt image was accepted")
+		}
+		entries, _ := os.ReadDir(filepath.Join(service.BasePath, "uploads/images"))
+		if len(entries) != 1 {
+			t.Errorf("%d files in the images folder, want only the first upload", len(entries))
+		}
+	})
+}
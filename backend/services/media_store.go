@@ -0,0 +1,42 @@
+package services
+
+import (
+	"log"
+	"mime/multipart"
+
+	"github.com/ginchat/config"
+	"github.com/ginchat/utils"
+)
+
+// MediaStore is where uploaded media lives. CloudinaryService is used when Cloudinary is configured;
+// otherwise MediaService keeps files on local disk, so uploads still work in development.
+type MediaStore interface {
+	// UploadFile stores a file and returns the URL messages should reference
+	UploadFile(file *multipart.FileHeader, mediaType utils.MediaType) (string, error)
+	// DeleteFile removes a stored file; a file that is already gone is not an error
+	DeleteFile(mediaURL string) error
+	// IsOwnedAssetURL reports whether mediaURL points at a file in this store
+	IsOwnedAssetURL(mediaURL string) bool
+	// AssetExists reports whether an owned file can currently be loaded
+	AssetExists(mediaURL string) bool
+}
+
+// NewMediaStore picks Cloudinary when its credentials are configured and local storage otherwise.
+// Create it once at startup and share it, so the upload concurrency limit holds across controllers.
+func NewMediaStore(cfg *config.Config) MediaStore {
+	cloudinaryService, err := NewCloudinaryService(cfg.CloudinaryCloudName, cfg.CloudinaryAPIKey, cfg.CloudinaryAPISecret,
+		cfg.MaxUploadSize, cfg.ChunkedUploadThreshold, cfg.MediaUploadConcurrency, cfg.MediaUploadQueueTimeout)
+	if err == nil {
+		return cloudinaryService
+	}
+	log.Printf("Cloudinary not configured (%v); storing media on local disk under %s", err, cfg.MediaLocalDir)
+	return NewMediaService(cfg.MediaLocalDir, "", cfg.MaxUploadSize)
+}
+
+// LocalMediaDir returns the directory store keeps media under when it is local storage, or "" for Cloudinary
+func LocalMediaDir(store MediaStore) string {
+	if mediaService, ok := store.(*MediaService); ok {
+		return mediaService.BasePath
+	}
+	return ""
+}
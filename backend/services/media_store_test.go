@@ -0,0 +1,147 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ginchat/config"
+	"github.com/ginchat/utils"
+)
+
+// fakeCloudinary serves the parts of the Cloudinary API a MediaStore uses: uploads, destroys, and
+// fetching delivered assets (reached through mediaFetchClient)
+type fakeCloudinary struct {
+	mu     sync.Mutex
+	stored map[string]bool // Delivery paths of uploaded, undeleted assets
+	serial int
+}
+
+func (f *fakeCloudinary) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/upload"):
+		f.serial++
+		path := fmt.Sprintf("/demo/video/upload/v1/ginchat/audio/asset%d.mp3", f.serial)
+		f.stored[path] = true
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"secure_url": "https://res.cloudinary.com` + path + `"}`))
+	case strings.HasSuffix(r.URL.Path, "/destroy"):
+		// The SDK sends the form without a Content-Type, so r.ParseForm would skip it
+		body, _ := io.ReadAll(r.Body)
+		form, _ := url.ParseQuery(string(body))
+		for path := range f.stored {
+			if strings.Contains(path, "/"+form.Get("public_id")+".") {
+				delete(f.stored, path)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"result": "ok"}`))
+	case f.stored[r.URL.Path]:
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// rewriteHost sends every request to server instead of the host it names
+type rewriteHost struct{ server *url.URL }
+
+func (rt rewriteHost) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host = rt.server.Scheme, rt.server.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// newFakeCloudinaryStore returns a CloudinaryService whose API calls and asset fetches go to a fakeCloudinary
+func newFakeCloudinaryStore(t *testing.T) *CloudinaryService {
+	t.Helper()
+	server := httptest.NewServer(&fakeCloudinary{stored: map[string]bool{}})
+	t.Cleanup(server.Close)
+	serverURL, _ := url.Parse(server.URL)
+
+	transport := mediaFetchClient.Transport
+	mediaFetchClient.Transport = rewriteHost{serverURL}
+	t.Cleanup(func() { mediaFetchClient.Transport = transport })
+
+	service, err := NewCloudinaryService("demo", "key", "secret", 4096, config.DefaultChunkedUploadThreshold,
+		config.DefaultMediaUploadConcurrency, config.DefaultMediaUploadQueueTimeout)
+	if err != nil {
+		t.Fatalf("NewCloudinaryService: %v", err)
+	}
+	service.Cld.Upload.Config.API.UploadPrefix = server.URL
+	return service
+}
+
+func TestMediaStoreBackends(t *testing.T) {
+	for name, newStore := range map[string]func(t *testing.T) MediaStore{
+		"local":      func(t *testing.T) MediaStore { return NewMediaService(t.TempDir(), "", 4096) },
+		"cloudinary": func(t *testing.T) MediaStore { return newFakeCloudinaryStore(t) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t)
+
+			mediaURL, err := store.UploadFile(fileHeader(t, "clip.mp3", 512), utils.AudioMedia)
+			if err != nil {
+				t.Fatalf("UploadFile: %v", err)
+			}
+			if !store.IsOwnedAssetURL(mediaURL) || !store.AssetExists(mediaURL) {
+				t.Fatalf("uploaded %s: owned = %t, exists = %t; want both", mediaURL, store.IsOwnedAssetURL(mediaURL), store.AssetExists(mediaURL))
+			}
+
+			if err := store.DeleteFile(mediaURL); err != nil {
+				t.Fatalf("DeleteFile: %v", err)
+			}
+			if store.AssetExists(mediaURL) {
+				t.Errorf("%s still exists after DeleteFile", mediaURL)
+			}
+			if err := store.DeleteFile(mediaURL); err != nil {
+				t.Errorf("deleting %s again: %v, want nil for a file that is already gone", mediaURL, err)
+			}
+
+			for _, foreign := range []string{"https://example.com/clip.mp3", "/etc/passwd", ""} {
+				if store.IsOwnedAssetURL(foreign) || store.AssetExists(foreign) {
+					t.Errorf("%q is treated as one of the store's files", foreign)
+				}
+			}
+
+			for _, tt := range []struct {
+				file *multipart.FileHeader
+				err  string
+			}{
+				{fileHeader(t, "huge.mp3", 5000), "file size exceeds the upload limit"},
+				{fileHeader(t, "notes.exe", 10), "invalid file type for the specified media type"},
+			} {
+				if _, err := store.UploadFile(tt.file, utils.AudioMedia); err == nil || err.Error() != tt.err {
+					t.Errorf("uploading %s: %v, want %q", tt.file.Filename, err, tt.err)
+				}
+			}
+		})
+	}
+}
+
+func TestNewMediaStoreFallsBackToLocal(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Config{
+		MediaLocalDir:           dir,
+		MaxUploadSize:           config.DefaultMaxUploadSize,
+		ChunkedUploadThreshold:  config.DefaultChunkedUploadThreshold,
+		MediaUploadConcurrency:  config.DefaultMediaUploadConcurrency,
+		MediaUploadQueueTimeout: config.DefaultMediaUploadQueueTimeout,
+	}
+	if store := NewMediaStore(cfg); LocalMediaDir(store) != dir {
+		t.Errorf("without Cloudinary credentials: store = %T with local dir %q, want local storage under %s", store, LocalMediaDir(store), dir)
+	}
+
+	cfg.CloudinaryCloudName, cfg.CloudinaryAPIKey, cfg.CloudinaryAPISecret = "demo", "key", "secret"
+	if store, ok := NewMediaStore(cfg).(*CloudinaryService); !ok || LocalMediaDir(store) != "" {
+		t.Errorf("with Cloudinary credentials: store = %T, want *CloudinaryService", store)
+	}
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/ginchat/models"
+)
+
+// Room-wide mentions recognised in message text
+const (
+	MentionEveryone = "everyone" // Every member of the room (admins only)
+	MentionHere     = "here"     // Members currently connected to the room
+)
+
+// roomMentionPattern matches @everyone / @here as standalone words, not inside e.g. an email address
+var roomMentionPattern = regexp.MustCompile(`(?i)(?:^|[^\w@])@(everyone|here)\b`)
+
+// RoomMentions records which room-wide mentions appear in a message
+type RoomMentions struct {
+	Everyone bool
+	Here     bool
+}
+
+// ParseRoomMentions finds @everyone and @here in message text
+func ParseRoomMentions(text string) RoomMentions {
+	var mentions RoomMentions
+	for _, match := range roomMentionPattern.FindAllStringSubmatch(text, -1) {
+		switch strings.ToLower(match[1]) {
+		case MentionEveryone:
+			mentions.Everyone = true
+		case MentionHere:
+			mentions.Here = true
+		}
+	}
+	return mentions
+}
+
+// MentionTargets is the set of members a room-wide mention resolved to
+type MentionTargets struct {
+	Kind  string // MentionEveryone or MentionHere
+	Users map[uint]bool
+}
+
+// ResolveRoomMentions resolves the mentions in a message to the members they notify.
+// @everyone covers every member; @here only the connected ones. The sender is never included.
+// Returns nil when the message has no room-wide mention.
+func ResolveRoomMentions(mentions RoomMentions, chatroom *models.Chatroom, senderID uint, connectedUsers []uint) *MentionTargets {
+	switch {
+	case mentions.Everyone:
+		targets := &MentionTargets{Kind: MentionEveryone, Users: make(map[uint]bool)}
+		for _, member := range chatroom.Members {
+			if member.UserID != senderID {
+				targets.Users[member.UserID] = true
+			}
+		}
+		return targets
+	case mentions.Here:
+		members := make(map[uint]bool, len(chatroom.Members))
+		for _, member := range chatroom.Members {
+			members[member.UserID] = true
+		}
+		targets := &MentionTargets{Kind: MentionHere, Users: make(map[uint]bool)}
+		for _, userID := range connectedUsers {
+			if userID != senderID && members[userID] {
+				targets.Users[userID] = true
+			}
+		}
+		return targets
+	default:
+		return nil
+	}
+}
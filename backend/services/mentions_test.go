@@ -0,0 +1,65 @@
+package services
+
+import (
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/ginchat/models"
+)
+
+func TestParseRoomMentions(t *testing.T) {
+	tests := []struct {
+		text string
+		want RoomMentions
+	}{
+		{"@everyone lunch is here", RoomMentions{Everyone: true}},
+		{"anyone @here?", RoomMentions{Here: true}},
+		{"@EVERYONE and @Here", RoomMentions{Everyone: true, Here: true}},
+		{"mail me at someone@everyone.com", RoomMentions{}},
+		{"@everyoneelse is not a mention", RoomMentions{}},
+		{"no mentions at all", RoomMentions{}},
+	}
+	for _, tt := range tests {
+		if got := ParseRoomMentions(tt.text); got != tt.want {
+			t.Errorf("ParseRoomMentions(%q) = %+v, want %+v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestResolveRoomMentions(t *testing.T) {
+	chatroom := &models.Chatroom{Members: []models.ChatroomMember{{UserID: 1}, {UserID: 2}, {UserID: 3}, {UserID: 4}}}
+	connected := []uint{1, 3, 9} // 9 is connected but not a member
+	resolved := func(mentions RoomMentions) []uint {
+		targets := ResolveRoomMentions(mentions, chatroom, 1, connected)
+		if targets == nil {
+			return nil
+		}
+		return slices.Sorted(maps.Keys(targets.Users))
+	}
+
+	if got := resolved(RoomMentions{Everyone: true}); !slices.Equal(got, []uint{2, 3, 4}) {
+		t.Errorf("@everyone resolved to %v, want every member but the sender", got)
+	}
+	if got := resolved(RoomMentions{Here: true}); !slices.Equal(got, []uint{3}) {
+		t.Errorf("@here resolved to %v, want only connected members other than the sender", got)
+	}
+	if targets := ResolveRoomMentions(RoomMentions{Everyone: true, Here: true}, chatroom, 1, connected); targets.Kind != MentionEveryone {
+		t.Errorf("@everyone with @here resolved as %q, want @everyone to win", targets.Kind)
+	}
+	if targets := ResolveRoomMentions(RoomMentions{}, chatroom, 1, connected); targets != nil {
+		t.Errorf("no mention resolved to %+v, want nil", targets)
+	}
+}
+
+func TestEveryoneMentionNeedsAdmin(t *testing.T) {
+	env := newTestEnv(t, false)
+	alice, bob := env.createUser(t, "alice"), env.createUser(t, "bob")
+	room := env.createChatroom(t, "General", alice, bob)
+
+	if _, err := env.Messages.SendMessage(room.ID, bob.UserID, bob.Username, "text", "@everyone hi", ""); err == nil || err.Error() != "only chatroom admins can mention everyone" {
+		t.Errorf("member @everyone: err = %v, want it rejected", err)
+	}
+	env.sendText(t, room, bob, "@here hi")
+	env.sendText(t, room, alice, "@everyone hi")
+}
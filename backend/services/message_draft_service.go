@@ -0,0 +1,104 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/ginchat/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxDraftTextLength caps how much unsent text is kept per draft
+const maxDraftTextLength = 10000
+
+// SaveDraft creates or replaces the user's draft for a chatroom. A draft with neither text nor media
+// is deleted instead, so clearing the input box clears the draft; nil is returned in that case.
+func (s *MessageService) SaveDraft(chatroomID primitive.ObjectID, userID uint, textContent, mediaURL string) (*models.MessageDraft, error) {
+	if len(textContent) > maxDraftTextLength {
+		return nil, errors.New("draft is too long")
+	}
+	if mediaURL != "" && !s.isAllowedMediaURL(mediaURL) {
+		return nil, errors.New("media URL is not hosted by this app")
+	}
+
+	chatroom, err := s.ChatSvc.GetChatroomByID(chatroomID)
+	if err != nil {
+		return nil, err
+	}
+	if !s.ChatSvc.IsMember(chatroom, userID) {
+		return nil, errors.New("user is not a member of this chatroom")
+	}
+
+	if textContent == "" && mediaURL == "" {
+		return nil, s.DeleteDraft(chatroomID, userID)
+	}
+
+	storedText, encrypted, err := s.encryptStoredText(textContent)
+	if err != nil {
+		return nil, err
+	}
+	draft := &models.MessageDraft{
+		UserID:      userID,
+		ChatroomID:  chatroomID,
+		TextContent: textContent,
+		MediaURL:    mediaURL,
+		UpdatedAt:   time.Now(),
+	}
+	filter := bson.M{"user_id": userID, "chatroom_id": chatroomID}
+	update := bson.M{
+		"$set": bson.M{
+			"text_content":   storedText,
+			"text_encrypted": encrypted,
+			"media_url":      mediaURL,
+			"updated_at":     draft.UpdatedAt,
+		},
+		"$setOnInsert": bson.M{"_id": primitive.NewObjectID()},
+	}
+	if _, err := s.DraftColl.UpdateOne(context.Background(), filter, update, options.Update().SetUpsert(true)); err != nil {
+		return nil, errors.New("failed to save draft")
+	}
+
+	return draft, nil
+}
+
+// GetDraft returns the user's draft for a chatroom, or nil if they have none
+func (s *MessageService) GetDraft(chatroomID primitive.ObjectID, userID uint) (*models.MessageDraft, error) {
+	chatroom, err := s.ChatSvc.GetChatroomByID(chatroomID)
+	if err != nil {
+		return nil, err
+	}
+	if !s.ChatSvc.IsMember(chatroom, userID) {
+		return nil, errors.New("user is not a member of this chatroom")
+	}
+
+	var draft models.MessageDraft
+	err = s.DraftColl.FindOne(context.Background(), bson.M{"user_id": userID, "chatroom_id": chatroomID}).Decode(&draft)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.New("failed to get draft")
+	}
+
+	if draft.TextEncrypted {
+		text, err := decryptStoredText(s.Encryption, draft.TextContent)
+		if err != nil {
+			log.Printf("Failed to decrypt draft for user %d in chatroom %s: %v", userID, chatroomID.Hex(), err)
+		}
+		draft.TextContent = text
+	}
+	return &draft, nil
+}
+
+// DeleteDraft removes the user's draft for a chatroom (deleting a missing draft is a no-op)
+func (s *MessageService) DeleteDraft(chatroomID primitive.ObjectID, userID uint) error {
+	if _, err := s.DraftColl.DeleteOne(context.Background(), bson.M{"user_id": userID, "chatroom_id": chatroomID}); err != nil {
+		return errors.New("failed to delete draft")
+	}
+	return nil
+}
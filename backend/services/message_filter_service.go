@@ -0,0 +1,101 @@
+package services
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// MessageFilter matches banned words in message text (case-insensitive, whole words only).
+// A nil *MessageFilter is valid and matches nothing, which is how filtering is disabled.
+type MessageFilter struct {
+	pattern *regexp.Regexp
+}
+
+// NewMessageFilter compiles the given words into a single case-insensitive pattern.
+// Returns nil if there are no words.
+func NewMessageFilter(words []string) *MessageFilter {
+	quoted := make([]string, 0, len(words))
+	for _, word := range words {
+		word = strings.TrimSpace(word)
+		if word == "" {
+			continue
+		}
+		quoted = append(quoted, wordPattern(word))
+	}
+	if len(quoted) == 0 {
+		return nil
+	}
+
+	// Longest first so "spammer" wins over "spam" in the alternation
+	sort.Slice(quoted, func(i, j int) bool { return len(quoted[i]) > len(quoted[j]) })
+
+	return &MessageFilter{
+		pattern: regexp.MustCompile(`(?i)(?:` + strings.Join(quoted, "|") + `)`),
+	}
+}
+
+// wordPattern matches word as a whole word. \b only holds next to a letter, digit or underscore,
+// so it is left off the ends of words like "c++" that start or end with punctuation.
+func wordPattern(word string) string {
+	pattern := regexp.QuoteMeta(word)
+	first, _ := utf8.DecodeRuneInString(word)
+	last, _ := utf8.DecodeLastRuneInString(word)
+	if isWordRune(first) {
+		pattern = `\b` + pattern
+	}
+	if isWordRune(last) {
+		pattern += `\b`
+	}
+	return pattern
+}
+
+// isWordRune reports whether \b treats r as a word character (ASCII letters, digits and underscore)
+func isWordRune(r rune) bool {
+	return r == '_' || (r >= '0' && r <= '9') || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+}
+
+// LoadMessageFilter reads a word list file (one word per line, # starts a comment)
+func LoadMessageFilter(path string) (*MessageFilter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var words []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return NewMessageFilter(words), nil
+}
+
+// Contains reports whether text has any banned word
+func (f *MessageFilter) Contains(text string) bool {
+	if f == nil || text == "" {
+		return false
+	}
+	return f.pattern.MatchString(text)
+}
+
+// Mask replaces each banned word in text with asterisks of the same length
+func (f *MessageFilter) Mask(text string) string {
+	if f == nil || text == "" {
+		return text
+	}
+	return f.pattern.ReplaceAllStringFunc(text, func(match string) string {
+		return strings.Repeat("*", utf8.RuneCountInString(match))
+	})
+}
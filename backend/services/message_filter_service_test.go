@@ -0,0 +1,104 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ginchat/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestMessageFilterMatching(t *testing.T) {
+	filter := NewMessageFilter([]string{"spam", " spammer ", "", "c++"})
+
+	tests := []struct {
+		text     string
+		contains bool
+		masked   string
+	}{
+		{"hello there", false, "hello there"},
+		{"buy SPAM now", true, "buy **** now"},
+		{"the Spammer struck", true, "the ******* struck"},
+		{"spamming is a different word", false, "spamming is a different word"},
+		{"I like c++ a lot", true, "I like *** a lot"},
+		{"", false, ""},
+	}
+	for _, tt := range tests {
+		if got := filter.Contains(tt.text); got != tt.contains {
+			t.Errorf("Contains(%q) = %v, want %v", tt.text, got, tt.contains)
+		}
+		if got := filter.Mask(tt.text); got != tt.masked {
+			t.Errorf("Mask(%q) = %q, want %q", tt.text, got, tt.masked)
+		}
+	}
+
+	t.Run("no words disables filtering", func(t *testing.T) {
+		var disabled *MessageFilter = NewMessageFilter([]string{" ", ""})
+		if disabled != nil {
+			t.Fatal("a filter with no words should be nil")
+		}
+		if disabled.Contains("spam") || disabled.Mask("spam") != "spam" {
+			t.Error("a nil filter changed text")
+		}
+	})
+
+	t.Run("word list file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "words.txt")
+		os.WriteFile(path, []byte("# house rules\nspam\n\n  scam  \n"), 0o644)
+		loaded, err := LoadMessageFilter(path)
+		if err != nil {
+			t.Fatalf("LoadMessageFilter: %v", err)
+		}
+		if !loaded.Contains("a Scam") || loaded.Contains("house rules") {
+			t.Error("word list file was not loaded as written")
+		}
+		if _, err := LoadMessageFilter(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+			t.Error("a missing word list should be an error")
+		}
+	})
+}
+
+func TestSendMessageAppliesRoomFilterPolicy(t *testing.T) {
+	env := newTestEnv(t, false)
+	env.Messages.Filter = NewMessageFilter([]string{"spam"})
+	alice := env.createUser(t, "alice")
+
+	roomWithPolicy := func(policy string) *models.Chatroom {
+		room := env.createChatroom(t, "Room "+policy, alice)
+		if policy != "" {
+			if _, err := env.Chatrooms.ChatColl.UpdateOne(context.Background(), bson.M{"_id": room.ID}, bson.M{"$set": bson.M{"filter_policy": policy}}); err != nil {
+				t.Fatalf("set filter policy: %v", err)
+			}
+		}
+		return room
+	}
+
+	t.Run("blocked", func(t *testing.T) {
+		room := roomWithPolicy(models.ChatroomFilterReject)
+		if _, err := env.Messages.SendMessage(room.ID, alice.UserID, alice.Username, "text", "buy spam", ""); err == nil || err.Error() != "message contains blocked content" {
+			t.Fatalf("err = %v, want blocked content", err)
+		}
+		if n, _ := env.Messages.MsgColl.CountDocuments(context.Background(), bson.M{"chatroom_id": room.ID}); n != 0 {
+			t.Errorf("%d messages stored for a blocked message", n)
+		}
+	})
+
+	for _, tc := range []struct {
+		name, policy, text, want string
+	}{
+		{"masked by default", "", "buy Spam now", "buy **** now"},
+		{"masked", models.ChatroomFilterMask, "buy spam", "buy ****"},
+		{"filtering off", models.ChatroomFilterOff, "buy spam", "buy spam"},
+		{"clean", models.ChatroomFilterReject, "hello", "hello"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			room := roomWithPolicy(tc.policy)
+			message := env.sendText(t, room, alice, tc.text)
+			if message.TextContent != tc.want {
+				t.Errorf("sent %q, stored %q, want %q", tc.text, message.TextContent, tc.want)
+			}
+		})
+	}
+}
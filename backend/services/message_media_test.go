@@ -0,0 +1,571 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ginchat/config"
+	"github.com/ginchat/models"
+	"github.com/ginchat/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeMediaStore keeps uploads in memory and fails the upload at index failAt (-1 for never)
+type fakeMediaStore struct {
+	failAt  int
+	uploads int
+	stored  map[string]bool
+}
+
+func newFakeMediaStore(failAt int) *fakeMediaStore {
+	return &fakeMediaStore{failAt: failAt, stored: map[string]bool{}}
+}
+
+func (f *fakeMediaStore) UploadFile(file *multipart.FileHeader, mediaType utils.MediaType) (string, error) {
+	defer func() { f.uploads++ }()
+	if f.uploads == f.failAt {
+		return "", errors.New("failed to upload file")
+	}
+	url := "https://media.test/" + string(mediaType) + "/" + file.Filename
+	f.stored[url] = true
+	return url, nil
+}
+
+func (f *fakeMediaStore) DeleteFile(mediaURL string) error {
+	delete(f.stored, mediaURL)
+	return nil
+}
+
+func (f *fakeMediaStore) IsOwnedAssetURL(mediaURL string) bool {
+	return strings.HasPrefix(mediaURL, "https://media.test/")
+}
+
+func (f *fakeMediaStore) AssetExists(mediaURL string) bool {
+	return f.stored[mediaURL]
+}
+
+// fileHeaders builds one small file header per name
+func fileHeaders(t *testing.T, names ...string) []*multipart.FileHeader {
+	t.Helper()
+	files := make([]*multipart.FileHeader, len(names))
+	for i, name := range names {
+		files[i] = fileHeader(t, name, 16)
+	}
+	return files
+}
+
+func TestSendMessageWithMediaCombinesTextAndImage(t *testing.T) {
+	env := newTestEnv(t, false)
+	store := newFakeMediaStore(-1)
+	env.Messages.Media = store
+	alice, bob := env.createUser(t, "alice"), env.createUser(t, "bob")
+	room := env.createChatroom(t, "General", alice, bob)
+
+	message, err := env.Messages.SendMessageWithMedia(room.ID, alice.UserID, alice.Username, "look at this", fileHeaders(t, "cat.jpg"))
+	if err != nil {
+		t.Fatalf("SendMessageWithMedia: %v", err)
+	}
+	if message.MessageType != "text_and_picture" || message.TextContent != "look at this" {
+		t.Errorf("message is %q with text %q, want text_and_picture with the caption", message.MessageType, message.TextContent)
+	}
+	if message.MediaURL == "" || !store.stored[message.MediaURL] {
+		t.Errorf("message points at %q, which wasn't uploaded", message.MediaURL)
+	}
+
+	// Without text the same file is a plain picture
+	message, err = env.Messages.SendMessageWithMedia(room.ID, alice.UserID, alice.Username, "", fileHeaders(t, "dog.png"))
+	if err != nil {
+		t.Fatalf("SendMessageWithMedia: %v", err)
+	}
+	if message.MessageType != "picture" {
+		t.Errorf("image without text is %q, want picture", message.MessageType)
+	}
+}
+
+func TestSendAlbum(t *testing.T) {
+	env := newTestEnv(t, false)
+	store := newFakeMediaStore(-1)
+	env.Messages.Media = store
+	alice, bob := env.createUser(t, "alice"), env.createUser(t, "bob")
+	room := env.createChatroom(t, "Holiday", alice, bob)
+	env.sendText(t, room, alice, "photos coming")
+
+	album, err := env.Messages.SendMessageWithMedia(room.ID, alice.UserID, alice.Username, "beach day", fileHeaders(t, "one.jpg", "two.png", "wave.mp4"))
+	if err != nil {
+		t.Fatalf("SendMessageWithMedia: %v", err)
+	}
+	want := []models.Attachment{
+		{URL: "https://media.test/image/one.jpg", MediaKind: "image"},
+		{URL: "https://media.test/image/two.png", MediaKind: "image"},
+		{URL: "https://media.test/video/wave.mp4", MediaKind: "video"},
+	}
+	if album.MessageType != models.MessageTypeAlbum || album.MediaURL != "" || album.TextContent != "beach day" || !slices.Equal(album.Attachments, want) {
+		t.Errorf("album = %+v, want one album message carrying every file in order", album)
+	}
+	if response := album.ToResponse(); !slices.Equal(response.Attachments, want) || response.Preview() != "beach day" {
+		t.Errorf("response = %+v, want the attachment list", response)
+	}
+
+	// The room's media lists the album alongside single media, not plain text
+	picture, err := env.Messages.SendMessageWithMedia(room.ID, bob.UserID, bob.Username, "", fileHeaders(t, "cat.jpg"))
+	if err != nil {
+		t.Fatalf("SendMessageWithMedia: %v", err)
+	}
+	media, err := env.Messages.GetChatroomMedia(room.ID)
+	if err != nil {
+		t.Fatalf("GetChatroomMedia: %v", err)
+	}
+	ids := make([]primitive.ObjectID, len(media))
+	for i, message := range media {
+		ids[i] = message.ID
+	}
+	if !slices.Equal(ids, []primitive.ObjectID{picture.ID, album.ID}) || !slices.Equal(media[1].Attachments, want) {
+		t.Errorf("media = %v, want the picture then the album", ids)
+	}
+
+	tooMany := make([]string, models.MaxAlbumAttachments+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("%d.jpg", i)
+	}
+	uploads := store.uploads
+	if _, err := env.Messages.SendMessageWithMedia(room.ID, alice.UserID, alice.Username, "", fileHeaders(t, tooMany...)); err == nil || err.Error() != "too many attachments" {
+		t.Errorf("%d files: err = %v, want too many attachments", len(tooMany), err)
+	}
+	if store.uploads != uploads {
+		t.Errorf("%d files were uploaded for a rejected album", store.uploads-uploads)
+	}
+}
+
+func TestDeleteAlbumRemovesEveryAttachment(t *testing.T) {
+	env := newTestEnv(t, false)
+	store := newFakeMediaStore(-1)
+	env.Messages.Media = store
+	alice := env.createUser(t, "alice")
+	room := env.createChatroom(t, "Holiday", alice)
+
+	kept, err := env.Messages.SendMessageWithMedia(room.ID, alice.UserID, alice.Username, "", fileHeaders(t, "keep.jpg"))
+	if err != nil {
+		t.Fatalf("SendMessageWithMedia: %v", err)
+	}
+	album, err := env.Messages.SendMessageWithMedia(room.ID, alice.UserID, alice.Username, "", fileHeaders(t, "one.jpg", "two.jpg", "clip.mp4"))
+	if err != nil {
+		t.Fatalf("SendMessageWithMedia: %v", err)
+	}
+	if len(store.stored) != 4 {
+		t.Fatalf("%d files stored, want 4", len(store.stored))
+	}
+
+	if _, err := env.Messages.DeleteMessage(album.ID, alice.UserID); err != nil {
+		t.Fatalf("DeleteMessage: %v", err)
+	}
+	for _, attachment := range album.Attachments {
+		if store.stored[attachment.URL] {
+			t.Errorf("%s is still stored after the album was deleted", attachment.URL)
+		}
+	}
+	if !store.stored[kept.MediaURL] {
+		t.Errorf("another message's %s was deleted with the album", kept.MediaURL)
+	}
+}
+
+func TestSendMessageWithMediaUploadFailureCreatesNothing(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		files []string
+	}{
+		{"single file", []string{"cat.jpg"}},
+		{"album", []string{"one.jpg", "two.jpg", "three.jpg"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			env := newTestEnv(t, false)
+			// The last upload fails, after any earlier ones succeeded
+			store := newFakeMediaStore(len(tc.files) - 1)
+			env.Messages.Media = store
+			alice := env.createUser(t, "alice")
+			room := env.createChatroom(t, "General", alice)
+
+			_, err := env.Messages.SendMessageWithMedia(room.ID, alice.UserID, alice.Username, "caption", fileHeaders(t, tc.files...))
+			if err == nil {
+				t.Fatal("SendMessageWithMedia succeeded despite the failed upload")
+			}
+			if !errors.Is(err, ErrMediaUpload) || err.Error() != "failed to upload file" {
+				t.Errorf("error = %v, want the store's error marked as ErrMediaUpload", err)
+			}
+			count, err := env.Messages.MsgColl.CountDocuments(context.Background(), bson.M{"chatroom_id": room.ID})
+			if err != nil {
+				t.Fatalf("CountDocuments: %v", err)
+			}
+			if count != 0 {
+				t.Errorf("%d messages were created", count)
+			}
+			if len(store.stored) != 0 {
+				t.Errorf("uploads left behind: %v", store.stored)
+			}
+		})
+	}
+}
+
+func TestSendMessageWithMediaRejectedSenderUploadsNothing(t *testing.T) {
+	env := newTestEnv(t, false)
+	store := newFakeMediaStore(-1)
+	env.Messages.Media = store
+	alice, mallory := env.createUser(t, "alice"), env.createUser(t, "mallory")
+	room := env.createChatroom(t, "General", alice)
+
+	_, err := env.Messages.SendMessageWithMedia(room.ID, mallory.UserID, mallory.Username, "", fileHeaders(t, "cat.jpg"))
+	if err == nil {
+		t.Fatal("a non-member could send media")
+	}
+	if errors.Is(err, ErrMediaUpload) {
+		t.Errorf("rejection %v is reported as an upload failure", err)
+	}
+	if store.uploads != 0 {
+		t.Errorf("%d files were uploaded for a rejected sender", store.uploads)
+	}
+}
+
+func TestAllowedMediaTypes(t *testing.T) {
+	env := newTestEnv(t, false)
+	store := newFakeMediaStore(-1)
+	env.Messages.Media = store
+	alice, bob := env.createUser(t, "alice"), env.createUser(t, "bob")
+	room := env.createChatroom(t, "Photos only", alice, bob)
+	imagesOnly := []string{"image"}
+
+	// Only the creator decides, even over another admin
+	if _, err := env.Chatrooms.ChatColl.UpdateOne(context.Background(),
+		bson.M{"_id": room.ID, "members.user_id": bob.UserID},
+		bson.M{"$set": bson.M{"members.$.role": models.ChatroomRoleAdmin}}); err != nil {
+		t.Fatalf("promote bob: %v", err)
+	}
+	if _, _, err := env.Chatrooms.UpdateChatroomDetails(room.ID, bob.UserID, nil, nil, nil, nil, &imagesOnly, nil, nil); err == nil || err.Error() != "only the creator can change allowed media types" {
+		t.Errorf("admin restricting media: err = %v, want it rejected", err)
+	}
+	if _, _, err := env.Chatrooms.UpdateChatroomDetails(room.ID, alice.UserID, nil, nil, nil, nil, &[]string{"file"}, nil, nil); err == nil || err.Error() != "invalid media type" {
+		t.Errorf("unknown media type: err = %v, want it rejected", err)
+	}
+	updated, _, err := env.Chatrooms.UpdateChatroomDetails(room.ID, alice.UserID, nil, nil, nil, nil, &imagesOnly, nil, nil)
+	if err != nil {
+		t.Fatalf("UpdateChatroomDetails: %v", err)
+	}
+	if !updated.AllowsMediaType("image") || updated.AllowsMediaType("video") {
+		t.Fatalf("allowed media types = %v, want images only", updated.AllowedMediaTypes)
+	}
+
+	const notAllowed = "this media type is not allowed in this chatroom"
+	for _, files := range [][]string{{"clip.mp4"}, {"song.mp3"}, {"cat.jpg", "clip.mp4"}} {
+		if _, err := env.Messages.SendMessageWithMedia(room.ID, bob.UserID, bob.Username, "", fileHeaders(t, files...)); err == nil || err.Error() != notAllowed {
+			t.Errorf("uploading %v: err = %v, want it rejected", files, err)
+		}
+	}
+	if store.uploads != 0 {
+		t.Errorf("%d files were uploaded for rejected messages", store.uploads)
+	}
+	const video = "https://media.test/video/clip.mp4"
+	store.stored[video] = true
+	if _, err := env.Messages.SendMessage(room.ID, bob.UserID, bob.Username, "video", "", video); err == nil || err.Error() != notAllowed {
+		t.Errorf("sending an uploaded video: err = %v, want it rejected", err)
+	}
+
+	if _, err := env.Messages.SendMessageWithMedia(room.ID, bob.UserID, bob.Username, "", fileHeaders(t, "cat.jpg")); err != nil {
+		t.Errorf("uploading an image: %v", err)
+	}
+	if _, err := env.Messages.SendMessageWithMedia(room.ID, bob.UserID, bob.Username, "", fileHeaders(t, "one.jpg", "two.png")); err != nil {
+		t.Errorf("uploading an image album: %v", err)
+	}
+	env.sendText(t, room, bob, "text is always allowed")
+
+	// Clearing the list allows everything again
+	if _, _, err := env.Chatrooms.UpdateChatroomDetails(room.ID, alice.UserID, nil, nil, nil, nil, &[]string{}, nil, nil); err != nil {
+		t.Fatalf("UpdateChatroomDetails: %v", err)
+	}
+	if _, err := env.Messages.SendMessage(room.ID, bob.UserID, bob.Username, "video", "", video); err != nil {
+		t.Errorf("sending a video once all types are allowed: %v", err)
+	}
+}
+
+func TestSendMessageOnlyAcceptsOwnedMediaURLs(t *testing.T) {
+	env := newTestEnv(t, false)
+	cloudinary, err := NewCloudinaryService("demo", "key", "secret", config.DefaultMaxUploadSize, config.DefaultChunkedUploadThreshold,
+		config.DefaultMediaUploadConcurrency, config.DefaultMediaUploadQueueTimeout)
+	if err != nil {
+		t.Fatalf("NewCloudinaryService: %v", err)
+	}
+	env.Messages.Media = cloudinary
+	alice := env.createUser(t, "alice")
+	room := env.createChatroom(t, "General", alice)
+
+	for _, tc := range []struct {
+		name, url string
+		accepted  bool
+	}{
+		{"Cloudinary asset in this cloud", "https://res.cloudinary.com/demo/image/upload/v1/ginchat/picture/cat.jpg", true},
+		{"local upload", "/media/picture/cat.jpg", true},
+		{"external URL", "https://evil.example.com/cat.jpg", false},
+		{"Cloudinary asset in another cloud", "https://res.cloudinary.com/other/image/upload/v1/cat.jpg", false},
+		{"local path escaping the media directory", "/media/../config.env", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := env.Messages.SendMessage(room.ID, alice.UserID, alice.Username, "picture", "", tc.url)
+			if tc.accepted && err != nil {
+				t.Errorf("rejected %s: %v", tc.url, err)
+			}
+			if !tc.accepted && (err == nil || err.Error() != "media URL is not hosted by this app") {
+				t.Errorf("%s got %v, want it rejected as not hosted by this app", tc.url, err)
+			}
+		})
+	}
+}
+
+func TestUpdateMessageMediaSwap(t *testing.T) {
+	// setup sends a picture message and uploads a replacement for it
+	setup := func(t *testing.T) (*testEnv, *fakeMediaStore, *models.Message, string) {
+		t.Helper()
+		env := newTestEnv(t, false)
+		store := newFakeMediaStore(-1)
+		env.Messages.Media = store
+		alice := env.createUser(t, "alice")
+		room := env.createChatroom(t, "General", alice)
+
+		oldURL, _ := store.UploadFile(fileHeader(t, "old.jpg", 16), utils.ImageMedia)
+		message, err := env.Messages.SendMessage(room.ID, alice.UserID, alice.Username, "picture", "", oldURL)
+		if err != nil {
+			t.Fatalf("SendMessage: %v", err)
+		}
+		newURL, _ := store.UploadFile(fileHeader(t, "new.jpg", 16), utils.ImageMedia)
+		return env, store, message, newURL
+	}
+	storedMediaURL := func(env *testEnv, id primitive.ObjectID) string {
+		for _, doc := range env.MongoDB.Documents("messages") {
+			if doc.Map()["_id"] == id {
+				url, _ := doc.Map()["media_url"].(string)
+				return url
+			}
+		}
+		return ""
+	}
+
+	t.Run("a successful swap deletes the old file", func(t *testing.T) {
+		env, store, message, newURL := setup(t)
+		if _, err := env.Messages.UpdateMessage(message.ID, message.SenderID, nil, &newURL, nil); err != nil {
+			t.Fatalf("UpdateMessage: %v", err)
+		}
+		if got := storedMediaURL(env, message.ID); got != newURL {
+			t.Errorf("message points at %q, want %q", got, newURL)
+		}
+		if store.stored[message.MediaURL] || !store.stored[newURL] {
+			t.Errorf("stored files %v, want only the replacement", store.stored)
+		}
+	})
+
+	t.Run("a failed update keeps the old file and discards the new one", func(t *testing.T) {
+		env, store, message, newURL := setup(t)
+		env.MongoDB.FailCommand("update", "messages", errors.New("write failed"))
+		if _, err := env.Messages.UpdateMessage(message.ID, message.SenderID, nil, &newURL, nil); err == nil {
+			t.Fatal("UpdateMessage succeeded with a failing write")
+		}
+		if got := storedMediaURL(env, message.ID); got != message.MediaURL {
+			t.Errorf("message points at %q, want the original %q", got, message.MediaURL)
+		}
+		if !store.stored[message.MediaURL] {
+			t.Error("the original file was deleted")
+		}
+		if store.stored[newURL] {
+			t.Error("the unused replacement was left behind")
+		}
+	})
+
+	t.Run("a failed update keeps a replacement other messages use", func(t *testing.T) {
+		env, store, message, _ := setup(t)
+		other, err := env.Messages.SendMessage(message.ChatroomID, message.SenderID, "alice", "picture", "", message.MediaURL)
+		if err != nil {
+			t.Fatalf("SendMessage: %v", err)
+		}
+		sharedURL, _ := store.UploadFile(fileHeader(t, "shared.jpg", 16), utils.ImageMedia)
+		if _, err := env.Messages.UpdateMessage(other.ID, other.SenderID, nil, &sharedURL, nil); err != nil {
+			t.Fatalf("UpdateMessage: %v", err)
+		}
+
+		env.MongoDB.FailCommand("update", "messages", errors.New("write failed"))
+		if _, err := env.Messages.UpdateMessage(message.ID, message.SenderID, nil, &sharedURL, nil); err == nil {
+			t.Fatal("UpdateMessage succeeded with a failing write")
+		}
+		if !store.stored[sharedURL] {
+			t.Error("a file another message uses was deleted")
+		}
+	})
+}
+
+func TestUpdateMessageAddingTextKeepsMediaKind(t *testing.T) {
+	for _, tc := range []struct {
+		messageType string
+		mediaType   utils.MediaType
+		file        string
+		wantType    string
+	}{
+		{"audio", utils.AudioMedia, "memo.mp3", "text_and_audio"},
+		{"video", utils.VideoMedia, "clip.mp4", "text_and_video"},
+	} {
+		t.Run(tc.messageType, func(t *testing.T) {
+			env := newTestEnv(t, false)
+			store := newFakeMediaStore(-1)
+			env.Messages.Media = store
+			alice := env.createUser(t, "alice")
+			room := env.createChatroom(t, "General", alice)
+
+			mediaURL, _ := store.UploadFile(fileHeader(t, tc.file, 16), tc.mediaType)
+			message, err := env.Messages.SendMessage(room.ID, alice.UserID, alice.Username, tc.messageType, "", mediaURL)
+			if err != nil {
+				t.Fatalf("SendMessage: %v", err)
+			}
+
+			caption := "check this out"
+			if _, err := env.Messages.UpdateMessage(message.ID, alice.UserID, &caption, nil, nil); err != nil {
+				t.Fatalf("UpdateMessage: %v", err)
+			}
+			var stored models.Message
+			if err := env.Messages.MsgColl.FindOne(context.Background(), bson.M{"_id": message.ID}).Decode(&stored); err != nil {
+				t.Fatalf("load edited message: %v", err)
+			}
+			if stored.MessageType != tc.wantType || stored.MediaKind != string(tc.mediaType) || stored.MediaURL != mediaURL {
+				t.Errorf("edited message is %q (kind %q, media %q), want %q (kind %q, media %q)",
+					stored.MessageType, stored.MediaKind, stored.MediaURL, tc.wantType, tc.mediaType, mediaURL)
+			}
+		})
+	}
+}
+
+func TestGetMediaCounts(t *testing.T) {
+	env := newTestEnv(t, false)
+	store := newFakeMediaStore(-1)
+	env.Messages.Media = store
+	alice, mallory := env.createUser(t, "alice"), env.createUser(t, "mallory")
+	room := env.createChatroom(t, "Gallery", alice)
+	other := env.createChatroom(t, "Elsewhere", alice)
+
+	send := func(chatroom *models.Chatroom, messageType, text, file string, mediaType utils.MediaType) {
+		t.Helper()
+		mediaURL := ""
+		if file != "" {
+			mediaURL, _ = store.UploadFile(fileHeader(t, file, 16), mediaType)
+		}
+		if _, err := env.Messages.SendMessage(chatroom.ID, alice.UserID, alice.Username, messageType, text, mediaURL); err != nil {
+			t.Fatalf("SendMessage %s: %v", messageType, err)
+		}
+	}
+	send(room, "picture", "", "a.jpg", utils.ImageMedia)
+	send(room, "text_and_picture", "look", "b.jpg", utils.ImageMedia)
+	send(room, "video", "", "c.mp4", utils.VideoMedia)
+	send(room, "audio", "", "d.mp3", utils.AudioMedia)
+	send(room, "text_and_audio", "listen", "e.mp3", utils.AudioMedia)
+	send(room, "text", "no media", "", "")
+	send(other, "picture", "", "f.jpg", utils.ImageMedia) // Another room's media isn't counted
+	// An album counts each of its items, by that item's kind
+	if _, err := env.Messages.SendMessageWithMedia(room.ID, alice.UserID, alice.Username, "trip", fileHeaders(t, "g.jpg", "h.png", "i.mp4")); err != nil {
+		t.Fatalf("SendMessageWithMedia: %v", err)
+	}
+
+	counts, err := env.Messages.GetMediaCounts(room.ID, alice.UserID)
+	if err != nil {
+		t.Fatalf("GetMediaCounts: %v", err)
+	}
+	if want := (MediaCountsResponse{Images: 4, Videos: 2, Audio: 2, Total: 8}); *counts != want {
+		t.Errorf("counts = %+v, want %+v", *counts, want)
+	}
+
+	if _, err := env.Messages.GetMediaCounts(room.ID, mallory.UserID); err == nil || err.Error() != "user is not a member of this chatroom" {
+		t.Errorf("non-member: err = %v, want not a member", err)
+	}
+}
+
+func TestGetUserMedia(t *testing.T) {
+	env := newTestEnv(t, false)
+	env.Messages.Media = newFakeMediaStore(-1)
+	alice, bob := env.createUser(t, "alice"), env.createUser(t, "bob")
+	general := env.createChatroom(t, "General", alice, bob)
+	holiday := env.createChatroom(t, "Holiday", alice)
+	left := env.createChatroom(t, "Left", bob, alice)
+
+	send := func(room *models.Chatroom, sender *models.User, text string, names ...string) *models.Message {
+		t.Helper()
+		message, err := env.Messages.SendMessageWithMedia(room.ID, sender.UserID, sender.Username, text, fileHeaders(t, names...))
+		if err != nil {
+			t.Fatalf("SendMessageWithMedia: %v", err)
+		}
+		return message
+	}
+	cat := send(general, alice, "", "cat.jpg")
+	album := send(holiday, alice, "beach day", "one.jpg", "wave.mp4")
+	send(left, alice, "", "gone.jpg")
+	env.sendText(t, general, alice, "no media here")
+	send(general, bob, "", "bobs.jpg")
+	if err := env.Chatrooms.LeaveChatroom(left.ID, alice.UserID); err != nil {
+		t.Fatalf("LeaveChatroom: %v", err)
+	}
+
+	type item struct{ message, room, url, kind string }
+	items := func(page *UserMediaResponse) []item {
+		var got []item
+		for _, i := range page.Items {
+			got = append(got, item{i.MessageID, i.ChatroomName, i.URL, i.MediaKind})
+		}
+		return got
+	}
+
+	// Only alice's own media, from rooms alice is still in, newest first
+	all, err := env.Messages.GetUserMedia(alice.UserID, 0, nil, "")
+	if err != nil {
+		t.Fatalf("GetUserMedia: %v", err)
+	}
+	want := []item{
+		{album.ID.Hex(), "Holiday", "https://media.test/image/one.jpg", "image"},
+		{album.ID.Hex(), "Holiday", "https://media.test/video/wave.mp4", "video"},
+		{cat.ID.Hex(), "General", "https://media.test/image/cat.jpg", "image"},
+	}
+	if got := items(all); !slices.Equal(got, want) || all.HasMore {
+		t.Errorf("media = %+v (has_more %t), want %+v", got, all.HasMore, want)
+	}
+
+	// Pages count messages, so an album stays on one page. Rooms can share a send time,
+	// so paging must not skip the cat picture even when it was sent in the same millisecond.
+	sameTime := album.SentAt
+	if _, err := env.Messages.MsgColl.UpdateByID(context.Background(), cat.ID, bson.M{"$set": bson.M{"sent_at": sameTime}}); err != nil {
+		t.Fatalf("align send times: %v", err)
+	}
+	if cat.ID.Hex() > album.ID.Hex() {
+		want = append(want[2:], want[:2]...) // Ties go to the higher ID first
+	}
+	first, err := env.Messages.GetUserMedia(alice.UserID, 1, nil, "")
+	if err != nil {
+		t.Fatalf("GetUserMedia: %v", err)
+	}
+	if first.NextCursor == nil || !first.HasMore {
+		t.Fatalf("first page = %+v, want more to come", first)
+	}
+	second, err := env.Messages.GetUserMedia(alice.UserID, 1, nil, *first.NextCursor)
+	if err != nil {
+		t.Fatalf("GetUserMedia: %v", err)
+	}
+	if got := append(items(first), items(second)...); !slices.Equal(got, want) || second.HasMore {
+		t.Errorf("pages = %+v (has_more %t), want %+v", got, second.HasMore, want)
+	}
+
+	// before starts from a point in time instead
+	earlier, err := env.Messages.GetUserMedia(alice.UserID, 0, &sameTime, "")
+	if err != nil {
+		t.Fatalf("GetUserMedia: %v", err)
+	}
+	if len(earlier.Items) != 0 {
+		t.Errorf("media before %v = %+v, want none", sameTime, earlier.Items)
+	}
+
+	if _, err := env.Messages.GetUserMedia(alice.UserID, 1, nil, "not-a-cursor"); err == nil || err.Error() != "invalid cursor" {
+		t.Errorf("bad cursor: err = %v, want invalid cursor", err)
+	}
+}
@@ -2,11 +2,18 @@ package services
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ginchat/models"
+	"github.com/ginchat/utils"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -22,10 +29,13 @@ type MessageReadStatusService struct {
 	ChatroomColl     *mongo.Collection
 	ChatroomService  *ChatroomService
 	UserService      *UserService
+
+	readPointerTracking bool // Unread state from last-read pointers instead of per-recipient rows
+	reconcileBatchSize  int  // Records scanned per query by the orphan reconciler
 }
 
 // NewMessageReadStatusService creates a new MessageReadStatusService
-func NewMessageReadStatusService(mongodb *mongo.Database, chatroomService *ChatroomService, userService *UserService) *MessageReadStatusService {
+func NewMessageReadStatusService(mongodb *mongo.Database, chatroomService *ChatroomService, userService *UserService, readPointerTracking bool, reconcileBatchSize int) *MessageReadStatusService {
 	return &MessageReadStatusService{
 		MongoDB:          mongodb,
 		ReadStatusColl:   mongodb.Collection("message_read_status"),
@@ -34,11 +44,19 @@ func NewMessageReadStatusService(mongodb *mongo.Database, chatroomService *Chatr
 		ChatroomColl:     mongodb.Collection("chatrooms"),
 		ChatroomService:  chatroomService,
 		UserService:      userService,
+
+		readPointerTracking: readPointerTracking,
+		reconcileBatchSize:  reconcileBatchSize,
 	}
 }
 
-// CreateReadStatusForMessage creates read status entries for all chatroom members when a message is sent
+// CreateReadStatusForMessage creates read status entries for all chatroom members when a message is sent.
+// With pointer tracking there is nothing to write: new messages are unread until members' pointers pass them.
 func (s *MessageReadStatusService) CreateReadStatusForMessage(messageID primitive.ObjectID, chatroomID primitive.ObjectID, senderID uint) error {
+	if s.readPointerTracking {
+		return nil
+	}
+
 	// Get chatroom to find all members
 	chatroom, err := s.ChatroomService.GetChatroomByID(chatroomID)
 	if err != nil {
@@ -78,6 +96,11 @@ func (s *MessageReadStatusService) CreateReadStatusForMessage(messageID primitiv
 
 // MarkMessageAsRead marks a message as read by a specific user
 func (s *MessageReadStatusService) MarkMessageAsRead(messageID primitive.ObjectID, userID uint) error {
+	if s.readPointerTracking {
+		_, err := s.markMessageReadByPointer(messageID, userID, nil)
+		return err
+	}
+
 	now := time.Now()
 
 	// Get the message to find the chatroom
@@ -108,6 +131,7 @@ func (s *MessageReadStatusService) MarkMessageAsRead(messageID primitive.ObjectI
 	if result.MatchedCount == 0 {
 		return errors.New("read status not found")
 	}
+	utils.ReadStatusOperationsTotal.WithLabelValues("mark_read").Inc()
 
 	// Update user's last read message for the chatroom
 	err = s.UpdateUserLastRead(messageID, userID)
@@ -124,7 +148,15 @@ func (s *MessageReadStatusService) MarkMessageAsRead(messageID primitive.ObjectI
 // MarkMessageAsReadOptimized marks a message as read by a specific user (optimized version)
 // Returns the chatroom ID to avoid additional database queries
 func (s *MessageReadStatusService) MarkMessageAsReadOptimized(messageID primitive.ObjectID, userID uint) (primitive.ObjectID, error) {
-	now := time.Now()
+	return s.MarkMessageAsReadAt(messageID, userID, nil)
+}
+
+// MarkMessageAsReadAt is MarkMessageAsReadOptimized with the time the user actually read the message,
+// for clients syncing reads made while offline. A nil readAt means now.
+func (s *MessageReadStatusService) MarkMessageAsReadAt(messageID primitive.ObjectID, userID uint, readAt *time.Time) (primitive.ObjectID, error) {
+	if s.readPointerTracking {
+		return s.markMessageReadByPointer(messageID, userID, readAt)
+	}
 
 	// Get the message and chatroom ID in a single query
 	var message models.Message
@@ -133,6 +165,11 @@ func (s *MessageReadStatusService) MarkMessageAsReadOptimized(messageID primitiv
 		return primitive.NilObjectID, errors.New("message not found")
 	}
 
+	now, err := resolveReadTime(readAt, message.SentAt)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
 	// Update the read status
 	filter := bson.M{
 		"message_id":   messageID,
@@ -154,10 +191,11 @@ func (s *MessageReadStatusService) MarkMessageAsReadOptimized(messageID primitiv
 	if result.MatchedCount == 0 {
 		return primitive.NilObjectID, errors.New("read status not found")
 	}
+	utils.ReadStatusOperationsTotal.WithLabelValues("mark_read").Inc()
 
 	// Update user's last read message for the chatroom (async to avoid blocking)
 	go func() {
-		err = s.UpdateUserLastRead(messageID, userID)
+		err := s.updateUserLastReadAt(messageID, userID, now)
 		if err != nil {
 			// Log error but don't fail the operation
 			// This is not critical for the read status update
@@ -167,8 +205,147 @@ func (s *MessageReadStatusService) MarkMessageAsReadOptimized(messageID primitiv
 	return message.ChatroomID, nil
 }
 
-// UpdateUserLastRead updates the last read message for a user in a chatroom
+// resolveReadTime returns when a message was read: readAt if the client gave one, otherwise now.
+// A client-provided time must fall between the message being sent and now.
+func resolveReadTime(readAt *time.Time, sentAt time.Time) (time.Time, error) {
+	now := time.Now()
+	if readAt == nil {
+		return now, nil
+	}
+	if readAt.After(now) {
+		return time.Time{}, errors.New("read time is in the future")
+	}
+	if readAt.Before(sentAt) {
+		return time.Time{}, errors.New("read time is before the message was sent")
+	}
+	return *readAt, nil
+}
+
+// MaxMarkReadBatch is the most message IDs one MarkMessagesAsRead call accepts
+const MaxMarkReadBatch = 500
+
+// MarkMessagesAsReadResult splits the IDs passed to MarkMessagesAsRead by outcome
+type MarkMessagesAsReadResult struct {
+	Marked      []primitive.ObjectID // Now read
+	AlreadyRead []primitive.ObjectID // Were already read by the user
+	NotFound    []primitive.ObjectID // No read status for the user: unknown message, or the user isn't a recipient
+}
+
+// MarkMessagesAsRead marks a batch of messages read for a user with a single UpdateMany.
+// The user's read statuses are looked up first so each ID can be reported as marked, already read or not found.
+func (s *MessageReadStatusService) MarkMessagesAsRead(messageIDs []primitive.ObjectID, userID uint) (*MarkMessagesAsReadResult, error) {
+	return s.MarkMessagesAsReadAt(messageIDs, userID, nil)
+}
+
+// MarkMessagesAsReadAt is MarkMessagesAsRead with a client-provided read time shared by the whole batch
+// (nil means now). It must not be before any of the messages being marked was sent; nothing is marked if it is.
+func (s *MessageReadStatusService) MarkMessagesAsReadAt(messageIDs []primitive.ObjectID, userID uint, readAt *time.Time) (*MarkMessagesAsReadResult, error) {
+	if s.readPointerTracking {
+		return s.markMessagesReadByPointer(messageIDs, userID, readAt)
+	}
+
+	ctx := context.Background()
+	result := &MarkMessagesAsReadResult{}
+	if len(messageIDs) == 0 {
+		return result, nil
+	}
+
+	cursor, err := s.ReadStatusColl.Find(ctx, bson.M{
+		"message_id":   bson.M{"$in": messageIDs},
+		"recipient_id": userID,
+	}, options.Find().SetProjection(bson.M{"message_id": 1, "chatroom_id": 1, "is_read": 1, "created_at": 1}))
+	if err != nil {
+		return nil, errors.New("failed to get read statuses")
+	}
+	var statuses []models.MessageReadStatus
+	if err := cursor.All(ctx, &statuses); err != nil {
+		return nil, errors.New("failed to get read statuses")
+	}
+
+	statusByMessage := make(map[primitive.ObjectID]models.MessageReadStatus, len(statuses))
+	for _, status := range statuses {
+		statusByMessage[status.MessageID] = status
+	}
+
+	// Newest message being marked in each chatroom, to move the user's last read marker
+	latestByChatroom := make(map[primitive.ObjectID]models.MessageReadStatus)
+	seen := make(map[primitive.ObjectID]bool, len(messageIDs))
+	for _, messageID := range messageIDs {
+		if seen[messageID] {
+			continue
+		}
+		seen[messageID] = true
+
+		status, ok := statusByMessage[messageID]
+		switch {
+		case !ok:
+			result.NotFound = append(result.NotFound, messageID)
+		case status.IsRead:
+			result.AlreadyRead = append(result.AlreadyRead, messageID)
+		default:
+			result.Marked = append(result.Marked, messageID)
+			if latest, ok := latestByChatroom[status.ChatroomID]; !ok || status.CreatedAt.After(latest.CreatedAt) {
+				latestByChatroom[status.ChatroomID] = status
+			}
+		}
+	}
+
+	if len(result.Marked) == 0 {
+		return result, nil
+	}
+
+	newestSentAt := time.Time{}
+	if readAt != nil {
+		if newestSentAt, err = s.newestSentAt(ctx, result.Marked); err != nil {
+			return nil, err
+		}
+	}
+	now, err := resolveReadTime(readAt, newestSentAt)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.ReadStatusColl.UpdateMany(ctx, bson.M{
+		"message_id":   bson.M{"$in": result.Marked},
+		"recipient_id": userID,
+		"is_read":      false,
+	}, bson.M{
+		"$set": bson.M{
+			"is_read": true,
+			"read_at": now,
+		},
+	})
+	if err != nil {
+		return nil, errors.New("failed to mark messages as read")
+	}
+	utils.ReadStatusOperationsTotal.WithLabelValues("mark_read").Add(float64(len(result.Marked)))
+
+	// Not critical for the read statuses themselves, so failures are ignored
+	for _, status := range latestByChatroom {
+		_ = s.updateUserLastReadAt(status.MessageID, userID, now)
+	}
+
+	return result, nil
+}
+
+// newestSentAt returns the send time of the most recent of the messages
+func (s *MessageReadStatusService) newestSentAt(ctx context.Context, messageIDs []primitive.ObjectID) (time.Time, error) {
+	var message models.Message
+	opts := options.FindOne().SetSort(bson.M{"sent_at": -1}).SetProjection(bson.M{"sent_at": 1})
+	if err := s.MessageColl.FindOne(ctx, bson.M{"_id": bson.M{"$in": messageIDs}}, opts).Decode(&message); err != nil {
+		return time.Time{}, errors.New("message not found")
+	}
+	return message.SentAt, nil
+}
+
+// UpdateUserLastRead updates the last read message for a user in a chatroom.
+// With pointer tracking the pointer only moves forward, since it defines what is read.
 func (s *MessageReadStatusService) UpdateUserLastRead(messageID primitive.ObjectID, userID uint) error {
+	return s.updateUserLastReadAt(messageID, userID, time.Now())
+}
+
+// updateUserLastReadAt is UpdateUserLastRead recording readAt as the time of the read
+func (s *MessageReadStatusService) updateUserLastReadAt(messageID primitive.ObjectID, userID uint, readAt time.Time) error {
 	// Get the message to find the chatroom
 	var message models.Message
 	err := s.MessageColl.FindOne(context.Background(), bson.M{"_id": messageID}).Decode(&message)
@@ -176,6 +353,10 @@ func (s *MessageReadStatusService) UpdateUserLastRead(messageID primitive.Object
 		return errors.New("message not found")
 	}
 
+	if s.readPointerTracking {
+		return s.advanceLastRead(context.Background(), &message, userID, readAt)
+	}
+
 	now := time.Now()
 
 	// Upsert user last read
@@ -187,7 +368,8 @@ func (s *MessageReadStatusService) UpdateUserLastRead(messageID primitive.Object
 	update := bson.M{
 		"$set": bson.M{
 			"message_id": messageID,
-			"read_at":    now,
+			"sent_at":    message.SentAt,
+			"read_at":    readAt,
 			"updated_at": now,
 		},
 		"$setOnInsert": bson.M{
@@ -206,9 +388,23 @@ func (s *MessageReadStatusService) UpdateUserLastRead(messageID primitive.Object
 	return nil
 }
 
-// GetMessageReadStatus gets read status for a specific message
-func (s *MessageReadStatusService) GetMessageReadStatus(messageID primitive.ObjectID) ([]models.ReadInfo, error) {
-	// Find all read statuses for the message
+// ReadReceiptsEnabled reports whether a chatroom shows per-member read details. Read status is still
+// tracked when they are off (unread counts depend on it); only who-read-what is hidden.
+func (s *MessageReadStatusService) ReadReceiptsEnabled(chatroomID primitive.ObjectID) bool {
+	var chatroom models.Chatroom
+	opts := options.FindOne().SetProjection(bson.M{"read_receipts_enabled": 1})
+	if err := s.ChatroomColl.FindOne(context.Background(), bson.M{"_id": chatroomID}, opts).Decode(&chatroom); err != nil {
+		return true
+	}
+	return chatroom.ReadReceiptsEnabled()
+}
+
+// readStatusesForMessage loads every read status of a message, from its rows or derived from pointers
+func (s *MessageReadStatusService) readStatusesForMessage(messageID primitive.ObjectID) ([]models.MessageReadStatus, error) {
+	if s.readPointerTracking {
+		return s.readStatusesByPointer(messageID)
+	}
+
 	cursor, err := s.ReadStatusColl.Find(context.Background(), bson.M{"message_id": messageID})
 	if err != nil {
 		return nil, errors.New("failed to get read statuses")
@@ -219,21 +415,26 @@ func (s *MessageReadStatusService) GetMessageReadStatus(messageID primitive.Obje
 	if err := cursor.All(context.Background(), &readStatuses); err != nil {
 		return nil, errors.New("failed to decode read statuses")
 	}
+	return readStatuses, nil
+}
+
+// GetMessageReadStatus gets read status for a specific message; it is empty when the chatroom has read receipts off
+func (s *MessageReadStatusService) GetMessageReadStatus(messageID primitive.ObjectID) ([]models.ReadInfo, error) {
+	readStatuses, err := s.readStatusesForMessage(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if len(readStatuses) > 0 && !s.ReadReceiptsEnabled(readStatuses[0].ChatroomID) {
+		return []models.ReadInfo{}, nil
+	}
 
 	// Convert to ReadInfo format with usernames
+	usernames := s.recipientUsernames(readStatuses)
 	var readInfos []models.ReadInfo
 	for _, status := range readStatuses {
-		// Get username from user service
-		username := fmt.Sprintf("User %d", status.RecipientID) // Default fallback
-		if s.UserService != nil {
-			if user, err := s.UserService.GetUserByID(status.RecipientID); err == nil {
-				username = user.Username
-			}
-		}
-
 		readInfo := models.ReadInfo{
 			UserID:   status.RecipientID,
-			Username: username,
+			Username: usernames[status.RecipientID],
 			IsRead:   status.IsRead,
 			ReadAt:   status.ReadAt,
 		}
@@ -243,6 +444,215 @@ func (s *MessageReadStatusService) GetMessageReadStatus(messageID primitive.Obje
 	return readInfos, nil
 }
 
+// recipientUsernames looks up the usernames of the statuses' recipients in one query. Every recipient
+// has an entry; users that can't be found get a "User <id>" fallback.
+func (s *MessageReadStatusService) recipientUsernames(statuses []models.MessageReadStatus) map[uint]string {
+	recipientIDs := make([]uint, 0, len(statuses))
+	for _, status := range statuses {
+		recipientIDs = append(recipientIDs, status.RecipientID)
+	}
+
+	usernames := map[uint]string{}
+	if s.UserService != nil && len(recipientIDs) > 0 {
+		found, err := s.UserService.GetUsernames(recipientIDs)
+		if err != nil {
+			log.Printf("Failed to look up usernames for read statuses: %v", err)
+		} else {
+			usernames = found
+		}
+	}
+	for _, id := range recipientIDs {
+		if _, ok := usernames[id]; !ok {
+			usernames[id] = fmt.Sprintf("User %d", id)
+		}
+	}
+	return usernames
+}
+
+// MaxReadStatusBatch is the most messages one read status batch request may ask about
+const MaxReadStatusBatch = 200
+
+// GetMessageReadStatusBatch gets the read status of many messages at once, keyed by message ID.
+// It costs one query for the statuses, one for the chatrooms' read receipt settings and one for
+// usernames, however many messages there are. Messages without read statuses have no entry, and
+// those in rooms with read receipts off get an empty list, as with GetMessageReadStatus.
+func (s *MessageReadStatusService) GetMessageReadStatusBatch(messageIDs []primitive.ObjectID) (map[primitive.ObjectID][]models.ReadInfo, error) {
+	result := make(map[primitive.ObjectID][]models.ReadInfo, len(messageIDs))
+	if len(messageIDs) == 0 {
+		return result, nil
+	}
+
+	statusesByMessage, err := s.readStatusesForMessages(messageIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	chatroomIDs := make([]primitive.ObjectID, 0)
+	recipientIDs := make([]uint, 0)
+	seenRecipients := make(map[uint]bool)
+	for _, statuses := range statusesByMessage {
+		if len(statuses) > 0 {
+			chatroomIDs = append(chatroomIDs, statuses[0].ChatroomID)
+		}
+		for _, status := range statuses {
+			if !seenRecipients[status.RecipientID] {
+				seenRecipients[status.RecipientID] = true
+				recipientIDs = append(recipientIDs, status.RecipientID)
+			}
+		}
+	}
+
+	receiptsOff, err := s.chatroomsWithoutReadReceipts(chatroomIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	usernames := map[uint]string{}
+	if s.UserService != nil {
+		if found, err := s.UserService.GetUsernames(recipientIDs); err == nil {
+			usernames = found
+		}
+	}
+
+	for messageID, statuses := range statusesByMessage {
+		if len(statuses) == 0 {
+			continue
+		}
+		if receiptsOff[statuses[0].ChatroomID] {
+			result[messageID] = []models.ReadInfo{}
+			continue
+		}
+		readInfos := make([]models.ReadInfo, 0, len(statuses))
+		for _, status := range statuses {
+			username, ok := usernames[status.RecipientID]
+			if !ok {
+				username = fmt.Sprintf("User %d", status.RecipientID) // Same fallback as GetMessageReadStatus
+			}
+			readInfos = append(readInfos, models.ReadInfo{
+				UserID:   status.RecipientID,
+				Username: username,
+				IsRead:   status.IsRead,
+				ReadAt:   status.ReadAt,
+			})
+		}
+		result[messageID] = readInfos
+	}
+
+	return result, nil
+}
+
+// GetMessageReadStatusBatchForUser is GetMessageReadStatusBatch for a caller who may only see read
+// receipts in their own chatrooms: messages in rooms the user isn't a member of are left out, the same
+// as unknown messages, so the result doesn't reveal that they exist.
+func (s *MessageReadStatusService) GetMessageReadStatusBatchForUser(messageIDs []primitive.ObjectID, userID uint) (map[primitive.ObjectID][]models.ReadInfo, error) {
+	visibleIDs, err := s.messagesVisibleTo(messageIDs, userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetMessageReadStatusBatch(visibleIDs)
+}
+
+// messagesVisibleTo returns the messages among messageIDs that belong to chatrooms the user is a member of
+func (s *MessageReadStatusService) messagesVisibleTo(messageIDs []primitive.ObjectID, userID uint) ([]primitive.ObjectID, error) {
+	if len(messageIDs) == 0 {
+		return nil, nil
+	}
+	ctx := context.Background()
+
+	cursor, err := s.MessageColl.Find(ctx, bson.M{"_id": bson.M{"$in": messageIDs}},
+		options.Find().SetProjection(bson.M{"_id": 1, "chatroom_id": 1}))
+	if err != nil {
+		return nil, errors.New("failed to get read statuses")
+	}
+	var messages []models.Message
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, errors.New("failed to get read statuses")
+	}
+	if len(messages) == 0 {
+		return nil, nil
+	}
+
+	chatroomIDs := make([]primitive.ObjectID, 0, len(messages))
+	for _, message := range messages {
+		chatroomIDs = append(chatroomIDs, message.ChatroomID)
+	}
+	memberCursor, err := s.ChatroomColl.Find(ctx,
+		bson.M{"_id": bson.M{"$in": chatroomIDs}, "members.user_id": userID},
+		options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, errors.New("failed to check chatroom membership")
+	}
+	var memberOf []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := memberCursor.All(ctx, &memberOf); err != nil {
+		return nil, errors.New("failed to check chatroom membership")
+	}
+	isMember := make(map[primitive.ObjectID]bool, len(memberOf))
+	for _, chatroom := range memberOf {
+		isMember[chatroom.ID] = true
+	}
+
+	visible := make([]primitive.ObjectID, 0, len(messages))
+	for _, message := range messages {
+		if isMember[message.ChatroomID] {
+			visible = append(visible, message.ID)
+		}
+	}
+	return visible, nil
+}
+
+// readStatusesForMessages loads the read statuses of several messages in one go, grouped by message
+func (s *MessageReadStatusService) readStatusesForMessages(messageIDs []primitive.ObjectID) (map[primitive.ObjectID][]models.MessageReadStatus, error) {
+	if s.readPointerTracking {
+		return s.readStatusesByPointerBatch(messageIDs)
+	}
+
+	ctx := context.Background()
+	cursor, err := s.ReadStatusColl.Find(ctx, bson.M{"message_id": bson.M{"$in": messageIDs}},
+		options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, errors.New("failed to get read statuses")
+	}
+	defer cursor.Close(ctx)
+
+	var readStatuses []models.MessageReadStatus
+	if err := cursor.All(ctx, &readStatuses); err != nil {
+		return nil, errors.New("failed to decode read statuses")
+	}
+
+	grouped := make(map[primitive.ObjectID][]models.MessageReadStatus, len(messageIDs))
+	for _, status := range readStatuses {
+		grouped[status.MessageID] = append(grouped[status.MessageID], status)
+	}
+	return grouped, nil
+}
+
+// chatroomsWithoutReadReceipts returns which of the chatrooms have read receipts turned off
+func (s *MessageReadStatusService) chatroomsWithoutReadReceipts(chatroomIDs []primitive.ObjectID) (map[primitive.ObjectID]bool, error) {
+	off := make(map[primitive.ObjectID]bool)
+	if len(chatroomIDs) == 0 {
+		return off, nil
+	}
+
+	cursor, err := s.ChatroomColl.Find(context.Background(),
+		bson.M{"_id": bson.M{"$in": chatroomIDs}, "read_receipts_enabled": false},
+		options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, errors.New("failed to get read statuses")
+	}
+	var chatrooms []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(context.Background(), &chatrooms); err != nil {
+		return nil, errors.New("failed to get read statuses")
+	}
+	for _, chatroom := range chatrooms {
+		off[chatroom.ID] = true
+	}
+	return off, nil
+}
+
 // GetUserLastReadForChatroom gets the last read message for a user in a chatroom
 func (s *MessageReadStatusService) GetUserLastReadForChatroom(chatroomID primitive.ObjectID, userID uint) (*models.UserLastRead, error) {
 	var lastRead models.UserLastRead
@@ -261,12 +671,30 @@ func (s *MessageReadStatusService) GetUserLastReadForChatroom(chatroomID primiti
 	return &lastRead, nil
 }
 
+// MaxUnreadCountFilter is the most chatrooms GetUnreadCountForChatrooms accepts in one call
+const MaxUnreadCountFilter = 200
+
 // GetUnreadCountForUser gets unread message count for all chatrooms for a user (optimized)
 func (s *MessageReadStatusService) GetUnreadCountForUser(userID uint) ([]models.ChatroomUnreadCount, error) {
-	// First, let's get all chatrooms the user is a member of
-	chatroomCursor, err := s.ChatroomColl.Find(context.Background(), bson.M{
+	return s.unreadCountsForChatrooms(userID, bson.M{"members.user_id": userID})
+}
+
+// GetUnreadCountForChatrooms gets unread message counts for just the given chatrooms, e.g. the rooms in a folder.
+// Chatrooms the user doesn't belong to (or that don't exist) are left out rather than reported as errors.
+func (s *MessageReadStatusService) GetUnreadCountForChatrooms(userID uint, chatroomIDs []primitive.ObjectID) ([]models.ChatroomUnreadCount, error) {
+	if len(chatroomIDs) == 0 {
+		return []models.ChatroomUnreadCount{}, nil
+	}
+	return s.unreadCountsForChatrooms(userID, bson.M{
+		"_id":             bson.M{"$in": chatroomIDs},
 		"members.user_id": userID,
 	})
+}
+
+// unreadCountsForChatrooms counts the user's unread messages in every chatroom matching chatroomFilter,
+// with one aggregation over their read statuses. The filter must only match chatrooms the user belongs to.
+func (s *MessageReadStatusService) unreadCountsForChatrooms(userID uint, chatroomFilter bson.M) ([]models.ChatroomUnreadCount, error) {
+	chatroomCursor, err := s.ChatroomColl.Find(context.Background(), chatroomFilter)
 	if err != nil {
 		return []models.ChatroomUnreadCount{}, nil // Return empty array instead of error
 	}
@@ -290,55 +718,155 @@ func (s *MessageReadStatusService) GetUnreadCountForUser(userID uint) ([]models.
 		chatroomIDs = append(chatroomIDs, chatroom.ID)
 	}
 
+	// One aggregation for all the rooms instead of a query per room
+	var unreadMap map[string]int64
+	var firstUnreadMap, lastReadMap map[string]string
+	if s.readPointerTracking {
+		unreadMap, firstUnreadMap, lastReadMap, err = s.unreadByPointer(context.Background(), userID, chatroomIDs)
+	} else {
+		joinedAt := make(map[primitive.ObjectID]time.Time, len(userChatrooms))
+		for _, chatroom := range userChatrooms {
+			for _, member := range chatroom.Members {
+				if member.UserID == userID {
+					joinedAt[chatroom.ID] = member.JoinedAt
+				}
+			}
+		}
+		unreadMap, firstUnreadMap, lastReadMap, err = s.unreadByRows(context.Background(), userID, joinedAt)
+	}
+	if err != nil {
+		return []models.ChatroomUnreadCount{}, nil // Return empty array instead of error
+	}
+
+	// Build final result with all chatrooms (including 0 counts)
+	var unreadCounts []models.ChatroomUnreadCount
+	for _, chatroom := range userChatrooms {
+		count := unreadMap[chatroom.ID.Hex()] // Will be 0 if not found
+		unreadCount := models.ChatroomUnreadCount{
+			ChatroomID:           chatroom.ID.Hex(),
+			ChatroomName:         chatroom.Name,
+			UnreadCount:          count,
+			LastReadMessageID:    lastReadMap[chatroom.ID.Hex()],
+			FirstUnreadMessageID: firstUnreadMap[chatroom.ID.Hex()],
+		}
+		unreadCounts = append(unreadCounts, unreadCount)
+	}
+
+	return unreadCounts, nil
+}
+
+// unreadByRows counts the user's unread read-status rows per chatroom, finds the first unread message
+// of each and collects their last-read pointers. joinedAt maps each chatroom to when the user joined it.
+func (s *MessageReadStatusService) unreadByRows(ctx context.Context, userID uint, joinedAt map[primitive.ObjectID]time.Time) (map[string]int64, map[string]string, map[string]string, error) {
+	chatroomIDs := make([]primitive.ObjectID, 0, len(joinedAt))
+	rooms := make([]bson.M, 0, len(joinedAt))
+	for chatroomID, at := range joinedAt {
+		chatroomIDs = append(chatroomIDs, chatroomID)
+		rooms = append(rooms, unreadRowsFilter(chatroomID, userID, at))
+	}
+	if len(rooms) == 0 {
+		return map[string]int64{}, map[string]string{}, map[string]string{}, nil
+	}
+
 	// Use aggregation pipeline for better performance (single query instead of N queries)
 	pipeline := []bson.M{
+		{"$match": bson.M{"$or": rooms}},
+		// ObjectIDs follow insertion order, not sent_at, so order by each message's time as the chat shows it
 		{
-			"$match": bson.M{
-				"chatroom_id":  bson.M{"$in": chatroomIDs},
-				"recipient_id": userID,
-				"is_read":      false,
+			"$lookup": bson.M{
+				"from": "messages",
+				"let":  bson.M{"message_id": "$message_id"},
+				"pipeline": bson.A{
+					bson.M{"$match": bson.M{"$expr": bson.M{"$eq": bson.A{"$_id", "$$message_id"}}}},
+					bson.M{"$project": bson.M{"sent_at": 1}},
+				},
+				"as": "message",
 			},
 		},
+		{"$sort": bson.D{{Key: "message.sent_at", Value: 1}, {Key: "message_id", Value: 1}}},
 		{
 			"$group": bson.M{
-				"_id":   "$chatroom_id",
-				"count": bson.M{"$sum": 1},
+				"_id":          "$chatroom_id",
+				"count":        bson.M{"$sum": 1},
+				"first_unread": bson.M{"$first": "$message_id"},
 			},
 		},
 	}
 
-	cursor, err := s.ReadStatusColl.Aggregate(context.Background(), pipeline)
+	cursor, err := s.ReadStatusColl.Aggregate(ctx, pipeline)
 	if err != nil {
-		return []models.ChatroomUnreadCount{}, nil // Return empty array instead of error
+		return nil, nil, nil, errors.New("failed to get unread count")
 	}
-	defer cursor.Close(context.Background())
+	defer cursor.Close(ctx)
 
-	// Create result map
+	// Create result maps
 	unreadMap := make(map[string]int64)
-	for cursor.Next(context.Background()) {
+	firstUnreadMap := make(map[string]string)
+	for cursor.Next(ctx) {
 		var result struct {
-			ID    primitive.ObjectID `bson:"_id"`
-			Count int64              `bson:"count"`
+			ID          primitive.ObjectID `bson:"_id"`
+			Count       int64              `bson:"count"`
+			FirstUnread primitive.ObjectID `bson:"first_unread"`
 		}
 		if err := cursor.Decode(&result); err != nil {
 			continue
 		}
 		unreadMap[result.ID.Hex()] = result.Count
+		firstUnreadMap[result.ID.Hex()] = result.FirstUnread.Hex()
 	}
 
-	// Build final result with all chatrooms (including 0 counts)
-	var unreadCounts []models.ChatroomUnreadCount
-	for _, chatroom := range userChatrooms {
-		count := unreadMap[chatroom.ID.Hex()] // Will be 0 if not found
-		unreadCount := models.ChatroomUnreadCount{
-			ChatroomID:   chatroom.ID.Hex(),
-			ChatroomName: chatroom.Name,
-			UnreadCount:  count,
+	// Get last read pointers for all chatrooms in a single query
+	lastReadMap := make(map[string]string)
+	lastReadCursor, err := s.UserLastReadColl.Find(ctx, bson.M{
+		"user_id":     userID,
+		"chatroom_id": bson.M{"$in": chatroomIDs},
+	})
+	if err == nil {
+		var lastReads []models.UserLastRead
+		if err := lastReadCursor.All(ctx, &lastReads); err == nil {
+			for _, lastRead := range lastReads {
+				lastReadMap[lastRead.ChatroomID.Hex()] = lastRead.MessageID.Hex()
+			}
 		}
-		unreadCounts = append(unreadCounts, unreadCount)
 	}
 
-	return unreadCounts, nil
+	return unreadMap, firstUnreadMap, lastReadMap, nil
+}
+
+// unreadRowsFilter matches the user's unread read-status rows in a chatroom. Rows are written as messages
+// are sent, so one created before joinedAt is left over from an earlier membership: the history a member
+// (re)joins into counts as read. The zero joinedAt matches every unread row.
+func unreadRowsFilter(chatroomID primitive.ObjectID, userID uint, joinedAt time.Time) bson.M {
+	filter := bson.M{
+		"chatroom_id":  chatroomID,
+		"recipient_id": userID,
+		"is_read":      false,
+	}
+	if !joinedAt.IsZero() {
+		filter["created_at"] = bson.M{"$gte": joinedAt}
+	}
+	return filter
+}
+
+// memberJoinTimes returns when the user joined each of the chatrooms; ones they aren't a member of are left out
+func (s *MessageReadStatusService) memberJoinTimes(ctx context.Context, userID uint, chatroomIDs []primitive.ObjectID) (map[primitive.ObjectID]time.Time, error) {
+	cursor, err := s.ChatroomColl.Find(ctx,
+		bson.M{"_id": bson.M{"$in": chatroomIDs}, "members.user_id": userID},
+		options.Find().SetProjection(bson.M{"members": bson.M{"$elemMatch": bson.M{"user_id": userID}}}))
+	if err != nil {
+		return nil, errors.New("failed to check chatroom membership")
+	}
+	var chatrooms []models.Chatroom
+	if err := cursor.All(ctx, &chatrooms); err != nil {
+		return nil, errors.New("failed to check chatroom membership")
+	}
+	joinedAt := make(map[primitive.ObjectID]time.Time, len(chatrooms))
+	for _, chatroom := range chatrooms {
+		if len(chatroom.Members) > 0 {
+			joinedAt[chatroom.ID] = chatroom.Members[0].JoinedAt
+		}
+	}
+	return joinedAt, nil
 }
 
 // GetLatestMessageForChatrooms gets the latest message for each chatroom the user has joined
@@ -349,108 +877,392 @@ func (s *MessageReadStatusService) GetLatestMessageForChatrooms(userID uint) ([]
 		return nil, errors.New("failed to get user chatrooms")
 	}
 
-	var latestMessages []models.LatestChatMessage
+	latestByChatroom, err := s.latestMessagesByChatroom(context.Background(), userChatrooms)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, chatroom := range userChatrooms {
-		// Get latest message for this chatroom
-		var message models.Message
-		opts := options.FindOne().SetSort(bson.D{{Key: "sent_at", Value: -1}})
-		err := s.MessageColl.FindOne(context.Background(), bson.M{"chatroom_id": chatroom.ID}, opts).Decode(&message)
+	latestMessages := make([]models.LatestChatMessage, 0, len(userChatrooms))
+	for i := range userChatrooms {
+		chatroom := &userChatrooms[i]
+		latestMessages = append(latestMessages, s.buildLatestChatMessage(chatroom, latestByChatroom[chatroom.ID]))
+	}
+
+	return latestMessages, nil
+}
+
+// Page sizes for GetLatestMessagesPage
+const (
+	DefaultLatestMessagesLimit = 50
+	MaxLatestMessagesLimit     = 200
+)
 
+// LatestMessagesQuery selects one page of chatrooms for GetLatestMessagesPage
+type LatestMessagesQuery struct {
+	Limit      int    // Chatrooms per page (defaults to DefaultLatestMessagesLimit, capped at MaxLatestMessagesLimit)
+	Cursor     string // next_cursor from the previous page; empty for the first page
+	UnreadOnly bool   // Only include chatrooms with unread messages
+}
+
+// LatestMessagesPage is a page of chatrooms with their latest message, most recently active first
+type LatestMessagesPage struct {
+	Chatrooms  []models.LatestChatMessage `json:"chatrooms"`
+	HasMore    bool                       `json:"has_more"`              // Whether more chatrooms follow this page
+	NextCursor *string                    `json:"next_cursor,omitempty"` // Pass as cursor to get the next page
+}
+
+// GetLatestMessagesPage returns a page of the user's chatrooms with their latest message, ordered by
+// latest activity (rooms without messages last). Read statuses are only loaded for the rooms on the page.
+func (s *MessageReadStatusService) GetLatestMessagesPage(userID uint, query LatestMessagesQuery) (*LatestMessagesPage, error) {
+	if query.Limit <= 0 {
+		query.Limit = DefaultLatestMessagesLimit
+	}
+	if query.Limit > MaxLatestMessagesLimit {
+		query.Limit = MaxLatestMessagesLimit
+	}
+
+	var after *latestMessagesCursor
+	if query.Cursor != "" {
+		cursor, err := decodeLatestMessagesCursor(query.Cursor)
 		if err != nil {
-			if err == mongo.ErrNoDocuments {
-				// No messages in this chatroom yet
-				latestMessage := models.LatestChatMessage{
-					ChatroomID:   chatroom.ID.Hex(),
-					ChatroomName: chatroom.Name,
-					MessageID:    "",
-					SenderName:   "",
-					MessageType:  "",
-					TextContent:  "",
-					MediaURL:     "",
-					SentAt:       time.Time{},
-					ReadStatus:   []models.ReadInfo{},
-				}
-				latestMessages = append(latestMessages, latestMessage)
-				continue
-			}
-			return nil, errors.New("failed to get latest message")
+			return nil, err
 		}
+		after = cursor
+	}
+
+	userChatrooms, err := s.ChatroomService.GetUserChatrooms(userID)
+	if err != nil {
+		return nil, errors.New("failed to get user chatrooms")
+	}
 
-		// Get read status for this message
-		readStatus, err := s.GetMessageReadStatus(message.ID)
+	latestByChatroom, err := s.latestMessagesByChatroom(context.Background(), userChatrooms)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only rooms with unread messages, if requested
+	var hasUnread map[string]bool
+	if query.UnreadOnly {
+		unreadCounts, err := s.GetUnreadCountForUser(userID)
 		if err != nil {
-			readStatus = []models.ReadInfo{} // Empty if failed to get read status
+			return nil, err
+		}
+		hasUnread = make(map[string]bool, len(unreadCounts))
+		for _, unread := range unreadCounts {
+			hasUnread[unread.ChatroomID] = unread.UnreadCount > 0
 		}
+	}
+
+	// Order every candidate room by its position key, then cut the page after the cursor
+	candidates := make([]latestMessagesCursor, 0, len(userChatrooms))
+	chatroomsByID := make(map[primitive.ObjectID]*models.Chatroom, len(userChatrooms))
+	for i := range userChatrooms {
+		chatroom := &userChatrooms[i]
+		if hasUnread != nil && !hasUnread[chatroom.ID.Hex()] {
+			continue
+		}
+		chatroomsByID[chatroom.ID] = chatroom
+		position := latestMessagesCursor{ChatroomID: chatroom.ID}
+		if message, ok := latestByChatroom[chatroom.ID]; ok {
+			position.SentAt = message.SentAt.UnixMilli()
+		}
+		if after == nil || position.isAfter(*after) {
+			candidates = append(candidates, position)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[j].isAfter(candidates[i])
+	})
+
+	page := &LatestMessagesPage{Chatrooms: []models.LatestChatMessage{}}
+	if len(candidates) > query.Limit {
+		candidates = candidates[:query.Limit]
+		page.HasMore = true
+		nextCursor := candidates[len(candidates)-1].encode()
+		page.NextCursor = &nextCursor
+	}
+	for _, position := range candidates {
+		page.Chatrooms = append(page.Chatrooms, s.buildLatestChatMessage(chatroomsByID[position.ChatroomID], latestByChatroom[position.ChatroomID]))
+	}
+
+	return page, nil
+}
+
+// latestMessagesCursor is a chatroom's position in the latest-activity order.
+// SentAt is the latest message time in Unix milliseconds (zero time for rooms without messages).
+type latestMessagesCursor struct {
+	SentAt     int64
+	ChatroomID primitive.ObjectID
+}
+
+// isAfter reports whether c comes after other: older activity first, then lower chatroom ID for ties
+func (c latestMessagesCursor) isAfter(other latestMessagesCursor) bool {
+	if c.SentAt != other.SentAt {
+		return c.SentAt < other.SentAt
+	}
+	return c.ChatroomID.Hex() < other.ChatroomID.Hex()
+}
+
+func (c latestMessagesCursor) encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%s", c.SentAt, c.ChatroomID.Hex())))
+}
+
+func decodeLatestMessagesCursor(value string) (*latestMessagesCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	sentAt, chatroomID, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return nil, errors.New("invalid cursor")
+	}
+	millis, err := strconv.ParseInt(sentAt, 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	id, err := primitive.ObjectIDFromHex(chatroomID)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	return &latestMessagesCursor{SentAt: millis, ChatroomID: id}, nil
+}
+
+// latestMessagesByChatroom finds the newest message of each chatroom with a single aggregation.
+// Chatrooms without messages have no entry.
+func (s *MessageReadStatusService) latestMessagesByChatroom(ctx context.Context, chatrooms []models.Chatroom) (map[primitive.ObjectID]models.Message, error) {
+	latestByChatroom := make(map[primitive.ObjectID]models.Message, len(chatrooms))
+	if len(chatrooms) == 0 {
+		return latestByChatroom, nil
+	}
+
+	chatroomIDs := make([]primitive.ObjectID, len(chatrooms))
+	for i, chatroom := range chatrooms {
+		chatroomIDs[i] = chatroom.ID
+	}
 
-		latestMessage := models.LatestChatMessage{
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"chatroom_id": bson.M{"$in": chatroomIDs}}}},
+		{{Key: "$sort", Value: bson.M{"sent_at": -1}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":     "$chatroom_id",
+			"message": bson.M{"$first": "$$ROOT"},
+		}}},
+	}
+	cursor, err := s.MessageColl.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, errors.New("failed to get latest message")
+	}
+
+	var latest []struct {
+		ChatroomID primitive.ObjectID `bson:"_id"`
+		Message    models.Message     `bson:"message"`
+	}
+	if err := cursor.All(ctx, &latest); err != nil {
+		return nil, errors.New("failed to get latest message")
+	}
+	for _, entry := range latest {
+		latestByChatroom[entry.ChatroomID] = entry.Message
+	}
+	return latestByChatroom, nil
+}
+
+// buildLatestChatMessage builds a chatroom's LatestChatMessage; message is empty for a room with no messages
+func (s *MessageReadStatusService) buildLatestChatMessage(chatroom *models.Chatroom, message models.Message) models.LatestChatMessage {
+	if message.ID.IsZero() {
+		// No messages in this chatroom yet
+		return models.LatestChatMessage{
 			ChatroomID:   chatroom.ID.Hex(),
 			ChatroomName: chatroom.Name,
-			MessageID:    message.ID.Hex(),
-			SenderName:   message.SenderName,
-			MessageType:  message.MessageType,
-			TextContent:  message.TextContent,
-			MediaURL:     message.MediaURL,
-			SentAt:       message.SentAt,
-			ReadStatus:   readStatus,
+			ReadStatus:   []models.ReadInfo{},
 		}
+	}
 
-		latestMessages = append(latestMessages, latestMessage)
+	// Get read status for this message
+	readStatus, err := s.GetMessageReadStatus(message.ID)
+	if err != nil {
+		readStatus = []models.ReadInfo{} // Empty if failed to get read status
 	}
 
-	return latestMessages, nil
+	return models.LatestChatMessage{
+		ChatroomID:   chatroom.ID.Hex(),
+		ChatroomName: chatroom.Name,
+		MessageID:    message.ID.Hex(),
+		SenderName:   message.SenderName,
+		MessageType:  message.MessageType,
+		TextContent:  message.TextContent,
+		MediaURL:     message.MediaURL,
+		Preview:      message.Preview(),
+		SentAt:       message.SentAt,
+		ReadStatus:   readStatus,
+	}
 }
 
 // GetMessageReadByWho gets detailed information about who has read a specific message
 func (s *MessageReadStatusService) GetMessageReadByWho(messageID primitive.ObjectID) ([]models.MessageReadStatusResponse, error) {
-	// Find all read statuses for the message
-	cursor, err := s.ReadStatusColl.Find(context.Background(), bson.M{"message_id": messageID})
+	readStatuses, err := s.readStatusesForMessage(messageID)
 	if err != nil {
-		return nil, errors.New("failed to get read statuses")
+		return nil, err
+	}
+	if len(readStatuses) > 0 && !s.ReadReceiptsEnabled(readStatuses[0].ChatroomID) {
+		return []models.MessageReadStatusResponse{}, nil
+	}
+
+	// Convert to response format
+	var responses []models.MessageReadStatusResponse
+	for _, status := range readStatuses {
+		responses = append(responses, status.ToResponse())
+	}
+
+	return responses, nil
+}
+
+// GetMessageReadByWhoPaginated gets one page of read statuses for a message along with the total count
+func (s *MessageReadStatusService) GetMessageReadByWhoPaginated(messageID primitive.ObjectID, limit, offset int) ([]models.MessageReadStatusResponse, int64, error) {
+	if s.readPointerTracking {
+		// Derived statuses are bounded by the member list, so page them in memory
+		all, err := s.GetMessageReadByWho(messageID)
+		if err != nil {
+			return nil, 0, err
+		}
+		total := int64(len(all))
+		start := min(offset, len(all))
+		end := min(start+limit, len(all))
+		return all[start:end], total, nil
+	}
+
+	filter := bson.M{"message_id": messageID}
+
+	total, err := s.ReadStatusColl.CountDocuments(context.Background(), filter)
+	if err != nil {
+		return nil, 0, errors.New("failed to get read statuses")
+	}
+
+	// Sort by _id so pages stay stable while statuses are being updated
+	opts := options.Find().
+		SetSort(bson.M{"_id": 1}).
+		SetSkip(int64(offset)).
+		SetLimit(int64(limit))
+
+	cursor, err := s.ReadStatusColl.Find(context.Background(), filter, opts)
+	if err != nil {
+		return nil, 0, errors.New("failed to get read statuses")
 	}
 	defer cursor.Close(context.Background())
 
 	var readStatuses []models.MessageReadStatus
 	if err := cursor.All(context.Background(), &readStatuses); err != nil {
-		return nil, errors.New("failed to decode read statuses")
+		return nil, 0, errors.New("failed to decode read statuses")
+	}
+	if len(readStatuses) > 0 && !s.ReadReceiptsEnabled(readStatuses[0].ChatroomID) {
+		return []models.MessageReadStatusResponse{}, 0, nil
 	}
 
 	// Convert to response format
-	var responses []models.MessageReadStatusResponse
+	responses := []models.MessageReadStatusResponse{}
 	for _, status := range readStatuses {
 		responses = append(responses, status.ToResponse())
 	}
 
-	return responses, nil
+	return responses, total, nil
 }
 
-// MarkAllMessagesInChatroomAsRead marks all messages in a chatroom as read for a specific user
-func (s *MessageReadStatusService) MarkAllMessagesInChatroomAsRead(chatroomID primitive.ObjectID, userID uint) error {
-	now := time.Now()
+// GetUnreadRecipients gets the recipients who have not read a message yet (only the sender may ask);
+// it is empty when the chatroom has read receipts off
+func (s *MessageReadStatusService) GetUnreadRecipients(messageID primitive.ObjectID, requesterID uint) ([]models.ReadInfo, error) {
+	// Get the message to check the sender
+	var message models.Message
+	err := s.MessageColl.FindOne(context.Background(), bson.M{"_id": messageID}).Decode(&message)
+	if err != nil {
+		return nil, errors.New("message not found")
+	}
 
-	// Update all unread messages in the chatroom for this user
-	filter := bson.M{
-		"chatroom_id":  chatroomID,
-		"recipient_id": userID,
-		"is_read":      false,
+	if message.SenderID != requesterID {
+		return nil, errors.New("user is not the sender of this message")
+	}
+	if !s.ReadReceiptsEnabled(message.ChatroomID) {
+		return []models.ReadInfo{}, nil
 	}
 
-	update := bson.M{
-		"$set": bson.M{
-			"is_read": true,
-			"read_at": now,
-		},
+	readStatuses, err := s.unreadStatusesForMessage(messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Convert to ReadInfo format with usernames (empty list when everyone has read it)
+	usernames := s.recipientUsernames(readStatuses)
+	unreadRecipients := []models.ReadInfo{}
+	for _, status := range readStatuses {
+		unreadRecipients = append(unreadRecipients, models.ReadInfo{
+			UserID:   status.RecipientID,
+			Username: usernames[status.RecipientID],
+			IsRead:   false,
+		})
+	}
+
+	return unreadRecipients, nil
+}
+
+// unreadStatusesForMessage loads the read statuses of a message's recipients who haven't read it
+func (s *MessageReadStatusService) unreadStatusesForMessage(messageID primitive.ObjectID) ([]models.MessageReadStatus, error) {
+	if s.readPointerTracking {
+		statuses, err := s.readStatusesByPointer(messageID)
+		if err != nil {
+			return nil, err
+		}
+		unread := make([]models.MessageReadStatus, 0, len(statuses))
+		for _, status := range statuses {
+			if !status.IsRead {
+				unread = append(unread, status)
+			}
+		}
+		return unread, nil
 	}
 
-	_, err := s.ReadStatusColl.UpdateMany(context.Background(), filter, update)
+	cursor, err := s.ReadStatusColl.Find(context.Background(), bson.M{
+		"message_id": messageID,
+		"is_read":    false,
+	})
 	if err != nil {
-		return errors.New("failed to mark messages as read")
+		return nil, errors.New("failed to get read statuses")
 	}
+	defer cursor.Close(context.Background())
+
+	var readStatuses []models.MessageReadStatus
+	if err := cursor.All(context.Background(), &readStatuses); err != nil {
+		return nil, errors.New("failed to decode read statuses")
+	}
+	return readStatuses, nil
+}
+
+// MarkAllMessagesInChatroomAsRead marks all messages in a chatroom as read for a specific user
+func (s *MessageReadStatusService) MarkAllMessagesInChatroomAsRead(chatroomID primitive.ObjectID, userID uint) error {
+	// With pointer tracking, moving the last-read pointer below is all it takes
+	if !s.readPointerTracking {
+		// Update all unread messages in the chatroom for this user
+		filter := bson.M{
+			"chatroom_id":  chatroomID,
+			"recipient_id": userID,
+			"is_read":      false,
+		}
+
+		update := bson.M{
+			"$set": bson.M{
+				"is_read": true,
+				"read_at": time.Now(),
+			},
+		}
+
+		_, err := s.ReadStatusColl.UpdateMany(context.Background(), filter, update)
+		if err != nil {
+			return errors.New("failed to mark messages as read")
+		}
+	}
+	utils.ReadStatusOperationsTotal.WithLabelValues("mark_all_read").Inc()
 
 	// Get the latest message in the chatroom to update user's last read
 	var latestMessage models.Message
 	opts := options.FindOne().SetSort(bson.D{{Key: "sent_at", Value: -1}})
-	err = s.MessageColl.FindOne(context.Background(), bson.M{"chatroom_id": chatroomID}, opts).Decode(&latestMessage)
+	err := s.MessageColl.FindOne(context.Background(), bson.M{"chatroom_id": chatroomID}, opts).Decode(&latestMessage)
 
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
@@ -470,6 +1282,100 @@ func (s *MessageReadStatusService) MarkAllMessagesInChatroomAsRead(chatroomID pr
 	return nil
 }
 
+// MarkAllChatroomsAsRead marks every unread message as read for a user across all their chatrooms
+// and moves each room's last-read pointer to its latest message. Returns the chatrooms that had messages.
+func (s *MessageReadStatusService) MarkAllChatroomsAsRead(userID uint) ([]primitive.ObjectID, error) {
+	ctx := context.Background()
+	now := time.Now()
+
+	// One UpdateMany over all the user's unread statuses, regardless of room (pointers alone suffice with pointer tracking)
+	if !s.readPointerTracking {
+		_, err := s.ReadStatusColl.UpdateMany(ctx,
+			bson.M{"recipient_id": userID, "is_read": false},
+			bson.M{"$set": bson.M{"is_read": true, "read_at": now}},
+		)
+		if err != nil {
+			return nil, errors.New("failed to mark messages as read")
+		}
+	}
+	utils.ReadStatusOperationsTotal.WithLabelValues("mark_all_chatrooms_read").Inc()
+
+	// Find the user's chatrooms
+	cursor, err := s.ChatroomColl.Find(ctx, bson.M{"members.user_id": userID}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, errors.New("failed to get chatrooms")
+	}
+	var chatrooms []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &chatrooms); err != nil {
+		return nil, errors.New("failed to decode chatrooms")
+	}
+	if len(chatrooms) == 0 {
+		return []primitive.ObjectID{}, nil
+	}
+
+	chatroomIDs := make([]primitive.ObjectID, len(chatrooms))
+	for i, chatroom := range chatrooms {
+		chatroomIDs[i] = chatroom.ID
+	}
+
+	// Latest message per chatroom in a single aggregation
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"chatroom_id": bson.M{"$in": chatroomIDs}}}},
+		{{Key: "$sort", Value: bson.M{"sent_at": -1}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":            "$chatroom_id",
+			"latest_id":      bson.M{"$first": "$_id"},
+			"latest_sent_at": bson.M{"$first": "$sent_at"},
+		}}},
+	}
+	latestCursor, err := s.MessageColl.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, errors.New("failed to get latest messages")
+	}
+	var latest []struct {
+		ChatroomID   primitive.ObjectID `bson:"_id"`
+		LatestID     primitive.ObjectID `bson:"latest_id"`
+		LatestSentAt time.Time          `bson:"latest_sent_at"`
+	}
+	if err := latestCursor.All(ctx, &latest); err != nil {
+		return nil, errors.New("failed to decode latest messages")
+	}
+	if len(latest) == 0 {
+		return []primitive.ObjectID{}, nil
+	}
+
+	// Batch the last-read upserts into one BulkWrite
+	writes := make([]mongo.WriteModel, 0, len(latest))
+	updated := make([]primitive.ObjectID, 0, len(latest))
+	for _, entry := range latest {
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"chatroom_id": entry.ChatroomID, "user_id": userID}).
+			SetUpdate(bson.M{
+				"$set": bson.M{
+					"message_id": entry.LatestID,
+					"sent_at":    entry.LatestSentAt,
+					"read_at":    now,
+					"updated_at": now,
+				},
+				"$setOnInsert": bson.M{
+					"_id":         primitive.NewObjectID(),
+					"chatroom_id": entry.ChatroomID,
+					"user_id":     userID,
+				},
+			}).
+			SetUpsert(true))
+		updated = append(updated, entry.ChatroomID)
+	}
+
+	if _, err := s.UserLastReadColl.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+		return nil, errors.New("failed to update user last read")
+	}
+
+	return updated, nil
+}
+
 // GetFirstUnreadMessageInChatroom gets the first unread message for a user in a chatroom
 func (s *MessageReadStatusService) GetFirstUnreadMessageInChatroom(chatroomID primitive.ObjectID, userID uint) (*models.Message, error) {
 	// Get user's last read message
@@ -479,7 +1385,13 @@ func (s *MessageReadStatusService) GetFirstUnreadMessageInChatroom(chatroomID pr
 	}
 
 	var filter bson.M
-	if lastRead == nil {
+	if s.readPointerTracking {
+		readUpTo, err := s.readHorizon(context.Background(), chatroomID, userID, lastRead)
+		if err != nil {
+			return nil, err
+		}
+		filter = unreadMessagesFilter(chatroomID, userID, readUpTo)
+	} else if lastRead == nil {
 		// User has never read any message, get the first message
 		filter = bson.M{"chatroom_id": chatroomID}
 	} else {
@@ -495,10 +1407,12 @@ func (s *MessageReadStatusService) GetFirstUnreadMessageInChatroom(chatroomID pr
 			"sent_at":     bson.M{"$gt": lastReadMessage.SentAt},
 		}
 	}
+	filter["message_type"] = bson.M{"$ne": models.MessageTypeSystem} // Notices are never unread
+	filter["sender_id"] = bson.M{"$ne": userID}                      // Nor are the user's own messages
 
 	// Find the first unread message
 	var message models.Message
-	opts := options.FindOne().SetSort(bson.D{{Key: "sent_at", Value: 1}})
+	opts := options.FindOne().SetSort(bson.D{{Key: "sent_at", Value: 1}, {Key: "_id", Value: 1}})
 	err = s.MessageColl.FindOne(context.Background(), filter, opts).Decode(&message)
 
 	if err != nil {
@@ -511,14 +1425,118 @@ func (s *MessageReadStatusService) GetFirstUnreadMessageInChatroom(chatroomID pr
 	return &message, nil
 }
 
+// Context shown above the first unread message when jumping to it
+const (
+	DefaultUnreadContextSize = 10
+	MaxUnreadContextSize     = 50
+)
+
+// UnreadContextResponse is the first unread message with the messages just before it, so a client can
+// open a chatroom scrolled to the unread divider in one request
+type UnreadContextResponse struct {
+	Messages      []models.MessageResponse `json:"messages"`                  // Oldest first: up to N earlier messages, then the first unread one
+	FirstUnreadID string                   `json:"first_unread_id,omitempty"` // Where the unread divider goes; omitted when nothing is unread
+	UnreadCount   int64                    `json:"unread_count"`              // Total unread messages in the chatroom
+	HasMoreBefore bool                     `json:"has_more_before"`           // Whether older messages exist before the first one returned
+}
+
+// GetFirstUnreadWithContext returns the user's first unread message in a chatroom preceded by up to before
+// earlier messages. With nothing unread it returns the latest before messages and no FirstUnreadID,
+// so the client can simply open the chatroom at the bottom.
+func (s *MessageReadStatusService) GetFirstUnreadWithContext(chatroomID primitive.ObjectID, userID uint, before int) (*UnreadContextResponse, error) {
+	first, err := s.GetFirstUnreadMessageInChatroom(chatroomID, userID)
+	if err != nil {
+		return nil, err
+	}
+	unreadCount, err := s.GetUnreadCountForChatroom(chatroomID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Messages before the first unread one (ties on sent_at broken by _id), or the latest ones if none is unread
+	filter := bson.M{"chatroom_id": chatroomID}
+	if first != nil {
+		filter["$or"] = []bson.M{
+			{"sent_at": bson.M{"$lt": first.SentAt}},
+			{"sent_at": first.SentAt, "_id": bson.M{"$lt": first.ID}},
+		}
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "sent_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(int64(before + 1)) // One extra to tell whether there are more
+	cursor, err := s.MessageColl.Find(context.Background(), filter, opts)
+	if err != nil {
+		return nil, errors.New("failed to get messages")
+	}
+	defer cursor.Close(context.Background())
+
+	var earlier []models.Message
+	if err := cursor.All(context.Background(), &earlier); err != nil {
+		return nil, errors.New("failed to get messages")
+	}
+	hasMoreBefore := len(earlier) > before
+	if hasMoreBefore {
+		earlier = earlier[:before]
+	}
+
+	// Flip to oldest first and put the first unread message at the end
+	slices.Reverse(earlier)
+	response := &UnreadContextResponse{
+		UnreadCount:   unreadCount,
+		HasMoreBefore: hasMoreBefore,
+	}
+	if first != nil {
+		earlier = append(earlier, *first)
+		response.FirstUnreadID = first.ID.Hex()
+	}
+	response.Messages = s.ResponsesWithReadStatus(earlier)
+
+	return response, nil
+}
+
+// ResponsesWithReadStatus converts messages to responses, adding the read status loaded for all of them
+// in one batch (messages go out without it if that fails)
+func (s *MessageReadStatusService) ResponsesWithReadStatus(messages []models.Message) []models.MessageResponse {
+	messageIDs := make([]primitive.ObjectID, len(messages))
+	for i, message := range messages {
+		messageIDs[i] = message.ID
+	}
+	readStatuses, _ := s.GetMessageReadStatusBatch(messageIDs)
+
+	responses := make([]models.MessageResponse, 0, len(messages))
+	for _, message := range messages {
+		response := message.ToResponse()
+		if readStatus, ok := readStatuses[message.ID]; ok {
+			response.ReadStatus = readStatus
+		}
+		responses = append(responses, response)
+	}
+	return responses
+}
+
 // GetUnreadCountForChatroom gets unread message count for a specific chatroom for a user
 func (s *MessageReadStatusService) GetUnreadCountForChatroom(chatroomID primitive.ObjectID, userID uint) (int64, error) {
-	count, err := s.ReadStatusColl.CountDocuments(context.Background(), bson.M{
-		"chatroom_id":  chatroomID,
-		"recipient_id": userID,
-		"is_read":      false,
-	})
+	if s.readPointerTracking {
+		lastRead, err := s.GetUserLastReadForChatroom(chatroomID, userID)
+		if err != nil {
+			return 0, errors.New("failed to get unread count")
+		}
+		readUpTo, err := s.readHorizon(context.Background(), chatroomID, userID, lastRead)
+		if err != nil {
+			return 0, errors.New("failed to get unread count")
+		}
+		count, err := s.MessageColl.CountDocuments(context.Background(), unreadMessagesFilter(chatroomID, userID, readUpTo))
+		if err != nil {
+			return 0, errors.New("failed to get unread count")
+		}
+		return count, nil
+	}
 
+	joinedAt, err := s.memberJoinTimes(context.Background(), userID, []primitive.ObjectID{chatroomID})
+	if err != nil {
+		return 0, errors.New("failed to get unread count")
+	}
+	count, err := s.ReadStatusColl.CountDocuments(context.Background(), unreadRowsFilter(chatroomID, userID, joinedAt[chatroomID]))
 	if err != nil {
 		return 0, errors.New("failed to get unread count")
 	}
@@ -526,14 +1544,19 @@ func (s *MessageReadStatusService) GetUnreadCountForChatroom(chatroomID primitiv
 	return count, nil
 }
 
-// GetUnreadMessagesInChatroom gets all unread messages for a user in a chatroom
-func (s *MessageReadStatusService) GetUnreadMessagesInChatroom(chatroomID primitive.ObjectID, userID uint) ([]models.Message, error) {
+// GetUnreadMessagesInChatroom gets all unread messages for a user in a chatroom, ordered by sent_at
+// (newest first if newestFirst is set) with ties broken by _id so the order is stable
+func (s *MessageReadStatusService) GetUnreadMessagesInChatroom(chatroomID primitive.ObjectID, userID uint, newestFirst bool) ([]models.Message, error) {
+	if s.readPointerTracking {
+		return s.unreadMessagesByPointer(chatroomID, userID, newestFirst)
+	}
+
 	// Find all unread message IDs for this user in this chatroom
-	cursor, err := s.ReadStatusColl.Find(context.Background(), bson.M{
-		"chatroom_id":  chatroomID,
-		"recipient_id": userID,
-		"is_read":      false,
-	})
+	joinedAt, err := s.memberJoinTimes(context.Background(), userID, []primitive.ObjectID{chatroomID})
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := s.ReadStatusColl.Find(context.Background(), unreadRowsFilter(chatroomID, userID, joinedAt[chatroomID]))
 	if err != nil {
 		return nil, errors.New("failed to get unread message statuses")
 	}
@@ -554,10 +1577,15 @@ func (s *MessageReadStatusService) GetUnreadMessagesInChatroom(chatroomID primit
 		return []models.Message{}, nil // No unread messages
 	}
 
-	// Get the actual messages
+	// Get the actual messages, sorted by the database (chatroom_sent_at_id_idx) rather than afterwards
+	order := 1
+	if newestFirst {
+		order = -1
+	}
 	messageCursor, err := s.MessageColl.Find(context.Background(), bson.M{
-		"_id": bson.M{"$in": messageIDs},
-	})
+		"chatroom_id": chatroomID,
+		"_id":         bson.M{"$in": messageIDs},
+	}, options.Find().SetSort(bson.D{{Key: "sent_at", Value: order}, {Key: "_id", Value: order}}))
 	if err != nil {
 		return nil, errors.New("failed to get unread messages")
 	}
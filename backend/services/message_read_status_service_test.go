@@ -0,0 +1,652 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ginchat/config"
+	"github.com/ginchat/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestResolveReadTime(t *testing.T) {
+	sentAt := time.Now().Add(-time.Hour)
+
+	t.Run("no client time uses now", func(t *testing.T) {
+		before := time.Now()
+		got, err := resolveReadTime(nil, sentAt)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got.Before(before) || got.After(time.Now()) {
+			t.Errorf("got %v, want the current time", got)
+		}
+	})
+
+	t.Run("valid client time is kept", func(t *testing.T) {
+		readAt := sentAt.Add(10 * time.Minute)
+		got, err := resolveReadTime(&readAt, sentAt)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !got.Equal(readAt) {
+			t.Errorf("got %v, want %v", got, readAt)
+		}
+	})
+
+	t.Run("read at the moment of sending is allowed", func(t *testing.T) {
+		readAt := sentAt
+		if _, err := resolveReadTime(&readAt, sentAt); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("future time is rejected", func(t *testing.T) {
+		readAt := time.Now().Add(time.Hour)
+		_, err := resolveReadTime(&readAt, sentAt)
+		if err == nil || err.Error() != "read time is in the future" {
+			t.Errorf("got error %v, want read time is in the future", err)
+		}
+	})
+
+	t.Run("time before the message was sent is rejected", func(t *testing.T) {
+		readAt := sentAt.Add(-time.Second)
+		_, err := resolveReadTime(&readAt, sentAt)
+		if err == nil || err.Error() != "read time is before the message was sent" {
+			t.Errorf("got error %v, want read time is before the message was sent", err)
+		}
+	})
+}
+
+// TestMessageReadStatusBatchQueryCount compares the queries needed to load read status for a page of
+// messages one at a time (as message lists used to) and in one batch
+func TestMessageReadStatusBatchQueryCount(t *testing.T) {
+	for _, pageSize := range []int{1, 20, 100} {
+		env := newTestEnv(t, false)
+		alice, bob := env.createUser(t, "alice"), env.createUser(t, "bob")
+		room := env.createChatroom(t, "General", alice, bob)
+		messageIDs := make([]primitive.ObjectID, pageSize)
+		for i := range messageIDs {
+			messageIDs[i] = env.sendText(t, room, alice, fmt.Sprintf("message %d", i)).ID
+		}
+
+		t.Run(fmt.Sprintf("per message, %d messages", pageSize), func(t *testing.T) {
+			env.MongoDB.Commands()
+			for _, messageID := range messageIDs {
+				if _, err := env.ReadStatus.GetMessageReadStatus(messageID); err != nil {
+					t.Fatalf("GetMessageReadStatus: %v", err)
+				}
+			}
+			if got, want := len(env.MongoDB.Commands()), 2*pageSize; got != want {
+				t.Errorf("got %d queries, want %d", got, want)
+			}
+		})
+
+		t.Run(fmt.Sprintf("batch, %d messages", pageSize), func(t *testing.T) {
+			env.MongoDB.Commands()
+			statuses, err := env.ReadStatus.GetMessageReadStatusBatch(messageIDs)
+			if err != nil {
+				t.Fatalf("GetMessageReadStatusBatch: %v", err)
+			}
+			if len(statuses) != pageSize {
+				t.Errorf("got read status for %d messages, want %d", len(statuses), pageSize)
+			}
+			if got := len(env.MongoDB.Commands()); got != 2 {
+				t.Errorf("got %d queries, want 2 whatever the page size", got)
+			}
+		})
+	}
+}
+
+func TestMessageReadStatusBatchForUserSkipsOtherRooms(t *testing.T) {
+	env := newTestEnv(t, false)
+	alice, bob, carol := env.createUser(t, "alice"), env.createUser(t, "bob"), env.createUser(t, "carol")
+	memberRoom := env.createChatroom(t, "Member", alice, bob)
+	otherRoom := env.createChatroom(t, "Other", alice, carol)
+	visible := env.sendText(t, memberRoom, alice, "for bob").ID
+	hidden := env.sendText(t, otherRoom, alice, "for carol").ID
+
+	env.MongoDB.Commands()
+	statuses, err := env.ReadStatus.GetMessageReadStatusBatchForUser([]primitive.ObjectID{visible, hidden}, bob.UserID)
+	if err != nil {
+		t.Fatalf("GetMessageReadStatusBatchForUser: %v", err)
+	}
+	if _, ok := statuses[visible]; !ok {
+		t.Error("expected read status for the message in the user's room")
+	}
+	if _, ok := statuses[hidden]; ok {
+		t.Error("got read status for a message in a room the user isn't in")
+	}
+
+	// The read status lookup must only ask about the visible message
+	lookups := 0
+	for _, cmd := range env.MongoDB.Commands() {
+		if cmd.Name != "find" || cmd.Collection != "message_read_status" {
+			continue
+		}
+		lookups++
+		body, err := bson.Marshal(cmd.Body)
+		if err != nil {
+			t.Fatalf("marshal command: %v", err)
+		}
+		values, _ := bson.Raw(body).Lookup("filter", "message_id", "$in").Array().Values()
+		if len(values) != 1 || values[0].ObjectID() != visible {
+			t.Errorf("read status query asked about %v, want only %s", values, visible.Hex())
+		}
+	}
+	if lookups != 1 {
+		t.Errorf("got %d read status queries, want 1", lookups)
+	}
+}
+
+func TestGetUnreadRecipients(t *testing.T) {
+	env := newTestEnv(t, false)
+	alice, bob, carol := env.createUser(t, "alice"), env.createUser(t, "bob"), env.createUser(t, "carol")
+	room := env.createChatroom(t, "General", alice, bob, carol)
+	message := env.sendText(t, room, alice, "lunch?")
+
+	if err := env.ReadStatus.MarkMessageAsRead(message.ID, bob.UserID); err != nil {
+		t.Fatalf("MarkMessageAsRead: %v", err)
+	}
+
+	t.Run("partially read lists the rest by name in one user query", func(t *testing.T) {
+		env.SQL.Statements()
+		unread, err := env.ReadStatus.GetUnreadRecipients(message.ID, alice.UserID)
+		if err != nil {
+			t.Fatalf("GetUnreadRecipients: %v", err)
+		}
+		if len(unread) != 1 || unread[0].UserID != carol.UserID || unread[0].Username != "carol" || unread[0].IsRead {
+			t.Fatalf("unread = %+v, want only carol", unread)
+		}
+		if statements := env.SQL.Statements(); len(statements) != 1 {
+			t.Errorf("looked up usernames with %d queries, want 1: %v", len(statements), statements)
+		}
+	})
+
+	t.Run("only the sender may ask", func(t *testing.T) {
+		_, err := env.ReadStatus.GetUnreadRecipients(message.ID, bob.UserID)
+		if err == nil || err.Error() != "user is not the sender of this message" {
+			t.Fatalf("got error %v, want the sender check", err)
+		}
+	})
+
+	t.Run("fully read is an empty list", func(t *testing.T) {
+		if err := env.ReadStatus.MarkMessageAsRead(message.ID, carol.UserID); err != nil {
+			t.Fatalf("MarkMessageAsRead: %v", err)
+		}
+		unread, err := env.ReadStatus.GetUnreadRecipients(message.ID, alice.UserID)
+		if err != nil {
+			t.Fatalf("GetUnreadRecipients: %v", err)
+		}
+		if unread == nil || len(unread) != 0 {
+			t.Fatalf("unread = %#v, want an empty list", unread)
+		}
+	})
+}
+
+func TestReadStatusUsernamesBatched(t *testing.T) {
+	env := newTestEnv(t, false)
+	users := []*models.User{env.createUser(t, "alice")}
+	for i := 0; i < 10; i++ {
+		users = append(users, env.createUser(t, fmt.Sprintf("member%d", i)))
+	}
+	room := env.createChatroom(t, "General", users...)
+	message := env.sendText(t, room, users[0], "hello all")
+
+	env.SQL.Statements()
+	readInfos, err := env.ReadStatus.GetMessageReadStatus(message.ID)
+	if err != nil {
+		t.Fatalf("GetMessageReadStatus: %v", err)
+	}
+	if len(readInfos) != 10 {
+		t.Fatalf("got %d read infos, want 10", len(readInfos))
+	}
+	for _, info := range readInfos {
+		if info.Username != fmt.Sprintf("member%d", info.UserID-2) {
+			t.Errorf("user %d has username %q", info.UserID, info.Username)
+		}
+	}
+	if statements := env.SQL.Statements(); len(statements) != 1 {
+		t.Errorf("looked up usernames with %d queries, want 1", len(statements))
+	}
+}
+
+func TestUnreadCountPointers(t *testing.T) {
+	for _, pointerTracking := range []bool{false, true} {
+		t.Run(fmt.Sprintf("pointer_tracking=%v", pointerTracking), func(t *testing.T) {
+			env := newTestEnv(t, pointerTracking)
+			alice, bob := env.createUser(t, "alice"), env.createUser(t, "bob")
+			partial := env.createChatroom(t, "Partial", alice, bob)
+			unread := env.createChatroom(t, "Unread", alice, bob)
+			caughtUp := env.createChatroom(t, "Caught up", alice, bob)
+
+			var sent []*models.Message
+			for i := 1; i <= 4; i++ {
+				sent = append(sent, env.sendText(t, partial, alice, fmt.Sprintf("partial %d", i)))
+			}
+			laterUnread := env.sendText(t, unread, alice, "unread 1")
+			// Written second but sent earlier, as when the server clock stepped back before sent_at was kept increasing
+			firstUnread := env.sendText(t, unread, alice, "unread 2")
+			if _, err := env.Messages.MsgColl.UpdateOne(context.Background(), bson.M{"_id": laterUnread.ID},
+				bson.M{"$set": bson.M{"sent_at": laterUnread.SentAt.Add(time.Hour)}}); err != nil {
+				t.Fatalf("move sent_at forward: %v", err)
+			}
+			lastCaughtUp := env.sendText(t, caughtUp, alice, "caught up")
+
+			for _, message := range []*models.Message{sent[0], sent[1], lastCaughtUp} {
+				if err := env.ReadStatus.MarkMessageAsRead(message.ID, bob.UserID); err != nil {
+					t.Fatalf("MarkMessageAsRead: %v", err)
+				}
+			}
+
+			env.MongoDB.Commands()
+			counts, err := env.ReadStatus.GetUnreadCountForUser(bob.UserID)
+			if err != nil {
+				t.Fatalf("GetUnreadCountForUser: %v", err)
+			}
+			queries := len(env.MongoDB.Commands())
+			want := map[string]models.ChatroomUnreadCount{
+				partial.ID.Hex():  {UnreadCount: 2, LastReadMessageID: sent[1].ID.Hex(), FirstUnreadMessageID: sent[2].ID.Hex()},
+				unread.ID.Hex():   {UnreadCount: 2, FirstUnreadMessageID: firstUnread.ID.Hex()},
+				caughtUp.ID.Hex(): {UnreadCount: 0, LastReadMessageID: lastCaughtUp.ID.Hex()},
+			}
+			if len(counts) != len(want) {
+				t.Fatalf("got counts for %d rooms, want %d", len(counts), len(want))
+			}
+			for _, count := range counts {
+				w := want[count.ChatroomID]
+				if count.UnreadCount != w.UnreadCount || count.LastReadMessageID != w.LastReadMessageID || count.FirstUnreadMessageID != w.FirstUnreadMessageID {
+					t.Errorf("%s: unread %d, last read %q, first unread %q; want %d, %q, %q", count.ChatroomName,
+						count.UnreadCount, count.LastReadMessageID, count.FirstUnreadMessageID,
+						w.UnreadCount, w.LastReadMessageID, w.FirstUnreadMessageID)
+				}
+			}
+
+			// The pointers are looked up for all rooms at once, so more rooms don't mean more queries
+			for i := 0; i < 5; i++ {
+				room := env.createChatroom(t, fmt.Sprintf("Extra %d", i), alice, bob)
+				env.sendText(t, room, alice, "more")
+			}
+			env.MongoDB.Commands()
+			if _, err := env.ReadStatus.GetUnreadCountForUser(bob.UserID); err != nil {
+				t.Fatalf("GetUnreadCountForUser: %v", err)
+			}
+			if more := len(env.MongoDB.Commands()); more != queries {
+				t.Errorf("%d queries for 8 rooms, %d for 3; want the same", more, queries)
+			}
+		})
+	}
+}
+
+func TestGetLatestMessagesPage(t *testing.T) {
+	env := newTestEnv(t, false)
+	alice, bob := env.createUser(t, "alice"), env.createUser(t, "bob")
+	rooms := make([]*models.Chatroom, 5)
+	for i := range rooms {
+		rooms[i] = env.createChatroom(t, fmt.Sprintf("Room %d", i), alice, bob)
+	}
+	// Activity from oldest to newest: 0 (read), 1 (unread), 2 (read), 3 (unread); room 4 has no messages
+	for i, sender := range []*models.User{alice, bob, alice, bob} {
+		env.sendText(t, rooms[i], sender, "hello")
+		time.Sleep(2 * time.Millisecond)
+	}
+	ids := func(page *LatestMessagesPage) []string {
+		var got []string
+		for _, latest := range page.Chatrooms {
+			got = append(got, latest.ChatroomID)
+		}
+		return got
+	}
+	roomIDs := func(indexes ...int) []string {
+		var want []string
+		for _, i := range indexes {
+			want = append(want, rooms[i].ID.Hex())
+		}
+		return want
+	}
+
+	t.Run("pages follow latest activity", func(t *testing.T) {
+		var got [][]string
+		query := LatestMessagesQuery{Limit: 2}
+		for {
+			page, err := env.ReadStatus.GetLatestMessagesPage(alice.UserID, query)
+			if err != nil {
+				t.Fatalf("GetLatestMessagesPage: %v", err)
+			}
+			got = append(got, ids(page))
+			if !page.HasMore {
+				break
+			}
+			query.Cursor = *page.NextCursor
+		}
+		want := [][]string{roomIDs(3, 2), roomIDs(1, 0), roomIDs(4)}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("pages = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("unread only", func(t *testing.T) {
+		page, err := env.ReadStatus.GetLatestMessagesPage(alice.UserID, LatestMessagesQuery{UnreadOnly: true})
+		if err != nil {
+			t.Fatalf("GetLatestMessagesPage: %v", err)
+		}
+		if got, want := ids(page), roomIDs(3, 1); !slices.Equal(got, want) || page.HasMore {
+			t.Errorf("unread rooms = %v (has_more %v), want %v", got, page.HasMore, want)
+		}
+	})
+
+	t.Run("invalid cursor", func(t *testing.T) {
+		if _, err := env.ReadStatus.GetLatestMessagesPage(alice.UserID, LatestMessagesQuery{Cursor: "not-a-cursor"}); err == nil || err.Error() != "invalid cursor" {
+			t.Errorf("err = %v, want invalid cursor", err)
+		}
+	})
+}
+
+func TestMarkMessagesAsReadMixedBatch(t *testing.T) {
+	for _, pointerTracking := range []bool{false, true} {
+		t.Run(fmt.Sprintf("pointer_tracking=%v", pointerTracking), func(t *testing.T) {
+			env := newTestEnv(t, pointerTracking)
+			alice, bob, carol := env.createUser(t, "alice"), env.createUser(t, "bob"), env.createUser(t, "carol")
+			room := env.createChatroom(t, "General", alice, bob)
+			private := env.createChatroom(t, "Private", alice, carol)
+
+			var sent []*models.Message
+			for i := 1; i <= 3; i++ {
+				sent = append(sent, env.sendText(t, room, alice, fmt.Sprintf("message %d", i)))
+				time.Sleep(2 * time.Millisecond) // Pointers compare send times, stored to the millisecond
+			}
+			own := env.sendText(t, room, bob, "bob's own message")
+			notRecipient := env.sendText(t, private, alice, "not for bob")
+			unknown := primitive.NewObjectID()
+			if err := env.ReadStatus.MarkMessageAsRead(sent[0].ID, bob.UserID); err != nil {
+				t.Fatalf("MarkMessageAsRead: %v", err)
+			}
+
+			batch := []primitive.ObjectID{sent[2].ID, sent[0].ID, own.ID, notRecipient.ID, unknown, sent[2].ID}
+			result, err := env.ReadStatus.MarkMessagesAsRead(batch, bob.UserID)
+			if err != nil {
+				t.Fatalf("MarkMessagesAsRead: %v", err)
+			}
+			assertIDs(t, "marked", result.Marked, sent[2].ID)
+			assertIDs(t, "already read", result.AlreadyRead, sent[0].ID)
+			assertIDs(t, "not found", result.NotFound, own.ID, notRecipient.ID, unknown)
+
+			// Marking the same batch again changes nothing
+			result, err = env.ReadStatus.MarkMessagesAsRead(batch, bob.UserID)
+			if err != nil {
+				t.Fatalf("MarkMessagesAsRead again: %v", err)
+			}
+			assertIDs(t, "marked again", result.Marked)
+			assertIDs(t, "already read again", result.AlreadyRead, sent[2].ID, sent[0].ID)
+
+			if count, err := env.ReadStatus.GetUnreadCountForChatroom(private.ID, carol.UserID); err != nil || count != 1 {
+				t.Errorf("carol's unread count = %d, %v; want carol's message untouched by bob's batch", count, err)
+			}
+		})
+	}
+}
+
+// assertIDs fails the test unless got holds exactly want, in order
+func assertIDs(t *testing.T, what string, got []primitive.ObjectID, want ...primitive.ObjectID) {
+	t.Helper()
+	if !slices.Equal(got, want) {
+		t.Errorf("%s = %v, want %v", what, got, want)
+	}
+}
+
+func TestGetUnreadCountForChatroomsSubset(t *testing.T) {
+	for _, pointerTracking := range []bool{false, true} {
+		t.Run(fmt.Sprintf("pointer_tracking=%v", pointerTracking), func(t *testing.T) {
+			env := newTestEnv(t, pointerTracking)
+			alice, bob := env.createUser(t, "alice"), env.createUser(t, "bob")
+			folder := []*models.Chatroom{env.createChatroom(t, "Work", alice, bob), env.createChatroom(t, "Family", alice, bob)}
+			notRequested := env.createChatroom(t, "Hobbies", alice, bob)
+			notJoined := env.createChatroom(t, "Alice only", alice)
+			for i, room := range append(folder, notRequested, notJoined) {
+				for range i + 1 {
+					env.sendText(t, room, alice, "hi")
+				}
+			}
+
+			counts := func(rooms ...primitive.ObjectID) map[string]int64 {
+				t.Helper()
+				got, err := env.ReadStatus.GetUnreadCountForChatrooms(bob.UserID, rooms)
+				if err != nil {
+					t.Fatalf("GetUnreadCountForChatrooms: %v", err)
+				}
+				byRoom := map[string]int64{}
+				for _, count := range got {
+					byRoom[count.ChatroomID] = count.UnreadCount
+				}
+				return byRoom
+			}
+
+			env.MongoDB.Commands()
+			got := counts(folder[0].ID, notJoined.ID, primitive.NewObjectID(), folder[1].ID)
+			queries := len(env.MongoDB.Commands())
+			want := map[string]int64{folder[0].ID.Hex(): 1, folder[1].ID.Hex(): 2}
+			if !maps.Equal(got, want) {
+				t.Errorf("counts = %v, want %v: only the requested rooms bob belongs to", got, want)
+			}
+
+			// One query for the rooms and one for the counts, however many rooms are asked for
+			env.MongoDB.Commands()
+			counts(folder[0].ID)
+			if fewer := len(env.MongoDB.Commands()); fewer != queries {
+				t.Errorf("%d queries for one room, %d for four; want the same", fewer, queries)
+			}
+			if got := counts(); len(got) != 0 {
+				t.Errorf("no rooms requested: counts = %v", got)
+			}
+		})
+	}
+}
+
+func TestPointerTrackingMatchesRows(t *testing.T) {
+	// world is one environment with the scenario's users, rooms and messages by name
+	type world struct {
+		*testEnv
+		users map[string]*models.User
+		rooms map[string]*models.Chatroom
+		sent  map[string]*models.Message
+	}
+	build := func(pointerTracking bool) *world {
+		w := &world{testEnv: newTestEnv(t, pointerTracking), users: map[string]*models.User{}, rooms: map[string]*models.Chatroom{}, sent: map[string]*models.Message{}}
+		for _, name := range []string{"alice", "bob", "carol"} {
+			w.users[name] = w.createUser(t, name)
+		}
+		w.rooms["general"] = w.createChatroom(t, "general", w.users["alice"], w.users["bob"], w.users["carol"])
+		w.rooms["pair"] = w.createChatroom(t, "pair", w.users["alice"], w.users["bob"])
+		return w
+	}
+	rows, pointers := build(false), build(true)
+	worlds := []*world{rows, pointers}
+
+	send := func(room, sender, text string) {
+		for _, w := range worlds {
+			w.sent[text] = w.sendText(t, w.rooms[room], w.users[sender], text)
+		}
+	}
+	// Reading a message also reads the ones before it with pointers, so the scenario reads in order
+	read := func(user string, texts ...string) {
+		for _, w := range worlds {
+			for _, text := range texts {
+				if err := w.ReadStatus.MarkMessageAsRead(w.sent[text].ID, w.users[user].UserID); err != nil {
+					t.Fatalf("MarkMessageAsRead(%s): %v", text, err)
+				}
+			}
+		}
+	}
+	// snapshot describes what every user sees as unread, by room and message text
+	snapshot := func(w *world) map[string]string {
+		got := map[string]string{}
+		for userName, user := range w.users {
+			counts, err := w.ReadStatus.GetUnreadCountForUser(user.UserID)
+			if err != nil {
+				t.Fatalf("GetUnreadCountForUser: %v", err)
+			}
+			for _, count := range counts {
+				got[userName+" count in "+count.ChatroomName] = fmt.Sprint(count.UnreadCount)
+			}
+			for roomName, room := range w.rooms {
+				if !w.Chatrooms.IsMember(room, user.UserID) {
+					continue // The endpoints turn non-members away before asking for counts
+				}
+				key := userName + " in " + roomName
+				count, err := w.ReadStatus.GetUnreadCountForChatroom(room.ID, user.UserID)
+				got[key+": count"] = fmt.Sprint(count, err)
+				unread, err := w.ReadStatus.GetUnreadMessagesInChatroom(room.ID, user.UserID, false)
+				var texts []string
+				for _, message := range unread {
+					texts = append(texts, message.TextContent)
+				}
+				got[key+": unread"] = fmt.Sprint(texts, err)
+				first, err := w.ReadStatus.GetFirstUnreadMessageInChatroom(room.ID, user.UserID)
+				if first != nil {
+					got[key+": first"] = first.TextContent
+				} else {
+					got[key+": first"] = fmt.Sprint(err)
+				}
+			}
+		}
+		return got
+	}
+	check := func(stage string) {
+		t.Helper()
+		want, got := snapshot(rows), snapshot(pointers)
+		for _, key := range slices.Sorted(maps.Keys(want)) {
+			if got[key] != want[key] {
+				t.Errorf("%s: %s = %q with pointers, %q with rows", stage, key, got[key], want[key])
+			}
+		}
+		if len(got) != len(want) {
+			t.Errorf("%s: %d values with pointers, %d with rows", stage, len(got), len(want))
+		}
+	}
+
+	send("general", "alice", "g1")
+	send("general", "carol", "g2")
+	send("general", "bob", "g3")
+	send("general", "alice", "g4")
+	send("pair", "alice", "p1")
+	send("pair", "alice", "p2")
+	check("before any reads")
+
+	read("bob", "g1", "g2")
+	read("carol", "g1", "g3", "g4")
+	read("bob", "p1")
+	check("after reading")
+
+	send("general", "carol", "g5")
+	send("pair", "bob", "p3")
+	check("after new messages")
+
+	for _, w := range worlds {
+		if err := w.ReadStatus.MarkAllMessagesInChatroomAsRead(w.rooms["general"].ID, w.users["alice"].UserID); err != nil {
+			t.Fatalf("MarkAllMessagesInChatroomAsRead: %v", err)
+		}
+		if _, err := w.ReadStatus.MarkAllChatroomsAsRead(w.users["bob"].UserID); err != nil {
+			t.Fatalf("MarkAllChatroomsAsRead: %v", err)
+		}
+	}
+	check("after marking everything read")
+}
+
+func TestPointerTrackingWithoutPointers(t *testing.T) {
+	env := newTestEnv(t, false)
+	ctx := context.Background()
+	alice, bob, carol := env.createUser(t, "alice"), env.createUser(t, "bob"), env.createUser(t, "carol")
+	room := env.createChatroom(t, "general", alice, bob)
+
+	var sent []*models.Message
+	for i := 1; i <= 4; i++ {
+		sent = append(sent, env.sendText(t, room, alice, fmt.Sprintf("message %d", i)))
+	}
+	// Bob reads the first two with rows only, as before user_last_read existed
+	if _, err := env.ReadStatus.MarkMessagesAsRead([]primitive.ObjectID{sent[0].ID, sent[1].ID}, bob.UserID); err != nil {
+		t.Fatalf("MarkMessagesAsRead: %v", err)
+	}
+	if _, err := env.ReadStatus.UserLastReadColl.DeleteMany(ctx, bson.M{}); err != nil {
+		t.Fatalf("drop pointers: %v", err)
+	}
+	// Carol joins right after the history was sent, without going through JoinChatroom
+	if _, err := env.Chatrooms.ChatColl.UpdateOne(ctx, bson.M{"_id": room.ID}, bson.M{"$push": bson.M{"members": models.ChatroomMember{
+		UserID: carol.UserID, Username: carol.Username, Role: models.ChatroomRoleMember, JoinedAt: sent[3].SentAt.Add(time.Millisecond),
+	}}}); err != nil {
+		t.Fatalf("add carol: %v", err)
+	}
+
+	// READ_POINTER_TRACKING is turned on
+	chatrooms := NewChatroomService(env.Mongo, true, nil)
+	pointers := NewMessageReadStatusService(env.Mongo, chatrooms, env.Users, true, config.DefaultReadStatusReconcileBatch)
+	unread := func(user *models.User) int64 {
+		t.Helper()
+		count, err := pointers.GetUnreadCountForChatroom(room.ID, user.UserID)
+		if err != nil {
+			t.Fatalf("GetUnreadCountForChatroom: %v", err)
+		}
+		return count
+	}
+
+	// Without a pointer, a member has read up to when they joined
+	if got := unread(carol); got != 0 {
+		t.Errorf("carol has %d unread before any new message, want 0", got)
+	}
+	counts, err := pointers.GetUnreadCountForUser(carol.UserID)
+	if err != nil || len(counts) != 1 || counts[0].UnreadCount != 0 {
+		t.Errorf("GetUnreadCountForUser(carol) = %+v, %v; want 0 unread", counts, err)
+	}
+	listed, err := chatrooms.GetUserChatroomsSortedByLatestMessage(carol.UserID, ChatroomSortUnreadFirst)
+	if err != nil || len(listed) != 1 || listed[0].HasUnread {
+		t.Errorf("chatroom list for carol = %+v, %v; want the room without unread", listed, err)
+	}
+	result, err := pointers.MarkMessagesAsRead([]primitive.ObjectID{sent[3].ID}, carol.UserID)
+	if err != nil || len(result.AlreadyRead) != 1 {
+		t.Errorf("carol marking history read = %+v, %v; want it already read", result, err)
+	}
+	readBy, err := pointers.GetMessageReadByWho(sent[3].ID)
+	if err != nil {
+		t.Fatalf("GetMessageReadByWho: %v", err)
+	}
+	for _, status := range readBy {
+		if status.RecipientID == carol.UserID && !status.IsRead {
+			t.Errorf("carol is listed as not having read history from before joining")
+		}
+	}
+
+	// The backfill carries bob's read rows over to a pointer
+	if got := unread(bob); got != 4 {
+		t.Errorf("bob has %d unread before the backfill, want all 4 since joining", got)
+	}
+	if _, err := pointers.BackfillLastReadPointers(); err != nil {
+		t.Fatalf("BackfillLastReadPointers: %v", err)
+	}
+	if got := unread(bob); got != 2 {
+		t.Errorf("bob has %d unread after the backfill, want 2 as with rows", got)
+	}
+	first, err := pointers.GetFirstUnreadMessageInChatroom(room.ID, bob.UserID)
+	if err != nil || first.ID != sent[2].ID {
+		t.Errorf("bob's first unread = %v, %v; want message 3", first, err)
+	}
+
+	// Running it again doesn't move pointers back past later reads
+	if _, err := pointers.MarkMessagesAsRead([]primitive.ObjectID{sent[3].ID}, bob.UserID); err != nil {
+		t.Fatalf("MarkMessagesAsRead: %v", err)
+	}
+	if _, err := pointers.BackfillLastReadPointers(); err != nil {
+		t.Fatalf("BackfillLastReadPointers: %v", err)
+	}
+	if got := unread(bob); got != 0 {
+		t.Errorf("bob has %d unread after reading everything and a second backfill, want 0", got)
+	}
+
+	env.sendText(t, room, alice, "after the switch")
+	if got := unread(carol); got != 1 {
+		t.Errorf("carol has %d unread after a new message, want 1", got)
+	}
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/ginchat/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Report limits
+const (
+	maxReportReasonLength = 500 // Longest reason a reporter can give
+	DefaultReportPageSize = 20
+	MaxReportPageSize     = 100
+)
+
+// MessageReportService handles reporting messages and reviewing the reports
+type MessageReportService struct {
+	ReportColl  *mongo.Collection
+	MessageColl *mongo.Collection
+	ChatSvc     *ChatroomService
+}
+
+// NewMessageReportService creates a new MessageReportService
+func NewMessageReportService(mongodb *mongo.Database, chatroomService *ChatroomService) *MessageReportService {
+	return &MessageReportService{
+		ReportColl:  mongodb.Collection("message_reports"),
+		MessageColl: mongodb.Collection("messages"),
+		ChatSvc:     chatroomService,
+	}
+}
+
+// ReportMessage files a report against a message. The reporter must be a member of the message's chatroom
+// and can't report their own or system messages. Reporting the same message twice is an error.
+// The chatroom is returned so the caller can notify its admins.
+func (s *MessageReportService) ReportMessage(messageID primitive.ObjectID, reporterID uint, reporterName, reason string) (*models.MessageReport, *models.Chatroom, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, nil, errors.New("report reason is required")
+	}
+	if len(reason) > maxReportReasonLength {
+		return nil, nil, errors.New("report reason is too long")
+	}
+
+	var message models.Message
+	if err := s.MessageColl.FindOne(context.Background(), bson.M{"_id": messageID}).Decode(&message); err != nil {
+		return nil, nil, errors.New("message not found")
+	}
+	if message.IsSystem() {
+		return nil, nil, errors.New("system messages cannot be reported")
+	}
+	if message.SenderID == reporterID {
+		return nil, nil, errors.New("you cannot report your own message")
+	}
+
+	chatroom, err := s.ChatSvc.GetChatroomByID(message.ChatroomID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !s.ChatSvc.IsMember(chatroom, reporterID) {
+		return nil, nil, errors.New("user is not a member of this chatroom")
+	}
+
+	report := &models.MessageReport{
+		ID:           primitive.NewObjectID(),
+		MessageID:    messageID,
+		ChatroomID:   message.ChatroomID,
+		SenderID:     message.SenderID,
+		ReporterID:   reporterID,
+		ReporterName: reporterName,
+		Reason:       reason,
+		Status:       models.ReportStatusOpen,
+		CreatedAt:    time.Now(),
+	}
+
+	// The unique index on (message_id, reporter_id) settles concurrent duplicates; the lookup gives the usual case a clear error
+	count, err := s.ReportColl.CountDocuments(context.Background(), bson.M{"message_id": messageID, "reporter_id": reporterID})
+	if err != nil {
+		return nil, nil, errors.New("failed to report message")
+	}
+	if count > 0 {
+		return nil, nil, errors.New("you have already reported this message")
+	}
+	if _, err := s.ReportColl.InsertOne(context.Background(), report); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, nil, errors.New("you have already reported this message")
+		}
+		return nil, nil, errors.New("failed to report message")
+	}
+
+	return report, chatroom, nil
+}
+
+// ListReports returns one page of reports, newest first, optionally filtered by status, with the total count.
+// limit is clamped to 1..MaxReportPageSize.
+func (s *MessageReportService) ListReports(status string, limit, offset int) ([]models.MessageReport, int64, error) {
+	if limit <= 0 {
+		limit = DefaultReportPageSize
+	}
+	if limit > MaxReportPageSize {
+		limit = MaxReportPageSize
+	}
+	filter := bson.M{}
+	if status != "" {
+		if !models.IsValidReportStatus(status) {
+			return nil, 0, errors.New("invalid report status")
+		}
+		filter["status"] = status
+	}
+
+	ctx := context.Background()
+	total, err := s.ReportColl.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, errors.New("failed to get reports")
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}). // _id settles reports filed in the same millisecond
+		SetSkip(int64(offset)).
+		SetLimit(int64(limit))
+	cursor, err := s.ReportColl.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, errors.New("failed to get reports")
+	}
+	defer cursor.Close(ctx)
+
+	reports := []models.MessageReport{}
+	if err := cursor.All(ctx, &reports); err != nil {
+		return nil, 0, errors.New("failed to decode reports")
+	}
+	return reports, total, nil
+}
+
+// ResolveReport closes an open report as resolved or dismissed
+func (s *MessageReportService) ResolveReport(reportID primitive.ObjectID, adminID uint, status string) (*models.MessageReport, error) {
+	if !models.IsValidReportResolution(status) {
+		return nil, errors.New("invalid report status")
+	}
+
+	now := time.Now()
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var report models.MessageReport
+	err := s.ReportColl.FindOneAndUpdate(context.Background(),
+		bson.M{"_id": reportID, "status": models.ReportStatusOpen},
+		bson.M{"$set": bson.M{"status": status, "resolved_by": adminID, "resolved_at": now}},
+		opts,
+	).Decode(&report)
+	if err == nil {
+		return &report, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, errors.New("failed to update report")
+	}
+
+	// Nothing matched: tell an unknown report apart from one that was already closed
+	count, countErr := s.ReportColl.CountDocuments(context.Background(), bson.M{"_id": reportID})
+	if countErr == nil && count > 0 {
+		return nil, errors.New("report is already closed")
+	}
+	return nil, errors.New("report not found")
+}
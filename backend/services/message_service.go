@@ -1,14 +1,24 @@
 package services
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
-	"sort"
+	"log"
+	"mime/multipart"
+	"net/url"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ginchat/models"
+	"github.com/ginchat/utils"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -19,24 +29,113 @@ import (
 type MessageService struct {
 	MongoDB       *mongo.Database
 	MsgColl       *mongo.Collection
+	DraftColl     *mongo.Collection // Per-user unsent drafts, cleared when the user sends to the room
 	ChatSvc       *ChatroomService
-	CloudinarySvc *CloudinaryService
+	Media         MediaStore // Cloudinary or local disk, see NewMediaStore
 	ReadStatusSvc *MessageReadStatusService
+	Filter        *MessageFilter     // Banned-word filter; nil disables filtering
+	Encryption    *EncryptionService // At-rest text encryption; nil stores text as plaintext
+
+	maxMessageLimit int           // Largest page a history request may return (MESSAGE_HISTORY_MAX_LIMIT)
+	editWindow      time.Duration // Edit window for rooms that don't set their own (MESSAGE_EDIT_WINDOW)
 }
 
 // NewMessageService creates a new MessageService
-func NewMessageService(mongodb *mongo.Database, chatroomService *ChatroomService, cloudinaryService *CloudinaryService, readStatusService *MessageReadStatusService) *MessageService {
+func NewMessageService(mongodb *mongo.Database, chatroomService *ChatroomService, mediaStore MediaStore, readStatusService *MessageReadStatusService,
+	filter *MessageFilter, encryption *EncryptionService, maxMessageLimit int, editWindow time.Duration) *MessageService {
 	return &MessageService{
-		MongoDB:       mongodb,
-		MsgColl:       mongodb.Collection("messages"),
-		ChatSvc:       chatroomService,
-		CloudinarySvc: cloudinaryService,
-		ReadStatusSvc: readStatusService,
+		MongoDB:         mongodb,
+		MsgColl:         mongodb.Collection("messages"),
+		DraftColl:       mongodb.Collection("message_drafts"),
+		ChatSvc:         chatroomService,
+		Media:           mediaStore,
+		ReadStatusSvc:   readStatusService,
+		Filter:          filter,
+		Encryption:      encryption,
+		maxMessageLimit: maxMessageLimit,
+		editWindow:      editWindow,
+	}
+}
+
+// sentAtClock hands out sent_at values that strictly increase within each chatroom, so ordering and
+// time-based cursors stay stable even if the server clock steps backwards (e.g. an NTP correction).
+// MongoDB stores dates to the millisecond, so collisions are bumped by a millisecond.
+var sentAtClock = struct {
+	mu   sync.Mutex
+	last map[primitive.ObjectID]time.Time // Latest sent_at handed out per chatroom by this process
+}{last: make(map[primitive.ObjectID]time.Time)}
+
+// sentAtClockPruneSize is how many chatrooms sentAtClock remembers before it forgets idle ones
+const sentAtClockPruneSize = 10000
+
+// nextSentAt returns the sent_at for a new message in the chatroom: the current time, unless that isn't after
+// the room's latest message, in which case one millisecond after it. The latest stored message is checked too,
+// so messages written by another server instance (or before a restart) are respected.
+func (s *MessageService) nextSentAt(chatroomID primitive.ObjectID) time.Time {
+	var latest models.Message
+	opts := options.FindOne().SetSort(bson.D{{Key: "sent_at", Value: -1}}).SetProjection(bson.M{"sent_at": 1})
+	if err := s.MsgColl.FindOne(context.Background(), bson.M{"chatroom_id": chatroomID}, opts).Decode(&latest); err != nil && err != mongo.ErrNoDocuments {
+		log.Printf("Failed to read latest sent_at in chatroom %s: %v", chatroomID.Hex(), err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+
+	sentAtClock.mu.Lock()
+	defer sentAtClock.mu.Unlock()
+
+	previous := latest.SentAt
+	if last, ok := sentAtClock.last[chatroomID]; ok && last.After(previous) {
+		previous = last
 	}
+	sentAt := now
+	if !sentAt.After(previous) {
+		sentAt = previous.UTC().Truncate(time.Millisecond).Add(time.Millisecond)
+		utils.MessageClockAdjustmentsTotal.Inc()
+	}
+
+	if len(sentAtClock.last) >= sentAtClockPruneSize {
+		for id, last := range sentAtClock.last {
+			if now.Sub(last) > time.Minute {
+				delete(sentAtClock.last, id)
+			}
+		}
+	}
+	sentAtClock.last[chatroomID] = sentAt
+	return sentAt
+}
+
+// validateMessageContent checks that a text/media message type is one clients may use and that the
+// text and media it requires are present. Albums and system notices aren't accepted here.
+func validateMessageContent(messageType, textContent, mediaURL string) error {
+	switch messageType {
+	case "text":
+		if textContent == "" {
+			return errors.New("text content is required for text messages")
+		}
+	case "picture", "audio", "video":
+		if mediaURL == "" {
+			return errors.New("media URL is required for media messages")
+		}
+	case "text_and_picture", "text_and_audio", "text_and_video":
+		if textContent == "" {
+			return errors.New("text content is required for combined messages")
+		}
+		if mediaURL == "" {
+			return errors.New("media URL is required for combined messages")
+		}
+	default:
+		return errors.New("invalid message type")
+	}
+	return nil
 }
 
 // SendMessage sends a message to a chatroom
 func (s *MessageService) SendMessage(chatroomID primitive.ObjectID, userID uint, username string, messageType, textContent, mediaURL string) (*models.Message, error) {
+	return s.sendMessage(chatroomID, userID, username, messageType, textContent, mediaURL, nil)
+}
+
+// sendMessage validates and stores a message; attachments are only set on album messages
+func (s *MessageService) sendMessage(chatroomID primitive.ObjectID, userID uint, username string, messageType, textContent, mediaURL string, attachments []models.Attachment) (*models.Message, error) {
 	// Check if chatroom exists and user is a member
 	chatroom, err := s.ChatSvc.GetChatroomByID(chatroomID)
 	if err != nil {
@@ -48,25 +147,50 @@ func (s *MessageService) SendMessage(chatroomID primitive.ObjectID, userID uint,
 		return nil, errors.New("user is not a member of this chatroom")
 	}
 
+	// Read-only members can't post
+	if s.ChatSvc.GetMemberRole(chatroom, userID) == models.ChatroomRoleReadonly {
+		return nil, errors.New("user is read-only in this chatroom")
+	}
+
 	// Validate message type and required fields
-	switch messageType {
-	case "text":
-		if textContent == "" {
-			return nil, errors.New("text content is required for text messages")
+	if messageType == models.MessageTypeAlbum {
+		if len(attachments) == 0 {
+			return nil, errors.New("attachments are required for album messages")
 		}
-	case "picture", "audio", "video":
-		if mediaURL == "" {
-			return nil, errors.New("media URL is required for media messages")
+		if len(attachments) > models.MaxAlbumAttachments {
+			return nil, errors.New("too many attachments")
 		}
-	case "text_and_picture", "text_and_audio", "text_and_video":
-		if textContent == "" {
-			return nil, errors.New("text content is required for combined messages")
+	} else if err := validateMessageContent(messageType, textContent, mediaURL); err != nil {
+		return nil, err
+	}
+
+	// Only accept media stored by this app, not arbitrary external links
+	if mediaURL != "" && !s.isAllowedMediaURL(mediaURL) {
+		return nil, errors.New("media URL is not hosted by this app")
+	}
+
+	// The room may only allow some kinds of media
+	if kind := utils.GetMediaTypeFromMessageType(messageType); kind != "" && !chatroom.AllowsMediaType(string(kind)) {
+		return nil, errors.New("this media type is not allowed in this chatroom")
+	}
+	for _, attachment := range attachments {
+		if !s.isAllowedMediaURL(attachment.URL) {
+			return nil, errors.New("media URL is not hosted by this app")
 		}
-		if mediaURL == "" {
-			return nil, errors.New("media URL is required for combined messages")
+		if !chatroom.AllowsMediaType(attachment.MediaKind) {
+			return nil, errors.New("this media type is not allowed in this chatroom")
 		}
-	default:
-		return nil, errors.New("invalid message type")
+	}
+
+	// Pinging the whole room is reserved for admins
+	if ParseRoomMentions(textContent).Everyone && s.ChatSvc.GetMemberRole(chatroom, userID) != models.ChatroomRoleAdmin {
+		return nil, errors.New("only chatroom admins can mention everyone")
+	}
+
+	// Apply the room's banned-word policy
+	textContent, err = s.applyMessageFilter(chatroom, textContent)
+	if err != nil {
+		return nil, err
 	}
 
 	// Create new message
@@ -78,16 +202,28 @@ func (s *MessageService) SendMessage(chatroomID primitive.ObjectID, userID uint,
 		MessageType: messageType,
 		TextContent: textContent,
 		MediaURL:    mediaURL,
-		SentAt:      time.Now(),
+		MediaKind:   string(utils.GetMediaTypeFromMessageType(messageType)),
+		Attachments: attachments,
+		SentAt:      s.nextSentAt(chatroomID),
 		Edited:      false,
 		EditedAt:    nil,
 	}
 
-	// Save message to MongoDB
-	_, err = s.MsgColl.InsertOne(context.Background(), message)
+	// Save message to MongoDB (the returned copy keeps the plaintext)
+	stored := message
+	if stored.TextContent, stored.TextEncrypted, err = s.encryptStoredText(message.TextContent); err != nil {
+		return nil, err
+	}
+	_, err = s.MsgColl.InsertOne(context.Background(), stored)
 	if err != nil {
 		return nil, errors.New("failed to send message")
 	}
+	utils.MessagesSentTotal.Inc()
+
+	// The draft for this room was just sent; a failure only leaves a stale draft behind
+	if err := s.DeleteDraft(chatroomID, userID); err != nil {
+		log.Printf("Failed to clear draft for user %d in chatroom %s: %v", userID, chatroomID.Hex(), err)
+	}
 
 	// Create read status entries for all chatroom members (except sender)
 	if s.ReadStatusSvc != nil {
@@ -101,6 +237,187 @@ func (s *MessageService) SendMessage(chatroomID primitive.ObjectID, userID uint,
 	return &message, nil
 }
 
+// CreateSystemMessage adds a server notice (e.g. a member joining) to a chatroom's transcript.
+// No read statuses are created for it, so it never counts as unread.
+func (s *MessageService) CreateSystemMessage(chatroomID primitive.ObjectID, event models.SystemEvent) (*models.Message, error) {
+	message := models.Message{
+		ID:          primitive.NewObjectID(),
+		ChatroomID:  chatroomID,
+		SenderID:    models.SystemSenderID,
+		MessageType: models.MessageTypeSystem,
+		TextContent: event.Text(),
+		SentAt:      s.nextSentAt(chatroomID),
+		SystemEvent: &event,
+	}
+
+	stored := message
+	storedText, encrypted, err := s.encryptStoredText(message.TextContent)
+	if err != nil {
+		return nil, err
+	}
+	stored.TextContent, stored.TextEncrypted = storedText, encrypted
+	if _, err := s.MsgColl.InsertOne(context.Background(), stored); err != nil {
+		return nil, errors.New("failed to create system message")
+	}
+	return &message, nil
+}
+
+// isAllowedMediaURL reports whether mediaURL is a file in the media store or a local /media/ path
+func (s *MessageService) isAllowedMediaURL(mediaURL string) bool {
+	if s.Media != nil && s.Media.IsOwnedAssetURL(mediaURL) {
+		return true
+	}
+	return strings.HasPrefix(mediaURL, "/media/") && !strings.Contains(mediaURL, "..")
+}
+
+// applyMessageFilter rejects or masks banned words in text according to the chatroom's filter policy
+func (s *MessageService) applyMessageFilter(chatroom *models.Chatroom, text string) (string, error) {
+	if !s.Filter.Contains(text) {
+		return text, nil
+	}
+
+	switch chatroom.GetFilterPolicy() {
+	case models.ChatroomFilterReject:
+		return "", errors.New("message contains blocked content")
+	case models.ChatroomFilterOff:
+		return text, nil
+	default:
+		return s.Filter.Mask(text), nil
+	}
+}
+
+// SendMessageWithMedia uploads files and sends them as a message in one step.
+// A single file becomes a normal media message whose type is inferred from the file extension and
+// whether text is present; several files become one album message with up to models.MaxAlbumAttachments items.
+// If an upload fails no message is created; if creating the message fails the uploads are removed.
+func (s *MessageService) SendMessageWithMedia(chatroomID primitive.ObjectID, userID uint, username, textContent string, files []*multipart.FileHeader) (*models.Message, error) {
+	if len(files) == 0 {
+		return nil, errors.New("no file provided")
+	}
+	if len(files) > models.MaxAlbumAttachments {
+		return nil, errors.New("too many attachments")
+	}
+
+	// Check membership before uploading so rejected senders don't leave orphaned media
+	chatroom, err := s.ChatSvc.GetChatroomByID(chatroomID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !s.ChatSvc.IsMember(chatroom, userID) {
+		return nil, errors.New("user is not a member of this chatroom")
+	}
+
+	if s.ChatSvc.GetMemberRole(chatroom, userID) == models.ChatroomRoleReadonly {
+		return nil, errors.New("user is read-only in this chatroom")
+	}
+
+	// Every file is checked before anything is uploaded, so a bad file in an album stores nothing
+	mediaTypes := make([]utils.MediaType, len(files))
+	for i, file := range files {
+		mediaType := utils.GetMediaTypeFromExtension(filepath.Ext(file.Filename))
+		if mediaType == "" {
+			return nil, errors.New("invalid file type for the specified media type")
+		}
+		if !chatroom.AllowsMediaType(string(mediaType)) {
+			return nil, errors.New("this media type is not allowed in this chatroom")
+		}
+		mediaTypes[i] = mediaType
+	}
+
+	if s.Media == nil {
+		return nil, errors.New("media storage not initialized")
+	}
+
+	attachments := make([]models.Attachment, 0, len(files))
+	for i, file := range files {
+		mediaURL, err := s.Media.UploadFile(file, mediaTypes[i])
+		if err != nil {
+			s.deleteAttachments(attachments)
+			return nil, mediaUploadError{err}
+		}
+		attachments = append(attachments, models.Attachment{URL: mediaURL, MediaKind: string(mediaTypes[i])})
+	}
+
+	var message *models.Message
+	if len(attachments) == 1 {
+		messageType := utils.GetMessageTypeFromMediaType(mediaTypes[0], strings.TrimSpace(textContent) != "")
+		message, err = s.SendMessage(chatroomID, userID, username, messageType, textContent, attachments[0].URL)
+	} else {
+		message, err = s.sendMessage(chatroomID, userID, username, models.MessageTypeAlbum, textContent, "", attachments)
+	}
+	if err != nil {
+		// Don't leave the uploaded files behind
+		s.deleteAttachments(attachments)
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// ErrMediaUpload matches (with errors.Is) the errors SendMessageWithMedia returns when storing a file
+// failed, as opposed to the message being rejected
+var ErrMediaUpload = errors.New("failed to upload media")
+
+// mediaUploadError is a failed upload. It keeps the storage error's text, which known errors are mapped by.
+type mediaUploadError struct {
+	err error
+}
+
+func (e mediaUploadError) Error() string        { return e.err.Error() }
+func (e mediaUploadError) Unwrap() error        { return e.err }
+func (e mediaUploadError) Is(target error) bool { return target == ErrMediaUpload }
+
+// deleteAttachments removes already uploaded files after sending failed part-way
+func (s *MessageService) deleteAttachments(attachments []models.Attachment) {
+	for _, attachment := range attachments {
+		_ = s.Media.DeleteFile(attachment.URL)
+	}
+}
+
+// CanUserAccessMedia reports whether the user is a member of a chatroom containing a message with this media URL
+func (s *MessageService) CanUserAccessMedia(mediaURL string, userID uint) (bool, error) {
+	chatroomIDs, err := s.MsgColl.Distinct(context.Background(), "chatroom_id", bson.M{
+		"$or": []bson.M{{"media_url": mediaURL}, {"attachments.url": mediaURL}},
+	})
+	if err != nil {
+		return false, errors.New("failed to find messages")
+	}
+
+	if len(chatroomIDs) == 0 {
+		return false, nil
+	}
+
+	count, err := s.ChatSvc.ChatColl.CountDocuments(context.Background(), bson.M{
+		"_id":             bson.M{"$in": chatroomIDs},
+		"members.user_id": userID,
+	})
+	if err != nil {
+		return false, errors.New("failed to check chatroom membership")
+	}
+
+	return count > 0, nil
+}
+
+// DefaultMessageLimit is the page size used when a history request doesn't give one
+const DefaultMessageLimit = 50
+
+// MaxMessageLimit returns the largest number of messages a single history request may return (MESSAGE_HISTORY_MAX_LIMIT)
+func (s *MessageService) MaxMessageLimit() int {
+	return s.maxMessageLimit
+}
+
+// ClampMessageLimit applies the default for missing/invalid limits and caps the rest at MaxMessageLimit
+func (s *MessageService) ClampMessageLimit(limit int) int {
+	if limit <= 0 {
+		return DefaultMessageLimit
+	}
+	if maxLimit := s.MaxMessageLimit(); limit > maxLimit {
+		return maxLimit
+	}
+	return limit
+}
+
 // GetMessages retrieves messages from a chatroom
 func (s *MessageService) GetMessages(chatroomID primitive.ObjectID, userID uint, limit int) ([]models.Message, error) {
 	// Check if chatroom exists and user is a member
@@ -114,10 +431,8 @@ func (s *MessageService) GetMessages(chatroomID primitive.ObjectID, userID uint,
 		return nil, errors.New("user is not a member of this chatroom")
 	}
 
-	// Set default limit if not provided
-	if limit <= 0 {
-		limit = 50
-	}
+	// Apply the default and cap so one request can't load an entire history
+	limit = s.ClampMessageLimit(limit)
 
 	// Find messages for the chatroom
 	findOptions := options.Find().SetSort(bson.M{"sent_at": -1}).SetLimit(int64(limit))
@@ -136,6 +451,23 @@ func (s *MessageService) GetMessages(chatroomID primitive.ObjectID, userID uint,
 	return messages, nil
 }
 
+// CountMessages returns the number of messages in a chatroom without loading any of them
+func (s *MessageService) CountMessages(chatroomID primitive.ObjectID, userID uint) (int64, error) {
+	chatroom, err := s.ChatSvc.GetChatroomByID(chatroomID)
+	if err != nil {
+		return 0, err
+	}
+	if !s.ChatSvc.IsMember(chatroom, userID) {
+		return 0, errors.New("user is not a member of this chatroom")
+	}
+
+	count, err := s.MsgColl.CountDocuments(context.Background(), bson.M{"chatroom_id": chatroomID})
+	if err != nil {
+		return 0, errors.New("failed to count messages")
+	}
+	return count, nil
+}
+
 // GetMessagesWithReadStatus retrieves messages from a chatroom with read status information
 func (s *MessageService) GetMessagesWithReadStatus(chatroomID primitive.ObjectID, userID uint, limit int) ([]models.MessageResponse, error) {
 	// Get messages first
@@ -145,22 +477,120 @@ func (s *MessageService) GetMessagesWithReadStatus(chatroomID primitive.ObjectID
 	}
 
 	// Convert to response format with read status
-	var messageResponses []models.MessageResponse
+	return s.ResponsesWithReadStatus(messages), nil
+}
+
+// ResponsesWithReadStatus converts messages to responses with their read status attached, loading
+// the read status of the whole list in one batch rather than a lookup per message
+func (s *MessageService) ResponsesWithReadStatus(messages []models.Message) []models.MessageResponse {
+	if s.ReadStatusSvc != nil {
+		return s.ReadStatusSvc.ResponsesWithReadStatus(messages)
+	}
+
+	responses := make([]models.MessageResponse, 0, len(messages))
 	for _, message := range messages {
-		response := message.ToResponse()
+		responses = append(responses, message.ToResponse())
+	}
+	return responses
+}
 
-		// Get read status for this message if read status service is available
-		if s.ReadStatusSvc != nil {
-			readStatus, err := s.ReadStatusSvc.GetMessageReadStatus(message.ID)
-			if err == nil {
-				response.ReadStatus = readStatus
-			}
-		}
+// MessageContextResponse represents the messages around an anchor message
+type MessageContextResponse struct {
+	Messages      []models.MessageResponse `json:"messages"`        // Messages in chronological order, including the anchor
+	AnchorID      string                   `json:"anchor_id"`       // ID of the message the window is centred on
+	HasMoreBefore bool                     `json:"has_more_before"` // Whether older messages exist before the window
+	HasMoreAfter  bool                     `json:"has_more_after"`  // Whether newer messages exist after the window
+}
+
+// GetMessagesAround returns up to radius messages before and after the anchor message, in chronological order.
+// Near the start or end of history the window is simply shorter on that side.
+func (s *MessageService) GetMessagesAround(chatroomID primitive.ObjectID, userID uint, messageID primitive.ObjectID, radius int) (*MessageContextResponse, error) {
+	// Check if chatroom exists and user is a member
+	chatroom, err := s.ChatSvc.GetChatroomByID(chatroomID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check if user is a member of the chatroom
+	if !s.ChatSvc.IsMember(chatroom, userID) {
+		return nil, errors.New("user is not a member of this chatroom")
+	}
+
+	if radius <= 0 {
+		radius = 20
+	}
+
+	ctx := context.Background()
+
+	// The anchor must belong to this chatroom
+	var anchor models.Message
+	err = s.MsgColl.FindOne(ctx, bson.M{"_id": messageID, "chatroom_id": chatroomID}).Decode(&anchor)
+	if err != nil {
+		return nil, errors.New("message not found")
+	}
+
+	// Order by (sent_at, _id) so messages sharing a timestamp are neither skipped nor repeated.
+	// One extra message is fetched on each side to tell whether more exist.
+	before, err := s.findMessagesBeside(ctx, chatroomID, anchor, "$lt", -1, radius+1)
+	if err != nil {
+		return nil, err
+	}
+	after, err := s.findMessagesBeside(ctx, chatroomID, anchor, "$gt", 1, radius+1)
+	if err != nil {
+		return nil, err
+	}
 
-		messageResponses = append(messageResponses, response)
+	hasMoreBefore := len(before) > radius
+	if hasMoreBefore {
+		before = before[:radius]
+	}
+	hasMoreAfter := len(after) > radius
+	if hasMoreAfter {
+		after = after[:radius]
 	}
 
-	return messageResponses, nil
+	// before is newest-first; flip it so the window reads oldest to newest
+	messages := make([]models.Message, 0, len(before)+1+len(after))
+	for i := len(before) - 1; i >= 0; i-- {
+		messages = append(messages, before[i])
+	}
+	messages = append(messages, anchor)
+	messages = append(messages, after...)
+
+	return &MessageContextResponse{
+		Messages:      s.ResponsesWithReadStatus(messages),
+		AnchorID:      anchor.ID.Hex(),
+		HasMoreBefore: hasMoreBefore,
+		HasMoreAfter:  hasMoreAfter,
+	}, nil
+}
+
+// findMessagesBeside finds up to limit messages on one side of the anchor.
+// op is "$lt" (older, sorted newest first with direction -1) or "$gt" (newer, oldest first with direction 1).
+func (s *MessageService) findMessagesBeside(ctx context.Context, chatroomID primitive.ObjectID, anchor models.Message, op string, direction, limit int) ([]models.Message, error) {
+	filter := bson.M{
+		"chatroom_id": chatroomID,
+		"$or": bson.A{
+			bson.M{"sent_at": bson.M{op: anchor.SentAt}},
+			bson.M{"sent_at": anchor.SentAt, "_id": bson.M{op: anchor.ID}},
+		},
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "sent_at", Value: direction}, {Key: "_id", Value: direction}}).
+		SetLimit(int64(limit))
+
+	cursor, err := s.MsgColl.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, errors.New("failed to get messages")
+	}
+	defer cursor.Close(ctx)
+
+	var messages []models.Message
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, errors.New("failed to decode messages")
+	}
+
+	return messages, nil
 }
 
 // PaginatedMessagesResponse represents the response for paginated messages
@@ -216,26 +646,8 @@ func (s *MessageService) GetMessagesPaginated(chatroomID primitive.ObjectID, use
 		return nil, err
 	}
 
-	// Convert to response format with read status
-	var messageResponses []models.MessageResponse
-	for _, message := range messages {
-		response := message.ToResponse()
-
-		// Get read status for this message if read status service is available
-		if s.ReadStatusSvc != nil {
-			readStatus, err := s.ReadStatusSvc.GetMessageReadStatus(message.ID)
-			if err == nil {
-				response.ReadStatus = readStatus
-			}
-		}
-
-		messageResponses = append(messageResponses, response)
-	}
-
-	// Ensure messageResponses is never nil
-	if messageResponses == nil {
-		messageResponses = []models.MessageResponse{}
-	}
+	// Convert to response format with read status (never nil)
+	messageResponses := s.ResponsesWithReadStatus(messages)
 
 	return &PaginatedMessagesResponse{
 		Messages:    messageResponses,
@@ -246,41 +658,222 @@ func (s *MessageService) GetMessagesPaginated(chatroomID primitive.ObjectID, use
 	}, nil
 }
 
-// DeleteMessage deletes a message and its associated media
-func (s *MessageService) DeleteMessage(messageID primitive.ObjectID, userID uint) error {
+// SenderMessagesResponse is one page of a single sender's messages in a chatroom
+type SenderMessagesResponse struct {
+	Messages   []models.MessageResponse `json:"messages"`              // Oldest to newest within the page
+	HasMore    bool                     `json:"has_more"`              // Whether the sender has older messages in the chatroom
+	NextCursor *string                  `json:"next_cursor,omitempty"` // Pass as before to load the previous page
+}
+
+// GetMessagesBySender returns up to limit of one sender's messages in a chatroom, newest page first.
+// Pages are ordered oldest to newest; pass before to step back through the sender's history.
+// The sender doesn't have to still be a member, but the requester does.
+func (s *MessageService) GetMessagesBySender(chatroomID primitive.ObjectID, senderID, requesterID uint, limit int, before *time.Time) (*SenderMessagesResponse, error) {
+	chatroom, err := s.ChatSvc.GetChatroomByID(chatroomID)
+	if err != nil {
+		return nil, err
+	}
+	if !s.ChatSvc.IsMember(chatroom, requesterID) {
+		return nil, errors.New("user is not a member of this chatroom")
+	}
+
+	limit = s.ClampMessageLimit(limit)
+
+	// Served by sender_sent_at_idx (sender_id, sent_at)
+	filter := bson.M{"chatroom_id": chatroomID, "sender_id": senderID}
+	if before != nil {
+		filter["sent_at"] = bson.M{"$lt": *before}
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "sent_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(int64(limit + 1))
+
+	ctx := context.Background()
+	cursor, err := s.MsgColl.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, errors.New("failed to get messages")
+	}
+	defer cursor.Close(ctx)
+
+	var messages []models.Message
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, errors.New("failed to decode messages")
+	}
+
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
+	}
+
+	var nextCursor *string
+	if hasMore {
+		oldest := messages[len(messages)-1].SentAt.Format(time.RFC3339Nano)
+		nextCursor = &oldest
+	}
+
+	// Fetched newest first; return the page in reading order
+	slices.Reverse(messages)
+
+	return &SenderMessagesResponse{
+		Messages:   s.ResponsesWithReadStatus(messages),
+		HasMore:    hasMore,
+		NextCursor: nextCursor,
+	}, nil
+}
+
+// MaxMessageRange is the longest span GetMessagesInRange accepts in one request
+const MaxMessageRange = 31 * 24 * time.Hour
+
+// MessageRangeResponse is one page of a chatroom's messages between two times
+type MessageRangeResponse struct {
+	Messages   []models.MessageResponse `json:"messages"`              // Oldest to newest
+	HasMore    bool                     `json:"has_more"`              // Whether the range holds more messages after this page
+	NextCursor *string                  `json:"next_cursor,omitempty"` // Pass as cursor, with the same range, to load the next page
+}
+
+// GetMessagesInRange returns up to limit messages sent in a chatroom between from and to (both inclusive),
+// oldest first. pageCursor, if set, is the next_cursor of the previous page: the position of its last
+// message, so messages sharing a send time aren't skipped or repeated across pages.
+func (s *MessageService) GetMessagesInRange(chatroomID primitive.ObjectID, userID uint, from, to time.Time, limit int, pageCursor string) (*MessageRangeResponse, error) {
+	var after *messageCursor
+	if pageCursor != "" {
+		decoded, err := decodeMessageCursor(pageCursor)
+		if err != nil {
+			return nil, err
+		}
+		after = decoded
+	}
+	if from.After(to) {
+		return nil, errors.New("invalid date range")
+	}
+	if to.Sub(from) > MaxMessageRange {
+		return nil, errors.New("date range is too long")
+	}
+
+	chatroom, err := s.ChatSvc.GetChatroomByID(chatroomID)
+	if err != nil {
+		return nil, err
+	}
+	if !s.ChatSvc.IsMember(chatroom, userID) {
+		return nil, errors.New("user is not a member of this chatroom")
+	}
+
+	limit = s.ClampMessageLimit(limit)
+
+	filter := bson.M{
+		"chatroom_id": chatroomID,
+		"sent_at":     bson.M{"$gte": from, "$lte": to},
+	}
+	if after != nil {
+		filter["$or"] = bson.A{
+			bson.M{"sent_at": bson.M{"$gt": after.SentAt}},
+			bson.M{"sent_at": after.SentAt, "_id": bson.M{"$gt": after.MessageID}},
+		}
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "sent_at", Value: 1}, {Key: "_id", Value: 1}}).
+		SetLimit(int64(limit + 1))
+
+	ctx := context.Background()
+	cursor, err := s.MsgColl.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, errors.New("failed to get messages")
+	}
+	defer cursor.Close(ctx)
+
+	var messages []models.Message
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, errors.New("failed to decode messages")
+	}
+
+	var nextCursor *string
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
+		last := messages[len(messages)-1]
+		next := messageCursor{SentAt: last.SentAt, MessageID: last.ID}.encode()
+		nextCursor = &next
+	}
+
+	return &MessageRangeResponse{
+		Messages:   s.ResponsesWithReadStatus(messages),
+		HasMore:    hasMore,
+		NextCursor: nextCursor,
+	}, nil
+}
+
+// DeleteMessage deletes a message and its associated media, returning the message as it was
+func (s *MessageService) DeleteMessage(messageID primitive.ObjectID, userID uint) (*models.Message, error) {
 	// Find the message
 	var message models.Message
 	err := s.MsgColl.FindOne(context.Background(), bson.M{"_id": messageID}).Decode(&message)
 	if err != nil {
-		return errors.New("message not found")
+		return nil, errors.New("message not found")
 	}
 
-	// Check if the user is the sender of the message
-	if message.SenderID != userID {
-		return errors.New("user is not the sender of this message")
+	// Check if the user is the sender of the message, or a chatroom admin moderating it
+	if message.SenderID != userID && !s.isChatroomAdmin(message.ChatroomID, userID) {
+		return nil, errors.New("user is not the sender of this message")
 	}
 
-	// Delete media from Cloudinary if exists
-	if message.MediaURL != "" && s.CloudinarySvc != nil {
-		err = s.CloudinarySvc.DeleteFile(message.MediaURL)
-		if err != nil {
-			// Log error but don't fail the deletion
-			// In production, you might want to queue this for retry
-			// For now, we'll continue with message deletion
+	// Delete stored media if exists (every attachment, for albums)
+	if s.Media != nil {
+		for _, mediaURL := range message.MediaURLs() {
+			err = s.Media.DeleteFile(mediaURL)
+			if err != nil {
+				// Log error but don't fail the deletion
+				// In production, you might want to queue this for retry
+				// For now, we'll continue with message deletion
+			}
 		}
 	}
 
 	// Delete the message from database
 	_, err = s.MsgColl.DeleteOne(context.Background(), bson.M{"_id": messageID})
 	if err != nil {
-		return errors.New("failed to delete message")
+		return nil, errors.New("failed to delete message")
 	}
 
-	return nil
+	return &message, nil
+}
+
+// GetMessageForNotificationResend loads a message whose push notification is being re-sent.
+// Only the sender or a chatroom admin may do this, and system messages never had one.
+func (s *MessageService) GetMessageForNotificationResend(messageID primitive.ObjectID, userID uint) (*models.Message, error) {
+	var message models.Message
+	err := s.MsgColl.FindOne(context.Background(), bson.M{"_id": messageID}).Decode(&message)
+	if err != nil {
+		return nil, errors.New("message not found")
+	}
+
+	if message.SenderID != userID && !s.isChatroomAdmin(message.ChatroomID, userID) {
+		return nil, errors.New("user is not the sender of this message")
+	}
+
+	if message.IsSystem() {
+		return nil, errors.New("system messages have no push notification")
+	}
+
+	return &message, nil
+}
+
+// isChatroomAdmin reports whether the user is an admin (or the creator) of the chatroom
+func (s *MessageService) isChatroomAdmin(chatroomID primitive.ObjectID, userID uint) bool {
+	chatroom, err := s.ChatSvc.GetChatroomByID(chatroomID)
+	if err != nil {
+		return false
+	}
+	return s.ChatSvc.GetMemberRole(chatroom, userID) == models.ChatroomRoleAdmin
 }
 
-// UpdateMessage updates a message with new content and/or media
-func (s *MessageService) UpdateMessage(messageID primitive.ObjectID, userID uint, textContent, newMediaURL, newMessageType string) (*models.Message, error) {
+// UpdateMessage updates a message with new content and/or media.
+// Nil fields are left untouched, so callers can swap media without resending text (and vice versa).
+// A non-nil empty string clears the field.
+func (s *MessageService) UpdateMessage(messageID primitive.ObjectID, userID uint, textContent, newMediaURL, newMessageType *string) (*models.Message, error) {
+	if textContent == nil && newMediaURL == nil && newMessageType == nil {
+		return nil, errors.New("no changes provided")
+	}
+
 	// Find the message
 	var message models.Message
 	err := s.MsgColl.FindOne(context.Background(), bson.M{"_id": messageID}).Decode(&message)
@@ -293,63 +886,94 @@ func (s *MessageService) UpdateMessage(messageID primitive.ObjectID, userID uint
 		return nil, errors.New("user is not the sender of this message")
 	}
 
-	// If media URL is being changed, delete the old media from Cloudinary
-	if message.MediaURL != "" && message.MediaURL != newMediaURL && s.CloudinarySvc != nil {
-		err = s.CloudinarySvc.DeleteFile(message.MediaURL)
-		if err != nil {
-			// Log error but don't fail the update
-			// In production, you might want to queue this for retry
+	chatroom, err := s.ChatSvc.GetChatroomByID(message.ChatroomID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Editing is posting new content, so it needs the same rights as sending: senders who left the
+	// room or were made read-only can't rewrite what they said before
+	if !s.ChatSvc.IsMember(chatroom, userID) {
+		return nil, errors.New("user is not a member of this chatroom")
+	}
+	if s.ChatSvc.GetMemberRole(chatroom, userID) == models.ChatroomRoleReadonly {
+		return nil, errors.New("user is read-only in this chatroom")
+	}
+
+	// Rewriting a message long after others replied would change history under them, so once the
+	// room's edit window has passed only chatroom admins may still edit their messages
+	if window := chatroom.MessageEditWindow(s.editWindow); window > 0 && time.Since(message.SentAt) > window &&
+		s.ChatSvc.GetMemberRole(chatroom, userID) != models.ChatroomRoleAdmin {
+		return nil, errors.New("message edit window has expired")
+	}
+
+	// An album's attachments are fixed once sent; only its caption can be edited
+	isAlbum := message.MessageType == models.MessageTypeAlbum
+	if isAlbum && (newMediaURL != nil || newMessageType != nil) {
+		return nil, errors.New("album attachments cannot be edited")
+	}
+
+	// Resolve the final field values, keeping existing ones where nothing was provided
+	finalText := message.TextContent
+	if textContent != nil {
+		finalText = *textContent
+	}
+	finalMediaURL := message.MediaURL
+	if newMediaURL != nil {
+		finalMediaURL = *newMediaURL
+	}
+	if finalText == "" && finalMediaURL == "" && !isAlbum {
+		return nil, errors.New("message must have text or media")
+	}
+	mediaChanged := newMediaURL != nil && finalMediaURL != message.MediaURL
+	if mediaChanged && finalMediaURL != "" {
+		if !s.isAllowedMediaURL(finalMediaURL) {
+			return nil, errors.New("media URL is not hosted by this app")
+		}
+		// Make sure the replacement actually exists before the message stops pointing at the old asset
+		if s.Media != nil && s.Media.IsOwnedAssetURL(finalMediaURL) && !s.Media.AssetExists(finalMediaURL) {
+			return nil, errors.New("media file not found")
 		}
 	}
 
-	// Determine the new message type based on content
-	finalMessageType := newMessageType
-	if finalMessageType == "" {
-		// Auto-determine message type based on content
-		if textContent != "" && newMediaURL != "" {
-			// Determine combined type based on media URL or existing type
-			if strings.Contains(message.MessageType, "picture") || strings.Contains(newMessageType, "picture") {
-				finalMessageType = "text_and_picture"
-			} else if strings.Contains(message.MessageType, "audio") || strings.Contains(newMessageType, "audio") {
-				finalMessageType = "text_and_audio"
-			} else if strings.Contains(message.MessageType, "video") || strings.Contains(newMessageType, "video") {
-				finalMessageType = "text_and_video"
-			} else {
-				finalMessageType = "text_and_picture" // Default for combined
-			}
-		} else if textContent != "" && newMediaURL == "" {
-			finalMessageType = "text"
-		} else if textContent == "" && newMediaURL != "" {
-			// Determine media type based on URL or existing type
-			if strings.Contains(message.MessageType, "picture") || strings.Contains(newMessageType, "picture") {
-				finalMessageType = "picture"
-			} else if strings.Contains(message.MessageType, "audio") || strings.Contains(newMessageType, "audio") {
-				finalMessageType = "audio"
-			} else if strings.Contains(message.MessageType, "video") || strings.Contains(newMessageType, "video") {
-				finalMessageType = "video"
-			} else {
-				finalMessageType = "picture" // Default for media only
-			}
-		} else {
-			// Keep original type if no content changes
-			finalMessageType = message.MessageType
+	// Edited text goes through the same banned-word policy as new messages
+	if textContent != nil && s.Filter.Contains(finalText) {
+		finalText, err = s.applyMessageFilter(chatroom, finalText)
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	// Prepare update fields
+	// Work out what kind of media the message ends up with, then its type
+	finalMessageType, mediaKind, err := editedMessageType(&message, finalText, finalMediaURL, mediaChanged, newMessageType)
+	if err != nil {
+		return nil, err
+	}
+
+	// Swapping in media the room doesn't allow, or relabelling media as a kind it doesn't allow,
+	// is refused like sending it
+	kindChanged := mediaKind != storedMediaKind(&message)
+	if (mediaChanged || kindChanged) && mediaKind != "" && !chatroom.AllowsMediaType(string(mediaKind)) {
+		return nil, errors.New("this media type is not allowed in this chatroom")
+	}
+
+	// Prepare update fields (only overwrite what was provided)
 	updateFields := bson.M{
-		"text_content": textContent,
 		"message_type": finalMessageType,
+		"media_kind":   string(mediaKind),
 		"edited":       true,
 		"edited_at":    time.Now(),
 	}
-
-	// Update media URL
-	if newMediaURL != "" {
-		updateFields["media_url"] = newMediaURL
-	} else {
-		// If removing media, set to empty string
-		updateFields["media_url"] = ""
+	if textContent != nil {
+		storedText, encrypted, err := s.encryptStoredText(finalText)
+		if err != nil {
+			return nil, err
+		}
+		updateFields["text_content"] = storedText
+		updateFields["text_encrypted"] = encrypted
+	}
+	if newMediaURL != nil {
+		updateFields["media_url"] = finalMediaURL
 	}
 
 	// Update the message
@@ -359,9 +983,22 @@ func (s *MessageService) UpdateMessage(messageID primitive.ObjectID, userID uint
 		bson.M{"$set": updateFields},
 	)
 	if err != nil {
+		// The old media is still referenced, so it is left in place; the replacement was uploaded
+		// for this edit and nothing points at it now
+		if mediaChanged && finalMediaURL != "" {
+			s.discardUnusedMedia(finalMediaURL)
+		}
 		return nil, errors.New("failed to update message")
 	}
 
+	// Only now that the message points at the new media is the old asset removed
+	oldMediaURL := message.MediaURL
+	if mediaChanged && oldMediaURL != "" && s.Media != nil {
+		if err := s.Media.DeleteFile(oldMediaURL); err != nil {
+			log.Printf("Failed to delete replaced media %s for message %s: %v", oldMediaURL, messageID.Hex(), err)
+		}
+	}
+
 	// Get the updated message
 	err = s.MsgColl.FindOne(context.Background(), bson.M{"_id": messageID}).Decode(&message)
 	if err != nil {
@@ -371,9 +1008,114 @@ func (s *MessageService) UpdateMessage(messageID primitive.ObjectID, userID uint
 	return &message, nil
 }
 
+// discardUnusedMedia deletes a stored file that no message refers to, such as the replacement
+// uploaded for an edit that failed. Files still in use, or not in the media store, are kept.
+func (s *MessageService) discardUnusedMedia(mediaURL string) {
+	if s.Media == nil || !s.Media.IsOwnedAssetURL(mediaURL) {
+		return
+	}
+
+	filter := bson.M{"$or": []bson.M{{"media_url": mediaURL}, {"attachments.url": mediaURL}}}
+	inUse, err := s.MsgColl.CountDocuments(context.Background(), filter)
+	if err != nil || inUse > 0 {
+		return
+	}
+	if err := s.Media.DeleteFile(mediaURL); err != nil {
+		log.Printf("Failed to delete unused media %s: %v", mediaURL, err)
+	}
+}
+
+// editedMessageType works out the type and media kind of a message after an edit that leaves it with
+// finalText and finalMediaURL. An explicit newMessageType must be a type clients can send and fit the
+// resulting content; without one the type is derived from it. Albums keep their type.
+func editedMessageType(message *models.Message, finalText, finalMediaURL string, mediaChanged bool, newMessageType *string) (string, utils.MediaType, error) {
+	if message.MessageType == models.MessageTypeAlbum {
+		return models.MessageTypeAlbum, storedMediaKind(message), nil
+	}
+
+	mediaKind := storedMediaKind(message)
+	if mediaChanged && finalMediaURL != "" {
+		if kind := mediaKindFromURL(finalMediaURL); kind != "" {
+			mediaKind = kind
+		}
+	}
+
+	var finalMessageType string
+	if newMessageType != nil && *newMessageType != "" {
+		finalMessageType = *newMessageType
+		if err := validateMessageContent(finalMessageType, finalText, finalMediaURL); err != nil {
+			return "", "", err
+		}
+		if kind := utils.GetMediaTypeFromMessageType(finalMessageType); kind != "" {
+			mediaKind = kind
+		}
+	} else {
+		finalMessageType = deriveMessageType(mediaKind, finalText, finalMediaURL)
+	}
+	if finalMediaURL == "" {
+		mediaKind = ""
+	}
+	return finalMessageType, mediaKind, nil
+}
+
+// storedMediaKind returns the message's media kind. Messages stored before media_kind existed
+// fall back to the kind implied by their message type.
+func storedMediaKind(message *models.Message) utils.MediaType {
+	if message.MediaKind != "" {
+		return utils.MediaType(message.MediaKind)
+	}
+	return utils.GetMediaTypeFromMessageType(message.MessageType)
+}
+
+// mediaKindFromURL infers the media kind from the file extension in a media URL (empty if unknown)
+func mediaKindFromURL(mediaURL string) utils.MediaType {
+	if parsedURL, err := url.Parse(mediaURL); err == nil {
+		return utils.GetMediaTypeFromExtension(path.Ext(parsedURL.Path))
+	}
+	return ""
+}
+
+// deriveMessageType picks a message type from the resulting text/media and the message's media kind
+func deriveMessageType(mediaKind utils.MediaType, textContent, mediaURL string) string {
+	if mediaURL == "" {
+		return "text"
+	}
+	if mediaKind == "" {
+		mediaKind = utils.ImageMedia // Unknown media has always been treated as a picture
+	}
+	return utils.GetMessageTypeFromMediaType(mediaKind, textContent != "")
+}
+
+// RefreshSenderName updates the denormalized sender name on all of a user's messages
+// and their username in chatroom member lists after a rename
+func (s *MessageService) RefreshSenderName(userID uint, newName string) error {
+	ctx := context.Background()
+
+	_, err := s.MsgColl.UpdateMany(ctx,
+		bson.M{"sender_id": userID},
+		bson.M{"$set": bson.M{"sender_name": newName}},
+	)
+	if err != nil {
+		return errors.New("failed to refresh sender name")
+	}
+
+	_, err = s.MongoDB.Collection("chatrooms").UpdateMany(ctx,
+		bson.M{"members.user_id": userID},
+		bson.M{"$set": bson.M{"members.$[member].username": newName}},
+		options.Update().SetArrayFilters(options.ArrayFilters{
+			Filters: []any{bson.M{"member.user_id": userID}},
+		}),
+	)
+	if err != nil {
+		return errors.New("failed to refresh member username")
+	}
+
+	return nil
+}
+
 // EditMessage edits only the text content of a message (legacy function for backward compatibility)
 func (s *MessageService) EditMessage(messageID primitive.ObjectID, userID uint, textContent string) (*models.Message, error) {
-	return s.UpdateMessage(messageID, userID, textContent, "", "")
+	return s.UpdateMessage(messageID, userID, &textContent, nil, nil)
 }
 
 // DeleteAllMessagesInChatroom deletes all messages in a chatroom and their associated media
@@ -385,8 +1127,8 @@ func (s *MessageService) DeleteAllMessagesInChatroom(chatroomID primitive.Object
 	}
 	defer cursor.Close(context.Background())
 
-	// Delete media files from Cloudinary for each message
-	if s.CloudinarySvc != nil {
+	// Delete stored media files for each message
+	if s.Media != nil {
 		for cursor.Next(context.Background()) {
 			var message models.Message
 			if err := cursor.Decode(&message); err != nil {
@@ -394,8 +1136,8 @@ func (s *MessageService) DeleteAllMessagesInChatroom(chatroomID primitive.Object
 			}
 
 			// Delete media if exists
-			if message.MediaURL != "" {
-				err = s.CloudinarySvc.DeleteFile(message.MediaURL)
+			for _, mediaURL := range message.MediaURLs() {
+				err = s.Media.DeleteFile(mediaURL)
 				if err != nil {
 					// Log error but continue with other deletions
 					// In production, you might want to queue failed deletions for retry
@@ -416,16 +1158,12 @@ func (s *MessageService) DeleteAllMessagesInChatroom(chatroomID primitive.Object
 // getUnreadAndRecentMessages loads all unread messages plus some recent read messages
 func (s *MessageService) getUnreadAndRecentMessages(chatroomID primitive.ObjectID, userID uint) ([]models.Message, bool, *string, error) {
 	// Get all unread messages for this user
-	unreadMessages, err := s.ReadStatusSvc.GetUnreadMessagesInChatroom(chatroomID, userID)
+	// Get all unread messages for this user (newest first for mobile display)
+	unreadMessages, err := s.ReadStatusSvc.GetUnreadMessagesInChatroom(chatroomID, userID, true)
 	if err != nil {
 		return nil, false, nil, err
 	}
 
-	// Sort unread messages by sent_at (newest first for mobile display)
-	sort.Slice(unreadMessages, func(i, j int) bool {
-		return unreadMessages[i].SentAt.After(unreadMessages[j].SentAt)
-	})
-
 	// Get some recent read messages to provide context (limit to 20)
 	readLimit := 20
 	filter := bson.M{
@@ -445,7 +1183,7 @@ func (s *MessageService) getUnreadAndRecentMessages(chatroomID primitive.ObjectI
 	cursor, err := s.MsgColl.Find(
 		context.Background(),
 		filter,
-		options.Find().SetSort(bson.D{{Key: "sent_at", Value: -1}}).SetLimit(int64(readLimit)),
+		options.Find().SetSort(bson.D{{Key: "sent_at", Value: -1}, {Key: "_id", Value: -1}}).SetLimit(int64(readLimit)),
 	)
 	if err != nil {
 		return nil, false, nil, errors.New("failed to get read messages")
@@ -457,13 +1195,8 @@ func (s *MessageService) getUnreadAndRecentMessages(chatroomID primitive.ObjectI
 		return nil, false, nil, errors.New("failed to decode read messages")
 	}
 
-	// Combine read and unread messages
-	allMessages := append(readMessages, unreadMessages...)
-
-	// Sort all messages by sent_at (newest first for mobile display)
-	sort.Slice(allMessages, func(i, j int) bool {
-		return allMessages[i].SentAt.After(allMessages[j].SentAt)
-	})
+	// Both lists are already newest first, so merging them keeps that order
+	allMessages := mergeNewestFirst(readMessages, unreadMessages)
 
 	// Check if there are more messages available
 	totalMessages, _ := s.MsgColl.CountDocuments(context.Background(), bson.M{"chatroom_id": chatroomID})
@@ -480,6 +1213,31 @@ func (s *MessageService) getUnreadAndRecentMessages(chatroomID primitive.ObjectI
 	return allMessages, hasMore, nextCursor, nil
 }
 
+// newerMessage reports whether a sorts before b newest first: by sent_at, then by _id when the timestamps collide
+func newerMessage(a, b *models.Message) bool {
+	if !a.SentAt.Equal(b.SentAt) {
+		return a.SentAt.After(b.SentAt)
+	}
+	return bytes.Compare(a.ID[:], b.ID[:]) > 0
+}
+
+// mergeNewestFirst merges two message lists that are each sorted newest first (see newerMessage)
+func mergeNewestFirst(a, b []models.Message) []models.Message {
+	merged := make([]models.Message, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if newerMessage(&b[j], &a[i]) {
+			merged = append(merged, b[j])
+			j++
+		} else {
+			merged = append(merged, a[i])
+			i++
+		}
+	}
+	merged = append(merged, a[i:]...)
+	return append(merged, b[j:]...)
+}
+
 // getPaginatedMessages gets messages with standard pagination
 func (s *MessageService) getPaginatedMessages(chatroomID primitive.ObjectID, limit int, beforeTime, afterTime *time.Time) ([]models.Message, bool, *string, error) {
 	// Build filter
@@ -546,16 +1304,24 @@ func (s *MessageService) getPaginatedMessages(chatroomID primitive.ObjectID, lim
 	return messages, hasMore, nextCursor, nil
 }
 
-// GetChatroomMedia gets all media messages from a chatroom
+// GetChatroomMedia gets all media messages from a chatroom, albums included
 func (s *MessageService) GetChatroomMedia(chatroomID primitive.ObjectID) ([]models.Message, error) {
-	// Filter for messages that have media_url and are media types
+	// Filter for media messages that have a media_url, and albums that have attachments
 	filter := bson.M{
 		"chatroom_id": chatroomID,
-		"media_url":   bson.M{"$exists": true, "$ne": ""},
-		"message_type": bson.M{
-			"$in": []string{
-				"picture", "video", "audio",
-				"text_and_picture", "text_and_video", "text_and_audio",
+		"$or": bson.A{
+			bson.M{
+				"media_url": bson.M{"$exists": true, "$ne": ""},
+				"message_type": bson.M{
+					"$in": []string{
+						"picture", "video", "audio",
+						"text_and_picture", "text_and_video", "text_and_audio",
+					},
+				},
+			},
+			bson.M{
+				"message_type":  models.MessageTypeAlbum,
+				"attachments.0": bson.M{"$exists": true},
 			},
 		},
 	}
@@ -578,3 +1344,238 @@ func (s *MessageService) GetChatroomMedia(chatroomID primitive.ObjectID) ([]mode
 
 	return messages, nil
 }
+
+// UserMediaItem is one file from a user's own messages; an album contributes one item per attachment
+type UserMediaItem struct {
+	MessageID    string    `json:"message_id" example:"60d5f8b8e6b5f0b3e8b4b5b4"`
+	ChatroomID   string    `json:"chatroom_id" example:"60d5f8b8e6b5f0b3e8b4b5b3"`
+	ChatroomName string    `json:"chatroom_name" example:"General Chat"`
+	URL          string    `json:"url" example:"https://res.cloudinary.com/your-cloud/image/upload/v123456789/abc123.jpg"`
+	MediaKind    string    `json:"media_kind" example:"image" enums:"image,audio,video"`
+	MessageType  string    `json:"message_type" example:"picture"`
+	SentAt       time.Time `json:"sent_at"`
+}
+
+// UserMediaResponse is one page of the media a user has sent
+type UserMediaResponse struct {
+	Items      []UserMediaItem `json:"items"`                 // Newest message first
+	HasMore    bool            `json:"has_more"`              // Whether older media messages exist
+	NextCursor *string         `json:"next_cursor,omitempty"` // Pass as cursor to load the next page
+}
+
+// messageCursor is a message's position in (sent_at, _id) order, for paging in either direction.
+// Send times aren't guaranteed unique, so the message ID breaks ties.
+type messageCursor struct {
+	SentAt    time.Time
+	MessageID primitive.ObjectID
+}
+
+func (c messageCursor) encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%s", c.SentAt.UnixMilli(), c.MessageID.Hex())))
+}
+
+func decodeMessageCursor(value string) (*messageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	sentAt, messageID, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return nil, errors.New("invalid cursor")
+	}
+	millis, err := strconv.ParseInt(sentAt, 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	id, err := primitive.ObjectIDFromHex(messageID)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	return &messageCursor{SentAt: time.UnixMilli(millis).UTC(), MessageID: id}, nil
+}
+
+// GetUserMedia returns the media from up to limit of the user's own messages across every chatroom they
+// still belong to, newest first. Rooms the user has left are excluded. before, if set, skips media sent at
+// or after it; pageCursor, if set, is the next_cursor of the previous page.
+func (s *MessageService) GetUserMedia(userID uint, limit int, before *time.Time, pageCursor string) (*UserMediaResponse, error) {
+	var after *messageCursor
+	if pageCursor != "" {
+		decoded, err := decodeMessageCursor(pageCursor)
+		if err != nil {
+			return nil, err
+		}
+		after = decoded
+	}
+
+	chatrooms, err := s.ChatSvc.GetUserChatrooms(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &UserMediaResponse{Items: []UserMediaItem{}}
+	if len(chatrooms) == 0 {
+		return response, nil
+	}
+
+	chatroomIDs := make([]primitive.ObjectID, 0, len(chatrooms))
+	chatroomNames := make(map[primitive.ObjectID]string, len(chatrooms))
+	for _, chatroom := range chatrooms {
+		chatroomIDs = append(chatroomIDs, chatroom.ID)
+		chatroomNames[chatroom.ID] = chatroom.Name
+	}
+
+	limit = s.ClampMessageLimit(limit)
+
+	// Served by sender_sent_at_idx (sender_id, sent_at)
+	conditions := bson.A{
+		bson.M{"$or": bson.A{
+			bson.M{"media_url": bson.M{"$exists": true, "$ne": ""}},
+			bson.M{"attachments.0": bson.M{"$exists": true}},
+		}},
+	}
+	if before != nil {
+		conditions = append(conditions, bson.M{"sent_at": bson.M{"$lt": *before}})
+	}
+	if after != nil {
+		conditions = append(conditions, bson.M{"$or": bson.A{
+			bson.M{"sent_at": bson.M{"$lt": after.SentAt}},
+			bson.M{"sent_at": after.SentAt, "_id": bson.M{"$lt": after.MessageID}},
+		}})
+	}
+	filter := bson.M{
+		"sender_id":   userID,
+		"chatroom_id": bson.M{"$in": chatroomIDs},
+		"$and":        conditions,
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "sent_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(int64(limit + 1))
+
+	ctx := context.Background()
+	cursor, err := s.MsgColl.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, errors.New("failed to get media messages")
+	}
+	defer cursor.Close(ctx)
+
+	var messages []models.Message
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, errors.New("failed to decode media messages")
+	}
+
+	if len(messages) > limit {
+		messages = messages[:limit]
+		response.HasMore = true
+		oldest := messages[len(messages)-1]
+		nextCursor := messageCursor{SentAt: oldest.SentAt, MessageID: oldest.ID}.encode()
+		response.NextCursor = &nextCursor
+	}
+
+	for _, message := range messages {
+		item := UserMediaItem{
+			MessageID:    message.ID.Hex(),
+			ChatroomID:   message.ChatroomID.Hex(),
+			ChatroomName: chatroomNames[message.ChatroomID],
+			MessageType:  message.MessageType,
+			SentAt:       message.SentAt,
+		}
+		if message.MediaURL != "" {
+			item.URL = message.MediaURL
+			item.MediaKind = message.MediaKind
+			if item.MediaKind == "" {
+				item.MediaKind = string(utils.GetMediaTypeFromMessageType(message.MessageType))
+			}
+			response.Items = append(response.Items, item)
+		}
+		for _, attachment := range message.Attachments {
+			item.URL = attachment.URL
+			item.MediaKind = attachment.MediaKind
+			response.Items = append(response.Items, item)
+		}
+	}
+
+	return response, nil
+}
+
+// MediaCountsResponse holds the number of media items of each kind in a chatroom; an album counts each attachment
+type MediaCountsResponse struct {
+	Images int64 `json:"images"` // Pictures, with or without text
+	Videos int64 `json:"videos"`
+	Audio  int64 `json:"audio"`
+	Total  int64 `json:"total"` // All media items
+}
+
+// GetMediaCounts tallies a chatroom's media items by kind with a single aggregation
+func (s *MessageService) GetMediaCounts(chatroomID primitive.ObjectID, userID uint) (*MediaCountsResponse, error) {
+	chatroom, err := s.ChatSvc.GetChatroomByID(chatroomID)
+	if err != nil {
+		return nil, err
+	}
+	if !s.ChatSvc.IsMember(chatroom, userID) {
+		return nil, errors.New("user is not a member of this chatroom")
+	}
+
+	// Albums are unwound to one document per attachment, grouped by the attachment's kind.
+	// Messages stored before media_kind existed are grouped by message type and folded in below
+	pipeline := []bson.M{
+		{
+			"$match": bson.M{
+				"chatroom_id": chatroomID,
+				"$or": bson.A{
+					bson.M{"media_url": bson.M{"$exists": true, "$ne": ""}},
+					bson.M{"attachments.0": bson.M{"$exists": true}},
+				},
+			},
+		},
+		{
+			"$unwind": bson.M{"path": "$attachments", "preserveNullAndEmptyArrays": true},
+		},
+		{
+			"$group": bson.M{
+				"_id": bson.M{
+					"media_kind":   bson.M{"$ifNull": []interface{}{"$attachments.media_kind", "$media_kind", ""}},
+					"message_type": "$message_type",
+				},
+				"count": bson.M{"$sum": 1},
+			},
+		},
+	}
+
+	cursor, err := s.MsgColl.Aggregate(context.Background(), pipeline)
+	if err != nil {
+		return nil, errors.New("failed to count media")
+	}
+	defer cursor.Close(context.Background())
+
+	var groups []struct {
+		ID struct {
+			MediaKind   string `bson:"media_kind"`
+			MessageType string `bson:"message_type"`
+		} `bson:"_id"`
+		Count int64 `bson:"count"`
+	}
+	if err := cursor.All(context.Background(), &groups); err != nil {
+		return nil, errors.New("failed to count media")
+	}
+
+	counts := &MediaCountsResponse{}
+	for _, group := range groups {
+		kind := utils.MediaType(group.ID.MediaKind)
+		if kind == "" {
+			kind = utils.GetMediaTypeFromMessageType(group.ID.MessageType)
+		}
+		switch kind {
+		case utils.ImageMedia:
+			counts.Images += group.Count
+		case utils.VideoMedia:
+			counts.Videos += group.Count
+		case utils.AudioMedia:
+			counts.Audio += group.Count
+		default:
+			continue // Not a media message type
+		}
+		counts.Total += group.Count
+	}
+
+	return counts, nil
+}
@@ -0,0 +1,486 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ginchat/config"
+	"github.com/ginchat/models"
+	"github.com/ginchat/utils"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestEditedMessageType(t *testing.T) {
+	const (
+		pictureURL = "https://res.cloudinary.com/demo/image/upload/v1/ginchat/images/a.jpg"
+		videoURL   = "https://res.cloudinary.com/demo/video/upload/v1/ginchat/video/b.mp4"
+	)
+	strPtr := func(s string) *string { return &s }
+
+	tests := []struct {
+		name           string
+		message        models.Message
+		finalText      string
+		finalMediaURL  string
+		mediaChanged   bool
+		newMessageType *string
+		wantType       string
+		wantKind       utils.MediaType
+		wantErr        string
+	}{
+		{
+			name:          "media-only edit keeps the caption and takes the new media's kind",
+			message:       models.Message{MessageType: "text_and_picture", TextContent: "look", MediaURL: pictureURL, MediaKind: string(utils.ImageMedia)},
+			finalText:     "look",
+			finalMediaURL: videoURL,
+			mediaChanged:  true,
+			wantType:      "text_and_video",
+			wantKind:      utils.VideoMedia,
+		},
+		{
+			name:          "media-only edit adding media to a text message",
+			message:       models.Message{MessageType: "text", TextContent: "hello"},
+			finalText:     "hello",
+			finalMediaURL: pictureURL,
+			mediaChanged:  true,
+			wantType:      "text_and_picture",
+			wantKind:      utils.ImageMedia,
+		},
+		{
+			name:          "text-only edit clearing the caption of a media message",
+			message:       models.Message{MessageType: "text_and_video", TextContent: "clip", MediaURL: videoURL, MediaKind: string(utils.VideoMedia)},
+			finalText:     "",
+			finalMediaURL: videoURL,
+			wantType:      "video",
+			wantKind:      utils.VideoMedia,
+		},
+		{
+			name:          "text-only edit of a text message",
+			message:       models.Message{MessageType: "text", TextContent: "helo"},
+			finalText:     "hello",
+			finalMediaURL: "",
+			wantType:      "text",
+			wantKind:      "",
+		},
+		{
+			name:          "combined edit replacing text and removing media",
+			message:       models.Message{MessageType: "text_and_picture", TextContent: "look", MediaURL: pictureURL, MediaKind: string(utils.ImageMedia)},
+			finalText:     "never mind",
+			finalMediaURL: "",
+			mediaChanged:  true,
+			wantType:      "text",
+			wantKind:      "",
+		},
+		{
+			name:           "combined edit with an explicit type that fits the content",
+			message:        models.Message{MessageType: "picture", MediaURL: pictureURL, MediaKind: string(utils.ImageMedia)},
+			finalText:      "caption",
+			finalMediaURL:  videoURL,
+			mediaChanged:   true,
+			newMessageType: strPtr("text_and_video"),
+			wantType:       "text_and_video",
+			wantKind:       utils.VideoMedia,
+		},
+		{
+			name:           "albums keep their type",
+			message:        models.Message{MessageType: models.MessageTypeAlbum, TextContent: "trip", MediaKind: string(utils.ImageMedia)},
+			finalText:      "our trip",
+			newMessageType: nil,
+			wantType:       models.MessageTypeAlbum,
+			wantKind:       utils.ImageMedia,
+		},
+		{
+			name:           "relabelling as a system notice is rejected",
+			message:        models.Message{MessageType: "text", TextContent: "hello"},
+			finalText:      "hello",
+			newMessageType: strPtr(models.MessageTypeSystem),
+			wantErr:        "invalid message type",
+		},
+		{
+			name:           "relabelling as an album is rejected",
+			message:        models.Message{MessageType: "picture", MediaURL: pictureURL, MediaKind: string(utils.ImageMedia)},
+			finalMediaURL:  pictureURL,
+			newMessageType: strPtr(models.MessageTypeAlbum),
+			wantErr:        "invalid message type",
+		},
+		{
+			name:           "unknown type is rejected",
+			message:        models.Message{MessageType: "text", TextContent: "hello"},
+			finalText:      "hello",
+			newMessageType: strPtr("sticker"),
+			wantErr:        "invalid message type",
+		},
+		{
+			name:           "explicit media type without media is rejected",
+			message:        models.Message{MessageType: "text", TextContent: "hello"},
+			finalText:      "hello",
+			newMessageType: strPtr("picture"),
+			wantErr:        "media URL is required for media messages",
+		},
+		{
+			name:           "explicit combined type without text is rejected",
+			message:        models.Message{MessageType: "picture", MediaURL: pictureURL, MediaKind: string(utils.ImageMedia)},
+			finalMediaURL:  pictureURL,
+			newMessageType: strPtr("text_and_picture"),
+			wantErr:        "text content is required for combined messages",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotType, gotKind, err := editedMessageType(&tt.message, tt.finalText, tt.finalMediaURL, tt.mediaChanged, tt.newMessageType)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if gotType != tt.wantType || gotKind != tt.wantKind {
+				t.Errorf("got (%q, %q), want (%q, %q)", gotType, gotKind, tt.wantType, tt.wantKind)
+			}
+		})
+	}
+}
+
+func TestRefreshSenderName(t *testing.T) {
+	env := newTestEnv(t, false)
+	alice, bob := env.createUser(t, "alice"), env.createUser(t, "bob")
+	general := env.createChatroom(t, "General", alice, bob)
+	random := env.createChatroom(t, "Random", bob, alice)
+	env.sendText(t, general, alice, "hi")
+	env.sendText(t, general, bob, "hey")
+	env.sendText(t, random, alice, "over here")
+
+	if err := env.Messages.RefreshSenderName(alice.UserID, "alicia"); err != nil {
+		t.Fatalf("RefreshSenderName: %v", err)
+	}
+
+	for _, room := range []*models.Chatroom{general, random} {
+		messages, err := env.Messages.GetMessages(room.ID, bob.UserID, 50)
+		if err != nil {
+			t.Fatalf("GetMessages: %v", err)
+		}
+		for _, message := range messages {
+			want := "bob"
+			if message.SenderID == alice.UserID {
+				want = "alicia"
+			}
+			if message.SenderName != want {
+				t.Errorf("%s: message %q has sender name %q, want %q", room.Name, message.TextContent, message.SenderName, want)
+			}
+		}
+
+		chatroom, err := env.Chatrooms.GetChatroomByID(room.ID)
+		if err != nil {
+			t.Fatalf("GetChatroomByID: %v", err)
+		}
+		for _, member := range chatroom.Members {
+			want := "bob"
+			if member.UserID == alice.UserID {
+				want = "alicia"
+			}
+			if member.Username != want {
+				t.Errorf("%s: member %d is listed as %q, want %q", room.Name, member.UserID, member.Username, want)
+			}
+		}
+	}
+}
+
+func TestUpdateMessageNeedsPostingRights(t *testing.T) {
+	env := newTestEnv(t, false)
+	alice, bob, carol := env.createUser(t, "alice"), env.createUser(t, "bob"), env.createUser(t, "carol")
+	room := env.createChatroom(t, "General", alice, bob, carol)
+	fromBob := env.sendText(t, room, bob, "original")
+	fromCarol := env.sendText(t, room, carol, "original")
+	edited := "edited"
+
+	if err := env.Chatrooms.SetMemberRole(room.ID, alice.UserID, bob.UserID, models.ChatroomRoleReadonly); err != nil {
+		t.Fatalf("SetMemberRole: %v", err)
+	}
+	if _, err := env.Messages.UpdateMessage(fromBob.ID, bob.UserID, &edited, nil, nil); err == nil || err.Error() != "user is read-only in this chatroom" {
+		t.Errorf("read-only edit: err = %v, want read-only", err)
+	}
+
+	if err := env.Chatrooms.LeaveChatroom(room.ID, carol.UserID); err != nil {
+		t.Fatalf("LeaveChatroom: %v", err)
+	}
+	if _, err := env.Messages.UpdateMessage(fromCarol.ID, carol.UserID, &edited, nil, nil); err == nil || err.Error() != "user is not a member of this chatroom" {
+		t.Errorf("edit after leaving: err = %v, want not a member", err)
+	}
+
+	for _, message := range []*models.Message{fromBob, fromCarol} {
+		var stored models.Message
+		if err := env.Messages.MsgColl.FindOne(context.Background(), bson.M{"_id": message.ID}).Decode(&stored); err != nil {
+			t.Fatalf("load message: %v", err)
+		}
+		if stored.TextContent != "original" || stored.Edited {
+			t.Errorf("blocked edit changed message %s: %q, edited %v", message.ID.Hex(), stored.TextContent, stored.Edited)
+		}
+	}
+
+	// Restoring the role restores editing
+	if err := env.Chatrooms.SetMemberRole(room.ID, alice.UserID, bob.UserID, models.ChatroomRoleMember); err != nil {
+		t.Fatalf("SetMemberRole: %v", err)
+	}
+	if _, err := env.Messages.UpdateMessage(fromBob.ID, bob.UserID, &edited, nil, nil); err != nil {
+		t.Errorf("member edit: %v", err)
+	}
+}
+
+func TestGetMessagesAround(t *testing.T) {
+	env := newTestEnv(t, false)
+	alice, mallory := env.createUser(t, "alice"), env.createUser(t, "mallory")
+	room := env.createChatroom(t, "General", alice)
+	other := env.createChatroom(t, "Other", mallory)
+	var sent []*models.Message
+	for i := 0; i < 10; i++ {
+		sent = append(sent, env.sendText(t, room, alice, fmt.Sprintf("message %d", i)))
+	}
+	elsewhere := env.sendText(t, other, mallory, "elsewhere")
+
+	window := func(anchor *models.Message, radius int) (*MessageContextResponse, []string) {
+		t.Helper()
+		got, err := env.Messages.GetMessagesAround(room.ID, alice.UserID, anchor.ID, radius)
+		if err != nil {
+			t.Fatalf("GetMessagesAround: %v", err)
+		}
+		texts := make([]string, len(got.Messages))
+		for i, message := range got.Messages {
+			texts[i] = message.TextContent
+		}
+		return got, texts
+	}
+
+	t.Run("mid-history anchor", func(t *testing.T) {
+		got, texts := window(sent[5], 2)
+		want := []string{"message 3", "message 4", "message 5", "message 6", "message 7"}
+		if !slices.Equal(texts, want) {
+			t.Errorf("window = %v, want %v", texts, want)
+		}
+		if got.AnchorID != sent[5].ID.Hex() || !got.HasMoreBefore || !got.HasMoreAfter {
+			t.Errorf("anchor %s, more before %v, more after %v", got.AnchorID, got.HasMoreBefore, got.HasMoreAfter)
+		}
+	})
+
+	t.Run("first-message anchor", func(t *testing.T) {
+		got, texts := window(sent[0], 3)
+		want := []string{"message 0", "message 1", "message 2", "message 3"}
+		if !slices.Equal(texts, want) {
+			t.Errorf("window = %v, want %v", texts, want)
+		}
+		if got.HasMoreBefore || !got.HasMoreAfter {
+			t.Errorf("more before %v, more after %v; want false, true", got.HasMoreBefore, got.HasMoreAfter)
+		}
+	})
+
+	t.Run("last-message anchor", func(t *testing.T) {
+		got, texts := window(sent[9], 20)
+		if len(texts) != 10 || texts[9] != "message 9" || got.HasMoreBefore || got.HasMoreAfter {
+			t.Errorf("window = %v, more before %v, more after %v", texts, got.HasMoreBefore, got.HasMoreAfter)
+		}
+	})
+
+	t.Run("anchor from another room", func(t *testing.T) {
+		if _, err := env.Messages.GetMessagesAround(room.ID, alice.UserID, elsewhere.ID, 2); err == nil || err.Error() != "message not found" {
+			t.Errorf("err = %v, want message not found", err)
+		}
+	})
+
+	t.Run("non-member", func(t *testing.T) {
+		if _, err := env.Messages.GetMessagesAround(room.ID, mallory.UserID, sent[5].ID, 2); err == nil {
+			t.Error("a non-member read the room's messages")
+		}
+	})
+}
+
+func TestUnreadMessagesWithCollidingTimestamps(t *testing.T) {
+	env := newTestEnv(t, false)
+	alice, bob := env.createUser(t, "alice"), env.createUser(t, "bob")
+	room := env.createChatroom(t, "Burst", alice, bob)
+
+	// Six messages sent in the same millisecond, stored out of _id order; bob has read every other one
+	sentAt := time.Now().Truncate(time.Millisecond)
+	ids := make([]primitive.ObjectID, 6)
+	for i := range ids {
+		ids[i] = primitive.NewObjectID()
+	}
+	for _, i := range []int{3, 0, 5, 1, 4, 2} {
+		message := models.Message{ID: ids[i], ChatroomID: room.ID, SenderID: alice.UserID, SenderName: alice.Username, MessageType: "text", TextContent: fmt.Sprint(i), SentAt: sentAt}
+		status := models.MessageReadStatus{MessageID: ids[i], ChatroomID: room.ID, SenderID: alice.UserID, RecipientID: bob.UserID, IsRead: i%2 == 0, CreatedAt: sentAt}
+		if _, err := env.Messages.MsgColl.InsertOne(context.Background(), message); err != nil {
+			t.Fatalf("insert message: %v", err)
+		}
+		if _, err := env.ReadStatus.ReadStatusColl.InsertOne(context.Background(), status); err != nil {
+			t.Fatalf("insert read status: %v", err)
+		}
+	}
+	texts := func(messages []models.Message) []string {
+		got := make([]string, len(messages))
+		for i, message := range messages {
+			got[i] = message.TextContent
+		}
+		return got
+	}
+
+	for newestFirst, want := range map[bool][]string{false: {"1", "3", "5"}, true: {"5", "3", "1"}} {
+		unread, err := env.ReadStatus.GetUnreadMessagesInChatroom(room.ID, bob.UserID, newestFirst)
+		if err != nil {
+			t.Fatalf("GetUnreadMessagesInChatroom: %v", err)
+		}
+		if got := texts(unread); !slices.Equal(got, want) {
+			t.Errorf("unread (newest first %v) = %v, want %v", newestFirst, got, want)
+		}
+	}
+
+	// Merged with the read ones, every message appears once, newest _id first
+	messages, _, _, err := env.Messages.getUnreadAndRecentMessages(room.ID, bob.UserID)
+	if err != nil {
+		t.Fatalf("getUnreadAndRecentMessages: %v", err)
+	}
+	if got := texts(messages); !slices.Equal(got, []string{"5", "4", "3", "2", "1", "0"}) {
+		t.Errorf("merged = %v, want 5 to 0", got)
+	}
+}
+
+func TestMessageEditWindow(t *testing.T) {
+	env := newTestEnv(t, false)
+	alice, bob := env.createUser(t, "alice"), env.createUser(t, "bob")
+	room := env.createChatroom(t, "General", alice, bob) // alice created it and is its admin
+
+	// sentAgo stores a message as if sent the given time ago
+	sentAgo := func(user *models.User, ago time.Duration) *models.Message {
+		t.Helper()
+		message := env.sendText(t, room, user, "original")
+		if _, err := env.Messages.MsgColl.UpdateOne(context.Background(), bson.M{"_id": message.ID}, bson.M{"$set": bson.M{"sent_at": time.Now().Add(-ago)}}); err != nil {
+			t.Fatalf("backdate message: %v", err)
+		}
+		return message
+	}
+	edit := func(message *models.Message, user *models.User) error {
+		edited := "edited"
+		_, err := env.Messages.UpdateMessage(message.ID, user.UserID, &edited, nil, nil)
+		return err
+	}
+	const expired = "message edit window has expired"
+	setWindow := func(minutes int) {
+		t.Helper()
+		if _, _, err := env.Chatrooms.UpdateChatroomDetails(room.ID, alice.UserID, nil, nil, nil, nil, nil, nil, &minutes); err != nil {
+			t.Fatalf("set edit window to %d: %v", minutes, err)
+		}
+	}
+
+	t.Run("server default", func(t *testing.T) {
+		if err := edit(sentAgo(bob, config.DefaultMessageEditWindow-time.Minute), bob); err != nil {
+			t.Errorf("edit inside the window: %v", err)
+		}
+		old := sentAgo(bob, config.DefaultMessageEditWindow+time.Minute)
+		if err := edit(old, bob); err == nil || err.Error() != expired {
+			t.Errorf("edit outside the window: err = %v, want %q", err, expired)
+		}
+		var stored models.Message
+		if err := env.Messages.MsgColl.FindOne(context.Background(), bson.M{"_id": old.ID}).Decode(&stored); err != nil || stored.TextContent != "original" || stored.Edited {
+			t.Errorf("rejected edit changed the message: %+v (%v)", stored, err)
+		}
+		// Admins may still edit their own messages
+		if err := edit(sentAgo(alice, time.Hour), alice); err != nil {
+			t.Errorf("admin edit outside the window: %v", err)
+		}
+	})
+
+	t.Run("room override", func(t *testing.T) {
+		setWindow(60)
+		if err := edit(sentAgo(bob, 30*time.Minute), bob); err != nil {
+			t.Errorf("edit inside a longer room window: %v", err)
+		}
+		setWindow(5)
+		if err := edit(sentAgo(bob, 10*time.Minute), bob); err == nil || err.Error() != expired {
+			t.Errorf("edit outside a shorter room window: err = %v, want %q", err, expired)
+		}
+		setWindow(0) // No limit
+		if err := edit(sentAgo(bob, 30*24*time.Hour), bob); err != nil {
+			t.Errorf("edit in a room without a limit: %v", err)
+		}
+	})
+
+	t.Run("only the creator sets valid windows", func(t *testing.T) {
+		// Not even another admin
+		if _, err := env.Chatrooms.ChatColl.UpdateOne(context.Background(),
+			bson.M{"_id": room.ID, "members.user_id": bob.UserID},
+			bson.M{"$set": bson.M{"members.$.role": models.ChatroomRoleAdmin}}); err != nil {
+			t.Fatalf("promote bob: %v", err)
+		}
+		minutes := 30
+		if _, _, err := env.Chatrooms.UpdateChatroomDetails(room.ID, bob.UserID, nil, nil, nil, nil, nil, nil, &minutes); err == nil || err.Error() != "only the creator can change the edit window" {
+			t.Errorf("admin setting the window: err = %v", err)
+		}
+		for _, minutes := range []int{-1, models.MaxMessageEditWindowMinutes + 1} {
+			if _, _, err := env.Chatrooms.UpdateChatroomDetails(room.ID, alice.UserID, nil, nil, nil, nil, nil, nil, &minutes); err == nil || err.Error() != "invalid edit window" {
+				t.Errorf("window of %d minutes: err = %v, want invalid edit window", minutes, err)
+			}
+		}
+	})
+}
+
+func TestSentAtIncreasesWhenClockStepsBack(t *testing.T) {
+	env := newTestEnv(t, false)
+	alice := env.createUser(t, "alice")
+	room := env.createChatroom(t, "General", alice)
+
+	// A message stamped an hour ahead is what the room looks like after the server clock steps back an hour
+	ahead := models.Message{
+		ID:          primitive.NewObjectID(),
+		ChatroomID:  room.ID,
+		SenderID:    alice.UserID,
+		SenderName:  alice.Username,
+		MessageType: "text",
+		TextContent: "before the clock stepped back",
+		SentAt:      time.Now().Add(time.Hour).UTC().Truncate(time.Millisecond),
+	}
+	if _, err := env.Messages.MsgColl.InsertOne(context.Background(), ahead); err != nil {
+		t.Fatalf("insert message: %v", err)
+	}
+	adjustments := testutil.ToFloat64(utils.MessageClockAdjustmentsTotal)
+
+	sent := []*models.Message{{ID: ahead.ID, SentAt: ahead.SentAt}}
+	for i := range 4 {
+		sent = append(sent, env.sendText(t, room, alice, fmt.Sprintf("after %d", i)))
+	}
+	notice, err := env.Messages.CreateSystemMessage(room.ID, models.SystemEvent{Type: models.SystemEventMemberJoined, UserID: alice.UserID, Username: alice.Username})
+	if err != nil {
+		t.Fatalf("CreateSystemMessage: %v", err)
+	}
+	sent = append(sent, notice)
+
+	for i := 1; i < len(sent); i++ {
+		if !sent[i].SentAt.After(sent[i-1].SentAt) {
+			t.Errorf("message %d sent_at %v is not after message %d's %v", i, sent[i].SentAt, i-1, sent[i-1].SentAt)
+		}
+	}
+	if got := testutil.ToFloat64(utils.MessageClockAdjustmentsTotal) - adjustments; got != 5 {
+		t.Errorf("clock adjustments = %v, want 5", got)
+	}
+
+	// History reads back newest first in the order the messages were sent
+	messages, err := env.Messages.GetMessages(room.ID, alice.UserID, 10)
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	var got, want []primitive.ObjectID
+	for _, message := range messages {
+		got = append(got, message.ID)
+	}
+	for _, message := range slices.Backward(sent) {
+		want = append(want, message.ID)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("history = %v, want %v", got, want)
+	}
+}
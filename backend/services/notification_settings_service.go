@@ -0,0 +1,174 @@
+package services
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ginchat/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gorm.io/gorm"
+)
+
+// MaxNotificationSettingsChatrooms caps how many chatrooms one bulk update may change
+const MaxNotificationSettingsChatrooms = 500
+
+// ChatroomNotificationSetting is a user's notification setting for one chatroom
+type ChatroomNotificationSetting struct {
+	ChatroomID   string `json:"chatroom_id" example:"60d5f8b8e6b5f0b3e8b4b5b3"`
+	ChatroomName string `json:"chatroom_name,omitempty" example:"General Chat"`
+	Muted        bool   `json:"muted" example:"false"` // No push notifications from this chatroom (except @everyone)
+}
+
+// NotificationSettings is everything that decides which push notifications a user gets and what they show
+type NotificationSettings struct {
+	NotificationPreview string                        `json:"notification_preview" example:"full" enums:"full,sender_only,hidden"`
+	QuietHours          *models.QuietHours            `json:"quiet_hours"` // Null when off
+	Chatrooms           []ChatroomNotificationSetting `json:"chatrooms"`   // Every chatroom the user belongs to, by name
+}
+
+// NotificationSettingsUpdate changes several notification settings at once; nil or empty fields are left as they are
+type NotificationSettingsUpdate struct {
+	NotificationPreview *string
+	QuietHours          *models.QuietHours
+	ClearQuietHours     bool
+	Chatrooms           map[primitive.ObjectID]bool // Chatroom ID to muted
+}
+
+// NotificationSettingsService reads and updates a user's notification settings in one place,
+// so clients don't need a request per chatroom on start-up
+type NotificationSettingsService struct {
+	DB       *gorm.DB
+	ChatColl *mongo.Collection
+	Users    *UserService
+}
+
+// NewNotificationSettingsService creates a new NotificationSettingsService
+func NewNotificationSettingsService(db *gorm.DB, mongodb *mongo.Database, userService *UserService) *NotificationSettingsService {
+	return &NotificationSettingsService{
+		DB:       db,
+		ChatColl: mongodb.Collection("chatrooms"),
+		Users:    userService,
+	}
+}
+
+// GetSettings returns the user's preview mode, quiet hours and the mute state of every chatroom they belong to
+func (s *NotificationSettingsService) GetSettings(userID uint) (*NotificationSettings, error) {
+	user, err := s.Users.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	preview := user.NotificationPreview
+	if preview == "" {
+		preview = models.NotificationPreviewFull
+	}
+
+	// Only the user's own member entry is needed from each chatroom
+	opts := options.Find().
+		SetProjection(bson.M{"name": 1, "members": bson.M{"$elemMatch": bson.M{"user_id": userID}}}).
+		SetSort(bson.D{{Key: "name", Value: 1}})
+	cursor, err := s.ChatColl.Find(context.Background(), bson.M{"members.user_id": userID}, opts)
+	if err != nil {
+		return nil, errors.New("failed to get notification settings")
+	}
+	defer cursor.Close(context.Background())
+
+	var chatrooms []models.Chatroom
+	if err := cursor.All(context.Background(), &chatrooms); err != nil {
+		return nil, errors.New("failed to get notification settings")
+	}
+
+	settings := &NotificationSettings{
+		NotificationPreview: preview,
+		QuietHours:          user.GetQuietHours(),
+		Chatrooms:           make([]ChatroomNotificationSetting, 0, len(chatrooms)),
+	}
+	for _, chatroom := range chatrooms {
+		setting := ChatroomNotificationSetting{ChatroomID: chatroom.ID.Hex(), ChatroomName: chatroom.Name}
+		if len(chatroom.Members) > 0 {
+			setting.Muted = chatroom.Members[0].Muted
+		}
+		settings.Chatrooms = append(settings.Chatrooms, setting)
+	}
+
+	return settings, nil
+}
+
+// UpdateSettings applies a bulk update and returns the resulting settings.
+// Everything is validated before anything is written, so a bad entry leaves all settings unchanged.
+func (s *NotificationSettingsService) UpdateSettings(userID uint, update NotificationSettingsUpdate) (*NotificationSettings, error) {
+	if update.NotificationPreview == nil && update.QuietHours == nil && !update.ClearQuietHours && len(update.Chatrooms) == 0 {
+		return nil, errors.New("no changes provided")
+	}
+	if update.NotificationPreview != nil && !models.IsValidNotificationPreview(*update.NotificationPreview) {
+		return nil, errors.New("invalid notification preview mode")
+	}
+	if update.QuietHours != nil && update.ClearQuietHours {
+		return nil, errors.New("quiet hours cannot be set and cleared together")
+	}
+	if update.QuietHours != nil && update.QuietHours.Validate() != nil {
+		return nil, errors.New("invalid quiet hours")
+	}
+	if len(update.Chatrooms) > MaxNotificationSettingsChatrooms {
+		return nil, errors.New("too many chatrooms in one update")
+	}
+
+	if _, err := s.Users.GetUserByID(userID); err != nil {
+		return nil, err
+	}
+
+	// Every chatroom must be one the user belongs to
+	if len(update.Chatrooms) > 0 {
+		chatroomIDs := make([]primitive.ObjectID, 0, len(update.Chatrooms))
+		for chatroomID := range update.Chatrooms {
+			chatroomIDs = append(chatroomIDs, chatroomID)
+		}
+		count, err := s.ChatColl.CountDocuments(context.Background(), bson.M{
+			"_id":             bson.M{"$in": chatroomIDs},
+			"members.user_id": userID,
+		})
+		if err != nil {
+			return nil, errors.New("failed to update notification settings")
+		}
+		if count != int64(len(chatroomIDs)) {
+			return nil, errors.New("user is not a member of this chatroom")
+		}
+	}
+
+	columns := map[string]interface{}{}
+	if update.NotificationPreview != nil {
+		columns["notification_preview"] = *update.NotificationPreview
+	}
+	if update.QuietHours != nil {
+		columns["quiet_hours_start"] = update.QuietHours.Start
+		columns["quiet_hours_end"] = update.QuietHours.End
+		columns["quiet_hours_timezone"] = update.QuietHours.Timezone
+	}
+	if update.ClearQuietHours {
+		columns["quiet_hours_start"] = ""
+		columns["quiet_hours_end"] = ""
+		columns["quiet_hours_timezone"] = ""
+	}
+	if len(columns) > 0 {
+		if err := s.DB.Model(&models.User{}).Where("user_id = ?", userID).Updates(columns).Error; err != nil {
+			return nil, errors.New("failed to update notification settings")
+		}
+	}
+
+	if len(update.Chatrooms) > 0 {
+		writes := make([]mongo.WriteModel, 0, len(update.Chatrooms))
+		for chatroomID, muted := range update.Chatrooms {
+			writes = append(writes, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"_id": chatroomID, "members.user_id": userID}).
+				SetUpdate(bson.M{"$set": bson.M{"members.$.muted": muted}}))
+		}
+		if _, err := s.ChatColl.BulkWrite(context.Background(), writes, options.BulkWrite().SetOrdered(false)); err != nil {
+			return nil, errors.New("failed to update notification settings")
+		}
+	}
+
+	return s.GetSettings(userID)
+}
@@ -1,13 +1,12 @@
 package services
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
-	"net/http"
+	"time"
 
+	"github.com/ginchat/config"
 	"github.com/ginchat/models"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -20,6 +19,11 @@ import (
 type PushNotificationService struct {
 	db      *gorm.DB
 	mongodb *mongo.Database
+	expo    NotificationSender
+	fcm     NotificationSender          // Nil unless FCM_CREDENTIALS_FILE is set
+	styles  map[string]config.PushStyle // PUSH_NOTIFICATION_STYLES, keyed by message type
+
+	tokenInvalidated func(userID uint, token string) // Called for each token deactivated after a send; may be nil
 }
 
 // ExpoMessage represents the structure for Expo push notifications
@@ -46,130 +50,238 @@ type ExpoResponse struct {
 }
 
 // NewPushNotificationService creates a new PushNotificationService
-func NewPushNotificationService(db *gorm.DB, mongodb *mongo.Database) *PushNotificationService {
-	return &PushNotificationService{
+func NewPushNotificationService(db *gorm.DB, mongodb *mongo.Database, fcm *FCMSender, styles map[string]config.PushStyle) *PushNotificationService {
+	service := &PushNotificationService{
 		db:      db,
 		mongodb: mongodb,
+		expo:    ExpoSender{},
+		styles:  styles,
+	}
+	if fcm != nil {
+		service.fcm = fcm // Only set when configured, so a nil *FCMSender doesn't make a non-nil sender
 	}
+	return service
+}
+
+// OnTokenInvalidated registers a function called for each push token deactivated because its
+// provider reported the device as no longer registered, so the owner can be told to re-register
+func (s *PushNotificationService) OnTokenInvalidated(handler func(userID uint, token string)) {
+	s.tokenInvalidated = handler
 }
 
-// SendMessageNotification sends a push notification for a new message
+// Notification display hints for the mobile app (sent as data.notificationDisplay)
+const (
+	NotificationDisplayInApp  = "in_app" // Recipient is using the app: show an in-app banner
+	NotificationDisplaySystem = "system" // Recipient is away: show a system notification
+)
+
+// SendMessageNotification sends a push notification for a new message.
+// activeUsers holds recipients currently active in the app; they get an in_app display hint instead of system.
+// mentionTargets (nil if the message has no @everyone/@here) flags the mentioned recipients; @everyone also
+// reaches members in their quiet hours, since only admins may send it.
+// Returns the number of push tokens the notification was sent to.
 func (s *PushNotificationService) SendMessageNotification(
 	chatroomID string,
 	senderID uint,
 	senderName string,
 	messageContent string,
+	messageType string,
 	chatroomName string,
-) error {
+	activeUsers map[uint]bool,
+	mentionTargets *MentionTargets,
+) (int, error) {
 	// Convert chatroomID string to ObjectID
 	objID, err := primitive.ObjectIDFromHex(chatroomID)
 	if err != nil {
-		return fmt.Errorf("invalid chatroom ID: %w", err)
+		return 0, fmt.Errorf("invalid chatroom ID: %w", err)
 	}
 
 	// Get chatroom from MongoDB to get members
 	var chatroom models.Chatroom
 	err = s.mongodb.Collection("chatrooms").FindOne(context.Background(), bson.M{"_id": objID}).Decode(&chatroom)
 	if err != nil {
-		return fmt.Errorf("failed to get chatroom: %w", err)
+		return 0, fmt.Errorf("failed to get chatroom: %w", err)
 	}
 
-	// Get all members except the sender
+	// Get all members except the sender. Members who muted the chatroom are skipped unless @everyone
+	// names them, the same exception quiet hours make.
 	var userIDs []uint
 	for _, member := range chatroom.Members {
-		if member.UserID != senderID {
-			userIDs = append(userIDs, member.UserID)
+		if member.UserID == senderID {
+			continue
 		}
+		if member.Muted && !(mentionTargets != nil && mentionTargets.Kind == MentionEveryone && mentionTargets.Users[member.UserID]) {
+			continue
+		}
+		userIDs = append(userIDs, member.UserID)
 	}
 
 	if len(userIDs) == 0 {
-		return nil // No members to notify
+		return 0, nil // No members to notify
 	}
 
 	// Get active push tokens for these users
 	var pushTokens []models.PushToken
 	if err := s.db.Where("user_id IN ? AND is_active = ?", userIDs, true).Find(&pushTokens).Error; err != nil {
-		return fmt.Errorf("failed to get push tokens: %w", err)
+		return 0, fmt.Errorf("failed to get push tokens: %w", err)
 	}
 
 	if len(pushTokens) == 0 {
 		log.Printf("No active push tokens found for chatroom %s", chatroomID)
-		return nil // No active push tokens
+		return 0, nil // No active push tokens
+	}
+
+	// Load notification preview and quiet hours preferences for the recipients
+	var users []models.User
+	if err := s.db.Select("user_id", "notification_preview", "quiet_hours_start", "quiet_hours_end", "quiet_hours_timezone").
+		Where("user_id IN ?", userIDs).Find(&users).Error; err != nil {
+		return 0, fmt.Errorf("failed to get notification preferences: %w", err)
+	}
+	now := time.Now()
+	previewByUser := make(map[uint]string)
+	quietUsers := make(map[uint]bool)
+	for _, user := range users {
+		previewByUser[user.UserID] = user.NotificationPreview
+		if quietHours := user.GetQuietHours(); quietHours != nil && quietHours.Contains(now) {
+			quietUsers[user.UserID] = true
+		}
 	}
 
-	// Prepare tokens
-	var tokens []string
+	// Group tokens by preview mode, display hint and push provider so each combination is sent in a single batch
+	type notificationGroup struct {
+		preview  string
+		display  string
+		mention  string
+		provider string
+	}
+	tokensByGroup := make(map[notificationGroup][]string)
 	for _, token := range pushTokens {
-		tokens = append(tokens, token.Token)
+		mention := ""
+		if mentionTargets != nil && mentionTargets.Users[token.UserID] {
+			mention = mentionTargets.Kind
+		}
+
+		// Quiet hours only silence system notifications; users active in the app still get the in-app hint
+		if quietUsers[token.UserID] && !activeUsers[token.UserID] && mention != MentionEveryone {
+			continue
+		}
+
+		mode := previewByUser[token.UserID]
+		if !models.IsValidNotificationPreview(mode) {
+			mode = models.NotificationPreviewFull
+		}
+		display := NotificationDisplaySystem
+		if activeUsers[token.UserID] {
+			display = NotificationDisplayInApp
+		}
+		group := notificationGroup{preview: mode, display: display, mention: mention, provider: token.Provider()}
+		tokensByGroup[group] = append(tokensByGroup[group], token.Token)
 	}
 
-	// Prepare notification content
-	title := fmt.Sprintf("New message in %s", chatroomName)
-	body := fmt.Sprintf("%s: %s", senderName, messageContent)
+	// Send one notification batch per preview mode and display hint
+	var sendErr error
+	var unregistered []string
+	targeted := 0
+	for group, tokens := range tokensByGroup {
+		sender := s.senderFor(group.provider)
+		if sender == nil {
+			log.Printf("Skipping %d %s push tokens for chatroom %s: provider not configured", len(tokens), group.provider, chatroomID)
+			continue
+		}
 
-	// Truncate body if too long
-	if len(body) > 100 {
-		body = body[:97] + "..."
+		data := map[string]interface{}{
+			"chatroomId":          chatroomID,
+			"senderId":            senderID,
+			"type":                "new_message",
+			"notificationDisplay": group.display,
+		}
+		if group.mention != "" {
+			data["mention"] = group.mention
+		}
+		title, body := BuildNotificationContent(group.preview, chatroomName, senderName, messageContent)
+		style := s.PushStyleFor(messageType, group.mention != "")
+		targeted += len(tokens)
+		log.Printf("Sending %s push notification (%s preview, %s display) to %d tokens for chatroom %s", group.provider, group.preview, group.display, len(tokens), chatroomID)
+		dropped, err := sender.Send(tokens, title, body, data, style)
+		if err != nil {
+			sendErr = err
+		}
+		unregistered = append(unregistered, dropped...)
 	}
 
-	// Send notification
-	log.Printf("Sending push notification to %d tokens for chatroom %s", len(tokens), chatroomID)
-	return s.sendExpoNotification(tokens, title, body, map[string]interface{}{
-		"chatroomId": chatroomID,
-		"senderId":   senderID,
-		"type":       "new_message",
-	})
+	if len(unregistered) > 0 {
+		s.deactivateUnregisteredTokens(unregistered)
+	}
+
+	return targeted, sendErr
 }
 
-// sendExpoNotification sends notification via Expo Push API
-func (s *PushNotificationService) sendExpoNotification(
-	tokens []string,
-	title string,
-	body string,
-	data map[string]interface{},
-) error {
-	message := ExpoMessage{
-		To:       tokens,
-		Title:    title,
-		Body:     body,
-		Data:     data,
-		Sound:    "default",
-		Priority: "high",
-	}
-
-	jsonData, err := json.Marshal(message)
-	if err != nil {
-		return fmt.Errorf("failed to marshal notification: %w", err)
+// deactivateUnregisteredTokens deactivates tokens a push provider rejected as unregistered, so no more
+// notifications are sent to them, and reports each one to the tokenInvalidated handler
+func (s *PushNotificationService) deactivateUnregisteredTokens(tokens []string) {
+	var pushTokens []models.PushToken
+	if err := s.db.Where("token IN ? AND is_active = ?", tokens, true).Find(&pushTokens).Error; err != nil {
+		log.Printf("Warning: Failed to look up unregistered push tokens: %v", err)
+		return
 	}
 
-	resp, err := http.Post(
-		"https://exp.host/--/api/v2/push/send",
-		"application/json",
-		bytes.NewBuffer(jsonData),
-	)
-	if err != nil {
-		return fmt.Errorf("failed to send notification: %w", err)
+	for _, pushToken := range pushTokens {
+		// Matching on is_active means a token deactivated concurrently is only reported once
+		result := s.db.Model(&models.PushToken{}).
+			Where("id = ? AND is_active = ?", pushToken.ID, true).
+			Update("is_active", false)
+		if result.Error != nil {
+			log.Printf("Warning: Failed to deactivate push token for user %d: %v", pushToken.UserID, result.Error)
+			continue
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+
+		log.Printf("Deactivated unregistered push token %s... for user %d", pushToken.Token[:min(len(pushToken.Token), 10)], pushToken.UserID)
+		if s.tokenInvalidated != nil {
+			s.tokenInvalidated(pushToken.UserID, pushToken.Token)
+		}
 	}
-	defer resp.Body.Close()
+}
+
+// BuildNotificationContent builds the notification title and body for a preview mode
+func BuildNotificationContent(mode, chatroomName, senderName, messageContent string) (string, string) {
+	var title, body string
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("expo push API returned status %d", resp.StatusCode)
+	switch mode {
+	case models.NotificationPreviewHidden:
+		title = "GinChat"
+		body = "New message"
+	case models.NotificationPreviewSenderOnly:
+		title = fmt.Sprintf("New message in %s", chatroomName)
+		body = fmt.Sprintf("New message from %s", senderName)
+	default:
+		title = fmt.Sprintf("New message in %s", chatroomName)
+		body = fmt.Sprintf("%s: %s", senderName, messageContent)
 	}
 
-	// Parse response to check for errors
-	var expoResp ExpoResponse
-	if err := json.NewDecoder(resp.Body).Decode(&expoResp); err != nil {
-		log.Printf("Warning: Failed to parse Expo response: %v", err)
-		return nil // Don't fail if we can't parse response
+	// Truncate body if too long
+	if len(body) > 100 {
+		body = body[:97] + "..."
 	}
 
-	// Log any errors from Expo
-	for _, result := range expoResp.Data {
-		if result.Status == "error" {
-			log.Printf("Expo push error: %s - %s", result.Message, result.Details.Error)
+	return title, body
+}
+
+// PushStyleFor picks the sound and priority for a notification: the mention style for mentioned
+// recipients, then the message type's style, then the configured default, then config.DefaultPushStyle.
+func (s *PushNotificationService) PushStyleFor(messageType string, mentioned bool) config.PushStyle {
+	if mentioned {
+		if style, ok := s.styles[config.PushStyleMentionKey]; ok {
+			return style
 		}
 	}
-
-	log.Printf("Successfully sent push notification to %d tokens", len(tokens))
-	return nil
+	if style, ok := s.styles[messageType]; ok {
+		return style
+	}
+	if style, ok := s.styles[config.PushStyleDefaultKey]; ok {
+		return style
+	}
+	return config.DefaultPushStyle
 }
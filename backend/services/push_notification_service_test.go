@@ -0,0 +1,328 @@
+package services
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ginchat/config"
+	"github.com/ginchat/models"
+)
+
+func TestBuildNotificationContent(t *testing.T) {
+	long := strings.Repeat("x", 200)
+
+	tests := []struct {
+		name      string
+		mode      string
+		content   string
+		wantTitle string
+		wantBody  string
+	}{
+		{
+			name:      "full preview shows sender and text",
+			mode:      models.NotificationPreviewFull,
+			content:   "see you at 5",
+			wantTitle: "New message in General",
+			wantBody:  "alice: see you at 5",
+		},
+		{
+			name:      "sender only hides the text",
+			mode:      models.NotificationPreviewSenderOnly,
+			content:   "see you at 5",
+			wantTitle: "New message in General",
+			wantBody:  "New message from alice",
+		},
+		{
+			name:      "hidden hides the room, sender and text",
+			mode:      models.NotificationPreviewHidden,
+			content:   "see you at 5",
+			wantTitle: "GinChat",
+			wantBody:  "New message",
+		},
+		{
+			name:      "unknown mode falls back to full",
+			mode:      "bogus",
+			content:   "see you at 5",
+			wantTitle: "New message in General",
+			wantBody:  "alice: see you at 5",
+		},
+		{
+			name:      "long full body is truncated",
+			mode:      models.NotificationPreviewFull,
+			content:   long,
+			wantTitle: "New message in General",
+			wantBody:  ("alice: " + long)[:97] + "...",
+		},
+		{
+			name:      "long text never leaks through sender only",
+			mode:      models.NotificationPreviewSenderOnly,
+			content:   long,
+			wantTitle: "New message in General",
+			wantBody:  "New message from alice",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			title, body := BuildNotificationContent(tt.mode, "General", "alice", tt.content)
+			if title != tt.wantTitle {
+				t.Errorf("title = %q, want %q", title, tt.wantTitle)
+			}
+			if body != tt.wantBody {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+			if len(body) > 100 {
+				t.Errorf("body is %d bytes, want at most 100", len(body))
+			}
+		})
+	}
+}
+
+// sentPush is one notification batch handed to a sender
+type sentPush struct {
+	Tokens []string
+	Title  string
+	Body   string
+	Data   map[string]interface{}
+	Style  config.PushStyle
+}
+
+// pushRecorder is a NotificationSender that records what it was asked to send
+type pushRecorder struct {
+	sent []sentPush
+}
+
+func (r *pushRecorder) Send(tokens []string, title, body string, data map[string]interface{}, style config.PushStyle) ([]string, error) {
+	r.sent = append(r.sent, sentPush{Tokens: tokens, Title: title, Body: body, Data: data, Style: style})
+	return nil, nil
+}
+
+// byToken returns the batch each token was sent in
+func (r *pushRecorder) byToken() map[string]sentPush {
+	batches := map[string]sentPush{}
+	for _, push := range r.sent {
+		for _, token := range push.Tokens {
+			batches[token] = push
+		}
+	}
+	return batches
+}
+
+// newPushTestService returns a push service over env whose Expo sends go to the returned recorder
+func newPushTestService(env *testEnv) (*PushNotificationService, *pushRecorder) {
+	recorder := &pushRecorder{}
+	service := NewPushNotificationService(env.Users.DB, env.Mongo, nil, nil)
+	service.expo = recorder
+	return service, recorder
+}
+
+// addPushToken registers an active Expo token for user and returns it
+func (env *testEnv) addPushToken(t *testing.T, user *models.User) string {
+	t.Helper()
+	token := "ExponentPushToken[" + user.Username + "]"
+	if err := env.Users.DB.Create(&models.PushToken{UserID: user.UserID, Token: token, Platform: "ios", IsActive: true}).Error; err != nil {
+		t.Fatalf("create push token: %v", err)
+	}
+	return token
+}
+
+func TestNotificationDisplayHint(t *testing.T) {
+	env := newTestEnv(t, false)
+	alice, bob, carol := env.createUser(t, "alice"), env.createUser(t, "bob"), env.createUser(t, "carol")
+	room := env.createChatroom(t, "General", alice, bob, carol)
+	bobToken, carolToken := env.addPushToken(t, bob), env.addPushToken(t, carol)
+	service, recorder := newPushTestService(env)
+
+	activeUsers := map[uint]bool{bob.UserID: true}
+	sent, err := service.SendMessageNotification(room.ID.Hex(), alice.UserID, alice.Username, "hi", "text", room.Name, activeUsers, nil)
+	if err != nil || sent != 2 {
+		t.Fatalf("SendMessageNotification = %d, %v; want 2 tokens", sent, err)
+	}
+
+	batches := recorder.byToken()
+	if got := batches[bobToken].Data["notificationDisplay"]; got != NotificationDisplayInApp {
+		t.Errorf("active recipient got display %v, want %q", got, NotificationDisplayInApp)
+	}
+	if got := batches[carolToken].Data["notificationDisplay"]; got != NotificationDisplaySystem {
+		t.Errorf("inactive recipient got display %v, want %q", got, NotificationDisplaySystem)
+	}
+	if len(recorder.sent) != 2 {
+		t.Errorf("sent %d batches, want one per display hint", len(recorder.sent))
+	}
+}
+
+func TestQuietHoursSuppressPush(t *testing.T) {
+	env := newTestEnv(t, false)
+	alice, bob, carol, dave := env.createUser(t, "alice"), env.createUser(t, "bob"), env.createUser(t, "carol"), env.createUser(t, "dave")
+	room := env.createChatroom(t, "General", alice, bob, carol, dave)
+	bobToken, carolToken, daveToken := env.addPushToken(t, bob), env.addPushToken(t, carol), env.addPushToken(t, dave)
+
+	// Bob and Dave are in quiet hours now; Carol's window is later today
+	now := time.Now().UTC()
+	setQuietHours := func(user *models.User, start, end time.Time) {
+		err := env.Users.DB.Model(user).Updates(map[string]any{
+			"quiet_hours_start":    start.Format("15:04"),
+			"quiet_hours_end":      end.Format("15:04"),
+			"quiet_hours_timezone": "UTC",
+		}).Error
+		if err != nil {
+			t.Fatalf("set quiet hours: %v", err)
+		}
+	}
+	setQuietHours(bob, now.Add(-time.Hour), now.Add(time.Hour))
+	setQuietHours(carol, now.Add(2*time.Hour), now.Add(3*time.Hour))
+	setQuietHours(dave, now.Add(-time.Hour), now.Add(time.Hour))
+
+	t.Run("quiet users get no push", func(t *testing.T) {
+		service, recorder := newPushTestService(env)
+		sent, err := service.SendMessageNotification(room.ID.Hex(), alice.UserID, alice.Username, "hi", "text", room.Name, nil, nil)
+		if err != nil || sent != 1 {
+			t.Fatalf("SendMessageNotification = %d, %v; want 1 token", sent, err)
+		}
+		batches := recorder.byToken()
+		if _, ok := batches[carolToken]; !ok {
+			t.Error("user outside their quiet hours got no push")
+		}
+		for _, token := range []string{bobToken, daveToken} {
+			if _, ok := batches[token]; ok {
+				t.Errorf("%s got a push during quiet hours", token)
+			}
+		}
+	})
+
+	t.Run("quiet users active in the app still get the in-app hint", func(t *testing.T) {
+		service, recorder := newPushTestService(env)
+		if _, err := service.SendMessageNotification(room.ID.Hex(), alice.UserID, alice.Username, "hi", "text", room.Name, map[uint]bool{bob.UserID: true}, nil); err != nil {
+			t.Fatalf("SendMessageNotification: %v", err)
+		}
+		batches := recorder.byToken()
+		if got := batches[bobToken].Data["notificationDisplay"]; got != NotificationDisplayInApp {
+			t.Errorf("active quiet user got display %v, want %q", got, NotificationDisplayInApp)
+		}
+		if _, ok := batches[daveToken]; ok {
+			t.Error("inactive quiet user got a push")
+		}
+	})
+
+	t.Run("@everyone reaches quiet users", func(t *testing.T) {
+		service, recorder := newPushTestService(env)
+		targets := &MentionTargets{Kind: MentionEveryone, Users: map[uint]bool{bob.UserID: true, carol.UserID: true, dave.UserID: true}}
+		if sent, err := service.SendMessageNotification(room.ID.Hex(), alice.UserID, alice.Username, "@everyone hi", "text", room.Name, nil, targets); err != nil || sent != 3 {
+			t.Errorf("SendMessageNotification = %d, %v; want all 3 tokens", sent, err)
+		}
+		if len(recorder.byToken()) != 3 {
+			t.Errorf("pushed to %v, want every member", recorder.byToken())
+		}
+	})
+}
+
+func TestPushStylePerMessageType(t *testing.T) {
+	env := newTestEnv(t, false)
+	alice, bob, carol := env.createUser(t, "alice"), env.createUser(t, "bob"), env.createUser(t, "carol")
+	room := env.createChatroom(t, "General", alice, bob, carol)
+	bobToken, carolToken := env.addPushToken(t, bob), env.addPushToken(t, carol)
+
+	mentionStyle := config.PushStyle{Sound: "ping", Priority: config.PushPriorityHigh}
+	defaultStyle := config.PushStyle{Sound: "soft", Priority: config.PushPriorityNormal}
+	pictureStyle := config.PushStyle{Sound: "", Priority: config.PushPriorityDefault}
+	service, recorder := newPushTestService(env)
+	service.styles = map[string]config.PushStyle{
+		config.PushStyleMentionKey: mentionStyle,
+		config.PushStyleDefaultKey: defaultStyle,
+		"picture":                  pictureStyle,
+	}
+
+	t.Run("a mention uses the mention style and a normal message the default", func(t *testing.T) {
+		recorder.sent = nil
+		targets := &MentionTargets{Kind: MentionHere, Users: map[uint]bool{bob.UserID: true}}
+		if _, err := service.SendMessageNotification(room.ID.Hex(), alice.UserID, alice.Username, "@here hi", "text", room.Name, nil, targets); err != nil {
+			t.Fatalf("SendMessageNotification: %v", err)
+		}
+		batches := recorder.byToken()
+		if got := batches[bobToken].Style; got != mentionStyle {
+			t.Errorf("mentioned recipient got %+v, want %+v", got, mentionStyle)
+		}
+		if got := batches[carolToken].Style; got != defaultStyle {
+			t.Errorf("other recipient got %+v, want %+v", got, defaultStyle)
+		}
+	})
+
+	t.Run("a message type with its own style uses it", func(t *testing.T) {
+		recorder.sent = nil
+		if _, err := service.SendMessageNotification(room.ID.Hex(), alice.UserID, alice.Username, "📷 Photo", "picture", room.Name, nil, nil); err != nil {
+			t.Fatalf("SendMessageNotification: %v", err)
+		}
+		if got := recorder.byToken()[carolToken].Style; got != pictureStyle {
+			t.Errorf("picture push got %+v, want %+v", got, pictureStyle)
+		}
+	})
+
+	t.Run("without configuration every push keeps the old style", func(t *testing.T) {
+		unstyled, recorder := newPushTestService(env)
+		if _, err := unstyled.SendMessageNotification(room.ID.Hex(), alice.UserID, alice.Username, "hi", "text", room.Name, nil, nil); err != nil {
+			t.Fatalf("SendMessageNotification: %v", err)
+		}
+		for token, batch := range recorder.byToken() {
+			if batch.Style != config.DefaultPushStyle {
+				t.Errorf("%s got %+v, want %+v", token, batch.Style, config.DefaultPushStyle)
+			}
+		}
+	})
+}
+
+func TestPushTokensRouteToTheirProvider(t *testing.T) {
+	env := newTestEnv(t, false)
+	alice, bob, carol, dave := env.createUser(t, "alice"), env.createUser(t, "bob"), env.createUser(t, "carol"), env.createUser(t, "dave")
+	room := env.createChatroom(t, "General", alice, bob, carol, dave)
+	expoToken := env.addPushToken(t, bob)
+	const fcmToken, namedFCM = "carol-device:APA91bExample", "dave-device"
+	for user, token := range map[*models.User]models.PushToken{
+		carol: {Token: fcmToken, Platform: "android"},
+		dave:  {Token: namedFCM, Platform: "fcm"},
+	} {
+		token.UserID, token.IsActive = user.UserID, true
+		if err := env.Users.DB.Create(&token).Error; err != nil {
+			t.Fatalf("create push token: %v", err)
+		}
+	}
+	send := func(service *PushNotificationService) int {
+		t.Helper()
+		sent, err := service.SendMessageNotification(room.ID.Hex(), alice.UserID, alice.Username, "hi", "text", room.Name, nil, nil)
+		if err != nil {
+			t.Fatalf("SendMessageNotification: %v", err)
+		}
+		return sent
+	}
+	tokens := func(recorder *pushRecorder) []string {
+		var all []string
+		for token := range recorder.byToken() {
+			all = append(all, token)
+		}
+		slices.Sort(all)
+		return all
+	}
+
+	service, expo := newPushTestService(env)
+	fcm := &pushRecorder{}
+	service.fcm = fcm
+	if sent := send(service); sent != 3 {
+		t.Errorf("sent to %d tokens, want 3", sent)
+	}
+	if got := tokens(expo); !slices.Equal(got, []string{expoToken}) {
+		t.Errorf("Expo got %v, want only the Expo token", got)
+	}
+	if got := tokens(fcm); !slices.Equal(got, []string{fcmToken, namedFCM}) {
+		t.Errorf("FCM got %v, want the FCM tokens", got)
+	}
+
+	// Without FCM credentials FCM tokens are skipped rather than sent to Expo
+	service, expo = newPushTestService(env)
+	if sent := send(service); sent != 1 {
+		t.Errorf("sent to %d tokens without FCM, want 1", sent)
+	}
+	if got := tokens(expo); !slices.Equal(got, []string{expoToken}) {
+		t.Errorf("Expo got %v without FCM, want only the Expo token", got)
+	}
+}
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/ginchat/config"
+	"github.com/ginchat/models"
+	"github.com/ginchat/utils"
+)
+
+// NotificationSender delivers a notification to a batch of device tokens that all belong to one push provider.
+// Send also returns the tokens the provider reported as no longer registered to a device.
+type NotificationSender interface {
+	Send(tokens []string, title, body string, data map[string]interface{}, style config.PushStyle) (unregistered []string, err error)
+}
+
+// expoDeviceNotRegistered is the Expo error for a token whose app was uninstalled or whose registration expired
+const expoDeviceNotRegistered = "DeviceNotRegistered"
+
+// ExpoSender sends notifications through the Expo Push API
+type ExpoSender struct{}
+
+// Send posts the notification to Expo in one request for all tokens
+func (ExpoSender) Send(tokens []string, title, body string, data map[string]interface{}, style config.PushStyle) ([]string, error) {
+	message := ExpoMessage{
+		To:       tokens,
+		Title:    title,
+		Body:     body,
+		Data:     data,
+		Sound:    style.Sound,
+		Priority: style.Priority,
+	}
+
+	jsonData, err := json.Marshal(message)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal notification: %w", err)
+	}
+
+	resp, err := http.Post(
+		"https://exp.host/--/api/v2/push/send",
+		"application/json",
+		bytes.NewBuffer(jsonData),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send notification: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("expo push API returned status %d", resp.StatusCode)
+	}
+
+	// Parse response to check for errors
+	var expoResp ExpoResponse
+	if err := json.NewDecoder(resp.Body).Decode(&expoResp); err != nil {
+		log.Printf("Warning: Failed to parse Expo response: %v", err)
+		return nil, nil // Don't fail if we can't parse response
+	}
+
+	// Log any errors from Expo; tickets come back in the same order as the tokens
+	var unregistered []string
+	for i, result := range expoResp.Data {
+		if result.Status == "error" {
+			log.Printf("Expo push error: %s - %s", result.Message, result.Details.Error)
+			if result.Details.Error == expoDeviceNotRegistered && i < len(tokens) {
+				unregistered = append(unregistered, tokens[i])
+			}
+		}
+	}
+
+	utils.PushNotificationsSentTotal.Add(float64(len(tokens)))
+	log.Printf("Successfully sent push notification to %d tokens", len(tokens))
+	return unregistered, nil
+}
+
+// senderFor returns the sender for a push provider, or nil if that provider isn't configured
+func (s *PushNotificationService) senderFor(provider string) NotificationSender {
+	switch provider {
+	case models.PushProviderFCM:
+		return s.fcm
+	default:
+		return s.expo
+	}
+}
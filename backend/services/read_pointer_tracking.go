@@ -0,0 +1,577 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/ginchat/models"
+	"github.com/ginchat/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Pointer-based read tracking (READ_POINTER_TRACKING).
+//
+// Instead of a message_read_status row per message and recipient, a member has read every message
+// sent up to their user_last_read pointer. A message counts as unread for a member when it was sent
+// after the pointer by someone else and isn't a system notice, which is exactly the set of rows the
+// row-based scheme leaves unread as long as messages are read in order. Marking one message read
+// therefore also marks everything before it, and pointers only ever move forward.
+//
+// A member without a pointer has read everything sent before they joined: history from before that
+// was never theirs to catch up on. Pointers for reads made before the switch come from BackfillLastReadPointers.
+//
+// The per-message read details (ReadInfo, read-by lists) are derived from the current members'
+// pointers. A member's read_at is when they last moved their pointer, and members who have left
+// the chatroom no longer appear.
+
+// unreadMessagesFilter matches the messages in a chatroom the user hasn't read, given the send time
+// they have read up to (the zero time if unknown)
+func unreadMessagesFilter(chatroomID primitive.ObjectID, userID uint, readUpTo time.Time) bson.M {
+	filter := bson.M{
+		"chatroom_id":  chatroomID,
+		"sender_id":    bson.M{"$ne": userID},
+		"message_type": bson.M{"$ne": models.MessageTypeSystem},
+	}
+	if !readUpTo.IsZero() {
+		filter["sent_at"] = bson.M{"$gt": readUpTo}
+	}
+	return filter
+}
+
+// lastReadPointers loads the user's last-read pointers for the given chatrooms
+func (s *MessageReadStatusService) lastReadPointers(ctx context.Context, userID uint, chatroomIDs []primitive.ObjectID) (map[primitive.ObjectID]models.UserLastRead, error) {
+	cursor, err := s.UserLastReadColl.Find(ctx, bson.M{
+		"user_id":     userID,
+		"chatroom_id": bson.M{"$in": chatroomIDs},
+	})
+	if err != nil {
+		return nil, errors.New("failed to get user last read")
+	}
+
+	var lastReads []models.UserLastRead
+	if err := cursor.All(ctx, &lastReads); err != nil {
+		return nil, errors.New("failed to get user last read")
+	}
+
+	pointers := make(map[primitive.ObjectID]models.UserLastRead, len(lastReads))
+	for _, lastRead := range lastReads {
+		pointers[lastRead.ChatroomID] = lastRead
+	}
+	return pointers, nil
+}
+
+// readHorizons returns, per chatroom, the send time the user has read up to: their pointer's, or just
+// before they joined if they have no pointer there. Chatrooms without either are left out.
+func (s *MessageReadStatusService) readHorizons(ctx context.Context, userID uint, chatroomIDs []primitive.ObjectID, pointers map[primitive.ObjectID]models.UserLastRead) (map[primitive.ObjectID]time.Time, error) {
+	horizons := make(map[primitive.ObjectID]time.Time, len(chatroomIDs))
+	withoutPointer := make([]primitive.ObjectID, 0)
+	for _, chatroomID := range chatroomIDs {
+		if pointer, ok := pointers[chatroomID]; ok {
+			horizons[chatroomID] = pointer.ReadUpTo()
+		} else {
+			withoutPointer = append(withoutPointer, chatroomID)
+		}
+	}
+	if len(withoutPointer) == 0 {
+		return horizons, nil
+	}
+
+	joinedAt, err := s.memberJoinTimes(ctx, userID, withoutPointer)
+	if err != nil {
+		return nil, err
+	}
+	for chatroomID, at := range joinedAt {
+		// Times are stored to the millisecond, so this keeps a message sent as they joined unread
+		horizons[chatroomID] = at.Add(-time.Millisecond)
+	}
+	return horizons, nil
+}
+
+// readHorizon is readHorizons for one chatroom, given the user's pointer there (nil if none);
+// the zero time means the user is no member
+func (s *MessageReadStatusService) readHorizon(ctx context.Context, chatroomID primitive.ObjectID, userID uint, pointer *models.UserLastRead) (time.Time, error) {
+	if pointer != nil {
+		return pointer.ReadUpTo(), nil
+	}
+	horizons, err := s.readHorizons(ctx, userID, []primitive.ObjectID{chatroomID}, nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return horizons[chatroomID], nil
+}
+
+// advanceLastRead moves the user's pointer in the message's chatroom up to the message, recording
+// readAt as the time of the read. A pointer that is already at or past the message is left alone.
+func (s *MessageReadStatusService) advanceLastRead(ctx context.Context, message *models.Message, userID uint, readAt time.Time) error {
+	now := time.Now()
+
+	// Only pointers behind the message match (records without sent_at are compared by read_at)
+	result, err := s.UserLastReadColl.UpdateOne(ctx,
+		bson.M{
+			"chatroom_id": message.ChatroomID,
+			"user_id":     userID,
+			"$or": []bson.M{
+				{"sent_at": bson.M{"$lt": message.SentAt}},
+				{"sent_at": bson.M{"$exists": false}, "read_at": bson.M{"$lt": message.SentAt}},
+			},
+		},
+		bson.M{"$set": bson.M{
+			"message_id": message.ID,
+			"sent_at":    message.SentAt,
+			"read_at":    readAt,
+			"updated_at": now,
+		}},
+	)
+	if err != nil {
+		return errors.New("failed to update user last read")
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+
+	// Either there is no pointer yet, or it is already further along; the unique
+	// user_chatroom_idx index rejects the insert in the second case
+	_, err = s.UserLastReadColl.InsertOne(ctx, models.UserLastRead{
+		ID:         primitive.NewObjectID(),
+		ChatroomID: message.ChatroomID,
+		UserID:     userID,
+		MessageID:  message.ID,
+		ReadAt:     readAt,
+		SentAt:     &message.SentAt,
+		UpdatedAt:  now,
+	})
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return errors.New("failed to update user last read")
+	}
+	return nil
+}
+
+// BackfillLastReadPointers moves each member's pointer up to the newest message they have a read row
+// for, so reads recorded as message_read_status rows before READ_POINTER_TRACKING was turned on still
+// count. Pointers only move forward, so running it again is harmless. It returns how many (member,
+// chatroom) pairs were visited.
+func (s *MessageReadStatusService) BackfillLastReadPointers() (int, error) {
+	ctx := context.Background()
+
+	pipeline := []bson.M{
+		{"$match": bson.M{"is_read": true}},
+		{
+			"$lookup": bson.M{
+				"from": "messages",
+				"let":  bson.M{"message_id": "$message_id"},
+				"pipeline": []bson.M{
+					{"$match": bson.M{"$expr": bson.M{"$eq": []interface{}{"$_id", "$$message_id"}}}},
+					{"$project": bson.M{"sent_at": 1}},
+				},
+				"as": "message",
+			},
+		},
+		{"$unwind": "$message"}, // Rows of deleted messages have nothing to point at
+		{"$sort": bson.D{{Key: "message.sent_at", Value: -1}, {Key: "message_id", Value: -1}}},
+		{
+			"$group": bson.M{
+				"_id":        bson.M{"user_id": "$recipient_id", "chatroom_id": "$chatroom_id"},
+				"message_id": bson.M{"$first": "$message_id"},
+				"sent_at":    bson.M{"$first": "$message.sent_at"},
+				"read_at":    bson.M{"$max": "$read_at"},
+			},
+		},
+	}
+	cursor, err := s.ReadStatusColl.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return 0, errors.New("failed to scan read statuses")
+	}
+	defer cursor.Close(ctx)
+
+	visited := 0
+	for cursor.Next(ctx) {
+		var latest struct {
+			Key struct {
+				UserID     uint               `bson:"user_id"`
+				ChatroomID primitive.ObjectID `bson:"chatroom_id"`
+			} `bson:"_id"`
+			MessageID primitive.ObjectID `bson:"message_id"`
+			SentAt    time.Time          `bson:"sent_at"`
+			ReadAt    *time.Time         `bson:"read_at"`
+		}
+		if err := cursor.Decode(&latest); err != nil {
+			return visited, errors.New("failed to decode read statuses")
+		}
+		readAt := latest.SentAt
+		if latest.ReadAt != nil && latest.ReadAt.After(readAt) {
+			readAt = *latest.ReadAt
+		}
+		message := models.Message{ID: latest.MessageID, ChatroomID: latest.Key.ChatroomID, SentAt: latest.SentAt}
+		if err := s.advanceLastRead(ctx, &message, latest.Key.UserID, readAt); err != nil {
+			return visited, err
+		}
+		visited++
+	}
+	if err := cursor.Err(); err != nil {
+		return visited, errors.New("failed to scan read statuses")
+	}
+
+	log.Printf("Last-read pointer backfill visited %d members' chatrooms", visited)
+	return visited, nil
+}
+
+// StartLastReadBackfill runs BackfillLastReadPointers once in the background
+func (s *MessageReadStatusService) StartLastReadBackfill() {
+	go func() {
+		if _, err := s.BackfillLastReadPointers(); err != nil {
+			log.Printf("Last-read pointer backfill failed: %v", err)
+		}
+	}()
+}
+
+// isReadableBy reports whether the user could have a read status for the message:
+// they are a member of its chatroom and didn't send it, and it isn't a system notice
+func (s *MessageReadStatusService) isReadableBy(ctx context.Context, message *models.Message, userID uint) (bool, error) {
+	if message.SenderID == userID || message.MessageType == models.MessageTypeSystem {
+		return false, nil
+	}
+	count, err := s.ChatroomColl.CountDocuments(ctx, bson.M{"_id": message.ChatroomID, "members.user_id": userID})
+	if err != nil {
+		return false, errors.New("failed to check chatroom membership")
+	}
+	return count > 0, nil
+}
+
+// markMessageReadByPointer is MarkMessageAsReadAt for pointer tracking; it returns the message's chatroom
+func (s *MessageReadStatusService) markMessageReadByPointer(messageID primitive.ObjectID, userID uint, readAt *time.Time) (primitive.ObjectID, error) {
+	ctx := context.Background()
+
+	var message models.Message
+	if err := s.MessageColl.FindOne(ctx, bson.M{"_id": messageID}).Decode(&message); err != nil {
+		return primitive.NilObjectID, errors.New("message not found")
+	}
+
+	readable, err := s.isReadableBy(ctx, &message, userID)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	if !readable {
+		return primitive.NilObjectID, errors.New("read status not found")
+	}
+
+	readTime, err := resolveReadTime(readAt, message.SentAt)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	if err := s.advanceLastRead(ctx, &message, userID, readTime); err != nil {
+		return primitive.NilObjectID, errors.New("failed to mark message as read")
+	}
+	utils.ReadStatusOperationsTotal.WithLabelValues("mark_read").Inc()
+
+	return message.ChatroomID, nil
+}
+
+// markMessagesReadByPointer is MarkMessagesAsReadAt for pointer tracking: each chatroom's pointer
+// moves to the newest message marked in it
+func (s *MessageReadStatusService) markMessagesReadByPointer(messageIDs []primitive.ObjectID, userID uint, readAt *time.Time) (*MarkMessagesAsReadResult, error) {
+	ctx := context.Background()
+	result := &MarkMessagesAsReadResult{}
+	if len(messageIDs) == 0 {
+		return result, nil
+	}
+
+	cursor, err := s.MessageColl.Find(ctx, bson.M{"_id": bson.M{"$in": messageIDs}},
+		options.Find().SetProjection(bson.M{"_id": 1, "chatroom_id": 1, "sender_id": 1, "message_type": 1, "sent_at": 1}))
+	if err != nil {
+		return nil, errors.New("failed to get read statuses")
+	}
+	var messages []models.Message
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, errors.New("failed to get read statuses")
+	}
+
+	messagesByID := make(map[primitive.ObjectID]models.Message, len(messages))
+	chatroomIDs := make([]primitive.ObjectID, 0)
+	for _, message := range messages {
+		if _, ok := messagesByID[message.ID]; !ok {
+			messagesByID[message.ID] = message
+		}
+		chatroomIDs = append(chatroomIDs, message.ChatroomID)
+	}
+
+	// Chatrooms among those the user belongs to
+	memberCursor, err := s.ChatroomColl.Find(ctx,
+		bson.M{"_id": bson.M{"$in": chatroomIDs}, "members.user_id": userID},
+		options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, errors.New("failed to check chatroom membership")
+	}
+	var memberOf []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := memberCursor.All(ctx, &memberOf); err != nil {
+		return nil, errors.New("failed to check chatroom membership")
+	}
+	isMember := make(map[primitive.ObjectID]bool, len(memberOf))
+	for _, chatroom := range memberOf {
+		isMember[chatroom.ID] = true
+	}
+
+	pointers, err := s.lastReadPointers(ctx, userID, chatroomIDs)
+	if err != nil {
+		return nil, err
+	}
+	horizons, err := s.readHorizons(ctx, userID, chatroomIDs, pointers)
+	if err != nil {
+		return nil, err
+	}
+
+	latestByChatroom := make(map[primitive.ObjectID]models.Message)
+	seen := make(map[primitive.ObjectID]bool, len(messageIDs))
+	for _, messageID := range messageIDs {
+		if seen[messageID] {
+			continue
+		}
+		seen[messageID] = true
+
+		message, ok := messagesByID[messageID]
+		if !ok || !isMember[message.ChatroomID] || message.SenderID == userID || message.MessageType == models.MessageTypeSystem {
+			result.NotFound = append(result.NotFound, messageID)
+			continue
+		}
+		if horizon, ok := horizons[message.ChatroomID]; ok && !horizon.Before(message.SentAt) {
+			result.AlreadyRead = append(result.AlreadyRead, messageID)
+			continue
+		}
+		result.Marked = append(result.Marked, messageID)
+		if latest, ok := latestByChatroom[message.ChatroomID]; !ok || message.SentAt.After(latest.SentAt) {
+			latestByChatroom[message.ChatroomID] = message
+		}
+	}
+
+	newestSentAt := time.Time{}
+	for _, message := range latestByChatroom {
+		if message.SentAt.After(newestSentAt) {
+			newestSentAt = message.SentAt
+		}
+	}
+	readTime, err := resolveReadTime(readAt, newestSentAt)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, message := range latestByChatroom {
+		if err := s.advanceLastRead(ctx, &message, userID, readTime); err != nil {
+			return nil, errors.New("failed to mark messages as read")
+		}
+	}
+	if len(result.Marked) > 0 {
+		utils.ReadStatusOperationsTotal.WithLabelValues("mark_read").Add(float64(len(result.Marked)))
+	}
+
+	return result, nil
+}
+
+// readStatusesByPointer derives a message's read statuses from the pointers of the chatroom's
+// current members (everyone but the sender), in member order
+func (s *MessageReadStatusService) readStatusesByPointer(messageID primitive.ObjectID) ([]models.MessageReadStatus, error) {
+	statuses, err := s.readStatusesByPointerBatch([]primitive.ObjectID{messageID})
+	if err != nil {
+		return nil, err
+	}
+	if statuses[messageID] == nil {
+		return []models.MessageReadStatus{}, nil // Same as a message without rows
+	}
+	return statuses[messageID], nil
+}
+
+// readStatusesByPointerBatch is readStatusesByPointer for many messages, with one query each for the
+// messages, their chatrooms and the members' pointers. Unknown messages and system notices have no entry.
+func (s *MessageReadStatusService) readStatusesByPointerBatch(messageIDs []primitive.ObjectID) (map[primitive.ObjectID][]models.MessageReadStatus, error) {
+	ctx := context.Background()
+	result := make(map[primitive.ObjectID][]models.MessageReadStatus, len(messageIDs))
+
+	cursor, err := s.MessageColl.Find(ctx,
+		bson.M{"_id": bson.M{"$in": messageIDs}, "message_type": bson.M{"$ne": models.MessageTypeSystem}},
+		options.Find().SetProjection(bson.M{"_id": 1, "chatroom_id": 1, "sender_id": 1, "sent_at": 1}))
+	if err != nil {
+		return nil, errors.New("failed to get read statuses")
+	}
+	var messages []models.Message
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, errors.New("failed to decode read statuses")
+	}
+	if len(messages) == 0 {
+		return result, nil
+	}
+
+	chatroomIDs := make([]primitive.ObjectID, 0, len(messages))
+	for _, message := range messages {
+		chatroomIDs = append(chatroomIDs, message.ChatroomID)
+	}
+
+	chatroomCursor, err := s.ChatroomColl.Find(ctx, bson.M{"_id": bson.M{"$in": chatroomIDs}},
+		options.Find().SetProjection(bson.M{"members": 1}))
+	if err != nil {
+		return nil, errors.New("failed to get read statuses")
+	}
+	var chatrooms []models.Chatroom
+	if err := chatroomCursor.All(ctx, &chatrooms); err != nil {
+		return nil, errors.New("failed to decode read statuses")
+	}
+	membersByChatroom := make(map[primitive.ObjectID][]models.ChatroomMember, len(chatrooms))
+	for _, chatroom := range chatrooms {
+		membersByChatroom[chatroom.ID] = chatroom.Members
+	}
+
+	lastReadCursor, err := s.UserLastReadColl.Find(ctx, bson.M{"chatroom_id": bson.M{"$in": chatroomIDs}})
+	if err != nil {
+		return nil, errors.New("failed to get read statuses")
+	}
+	var lastReads []models.UserLastRead
+	if err := lastReadCursor.All(ctx, &lastReads); err != nil {
+		return nil, errors.New("failed to decode read statuses")
+	}
+	type pointerKey struct {
+		chatroomID primitive.ObjectID
+		userID     uint
+	}
+	pointers := make(map[pointerKey]models.UserLastRead, len(lastReads))
+	for _, lastRead := range lastReads {
+		pointers[pointerKey{lastRead.ChatroomID, lastRead.UserID}] = lastRead
+	}
+
+	for _, message := range messages {
+		members, ok := membersByChatroom[message.ChatroomID]
+		if !ok {
+			continue // Chatroom deleted
+		}
+		statuses := make([]models.MessageReadStatus, 0, len(members))
+		for _, member := range members {
+			if member.UserID == message.SenderID {
+				continue
+			}
+			status := models.MessageReadStatus{
+				MessageID:   message.ID,
+				ChatroomID:  message.ChatroomID,
+				SenderID:    message.SenderID,
+				RecipientID: member.UserID,
+				CreatedAt:   message.SentAt,
+			}
+			if pointer, ok := pointers[pointerKey{message.ChatroomID, member.UserID}]; ok {
+				if !pointer.ReadUpTo().Before(message.SentAt) {
+					readAt := pointer.ReadAt
+					status.IsRead = true
+					status.ReadAt = &readAt
+				}
+			} else if message.SentAt.Before(member.JoinedAt) {
+				// Sent before they joined, which counts as read on joining
+				readAt := member.JoinedAt
+				status.IsRead = true
+				status.ReadAt = &readAt
+			}
+			statuses = append(statuses, status)
+		}
+		result[message.ID] = statuses
+	}
+	return result, nil
+}
+
+// unreadByPointer counts the user's unread messages per chatroom (and finds the first unread one)
+// with a single aggregation over the messages after each of their pointers
+func (s *MessageReadStatusService) unreadByPointer(ctx context.Context, userID uint, chatroomIDs []primitive.ObjectID) (map[string]int64, map[string]string, map[string]string, error) {
+	pointers, err := s.lastReadPointers(ctx, userID, chatroomIDs)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	horizons, err := s.readHorizons(ctx, userID, chatroomIDs, pointers)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	lastReadMap := make(map[string]string, len(pointers))
+	for chatroomID, pointer := range pointers {
+		lastReadMap[chatroomID.Hex()] = pointer.MessageID.Hex()
+	}
+	branches := make([]bson.M, 0, len(chatroomIDs))
+	for _, chatroomID := range chatroomIDs {
+		branch := bson.M{"chatroom_id": chatroomID}
+		if horizon, ok := horizons[chatroomID]; ok && !horizon.IsZero() {
+			branch["sent_at"] = bson.M{"$gt": horizon}
+		}
+		branches = append(branches, branch)
+	}
+
+	pipeline := []bson.M{
+		{
+			"$match": bson.M{
+				"$or":          branches,
+				"sender_id":    bson.M{"$ne": userID},
+				"message_type": bson.M{"$ne": models.MessageTypeSystem},
+			},
+		},
+		// The first unread message is the earliest as the chat orders them, which ObjectIDs don't follow
+		{"$sort": bson.D{{Key: "sent_at", Value: 1}, {Key: "_id", Value: 1}}},
+		{
+			"$group": bson.M{
+				"_id":          "$chatroom_id",
+				"count":        bson.M{"$sum": 1},
+				"first_unread": bson.M{"$first": "$_id"},
+			},
+		},
+	}
+
+	cursor, err := s.MessageColl.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, nil, nil, errors.New("failed to get unread count")
+	}
+	defer cursor.Close(ctx)
+
+	unreadMap := make(map[string]int64)
+	firstUnreadMap := make(map[string]string)
+	for cursor.Next(ctx) {
+		var result struct {
+			ID          primitive.ObjectID `bson:"_id"`
+			Count       int64              `bson:"count"`
+			FirstUnread primitive.ObjectID `bson:"first_unread"`
+		}
+		if err := cursor.Decode(&result); err != nil {
+			continue
+		}
+		unreadMap[result.ID.Hex()] = result.Count
+		firstUnreadMap[result.ID.Hex()] = result.FirstUnread.Hex()
+	}
+
+	return unreadMap, firstUnreadMap, lastReadMap, nil
+}
+
+// unreadMessagesByPointer is GetUnreadMessagesInChatroom for pointer tracking
+func (s *MessageReadStatusService) unreadMessagesByPointer(chatroomID primitive.ObjectID, userID uint, newestFirst bool) ([]models.Message, error) {
+	ctx := context.Background()
+	lastRead, err := s.GetUserLastReadForChatroom(chatroomID, userID)
+	if err != nil {
+		return nil, errors.New("failed to get unread message statuses")
+	}
+	readUpTo, err := s.readHorizon(ctx, chatroomID, userID, lastRead)
+	if err != nil {
+		return nil, errors.New("failed to get unread message statuses")
+	}
+
+	order := 1
+	if newestFirst {
+		order = -1
+	}
+	cursor, err := s.MessageColl.Find(ctx, unreadMessagesFilter(chatroomID, userID, readUpTo),
+		options.Find().SetSort(bson.D{{Key: "sent_at", Value: order}, {Key: "_id", Value: order}}))
+	if err != nil {
+		return nil, errors.New("failed to get unread messages")
+	}
+	defer cursor.Close(ctx)
+
+	messages := []models.Message{}
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, errors.New("failed to decode unread messages")
+	}
+	return messages, nil
+}
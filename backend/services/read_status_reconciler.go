@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ReconcileResult counts the records removed by one reconciliation run
+type ReconcileResult struct {
+	ReadStatusesPruned int64 `json:"read_statuses_pruned" example:"42"`
+	LastReadsPruned    int64 `json:"last_reads_pruned" example:"3"`
+}
+
+// orphanCandidate is the part of a read-status or last-read document the reconciler looks at
+type orphanCandidate struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	MessageID  primitive.ObjectID `bson:"message_id"`
+	ChatroomID primitive.ObjectID `bson:"chatroom_id"`
+}
+
+// ReconcileOrphanedRecords removes read-status rows whose message or chatroom no longer exists,
+// and last-read pointers for chatrooms that were deleted. Deletion paths don't always clean these up,
+// and leftovers inflate unread counts. Records are scanned READ_STATUS_RECONCILE_BATCH at a time.
+func (s *MessageReadStatusService) ReconcileOrphanedRecords() (*ReconcileResult, error) {
+	ctx := context.Background()
+	result := &ReconcileResult{}
+
+	pruned, err := s.pruneOrphans(ctx, s.ReadStatusColl, true)
+	result.ReadStatusesPruned = pruned
+	if err != nil {
+		return result, err
+	}
+
+	// A last-read pointer to a deleted message is still a valid position, so only the chatroom is checked
+	pruned, err = s.pruneOrphans(ctx, s.UserLastReadColl, false)
+	result.LastReadsPruned = pruned
+	if err != nil {
+		return result, err
+	}
+
+	log.Printf("Read status reconciliation pruned %d read statuses and %d last-read records", result.ReadStatusesPruned, result.LastReadsPruned)
+	return result, nil
+}
+
+// StartOrphanReconciler runs ReconcileOrphanedRecords every interval in the background
+func (s *MessageReadStatusService) StartOrphanReconciler(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if _, err := s.ReconcileOrphanedRecords(); err != nil {
+				log.Printf("Read status reconciliation failed: %v", err)
+			}
+		}
+	}()
+}
+
+// pruneOrphans walks coll in _id order one batch at a time, deleting documents whose chatroom
+// (and, when checkMessages is set, message) is gone. It returns how many documents were deleted.
+func (s *MessageReadStatusService) pruneOrphans(ctx context.Context, coll *mongo.Collection, checkMessages bool) (int64, error) {
+	var pruned int64
+	lastID := primitive.NilObjectID
+
+	for {
+		opts := options.Find().
+			SetSort(bson.D{{Key: "_id", Value: 1}}).
+			SetLimit(int64(s.reconcileBatchSize)).
+			SetProjection(bson.M{"_id": 1, "message_id": 1, "chatroom_id": 1})
+		cursor, err := coll.Find(ctx, bson.M{"_id": bson.M{"$gt": lastID}}, opts)
+		if err != nil {
+			return pruned, errors.New("failed to scan for orphaned records")
+		}
+		var batch []orphanCandidate
+		if err := cursor.All(ctx, &batch); err != nil {
+			return pruned, errors.New("failed to scan for orphaned records")
+		}
+		if len(batch) == 0 {
+			return pruned, nil
+		}
+		lastID = batch[len(batch)-1].ID
+
+		var chatroomIDs, messageIDs []primitive.ObjectID
+		for _, candidate := range batch {
+			chatroomIDs = append(chatroomIDs, candidate.ChatroomID)
+			messageIDs = append(messageIDs, candidate.MessageID)
+		}
+		chatrooms, err := existingIDs(ctx, s.ChatroomColl, chatroomIDs)
+		if err != nil {
+			return pruned, err
+		}
+		var messages map[primitive.ObjectID]bool
+		if checkMessages {
+			if messages, err = existingIDs(ctx, s.MessageColl, messageIDs); err != nil {
+				return pruned, err
+			}
+		}
+
+		var orphans []primitive.ObjectID
+		for _, candidate := range batch {
+			if !chatrooms[candidate.ChatroomID] || (checkMessages && !messages[candidate.MessageID]) {
+				orphans = append(orphans, candidate.ID)
+			}
+		}
+		if len(orphans) > 0 {
+			deleted, err := coll.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": orphans}})
+			if err != nil {
+				return pruned, errors.New("failed to delete orphaned records")
+			}
+			pruned += deleted.DeletedCount
+		}
+
+		if len(batch) < s.reconcileBatchSize {
+			return pruned, nil
+		}
+	}
+}
+
+// existingIDs returns which of ids still have a document in coll
+func existingIDs(ctx context.Context, coll *mongo.Collection, ids []primitive.ObjectID) (map[primitive.ObjectID]bool, error) {
+	cursor, err := coll.Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, errors.New("failed to scan for orphaned records")
+	}
+	var docs []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, errors.New("failed to scan for orphaned records")
+	}
+
+	existing := make(map[primitive.ObjectID]bool, len(docs))
+	for _, doc := range docs {
+		existing[doc.ID] = true
+	}
+	return existing, nil
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ginchat/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestReconcileOrphanedRecords(t *testing.T) {
+	// 6 read statuses: batches of 1 and 3 end exactly on the last record, 4 splits it unevenly
+	for _, batchSize := range []int{1, 3, 4, 100} {
+		t.Run(fmt.Sprintf("batch of %d", batchSize), func(t *testing.T) {
+			env := newTestEnv(t, false)
+			service := NewMessageReadStatusService(env.Mongo, env.Chatrooms, env.Users, false, batchSize)
+			alice, bob := env.createUser(t, "alice"), env.createUser(t, "bob")
+			room := env.createChatroom(t, "General", alice, bob)
+			deletedRoom := primitive.NewObjectID()
+			ctx := context.Background()
+
+			message := func(chatroomID primitive.ObjectID) primitive.ObjectID {
+				stored := models.Message{ID: primitive.NewObjectID(), ChatroomID: chatroomID, SenderID: alice.UserID, MessageType: "text", TextContent: "hi", SentAt: time.Now()}
+				if _, err := env.Messages.MsgColl.InsertOne(ctx, stored); err != nil {
+					t.Fatalf("insert message: %v", err)
+				}
+				return stored.ID
+			}
+			readStatus := func(messageID, chatroomID primitive.ObjectID) primitive.ObjectID {
+				status := models.MessageReadStatus{ID: primitive.NewObjectID(), MessageID: messageID, ChatroomID: chatroomID, SenderID: alice.UserID, RecipientID: bob.UserID, CreatedAt: time.Now()}
+				if _, err := service.ReadStatusColl.InsertOne(ctx, status); err != nil {
+					t.Fatalf("insert read status: %v", err)
+				}
+				return status.ID
+			}
+			lastRead := func(messageID, chatroomID primitive.ObjectID) primitive.ObjectID {
+				record := models.UserLastRead{ID: primitive.NewObjectID(), ChatroomID: chatroomID, UserID: bob.UserID, MessageID: messageID, ReadAt: time.Now(), UpdatedAt: time.Now()}
+				if _, err := service.UserLastReadColl.InsertOne(ctx, record); err != nil {
+					t.Fatalf("insert last read: %v", err)
+				}
+				return record.ID
+			}
+
+			// Orphans are interleaved with valid records so they land in different batches
+			first := readStatus(message(room.ID), room.ID)
+			readStatus(primitive.NewObjectID(), room.ID) // Message deleted
+			second := readStatus(message(room.ID), room.ID)
+			readStatus(message(deletedRoom), deletedRoom) // Chatroom deleted, message left behind
+			third := readStatus(message(room.ID), room.ID)
+			readStatus(primitive.NewObjectID(), deletedRoom) // Both deleted
+
+			pointer := lastRead(message(room.ID), room.ID)
+			deletedMessagePointer := lastRead(primitive.NewObjectID(), room.ID) // Still a valid position
+			lastRead(primitive.NewObjectID(), deletedRoom)
+
+			result, err := service.ReconcileOrphanedRecords()
+			if err != nil {
+				t.Fatalf("ReconcileOrphanedRecords: %v", err)
+			}
+			if result.ReadStatusesPruned != 3 || result.LastReadsPruned != 1 {
+				t.Errorf("pruned %+v, want 3 read statuses and 1 last read", *result)
+			}
+			assertIDs(t, "remaining read statuses", storedIDs(env, "message_read_status"), first, second, third)
+			assertIDs(t, "remaining last reads", storedIDs(env, "user_last_read"), pointer, deletedMessagePointer)
+
+			// A second run finds nothing left to prune
+			if result, err := service.ReconcileOrphanedRecords(); err != nil || result.ReadStatusesPruned != 0 || result.LastReadsPruned != 0 {
+				t.Errorf("second run = %+v, %v; want nothing pruned", result, err)
+			}
+		})
+	}
+}
+
+// storedIDs returns the _id of every document in the collection, in insertion order
+func storedIDs(env *testEnv, collection string) []primitive.ObjectID {
+	var ids []primitive.ObjectID
+	for _, doc := range env.MongoDB.Documents(collection) {
+		ids = append(ids, doc.Map()["_id"].(primitive.ObjectID))
+	}
+	return ids
+}
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ginchat/models"
+	"github.com/ginchat/utils"
+	"gorm.io/gorm"
+)
+
+// sessionActivityInterval limits how often a session's last_active_at is written
+const sessionActivityInterval = time.Minute
+
+// SessionService tracks the tokens a user has signed in with
+type SessionService struct {
+	DB    *gorm.DB
+	Users *UserService
+
+	// touchedMu guards touched, the last time each token's activity was written,
+	// so most requests skip the database entirely
+	touchedMu sync.Mutex
+	touched   map[string]time.Time
+	prunedAt  time.Time
+}
+
+// NewSessionService creates a new SessionService
+func NewSessionService(db *gorm.DB, userService *UserService) *SessionService {
+	return &SessionService{
+		DB:      db,
+		Users:   userService,
+		touched: make(map[string]time.Time),
+	}
+}
+
+// CreateSession records a newly issued token as a session for the device that asked for it
+func (s *SessionService) CreateSession(userID uint, token *utils.IssuedToken, userAgent, ipAddress string) error {
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+	now := time.Now()
+	session := models.Session{
+		TokenID:      token.ID,
+		UserID:       userID,
+		UserAgent:    userAgent,
+		IPAddress:    ipAddress,
+		CreatedAt:    now,
+		LastActiveAt: now,
+		ExpiresAt:    token.ExpiresAt,
+	}
+	if err := s.DB.Create(&session).Error; err != nil {
+		return errors.New("failed to create session")
+	}
+
+	// Sessions whose token has expired can't be used any more
+	s.DB.Where("user_id = ? AND expires_at < ?", userID, now).Delete(&models.Session{})
+	return nil
+}
+
+// ListSessions returns the user's unexpired sessions, most recently active first
+func (s *SessionService) ListSessions(userID uint) ([]models.Session, error) {
+	var sessions []models.Session
+	err := s.DB.Where("user_id = ? AND expires_at > ?", userID, time.Now()).
+		Order("last_active_at DESC").Find(&sessions).Error
+	if err != nil {
+		return nil, errors.New("failed to get sessions")
+	}
+	return sessions, nil
+}
+
+// RevokeSession signs one of the user's sessions out by revoking its token, and returns the revoked session.
+// Revoking the last session also marks the user logged out so they can sign in again on another device.
+func (s *SessionService) RevokeSession(userID, sessionID uint) (*models.Session, error) {
+	var session models.Session
+	if err := s.DB.Where("id = ? AND user_id = ?", sessionID, userID).First(&session).Error; err != nil {
+		return nil, errors.New("session not found")
+	}
+
+	// RevokeToken also deletes the session row
+	if err := s.Users.RevokeToken(session.TokenID, userID, session.ExpiresAt); err != nil {
+		return nil, errors.New("failed to revoke session")
+	}
+
+	var remaining int64
+	if err := s.DB.Model(&models.Session{}).Where("user_id = ? AND expires_at > ?", userID, time.Now()).Count(&remaining).Error; err == nil && remaining == 0 {
+		if err := s.Users.Logout(userID); err != nil {
+			return nil, err
+		}
+	}
+
+	return &session, nil
+}
+
+// TouchSession records that the session's token was just used, at most once per sessionActivityInterval.
+// It is called on every authenticated request, so it only reaches the database when the interval has passed.
+func (s *SessionService) TouchSession(tokenID string) {
+	if tokenID == "" {
+		return
+	}
+	now := time.Now()
+	cutoff := now.Add(-sessionActivityInterval)
+
+	s.touchedMu.Lock()
+	if last, ok := s.touched[tokenID]; ok && last.After(cutoff) {
+		s.touchedMu.Unlock()
+		return
+	}
+	// Tokens that haven't been seen for an interval would be written anyway, so their entries can go
+	if s.prunedAt.Before(cutoff) {
+		for id, last := range s.touched {
+			if !last.After(cutoff) {
+				delete(s.touched, id)
+			}
+		}
+		s.prunedAt = now
+	}
+	s.touched[tokenID] = now
+	s.touchedMu.Unlock()
+
+	s.DB.Model(&models.Session{}).
+		Where("token_id = ? AND last_active_at < ?", tokenID, cutoff).
+		Update("last_active_at", now)
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ginchat/models"
+	"github.com/ginchat/utils"
+)
+
+func TestTouchSessionWritesOncePerInterval(t *testing.T) {
+	env := newTestEnv(t, false)
+	alice := env.createUser(t, "alice")
+	sessions := NewSessionService(env.Users.DB, env.Users)
+	utils.ConfigureJWT("session-test-secret", time.Hour)
+	issued, err := utils.IssueJWT(alice.UserID, alice.Username, alice.Email, alice.Role)
+	if err != nil {
+		t.Fatalf("IssueJWT: %v", err)
+	}
+	if err := sessions.CreateSession(alice.UserID, issued, "phone", "203.0.113.7"); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	// backdate sets the session's last activity to an hour ago and returns that time
+	backdate := func() time.Time {
+		t.Helper()
+		stale := time.Now().Add(-time.Hour).Truncate(time.Second)
+		if err := env.Users.DB.Model(&models.Session{}).Where("token_id = ?", issued.ID).Update("last_active_at", stale).Error; err != nil {
+			t.Fatalf("backdate session: %v", err)
+		}
+		return stale
+	}
+	lastActive := func() time.Time {
+		t.Helper()
+		var session models.Session
+		if err := env.Users.DB.Where("token_id = ?", issued.ID).First(&session).Error; err != nil {
+			t.Fatalf("load session: %v", err)
+		}
+		return session.LastActiveAt
+	}
+
+	stale := backdate()
+	sessions.TouchSession(issued.ID)
+	if got := lastActive(); !got.After(stale.Add(time.Minute)) {
+		t.Errorf("last_active_at = %v after the first touch, want now", got)
+	}
+
+	// Within the interval the touch is answered from memory, without a write
+	stale = backdate()
+	sessions.TouchSession(issued.ID)
+	if got := lastActive(); !got.Equal(stale) {
+		t.Errorf("last_active_at = %v after a second touch within the interval, want it left at %v", got, stale)
+	}
+
+	sessions.TouchSession("") // Tokens from before session tracking have no ID
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ginchat/config"
+	"github.com/ginchat/internal/mongotest"
+	"github.com/ginchat/internal/sqltest"
+	"github.com/ginchat/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// testEnv wires the services together over in-memory MySQL and MongoDB stand-ins
+type testEnv struct {
+	Mongo   *mongo.Database
+	MongoDB *mongotest.Server
+	SQL     *sqltest.DB
+
+	Users      *UserService
+	Chatrooms  *ChatroomService
+	ReadStatus *MessageReadStatusService
+	Messages   *MessageService
+}
+
+// newTestEnv builds a fresh environment; pointerTracking selects the user_last_read unread backend
+func newTestEnv(t *testing.T, pointerTracking bool) *testEnv {
+	t.Helper()
+	gdb, sqlDB := sqltest.Open(t, &models.User{}, &models.PushToken{}, &models.RevokedToken{}, &models.Session{})
+	mdb, server := mongotest.NewDatabase(t)
+
+	env := &testEnv{Mongo: mdb, MongoDB: server, SQL: sqlDB}
+	env.Users = NewUserService(gdb)
+	env.Chatrooms = NewChatroomService(mdb, pointerTracking, nil)
+	env.ReadStatus = NewMessageReadStatusService(mdb, env.Chatrooms, env.Users, pointerTracking, config.DefaultReadStatusReconcileBatch)
+	env.Messages = NewMessageService(mdb, env.Chatrooms, nil, env.ReadStatus, nil, nil, 100, config.DefaultMessageEditWindow)
+	return env
+}
+
+// createUser stores a user with the given name
+func (env *testEnv) createUser(t *testing.T, username string) *models.User {
+	t.Helper()
+	user := &models.User{Username: username, Email: username + "@example.com", Password: "x"}
+	if err := env.Users.DB.Create(user).Error; err != nil {
+		t.Fatalf("create user %s: %v", username, err)
+	}
+	return user
+}
+
+// createChatroom stores a chatroom created by the first user (its admin) with the rest as members
+func (env *testEnv) createChatroom(t *testing.T, name string, users ...*models.User) *models.Chatroom {
+	t.Helper()
+	roomCode, err := env.Chatrooms.generateRoomCode()
+	if err != nil {
+		t.Fatalf("generate room code: %v", err)
+	}
+	chatroom := &models.Chatroom{
+		ID:        primitive.NewObjectID(),
+		Name:      name,
+		RoomCode:  roomCode,
+		CreatedBy: users[0].UserID,
+		CreatedAt: time.Now(),
+	}
+	for i, user := range users {
+		role := models.ChatroomRoleMember
+		if i == 0 {
+			role = models.ChatroomRoleAdmin
+		}
+		chatroom.Members = append(chatroom.Members, models.ChatroomMember{
+			UserID:   user.UserID,
+			Username: user.Username,
+			Role:     role,
+			JoinedAt: time.Now(),
+		})
+	}
+	if _, err := env.Chatrooms.ChatColl.InsertOne(context.Background(), chatroom); err != nil {
+		t.Fatalf("create chatroom %s: %v", name, err)
+	}
+	return chatroom
+}
+
+// sendText sends a text message, failing the test on error
+func (env *testEnv) sendText(t *testing.T, chatroom *models.Chatroom, sender *models.User, text string) *models.Message {
+	t.Helper()
+	message, err := env.Messages.SendMessage(chatroom.ID, sender.UserID, sender.Username, "text", text, "")
+	if err != nil {
+		t.Fatalf("send %q: %v", text, err)
+	}
+	return message
+}
@@ -3,16 +3,28 @@ package services
 import (
 	"errors"
 	"log"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/ginchat/models"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// revokedTokenRefreshInterval is how often the revoked token cache is reloaded from the database,
+// which picks up tokens revoked by other instances
+const revokedTokenRefreshInterval = 30 * time.Second
+
 // UserService handles business logic related to users
 type UserService struct {
-	DB *gorm.DB
+	DB               *gorm.DB
+	defaultChatrooms *ChatroomService // Adds new users to the configured default rooms; nil skips it
+
+	revokedMu       sync.Mutex
+	revoked         map[string]time.Time // Revoked token IDs and when each token expires
+	revokedLoadedAt time.Time            // When revoked was last loaded from the database; zero forces a load
 }
 
 // NewUserService creates a new UserService
@@ -22,8 +34,35 @@ func NewUserService(db *gorm.DB) *UserService {
 	}
 }
 
+// SetDefaultChatroomJoiner sets the chatroom service Register uses to add new users to DEFAULT_CHATROOM_IDS
+func (s *UserService) SetDefaultChatroomJoiner(chatroomService *ChatroomService) {
+	s.defaultChatrooms = chatroomService
+}
+
+// NormalizeEmail trims and lowercases an email so lookups and the unique index agree
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// duplicateUserError maps a unique-index violation on users to the matching "already exists" error
+func (s *UserService) duplicateUserError(email, username string, excludeUserID uint) error {
+	var count int64
+	if email != "" {
+		s.DB.Model(&models.User{}).Where("email = ? AND user_id <> ?", email, excludeUserID).Count(&count)
+		if count > 0 {
+			return errors.New("user with this email already exists")
+		}
+	}
+	return errors.New("user with this username already exists")
+}
+
 // Register creates a new user
 func (s *UserService) Register(username, email, password, role string) (*models.User, error) {
+	email = NormalizeEmail(email)
+	username = strings.TrimSpace(username)
+
+	// Fast path for a friendly error; the unique indexes on email and username
+	// are what actually prevent duplicates when two registrations race.
 	// Check if email already exists
 	var existingUser models.User
 	if result := s.DB.Where("email = ?", email).First(&existingUser); result.Error == nil {
@@ -44,20 +83,28 @@ func (s *UserService) Register(username, email, password, role string) (*models.
 	// Create new user
 	now := models.CustomTime{Time: time.Now()}
 	user := models.User{
-		Username:  username,
-		Email:     email,
-		Password:  hashedPassword,
-		Role:      role,
-		Status:    "offline",
-		CreatedAt: now,
-		UpdatedAt: now,
+		Username:            username,
+		Email:               email,
+		Password:            hashedPassword,
+		Role:                role,
+		Status:              "offline",
+		CreatedAt:           now,
+		UpdatedAt:           now,
+		NotificationPreview: models.NotificationPreviewFull,
 	}
 
 	// Save user to database
 	if result := s.DB.Create(&user); result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrDuplicatedKey) {
+			return nil, s.duplicateUserError(email, username, 0)
+		}
 		return nil, errors.New("failed to create user")
 	}
 
+	if s.defaultChatrooms != nil {
+		s.defaultChatrooms.JoinDefaultChatrooms(user.UserID, user.Username)
+	}
+
 	return &user, nil
 }
 
@@ -65,7 +112,7 @@ func (s *UserService) Register(username, email, password, role string) (*models.
 func (s *UserService) Login(email, password string) (*models.User, error) {
 	// Find user by email
 	var user models.User
-	if result := s.DB.Where("email = ?", email).First(&user); result.Error != nil {
+	if result := s.DB.Where("email = ?", NormalizeEmail(email)).First(&user); result.Error != nil {
 		return nil, errors.New("invalid email or password")
 	}
 
@@ -119,6 +166,74 @@ func (s *UserService) Logout(userID uint) error {
 	return nil
 }
 
+// RevokeToken stops a token from being accepted again before it expires.
+// Entries for tokens that have since expired are cleared at the same time.
+func (s *UserService) RevokeToken(tokenID string, userID uint, expiresAt time.Time) error {
+	if tokenID == "" {
+		return nil // Tokens issued without an ID can't be tracked
+	}
+
+	revoked := models.RevokedToken{TokenID: tokenID, UserID: userID, ExpiresAt: expiresAt}
+	if err := s.DB.Where(models.RevokedToken{TokenID: tokenID}).FirstOrCreate(&revoked).Error; err != nil {
+		return errors.New("failed to revoke token")
+	}
+
+	s.revokedMu.Lock()
+	if s.revoked != nil {
+		s.revoked[tokenID] = revoked.ExpiresAt
+	}
+	s.revokedMu.Unlock()
+
+	// A revoked token is no longer a signed-in session
+	if err := s.DB.Where("token_id = ?", tokenID).Delete(&models.Session{}).Error; err != nil {
+		log.Printf("Warning: Failed to delete session for revoked token: %v", err)
+	}
+
+	if err := s.DB.Where("expires_at < ?", time.Now()).Delete(&models.RevokedToken{}).Error; err != nil {
+		log.Printf("Warning: Failed to clear expired revoked tokens: %v", err)
+	}
+	return nil
+}
+
+// IsTokenRevoked reports whether a token has been revoked. Revoked token IDs are cached until the
+// tokens expire and reloaded every revokedTokenRefreshInterval, so most checks don't touch the database.
+// If the reload fails the token is treated as revoked, so a database error never lets a logged-out token through.
+func (s *UserService) IsTokenRevoked(tokenID string) bool {
+	if tokenID == "" {
+		return false
+	}
+
+	s.revokedMu.Lock()
+	defer s.revokedMu.Unlock()
+
+	if time.Since(s.revokedLoadedAt) >= revokedTokenRefreshInterval {
+		if err := s.loadRevokedTokens(); err != nil {
+			log.Printf("Failed to load revoked tokens: %v", err)
+			return true
+		}
+	}
+	expiresAt, ok := s.revoked[tokenID]
+	return ok && time.Now().Before(expiresAt)
+}
+
+// loadRevokedTokens replaces the revoked token cache with the unexpired entries in the database.
+// The caller must hold revokedMu.
+func (s *UserService) loadRevokedTokens() error {
+	var tokens []models.RevokedToken
+	now := time.Now()
+	if err := s.DB.Select("token_id", "expires_at").Where("expires_at > ?", now).Find(&tokens).Error; err != nil {
+		return err
+	}
+
+	revoked := make(map[string]time.Time, len(tokens))
+	for _, token := range tokens {
+		revoked[token.TokenID] = token.ExpiresAt
+	}
+	s.revoked = revoked
+	s.revokedLoadedAt = now
+	return nil
+}
+
 // GetUserByID retrieves a user by ID
 func (s *UserService) GetUserByID(userID uint) (*models.User, error) {
 	var user models.User
@@ -131,12 +246,61 @@ func (s *UserService) GetUserByID(userID uint) (*models.User, error) {
 // GetUserByEmail retrieves a user by email
 func (s *UserService) GetUserByEmail(email string) (*models.User, error) {
 	var user models.User
-	if result := s.DB.Where("email = ?", email).First(&user); result.Error != nil {
+	if result := s.DB.Where("email = ?", NormalizeEmail(email)).First(&user); result.Error != nil {
 		return nil, errors.New("user not found")
 	}
 	return &user, nil
 }
 
+// User search limits
+const (
+	DefaultUserSearchLimit = 10
+	MaxUserSearchLimit     = 25
+	maxUserSearchQuery     = 50 // Usernames are at most 50 characters
+)
+
+// likeEscaper escapes LIKE wildcards so a search for "a_b" doesn't match "axb"
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// SearchUsers finds users whose username contains query (case-insensitive under the default collation).
+// Usernames starting with query come first, then alphabetical order. excludeUserID, when non-zero,
+// is left out of the results. limit is clamped to 1..MaxUserSearchLimit.
+func (s *UserService) SearchUsers(query string, limit int, excludeUserID uint) ([]models.User, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, errors.New("search query is required")
+	}
+	if len(query) > maxUserSearchQuery {
+		return nil, errors.New("search query is too long")
+	}
+	if limit <= 0 {
+		limit = DefaultUserSearchLimit
+	}
+	if limit > MaxUserSearchLimit {
+		limit = MaxUserSearchLimit
+	}
+
+	escaped := likeEscaper.Replace(query)
+	db := s.DB.Select("user_id", "username", "avatar_url").
+		Where("username LIKE ?", "%"+escaped+"%")
+	if excludeUserID != 0 {
+		db = db.Where("user_id <> ?", excludeUserID)
+	}
+
+	// Order drops gorm.Expr values, so the prefix-first ordering goes in as an ORDER BY clause
+	var users []models.User
+	err := db.Clauses(clause.OrderBy{Expression: clause.Expr{
+		SQL:  "CASE WHEN username LIKE ? THEN 0 ELSE 1 END, username",
+		Vars: []any{escaped + "%"},
+	}}).
+		Limit(limit).
+		Find(&users).Error
+	if err != nil {
+		return nil, errors.New("failed to search users")
+	}
+	return users, nil
+}
+
 // UpdateUser updates a user's information
 func (s *UserService) UpdateUser(user *models.User) error {
 	if result := s.DB.Save(user); result.Error != nil {
@@ -145,6 +309,171 @@ func (s *UserService) UpdateUser(user *models.User) error {
 	return nil
 }
 
+// UpdateProfile updates the user's profile (currently only the username)
+func (s *UserService) UpdateProfile(userID uint, username string) (*models.User, error) {
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Nothing to do if the username is unchanged
+	if user.Username == username {
+		return user, nil
+	}
+
+	// Check if username already exists
+	var existingUser models.User
+	if result := s.DB.Where("username = ? AND user_id <> ?", username, userID).First(&existingUser); result.Error == nil {
+		return nil, errors.New("user with this username already exists")
+	}
+
+	user.Username = username
+	if result := s.DB.Save(user); result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrDuplicatedKey) {
+			return nil, s.duplicateUserError("", username, userID)
+		}
+		return nil, errors.New("failed to update profile")
+	}
+
+	return user, nil
+}
+
+// GetNotificationPreview returns the user's push notification preview mode
+func (s *UserService) GetNotificationPreview(userID uint) (string, error) {
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return "", err
+	}
+	if user.NotificationPreview == "" {
+		return models.NotificationPreviewFull, nil
+	}
+	return user.NotificationPreview, nil
+}
+
+// UpdateNotificationPreview updates the user's push notification preview mode
+func (s *UserService) UpdateNotificationPreview(userID uint, mode string) error {
+	if !models.IsValidNotificationPreview(mode) {
+		return errors.New("invalid notification preview mode")
+	}
+
+	if _, err := s.GetUserByID(userID); err != nil {
+		return err
+	}
+
+	if err := s.DB.Model(&models.User{}).Where("user_id = ?", userID).Update("notification_preview", mode).Error; err != nil {
+		return errors.New("failed to update notification preview")
+	}
+	return nil
+}
+
+// GetQuietHours returns the user's quiet hours, or nil when none are set
+func (s *UserService) GetQuietHours(userID uint) (*models.QuietHours, error) {
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	return user.GetQuietHours(), nil
+}
+
+// UpdateQuietHours sets the user's daily do-not-disturb window
+func (s *UserService) UpdateQuietHours(userID uint, quietHours models.QuietHours) error {
+	if err := quietHours.Validate(); err != nil {
+		return errors.New("invalid quiet hours")
+	}
+
+	if _, err := s.GetUserByID(userID); err != nil {
+		return err
+	}
+
+	err := s.DB.Model(&models.User{}).Where("user_id = ?", userID).Updates(map[string]interface{}{
+		"quiet_hours_start":    quietHours.Start,
+		"quiet_hours_end":      quietHours.End,
+		"quiet_hours_timezone": quietHours.Timezone,
+	}).Error
+	if err != nil {
+		return errors.New("failed to update quiet hours")
+	}
+	return nil
+}
+
+// ClearQuietHours turns quiet hours off for the user
+func (s *UserService) ClearQuietHours(userID uint) error {
+	if _, err := s.GetUserByID(userID); err != nil {
+		return err
+	}
+
+	err := s.DB.Model(&models.User{}).Where("user_id = ?", userID).Updates(map[string]interface{}{
+		"quiet_hours_start":    "",
+		"quiet_hours_end":      "",
+		"quiet_hours_timezone": "",
+	}).Error
+	if err != nil {
+		return errors.New("failed to update quiet hours")
+	}
+	return nil
+}
+
+// UpdateShareLastSeen sets whether other users can see when the user was last active
+func (s *UserService) UpdateShareLastSeen(userID uint, share bool) error {
+	if _, err := s.GetUserByID(userID); err != nil {
+		return err
+	}
+
+	if err := s.DB.Model(&models.User{}).Where("user_id = ?", userID).Update("share_last_seen", share).Error; err != nil {
+		return errors.New("failed to update last seen sharing")
+	}
+	return nil
+}
+
+// UpdateHeartbeat records that the user was just active, which is what "last seen" reports
+func (s *UserService) UpdateHeartbeat(userID uint) error {
+	now := models.CustomTime{Time: time.Now()}
+	if err := s.DB.Model(&models.User{}).Where("user_id = ?", userID).UpdateColumn("heartbeat", now).Error; err != nil {
+		return errors.New("failed to update heartbeat")
+	}
+	return nil
+}
+
+// GetLastSeen returns the last seen time of each of the given users who share it.
+// Users who opted out, or were never active, are missing from the map.
+func (s *UserService) GetLastSeen(userIDs []uint) (map[uint]time.Time, error) {
+	lastSeen := make(map[uint]time.Time, len(userIDs))
+	if len(userIDs) == 0 {
+		return lastSeen, nil
+	}
+
+	var users []models.User
+	err := s.DB.Select("user_id", "heartbeat", "last_login_at", "share_last_seen").
+		Where("user_id IN ?", userIDs).Find(&users).Error
+	if err != nil {
+		return nil, errors.New("failed to get last seen times")
+	}
+	for _, user := range users {
+		if seen := user.LastSeenAt(); seen != nil {
+			lastSeen[user.UserID] = *seen
+		}
+	}
+	return lastSeen, nil
+}
+
+// GetUsernames looks up the usernames of the given users in one query.
+// Users that don't exist are missing from the map.
+func (s *UserService) GetUsernames(userIDs []uint) (map[uint]string, error) {
+	usernames := make(map[uint]string, len(userIDs))
+	if len(userIDs) == 0 {
+		return usernames, nil
+	}
+
+	var users []models.User
+	if err := s.DB.Select("user_id", "username").Where("user_id IN ?", userIDs).Find(&users).Error; err != nil {
+		return nil, errors.New("failed to get users")
+	}
+	for _, user := range users {
+		usernames[user.UserID] = user.Username
+	}
+	return usernames, nil
+}
+
 // HashPassword hashes a password using bcrypt
 func (s *UserService) HashPassword(password string) (string, error) {
 	// Use a higher cost factor for better security (12 is a good balance between security and performance)
@@ -172,13 +501,5 @@ func (s *UserService) IsHashedPassword(password string) bool {
 
 // ToResponse converts a User to a UserResponse
 func (s *UserService) ToResponse(user *models.User) models.UserResponse {
-	return models.UserResponse{
-		UserID:    user.UserID,
-		Username:  user.Username,
-		Email:     user.Email,
-		Role:      user.Role,
-		Status:    user.Status,
-		AvatarURL: user.AvatarURL,
-		CreatedAt: user.CreatedAt.Time,
-	}
+	return user.ToResponse()
 }
@@ -0,0 +1,189 @@
+package services
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ginchat/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gorm.io/gorm"
+)
+
+func TestIsTokenRevoked(t *testing.T) {
+	env := newTestEnv(t, false)
+	alice := env.createUser(t, "alice")
+	otherInstance := NewUserService(env.Users.DB) // Another server sharing the database
+
+	var lookups atomic.Int32
+	env.Users.DB.Callback().Query().After("gorm:query").Register("test:count_revocation_lookups", func(tx *gorm.DB) {
+		if strings.Contains(tx.Statement.SQL.String(), "revoked_tokens") {
+			lookups.Add(1)
+		}
+	})
+
+	if err := env.Users.RevokeToken("logged-out", alice.UserID, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("RevokeToken: %v", err)
+	}
+	if !env.Users.IsTokenRevoked("logged-out") || !otherInstance.IsTokenRevoked("logged-out") {
+		t.Error("revoked token accepted")
+	}
+	if env.Users.IsTokenRevoked("fresh") || otherInstance.IsTokenRevoked("fresh") {
+		t.Error("fresh token rejected")
+	}
+
+	t.Run("checks are served from the cache", func(t *testing.T) {
+		before := lookups.Load()
+		if before == 0 {
+			t.Fatal("the initial cache loads weren't counted")
+		}
+		for range 10 {
+			env.Users.IsTokenRevoked("logged-out")
+			env.Users.IsTokenRevoked("fresh")
+		}
+		if queries := lookups.Load() - before; queries != 0 {
+			t.Errorf("%d revoked token queries for cached checks, want 0", queries)
+		}
+	})
+
+	t.Run("revocations by another instance show up after a refresh", func(t *testing.T) {
+		if err := env.Users.RevokeToken("revoked-elsewhere", alice.UserID, time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("RevokeToken: %v", err)
+		}
+		otherInstance.revokedMu.Lock()
+		otherInstance.revokedLoadedAt = time.Now().Add(-revokedTokenRefreshInterval)
+		otherInstance.revokedMu.Unlock()
+		if !otherInstance.IsTokenRevoked("revoked-elsewhere") {
+			t.Error("token revoked by another instance still accepted after a refresh")
+		}
+	})
+
+	t.Run("entries lapse when the token expires", func(t *testing.T) {
+		if err := env.Users.RevokeToken("short-lived", alice.UserID, time.Now().Add(50*time.Millisecond)); err != nil {
+			t.Fatalf("RevokeToken: %v", err)
+		}
+		if !env.Users.IsTokenRevoked("short-lived") {
+			t.Fatal("revoked token accepted")
+		}
+		time.Sleep(60 * time.Millisecond)
+		if env.Users.IsTokenRevoked("short-lived") {
+			t.Error("expired revocation is still reported")
+		}
+	})
+}
+
+func TestSearchUsers(t *testing.T) {
+	env := newTestEnv(t, false)
+	var bob *models.User
+	for _, name := range []string{"xbobx", "jimbob", "bobby", "bob", "abob", "alice", "a_b", "axb", `a\b`} {
+		if user := env.createUser(t, name); name == "bob" {
+			bob = user
+		}
+	}
+	search := func(query string, limit int, exclude uint) []string {
+		t.Helper()
+		users, err := env.Users.SearchUsers(query, limit, exclude)
+		if err != nil {
+			t.Fatalf("SearchUsers(%q): %v", query, err)
+		}
+		names := make([]string, len(users))
+		for i, user := range users {
+			names[i] = user.Username
+			if user.Email != "" || user.Password != "" {
+				t.Errorf("search result for %s carries private fields", user.Username)
+			}
+		}
+		return names
+	}
+
+	if got := search("bob", 0, 0); !slices.Equal(got, []string{"bob", "bobby", "abob", "jimbob", "xbobx"}) {
+		t.Errorf("search bob = %v, want prefix matches first, then the rest, each by name", got)
+	}
+	if got := search(" BOB ", 0, 0); len(got) != 5 {
+		t.Errorf("search is not trimmed and case-insensitive: %v", got)
+	}
+	if got := search("bob", 0, bob.UserID); !slices.Equal(got, []string{"bobby", "abob", "jimbob", "xbobx"}) {
+		t.Errorf("search bob excluding bob = %v", got)
+	}
+
+	// LIKE wildcards in the query match only themselves
+	if got := search("a_b", 0, 0); !slices.Equal(got, []string{"a_b"}) {
+		t.Errorf("search a_b = %v, want the underscore matched literally", got)
+	}
+	if got := search("%", 0, 0); len(got) != 0 {
+		t.Errorf("search %% = %v, want nothing", got)
+	}
+	if got := search(`a\b`, 0, 0); !slices.Equal(got, []string{`a\b`}) {
+		t.Errorf(`search a\b = %v, want the backslash matched literally`, got)
+	}
+
+	for i := range 30 {
+		env.createUser(t, fmt.Sprintf("user%02d", i))
+	}
+	if got := search("user", 0, 0); len(got) != DefaultUserSearchLimit || got[0] != "user00" {
+		t.Errorf("default limit returned %d users starting at %v, want %d from user00", len(got), got, DefaultUserSearchLimit)
+	}
+	if got := search("user", 3, 0); !slices.Equal(got, []string{"user00", "user01", "user02"}) {
+		t.Errorf("limit 3 returned %v", got)
+	}
+	if got := search("user", 1000, 0); len(got) != MaxUserSearchLimit {
+		t.Errorf("limit 1000 returned %d users, want it clamped to %d", len(got), MaxUserSearchLimit)
+	}
+
+	for query, want := range map[string]string{"": "search query is required", "   ": "search query is required", strings.Repeat("a", 51): "search query is too long"} {
+		if _, err := env.Users.SearchUsers(query, 0, 0); err == nil || err.Error() != want {
+			t.Errorf("SearchUsers(%q) error = %v, want %q", query, err, want)
+		}
+	}
+}
+
+func TestRegisterJoinsDefaultChatrooms(t *testing.T) {
+	for _, pointerTracking := range []bool{false, true} {
+		t.Run(fmt.Sprintf("pointerTracking=%t", pointerTracking), func(t *testing.T) {
+			env := newTestEnv(t, pointerTracking)
+			alice := env.createUser(t, "alice")
+			welcome := env.createChatroom(t, "Welcome", alice)
+			announcements := env.createChatroom(t, "Announcements", alice)
+			other := env.createChatroom(t, "Other", alice)
+			env.sendText(t, welcome, alice, "hello newcomers")
+			env.sendText(t, announcements, alice, "release notes")
+
+			missing := primitive.NewObjectID().Hex()
+			defaults := NewChatroomService(env.Mongo, pointerTracking, []string{welcome.ID.Hex(), missing, announcements.ID.Hex()})
+			env.Users.SetDefaultChatroomJoiner(defaults)
+
+			// A missing room is skipped rather than failing registration
+			newcomer, err := env.Users.Register("newcomer", "newcomer@example.com", "Sunflower#42", models.UserRoleMember)
+			if err != nil {
+				t.Fatalf("Register: %v", err)
+			}
+
+			for _, room := range []*models.Chatroom{welcome, announcements, other} {
+				chatroom, err := env.Chatrooms.GetChatroomByID(room.ID)
+				if err != nil {
+					t.Fatalf("GetChatroomByID(%s): %v", room.Name, err)
+				}
+				if member, want := env.Chatrooms.IsMember(chatroom, newcomer.UserID), room != other; member != want {
+					t.Errorf("newcomer member of %s = %t, want %t", room.Name, member, want)
+				}
+			}
+
+			// History from before they joined doesn't count as unread
+			counts, err := env.ReadStatus.GetUnreadCountForUser(newcomer.UserID)
+			if err != nil {
+				t.Fatalf("GetUnreadCountForUser: %v", err)
+			}
+			if len(counts) != 2 {
+				t.Errorf("unread counts = %+v, want one per default room", counts)
+			}
+			for _, count := range counts {
+				if count.UnreadCount != 0 {
+					t.Errorf("unread in %s = %d, want 0", count.ChatroomID, count.UnreadCount)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package utils
+
+import (
+	"net/http"
+)
+
+// APIError is the error envelope every API error response uses:
+// {"error": "<user-friendly message>", "code": "<MACHINE_CODE>", "details": ...}.
+// The message stays under "error" so clients that only read that field keep working.
+type APIError struct {
+	Status  int    `json:"-"`
+	Code    string `json:"code" example:"CHATROOM_NOT_FOUND"`
+	Message string `json:"error" example:"Chat room not found. It may have been deleted"`
+	Details any    `json:"details,omitempty"`
+}
+
+// Error implements the error interface
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// NewAPIError creates an APIError
+func NewAPIError(status int, code, message string) *APIError {
+	return &APIError{Status: status, Code: code, Message: message}
+}
+
+// WithMessage returns a copy of the error with a more specific message
+func (e *APIError) WithMessage(message string) *APIError {
+	copied := *e
+	copied.Message = message
+	return &copied
+}
+
+// WithStatus returns a copy of the error with a different HTTP status
+func (e *APIError) WithStatus(status int) *APIError {
+	copied := *e
+	copied.Status = status
+	return &copied
+}
+
+// Generic codes for errors raised by controllers rather than services
+const (
+	CodeInvalidRequest = "INVALID_REQUEST"
+	CodeUnauthorized   = "UNAUTHORIZED"
+	CodeForbidden      = "FORBIDDEN"
+	CodeNotFound       = "NOT_FOUND"
+	CodeConflict       = "CONFLICT"
+	CodeTooLarge       = "PAYLOAD_TOO_LARGE"
+	CodeRateLimited    = "RATE_LIMITED"
+	CodeUnavailable    = "SERVICE_UNAVAILABLE"
+	CodeInternalError  = "INTERNAL_ERROR"
+	CodeUploadFailed   = "UPLOAD_FAILED"
+)
+
+// CodeForStatus returns the generic code for an HTTP status
+func CodeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeInvalidRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusRequestEntityTooLarge:
+		return CodeTooLarge
+	case http.StatusTooManyRequests:
+		return CodeRateLimited
+	case http.StatusServiceUnavailable:
+		return CodeUnavailable
+	default:
+		return CodeInternalError
+	}
+}
+
+// serviceErrorInfo is the HTTP status and code for a known service error
+type serviceErrorInfo struct {
+	status int
+	code   string
+}
+
+// serviceErrors maps service error strings to their HTTP status and code. Any error not listed is a 500.
+// User-facing messages come from FormatServiceError.
+var serviceErrors = map[string]serviceErrorInfo{
+	// User service errors
+	"user with this email already exists":              {http.StatusConflict, "EMAIL_TAKEN"},
+	"user with this username already exists":           {http.StatusConflict, "USERNAME_TAKEN"},
+	"invalid email or password":                        {http.StatusUnauthorized, "INVALID_CREDENTIALS"},
+	"this user is already logged in on another device": {http.StatusConflict, "ALREADY_LOGGED_IN"},
+	"user not found":                                   {http.StatusNotFound, "USER_NOT_FOUND"},
+	"invalid notification preview mode":                {http.StatusBadRequest, "INVALID_NOTIFICATION_PREVIEW"},
+	"invalid quiet hours":                              {http.StatusBadRequest, "INVALID_QUIET_HOURS"},
+	"search query is required":                         {http.StatusBadRequest, "SEARCH_QUERY_REQUIRED"},
+	"search query is too long":                         {http.StatusBadRequest, "SEARCH_QUERY_TOO_LONG"},
+
+	// Notification settings errors
+	"quiet hours cannot be set and cleared together": {http.StatusBadRequest, "INVALID_QUIET_HOURS"},
+	"too many chatrooms in one update":               {http.StatusBadRequest, "TOO_MANY_CHATROOMS"},
+	"failed to get notification settings":            {http.StatusInternalServerError, "INTERNAL_ERROR"},
+	"failed to update notification settings":         {http.StatusInternalServerError, "INTERNAL_ERROR"},
+
+	// Session errors
+	"session not found":        {http.StatusNotFound, "SESSION_NOT_FOUND"},
+	"failed to get sessions":   {http.StatusInternalServerError, "INTERNAL_ERROR"},
+	"failed to revoke session": {http.StatusInternalServerError, "INTERNAL_ERROR"},
+	"failed to create session": {http.StatusInternalServerError, "INTERNAL_ERROR"},
+
+	// Chatroom service errors
+	"chatroom with this name already exists":          {http.StatusConflict, "CHATROOM_NAME_TAKEN"},
+	"chatroom not found":                              {http.StatusNotFound, "CHATROOM_NOT_FOUND"},
+	"room not found":                                  {http.StatusNotFound, "CHATROOM_NOT_FOUND"},
+	"invalid room code format":                        {http.StatusBadRequest, "INVALID_ROOM_CODE"},
+	"incorrect password":                              {http.StatusForbidden, "INCORRECT_PASSWORD"},
+	"user is already a member of this chatroom":       {http.StatusConflict, "ALREADY_MEMBER"},
+	"chatroom membership limit reached":               {http.StatusForbidden, "CHATROOM_LIMIT_REACHED"},
+	"user is not a member of this chatroom":           {http.StatusForbidden, "NOT_A_MEMBER"},
+	"the creator cannot leave this chatroom":          {http.StatusBadRequest, "CREATOR_CANNOT_LEAVE"},
+	"only the creator can delete this chatroom":       {http.StatusForbidden, "CREATOR_ONLY"},
+	"only the creator can clear this chatroom":        {http.StatusForbidden, "CREATOR_ONLY"},
+	"only the creator can change member roles":        {http.StatusForbidden, "CREATOR_ONLY"},
+	"cannot change the creator's role":                {http.StatusForbidden, "CREATOR_ROLE_LOCKED"},
+	"invalid chatroom role":                           {http.StatusBadRequest, "INVALID_ROLE"},
+	"only chatroom admins can update this chatroom":   {http.StatusForbidden, "ADMIN_ONLY"},
+	"invalid chatroom name":                           {http.StatusBadRequest, "INVALID_CHATROOM_NAME"},
+	"chatroom description is too long":                {http.StatusBadRequest, "DESCRIPTION_TOO_LONG"},
+	"chatroom topic is too long":                      {http.StatusBadRequest, "TOPIC_TOO_LONG"},
+	"invalid filter policy":                           {http.StatusBadRequest, "INVALID_FILTER_POLICY"},
+	"invalid sort mode":                               {http.StatusBadRequest, "INVALID_SORT"},
+	"chatroom search is too long":                     {http.StatusBadRequest, "SEARCH_QUERY_TOO_LONG"},
+	"invalid media type":                              {http.StatusBadRequest, "INVALID_MEDIA_TYPE"},
+	"only the creator can change allowed media types": {http.StatusForbidden, "CREATOR_ONLY"},
+	"only the creator can change read receipts":       {http.StatusForbidden, "CREATOR_ONLY"},
+	"only the creator can change the edit window":     {http.StatusForbidden, "CREATOR_ONLY"},
+	"invalid edit window":                             {http.StatusBadRequest, "INVALID_EDIT_WINDOW"},
+
+	// Message service errors
+	"user is read-only in this chatroom":              {http.StatusForbidden, "READ_ONLY_MEMBER"},
+	"only chatroom admins can mention everyone":       {http.StatusForbidden, "ADMIN_ONLY"},
+	"text content is required for text messages":      {http.StatusBadRequest, "TEXT_REQUIRED"},
+	"text content is required for combined messages":  {http.StatusBadRequest, "TEXT_REQUIRED"},
+	"media URL is required for media messages":        {http.StatusBadRequest, "MEDIA_REQUIRED"},
+	"media URL is required for combined messages":     {http.StatusBadRequest, "MEDIA_REQUIRED"},
+	"invalid message type":                            {http.StatusBadRequest, "INVALID_MESSAGE_TYPE"},
+	"message contains blocked content":                {http.StatusBadRequest, "BLOCKED_CONTENT"},
+	"media URL is not hosted by this app":             {http.StatusBadRequest, "MEDIA_NOT_ALLOWED"},
+	"media file not found":                            {http.StatusBadRequest, "MEDIA_NOT_FOUND"},
+	"message not found":                               {http.StatusNotFound, "MESSAGE_NOT_FOUND"},
+	"invalid date range":                              {http.StatusBadRequest, "INVALID_DATE_RANGE"},
+	"date range is too long":                          {http.StatusBadRequest, "DATE_RANGE_TOO_LONG"},
+	"read time is in the future":                      {http.StatusBadRequest, "READ_TIME_IN_FUTURE"},
+	"read time is before the message was sent":        {http.StatusBadRequest, "READ_TIME_BEFORE_SENT"},
+	"user is not the sender of this message":          {http.StatusForbidden, "NOT_MESSAGE_SENDER"},
+	"no changes provided":                             {http.StatusBadRequest, "NO_CHANGES"},
+	"message must have text or media":                 {http.StatusBadRequest, "EMPTY_MESSAGE"},
+	"invalid cursor":                                  {http.StatusBadRequest, "INVALID_CURSOR"},
+	"this media type is not allowed in this chatroom": {http.StatusBadRequest, "MEDIA_TYPE_NOT_ALLOWED"},
+	"read status not found":                           {http.StatusNotFound, "READ_STATUS_NOT_FOUND"},
+	"system messages have no push notification":       {http.StatusBadRequest, "SYSTEM_MESSAGE"},
+	"draft is too long":                               {http.StatusBadRequest, "DRAFT_TOO_LONG"},
+	"attachments are required for album messages":     {http.StatusBadRequest, "MEDIA_REQUIRED"},
+	"too many attachments":                            {http.StatusBadRequest, "TOO_MANY_ATTACHMENTS"},
+	"album attachments cannot be edited":              {http.StatusBadRequest, "ALBUM_NOT_EDITABLE"},
+	"no file provided":                                {http.StatusBadRequest, "FILE_REQUIRED"},
+	"message edit window has expired":                 {http.StatusForbidden, "EDIT_WINDOW_EXPIRED"},
+
+	// Stats errors
+	"failed to get system stats": {http.StatusInternalServerError, "INTERNAL_ERROR"},
+
+	// Message report errors
+	"report reason is required":              {http.StatusBadRequest, "REPORT_REASON_REQUIRED"},
+	"report reason is too long":              {http.StatusBadRequest, "REPORT_REASON_TOO_LONG"},
+	"system messages cannot be reported":     {http.StatusBadRequest, "SYSTEM_MESSAGE"},
+	"you cannot report your own message":     {http.StatusBadRequest, "OWN_MESSAGE"},
+	"you have already reported this message": {http.StatusConflict, "ALREADY_REPORTED"},
+	"invalid report status":                  {http.StatusBadRequest, "INVALID_REPORT_STATUS"},
+	"report not found":                       {http.StatusNotFound, "REPORT_NOT_FOUND"},
+	"report is already closed":               {http.StatusConflict, "REPORT_CLOSED"},
+
+	// Media service errors
+	"file size exceeds the upload limit":             {http.StatusBadRequest, "FILE_TOO_LARGE"},
+	"invalid file type for the specified media type": {http.StatusBadRequest, "INVALID_FILE_TYPE"},
+	"invalid image file":                             {http.StatusBadRequest, "INVALID_FILE_TYPE"},
+	"media storage not initialized":                  {http.StatusInternalServerError, "UPLOAD_UNAVAILABLE"},
+	"upload queue is full":                           {http.StatusServiceUnavailable, "UPLOAD_BUSY"},
+}
+
+// LookupServiceError converts a known service error to an APIError; ok is false for unknown errors
+func LookupServiceError(err error) (apiErr *APIError, ok bool) {
+	info, ok := serviceErrors[err.Error()]
+	if !ok {
+		return nil, false
+	}
+	return NewAPIError(info.status, info.code, FormatServiceError(err)), true
+}
+
+// ServiceAPIError converts any error returned by a service to an APIError.
+// An APIError is returned as is; unknown errors become a 500 with a generic message.
+func ServiceAPIError(err error) *APIError {
+	if apiErr, ok := err.(*APIError); ok {
+		return apiErr
+	}
+	if apiErr, ok := LookupServiceError(err); ok {
+		return apiErr
+	}
+	return NewAPIError(http.StatusInternalServerError, CodeInternalError, FormatServiceError(err))
+}
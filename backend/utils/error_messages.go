@@ -122,18 +122,52 @@ func FormatServiceError(err error) string {
 		return "This username is already taken. Please choose a different username"
 	case "invalid email or password":
 		return "Invalid email or password. Please check your credentials and try again"
+	case "this user is already logged in on another device":
+		return "This account is already logged in on another device. Please log out there first"
 	case "user not found":
 		return "User account not found"
 	case "failed to create user":
 		return "Unable to create account. Please try again later"
 	case "failed to update user":
 		return "Unable to update account. Please try again later"
+	case "failed to revoke token":
+		return "Unable to log out. Please try again"
 	case "failed to update user status":
 		return "Unable to update account status. Please try again later"
+	case "failed to update profile":
+		return "Unable to update your profile. Please try again later"
+	case "invalid notification preview mode":
+		return "Please choose full, sender_only, or hidden for notification previews"
+	case "failed to update notification preview":
+		return "Unable to update notification settings. Please try again later"
+	case "invalid quiet hours":
+		return "Please give quiet hours as two different HH:MM times and a valid timezone (e.g. Asia/Singapore)"
+	case "failed to update quiet hours":
+		return "Unable to update quiet hours. Please try again later"
+	case "quiet hours cannot be set and cleared together":
+		return "Send either new quiet hours or clear_quiet_hours, not both"
+	case "too many chatrooms in one update":
+		return "Too many chat rooms in one update. Please send at most 500 at a time"
+	case "failed to get notification settings":
+		return "Unable to load notification settings. Please try again later"
+	case "failed to update notification settings":
+		return "Unable to update notification settings. Please try again later"
+	case "session not found":
+		return "That session was not found. It may already be signed out"
+	case "failed to get sessions":
+		return "Unable to load your signed-in devices. Please try again later"
+	case "failed to revoke session":
+		return "Unable to sign out that device. Please try again"
+	case "failed to create session":
+		return "Unable to record this sign-in. Please try again"
+	case "search query is required":
+		return "Please enter part of a username to search for"
+	case "search query is too long":
+		return "Search text can be at most 50 characters"
 
 	// Chatroom service errors
 	case "chatroom with this name already exists":
-		return "A chat room with this name already exists. Please choose a different name"
+		return "You already have a chat room with this name. Please choose a different name"
 	case "chatroom not found":
 		return "Chat room not found. It may have been deleted"
 	case "failed to create chatroom":
@@ -146,10 +180,24 @@ func FormatServiceError(err error) string {
 		return "Unable to join chat room. Please try again later"
 	case "failed to leave chatroom":
 		return "Unable to leave chat room. Please try again later"
+	case "room not found":
+		return "Room not found. Please check the room code"
+	case "invalid room code format":
+		return "Room codes are 6 letters or numbers. Please check the code and try again"
+	case "incorrect password":
+		return "Incorrect password"
 	case "user is already a member of this chatroom":
 		return "You are already a member of this chat room"
+	case "chatroom membership limit reached":
+		return "You've reached the maximum number of chat rooms you can be in. Leave a room before joining or creating another"
 	case "user is not a member of this chatroom":
 		return "You are not a member of this chat room"
+	case "failed to pin chatroom":
+		return "Unable to pin chat room. Please try again later"
+	case "failed to unpin chatroom":
+		return "Unable to unpin chat room. Please try again later"
+	case "the creator cannot leave this chatroom":
+		return "As the creator you can't leave this chat room. Delete it instead"
 
 	// Message service errors
 	case "text content is required for text messages":
@@ -168,8 +216,16 @@ func FormatServiceError(err error) string {
 		return "You can only modify your own messages"
 	case "failed to update message":
 		return "Unable to update message. Please try again later"
+	case "no changes provided":
+		return "Please provide the text or media you want to change"
+	case "message must have text or media":
+		return "A message cannot be empty. Please keep some text or media"
 	case "failed to delete message":
 		return "Unable to delete message. Please try again later"
+	case "failed to count messages":
+		return "Unable to load the message count. Please try again later"
+	case "failed to count media":
+		return "Unable to load media counts. Please try again later"
 	case "failed to find messages":
 		return "Unable to load messages. Please try again later"
 	case "failed to delete messages":
@@ -180,16 +236,131 @@ func FormatServiceError(err error) string {
 		return "Only the chatroom creator can delete this chatroom"
 	case "failed to delete chatroom":
 		return "Unable to delete chatroom. Please try again later"
+	case "invalid chatroom role":
+		return "Please choose member, readonly, or admin as the role"
+	case "only the creator can change member roles":
+		return "Only the chatroom creator can change member roles"
+	case "cannot change the creator's role":
+		return "The chatroom creator's role cannot be changed"
+	case "failed to update member role":
+		return "Unable to update member role. Please try again later"
+	case "only chatroom admins can mention everyone":
+		return "Only chatroom admins can use @everyone. Try @here to reach members who are online"
+	case "user is read-only in this chatroom":
+		return "You have read-only access in this chatroom and cannot send messages"
+	case "invalid chatroom name":
+		return "Chatroom name must be between 3 and 100 characters"
+	case "chatroom description is too long":
+		return "Chatroom description must be 500 characters or fewer"
+	case "chatroom topic is too long":
+		return "Chatroom topic must be 100 characters or fewer"
+	case "only chatroom admins can update this chatroom":
+		return "Only chatroom admins can update the name, description and topic"
+	case "failed to update chatroom":
+		return "Unable to update chatroom. Please try again later"
+	case "invalid filter policy":
+		return "Please choose mask, reject, or off as the filter policy"
+	case "media URL is not hosted by this app":
+		return "Media must be uploaded through GinChat before it can be sent"
+	case "media file not found":
+		return "The new media file could not be found. Please upload it again"
+	case "message contains blocked content":
+		return "Your message contains words that aren't allowed in this chatroom"
+	case "failed to check chatroom membership":
+		return "Unable to verify chatroom access. Please try again later"
+	case "only the creator can clear this chatroom":
+		return "Only the chatroom creator can clear this chatroom"
+	case "failed to mark messages as read":
+		return "Unable to mark messages as read. Please try again later"
+	case "failed to delete read statuses":
+		return "Unable to clear chatroom history. Please try again later"
+
+	case "read status not found":
+		return "Message not found or already read"
+	case "invalid media type":
+		return "Allowed media types must be image, audio or video"
+	case "only the creator can change allowed media types":
+		return "Only the chat room creator can change which media types are allowed"
+	case "only the creator can change read receipts":
+		return "Only the chat room creator can turn read receipts on or off"
+	case "only the creator can change the edit window":
+		return "Only the chat room creator can change how long messages stay editable"
+	case "invalid edit window":
+		return "Edit window must be between 0 (no limit) and 10080 minutes"
+	case "this media type is not allowed in this chatroom":
+		return "This chat room doesn't allow this type of media"
+	case "chatroom search is too long":
+		return "Search text can be at most 100 characters"
+	case "invalid sort mode":
+		return "Unknown sort order. Use recent or unread_first"
+	case "invalid cursor":
+		return "This page link is no longer valid. Please reload the list"
+	case "system messages have no push notification":
+		return "System messages don't send push notifications"
+	case "draft is too long":
+		return "Draft is too long. Please keep it under 10000 characters"
+	case "attachments are required for album messages":
+		return "Please upload the files for this album"
+	case "too many attachments":
+		return "Too many files. An album can have at most 10 items"
+	case "album attachments cannot be edited":
+		return "The files in an album can't be changed. You can still edit its text"
+	case "no file provided":
+		return "Please select a file to upload"
+	case "message edit window has expired":
+		return "This message is too old to edit"
+	case "invalid date range":
+		return "The start of the date range must not be after its end"
+	case "date range is too long":
+		return "Date ranges can span at most 31 days"
+	case "read time is in the future":
+		return "The read time can't be in the future"
+	case "read time is before the message was sent":
+		return "The read time can't be earlier than when the message was sent"
+	case "failed to save draft", "failed to get draft", "failed to delete draft":
+		return "Unable to sync your draft. Please try again later"
+
+	// Message report errors
+	case "report reason is required":
+		return "Please give a reason for the report"
+	case "report reason is too long":
+		return "Report reason is too long. Please keep it under 500 characters"
+	case "system messages cannot be reported":
+		return "System messages can't be reported"
+	case "you cannot report your own message":
+		return "You can't report your own message"
+	case "you have already reported this message":
+		return "You have already reported this message"
+	case "failed to report message":
+		return "Unable to report message. Please try again later"
+	case "failed to get reports", "failed to decode reports":
+		return "Unable to load reports. Please try again later"
+	case "invalid report status":
+		return "Unknown report status. Use open, resolved or dismissed"
+	case "report not found":
+		return "Report not found"
+	case "report is already closed":
+		return "This report has already been resolved or dismissed"
+	case "failed to update report":
+		return "Unable to update report. Please try again later"
+	case "failed to get system stats":
+		return "Unable to load system stats. Please try again later"
 
 	// Media service errors
-	case "file size exceeds the 10MB limit":
-		return "File is too large. Please choose a file smaller than 10MB"
+	case "file size exceeds the upload limit":
+		return "File is too large. Please choose a smaller file"
 	case "invalid file type for the specified media type":
 		return "Invalid file type. Please choose a supported file format"
 	case "No file uploaded":
 		return "Please select a file to upload"
+	case "media storage not initialized":
+		return "File upload service is temporarily unavailable. Please try again later"
+	case "invalid image file":
+		return "This image could not be read. Please choose a different file"
 	case "Invalid message type for media upload":
 		return "Invalid file type selected"
+	case "upload queue is full":
+		return "Too many uploads are in progress. Please try again in a moment"
 
 	// Generic database errors
 	case "failed to check chatroom existence":
@@ -215,16 +386,18 @@ func FormatMediaError(err error) string {
 	errMsg := err.Error()
 
 	switch {
-	case strings.Contains(errMsg, "Cloudinary service not initialized"):
+	case strings.Contains(errMsg, "media storage not initialized"):
 		return "File upload service is temporarily unavailable. Please try again later"
 	case strings.Contains(errMsg, "file size"):
-		return "File is too large. Please choose a file smaller than 10MB"
+		return "File is too large. Please choose a smaller file"
 	case strings.Contains(errMsg, "invalid file type"):
 		return "Unsupported file type. Please choose a valid image, audio, or video file"
 	case strings.Contains(errMsg, "No file uploaded"):
 		return "Please select a file to upload"
 	case strings.Contains(errMsg, "Invalid message type"):
 		return "Please select a valid message type"
+	case strings.Contains(errMsg, "upload queue is full"):
+		return "Too many uploads are in progress. Please try again in a moment"
 	default:
 		return "File upload failed. Please try again"
 	}
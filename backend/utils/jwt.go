@@ -5,7 +5,6 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"os"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -21,6 +20,18 @@ func generateTokenID() string {
 	return hex.EncodeToString(bytes)
 }
 
+// JWT settings, set once at startup by ConfigureJWT
+var (
+	jwtSecret     string
+	jwtExpiration = 24 * time.Hour
+)
+
+// ConfigureJWT sets the signing secret and token lifetime
+func ConfigureJWT(secret string, expiration time.Duration) {
+	jwtSecret = secret
+	jwtExpiration = expiration
+}
+
 // JWTClaims represents the claims in a JWT
 type JWTClaims struct {
 	UserID   uint   `json:"user_id"`
@@ -30,30 +41,33 @@ type JWTClaims struct {
 	jwt.StandardClaims
 }
 
+// IssuedToken is a signed JWT along with the ID and expiry needed to track it as a session
+type IssuedToken struct {
+	Token     string
+	ID        string // The jti claim
+	ExpiresAt time.Time
+}
+
 // GenerateJWT generates a new JWT token for a user
 func GenerateJWT(userID uint, username, email, role string) (string, error) {
-	// Get JWT secret from environment
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		return "", errors.New("JWT_SECRET environment variable not set")
-	}
-
-	// Get JWT expiration from environment
-	jwtExpiration := os.Getenv("JWT_EXPIRATION")
-	if jwtExpiration == "" {
-		jwtExpiration = "24h" // Default to 24 hours
-	}
-
-	// Parse expiration duration
-	expirationDuration, err := time.ParseDuration(jwtExpiration)
+	issued, err := IssueJWT(userID, username, email, role)
 	if err != nil {
 		return "", err
 	}
+	return issued.Token, nil
+}
+
+// IssueJWT generates a new JWT token for a user and returns it with its ID and expiry
+func IssueJWT(userID uint, username, email, role string) (*IssuedToken, error) {
+	if jwtSecret == "" {
+		return nil, errors.New("JWT secret not configured")
+	}
 
 	// Generate a unique token ID (jti)
 	tokenID := generateTokenID()
 
 	now := time.Now()
+	expiresAt := now.Add(jwtExpiration)
 	// Create claims
 	claims := JWTClaims{
 		UserID:   userID,
@@ -61,7 +75,7 @@ func GenerateJWT(userID uint, username, email, role string) (string, error) {
 		Email:    email,
 		Role:     role,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: now.Add(expirationDuration).Unix(),
+			ExpiresAt: expiresAt.Unix(),
 			IssuedAt:  now.Unix(),
 			NotBefore: now.Unix(),                // Token is not valid before the issued time
 			Issuer:    "ginchat-api",             // Identify the issuer
@@ -80,18 +94,16 @@ func GenerateJWT(userID uint, username, email, role string) (string, error) {
 	// Sign token with secret
 	tokenString, err := token.SignedString([]byte(jwtSecret))
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return tokenString, nil
+	return &IssuedToken{Token: tokenString, ID: tokenID, ExpiresAt: time.Unix(expiresAt.Unix(), 0)}, nil
 }
 
 // ValidateJWT validates a JWT token and returns the claims
 func ValidateJWT(tokenString string) (*JWTClaims, error) {
-	// Get JWT secret from environment
-	jwtSecret := os.Getenv("JWT_SECRET")
 	if jwtSecret == "" {
-		return nil, errors.New("JWT_SECRET environment variable not set")
+		return nil, errors.New("JWT secret not configured")
 	}
 
 	// Parse token
@@ -1,8 +1,14 @@
 package utils
 
 import (
+	"bytes"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
 )
 
 // MediaType represents the type of media file
@@ -31,6 +37,39 @@ func GetMediaTypeFromMessageType(messageType string) MediaType {
 	}
 }
 
+// GetMediaTypeFromExtension returns the media type for a file extension (e.g. ".jpg")
+func GetMediaTypeFromExtension(ext string) MediaType {
+	switch strings.ToLower(ext) {
+	case ".jpg", ".jpeg", ".png", ".gif", ".webp":
+		return ImageMedia
+	case ".mp3", ".wav", ".ogg", ".m4a":
+		return AudioMedia
+	case ".mp4", ".webm", ".mov", ".avi":
+		return VideoMedia
+	default:
+		return ""
+	}
+}
+
+// GetMessageTypeFromMediaType returns the message type for a media type, combined with text if hasText is true
+func GetMessageTypeFromMediaType(mediaType MediaType, hasText bool) string {
+	var messageType string
+	switch mediaType {
+	case ImageMedia:
+		messageType = "picture"
+	case AudioMedia:
+		messageType = "audio"
+	case VideoMedia:
+		messageType = "video"
+	default:
+		return ""
+	}
+	if hasText {
+		return "text_and_" + messageType
+	}
+	return messageType
+}
+
 // GenerateRandomID generates a random ID for filenames
 func GenerateRandomID(length int) (string, error) {
 	bytes := make([]byte, length/2)
@@ -39,3 +78,36 @@ func GenerateRandomID(length int) (string, error) {
 	}
 	return hex.EncodeToString(bytes), nil
 }
+
+// StripImageMetadata re-encodes JPEG and PNG images so EXIF data (GPS location, camera details) is dropped.
+// Other formats are returned unchanged. The decoded pixels are kept as stored, so EXIF orientation is lost.
+func StripImageMetadata(src io.Reader, ext string) ([]byte, error) {
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	switch strings.ToLower(ext) {
+	case ".jpg", ".jpeg":
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, errors.New("invalid image file")
+		}
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+			return nil, err
+		}
+	case ".png":
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, errors.New("invalid image file")
+		}
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, err
+		}
+	default:
+		return data, nil
+	}
+
+	return buf.Bytes(), nil
+}
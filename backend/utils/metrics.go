@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Prometheus metrics exposed on /metrics
+// Labels are kept low-cardinality on purpose (no per-user or per-room labels)
+var (
+	// MessagesSentTotal counts messages successfully stored
+	MessagesSentTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ginchat_messages_sent_total",
+		Help: "Total number of messages sent",
+	})
+
+	// MessageClockAdjustmentsTotal counts messages whose sent_at was moved past the room's latest message
+	// because the server clock hadn't advanced (or had stepped back)
+	MessageClockAdjustmentsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ginchat_message_clock_adjustments_total",
+		Help: "Total number of message timestamps bumped to keep sent_at increasing within a chatroom",
+	})
+
+	// PushNotificationsSentTotal counts push notifications accepted by the push provider (one per device token)
+	PushNotificationsSentTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ginchat_push_notifications_sent_total",
+		Help: "Total number of push notifications sent",
+	})
+
+	// WebSocketConnections tracks currently open WebSocket connections
+	WebSocketConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "ginchat_websocket_connections",
+		Help: "Number of currently open WebSocket connections",
+	})
+
+	// WebSocketBroadcastErrorsTotal counts failed writes while broadcasting to WebSocket clients
+	WebSocketBroadcastErrorsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ginchat_websocket_broadcast_errors_total",
+		Help: "Total number of failed WebSocket broadcast writes",
+	})
+
+	// WebSocketSlowClientsEvictedTotal counts connections closed because their send queue stayed full
+	WebSocketSlowClientsEvictedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ginchat_websocket_slow_clients_evicted_total",
+		Help: "Total number of WebSocket clients evicted for not keeping up with outbound messages",
+	})
+
+	// WebSocketSendQueueMaxDepth is the deepest per-connection send queue at the last sample
+	WebSocketSendQueueMaxDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "ginchat_websocket_send_queue_max_depth",
+		Help: "Largest number of messages waiting in any WebSocket connection's send queue",
+	})
+
+	// ReadStatusOperationsTotal counts read-status updates by operation
+	ReadStatusOperationsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ginchat_read_status_operations_total",
+		Help: "Total number of read status operations",
+	}, []string{"operation"})
+)
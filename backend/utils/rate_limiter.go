@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// TokenBucket is a simple token bucket rate limiter.
+// It is not safe for concurrent use; each WebSocket reader goroutine owns its own buckets.
+type TokenBucket struct {
+	capacity   float64
+	tokens     float64
+	refillRate float64 // tokens added per second
+	lastRefill time.Time
+}
+
+// NewTokenBucket creates a full bucket holding up to capacity tokens and refilling at ratePerSecond
+func NewTokenBucket(capacity int, ratePerSecond float64) *TokenBucket {
+	return &TokenBucket{
+		capacity:   float64(capacity),
+		tokens:     float64(capacity),
+		refillRate: ratePerSecond,
+		lastRefill: time.Now(),
+	}
+}
+
+// Allow takes one token if available and reports whether the action is allowed
+func (b *TokenBucket) Allow() bool {
+	now := time.Now()
+	b.tokens += now.Sub(b.lastRefill).Seconds() * b.refillRate
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.lastRefill = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// WindowLimiter allows each key at most limit actions within a sliding window.
+// Unlike TokenBucket it is safe for concurrent use and tracks many keys (e.g. user IDs) at once.
+type WindowLimiter struct {
+	limit  int
+	window time.Duration
+	mu     sync.Mutex
+	events map[uint][]time.Time // Action times within the window, oldest first
+}
+
+// NewWindowLimiter creates a limiter allowing limit actions per key within window
+func NewWindowLimiter(limit int, window time.Duration) *WindowLimiter {
+	return &WindowLimiter{
+		limit:  limit,
+		window: window,
+		events: make(map[uint][]time.Time),
+	}
+}
+
+// Allow records an action for key if it is within the limit. When it isn't, it returns false
+// and how long until the oldest action in the window expires.
+func (l *WindowLimiter) Allow(key uint) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	cutoff := now.Add(-l.window)
+	recent := l.events[key]
+	for len(recent) > 0 && !recent[0].After(cutoff) {
+		recent = recent[1:]
+	}
+
+	if len(recent) >= l.limit {
+		l.events[key] = recent
+		return false, recent[0].Sub(cutoff)
+	}
+
+	l.events[key] = append(recent, now)
+	return true, 0
+}
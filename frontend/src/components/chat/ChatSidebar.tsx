@@ -126,6 +126,7 @@ const ChatSidebar: React.FC<ChatSidebarProps> = ({
 
     switch (lastMessage.type) {
       case 'new_message':
+      case 'sidebar_update':
         // Refresh latest messages and unread counts when a new message arrives
         fetchLatestMessagesAndCounts();
         break;